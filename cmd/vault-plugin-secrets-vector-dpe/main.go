@@ -12,10 +12,20 @@ import (
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
 
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/compute"
 	vectordpe "github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/plugin"
 )
 
 func main() {
+	// With VECTOR_DPE_COMPUTE_WORKER set, the plugin re-executes this
+	// binary as its compute worker.
+	if compute.IsWorker() {
+		if err := compute.ServeWorker(); err != nil {
+			log.Fatalf("compute worker exited with error: %v", err)
+		}
+		return
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
 	if err := flags.Parse(os.Args[1:]); err != nil {
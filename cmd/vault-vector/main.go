@@ -0,0 +1,25 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package main is vault-vector, the command-line client for the plugin:
+//
+//	vault-vector encrypt -key products -file vectors.npy -out ciphertexts.npy
+//
+// See package internal/cli.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,256 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package main runs a local Vault dev server with the plugin registered,
+// mounted and keyed, for onboarding and manual testing.
+//
+// Run it from the repository root:
+//
+//	go run ./cmd/vector-dpe-dev
+//
+// It builds the plugin, starts `vault server -dev` (the vault binary must be
+// on PATH or given with -vault), registers and mounts the plugin, rotates a
+// test key and prints example commands. Ctrl-C stops Vault and removes all
+// temporary files. Nothing here is suitable for production.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// pluginName is the catalog name and binary name of the plugin.
+	pluginName = "vault-plugin-secrets-vector-dpe"
+
+	// rootToken is the dev server's fixed root token.
+	rootToken = "root"
+
+	// startTimeout bounds how long to wait for the dev server to come up.
+	startTimeout = 30 * time.Second
+)
+
+func main() {
+	vaultBin := flag.String("vault", "vault", "Path to the vault binary.")
+	pluginBin := flag.String("plugin", "", "Path to a prebuilt plugin binary (default: build ./cmd/"+pluginName+").")
+	addr := flag.String("addr", "127.0.0.1:8200", "Listen address of the dev server.")
+	mount := flag.String("mount", "vector", "Mount path of the plugin.")
+	dimension := flag.Int("dimension", 1536, "Dimension of the test key.")
+	race := flag.Bool("race", false, "Build the plugin with the race detector (for debug/stress).")
+	flag.Parse()
+
+	if err := run(*vaultBin, *pluginBin, *addr, *mount, *dimension, *race); err != nil {
+		log.Fatalf("vector-dpe-dev: %v", err)
+	}
+}
+
+func run(vaultBin, pluginBin, addr, mount string, dimension int, race bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workDir, err := os.MkdirTemp("", "vector-dpe-dev-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	pluginDir := filepath.Join(workDir, "plugins")
+	if err := os.Mkdir(pluginDir, 0o700); err != nil {
+		return err
+	}
+	pluginPath := filepath.Join(pluginDir, pluginName)
+	if err := installPlugin(ctx, pluginBin, pluginPath, race); err != nil {
+		return err
+	}
+	sum, err := fileSHA256(pluginPath)
+	if err != nil {
+		return err
+	}
+
+	logPath := filepath.Join(workDir, "vault.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	log.Printf("starting vault dev server on %s (log: %s)", addr, logPath)
+	server := exec.Command(vaultBin, "server", "-dev",
+		"-dev-root-token-id="+rootToken,
+		"-dev-listen-address="+addr,
+		"-dev-plugin-dir="+pluginDir)
+	server.Stdout = logFile
+	server.Stderr = logFile
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("start vault: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- server.Wait() }()
+	defer func() {
+		if server.ProcessState == nil {
+			_ = server.Process.Signal(os.Interrupt)
+			<-exited
+		}
+	}()
+
+	client, err := api.NewClient(&api.Config{Address: "http://" + addr})
+	if err != nil {
+		return err
+	}
+	client.SetToken(rootToken)
+
+	if err := waitReady(ctx, client, exited); err != nil {
+		return fmt.Errorf("%w (see %s)", err, logPath)
+	}
+	if err := setup(ctx, client, sum, mount, dimension); err != nil {
+		return err
+	}
+
+	examplePath := filepath.Join(workDir, "vector.json")
+	if err := os.WriteFile(examplePath, []byte(exampleVector(dimension)), 0o600); err != nil {
+		return err
+	}
+	printUsage(addr, mount, dimension, examplePath)
+
+	select {
+	case <-ctx.Done():
+		log.Printf("stopping vault dev server")
+		return nil
+	case err := <-exited:
+		return fmt.Errorf("vault exited: %v (see %s)", err, logPath)
+	}
+}
+
+// installPlugin copies a prebuilt plugin binary to dst, or builds one there,
+// with the race detector if race is set.
+func installPlugin(ctx context.Context, src, dst string, race bool) error {
+	if src == "" {
+		log.Printf("building %s", pluginName)
+		args := []string{"build", "-o", dst}
+		if race {
+			args = append(args, "-race")
+		}
+		build := exec.CommandContext(ctx, "go", append(args, "./cmd/"+pluginName)...)
+		build.Stdout = os.Stderr
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			return fmt.Errorf("build plugin (run from the repository root or pass -plugin): %w", err)
+		}
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o700)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// waitReady polls the dev server until it is unsealed.
+func waitReady(ctx context.Context, client *api.Client, exited <-chan error) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	for {
+		if health, err := client.Sys().HealthWithContext(ctx); err == nil && health.Initialized && !health.Sealed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("vault did not become ready within %s", startTimeout)
+		case err := <-exited:
+			return fmt.Errorf("vault exited during startup: %v", err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// setup registers and mounts the plugin and writes a test key.
+func setup(ctx context.Context, client *api.Client, sum, mount string, dimension int) error {
+	// Re-register over the dev server's automatic registration to enable
+	// the plugin's dev-only endpoints.
+	if err := client.Sys().RegisterPluginWithContext(ctx, &api.RegisterPluginInput{
+		Name:    pluginName,
+		Type:    api.PluginTypeSecrets,
+		Command: pluginName,
+		SHA256:  sum,
+		Env:     []string{"VECTOR_DPE_DEV_MODE=true"},
+	}); err != nil {
+		return fmt.Errorf("register plugin: %w", err)
+	}
+	if err := client.Sys().MountWithContext(ctx, mount, &api.MountInput{Type: pluginName}); err != nil {
+		return fmt.Errorf("mount plugin: %w", err)
+	}
+	if _, err := client.Logical().WriteWithContext(ctx, mount+"/config/rotate", map[string]interface{}{
+		"dimension": dimension,
+	}); err != nil {
+		return fmt.Errorf("seed test key: %w", err)
+	}
+	return nil
+}
+
+// printUsage prints ready-to-copy commands for the running dev server.
+// examplePath holds a sample vector of the key's dimension.
+func printUsage(addr, mount string, dimension int, examplePath string) {
+	fmt.Printf(`
+Vault dev server is ready with %[2]s mounted at %[3]s/ (dimension %[4]d).
+
+  export VAULT_ADDR=http://%[1]s
+  export VAULT_TOKEN=%[5]s
+
+  vault read %[3]s/status
+  vault write %[3]s/encrypt/vector vector=@%[6]s
+  vault write %[3]s/debug/compare vector_a=@%[6]s vector_b=@%[6]s
+  vault read %[3]s/verify/security-margin
+
+Press Ctrl-C to stop the server and remove all temporary files.
+`, addr, pluginName, mount, dimension, rootToken, examplePath)
+}
+
+// exampleVector returns a JSON array of dimension small values.
+func exampleVector(dimension int) string {
+	b := make([]byte, 0, dimension*5)
+	b = append(b, '[')
+	for i := 0; i < dimension; i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, fmt.Sprintf("0.%d", i%10)...)
+	}
+	return string(append(b, ']'))
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package main is vector-dpe-extproc, an Envoy external processor that
+// encrypts embeddings responses through a Vault mount of the plugin:
+//
+//	VAULT_ADDR=... VAULT_TOKEN=... vector-dpe-extproc -listen :9002 -mount vector -key products
+//
+// See package internal/extproc.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/extproc"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+func main() {
+	listen := flag.String("listen", ":9002", "Address to serve gRPC on; unix:/path for a Unix socket.")
+	mount := flag.String("mount", "vector", "Mount path of the plugin.")
+	role := flag.String("key", "", "Role to encrypt under (default: the mount's key).")
+	opsListen := flag.String("ops-listen", ":9090", "Address to serve /healthz, /readyz and /metrics on; empty disables.")
+	drainDelay := flag.Duration("drain-delay", 5*time.Second, "After SIGTERM, how long /readyz fails before the server stops accepting streams.")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long open streams get to finish before they are cut.")
+	flag.Parse()
+
+	// The client reads VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE and the
+	// VAULT_CACERT family from the environment.
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatalf("failed to create Vault client: %v", err)
+	}
+
+	network, address := "tcp", *listen
+	if path, ok := strings.CutPrefix(*listen, "unix:"); ok {
+		network, address = "unix", path
+		_ = os.Remove(path)
+	}
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *listen, err)
+	}
+
+	vault := &vaultenc.Client{Vault: client, Mount: *mount, Role: *role}
+	metrics := ops.NewRegistry()
+	opsServer := &ops.Server{Ready: vault.Ready, Metrics: metrics}
+	if *opsListen != "" {
+		if _, err := opsServer.Listen(*opsListen); err != nil {
+			log.Fatalf("failed to listen on %s: %v", *opsListen, err)
+		}
+	}
+	server := (&extproc.Server{Encrypter: vault, Metrics: metrics}).NewGRPCServer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Fail readiness first so Kubernetes stops routing new streams
+		// here, then let the open ones finish.
+		opsServer.Drain()
+		time.Sleep(*drainDelay)
+		timer := time.AfterFunc(*drainTimeout, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	}()
+
+	log.Printf("serving ext_proc on %s for mount %q", *listen, *mount)
+	if err := server.Serve(lis); err != nil {
+		log.Fatalf("ext_proc server exited with error: %v", err)
+	}
+}
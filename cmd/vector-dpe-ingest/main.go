@@ -0,0 +1,107 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package main is vector-dpe-ingest, a mutually authenticated gRPC
+// streaming service for bulk encryption through a Vault mount of the
+// plugin:
+//
+//	VAULT_ADDR=... VAULT_TOKEN=... vector-dpe-ingest -listen :9443 -mount vector -key products \
+//	    -tls-cert server.pem -tls-key server-key.pem -client-ca clients-ca.pem
+//
+// See package internal/ingest.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ingest"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+func main() {
+	listen := flag.String("listen", ":9443", "Address to serve gRPC on.")
+	mount := flag.String("mount", "vector", "Mount path of the plugin.")
+	role := flag.String("key", "", "Role to encrypt under (default: the mount's key).")
+	tlsCert := flag.String("tls-cert", "", "Server certificate (PEM). Required.")
+	tlsKey := flag.String("tls-key", "", "Server private key (PEM). Required.")
+	clientCA := flag.String("client-ca", "", "CA certificates (PEM) that issue client certificates. Required.")
+	allowedClients := flag.String("allowed-clients", "", "Comma-separated client names (DNS SAN or common name) to admit; empty admits any certificate from -client-ca.")
+	batchSize := flag.Int("batch-size", vaultenc.MaxBatchVectors, "Most vectors per encrypt/batch request.")
+	flushInterval := flag.Duration("flush-interval", 20*time.Millisecond, "How long a partial batch waits for more vectors.")
+	opsListen := flag.String("ops-listen", ":9090", "Address to serve /healthz, /readyz and /metrics on; empty disables.")
+	drainDelay := flag.Duration("drain-delay", 5*time.Second, "After SIGTERM, how long /readyz fails before the server stops accepting streams.")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long open streams get to finish before they are cut.")
+	flag.Parse()
+
+	if *tlsCert == "" || *tlsKey == "" || *clientCA == "" {
+		log.Fatal("-tls-cert, -tls-key and -client-ca are required: the service only accepts mutually authenticated clients")
+	}
+	var allowed []string
+	for _, name := range strings.Split(*allowedClients, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed = append(allowed, name)
+		}
+	}
+	tlsConfig, err := ingest.ServerTLSConfig(*tlsCert, *tlsKey, *clientCA, allowed)
+	if err != nil {
+		log.Fatalf("failed to load TLS configuration: %v", err)
+	}
+
+	// The client reads VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE and the
+	// VAULT_CACERT family from the environment.
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatalf("failed to create Vault client: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *listen, err)
+	}
+
+	vault := &vaultenc.Client{Vault: client, Mount: *mount, Role: *role}
+	metrics := ops.NewRegistry()
+	opsServer := &ops.Server{Ready: vault.Ready, Metrics: metrics}
+	if *opsListen != "" {
+		if _, err := opsServer.Listen(*opsListen); err != nil {
+			log.Fatalf("failed to listen on %s: %v", *opsListen, err)
+		}
+	}
+	server := (&ingest.Server{
+		Encrypter:     vault,
+		BatchSize:     *batchSize,
+		FlushInterval: *flushInterval,
+		Metrics:       metrics,
+	}).NewGRPCServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Fail readiness first so Kubernetes stops routing new streams
+		// here, then let the open ones finish.
+		opsServer.Drain()
+		time.Sleep(*drainDelay)
+		timer := time.AfterFunc(*drainTimeout, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	}()
+
+	log.Printf("serving ingest on %s for mount %q", *listen, *mount)
+	if err := server.Serve(lis); err != nil {
+		log.Fatalf("ingest server exited with error: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package main is vector-dpe-stream, a NATS JetStream consumer that
+// encrypts embedding messages through a Vault mount of the plugin:
+//
+//	VAULT_ADDR=... VAULT_TOKEN=... vector-dpe-stream -nats nats://nats:4222 \
+//	    -stream EMBEDDINGS -consumer encryptor -out-subject embeddings.encrypted
+//
+// See package internal/stream.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/stream"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+func main() {
+	natsURL := flag.String("nats", envOr("NATS_URL", "nats://127.0.0.1:4222"), "NATS server URL; tls:// requires TLS. Credentials may be given as user:pass@ or token@.")
+	streamName := flag.String("stream", "", "JetStream stream to consume from.")
+	consumer := flag.String("consumer", "", "Durable pull consumer on -stream; it must exist.")
+	outSubject := flag.String("out-subject", "", "Subject to publish encrypted messages to; a stream must store it.")
+	dlqSubject := flag.String("dlq-subject", "", "Subject for messages that cannot be encrypted (default: drop and log).")
+	field := flag.String("field", "embedding", "JSON field holding the embedding.")
+	batchSize := flag.Int("batch-size", 256, "Most messages encrypted per request.")
+	maxWait := flag.Duration("max-wait", time.Second, "How long to wait to fill a batch.")
+	retryDelay := flag.Duration("retry-delay", 5*time.Second, "Redelivery delay after a transient failure.")
+	mount := flag.String("mount", "vector", "Mount path of the plugin.")
+	role := flag.String("key", "", "Role to encrypt under (default: the mount's key).")
+	opsListen := flag.String("ops-listen", ":9090", "Address to serve /healthz, /readyz and /metrics on; empty disables.")
+	flag.Parse()
+
+	if *streamName == "" || *consumer == "" || *outSubject == "" {
+		log.Fatal("-stream, -consumer and -out-subject are required")
+	}
+
+	// The client reads VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE and the
+	// VAULT_CACERT family from the environment.
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatalf("failed to create Vault client: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	js, err := stream.DialJetStream(ctx, *natsURL, nil)
+	if err != nil {
+		log.Fatalf("failed to connect to NATS: %v", err)
+	}
+	defer js.Close()
+	js.Stream, js.Consumer = *streamName, *consumer
+
+	vault := &vaultenc.Client{Vault: client, Mount: *mount, Role: *role}
+	metrics := ops.NewRegistry()
+	opsServer := &ops.Server{
+		Ready: func(ctx context.Context) error {
+			if err := js.Err(); err != nil {
+				return err
+			}
+			return vault.Ready(ctx)
+		},
+		Metrics: metrics,
+	}
+	metrics.GaugeFunc("vector_dpe_stream_connected", "1 while the NATS connection is open.", func() float64 {
+		if js.Err() != nil {
+			return 0
+		}
+		return 1
+	})
+	if *opsListen != "" {
+		if _, err := opsServer.Listen(*opsListen); err != nil {
+			log.Fatalf("failed to listen on %s: %v", *opsListen, err)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		opsServer.Drain()
+	}()
+
+	// On SIGTERM, Run stops fetching and finishes the batch in flight.
+	c := &stream.Consumer{
+		Broker:     js,
+		Encrypter:  vault,
+		OutSubject: *outSubject,
+		DLQSubject: *dlqSubject,
+		Field:      *field,
+		BatchSize:  *batchSize,
+		MaxWait:    *maxWait,
+		RetryDelay: *retryDelay,
+		Metrics:    metrics,
+	}
+	log.Printf("consuming %s/%s into %s for mount %q", *streamName, *consumer, *outSubject, *mount)
+	if err := c.Run(ctx); err != nil {
+		log.Fatalf("consumer exited with error: %v", err)
+	}
+}
+
+// envOr returns the environment variable key, or def if it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cli implements vault-vector, a command-line client for the
+// plugin. The Vault CLI cannot load subcommands from plugins, so this is a
+// separate binary; it reads the same VAULT_ADDR, VAULT_TOKEN and TLS
+// environment variables as vault itself.
+//
+// It spares users hand-crafted JSON arrays: vectors are read from JSON,
+// NDJSON, NumPy .npy or packed float32 files, split into requests under the
+// plugin's limits, sent as packed float32 frames when that loses nothing,
+// and the response envelopes are unwrapped into a file of ciphertexts in
+// any of the same formats.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// usage is printed for -h and unknown commands.
+const usage = `Usage: vault-vector <command> [flags]
+
+Commands:
+  encrypt    Encrypt vectors from a file, stdin or the command line
+  config     Show the parameters of the mount's current key
+
+Run "vault-vector <command> -h" for a command's flags. The Vault address,
+token and TLS settings come from the usual VAULT_* environment variables.
+`
+
+// streams are the process's standard streams, replaceable in tests.
+type streams struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run runs the command line args (without the program name) and returns
+// the process exit code.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	s := &streams{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "encrypt":
+		err = s.encrypt(ctx, args[1:])
+	case "config":
+		err = s.config(ctx, args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "vault-vector: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "vault-vector %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet returns a flag set for a command, with the flags every
+// command shares.
+func (s *streams) newFlagSet(name string) (*flag.FlagSet, *client) {
+	fs := flag.NewFlagSet("vault-vector "+name, flag.ContinueOnError)
+	fs.SetOutput(s.stderr)
+	c := &client{}
+	fs.StringVar(&c.mount, "mount", "vector", "Mount path of the plugin.")
+	return fs, c
+}
+
+// connect creates the Vault client from the environment.
+func connect(c *client) error {
+	vault, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return fmt.Errorf("create vault client: %w", err)
+	}
+	c.vault = vault
+	return nil
+}
+
+// config prints the current key's parameters.
+func (s *streams) config(ctx context.Context, args []string) error {
+	fs, c := s.newFlagSet("config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := connect(c); err != nil {
+		return err
+	}
+	data, err := c.readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := prettyJSON(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.stdout.Write(out)
+	return err
+}
+
+// encrypt encrypts vectors and writes the ciphertexts in input order.
+func (s *streams) encrypt(ctx context.Context, args []string) error {
+	fs, c := s.newFlagSet("encrypt")
+	fs.StringVar(&c.role, "key", "", "Role whose key to encrypt under (default: the mount key).")
+	fs.IntVar(&c.batchSize, "batch-size", maxBatchVectors, "Vectors per request.")
+	file := fs.String("file", "-", "Input file, or - for stdin.")
+	vector := fs.String("vector", "", "A single vector as a JSON array, instead of -file.")
+	inFormat := fs.String("format", "", "Input format: json, ndjson, npy or f32 (default: from the -file extension, else json).")
+	outPath := fs.String("out", "-", "Output file, or - for stdout.")
+	outFormat := fs.String("out-format", "", "Output format: json, ndjson, npy or f32 (default: from the -out extension, else json).")
+	dimension := fs.Int("dimension", 0, "Dimension of f32 input (default: the key's).")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if c.batchSize <= 0 {
+		return fmt.Errorf("-batch-size must be positive")
+	}
+	if *outFormat == "" {
+		*outFormat = formatFromPath(*outPath, formatJSON)
+	}
+	if err := connect(c); err != nil {
+		return err
+	}
+
+	var input *vectorFile
+	var err error
+	if *vector != "" {
+		input, err = readVectors(strings.NewReader(*vector), formatJSON, 0)
+	} else {
+		format := *inFormat
+		if format == "" {
+			format = formatFromPath(*file, formatJSON)
+		}
+		if format == formatF32 && *dimension == 0 {
+			if *dimension, err = c.dimension(ctx); err != nil {
+				return err
+			}
+		}
+		input, err = s.readInput(*file, format, *dimension)
+	}
+	if err != nil {
+		return err
+	}
+	if len(input.Vectors) == 0 {
+		return fmt.Errorf("no vectors in the input")
+	}
+
+	// Float32 input loses nothing as a packed frame, which is far cheaper
+	// to send than JSON; other input keeps full precision in JSON.
+	var ciphertexts [][]float64
+	var params *schemeParams
+	if input.Float32 {
+		ciphertexts, params, err = c.encryptRaw(ctx, input.Vectors, len(input.Vectors[0]))
+	} else {
+		ciphertexts, params, err = c.encryptBatch(ctx, input.Vectors, func(w string) {
+			fmt.Fprintf(s.stderr, "warning: %s\n", w)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return s.writeOutput(*outPath, *outFormat, ciphertexts, params, input.Float32)
+}
+
+// readInput reads vectors from a file, or from stdin for "-".
+func (s *streams) readInput(path, format string, dimension int) (*vectorFile, error) {
+	if path == "-" {
+		return readVectors(s.stdin, format, dimension)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readVectors(f, format, dimension)
+}
+
+// writeOutput writes ciphertexts to a file, or to stdout for "-".
+func (s *streams) writeOutput(path, format string, ciphertexts [][]float64, params *schemeParams, narrow bool) error {
+	if path == "-" {
+		return writeCiphertexts(s.stdout, format, ciphertexts, params, narrow)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeCiphertexts(f, format, ciphertexts, params, narrow); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
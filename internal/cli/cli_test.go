@@ -0,0 +1,179 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves the plugin endpoints the CLI uses. Its "encryption"
+// negates each component, and every batch response ends with a canary.
+type fakeVault struct {
+	t        *testing.T
+	requests []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("X-Vault-Token") != "test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/vector/config":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"dimension": 3},
+		})
+	case "/v1/vector/encrypt/batch/products":
+		var body struct {
+			Vectors [][]float64 `json:"vectors"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("decode batch: %v", err)
+		}
+		var results []map[string]interface{}
+		for _, vector := range body.Vectors {
+			if len(vector) != 3 {
+				results = append(results, map[string]interface{}{"error": "dimension mismatch"})
+				continue
+			}
+			results = append(results, map[string]interface{}{"ciphertext": negate(vector)})
+		}
+		results = append(results, map[string]interface{}{"ciphertext": []float64{9, 9, 9}, "canary": true})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"batch_results": results,
+				"scheme":        "sap",
+				"key_version":   2,
+				"dimension":     3,
+				"transform_id":  "abc123",
+			},
+		})
+	case "/v1/vector/encrypt/raw":
+		var body struct {
+			Frame string `json:"frame"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("decode raw: %v", err)
+		}
+		frame, _ := base64.StdEncoding.DecodeString(body.Frame)
+		vectors, err := unpackFloat32(frame, 3)
+		if err != nil {
+			f.t.Errorf("raw frame: %v", err)
+		}
+		for i := range vectors {
+			vectors[i] = negate(vectors[i])
+		}
+		w.Header().Set("X-Vector-Dpe-Transform-Id", "abc123")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(packFloat32(nil, vectors))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func negate(vector []float64) []float64 {
+	out := make([]float64, len(vector))
+	for i, v := range vector {
+		out[i] = -v
+	}
+	return out
+}
+
+// runCLI runs the CLI against a fake Vault and returns its exit code,
+// stdout and stderr.
+func runCLI(t *testing.T, fake *fakeVault, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestEncryptJSONBatches(t *testing.T) {
+	fake := &fakeVault{t: t}
+	code, stdout, stderr := runCLI(t, fake, "[[1, 2, 3], [4, 5, 6], [7, 8, 9]]",
+		"encrypt", "-key", "products", "-batch-size", "2")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var out struct {
+		schemeParams
+		Ciphertexts [][]float64 `json:"ciphertexts"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Ciphertexts) != 3 || out.Ciphertexts[2][0] != -7 {
+		t.Errorf("ciphertexts = %v; canaries must be dropped and order kept", out.Ciphertexts)
+	}
+	if out.TransformID != "abc123" || out.KeyVersion != 2 {
+		t.Errorf("scheme parameters = %+v", out.schemeParams)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("requests = %v, want two batches", fake.requests)
+	}
+}
+
+func TestEncryptNPYUsesRawFrames(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "vectors.npy")
+	var npy bytes.Buffer
+	if err := writeNPY(&npy, [][]float64{{1, 2, 3}, {0.5, 0, -1}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(in, npy.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "ciphertexts.npy")
+
+	fake := &fakeVault{t: t}
+	if code, _, stderr := runCLI(t, fake, "", "encrypt", "-file", in, "-out", out); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if len(fake.requests) != 1 || fake.requests[0] != "PUT /v1/vector/encrypt/raw" {
+		t.Errorf("requests = %v, want one raw frame", fake.requests)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, narrow, err := readNPY(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !narrow || len(got) != 2 || got[1][0] != -0.5 {
+		t.Errorf("output = %v (float32=%v)", got, narrow)
+	}
+}
+
+func TestEncryptReportsFailedVector(t *testing.T) {
+	code, _, stderr := runCLI(t, &fakeVault{t: t}, "[1, 2, 3]\n[4, 5]\n",
+		"encrypt", "-key", "products", "-format", "ndjson")
+	if code != 1 || !strings.Contains(stderr, "vector 1: dimension mismatch") {
+		t.Errorf("exit %d, stderr %q; want the failing vector's index", code, stderr)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	if code, _, _ := runCLI(t, &fakeVault{t: t}, "", "decrypt"); code != 2 {
+		t.Errorf("unknown command exited %d, want 2", code)
+	}
+	if code, stdout, _ := runCLI(t, &fakeVault{t: t}, "", "config"); code != 0 || !strings.Contains(stdout, `"dimension": 3`) {
+		t.Errorf("config exited %d with %q", code, stdout)
+	}
+}
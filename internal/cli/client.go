@@ -0,0 +1,269 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// maxBatchVectors is the plugin's encrypt/batch limit.
+	maxBatchVectors = 1024
+
+	// maxRawFrameVectors is the plugin's encrypt/raw limit.
+	maxRawFrameVectors = 4096
+)
+
+// schemeParams are the scheme parameters the plugin reports with each
+// response; see the plugin's scheme.go.
+type schemeParams struct {
+	Scheme      string `json:"scheme,omitempty"`
+	KeyVersion  int    `json:"key_version,omitempty"`
+	Dimension   int    `json:"dimension,omitempty"`
+	TransformID string `json:"transform_id,omitempty"`
+}
+
+// client sends requests to one mount of the plugin, under an optional role.
+type client struct {
+	vault *api.Client
+	mount string
+	role  string
+
+	// batchSize is the number of vectors per request.
+	batchSize int
+}
+
+// path returns the path of an endpoint that takes an optional role.
+func (c *client) path(endpoint string) string {
+	p := strings.Trim(c.mount, "/") + "/" + endpoint
+	if c.role != "" {
+		p += "/" + c.role
+	}
+	return p
+}
+
+// readConfig returns the data of the mount's config endpoint.
+func (c *client) readConfig(ctx context.Context) (map[string]interface{}, error) {
+	secret, err := c.vault.Logical().ReadWithContext(ctx, strings.Trim(c.mount, "/")+"/config")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no key configured at %s", c.mount)
+	}
+	return secret.Data, nil
+}
+
+// dimension returns the dimension of the mount's current key.
+func (c *client) dimension(ctx context.Context) (int, error) {
+	data, err := c.readConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+	dimension, err := toInt(data["dimension"])
+	if err != nil {
+		return 0, fmt.Errorf("config dimension: %w", err)
+	}
+	return dimension, nil
+}
+
+// encryptBatch encrypts vectors through encrypt/batch, batchSize at a time.
+// Any failed item fails the run, naming the vector's index in the input.
+func (c *client) encryptBatch(ctx context.Context, vectors [][]float64, warn func(string)) ([][]float64, *schemeParams, error) {
+	size := min(c.batchSize, maxBatchVectors)
+	out := make([][]float64, 0, len(vectors))
+	var params *schemeParams
+	for start := 0; start < len(vectors); start += size {
+		chunk := vectors[start:min(start+size, len(vectors))]
+		secret, err := c.vault.Logical().WriteWithContext(ctx, c.path("encrypt/batch"), map[string]interface{}{
+			"vectors": chunk,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("vectors %d-%d: %w", start, start+len(chunk)-1, err)
+		}
+		if secret == nil {
+			return nil, nil, fmt.Errorf("vectors %d-%d: empty response", start, start+len(chunk)-1)
+		}
+		for _, w := range secret.Warnings {
+			warn(w)
+		}
+		if params, err = sameParams(params, responseParams(secret.Data)); err != nil {
+			return nil, nil, err
+		}
+
+		items, _ := secret.Data["batch_results"].([]interface{})
+		n := 0
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			if canary, _ := item["canary"].(bool); canary {
+				// Canaries are decoys for leak detection, not results.
+				continue
+			}
+			if msg, ok := item["error"].(string); ok && msg != "" {
+				return nil, nil, fmt.Errorf("vector %d: %s", start+n, msg)
+			}
+			ciphertext, err := toFloats(item["ciphertext"])
+			if err != nil {
+				return nil, nil, fmt.Errorf("vector %d: %w", start+n, err)
+			}
+			for _, w := range stringList(item["warnings"]) {
+				warn(fmt.Sprintf("vector %d: %s", start+n, w))
+			}
+			out = append(out, ciphertext)
+			n++
+		}
+		if n != len(chunk) {
+			return nil, nil, fmt.Errorf("vectors %d-%d: got %d results", start, start+len(chunk)-1, n)
+		}
+	}
+	return out, params, nil
+}
+
+// encryptRaw encrypts vectors of the given dimension as packed float32
+// frames through encrypt/raw.
+func (c *client) encryptRaw(ctx context.Context, vectors [][]float64, dimension int) ([][]float64, *schemeParams, error) {
+	size := min(c.batchSize, maxRawFrameVectors)
+	out := make([][]float64, 0, len(vectors))
+	var params *schemeParams
+	for start := 0; start < len(vectors); start += size {
+		chunk := vectors[start:min(start+size, len(vectors))]
+		body, err := json.Marshal(map[string]string{
+			"frame": base64.StdEncoding.EncodeToString(packFloat32(nil, chunk)),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := c.vault.Logical().WriteRawWithContext(ctx, c.path("encrypt/raw"), body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("vectors %d-%d: %w", start, start+len(chunk)-1, err)
+		}
+		frame, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("vectors %d-%d: %w", start, start+len(chunk)-1, err)
+		}
+		if params, err = sameParams(params, headerParams(resp)); err != nil {
+			return nil, nil, err
+		}
+		ciphertexts, err := unpackFloat32(frame, dimension)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ciphertexts) != len(chunk) {
+			return nil, nil, fmt.Errorf("vectors %d-%d: got %d ciphertexts", start, start+len(chunk)-1, len(ciphertexts))
+		}
+		out = append(out, ciphertexts...)
+	}
+	return out, params, nil
+}
+
+// sameParams checks that every response of a run came from one transform:
+// a rotation mid-run would otherwise mix incomparable ciphertexts.
+func sameParams(prev, next *schemeParams) (*schemeParams, error) {
+	if prev == nil {
+		return next, nil
+	}
+	if next != nil && next.TransformID != prev.TransformID {
+		return nil, fmt.Errorf("the key changed during the run (transform %s, then %s); encrypt again", prev.TransformID, next.TransformID)
+	}
+	return prev, nil
+}
+
+// responseParams returns the scheme parameters of a JSON response, or nil
+// for a plugin too old to report them.
+func responseParams(data map[string]interface{}) *schemeParams {
+	id, _ := data["transform_id"].(string)
+	if id == "" {
+		return nil
+	}
+	params := &schemeParams{TransformID: id}
+	params.Scheme, _ = data["scheme"].(string)
+	params.KeyVersion, _ = toInt(data["key_version"])
+	params.Dimension, _ = toInt(data["dimension"])
+	return params
+}
+
+// headerParams returns the scheme parameters of a raw response's headers.
+func headerParams(resp *api.Response) *schemeParams {
+	id := resp.Header.Get("X-Vector-Dpe-Transform-Id")
+	if id == "" {
+		return nil
+	}
+	params := &schemeParams{
+		Scheme:      resp.Header.Get("X-Vector-Dpe-Scheme"),
+		TransformID: id,
+	}
+	params.KeyVersion, _ = strconv.Atoi(resp.Header.Get("X-Vector-Dpe-Key-Version"))
+	params.Dimension, _ = strconv.Atoi(resp.Header.Get("X-Vector-Dpe-Dimension"))
+	return params
+}
+
+// toFloats converts a decoded JSON array of numbers.
+func toFloats(v interface{}) ([]float64, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of numbers, got %T", v)
+	}
+	out := make([]float64, len(items))
+	for i, item := range items {
+		switch n := item.(type) {
+		case json.Number:
+			f, err := n.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out[i] = f
+		case float64:
+			out[i] = n
+		default:
+			return nil, fmt.Errorf("element %d: expected a number, got %T", i, item)
+		}
+	}
+	return out, nil
+}
+
+// toInt converts a decoded JSON number.
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
+
+// stringList converts a decoded JSON array of strings, skipping others.
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// prettyJSON renders data for terminal output.
+func prettyJSON(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
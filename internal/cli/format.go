@@ -0,0 +1,201 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// File formats for vectors and ciphertexts.
+const (
+	// formatJSON is a JSON array of vectors, or a single vector.
+	formatJSON = "json"
+
+	// formatNDJSON is one JSON array, or {"vector": [...]} object, per line.
+	formatNDJSON = "ndjson"
+
+	// formatNPY is a NumPy .npy array of float32 or float64.
+	formatNPY = "npy"
+
+	// formatF32 is packed little-endian float32, the encrypt/raw layout.
+	formatF32 = "f32"
+)
+
+// float32Size is the size of one packed float32 component.
+const float32Size = 4
+
+// formatFromPath infers a format from a file extension, or returns def.
+func formatFromPath(path, def string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".ndjson", ".jsonl":
+		return formatNDJSON
+	case ".npy":
+		return formatNPY
+	case ".f32", ".bin":
+		return formatF32
+	}
+	return def
+}
+
+// vectorFile is a set of vectors read from a file.
+type vectorFile struct {
+	Vectors [][]float64
+
+	// Float32 is set when the input stored float32, so the ciphertexts
+	// lose nothing by travelling as a packed float32 frame.
+	Float32 bool
+}
+
+// readVectors reads vectors in the given format. Packed float32 carries no
+// shape, so dimension must be given for formatF32.
+func readVectors(r io.Reader, format string, dimension int) (*vectorFile, error) {
+	switch format {
+	case formatJSON:
+		return readJSONVectors(r)
+	case formatNDJSON:
+		return readNDJSONVectors(r)
+	case formatNPY:
+		vectors, narrow, err := readNPY(r)
+		if err != nil {
+			return nil, err
+		}
+		return &vectorFile{Vectors: vectors, Float32: narrow}, nil
+	case formatF32:
+		if dimension <= 0 {
+			return nil, fmt.Errorf("packed float32 input needs a dimension")
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		vectors, err := unpackFloat32(data, dimension)
+		if err != nil {
+			return nil, err
+		}
+		return &vectorFile{Vectors: vectors, Float32: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// readJSONVectors accepts an array of vectors or a single vector.
+func readJSONVectors(r io.Reader) (*vectorFile, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+	var vectors [][]float64
+	if err := json.Unmarshal(raw, &vectors); err == nil {
+		return &vectorFile{Vectors: vectors}, nil
+	}
+	var vector []float64
+	if err := json.Unmarshal(raw, &vector); err != nil {
+		return nil, fmt.Errorf("json input must be a vector or an array of vectors")
+	}
+	return &vectorFile{Vectors: [][]float64{vector}}, nil
+}
+
+// readNDJSONVectors accepts the plugin's NDJSON batch input: one array or
+// {"vector": [...]} object per line, blank lines ignored.
+func readNDJSONVectors(r io.Reader) (*vectorFile, error) {
+	var vectors [][]float64
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var vector []float64
+		if strings.HasPrefix(text, "{") {
+			var object struct {
+				Vector []float64 `json:"vector"`
+			}
+			if err := json.Unmarshal([]byte(text), &object); err != nil {
+				return nil, fmt.Errorf("ndjson line %d: %w", line, err)
+			}
+			if object.Vector == nil {
+				return nil, fmt.Errorf("ndjson line %d: object has no 'vector' field", line)
+			}
+			vector = object.Vector
+		} else if err := json.Unmarshal([]byte(text), &vector); err != nil {
+			return nil, fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+		vectors = append(vectors, vector)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ndjson: %w", err)
+	}
+	return &vectorFile{Vectors: vectors}, nil
+}
+
+// packFloat32 appends vectors as packed little-endian float32.
+func packFloat32(dst []byte, vectors [][]float64) []byte {
+	for _, vector := range vectors {
+		for _, v := range vector {
+			dst = binary.LittleEndian.AppendUint32(dst, math.Float32bits(float32(v)))
+		}
+	}
+	return dst
+}
+
+// unpackFloat32 splits packed little-endian float32 into vectors of the
+// given dimension.
+func unpackFloat32(data []byte, dimension int) ([][]float64, error) {
+	stride := dimension * float32Size
+	if len(data)%stride != 0 {
+		return nil, fmt.Errorf("packed float32 data of %d bytes is not a whole number of %d-dimensional vectors", len(data), dimension)
+	}
+	vectors := make([][]float64, len(data)/stride)
+	for i := range vectors {
+		vector := make([]float64, dimension)
+		for j := range vector {
+			vector[j] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*stride+j*float32Size:])))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// writeCiphertexts writes ciphertexts in the given format. JSON output is
+// an object that also carries the scheme parameters of the key, so that a
+// file of ciphertexts records what produced it.
+func writeCiphertexts(w io.Writer, format string, ciphertexts [][]float64, params *schemeParams, narrow bool) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			*schemeParams
+			Ciphertexts [][]float64 `json:"ciphertexts"`
+		}{params, ciphertexts})
+	case formatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, ciphertext := range ciphertexts {
+			if err := enc.Encode(struct {
+				*schemeParams
+				Ciphertext []float64 `json:"ciphertext"`
+			}{params, ciphertext}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case formatNPY:
+		return writeNPY(w, ciphertexts, narrow)
+	case formatF32:
+		_, err := w.Write(packFloat32(nil, ciphertexts))
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
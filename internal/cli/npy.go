@@ -0,0 +1,175 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// npyMagic starts every NumPy .npy file.
+const npyMagic = "\x93NUMPY"
+
+// maxNPYHeader bounds the header we are willing to read.
+const maxNPYHeader = 1 << 16
+
+var (
+	npyDescrRegex   = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	npyFortranRegex = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	npyShapeRegex   = regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`)
+)
+
+// readNPY decodes a 1-D (one vector) or 2-D (one vector per row) .npy array
+// of little-endian float32 or float64. It reports whether the array was
+// float32, so output can keep the input's width.
+func readNPY(r io.Reader) ([][]float64, bool, error) {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, false, fmt.Errorf("read npy magic: %w", err)
+	}
+	if string(prefix[:len(npyMagic)]) != npyMagic {
+		return nil, false, fmt.Errorf("not a .npy file")
+	}
+
+	var headerLen int
+	switch major := prefix[len(npyMagic)]; major {
+	case 1:
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, false, fmt.Errorf("read npy header length: %w", err)
+		}
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, false, fmt.Errorf("read npy header length: %w", err)
+		}
+		if n > maxNPYHeader {
+			return nil, false, fmt.Errorf("npy header of %d bytes is too large", n)
+		}
+		headerLen = int(n)
+	default:
+		return nil, false, fmt.Errorf("unsupported npy version %d", major)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, false, fmt.Errorf("read npy header: %w", err)
+	}
+
+	descr := npyDescrRegex.FindSubmatch(header)
+	fortran := npyFortranRegex.FindSubmatch(header)
+	shape := npyShapeRegex.FindSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return nil, false, fmt.Errorf("malformed npy header %q", strings.TrimSpace(string(header)))
+	}
+	var size int
+	switch string(descr[1]) {
+	case "<f4":
+		size = 4
+	case "<f8":
+		size = 8
+	default:
+		return nil, false, fmt.Errorf("unsupported npy dtype %q; use little-endian float32 or float64", descr[1])
+	}
+	rows, cols, err := parseNPYShape(string(shape[1]))
+	if err != nil {
+		return nil, false, err
+	}
+
+	data := make([]byte, rows*cols*size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, false, fmt.Errorf("read npy data: %w", err)
+	}
+	vectors := make([][]float64, rows)
+	for i := range vectors {
+		vectors[i] = make([]float64, cols)
+	}
+	for k := 0; k < rows*cols; k++ {
+		// Fortran order stores the array column by column.
+		i, j := k/cols, k%cols
+		if string(fortran[1]) == "True" {
+			i, j = k%rows, k/rows
+		}
+		if size == 4 {
+			vectors[i][j] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[k*4:])))
+		} else {
+			vectors[i][j] = math.Float64frombits(binary.LittleEndian.Uint64(data[k*8:]))
+		}
+	}
+	return vectors, size == 4, nil
+}
+
+// parseNPYShape returns the rows and columns of a 1-D or 2-D shape tuple.
+func parseNPYShape(tuple string) (int, int, error) {
+	var dims []int
+	for _, field := range strings.Split(tuple, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid npy shape (%s)", tuple)
+		}
+		dims = append(dims, n)
+	}
+	switch len(dims) {
+	case 1:
+		return 1, dims[0], nil
+	case 2:
+		if dims[0] > 0 && dims[1] > math.MaxInt32/dims[0] {
+			return 0, 0, fmt.Errorf("npy shape (%s) is too large", tuple)
+		}
+		return dims[0], dims[1], nil
+	default:
+		return 0, 0, fmt.Errorf("npy shape (%s) must have 1 or 2 dimensions", tuple)
+	}
+}
+
+// writeNPY encodes vectors as a 2-D version 1.0 .npy array of
+// little-endian float32, or float64 unless narrow is set.
+func writeNPY(w io.Writer, vectors [][]float64, narrow bool) error {
+	cols := 0
+	if len(vectors) > 0 {
+		cols = len(vectors[0])
+	}
+	descr, size := "<f8", 8
+	if narrow {
+		descr, size = "<f4", 4
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%d, %d), }", descr, len(vectors), cols)
+	// Pad with spaces so the data starts on a 64-byte boundary.
+	total := len(npyMagic) + 2 + 2 + len(header) + 1
+	header += strings.Repeat(" ", (64-total%64)%64) + "\n"
+
+	var buf bytes.Buffer
+	var scratch [8]byte
+	buf.WriteString(npyMagic)
+	buf.Write([]byte{1, 0})
+	buf.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(header))))
+	buf.WriteString(header)
+	for i, vector := range vectors {
+		if len(vector) != cols {
+			return fmt.Errorf("vector %d has %d elements, expected %d", i, len(vector), cols)
+		}
+		for _, v := range vector {
+			if narrow {
+				binary.LittleEndian.PutUint32(scratch[:], math.Float32bits(float32(v)))
+			} else {
+				binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v))
+			}
+			buf.Write(scratch[:size])
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNPYRoundTrip(t *testing.T) {
+	vectors := [][]float64{{1, -2.5, 3}, {0.25, 0, -7}}
+	for _, narrow := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := writeNPY(&buf, vectors, narrow); err != nil {
+			t.Fatal(err)
+		}
+		headerLen := int(binary.LittleEndian.Uint16(buf.Bytes()[8:]))
+		if (10+headerLen)%64 != 0 {
+			t.Errorf("data offset %d is not 64-byte aligned", 10+headerLen)
+		}
+		got, gotNarrow, err := readNPY(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotNarrow != narrow || !reflect.DeepEqual(got, vectors) {
+			t.Errorf("narrow=%v: read %v (narrow=%v), want %v", narrow, got, gotNarrow, vectors)
+		}
+	}
+}
+
+// npyFile assembles a version 1.0 .npy file of float64 data.
+func npyFile(header string, data ...float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(npyMagic + "\x01\x00")
+	buf.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(header))))
+	buf.WriteString(header)
+	for _, v := range data {
+		buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+	}
+	return buf.Bytes()
+}
+
+func TestReadNPYShapes(t *testing.T) {
+	got, _, err := readNPY(bytes.NewReader(npyFile("{'descr': '<f8', 'fortran_order': False, 'shape': (3,), }\n", 1, 2, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, [][]float64{{1, 2, 3}}) {
+		t.Errorf("1-D array read as %v", got)
+	}
+
+	// Column-major: the columns of [[1, 2, 3], [4, 5, 6]].
+	got, _, err = readNPY(bytes.NewReader(npyFile("{'descr': '<f8', 'fortran_order': True, 'shape': (2, 3), }\n", 1, 4, 2, 5, 3, 6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, [][]float64{{1, 2, 3}, {4, 5, 6}}) {
+		t.Errorf("fortran-order array read as %v", got)
+	}
+}
+
+func TestReadNPYRejects(t *testing.T) {
+	for name, file := range map[string][]byte{
+		"not npy":     []byte("PK\x03\x04 not a numpy file"),
+		"big endian":  npyFile("{'descr': '>f8', 'fortran_order': False, 'shape': (1,), }\n", 1),
+		"integers":    npyFile("{'descr': '<i8', 'fortran_order': False, 'shape': (1,), }\n", 1),
+		"3-D":         npyFile("{'descr': '<f8', 'fortran_order': False, 'shape': (1, 1, 1), }\n", 1),
+		"short data":  npyFile("{'descr': '<f8', 'fortran_order': False, 'shape': (2, 2), }\n", 1, 2, 3),
+		"no shape":    npyFile("{'descr': '<f8', 'fortran_order': False, }\n"),
+		"huge header": []byte(npyMagic + "\x02\x00\xff\xff\xff\x00"),
+	} {
+		if _, _, err := readNPY(bytes.NewReader(file)); err == nil {
+			t.Errorf("%s: read without error", name)
+		}
+	}
+}
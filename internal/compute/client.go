@@ -0,0 +1,236 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// GOMAXPROCSEnv and GOMEMLIMITEnv, when set in the plugin's
+	// environment, become the worker's GOMAXPROCS and GOMEMLIMIT, so its
+	// CPU and memory are bounded independently of the plugin's.
+	GOMAXPROCSEnv = "VECTOR_DPE_COMPUTE_GOMAXPROCS"
+	GOMEMLIMITEnv = "VECTOR_DPE_COMPUTE_GOMEMLIMIT"
+
+	// startTimeout bounds how long a new worker takes to listen.
+	startTimeout = 10 * time.Second
+)
+
+// dialFunc starts a worker and connects to it. stop ends the worker.
+type dialFunc func() (client *rpc.Client, stop func(), err error)
+
+// Client sends multiplies to a worker process, starting it on first use
+// and again after it fails. It is safe for concurrent use.
+type Client struct {
+	dial dialFunc
+
+	mu     sync.Mutex
+	rpc    *rpc.Client
+	stop   func()
+	ids    map[*mat.Dense]uint64
+	nextID uint64
+}
+
+// NewClient returns a client whose worker is this executable, re-executed
+// with SocketEnv set. The worker is started on the first multiply.
+func NewClient() (*Client, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("compute: locate plugin executable: %w", err)
+	}
+	return newClient(func() (*rpc.Client, func(), error) {
+		return spawn(executable)
+	}), nil
+}
+
+// newClient returns a client that reaches its worker through dial.
+func newClient(dial dialFunc) *Client {
+	return &Client{dial: dial, ids: make(map[*mat.Dense]uint64)}
+}
+
+// spawn starts a worker from executable on a socket in a new private
+// directory and connects to it.
+func spawn(executable string) (*rpc.Client, func(), error) {
+	dir, err := os.MkdirTemp("", "vector-dpe-compute-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("compute: create socket directory: %w", err)
+	}
+	socket := filepath.Join(dir, "worker.sock")
+
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), SocketEnv+"="+socket)
+	if v := os.Getenv(GOMAXPROCSEnv); v != "" {
+		cmd.Env = append(cmd.Env, "GOMAXPROCS="+v)
+	}
+	if v := os.Getenv(GOMEMLIMITEnv); v != "" {
+		cmd.Env = append(cmd.Env, "GOMEMLIMIT="+v)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// The worker exits when this pipe closes, including when the plugin
+	// process dies without stopping it.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("compute: start worker: %w", err)
+	}
+	stop := func() {
+		stdin.Close()
+		done := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			_ = cmd.Process.Kill()
+			<-done
+		}
+		os.RemoveAll(dir)
+	}
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			return rpc.NewClient(conn), stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, nil, fmt.Errorf("compute: worker did not listen within %s: %w", startTimeout, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Multiply sets outputs[i] to m·inputs[i] in the worker, sending m to it
+// first when the worker does not have it. A worker that fails is restarted
+// once; if the multiply still fails, the error is returned.
+func (c *Client) Multiply(m *mat.Dense, inputs, outputs [][]float64) error {
+	dim, _ := m.Dims()
+	args := &MultiplyArgs{Count: len(inputs), Inputs: make([]float64, 0, len(inputs)*dim)}
+	for _, input := range inputs {
+		args.Inputs = append(args.Inputs, input...)
+	}
+	defer zero(args.Inputs)
+
+	var reply MultiplyReply
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var client *rpc.Client
+		client, args.ID, err = c.connect(m)
+		if err != nil {
+			return err
+		}
+		err = client.Call(serviceName+".Multiply", args, &reply)
+		if err != nil && err.Error() == errUnknownMatrix.Error() {
+			if err = c.load(client, m, args.ID); err == nil {
+				err = client.Call(serviceName+".Multiply", args, &reply)
+			}
+		}
+		if err == nil {
+			break
+		}
+		var serverErr rpc.ServerError
+		if errors.As(err, &serverErr) {
+			// The worker is healthy and refused the request.
+			return err
+		}
+		c.reset(client)
+	}
+	if err != nil {
+		return fmt.Errorf("compute: worker failed: %w", err)
+	}
+	defer zero(reply.Outputs)
+	for i, output := range outputs {
+		copy(output, reply.Outputs[i*dim:(i+1)*dim])
+	}
+	return nil
+}
+
+// Forget tells the worker to zero and drop m, which is about to be zeroed
+// in the plugin. A nil client does nothing.
+func (c *Client) Forget(m *mat.Dense) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	id, ok := c.ids[m]
+	delete(c.ids, m)
+	client := c.rpc
+	c.mu.Unlock()
+	if ok && client != nil {
+		// Callers hold the plugin's cache locks, so do not wait on the
+		// socket. Best effort: a worker that misses this drops m when it
+		// restarts.
+		go func() { _ = client.Call(serviceName+".Forget", id, nil) }()
+	}
+}
+
+// Close stops the worker.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rpc != nil {
+		c.rpc.Close()
+		c.stop()
+		c.rpc, c.stop = nil, nil
+	}
+}
+
+// connect returns a connection to the worker, starting one if none runs,
+// and m's ID.
+func (c *Client) connect(m *mat.Dense) (*rpc.Client, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rpc == nil {
+		client, stop, err := c.dial()
+		if err != nil {
+			return nil, 0, err
+		}
+		c.rpc, c.stop = client, stop
+	}
+	id, ok := c.ids[m]
+	if !ok {
+		c.nextID++
+		id = c.nextID
+		c.ids[m] = id
+	}
+	return c.rpc, id, nil
+}
+
+// load sends m to the worker under id.
+func (c *Client) load(client *rpc.Client, m *mat.Dense, id uint64) error {
+	dim, _ := m.Dims()
+	return client.Call(serviceName+".Load", &LoadArgs{ID: id, Dim: dim, Data: m.RawMatrix().Data}, nil)
+}
+
+// reset stops the worker behind client, unless another caller already
+// replaced it, so the next multiply starts a new one.
+func (c *Client) reset(client *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rpc != client {
+		return
+	}
+	c.rpc.Close()
+	c.stop()
+	c.rpc, c.stop = nil, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package compute runs the plugin's matrix multiplications in a worker
+// process of its own. The plugin binary re-executes itself as the worker
+// and talks to it with net/rpc over a Unix socket in a private directory,
+// so the CPU and the working memory of large multiplies are accounted to,
+// and bounded in, a process that Vault does not talk to. A worker that
+// crashes or is killed for exceeding its memory limit fails only the
+// multiplies in flight, and is restarted on the next one.
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// SocketEnv names the worker's socket path. It is set only in the
+	// environment of the worker process, which is how the binary knows to
+	// run as one.
+	SocketEnv = "VECTOR_DPE_COMPUTE_SOCKET"
+
+	// serviceName is the name the worker registers with net/rpc.
+	serviceName = "Worker"
+)
+
+// errUnknownMatrix is returned by the worker for a matrix it has not been
+// sent, or has forgotten; the client sends it and retries. net/rpc carries
+// errors as strings, so the client matches on the message.
+var errUnknownMatrix = errors.New("compute: unknown matrix")
+
+// LoadArgs sends a matrix to the worker under an ID chosen by the client.
+type LoadArgs struct {
+	ID   uint64
+	Dim  int
+	Data []float64
+}
+
+// MultiplyArgs asks for matrix ID times each of Count vectors, packed one
+// after another in Inputs.
+type MultiplyArgs struct {
+	ID     uint64
+	Count  int
+	Inputs []float64
+}
+
+// MultiplyReply holds the products, packed like MultiplyArgs.Inputs.
+type MultiplyReply struct {
+	Outputs []float64
+}
+
+// Worker is the net/rpc service of the worker process.
+type Worker struct {
+	mu       sync.RWMutex
+	matrices map[uint64]*mat.Dense
+}
+
+// Load stores a matrix sent by the client, replacing any under the ID.
+func (w *Worker) Load(args *LoadArgs, _ *struct{}) error {
+	if args.Dim <= 0 || len(args.Data) != args.Dim*args.Dim {
+		return fmt.Errorf("compute: matrix of %d elements is not %d×%d", len(args.Data), args.Dim, args.Dim)
+	}
+	m := mat.NewDense(args.Dim, args.Dim, args.Data)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.matrices == nil {
+		w.matrices = make(map[uint64]*mat.Dense)
+	}
+	if old := w.matrices[args.ID]; old != nil {
+		zero(old.RawMatrix().Data)
+	}
+	w.matrices[args.ID] = m
+	return nil
+}
+
+// Forget zeroes and drops a matrix.
+func (w *Worker) Forget(id uint64, _ *struct{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if m := w.matrices[id]; m != nil {
+		zero(m.RawMatrix().Data)
+		delete(w.matrices, id)
+	}
+	return nil
+}
+
+// Multiply computes the products of a stored matrix with the vectors of
+// args. The plaintexts are zeroed once multiplied.
+func (w *Worker) Multiply(args *MultiplyArgs, reply *MultiplyReply) error {
+	w.mu.RLock()
+	m := w.matrices[args.ID]
+	w.mu.RUnlock()
+	if m == nil {
+		return errUnknownMatrix
+	}
+	dim, _ := m.Dims()
+	if args.Count <= 0 || len(args.Inputs) != args.Count*dim {
+		return fmt.Errorf("compute: %d inputs are not %d vectors of dimension %d", len(args.Inputs), args.Count, dim)
+	}
+	defer zero(args.Inputs)
+
+	reply.Outputs = make([]float64, len(args.Inputs))
+	if args.Count == 1 {
+		mat.NewVecDense(dim, reply.Outputs).MulVec(m, mat.NewVecDense(dim, args.Inputs))
+		return nil
+	}
+	// Row i of inputs·Mᵀ is M·vᵢ, so the packed layout needs no transposes.
+	inputs := mat.NewDense(args.Count, dim, args.Inputs)
+	mat.NewDense(args.Count, dim, reply.Outputs).Mul(inputs, m.T())
+	return nil
+}
+
+// IsWorker reports whether this process was started as a worker.
+func IsWorker() bool {
+	return os.Getenv(SocketEnv) != ""
+}
+
+// ServeWorker runs the worker on the socket named by SocketEnv. It returns
+// when standard input closes, which happens when the plugin process exits
+// or stops the worker, so an orphaned worker never outlives the plugin.
+func ServeWorker() error {
+	path := os.Getenv(SocketEnv)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("compute: listen: %w", err)
+	}
+	defer os.Remove(path)
+	go func() {
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		l.Close()
+	}()
+	return serve(l)
+}
+
+// serve accepts client connections on l until it is closed.
+func serve(l net.Listener) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, &Worker{}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// zero overwrites a slice of key material or plaintext.
+func zero(data []float64) {
+	for i := range data {
+		data[i] = 0
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package compute
+
+import (
+	"math"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// inProcessClient returns a client whose workers are served in this
+// process, and the number of workers started so far.
+func inProcessClient(t *testing.T) (*Client, *int) {
+	t.Helper()
+	started := 0
+	c := newClient(func() (*rpc.Client, func(), error) {
+		started++
+		socket := filepath.Join(t.TempDir(), "worker.sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, nil, err
+		}
+		go serve(l)
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		return rpc.NewClient(conn), func() { l.Close() }, nil
+	})
+	t.Cleanup(c.Close)
+	return c, &started
+}
+
+func TestClient_Multiply(t *testing.T) {
+	c, _ := inProcessClient(t)
+	m := mat.NewDense(3, 3, []float64{
+		0, 1, 0,
+		-1, 0, 0,
+		0, 0, 2,
+	})
+	inputs := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	outputs := [][]float64{make([]float64, 3), make([]float64, 3)}
+	if err := c.Multiply(m, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{2, -1, 6}, {5, -4, 12}}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(outputs[i][j]-want[i][j]) > 1e-12 {
+				t.Fatalf("outputs = %v, want %v", outputs, want)
+			}
+		}
+	}
+
+	single := [][]float64{make([]float64, 3)}
+	if err := c.Multiply(m, inputs[:1], single); err != nil {
+		t.Fatal(err)
+	}
+	if single[0][0] != 2 || single[0][2] != 6 {
+		t.Errorf("single output = %v", single[0])
+	}
+}
+
+func TestClient_ReloadsForgottenMatrix(t *testing.T) {
+	c, _ := inProcessClient(t)
+	m := mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+	out := [][]float64{make([]float64, 2)}
+	if err := c.Multiply(m, [][]float64{{1, 2}}, out); err != nil {
+		t.Fatal(err)
+	}
+	c.Forget(m)
+	if err := c.Multiply(m, [][]float64{{3, 4}}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out[0][0] != 3 || out[0][1] != 4 {
+		t.Errorf("output = %v", out[0])
+	}
+}
+
+func TestClient_RestartsFailedWorker(t *testing.T) {
+	c, started := inProcessClient(t)
+	m := mat.NewDense(2, 2, []float64{2, 0, 0, 2})
+	out := [][]float64{make([]float64, 2)}
+	if err := c.Multiply(m, [][]float64{{1, 1}}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker dies; the next multiply starts another and resends m.
+	c.mu.Lock()
+	c.rpc.Close()
+	c.mu.Unlock()
+	if err := c.Multiply(m, [][]float64{{1, 2}}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out[0][0] != 2 || out[0][1] != 4 {
+		t.Errorf("output = %v", out[0])
+	}
+	if *started != 2 {
+		t.Errorf("%d workers started, want 2", *started)
+	}
+}
+
+func TestWorker_RejectsMalformed(t *testing.T) {
+	var w Worker
+	if err := w.Load(&LoadArgs{ID: 1, Dim: 2, Data: []float64{1, 2, 3}}, nil); err == nil {
+		t.Error("non-square matrix loaded")
+	}
+	if err := w.Multiply(&MultiplyArgs{ID: 1, Count: 1, Inputs: []float64{1, 2}}, &MultiplyReply{}); err == nil {
+		t.Error("multiply by an unknown matrix succeeded")
+	}
+	if err := w.Load(&LoadArgs{ID: 1, Dim: 2, Data: []float64{1, 0, 0, 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Multiply(&MultiplyArgs{ID: 1, Count: 2, Inputs: []float64{1, 2}}, &MultiplyReply{}); err == nil {
+		t.Error("multiply with a short input succeeded")
+	}
+}
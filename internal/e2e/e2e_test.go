@@ -0,0 +1,110 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build e2e
+
+// Package e2e exercises the plugin against real Vault servers.
+//
+// Run with:
+//
+//	go test -tags e2e ./internal/e2e/...
+//
+// VAULT_E2E_VERSIONS selects the Vault image tags (comma-separated).
+package e2e
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/e2e/vaulttest"
+)
+
+// defaultVersions are the Vault releases tested when VAULT_E2E_VERSIONS is unset.
+var defaultVersions = []string{"1.15", "1.16", "1.17"}
+
+// testDimension keeps matrix generation fast.
+const testDimension = 16
+
+func vaultVersions() []string {
+	if env := os.Getenv("VAULT_E2E_VERSIONS"); env != "" {
+		return strings.Split(env, ",")
+	}
+	return defaultVersions
+}
+
+func testVector(offset float64) []float64 {
+	v := make([]float64, testDimension)
+	for i := range v {
+		v[i] = offset + float64(i)/10
+	}
+	return v
+}
+
+func TestE2E(t *testing.T) {
+	vaulttest.RequireDocker(t)
+	pluginDir := vaulttest.BuildPlugin(t, "../..")
+
+	for _, version := range vaultVersions() {
+		version := strings.TrimSpace(version)
+		t.Run("vault-"+version, func(t *testing.T) {
+			t.Parallel()
+			v := vaulttest.Start(t, version, pluginDir)
+
+			t.Run("rotate", func(t *testing.T) {
+				secret := v.Write(t, "config/rotate", map[string]interface{}{
+					"dimension": testDimension,
+				})
+				if got, _ := secret.Data["dimension"].(json.Number).Int64(); got != testDimension {
+					t.Fatalf("dimension = %v, want %d", secret.Data["dimension"], testDimension)
+				}
+			})
+
+			t.Run("encrypt", func(t *testing.T) {
+				secret := v.Write(t, "encrypt/vector", map[string]interface{}{
+					"vector": testVector(0),
+				})
+				if ct, ok := secret.Data["ciphertext"].([]interface{}); !ok || len(ct) != testDimension {
+					t.Fatalf("unexpected ciphertext: %#v", secret.Data["ciphertext"])
+				}
+			})
+
+			t.Run("batch", func(t *testing.T) {
+				secret := v.Write(t, "encrypt/batch", map[string]interface{}{
+					"vectors": [][]float64{testVector(0), testVector(1)},
+				})
+				results, ok := secret.Data["batch_results"].([]interface{})
+				if !ok || len(results) != 2 {
+					t.Fatalf("unexpected batch_results: %#v", secret.Data["batch_results"])
+				}
+				for i, r := range results {
+					if item := r.(map[string]interface{}); item["error"] != nil {
+						t.Errorf("item %d: %v", i, item["error"])
+					}
+				}
+			})
+
+			t.Run("role", func(t *testing.T) {
+				v.Write(t, "roles/app", map[string]interface{}{
+					"allowed_operations": "encrypt",
+				})
+				v.Write(t, "encrypt/vector/app", map[string]interface{}{
+					"vector": testVector(0),
+				})
+				if _, err := v.Client.Logical().Write(vaulttest.MountPath+"/encrypt/batch/app", map[string]interface{}{
+					"vectors": [][]float64{testVector(0)},
+				}); err == nil {
+					t.Error("batch allowed for a role limited to encrypt")
+				}
+			})
+
+			t.Run("status", func(t *testing.T) {
+				secret := v.Read(t, "status")
+				if secret.Data["ready"] != true {
+					t.Errorf("ready = %v", secret.Data["ready"])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package vaulttest runs a real Vault server in a Docker container with the
+// plugin registered and mounted, for end-to-end tests.
+//
+// It shells out to the docker CLI rather than depending on a container
+// library. Tests using it are skipped when docker is not available.
+package vaulttest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// PluginName is the catalog name and binary name of the plugin.
+	PluginName = "vault-plugin-secrets-vector-dpe"
+
+	// MountPath is where Start mounts the plugin.
+	MountPath = "vector"
+
+	// rootToken is the dev server's fixed root token.
+	rootToken = "root"
+
+	// imageRepository is the Vault image; the tag is the Vault version.
+	imageRepository = "hashicorp/vault"
+
+	// startTimeout bounds how long to wait for the container to serve requests.
+	startTimeout = 60 * time.Second
+)
+
+// Vault is a running Vault container with the plugin mounted at MountPath.
+type Vault struct {
+	// Version is the Vault image tag.
+	Version string
+
+	// Client is authenticated with the root token.
+	Client *api.Client
+
+	container string
+}
+
+// Write issues a write against the plugin mount and fails the test on error.
+func (v *Vault) Write(t testing.TB, path string, data map[string]interface{}) *api.Secret {
+	t.Helper()
+	secret, err := v.Client.Logical().Write(MountPath+"/"+path, data)
+	if err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return secret
+}
+
+// Read issues a read against the plugin mount and fails the test on error.
+func (v *Vault) Read(t testing.TB, path string) *api.Secret {
+	t.Helper()
+	secret, err := v.Client.Logical().Read(MountPath + "/" + path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return secret
+}
+
+// Logs returns the container's output, for failure diagnostics.
+func (v *Vault) Logs() string {
+	out, _ := exec.Command("docker", "logs", v.container).CombinedOutput()
+	return string(out)
+}
+
+// RequireDocker skips the test if the docker CLI or daemon is unavailable.
+func RequireDocker(t testing.TB) {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found on PATH")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("docker daemon not reachable")
+	}
+}
+
+// BuildPlugin cross-compiles the plugin for Linux into a fresh directory and
+// returns the directory. moduleRoot is the repository root.
+func BuildPlugin(t testing.TB, moduleRoot string) string {
+	t.Helper()
+	dir := t.TempDir()
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, PluginName), "./cmd/"+PluginName)
+	build.Dir = moduleRoot
+	build.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+runtime.GOARCH, "CGO_ENABLED=0")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build plugin: %v\n%s", err, out)
+	}
+	// The container runs Vault as an unprivileged user.
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Start runs Vault version in a container with the plugins in pluginDir,
+// registers and mounts the plugin, and stops the container when the test ends.
+func Start(t testing.TB, version, pluginDir string) *Vault {
+	t.Helper()
+
+	out, err := exec.Command("docker", "run", "--detach", "--rm",
+		"--cap-add=IPC_LOCK",
+		"--publish", "127.0.0.1::8200",
+		"--volume", pluginDir+":/vault/plugins:ro",
+		"--env", "VAULT_DEV_ROOT_TOKEN_ID="+rootToken,
+		imageRepository+":"+version,
+		"server", "-dev",
+		"-dev-listen-address=0.0.0.0:8200",
+		"-dev-plugin-dir=/vault/plugins",
+	).Output()
+	if err != nil {
+		t.Fatalf("start vault %s: %v", version, commandError(err))
+	}
+	v := &Vault{Version: version, container: strings.TrimSpace(string(out))}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("vault %s logs:\n%s", version, v.Logs())
+		}
+		_ = exec.Command("docker", "stop", v.container).Run()
+	})
+
+	addr, err := exec.Command("docker", "port", v.container, "8200/tcp").Output()
+	if err != nil {
+		t.Fatalf("find vault port: %v", commandError(err))
+	}
+	// docker port may list several bindings; the first is ours.
+	hostPort := strings.TrimSpace(strings.SplitN(string(addr), "\n", 2)[0])
+
+	v.Client, err = api.NewClient(&api.Config{Address: "http://" + hostPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Client.SetToken(rootToken)
+
+	if err := waitReady(v.Client); err != nil {
+		t.Fatalf("vault %s: %v", version, err)
+	}
+
+	sum, err := fileSHA256(filepath.Join(pluginDir, PluginName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Client.Sys().RegisterPlugin(&api.RegisterPluginInput{
+		Name:    PluginName,
+		Type:    api.PluginTypeSecrets,
+		Command: PluginName,
+		SHA256:  sum,
+	}); err != nil {
+		t.Fatalf("register plugin: %v", err)
+	}
+	if err := v.Client.Sys().Mount(MountPath, &api.MountInput{Type: PluginName}); err != nil {
+		t.Fatalf("mount plugin: %v", err)
+	}
+	return v
+}
+
+// waitReady polls until the server is unsealed and serving.
+func waitReady(client *api.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	for {
+		if health, err := client.Sys().HealthWithContext(ctx); err == nil && health.Initialized && !health.Sealed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready within %s", startTimeout)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// commandError includes a failed command's stderr in its error.
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return err
+}
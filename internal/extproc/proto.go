@@ -0,0 +1,214 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package extproc
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+)
+
+// The ext_proc v3 messages are encoded by hand with protowire, covering
+// only the fields this processor reads or writes, rather than importing
+// Envoy's generated API for a handful of messages. Field numbers are those
+// of envoy/service/ext_proc/v3/external_processor.proto and
+// envoy/config/core/v3/base.proto; unknown fields are skipped.
+
+// Fields of ProcessingRequest, all members of its 'request' oneof.
+const (
+	reqRequestHeaders  = 2
+	reqResponseHeaders = 3
+	reqRequestBody     = 4
+	reqResponseBody    = 5
+	reqRequestTrailers = 6
+	reqResponseTrailer = 7
+)
+
+// Fields of ProcessingResponse: the 'response' oneof.
+const (
+	respRequestHeaders    = 1
+	respResponseHeaders   = 2
+	respRequestBody       = 3
+	respResponseBody      = 4
+	respRequestTrailers   = 5
+	respResponseTrailers  = 6
+	respImmediateResponse = 7
+)
+
+// processingRequest is the decoded part of a ProcessingRequest.
+type processingRequest struct {
+	// Kind is the number of the oneof field that was set.
+	Kind int
+
+	// Headers is set for request and response headers.
+	Headers map[string]string
+
+	// Body and EndOfStream are set for request and response bodies.
+	Body        []byte
+	EndOfStream bool
+}
+
+// decodeProcessingRequest decodes a ProcessingRequest.
+func decodeProcessingRequest(b []byte) (*processingRequest, error) {
+	req := &processingRequest{}
+	err := rawgrpc.WalkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case reqRequestHeaders, reqResponseHeaders:
+			req.Kind = int(num)
+			headers, err := decodeHttpHeaders(v)
+			req.Headers = headers
+			return err
+		case reqRequestBody, reqResponseBody:
+			req.Kind = int(num)
+			return decodeHttpBody(v, req)
+		case reqRequestTrailers, reqResponseTrailer:
+			req.Kind = int(num)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.Kind == 0 {
+		return nil, fmt.Errorf("processing request has no known request type")
+	}
+	return req, nil
+}
+
+// decodeHttpHeaders decodes HttpHeaders{HeaderMap headers = 1} into a map
+// with lower-case keys, as Envoy sends them.
+func decodeHttpHeaders(b []byte) (map[string]string, error) {
+	headers := map[string]string{}
+	err := rawgrpc.WalkFields(b, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		// HeaderMap{repeated HeaderValue headers = 1}
+		return rawgrpc.WalkFields(v, func(num protowire.Number, _ protowire.Type, v []byte) error {
+			if num != 1 {
+				return nil
+			}
+			// HeaderValue{key = 1, value = 2, raw_value = 3}
+			var key, value string
+			err := rawgrpc.WalkFields(v, func(num protowire.Number, _ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					key = string(v)
+				case 2, 3:
+					value = string(v)
+				}
+				return nil
+			})
+			headers[key] = value
+			return err
+		})
+	})
+	return headers, err
+}
+
+// decodeHttpBody decodes HttpBody{bytes body = 1; bool end_of_stream = 2}.
+func decodeHttpBody(b []byte, req *processingRequest) error {
+	return rawgrpc.WalkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			req.Body = append([]byte(nil), v...)
+		case 2:
+			n, _ := protowire.ConsumeVarint(v)
+			req.EndOfStream = n != 0
+		}
+		return nil
+	})
+}
+
+// headerMutation is a HeaderMutation: headers to set and to remove.
+type headerMutation struct {
+	Set    map[string]string
+	Remove []string
+}
+
+// encode encodes HeaderMutation{repeated HeaderValueOption set_headers = 1;
+// repeated string remove_headers = 2}.
+func (m *headerMutation) encode() []byte {
+	var b []byte
+	for key, value := range m.Set {
+		// HeaderValueOption{HeaderValue header = 1}, with the value as
+		// raw_value (3), which recent Envoy versions require.
+		var hv []byte
+		hv = protowire.AppendTag(hv, 1, protowire.BytesType)
+		hv = protowire.AppendString(hv, key)
+		hv = protowire.AppendTag(hv, 3, protowire.BytesType)
+		hv = protowire.AppendString(hv, value)
+		var opt []byte
+		opt = protowire.AppendTag(opt, 1, protowire.BytesType)
+		opt = protowire.AppendBytes(opt, hv)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, opt)
+	}
+	for _, key := range m.Remove {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	return b
+}
+
+// commonResponse encodes CommonResponse{HeaderMutation header_mutation = 2;
+// BodyMutation body_mutation = 3}, with status CONTINUE (the default).
+// A nil body leaves the body unchanged.
+func commonResponse(headers *headerMutation, body []byte) []byte {
+	var b []byte
+	if headers != nil {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, headers.encode())
+	}
+	if body != nil {
+		// BodyMutation{bytes body = 1}
+		var mutation []byte
+		mutation = protowire.AppendTag(mutation, 1, protowire.BytesType)
+		mutation = protowire.AppendBytes(mutation, body)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, mutation)
+	}
+	return b
+}
+
+// encodeProcessingResponse encodes a ProcessingResponse whose oneof field
+// kind is a HeadersResponse or BodyResponse {CommonResponse response = 1},
+// or a TrailersResponse, which is sent empty.
+func encodeProcessingResponse(kind int, common []byte) []byte {
+	var inner []byte
+	if kind != respRequestTrailers && kind != respResponseTrailers {
+		inner = protowire.AppendTag(inner, 1, protowire.BytesType)
+		inner = protowire.AppendBytes(inner, common)
+	}
+	var b []byte
+	b = protowire.AppendTag(b, protowire.Number(kind), protowire.BytesType)
+	return protowire.AppendBytes(b, inner)
+}
+
+// encodeImmediateResponse encodes a ProcessingResponse with an
+// ImmediateResponse{HttpStatus status = 1; HeaderMutation headers = 2;
+// body = 3; string details = 5}, which ends the stream with this reply
+// instead of the upstream's.
+func encodeImmediateResponse(status int, contentType string, body []byte, details string) []byte {
+	var httpStatus []byte
+	httpStatus = protowire.AppendTag(httpStatus, 1, protowire.VarintType)
+	httpStatus = protowire.AppendVarint(httpStatus, uint64(status))
+
+	var inner []byte
+	inner = protowire.AppendTag(inner, 1, protowire.BytesType)
+	inner = protowire.AppendBytes(inner, httpStatus)
+	headers := &headerMutation{Set: map[string]string{"content-type": contentType}}
+	inner = protowire.AppendTag(inner, 2, protowire.BytesType)
+	inner = protowire.AppendBytes(inner, headers.encode())
+	inner = protowire.AppendTag(inner, 3, protowire.BytesType)
+	inner = protowire.AppendBytes(inner, body)
+	inner = protowire.AppendTag(inner, 5, protowire.BytesType)
+	inner = protowire.AppendString(inner, details)
+
+	var b []byte
+	b = protowire.AppendTag(b, respImmediateResponse, protowire.BytesType)
+	return protowire.AppendBytes(b, inner)
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package extproc runs the plugin's encryption as an Envoy external
+// processor (envoy.service.ext_proc.v3). Attached to the routes of an
+// embeddings API, it replaces the plaintext embeddings of each response
+// with ciphertexts from a Vault mount before they reach the client, so
+// every service behind the mesh gets encrypted vectors without calling
+// Vault itself.
+//
+// The processor fails closed: a response it recognizes as embeddings but
+// cannot encrypt is replaced with a 502, never forwarded in plaintext.
+package extproc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+)
+
+// serviceName is the ext_proc v3 service Envoy calls.
+const serviceName = "envoy.service.ext_proc.v3.ExternalProcessor"
+
+// Server is an ext_proc server that encrypts embeddings responses.
+type Server struct {
+	// Encrypter encrypts the embeddings of each response.
+	Encrypter Encrypter
+
+	// ErrorLog receives failures; nil means the log package's default.
+	ErrorLog *log.Logger
+
+	// Metrics, if set, receives the processor's counters when
+	// NewGRPCServer is called.
+	Metrics *ops.Registry
+
+	responses *ops.Counter
+	streams   atomic.Int64
+}
+
+// NewGRPCServer returns a gRPC server with the processor registered.
+// Messages are encoded by this package, so the server's codec is forced
+// to one that passes them through.
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s.responses = s.Metrics.Counter("vector_dpe_extproc_responses_total",
+		"Responses processed, by result: encrypted, passed (not embeddings) or refused.", "result")
+	s.Metrics.GaugeFunc("vector_dpe_extproc_streams",
+		"HTTP streams being processed.", func() float64 { return float64(s.streams.Load()) })
+	g := grpc.NewServer(append(opts, grpc.ForceServerCodec(rawgrpc.Codec{}))...)
+	g.RegisterService(&serviceDesc, s)
+	return g
+}
+
+// processor is the handler type of serviceDesc.
+type processor interface {
+	process(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*processor)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Process",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(processor).process(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "envoy/service/ext_proc/v3/external_processor.proto",
+}
+
+// process handles one HTTP stream. Envoy sends a message for each phase
+// enabled by the filter's processing_mode and waits for the reply to each.
+func (s *Server) process(stream grpc.ServerStream) error {
+	s.streams.Add(1)
+	defer s.streams.Add(-1)
+	for {
+		var in rawgrpc.Message
+		if err := stream.RecvMsg(&in); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		req, err := decodeProcessingRequest(in)
+		if err != nil {
+			return err
+		}
+		out := rawgrpc.Message(s.handle(stream, req))
+		if err := stream.SendMsg(&out); err != nil {
+			return err
+		}
+		if isImmediate(out) {
+			return nil
+		}
+	}
+}
+
+// handle returns the reply to one processing request.
+func (s *Server) handle(stream grpc.ServerStream, req *processingRequest) []byte {
+	switch req.Kind {
+	case reqRequestHeaders:
+		// Compressed responses cannot be rewritten, so ask for identity.
+		return encodeProcessingResponse(respRequestHeaders,
+			commonResponse(&headerMutation{Remove: []string{"accept-encoding"}}, nil))
+
+	case reqResponseHeaders:
+		if enc := req.Headers["content-encoding"]; enc != "" && !strings.EqualFold(enc, "identity") {
+			return s.refuse(fmt.Errorf("response has content-encoding %q", enc))
+		}
+		// The body changes length once rewritten.
+		return encodeProcessingResponse(respResponseHeaders,
+			commonResponse(&headerMutation{Remove: []string{"content-length"}}, nil))
+
+	case reqResponseBody:
+		if !req.EndOfStream {
+			return s.refuse(fmt.Errorf("response body was not buffered; set response_body_mode to BUFFERED"))
+		}
+		body, rewritten, err := rewriteEmbeddings(stream.Context(), s.Encrypter, req.Body)
+		if err != nil {
+			return s.refuse(err)
+		}
+		if !rewritten {
+			s.responses.Inc("passed")
+			return encodeProcessingResponse(respResponseBody, nil)
+		}
+		s.responses.Inc("encrypted")
+		return encodeProcessingResponse(respResponseBody, commonResponse(nil, body))
+
+	case reqRequestBody:
+		return encodeProcessingResponse(respRequestBody, nil)
+	case reqRequestTrailers:
+		return encodeProcessingResponse(respRequestTrailers, nil)
+	default:
+		return encodeProcessingResponse(respResponseTrailers, nil)
+	}
+}
+
+// refuse logs err and returns an immediate 502 in the OpenAI error shape.
+// The client sees that encryption failed, not why.
+func (s *Server) refuse(err error) []byte {
+	logger := s.ErrorLog
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("extproc: refusing response: %v", err)
+	s.responses.Inc("refused")
+	return encodeImmediateResponse(502, "application/json",
+		[]byte(`{"error":{"message":"embeddings could not be encrypted","type":"vector_dpe_error"}}`),
+		"vector_dpe_encryption_failed")
+}
+
+// isImmediate reports whether an encoded response is an ImmediateResponse,
+// after which Envoy ends the stream.
+func isImmediate(b []byte) bool {
+	return len(b) > 0 && b[0] == byte(respImmediateResponse<<3|2)
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package extproc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+)
+
+// testStream starts a server over an in-memory listener and opens a
+// Process stream to it, as Envoy would.
+func testStream(t *testing.T, enc Encrypter) grpc.ClientStream {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := (&Server{Encrypter: enc, ErrorLog: log.New(io.Discard, "", 0)}).NewGRPCServer()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawgrpc.Codec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// roundTrip sends one ProcessingRequest and returns the reply's oneof
+// field number and contents.
+func roundTrip(t *testing.T, stream grpc.ClientStream, req []byte) (int, []byte) {
+	t.Helper()
+	in := rawgrpc.Message(req)
+	if err := stream.SendMsg(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out rawgrpc.Message
+	if err := stream.RecvMsg(&out); err != nil {
+		t.Fatal(err)
+	}
+	var kind int
+	var inner []byte
+	if err := rawgrpc.WalkFields(out, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		kind, inner = int(num), v
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return kind, inner
+}
+
+func headersRequest(kind int, headers map[string]string) []byte {
+	var hm []byte
+	for k, v := range headers {
+		var hv []byte
+		hv = protowire.AppendTag(hv, 1, protowire.BytesType)
+		hv = protowire.AppendString(hv, k)
+		hv = protowire.AppendTag(hv, 3, protowire.BytesType)
+		hv = protowire.AppendString(hv, v)
+		hm = protowire.AppendTag(hm, 1, protowire.BytesType)
+		hm = protowire.AppendBytes(hm, hv)
+	}
+	var h []byte
+	h = protowire.AppendTag(h, 1, protowire.BytesType)
+	h = protowire.AppendBytes(h, hm)
+	var b []byte
+	b = protowire.AppendTag(b, protowire.Number(kind), protowire.BytesType)
+	return protowire.AppendBytes(b, h)
+}
+
+func bodyRequest(body string, endOfStream bool) []byte {
+	var hb []byte
+	hb = protowire.AppendTag(hb, 1, protowire.BytesType)
+	hb = protowire.AppendString(hb, body)
+	if endOfStream {
+		hb = protowire.AppendTag(hb, 2, protowire.VarintType)
+		hb = protowire.AppendVarint(hb, 1)
+	}
+	var b []byte
+	b = protowire.AppendTag(b, reqResponseBody, protowire.BytesType)
+	return protowire.AppendBytes(b, hb)
+}
+
+// field returns the contents of the first field num of a message, or nil.
+func field(t *testing.T, b []byte, num protowire.Number) []byte {
+	t.Helper()
+	var out []byte
+	if err := rawgrpc.WalkFields(b, func(n protowire.Number, _ protowire.Type, v []byte) error {
+		if n == num && out == nil {
+			out = v
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestServer_EncryptsResponse(t *testing.T) {
+	stream := testStream(t, &fakeEncrypter{})
+
+	kind, inner := roundTrip(t, stream, headersRequest(reqRequestHeaders, map[string]string{":path": "/v1/embeddings", "accept-encoding": "gzip"}))
+	if kind != respRequestHeaders {
+		t.Fatalf("request headers reply kind = %d", kind)
+	}
+	mutation := field(t, field(t, inner, 1), 2)
+	if got := string(field(t, mutation, 2)); got != "accept-encoding" {
+		t.Errorf("removed header = %q, want accept-encoding", got)
+	}
+
+	kind, _ = roundTrip(t, stream, headersRequest(reqResponseHeaders, map[string]string{":status": "200", "content-length": "80"}))
+	if kind != respResponseHeaders {
+		t.Fatalf("response headers reply kind = %d", kind)
+	}
+
+	kind, inner = roundTrip(t, stream, bodyRequest(`{"object":"list","data":[{"embedding":[1,2]}]}`, true))
+	if kind != respResponseBody {
+		t.Fatalf("response body reply kind = %d", kind)
+	}
+	body := field(t, field(t, field(t, inner, 1), 3), 1)
+	var resp struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("mutated body %q: %v", body, err)
+	}
+	if got := resp.Data[0].Embedding; len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("embedding = %v, want [2 4]", got)
+	}
+}
+
+func TestServer_PassesThroughOtherBodies(t *testing.T) {
+	stream := testStream(t, &fakeEncrypter{})
+	kind, inner := roundTrip(t, stream, bodyRequest(`{"error":{"message":"bad model"}}`, true))
+	if kind != respResponseBody {
+		t.Fatalf("reply kind = %d", kind)
+	}
+	if mutation := field(t, field(t, inner, 1), 3); mutation != nil {
+		t.Errorf("unexpected body mutation %q", mutation)
+	}
+}
+
+func TestServer_FailsClosed(t *testing.T) {
+	cases := map[string][]byte{
+		"encryption error": bodyRequest(`{"object":"list","data":[{"embedding":[1]}]}`, true),
+		"streamed body":    bodyRequest(`{"object":"list"`, false),
+		"compressed":       headersRequest(reqResponseHeaders, map[string]string{"content-encoding": "gzip"}),
+	}
+	for name, req := range cases {
+		t.Run(name, func(t *testing.T) {
+			stream := testStream(t, &fakeEncrypter{err: fmt.Errorf("vault down")})
+			kind, inner := roundTrip(t, stream, req)
+			if kind != respImmediateResponse {
+				t.Fatalf("reply kind = %d, want an immediate response", kind)
+			}
+			status, _ := protowire.ConsumeVarint(field(t, field(t, inner, 1), 1))
+			if status != 502 {
+				t.Errorf("status = %d, want 502", status)
+			}
+			if body := string(field(t, inner, 3)); body == "" || !json.Valid([]byte(body)) {
+				t.Errorf("body = %q, want a JSON error", body)
+			}
+		})
+	}
+}
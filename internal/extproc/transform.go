@@ -0,0 +1,142 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package extproc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// Encrypter encrypts embeddings. Ciphertexts are returned in input order.
+// *vaultenc.Client implements it.
+type Encrypter interface {
+	Encrypt(ctx context.Context, vectors [][]float64) ([][]float64, *vaultenc.SchemeParams, error)
+}
+
+// embedding is one entry of an embeddings response's data array. Fields
+// other than the embedding are kept as they are.
+type embedding struct {
+	fields map[string]json.RawMessage
+	vector []float64
+	base64 bool
+}
+
+// rewriteEmbeddings replaces the plaintext embeddings of an OpenAI-shaped
+// embeddings response with their ciphertexts. Base64 embeddings (little-
+// endian float32) stay base64; everything else in the body is kept.
+//
+// A body that is not an embeddings response is returned unchanged with
+// rewritten false. Once a body is recognized, any failure is an error:
+// the caller must not forward the plaintext.
+func rewriteEmbeddings(ctx context.Context, enc Encrypter, body []byte) (out []byte, rewritten bool, err error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(body, &top); err != nil {
+		return body, false, nil
+	}
+	var object string
+	if err := json.Unmarshal(top["object"], &object); err != nil || object != "list" {
+		return body, false, nil
+	}
+	var rawItems []map[string]json.RawMessage
+	if err := json.Unmarshal(top["data"], &rawItems); err != nil || len(rawItems) == 0 {
+		return body, false, nil
+	}
+
+	items := make([]embedding, len(rawItems))
+	vectors := make([][]float64, len(rawItems))
+	for i, fields := range rawItems {
+		raw, ok := fields["embedding"]
+		if !ok {
+			return body, false, nil
+		}
+		item, err := decodeEmbedding(raw)
+		if err != nil {
+			return nil, true, fmt.Errorf("data[%d].embedding: %w", i, err)
+		}
+		item.fields = fields
+		items[i] = item
+		vectors[i] = item.vector
+	}
+
+	ciphertexts, params, err := enc.Encrypt(ctx, vectors)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(ciphertexts) != len(items) {
+		return nil, true, fmt.Errorf("got %d ciphertexts for %d embeddings", len(ciphertexts), len(items))
+	}
+
+	for i, item := range items {
+		var value interface{} = ciphertexts[i]
+		if item.base64 {
+			value = base64.StdEncoding.EncodeToString(packFloat32(ciphertexts[i]))
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, true, err
+		}
+		item.fields["embedding"] = encoded
+	}
+	if top["data"], err = json.Marshal(rawItems); err != nil {
+		return nil, true, err
+	}
+	if params != nil {
+		if top["vector_dpe"], err = json.Marshal(params); err != nil {
+			return nil, true, err
+		}
+	}
+	out, err = json.Marshal(top)
+	if err != nil {
+		return nil, true, err
+	}
+	return out, true, nil
+}
+
+// decodeEmbedding decodes a float array or a base64 string of
+// little-endian float32.
+func decodeEmbedding(raw json.RawMessage) (embedding, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return embedding{}, err
+		}
+		packed, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return embedding{}, err
+		}
+		if len(packed) == 0 || len(packed)%4 != 0 {
+			return embedding{}, fmt.Errorf("base64 embedding of %d bytes is not a float32 array", len(packed))
+		}
+		vector := make([]float64, len(packed)/4)
+		for i := range vector {
+			vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(packed[4*i:])))
+		}
+		return embedding{vector: vector, base64: true}, nil
+	}
+	var vector []float64
+	if err := json.Unmarshal(raw, &vector); err != nil {
+		return embedding{}, err
+	}
+	if len(vector) == 0 {
+		return embedding{}, fmt.Errorf("empty embedding")
+	}
+	return embedding{vector: vector}, nil
+}
+
+// packFloat32 encodes a vector as little-endian float32.
+func packFloat32(vector []float64) []byte {
+	out := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(out[4*i:], math.Float32bits(float32(v)))
+	}
+	return out
+}
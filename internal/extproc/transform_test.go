@@ -0,0 +1,118 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package extproc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// fakeEncrypter doubles every component and reports fixed parameters.
+type fakeEncrypter struct {
+	err   error
+	calls int
+}
+
+func (f *fakeEncrypter) Encrypt(_ context.Context, vectors [][]float64) ([][]float64, *vaultenc.SchemeParams, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	out := make([][]float64, len(vectors))
+	for i, v := range vectors {
+		out[i] = make([]float64, len(v))
+		for j, x := range v {
+			out[i][j] = 2 * x
+		}
+	}
+	return out, &vaultenc.SchemeParams{Scheme: "sap", KeyVersion: 3, Dimension: len(vectors[0]), TransformID: "abc"}, nil
+}
+
+func TestRewriteEmbeddings_Float(t *testing.T) {
+	body := []byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.5,-1]}],"model":"m","usage":{"total_tokens":3}}`)
+	out, rewritten, err := rewriteEmbeddings(context.Background(), &fakeEncrypter{}, body)
+	if err != nil || !rewritten {
+		t.Fatalf("rewriteEmbeddings: rewritten=%v err=%v", rewritten, err)
+	}
+
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Model  string                `json:"model"`
+		Usage  json.RawMessage       `json:"usage"`
+		Scheme vaultenc.SchemeParams `json:"vector_dpe"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Data[0].Embedding; len(got) != 2 || got[0] != 1 || got[1] != -2 {
+		t.Errorf("embedding = %v, want [1 -2]", got)
+	}
+	if resp.Model != "m" || string(resp.Usage) != `{"total_tokens":3}` {
+		t.Errorf("other fields not kept: model=%q usage=%s", resp.Model, resp.Usage)
+	}
+	if resp.Scheme.TransformID != "abc" || resp.Scheme.KeyVersion != 3 {
+		t.Errorf("vector_dpe = %+v", resp.Scheme)
+	}
+}
+
+func TestRewriteEmbeddings_Base64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(packFloat32([]float64{0.25, 4}))
+	body := []byte(fmt.Sprintf(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":%q}]}`, encoded))
+	out, rewritten, err := rewriteEmbeddings(context.Background(), &fakeEncrypter{}, body)
+	if err != nil || !rewritten {
+		t.Fatalf("rewriteEmbeddings: rewritten=%v err=%v", rewritten, err)
+	}
+
+	var resp struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+	item, err := decodeEmbedding(json.RawMessage(fmt.Sprintf("%q", resp.Data[0].Embedding)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.base64 || item.vector[0] != 0.5 || item.vector[1] != 8 {
+		t.Errorf("embedding = %v (base64 %v), want [0.5 8] as base64", item.vector, item.base64)
+	}
+}
+
+func TestRewriteEmbeddings_PassThrough(t *testing.T) {
+	for _, body := range []string{
+		`not json`,
+		`{"error":{"message":"rate limited"}}`,
+		`{"object":"list","data":[{"id":"file-1"}]}`,
+		`{"object":"chat.completion","data":[{"embedding":[1]}]}`,
+	} {
+		enc := &fakeEncrypter{}
+		out, rewritten, err := rewriteEmbeddings(context.Background(), enc, []byte(body))
+		if err != nil || rewritten || string(out) != body || enc.calls != 0 {
+			t.Errorf("%s: rewritten=%v err=%v out=%s", body, rewritten, err, out)
+		}
+	}
+}
+
+func TestRewriteEmbeddings_FailsClosed(t *testing.T) {
+	body := []byte(`{"object":"list","data":[{"embedding":[1,2]}]}`)
+	out, rewritten, err := rewriteEmbeddings(context.Background(), &fakeEncrypter{err: fmt.Errorf("vault down")}, body)
+	if err == nil || !rewritten || out != nil {
+		t.Errorf("rewritten=%v err=%v out=%s, want an error and no body", rewritten, err, out)
+	}
+
+	malformed := []byte(`{"object":"list","data":[{"embedding":"%%%"}]}`)
+	if _, _, err := rewriteEmbeddings(context.Background(), &fakeEncrypter{}, malformed); err == nil {
+		t.Error("expected an error for a malformed base64 embedding")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+)
+
+// encryptRequest is an EncryptRequest of ingest.proto.
+type encryptRequest struct {
+	ID     string
+	Vector []float64
+}
+
+// encryptResponse is an EncryptResponse of ingest.proto.
+type encryptResponse struct {
+	ID          string
+	Ciphertext  []float64
+	Error       string
+	KeyVersion  int
+	TransformID string
+}
+
+// decodeEncryptRequest decodes an EncryptRequest. The vector may arrive
+// packed, as proto3 encoders write it, or one element per field.
+func decodeEncryptRequest(b []byte) (encryptRequest, error) {
+	var req encryptRequest
+	err := rawgrpc.WalkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			req.ID = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			if len(v)%4 != 0 {
+				return fmt.Errorf("vector: packed length %d is not a multiple of 4", len(v))
+			}
+			for ; len(v) > 0; v = v[4:] {
+				req.Vector = append(req.Vector, float64(math.Float32frombits(binary.LittleEndian.Uint32(v))))
+			}
+		case num == 2 && typ == protowire.Fixed32Type:
+			req.Vector = append(req.Vector, float64(math.Float32frombits(binary.LittleEndian.Uint32(v))))
+		}
+		return nil
+	})
+	return req, err
+}
+
+// encode encodes the response as an EncryptResponse.
+func (r *encryptResponse) encode() []byte {
+	var b []byte
+	if r.ID != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, r.ID)
+	}
+	if len(r.Ciphertext) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(4*len(r.Ciphertext)))
+		for _, c := range r.Ciphertext {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(c)))
+		}
+	}
+	if r.Error != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, r.Error)
+	}
+	if r.KeyVersion != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(r.KeyVersion)))
+	}
+	if r.TransformID != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, r.TransformID)
+	}
+	return b
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ingest serves bulk encryption over a bidirectional gRPC stream
+// (vectordpe.ingest.v1.Ingest, see ingest.proto). Clients stream vectors
+// in; the server gathers them into encrypt/batch requests to a Vault
+// mount and streams the ciphertexts back in order. One stream replaces
+// thousands of HTTP round trips to Vault, while the key never leaves the
+// plugin: every vector is still encrypted by Vault.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// serviceName is the service of ingest.proto.
+const serviceName = "vectordpe.ingest.v1.Ingest"
+
+// Encrypter encrypts vectors, reporting refused vectors individually.
+// *vaultenc.Client implements it.
+type Encrypter interface {
+	EncryptItems(ctx context.Context, vectors [][]float64) ([]vaultenc.Item, *vaultenc.SchemeParams, error)
+}
+
+// Server is the Ingest service.
+type Server struct {
+	// Encrypter encrypts each batch.
+	Encrypter Encrypter
+
+	// BatchSize is the most vectors encrypted in one request; default and
+	// maximum vaultenc.MaxBatchVectors.
+	BatchSize int
+
+	// FlushInterval is how long a partial batch waits for more vectors
+	// before it is encrypted; default 20ms.
+	FlushInterval time.Duration
+
+	// ErrorLog receives failures; nil means the log package's default.
+	ErrorLog *log.Logger
+
+	// Metrics, if set, receives the service's counters when NewGRPCServer
+	// is called.
+	Metrics *ops.Registry
+
+	vectors *ops.Counter
+	streams atomic.Int64
+}
+
+// NewGRPCServer returns a gRPC server with the service registered. Pass
+// grpc.Creds with a mutual TLS config, such as from ServerTLSConfig.
+// Messages are encoded by this package, so the server's codec is forced
+// to one that passes them through.
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s.vectors = s.Metrics.Counter("vector_dpe_ingest_vectors_total",
+		"Vectors processed, by result: encrypted or refused.", "result")
+	s.Metrics.GaugeFunc("vector_dpe_ingest_streams",
+		"Ingest streams open.", func() float64 { return float64(s.streams.Load()) })
+	g := grpc.NewServer(append(opts, grpc.ForceServerCodec(rawgrpc.Codec{}))...)
+	g.RegisterService(&serviceDesc, s)
+	return g
+}
+
+// encrypter is the handler type of serviceDesc.
+type encrypter interface {
+	encrypt(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*encrypter)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Encrypt",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(encrypter).encrypt(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "ingest.proto",
+}
+
+func (s *Server) batchSize() int {
+	if s.BatchSize <= 0 || s.BatchSize > vaultenc.MaxBatchVectors {
+		return vaultenc.MaxBatchVectors
+	}
+	return s.BatchSize
+}
+
+func (s *Server) flushInterval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return 20 * time.Millisecond
+	}
+	return s.FlushInterval
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	logger := s.ErrorLog
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(format, args...)
+}
+
+// encrypt handles one stream. Requests are received in the background so
+// that the next batch fills while the current one is with Vault.
+func (s *Server) encrypt(stream grpc.ServerStream) error {
+	s.streams.Add(1)
+	defer s.streams.Add(-1)
+
+	ctx := stream.Context()
+	reqs := make(chan encryptRequest, s.batchSize())
+	recvErr := make(chan error, 1)
+	go func() { recvErr <- s.receive(ctx, stream, reqs) }()
+
+	for {
+		batch, open := s.collect(reqs)
+		if len(batch) > 0 {
+			if err := s.flush(ctx, stream, batch); err != nil {
+				return err
+			}
+		}
+		if !open {
+			return <-recvErr
+		}
+	}
+}
+
+// receive decodes requests into reqs until the client closes its side of
+// the stream, and closes reqs.
+func (s *Server) receive(ctx context.Context, stream grpc.ServerStream, reqs chan<- encryptRequest) error {
+	defer close(reqs)
+	for {
+		var in rawgrpc.Message
+		if err := stream.RecvMsg(&in); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		req, err := decodeEncryptRequest(in)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "malformed EncryptRequest: %v", err)
+		}
+		select {
+		case reqs <- req:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// collect waits for a request, then gathers more until the batch is full
+// or the flush interval passes. open is false once reqs is closed.
+func (s *Server) collect(reqs <-chan encryptRequest) (batch []encryptRequest, open bool) {
+	first, ok := <-reqs
+	if !ok {
+		return nil, false
+	}
+	batch = append(batch, first)
+	timer := time.NewTimer(s.flushInterval())
+	defer timer.Stop()
+	for len(batch) < s.batchSize() {
+		select {
+		case req, ok := <-reqs:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, req)
+		case <-timer.C:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// flush encrypts a batch and sends its responses. A vector the plugin
+// refuses gets an error response; a failed request ends the stream with
+// Unavailable, and the client resumes after its last response.
+func (s *Server) flush(ctx context.Context, stream grpc.ServerStream, batch []encryptRequest) error {
+	vectors := make([][]float64, len(batch))
+	for i, req := range batch {
+		vectors[i] = req.Vector
+	}
+	items, params, err := s.Encrypter.EncryptItems(ctx, vectors)
+	if err == nil && len(items) != len(batch) {
+		err = errors.New("result count does not match the batch")
+	}
+	if err != nil {
+		s.logf("ingest: encrypting %d vectors: %v", len(batch), err)
+		return status.Error(codes.Unavailable, "encryption failed; resume after the last response")
+	}
+
+	for i, item := range items {
+		resp := encryptResponse{
+			ID:          batch[i].ID,
+			Ciphertext:  item.Ciphertext,
+			Error:       item.Error,
+			KeyVersion:  params.KeyVersion,
+			TransformID: params.TransformID,
+		}
+		if item.Error != "" {
+			s.vectors.Inc("refused")
+		} else {
+			s.vectors.Inc("encrypted")
+		}
+		out := rawgrpc.Message(resp.encode())
+		if err := stream.SendMsg(&out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
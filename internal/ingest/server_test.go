@@ -0,0 +1,226 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// fakeEncrypter doubles every component, refuses vectors whose first
+// component is negative, and records the size of each batch.
+type fakeEncrypter struct {
+	err error
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (f *fakeEncrypter) EncryptItems(_ context.Context, vectors [][]float64) ([]vaultenc.Item, *vaultenc.SchemeParams, error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, len(vectors))
+	f.mu.Unlock()
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	items := make([]vaultenc.Item, len(vectors))
+	for i, v := range vectors {
+		if len(v) == 0 || v[0] < 0 {
+			items[i].Error = "vector norm out of range"
+			continue
+		}
+		items[i].Ciphertext = make([]float64, len(v))
+		for j, x := range v {
+			items[i].Ciphertext[j] = 2 * x
+		}
+	}
+	return items, &vaultenc.SchemeParams{Scheme: "sap", KeyVersion: 3, Dimension: 2, TransformID: "abc"}, nil
+}
+
+// testStream starts a server over an in-memory listener and opens an
+// Encrypt stream to it.
+func testStream(t *testing.T, server *Server) grpc.ClientStream {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server.ErrorLog = log.New(io.Discard, "", 0)
+	g := server.NewGRPCServer()
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawgrpc.Codec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return openStream(t, conn)
+}
+
+func openStream(t *testing.T, conn *grpc.ClientConn) grpc.ClientStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// request encodes an EncryptRequest with a packed vector.
+func request(id string, vector ...float32) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, id)
+	var packed []byte
+	for _, v := range vector {
+		packed = binary.LittleEndian.AppendUint32(packed, math.Float32bits(v))
+	}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func send(t *testing.T, stream grpc.ClientStream, b []byte) {
+	t.Helper()
+	msg := rawgrpc.Message(b)
+	if err := stream.SendMsg(&msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive decodes the next EncryptResponse.
+func receive(stream grpc.ClientStream) (*encryptResponse, error) {
+	var msg rawgrpc.Message
+	if err := stream.RecvMsg(&msg); err != nil {
+		return nil, err
+	}
+	resp := &encryptResponse{}
+	err := rawgrpc.WalkFields(msg, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			resp.ID = string(v)
+		case 2:
+			for ; len(v) >= 4; v = v[4:] {
+				resp.Ciphertext = append(resp.Ciphertext, float64(math.Float32frombits(binary.LittleEndian.Uint32(v))))
+			}
+		case 3:
+			resp.Error = string(v)
+		case 4:
+			n, _ := protowire.ConsumeVarint(v)
+			resp.KeyVersion = int(n)
+		case 5:
+			resp.TransformID = string(v)
+		}
+		return nil
+	})
+	return resp, err
+}
+
+func TestEncryptStream(t *testing.T) {
+	enc := &fakeEncrypter{}
+	stream := testStream(t, &Server{Encrypter: enc, BatchSize: 4, FlushInterval: time.Hour})
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		first := float32(i)
+		if i == 5 {
+			first = -1
+		}
+		send(t, stream, request(fmt.Sprint("doc-", i), first, 0.5))
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		resp, err := receive(stream)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if resp.ID != fmt.Sprint("doc-", i) {
+			t.Fatalf("response %d has id %q", i, resp.ID)
+		}
+		if i == 5 {
+			if resp.Error == "" || len(resp.Ciphertext) != 0 {
+				t.Errorf("refused vector: %+v", resp)
+			}
+			continue
+		}
+		if resp.Error != "" || len(resp.Ciphertext) != 2 || resp.Ciphertext[0] != float64(2*i) || resp.Ciphertext[1] != 1 {
+			t.Errorf("response %d: %+v", i, resp)
+		}
+		if resp.KeyVersion != 3 || resp.TransformID != "abc" {
+			t.Errorf("response %d: key_version %d, transform_id %q", i, resp.KeyVersion, resp.TransformID)
+		}
+	}
+	if _, err := receive(stream); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last response: %v, want EOF", err)
+	}
+
+	// Full batches go at once; the tail goes when the client closes.
+	if fmt.Sprint(enc.batches) != "[4 4 2]" {
+		t.Errorf("batches = %v, want [4 4 2]", enc.batches)
+	}
+}
+
+func TestEncryptStreamFlushInterval(t *testing.T) {
+	stream := testStream(t, &Server{Encrypter: &fakeEncrypter{}, FlushInterval: time.Millisecond})
+
+	// A partial batch is answered without closing the stream.
+	send(t, stream, request("a", 1, 2))
+	resp, err := receive(stream)
+	if err != nil || resp.ID != "a" {
+		t.Fatalf("got %+v, %v", resp, err)
+	}
+}
+
+func TestEncryptStreamErrors(t *testing.T) {
+	stream := testStream(t, &Server{Encrypter: &fakeEncrypter{err: errors.New("permission denied")}})
+	send(t, stream, request("a", 1, 2))
+	if _, err := receive(stream); status.Code(err) != codes.Unavailable {
+		t.Errorf("encrypter failure: %v, want Unavailable", err)
+	}
+
+	stream = testStream(t, &Server{Encrypter: &fakeEncrypter{}})
+	send(t, stream, []byte{0x12, 0x03, 1, 2, 3})
+	if _, err := receive(stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("malformed request: %v, want InvalidArgument", err)
+	}
+}
+
+func TestDecodeEncryptRequestUnpacked(t *testing.T) {
+	var b []byte
+	for _, v := range []float32{0.25, -1} {
+		b = protowire.AppendTag(b, 2, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(v))
+	}
+	req, err := decodeEncryptRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(req.Vector) != "[0.25 -1]" {
+		t.Errorf("vector = %v", req.Vector)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// ServerTLSConfig returns a mutual TLS config: the server presents
+// certFile and keyFile, and every client must present a certificate
+// issued by a CA in clientCAFile. If allowedClients is not empty, the
+// client certificate must also name one of them as a DNS SAN or, failing
+// that, its common name, so that a CA shared with other services does not
+// admit them all.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string, allowedClients []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("server certificate: %w", err)
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA: no certificates in %s", clientCAFile)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(allowedClients) > 0 {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkClientName(cs, allowedClients)
+		}
+	}
+	return config, nil
+}
+
+// checkClientName admits a verified client certificate that names one of
+// allowed.
+func checkClientName(cs tls.ConnectionState, allowed []string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	names := leaf.DNSNames
+	if len(names) == 0 {
+		names = []string{leaf.Subject.CommonName}
+	}
+	for _, name := range names {
+		if slices.Contains(allowed, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not an allowed client", leaf.Subject.CommonName)
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ingest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/rawgrpc"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a leaf certificate for name, usable by servers and
+// clients.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeFiles writes cert and its key as PEM files and returns their paths.
+func writeFiles(t *testing.T, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := writeFiles(t, ca.issue(t, "ingest.local"))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ServerTLSConfig(certFile, keyFile, caFile, []string{"indexer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := (&Server{Encrypter: &fakeEncrypter{}}).NewGRPCServer(grpc.Creds(credentials.NewTLS(config)))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(clientCerts ...tls.Certificate) error {
+		conn, err := grpc.Dial(lis.Addr().String(),
+			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+				Certificates: clientCerts,
+				RootCAs:      roots,
+				ServerName:   "ingest.local",
+			})),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(rawgrpc.Codec{})))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stream, err := conn.NewStream(context.Background(), &serviceDesc.Streams[0], "/"+serviceName+"/Encrypt")
+		if err != nil {
+			return err
+		}
+		msg := rawgrpc.Message(request("a", 1))
+		if err := stream.SendMsg(&msg); err != nil {
+			return err
+		}
+		_, err = receive(stream)
+		return err
+	}
+
+	if err := dial(ca.issue(t, "indexer")); err != nil {
+		t.Errorf("allowed client: %v", err)
+	}
+	if err := dial(); err == nil {
+		t.Error("client without a certificate was admitted")
+	}
+	if err := dial(ca.issue(t, "someone-else")); err == nil {
+		t.Error("client not in the allowed list was admitted")
+	}
+	if err := dial(newTestCA(t).issue(t, "indexer")); err == nil {
+		t.Error("client from another CA was admitted")
+	}
+}
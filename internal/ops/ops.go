@@ -0,0 +1,201 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ops serves the operational endpoints of the plugin's companion
+// services, so they run on Kubernetes like any other service:
+//
+//	/healthz  liveness: 200 while the process serves HTTP
+//	/readyz   readiness: 200 while Ready succeeds and the service is not
+//	          draining, 503 otherwise
+//	/metrics  counters and gauges in the Prometheus text format
+package ops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readyTimeout bounds one readiness check.
+const readyTimeout = 2 * time.Second
+
+// Server serves the operational endpoints.
+type Server struct {
+	// Ready reports whether the service can do its work, typically by
+	// checking its dependencies. Nil means always ready.
+	Ready func(ctx context.Context) error
+
+	// Metrics is exported at /metrics; nil exports nothing.
+	Metrics *Registry
+
+	draining atomic.Bool
+}
+
+// Drain marks the service as going away: /readyz fails from now on, so
+// the service is taken out of rotation while it finishes in-flight work.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Handler returns the handler of the operational endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		if s.Ready != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+			defer cancel()
+			if err := s.Ready(ctx); err != nil {
+				http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.Metrics.WriteText(w)
+	})
+	return mux
+}
+
+// Listen serves the endpoints on addr in the background. Errors after the
+// listener is open are logged.
+func (s *Server) Listen(addr string) (*http.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Printf("ops: server exited with error: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// Registry holds the metrics of a service. A nil *Registry is valid and
+// hands out nil metrics, which discard updates.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is a registered counter or gauge.
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a counter, partitioned by label unless label is empty.
+func (r *Registry) Counter(name, help, label string) *Counter {
+	if r == nil {
+		return nil
+	}
+	c := &Counter{name: name, help: help, label: label, values: map[string]float64{}}
+	r.register(c)
+	return c
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at each scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	if r == nil {
+		return
+	}
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Counter is a monotonically increasing count. Methods on a nil *Counter
+// do nothing.
+type Counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Inc adds one to the count for value, the counter's label value.
+func (c *Counter) Inc(value string) {
+	c.Add(value, 1)
+}
+
+// Add adds n to the count for value, the counter's label value.
+func (c *Counter) Add(value string, n float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value] += n
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, escapeHelp(c.help), c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %g\n", c.name, c.values[""])
+		return
+	}
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", c.name, c.label, v, c.values[v])
+	}
+}
+
+// gaugeFunc is a gauge read at scrape time.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, escapeHelp(g.help), g.name, g.name, g.fn())
+}
+
+// escapeHelp escapes a HELP text as the exposition format requires.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ops
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestServer_Probes(t *testing.T) {
+	var readyErr error
+	s := &Server{Ready: func(context.Context) error { return readyErr }}
+	h := s.Handler()
+
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d", code)
+	}
+	if code, _ := get(t, h, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d", code)
+	}
+
+	readyErr = fmt.Errorf("vault is sealed")
+	if code, body := get(t, h, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "sealed") {
+		t.Errorf("/readyz = %d %q, want 503 naming the cause", code, body)
+	}
+
+	readyErr = nil
+	s.Drain()
+	if code, _ := get(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining = %d, want 503", code)
+	}
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz while draining = %d, want 200", code)
+	}
+}
+
+func TestRegistry_Metrics(t *testing.T) {
+	r := NewRegistry()
+	results := r.Counter("test_results_total", "Results by kind.", "result")
+	total := r.Counter("test_total", "All of them.", "")
+	r.GaugeFunc("test_inflight", "In flight.", func() float64 { return 3 })
+	results.Inc("ok")
+	results.Inc("ok")
+	results.Add("failed", 1)
+	total.Inc("")
+
+	_, body := get(t, (&Server{Metrics: r}).Handler(), "/metrics")
+	want := `# HELP test_results_total Results by kind.
+# TYPE test_results_total counter
+test_results_total{result="failed"} 1
+test_results_total{result="ok"} 2
+# HELP test_total All of them.
+# TYPE test_total counter
+test_total 1
+# HELP test_inflight In flight.
+# TYPE test_inflight gauge
+test_inflight 3
+`
+	if body != want {
+		t.Errorf("/metrics =\n%s\nwant\n%s", body, want)
+	}
+
+	// Nil registries and counters discard updates.
+	var nilRegistry *Registry
+	nilRegistry.Counter("x", "", "").Inc("")
+	nilRegistry.GaugeFunc("y", "", nil)
+}
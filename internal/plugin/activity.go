@@ -0,0 +1,350 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// activityStoragePrefix is the Vault storage prefix for hourly activity
+	// buckets, stored as stats/activity/<YYYY-MM-DD>/<HH>.
+	activityStoragePrefix = "stats/activity/"
+
+	// activityDayLayout and activityHourLayout format bucket keys (UTC).
+	activityDayLayout  = "2006-01-02"
+	activityHourLayout = "15"
+
+	// maxActivityRangeDays bounds the range of a single stats/activity read.
+	maxActivityRangeDays = 366
+
+	// defaultActivityRangeDays is the range read when no start is given.
+	defaultActivityRangeDays = 30
+
+	// granularityDay and granularityHour select the stats/activity period.
+	granularityDay  = "day"
+	granularityHour = "hour"
+)
+
+// activityKey identifies one counter: who used which role for what, and when.
+type activityKey struct {
+	Hour      time.Time
+	EntityID  string
+	Role      string
+	Operation string
+}
+
+// activityCounts are the counters kept per activityKey.
+type activityCounts struct {
+	Requests uint64 `json:"requests"`
+	Vectors  uint64 `json:"vectors"`
+}
+
+// activityRecord is one stored counter. Only identifiers and counts are
+// kept; no vector content or token material is ever recorded.
+type activityRecord struct {
+	EntityID  string `json:"entity_id"`
+	Role      string `json:"role"`
+	Operation string `json:"operation"`
+	activityCounts
+}
+
+// activityBucket is the stored form of one hour of activity.
+type activityBucket struct {
+	Records []activityRecord `json:"records"`
+}
+
+// activityRecorder accumulates counts in memory until the periodic flush.
+type activityRecorder struct {
+	mu      sync.Mutex
+	pending map[activityKey]activityCounts
+}
+
+// record adds one request of n vectors to the current hour's counters.
+func (r *activityRecorder) record(now time.Time, entityID, role, operation string, n int) {
+	key := activityKey{
+		Hour:      now.UTC().Truncate(time.Hour),
+		EntityID:  entityID,
+		Role:      role,
+		Operation: operation,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[activityKey]activityCounts)
+	}
+	c := r.pending[key]
+	c.Requests++
+	c.Vectors += uint64(n)
+	r.pending[key] = c
+}
+
+// drain removes and returns the pending counters.
+func (r *activityRecorder) drain() map[activityKey]activityCounts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending
+	r.pending = nil
+	return pending
+}
+
+// restore merges counters back after a failed flush so they are retried.
+func (r *activityRecorder) restore(counts map[activityKey]activityCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[activityKey]activityCounts)
+	}
+	for k, c := range counts {
+		p := r.pending[k]
+		p.Requests += c.Requests
+		p.Vectors += c.Vectors
+		r.pending[k] = p
+	}
+}
+
+// recordActivity counts a request of n vectors for the request's entity and role.
+func (b *vectorBackend) recordActivity(ctx context.Context, req *logical.Request, data *framework.FieldData, operation string, n int) {
+	role, _ := data.Get("role").(string)
+	b.activity.record(time.Now(), req.EntityID, role, operation, n)
+	setOperation(ctx, operation, role, n)
+}
+
+// flushActivity merges the pending counters into their hourly storage buckets.
+func (b *vectorBackend) flushActivity(ctx context.Context, storage logical.Storage) error {
+	pending := b.activity.drain()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	byHour := make(map[time.Time]map[activityKey]activityCounts)
+	for k, c := range pending {
+		if byHour[k.Hour] == nil {
+			byHour[k.Hour] = make(map[activityKey]activityCounts)
+		}
+		byHour[k.Hour][k] = c
+	}
+
+	for hour, counts := range byHour {
+		if err := mergeActivityBucket(ctx, storage, hour, counts); err != nil {
+			// Keep the unwritten hours' counts for the next flush.
+			for _, remaining := range byHour {
+				b.activity.restore(remaining)
+			}
+			return err
+		}
+		delete(byHour, hour)
+	}
+	return nil
+}
+
+// mergeActivityBucket adds counts to the stored bucket for hour.
+func mergeActivityBucket(ctx context.Context, storage logical.Storage, hour time.Time, counts map[activityKey]activityCounts) error {
+	path := activityBucketPath(hour)
+	var bucket activityBucket
+	if _, err := getStorageJSON(ctx, storage, path, &bucket); err != nil {
+		return err
+	}
+
+	index := make(map[activityKey]int, len(bucket.Records))
+	for i, rec := range bucket.Records {
+		index[activityKey{Hour: hour, EntityID: rec.EntityID, Role: rec.Role, Operation: rec.Operation}] = i
+	}
+	for k, c := range counts {
+		if i, ok := index[k]; ok {
+			bucket.Records[i].Requests += c.Requests
+			bucket.Records[i].Vectors += c.Vectors
+			continue
+		}
+		bucket.Records = append(bucket.Records, activityRecord{
+			EntityID:       k.EntityID,
+			Role:           k.Role,
+			Operation:      k.Operation,
+			activityCounts: c,
+		})
+	}
+	return putStorageJSON(ctx, storage, path, &bucket)
+}
+
+// activityBucketPath returns the storage path of the bucket for hour.
+func activityBucketPath(hour time.Time) string {
+	return activityStoragePrefix + hour.Format(activityDayLayout) + "/" + hour.Format(activityHourLayout)
+}
+
+// pathActivity returns the path configuration for stats/activity.
+func (b *vectorBackend) pathActivity() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "stats/activity",
+			Fields: map[string]*framework.FieldSchema{
+				"start": {
+					Type:        framework.TypeString,
+					Description: "First day to include (YYYY-MM-DD, UTC). Defaults to 30 days before end.",
+				},
+				"end": {
+					Type:        framework.TypeString,
+					Description: "Last day to include (YYYY-MM-DD, UTC). Defaults to today.",
+				},
+				"granularity": {
+					Type:          framework.TypeString,
+					Description:   "Aggregation period: 'day' or 'hour'.",
+					Default:       granularityDay,
+					AllowedValues: []interface{}{granularityDay, granularityHour},
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleActivityRead,
+					Summary:  "Report operation counts per entity, role and period.",
+				},
+			},
+			HelpSynopsis:    pathActivityHelpSyn,
+			HelpDescription: pathActivityHelpDesc,
+		},
+	}
+}
+
+// activityRow is one row of a stats/activity response.
+type activityRow struct {
+	Period    string `json:"period"`
+	EntityID  string `json:"entity_id"`
+	Role      string `json:"role"`
+	Operation string `json:"operation"`
+	activityCounts
+}
+
+// handleActivityRead aggregates the stored buckets in the requested range.
+func (b *vectorBackend) handleActivityRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := data.Get("end").(string); raw != "" {
+		t, err := time.Parse(activityDayLayout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end: %w", err)
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -defaultActivityRangeDays)
+	if raw := data.Get("start").(string); raw != "" {
+		t, err := time.Parse(activityDayLayout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start: %w", err)
+		}
+		start = t
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end %s is before start %s", end.Format(activityDayLayout), start.Format(activityDayLayout))
+	}
+	if end.Sub(start) > maxActivityRangeDays*24*time.Hour {
+		return nil, fmt.Errorf("range exceeds maximum of %d days", maxActivityRangeDays)
+	}
+	granularity := data.Get("granularity").(string)
+
+	days, err := req.Storage.List(ctx, activityStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	type rowKey struct{ Period, EntityID, Role, Operation string }
+	rows := make(map[rowKey]activityCounts)
+	for _, day := range days {
+		day = strings.TrimSuffix(day, "/")
+		t, err := time.Parse(activityDayLayout, day)
+		if err != nil || t.Before(start) || t.After(end) {
+			continue
+		}
+		hours, err := req.Storage.List(ctx, activityStoragePrefix+day+"/")
+		if err != nil {
+			return nil, err
+		}
+		for _, hour := range hours {
+			if strings.HasSuffix(hour, "/") {
+				continue
+			}
+			var bucket activityBucket
+			if _, err := getStorageJSON(ctx, req.Storage, activityStoragePrefix+day+"/"+hour, &bucket); err != nil {
+				return nil, err
+			}
+			period := day
+			if granularity == granularityHour {
+				period = day + "T" + hour
+			}
+			for _, rec := range bucket.Records {
+				k := rowKey{period, rec.EntityID, rec.Role, rec.Operation}
+				c := rows[k]
+				c.Requests += rec.Requests
+				c.Vectors += rec.Vectors
+				rows[k] = c
+			}
+		}
+	}
+
+	result := make([]activityRow, 0, len(rows))
+	for k, c := range rows {
+		result = append(result, activityRow{
+			Period:         k.Period,
+			EntityID:       k.EntityID,
+			Role:           k.Role,
+			Operation:      k.Operation,
+			activityCounts: c,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.EntityID != b.EntityID {
+			return a.EntityID < b.EntityID
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.Operation < b.Operation
+	})
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"start":       start.Format(activityDayLayout),
+			"end":         end.Format(activityDayLayout),
+			"granularity": granularity,
+			"activity":    result,
+		},
+	}, nil
+}
+
+// Help text constants for the activity path.
+const pathActivityHelpSyn = `Report operation counts per client entity, role and period.`
+
+const pathActivityHelpDesc = `
+This endpoint answers compliance questions such as "which services used this
+key in March" without searching audit logs. Each encrypt request is counted
+by the requesting token's identity entity, the role in the request path,
+and the operation (encrypt, batch, raw, search). Only identifiers and counts
+are stored: no vector content, tokens or client addresses.
+
+Counts are accumulated in memory and written to hourly storage buckets by
+the periodic rollback function, roughly once a minute. Counts from requests
+served by performance standby nodes are kept by that node only.
+
+Parameters:
+  start       - First day (YYYY-MM-DD, UTC; default: 30 days before end)
+  end         - Last day (YYYY-MM-DD, UTC; default: today)
+  granularity - 'day' (default) or 'hour'
+
+Output:
+  activity - Rows of period, entity_id (empty for tokens without an entity),
+             role (empty for the unrestricted paths), operation, requests
+             and vectors
+
+Example:
+  vault read vector/stats/activity start=2026-03-01 end=2026-03-31
+`
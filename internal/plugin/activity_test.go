@@ -0,0 +1,142 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestActivityFlushMergesBuckets(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	hour := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	b.activity.record(hour, "entity-a", "", operationEncrypt, 1)
+	b.activity.record(hour, "entity-a", "", operationEncrypt, 1)
+	b.activity.record(hour, "entity-b", "partners", operationBatch, 10)
+	b.activity.record(hour.Add(time.Hour), "entity-a", "", operationEncrypt, 1)
+	if err := b.flushActivity(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	// A second flush merges into the existing bucket.
+	b.activity.record(hour, "entity-a", "", operationEncrypt, 1)
+	if err := b.flushActivity(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if pending := b.activity.drain(); len(pending) != 0 {
+		t.Errorf("pending after flush = %v, want none", pending)
+	}
+
+	var bucket activityBucket
+	if _, err := getStorageJSON(ctx, s, "stats/activity/2026-03-14/09", &bucket); err != nil {
+		t.Fatal(err)
+	}
+	if len(bucket.Records) != 2 {
+		t.Fatalf("bucket has %d records, want 2: %+v", len(bucket.Records), bucket.Records)
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "stats/activity", map[string]interface{}{
+		"start": "2026-03-01",
+		"end":   "2026-03-31",
+	})
+	rows := resp.Data["activity"].([]activityRow)
+	want := []activityRow{
+		{Period: "2026-03-14", EntityID: "entity-a", Operation: operationEncrypt, activityCounts: activityCounts{Requests: 4, Vectors: 4}},
+		{Period: "2026-03-14", EntityID: "entity-b", Role: "partners", Operation: operationBatch, activityCounts: activityCounts{Requests: 1, Vectors: 10}},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %+v, want %+v", rows, want)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, "stats/activity", map[string]interface{}{
+		"start":       "2026-03-14",
+		"end":         "2026-03-14",
+		"granularity": granularityHour,
+	})
+	if rows := resp.Data["activity"].([]activityRow); len(rows) != 3 {
+		t.Errorf("hourly rows = %+v, want 3", rows)
+	}
+}
+
+func TestBackendRecordsActivity(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector": testVector(0)},
+		Storage:   s,
+		EntityID:  "entity-a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rollback operation runs the periodic function.
+	testRequest(t, b, s, logical.RollbackOperation, "", nil)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "stats/activity", nil)
+	rows := resp.Data["activity"].([]activityRow)
+	if len(rows) != 1 || rows[0].EntityID != "entity-a" || rows[0].Requests != 1 {
+		t.Errorf("activity = %+v, want one encrypt by entity-a", rows)
+	}
+}
+
+func TestActivityRetention(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"stats_retention": "48h",
+	})
+
+	for _, at := range []time.Time{
+		now.Add(-72 * time.Hour),   // expired
+		now.Add(-49 * time.Hour),   // expired
+		now.Add(-47 * time.Hour),   // kept
+		now.Add(-30 * time.Minute), // kept
+	} {
+		b.activity.record(at, "entity-a", "", operationEncrypt, 1)
+	}
+	if err := b.flushActivity(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.runRetention(ctx, s, now); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := logical.CollectKeysWithPrefix(ctx, s, activityStoragePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("activity buckets after retention = %v, want 2", keys)
+	}
+
+	// A second sweep within the interval is skipped.
+	b.activity.record(now.Add(-72*time.Hour), "entity-a", "", operationEncrypt, 1)
+	if err := b.flushActivity(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.runRetention(ctx, s, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = logical.CollectKeysWithPrefix(ctx, s, activityStoragePrefix)
+	if len(keys) != 3 {
+		t.Errorf("activity buckets after skipped sweep = %v, want 3", keys)
+	}
+}
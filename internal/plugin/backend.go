@@ -14,12 +14,19 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gonum.org/v1/gonum/mat"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/compute"
 )
 
 const (
@@ -38,6 +45,122 @@ type rotationConfig struct {
 	Dimension           int     `json:"dimension"`
 	ScalingFactor       float64 `json:"scaling_factor"`
 	ApproximationFactor float64 `json:"approximation_factor"`
+
+	// MinNoiseRadius is an absolute floor on the noise ball radius that does
+	// not scale with ScalingFactor. Zero means the radius is s·β/4 alone.
+	MinNoiseRadius float64 `json:"min_noise_radius,omitempty"`
+
+	// NoiseMask, when set, confines the perturbation to these plaintext
+	// components; see noisemask.go. Empty perturbs every component.
+	NoiseMask componentRanges `json:"noise_mask,omitempty"`
+
+	// NoiseVariance, when set, scales the perturbation of each plaintext
+	// component by its typical spread; see whiten.go. Empty is isotropic.
+	NoiseVariance noiseVariance `json:"noise_variance,omitempty"`
+
+	// EmbeddingModel identifies the embedding model whose vectors this key
+	// encrypts. When set, requests passing a different 'model' are refused,
+	// and RequireModel refuses requests that pass none.
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	RequireModel   bool   `json:"require_model,omitempty"`
+
+	// SeedSource records where the seed's randomness came from; see
+	// randomBytes. Empty for keys stored before it was recorded.
+	SeedSource string `json:"seed_source,omitempty"`
+
+	// Split keys use the product of two factor matrices, which can be
+	// exported separately for threshold decryption; see split.go.
+	Split bool `json:"split,omitempty"`
+
+	// PortableArithmetic generates the matrix and rotates vectors with
+	// arithmetic that is bit-identical on every architecture; see
+	// portable.go.
+	PortableArithmetic bool `json:"portable_arithmetic,omitempty"`
+
+	// Derived keys only encrypt under a derivation context, each with its
+	// own matrix generated from a seed derived by HKDF; see derive.go.
+	Derived bool `json:"derived,omitempty"`
+
+	// Exportable allows config/export to return the seed. It is fixed when
+	// the key is created; see export.go.
+	Exportable bool `json:"exportable,omitempty"`
+
+	// Version numbers the key in the keyring; see keyring.go. Zero for
+	// keys stored before versioning, which are version 1.
+	Version int `json:"version,omitempty"`
+
+	// CreatedAt is when the key was installed. Nil for keys stored before
+	// it was recorded.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// createdAt renders CreatedAt for API responses: RFC 3339, or "" when
+// unknown.
+func (c *rotationConfig) createdAt() string {
+	if c.CreatedAt == nil {
+		return ""
+	}
+	return c.CreatedAt.Format(time.RFC3339)
+}
+
+// checkModel returns an error if a request declaring model may not be
+// encrypted under this key. An empty model declares nothing.
+func (c *rotationConfig) checkModel(model string) error {
+	if model == "" {
+		if c.RequireModel {
+			return fmt.Errorf("this key requires 'model' to be set to its embedding_model %q", c.EmbeddingModel)
+		}
+		return nil
+	}
+	if c.EmbeddingModel != "" && model != c.EmbeddingModel {
+		return fmt.Errorf("model %q does not match the key's embedding_model %q", model, c.EmbeddingModel)
+	}
+	return nil
+}
+
+// noiseRadius returns the effective radius R of the noise ball:
+// max(s·β/4, min_noise_radius).
+func (c *rotationConfig) noiseRadius() float64 {
+	return math.Max((c.ScalingFactor*c.ApproximationFactor)/4.0, c.MinNoiseRadius)
+}
+
+// validate checks a stored configuration before it is used to derive a key.
+func (c *rotationConfig) validate() error {
+	seed, err := base64.StdEncoding.DecodeString(c.Seed)
+	if err != nil {
+		return fmt.Errorf("decode seed: %w", err)
+	}
+	if len(seed) != seedLength {
+		return fmt.Errorf("seed is %d bytes, expected %d", len(seed), seedLength)
+	}
+	if c.Dimension <= 0 || c.Dimension > MaxDimension {
+		return fmt.Errorf("dimension %d out of range (1-%d)", c.Dimension, MaxDimension)
+	}
+	if !(c.ScalingFactor > 0) || math.IsInf(c.ScalingFactor, 0) {
+		return fmt.Errorf("scaling_factor must be a positive finite number (got %v)", c.ScalingFactor)
+	}
+	if !(c.ApproximationFactor >= 0) || math.IsInf(c.ApproximationFactor, 0) {
+		return fmt.Errorf("approximation_factor must be a non-negative finite number (got %v)", c.ApproximationFactor)
+	}
+	if !(c.MinNoiseRadius >= 0) || math.IsInf(c.MinNoiseRadius, 0) {
+		return fmt.Errorf("min_noise_radius must be a non-negative finite number (got %v)", c.MinNoiseRadius)
+	}
+	if err := c.NoiseMask.validate(c.Dimension); err != nil {
+		return err
+	}
+	if err := c.NoiseVariance.validate(c.Dimension); err != nil {
+		return err
+	}
+	if len(c.NoiseMask) > 0 && len(c.NoiseVariance) > 0 {
+		return fmt.Errorf("noise_mask and noise_variance cannot be combined")
+	}
+	if c.Split && c.PortableArithmetic {
+		return fmt.Errorf("split and portable_arithmetic cannot be combined")
+	}
+	if c.Split && c.Derived {
+		return fmt.Errorf("split and derived cannot be combined")
+	}
+	return nil
 }
 
 // vectorBackend is the main backend struct for the DPE secrets engine.
@@ -46,116 +169,581 @@ type rotationConfig struct {
 type vectorBackend struct {
 	*framework.Backend
 
-	// matrixLock protects cachedMatrix and cachedConfig.
+	// matrixLock protects cachedMatrix, cachedConfig and lru.
 	// RLock is used for reads, Lock for writes/invalidation.
 	matrixLock   sync.RWMutex
 	cachedMatrix *mat.Dense
 	cachedConfig *rotationConfig
 
+	// lru caches the matrices of derivation contexts and of older key
+	// versions pinned by key_version, within the cache_max_entries and
+	// cache_max_bytes settings.
+	lru matrixLRU
+
+	// splitFactor caches the imported config/split/factor matrix.
+	splitFactor *mat.Dense
+
+	// matrixFlights are the generations in progress of matrices that missed
+	// the caches above, and cacheEpoch counts the invalidations of those
+	// caches, so that a generation that straddles one is not cached. Both
+	// are protected by matrixLock.
+	matrixFlights map[string]*matrixFlight
+	cacheEpoch    uint64
+
+	// refLock protects matrixRefs, which counts the request leases holding
+	// each cached matrix so invalidation never zeroes one still in use.
+	refLock    sync.Mutex
+	matrixRefs map[*mat.Dense]*matrixRef
+
 	// floatSlicePool reduces GC pressure by reusing []float64 buffers.
 	floatSlicePool sync.Pool
+
+	// settingsLock protects cachedSettings.
+	settingsLock   sync.RWMutex
+	cachedSettings *mountSettings
+
+	// kvLock protects kvClient and cachedKVConfig, used to resolve vector_ref.
+	kvLock         sync.RWMutex
+	kvClient       *api.Client
+	cachedKVConfig *kvConfig
+
+	// indexLock protects ciphertextIndex, the in-memory copy of the
+	// ciphertexts stored under ciphertext/ that search/knn scans. It is nil
+	// until the first search.
+	indexLock       sync.RWMutex
+	ciphertextIndex map[string]*storedCiphertext
+
+	// sinkLock protects webhookSink, built from config/sink. sinkLoaded
+	// tells an unconfigured sink (nil) from one not read yet.
+	sinkLock    sync.RWMutex
+	webhookSink *webhookSink
+	sinkLoaded  bool
+
+	// embeddingsLock protects embeddingsUpstream, built from
+	// config/embeddings, as sinkLock does the sink.
+	embeddingsLock     sync.RWMutex
+	embeddingsUpstream *embeddingsUpstream
+	embeddingsLoaded   bool
+
+	// siemLock protects siemExporter, built from config/siem, as sinkLock
+	// does the sink. The exporter's queue is only closed under the write
+	// lock.
+	siemLock     sync.RWMutex
+	siemExporter *siemExporter
+	siemLoaded   bool
+
+	// outboundLock protects cachedOutbound, the config/outbound settings.
+	outboundLock   sync.RWMutex
+	cachedOutbound *outboundConfig
+
+	// integrationsLock protects integrations, the per-integration outbound
+	// call counters and circuit breakers of this node.
+	integrationsLock sync.Mutex
+	integrations     map[string]*integrationStats
+
+	// approvalLock serializes changes to pending approvals, so that an
+	// approval is approved once and executed once.
+	approvalLock sync.Mutex
+
+	// ceremonyLock serializes changes to the key ceremony.
+	ceremonyLock sync.Mutex
+
+	// splitLock serializes exports of split key factors.
+	splitLock sync.Mutex
+
+	// importLock serializes the creation of the config/import wrapping key.
+	importLock sync.Mutex
+
+	// certificationLock serializes updates to the matrix certifications.
+	certificationLock sync.Mutex
+
+	// keyringLock serializes rotations, so that each archives the key it
+	// replaces under a distinct version.
+	keyringLock sync.Mutex
+
+	// rotationLockMu makes taking the storage rotation lease atomic on
+	// this node; see acquireRotationLock.
+	rotationLockMu sync.Mutex
+
+	// lifecycleLock protects cachedLifecycle.
+	lifecycleLock   sync.RWMutex
+	cachedLifecycle *keyLifecycle
+
+	// repeatSketch counts encryptions per plaintext fingerprint for
+	// averaging-attack mitigation. It is reset whenever the key changes.
+	repeatSketch countMinSketch
+
+	// coalescer batches the rotations of single-vector requests when
+	// coalesce_window is set.
+	coalescer coalescer
+
+	// poolStats tracks floatSlicePool efficiency when pool_stats is enabled.
+	poolStats poolStats
+
+	// pressure samples resource use for the load-shedding thresholds.
+	pressure pressureMonitor
+
+	// matrixCache persists derived matrices on local disk across restarts.
+	// It is nil unless the operator sets VECTOR_DPE_MATRIX_CACHE_DIR.
+	matrixCache *matrixDiskCache
+
+	// mlockMatrices locks every matrix loaded or generated into memory. It
+	// is set by the operator with VECTOR_DPE_MLOCK.
+	mlockMatrices bool
+
+	// warmCancel stops a startup warm-up in progress; warmDone is closed
+	// when it finishes. Both are nil unless warm_on_startup is set.
+	warmCancel context.CancelFunc
+	warmDone   chan struct{}
+
+	// storage is the mount's storage, for background work outside a
+	// request. prefetches tracks matrix prefetches started by invalidate.
+	storage    logical.Storage
+	prefetches sync.WaitGroup
+
+	// ready reports whether requests are served without a matrix generation
+	// delay. It is set at initialization, or once warm-up completes.
+	ready atomic.Bool
+
+	// activity accumulates per-entity request counts until the periodic flush.
+	activity activityRecorder
+
+	// lastRetention is the UnixNano time of the last retention sweep.
+	lastRetention atomic.Int64
+
+	// lastDriftCheck is the UnixNano time of the last periodic drift check.
+	lastDriftCheck atomic.Int64
+
+	// uploadLock serializes changes to upload sessions, which are read,
+	// modified and written back by both requests and the periodic job.
+	uploadLock sync.Mutex
 }
 
 // Factory creates a new instance of the vectorBackend.
 // This is the entry point called by Vault when the plugin is loaded.
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
-	b := &vectorBackend{
-		floatSlicePool: sync.Pool{
-			New: func() interface{} {
-				// Initialize with 0 length, will be resized as needed.
-				s := make([]float64, 0)
-				return &s
-			},
+	b := &vectorBackend{}
+	b.floatSlicePool = sync.Pool{
+		New: func() interface{} {
+			b.poolStats.recordMiss()
+			// Initialize with 0 length, will be resized as needed.
+			s := make([]float64, 0)
+			return &s
 		},
 	}
 
 	b.Backend = &framework.Backend{
-		BackendType:    logical.TypeLogical,
-		Help:           strings.TrimSpace(backendHelp),
+		BackendType: logical.TypeLogical,
+		Help:        strings.TrimSpace(backendHelp),
+		PathsSpecial: &logical.Paths{
+			// Health checks from load balancers carry no token.
+			Unauthenticated: []string{"status"},
+			Root:            sensitivePaths,
+			SealWrapStorage: sealWrappedStorage,
+		},
 		InitializeFunc: b.initialize,
 		Invalidate:     b.invalidate,
+		Clean:          b.cleanup,
+		PeriodicFunc:   b.periodic,
 		Paths: framework.PathAppend(
 			b.pathConfig(),
+			b.pathSettings(),
+			b.pathPolicy(),
+			b.pathLifecycle(),
+			b.pathKeyVersions(),
+			b.pathCompromise(),
+			b.pathRotationLock(),
+			b.pathCeremony(),
+			b.pathSplit(),
+			b.pathImport(),
+			b.pathExport(),
+			b.pathDualControl(),
+			b.pathHistory(),
+			b.pathKV(),
+			b.pathSink(),
+			b.pathEmbeddings(),
+			b.pathOutbound(),
+			b.pathSIEM(),
+			b.pathCanary(),
+			b.pathRoles(),
+			b.pathCiphertext(),
+			b.pathSearch(),
+			b.pathCompare(),
+			b.pathErasure(),
+			b.pathFitScale(),
 			b.pathEncrypt(),
+			b.pathQuery(),
+			b.pathBatch(),
+			b.pathUpload(),
+			b.pathRaw(),
+			b.pathRerandomize(),
+			b.pathRewrap(),
+			b.pathVerify(),
+			b.pathDeterminism(),
+			b.pathInvariants(),
+			b.pathDrift(),
+			b.pathStats(),
+			b.pathOutboundStats(),
+			b.pathCapacity(),
+			b.pathCache(),
+			b.pathActivity(),
+			b.pathStatus(),
+			b.pathDebug(),
 		),
 	}
 
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	b.storage = conf.StorageView
+
+	if dir := os.Getenv(matrixCacheDirEnv); dir != "" {
+		cache, err := newMatrixDiskCache(dir)
+		if err != nil {
+			// The cache is an optimization; run without it rather than fail the mount.
+			b.Logger().Warn("matrix disk cache disabled", "error", err)
+		} else {
+			b.matrixCache = cache
+		}
+	}
+	b.mlockMatrices = mlockEnabled()
+	if computeWorkerEnabled() {
+		client, err := compute.NewClient()
+		if err != nil {
+			// Multiplying in process is always possible; do not fail the mount.
+			b.Logger().Warn("compute worker disabled", "error", err)
+		} else {
+			b.coalescer.compute = client
+		}
+	}
 
 	return b, nil
 }
 
+// borrowFloats takes a []float64 buffer from the pool.
+// The buffer's contents are zero but its length is unspecified; callers
+// resize it and MUST hand it back with returnFloats.
+func (b *vectorBackend) borrowFloats() *[]float64 {
+	b.poolStats.recordBorrow()
+	return b.floatSlicePool.Get().(*[]float64)
+}
+
+// adoptFloats stores s in the borrowed buffer p, recording a grow when s
+// had to be reallocated because p was too small.
+func (b *vectorBackend) adoptFloats(p *[]float64, s []float64) {
+	if cap(s) != cap(*p) {
+		b.poolStats.recordGrow(cap(s))
+	}
+	*p = s
+}
+
+// sizeFloats resizes the borrowed buffer p to n elements.
+func (b *vectorBackend) sizeFloats(p *[]float64, n int) {
+	b.adoptFloats(p, resizeFloats(*p, n))
+}
+
+// returnFloats zeroes a buffer and puts it back in the pool.
+// Zeroing keeps plaintext and ciphertext values from lingering in pooled memory.
+func (b *vectorBackend) returnFloats(p *[]float64) {
+	b.poolStats.recordReturn(cap(*p))
+	buf := (*p)[:cap(*p)]
+	for i := range buf {
+		buf[i] = 0
+	}
+	*p = buf[:0]
+	b.floatSlicePool.Put(p)
+}
+
 // initialize is called when the backend is first mounted or Vault starts.
-// It can be used for any startup initialization.
+// The matrix is lazily loaded on first request unless warm_on_startup is set,
+// in which case it is generated in the background, with the derived matrices
+// of roles with a fixed derivation context, so the first request after a
+// restart or unseal does not pay for it.
 func (b *vectorBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
-	// No special initialization required; matrix is lazily loaded on first request.
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		// Warm-up is an optimization; never fail the mount over it.
+		b.Logger().Warn("skipping matrix warm-up", "error", err)
+		b.ready.Store(true)
+		return nil
+	}
+	if settings.WarmOnStartup {
+		b.startWarmup(req.Storage)
+		return nil
+	}
+	b.ready.Store(true)
 	return nil
 }
 
+// prefetchMatrix generates and caches the current key's matrix in the
+// background. Requests that arrive meanwhile wait on matrixLock rather than
+// generating it twice.
+func (b *vectorBackend) prefetchMatrix() {
+	if b.storage == nil {
+		return
+	}
+	b.prefetches.Add(1)
+	go func() {
+		defer b.prefetches.Done()
+		ctx := context.Background()
+		if disabled, err := b.keyDisabled(ctx, b.storage); err != nil || disabled {
+			return
+		}
+		if _, _, err := b.getMatrixAndConfig(ctx, b.storage); err != nil {
+			b.Logger().Warn("matrix prefetch after rotation failed", "error", err)
+		}
+	}()
+}
+
+// startWarmup generates and caches the matrix in the background.
+// Requests that arrive meanwhile wait on matrixLock rather than generating it twice.
+func (b *vectorBackend) startWarmup(storage logical.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	b.warmCancel, b.warmDone = cancel, done
+
+	go func() {
+		defer close(done)
+		start := time.Now()
+		if disabled, err := b.keyDisabled(ctx, storage); err != nil || disabled {
+			b.Logger().Info("key unavailable; skipping matrix warm-up", "disabled", disabled, "error", err)
+			b.ready.Store(true)
+			return
+		}
+		_, cfg, err := b.getMatrixAndConfig(ctx, storage)
+		switch {
+		case errors.Is(err, errConfigNotInitialized):
+			b.Logger().Debug("no key configured; nothing to warm")
+			b.ready.Store(true)
+		case err != nil:
+			b.Logger().Warn("matrix warm-up failed", "error", err)
+		default:
+			warmed := b.warmRoleMatrices(ctx, storage)
+			b.Logger().Info("matrix warm-up complete",
+				"dimension", cfg.Dimension,
+				"derived", warmed,
+				"duration", time.Since(start))
+		}
+	}()
+}
+
+// warmRoleMatrices generates the derived matrices of roles with a fixed
+// derivation context, up to cache_max_entries, and returns how
+// many it warmed. Identity-templated contexts depend on the requesting
+// entity and are left to their first request.
+func (b *vectorBackend) warmRoleMatrices(ctx context.Context, storage logical.Storage) int {
+	names, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		b.Logger().Warn("listing roles for warm-up failed", "error", err)
+		return 0
+	}
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		b.Logger().Warn("skipping derived matrix warm-up", "error", err)
+		return 0
+	}
+	warmed := make(map[string]bool)
+	for _, name := range names {
+		if len(warmed) >= settings.CacheMaxEntries || ctx.Err() != nil {
+			break
+		}
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil || role == nil || role.DerivationContext == "" || warmed[role.DerivationContext] {
+			continue
+		}
+		if templated, err := framework.ValidateIdentityTemplate(role.DerivationContext); err != nil || templated {
+			continue
+		}
+		if _, _, err := b.getDerivedMatrix(ctx, storage, role.DerivationContext); err != nil {
+			b.Logger().Warn("derived matrix warm-up failed", "role", name, "error", err)
+			continue
+		}
+		warmed[role.DerivationContext] = true
+	}
+	return len(warmed)
+}
+
+// periodic is called by Vault's rollback manager, roughly once a minute.
+// Every job runs even if an earlier one fails, so one failing sweep cannot
+// stall the others; the failures are logged and returned together.
+func (b *vectorBackend) periodic(ctx context.Context, req *logical.Request) error {
+	var errs []error
+	run := func(job string, err error) {
+		if err != nil {
+			b.Logger().Warn(job+" failed", "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", job, err))
+		}
+	}
+	run("activity flush", b.flushActivity(ctx, req.Storage))
+	run("retention sweep", b.runRetention(ctx, req.Storage, time.Now()))
+	run("compromise re-keying job", b.runCompromiseJob(ctx, req.Storage))
+	deleted, err := b.expireCiphertexts(ctx, req.Storage, time.Now())
+	run("ciphertext expiry sweep", err)
+	if deleted > 0 {
+		b.Logger().Info("deleted expired ciphertexts", "deleted", deleted)
+	}
+	run("reference drift check", b.runDriftCheck(ctx, req.Storage, time.Now()))
+	run("upload processing", b.runUploadJobs(ctx, req.Storage, time.Now()))
+	run("approval expiry sweep", b.expireApprovals(ctx, req.Storage, time.Now()))
+	return errors.Join(errs...)
+}
+
+// cleanup is called when the backend is unloaded. It waits for any warm-up
+// or prefetch in progress so the matrix is not cached after the backend is
+// gone, and for the SIEM exporter to deliver the events it has queued. It
+// then stops the compute worker, if any.
+func (b *vectorBackend) cleanup(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if b.warmCancel != nil {
+			b.warmCancel()
+			<-b.warmDone
+		}
+		b.prefetches.Wait()
+		if siemDone := b.resetSIEM(); siemDone != nil {
+			<-siemDone
+		}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	if b.coalescer.compute != nil {
+		b.coalescer.compute.Close()
+	}
+}
+
 // invalidate is called by Vault when a key in storage is modified.
 // This is the "Vault way" to handle cache invalidation rather than ad-hoc checks.
 // It ensures the cache is cleared when config changes, on seal, or on plugin reload.
 func (b *vectorBackend) invalidate(ctx context.Context, key string) {
-	if key == configStoragePath {
+	switch key {
+	case configStoragePath:
 		b.matrixLock.Lock()
+		serving := b.cachedMatrix != nil
 		b.invalidateCacheLocked()
 		b.matrixLock.Unlock()
+		// Another node rotated the key. A node that was serving requests
+		// will need the new matrix; build it now rather than on the next
+		// request.
+		if serving {
+			b.prefetchMatrix()
+		}
+	case settingsStoragePath:
+		b.settingsLock.Lock()
+		b.cachedSettings = nil
+		b.settingsLock.Unlock()
+	case kvStoragePath:
+		b.resetKVClient()
+	case sinkStoragePath:
+		b.resetSink()
+	case embeddingsStoragePath:
+		b.resetEmbeddingsUpstream()
+	case outboundStoragePath:
+		b.resetOutboundClients()
+	case siemStoragePath:
+		b.resetSIEM()
+	case splitFactorStoragePath:
+		b.matrixLock.Lock()
+		b.resetSplitFactorLocked()
+		b.matrixLock.Unlock()
+	default:
+		// Another node stored or deleted a ciphertext.
+		if strings.HasPrefix(key, ciphertextStoragePrefix) {
+			b.resetCiphertextIndex()
+		}
+		// Another node deleted a key version.
+		if version, ok := parseKeyVersionKey(key); ok {
+			b.matrixLock.Lock()
+			b.resetVersionMatrixLocked(version)
+			b.matrixLock.Unlock()
+		}
+	case lifecycleStoragePath:
+		b.lifecycleLock.Lock()
+		b.cachedLifecycle = nil
+		b.lifecycleLock.Unlock()
+		// The key may have been disabled on another node. Zeroize without
+		// touching the disk cache; the matrix is rebuilt on next use.
+		b.matrixLock.Lock()
+		b.zeroizeCacheLocked()
+		b.matrixLock.Unlock()
 	}
 }
 
 // invalidateCacheLocked clears the cached matrix and config.
 // MUST be called while holding matrixLock.
 func (b *vectorBackend) invalidateCacheLocked() {
+	// The disk copies of rotated-away matrices are dead weight; remove them.
+	if b.matrixCache != nil && b.cachedConfig != nil {
+		if seed, err := base64.StdEncoding.DecodeString(b.cachedConfig.Seed); err == nil {
+			if err := b.matrixCache.remove(seed, b.cachedConfig.matrixKind(), b.cachedConfig.Dimension); err != nil {
+				b.Logger().Warn("failed to remove cached matrix", "error", err)
+			}
+			for _, derivationContext := range b.lru.derivedContexts() {
+				derived := b.cachedConfig.deriveSeed(seed, derivationContext)
+				if err := b.matrixCache.remove(derived, b.cachedConfig.derivedMatrixKind(), b.cachedConfig.Dimension); err != nil {
+					b.Logger().Warn("failed to remove cached matrix", "error", err)
+				}
+				zeroBytes(derived)
+			}
+		}
+	}
+	b.zeroizeCacheLocked()
+
+	// Repeat counts are only meaningful for the key they were collected under.
+	b.repeatSketch.reset()
+}
+
+// zeroizeCacheLocked zeroes and drops the cached matrices and config.
+// MUST be called while holding matrixLock.
+func (b *vectorBackend) zeroizeCacheLocked() {
+	b.cacheEpoch++
 	// Memory Hygiene: Zero out the matrix memory before releasing.
-	// Gonum Dense matrices wrap a slice; we can zero that slice.
+	// Gonum Dense matrices wrap a slice; we can zero that slice. Requests
+	// still multiplying with a matrix zero it when they finish.
 	if b.cachedMatrix != nil {
-		data := b.cachedMatrix.RawMatrix().Data
-		for i := range data {
-			data[i] = 0
-		}
+		b.retireMatrixLocked(b.cachedMatrix)
 	}
+	b.lru.clear(b.retireMatrixLocked)
 	b.cachedMatrix = nil
 	b.cachedConfig = nil
 }
 
-// readConfig retrieves the encryption configuration from Vault storage.
+// readConfig retrieves and validates the encryption configuration from Vault storage.
 func (b *vectorBackend) readConfig(ctx context.Context, storage logical.Storage) (*rotationConfig, error) {
-	entry, err := storage.Get(ctx, configStoragePath)
+	var cfg rotationConfig
+	found, err := getStorageJSON(ctx, storage, configStoragePath, &cfg)
 	if err != nil {
 		return nil, err
 	}
-	if entry == nil {
+	if !found {
 		return nil, nil
 	}
-
-	var cfg rotationConfig
-	if err := entry.DecodeJSON(&cfg); err != nil {
-		return nil, err
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("stored config is invalid: %w", err)
 	}
 	return &cfg, nil
 }
 
 // writeConfig persists the encryption configuration to Vault storage.
 func (b *vectorBackend) writeConfig(ctx context.Context, storage logical.Storage, cfg *rotationConfig) error {
-	entry, err := logical.StorageEntryJSON(configStoragePath, cfg)
-	if err != nil {
-		return err
-	}
-	return storage.Put(ctx, entry)
+	return putStorageJSON(ctx, storage, configStoragePath, cfg)
 }
 
 // getMatrixAndConfig returns the cached orthogonal matrix and config.
 // It uses the "Check-Lock-Check" pattern to minimize lock contention.
-// The matrix is lazily generated on first access.
+// The matrix is lazily generated on first access, by one request while
+// the others wait; see generateSharedLocked.
 func (b *vectorBackend) getMatrixAndConfig(ctx context.Context, storage logical.Storage) (*mat.Dense, *rotationConfig, error) {
 	// Fast path: check if already cached (read lock).
 	b.matrixLock.RLock()
 	if b.cachedMatrix != nil && b.cachedConfig != nil {
 		matrix := b.cachedMatrix
 		cfg := b.cachedConfig
+		b.holdMatrixLocked(ctx, matrix)
 		b.matrixLock.RUnlock()
+		recordMatrixCache(matrixCacheMemory, "mount", true)
 		return matrix, cfg, nil
 	}
 	b.matrixLock.RUnlock()
@@ -164,35 +752,117 @@ func (b *vectorBackend) getMatrixAndConfig(ctx context.Context, storage logical.
 	b.matrixLock.Lock()
 	defer b.matrixLock.Unlock()
 
-	// Double-check after acquiring write lock (another goroutine may have populated it).
-	if b.cachedMatrix != nil && b.cachedConfig != nil {
-		return b.cachedMatrix, b.cachedConfig, nil
+	for {
+		// Double-check after acquiring write lock (another goroutine may have populated it).
+		if b.cachedMatrix != nil && b.cachedConfig != nil {
+			b.holdMatrixLocked(ctx, b.cachedMatrix)
+			recordMatrixCache(matrixCacheMemory, "mount", true)
+			return b.cachedMatrix, b.cachedConfig, nil
+		}
+		recordMatrixCache(matrixCacheMemory, "mount", false)
+
+		var cfg *rotationConfig
+		matrix, retry, err := b.generateSharedLocked(ctx, storage, mountFlightKey, func() (*mat.Dense, error) {
+			var err error
+			if cfg, err = b.readConfig(ctx, storage); err != nil {
+				return nil, err
+			}
+			if cfg == nil {
+				return nil, errConfigNotInitialized
+			}
+			seedBytes, err := base64.StdEncoding.DecodeString(cfg.Seed)
+			if err != nil {
+				return nil, fmt.Errorf("decode seed: %w", err)
+			}
+			return b.loadOrGenerateKeyMatrix(cfg, seedBytes)
+		}, func(matrix *mat.Dense) {
+			b.cachedMatrix = matrix
+			b.cachedConfig = cfg
+			b.ready.Store(true)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !retry {
+			return matrix, cfg, nil
+		}
 	}
+}
 
-	cfg, err := b.readConfig(ctx, storage)
-	if err != nil {
-		return nil, nil, err
-	}
-	if cfg == nil {
-		return nil, nil, errConfigNotInitialized
+// matrixKind returns how the matrix of the key cfg is generated from its
+// seed: as the product of two factors for split keys (see split.go).
+func (c *rotationConfig) matrixKind() matrixKind {
+	switch {
+	case c.Split:
+		return matrixSplit
+	case c.PortableArithmetic:
+		return matrixPortable
 	}
+	return matrixOrthogonal
+}
 
-	seedBytes, err := base64.StdEncoding.DecodeString(cfg.Seed)
-	if err != nil {
-		return nil, nil, fmt.Errorf("decode seed: %w", err)
+// loadOrGenerateKeyMatrix returns the matrix of the key cfg, whose decoded
+// seed is seed.
+func (b *vectorBackend) loadOrGenerateKeyMatrix(cfg *rotationConfig, seed []byte) (*mat.Dense, error) {
+	return b.loadOrGenerate(seed, cfg.matrixKind(), cfg.Dimension)
+}
+
+// loadOrGenerateMatrix returns the orthogonal matrix for seed from the disk
+// cache when one is configured, falling back to generating it. Cache
+// failures are logged and never fail the request.
+func (b *vectorBackend) loadOrGenerateMatrix(seed []byte, dim int) (*mat.Dense, error) {
+	return b.loadOrGenerate(seed, matrixOrthogonal, dim)
+}
+
+// loadOrGenerate is loadOrGenerateMatrix for a matrix of any kind.
+func (b *vectorBackend) loadOrGenerate(seed []byte, kind matrixKind, dim int) (*mat.Dense, error) {
+	if b.matrixCache != nil {
+		matrix, err := b.matrixCache.load(seed, kind, dim)
+		switch {
+		case err != nil:
+			b.Logger().Warn("discarding unusable cached matrix", "error", err)
+			if err := b.matrixCache.remove(seed, kind, dim); err != nil {
+				b.Logger().Warn("failed to remove cached matrix", "error", err)
+			}
+		case matrix != nil:
+			recordMatrixCache(matrixCacheDisk, "", true)
+			b.lockLoadedMatrix(matrix)
+			return matrix, nil
+		}
+		recordMatrixCache(matrixCacheDisk, "", false)
 	}
 
-	// GenerateOrthogonalMatrix internally validates orthogonality and returns
-	// an error if the check fails. No need to validate again here.
-	matrix, err := GenerateOrthogonalMatrix(seedBytes, cfg.Dimension)
+	start := time.Now()
+	matrix, err := kind.generate(seed, dim)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	b.lockLoadedMatrix(matrix)
+	if err := b.validateMatrix(seed, matrix); err != nil {
+		zeroMatrix(matrix)
+		return nil, fmt.Errorf("generated matrix failed orthogonality check: %w", err)
+	}
+	recordMatrixGeneration(dim, start)
 
-	b.cachedMatrix = matrix
-	b.cachedConfig = cfg
+	if b.matrixCache != nil {
+		if err := b.matrixCache.store(seed, kind, matrix); err != nil {
+			b.Logger().Warn("failed to cache matrix on disk", "error", err)
+		}
+	}
+	return matrix, nil
+}
 
-	return matrix, cfg, nil
+// lockLoadedMatrix locks a matrix into memory when VECTOR_DPE_MLOCK is set.
+// A failure, typically RLIMIT_MEMLOCK below the matrix size, leaves the
+// matrix swappable but usable, so it is logged rather than returned.
+func (b *vectorBackend) lockLoadedMatrix(matrix *mat.Dense) {
+	if !b.mlockMatrices {
+		return
+	}
+	if err := lockMatrix(matrix); err != nil {
+		b.Logger().Warn("failed to lock matrix in memory; it may be swapped to disk",
+			"bytes", len(matrix.RawMatrix().Data)*8, "error", err)
+	}
 }
 
 // backendHelp is the help text shown when running `vault path-help <mount>`.
@@ -209,8 +879,57 @@ Key Features:
   • Resistance to frequency analysis and known-plaintext attacks
 
 Endpoints:
-  config/rotate   - Generate a new encryption key and set parameters
-  encrypt/vector  - Encrypt a vector embedding
+  config                 - Read the current key's parameters (never the seed)
+  config/rotate          - Generate a new encryption key and set parameters
+  config/import          - Install a caller-supplied seed (BYOK)
+  config/export          - Export an exportable key's seed for backup
+  config/settings        - Configure operational settings (e.g. repeat limiting)
+  config/policy          - Floors on new keys' approximation_factor and dimension
+  config/lifecycle       - Manage the key's lifecycle (e.g. expiration)
+  config/versions/       - Key versions kept across rotations (key_version)
+  config/versions/delete - Delete an older key version (sudo)
+  config/kv              - Read plaintext vectors from KV v2 (vector_ref)
+  config/sink            - Forward stored ciphertexts to a webhook adapter
+  config/embeddings      - Upstream embeddings API of openai/embeddings
+  config/outbound        - mTLS, proxy and timeouts of outbound connections
+  config/siem            - Forward key lifecycle events to a SIEM (HTTPS/syslog)
+  config/disable         - Emergency kill-switch (config/enable restores)
+  config/compromise      - Key-compromise playbook: disable, rotate, re-key
+  config/rotation-lock   - Inspect or break the lock serializing key changes
+  config/ceremony        - Generate the key from several operators' entropy
+  config/dual-control    - Require a second caller's approval (approvals/)
+  history/               - Audit trail of configuration and key changes
+  config/fit-scale       - Recommend a scaling factor from a sample of vectors
+  roles/:name            - Restrict response fields and formats per client role
+  ciphertext/:id         - Read, list and delete ciphertexts stored by encrypt
+  purge/ciphertext       - Delete the stored ciphertexts matching a filter
+  search/knn             - Nearest stored ciphertexts to a query (brute force)
+  compare                - Estimated distance and cosine similarity of two ciphertexts
+  erase/subject          - Erase a data subject's stored ciphertexts
+  encrypt/vector[/:role] - Encrypt a vector embedding
+  encrypt/query[/:role]  - Encrypt a search query without noise
+  encrypt/queries[/:role] - Encrypt related queries under one shared noise draw
+  encrypt/batch[/:role]  - Encrypt a batch of vectors (JSON or NDJSON)
+  encrypt/raw[/:role]    - Encrypt a packed float32 frame of vectors
+  openai/[:role/]embeddings - OpenAI-compatible embeddings, returned encrypted
+  rerandomize/vector[/:role] - Refresh a ciphertext's noise under the same key
+  rewrap/vector[/:role]  - Move a ciphertext from an older key version to the current key
+  decrypt/split          - Apply an imported split-key factor (config/split/)
+  upload/start[/:role]   - Encrypt a batch too large for one request, in parts
+  verify/security-margin - Report security indicators for the current parameters
+  verify/canary[/:role]  - Test a dataset for the key's canary ciphertexts
+  verify/invariants      - Property-test distance preservation against the live key
+  verify/determinism     - Fingerprint this node's matrix, to compare across nodes
+  verify/drift           - Reference vector drift checks (references/ registers them)
+  debug/compare          - Compare plaintext and encrypted distances (dev mode only)
+  debug/stress           - Encrypt while invalidating the cache (dev mode only)
+  stats/pool             - Report buffer pool efficiency and GC pressure
+  stats/outbound         - Outbound call counts, latency and circuit state
+  capacity               - Matrix memory use and headroom on this node
+  cache/status           - Cached derived and key version matrices on this node
+  cache/clear            - Drop them, keeping the current key's matrix
+  stats/activity         - Report operation counts per client entity and role
+  status                 - Report readiness for load balancer health checks
 
 For more information, see the plugin documentation.
 `
@@ -0,0 +1,998 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testDimension keeps matrix generation fast in backend tests.
+const testDimension = 8
+
+// getTestBackend returns a backend backed by in-memory storage.
+func getTestBackend(t *testing.T) (*vectorBackend, logical.Storage) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Factory failed: %v", err)
+	}
+	return b.(*vectorBackend), config.StorageView
+}
+
+// testRequest issues a request against the backend and fails the test on error.
+func testRequest(t *testing.T, b *vectorBackend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Data:      data,
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", op, path, err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("%s %s returned error response: %v", op, path, resp.Error())
+	}
+	return resp
+}
+
+// testVector returns a vector of testDimension elements.
+func testVector(offset float64) []interface{} {
+	v := make([]interface{}, testDimension)
+	for i := range v {
+		v[i] = offset + float64(i)/10
+	}
+	return v
+}
+
+func TestBackendRotateAndEncrypt(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	ciphertext, ok := resp.Data["ciphertext"].([]float64)
+	if !ok || len(ciphertext) != testDimension {
+		t.Fatalf("unexpected ciphertext: %#v", resp.Data["ciphertext"])
+	}
+}
+
+func TestBackendConfigRead(t *testing.T) {
+	b, s := getTestBackend(t)
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config", nil); resp != nil {
+		t.Errorf("config before rotation = %v, want none", resp.Data)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 2.0,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "config", nil)
+	if resp.Data["dimension"] != testDimension || resp.Data["scaling_factor"] != 2.0 ||
+		resp.Data["approximation_factor"] != defaultApproximation || resp.Data["key_version"] != 1 {
+		t.Errorf("config = %v", resp.Data)
+	}
+	if created, err := time.Parse(time.RFC3339, resp.Data["created_at"].(string)); err != nil || time.Since(created) > time.Minute {
+		t.Errorf("created_at = %v", resp.Data["created_at"])
+	}
+	if _, ok := resp.Data["key_id"].(string); !ok {
+		t.Errorf("config has no key_id: %v", resp.Data)
+	}
+	for name, value := range resp.Data {
+		if strings.Contains(name, "seed") && name != "seed_source" {
+			t.Errorf("config returned %s = %v", name, value)
+		}
+	}
+}
+
+func TestBackendEncryptBatch(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), []interface{}{1.0, 2.0}, testVector(1)},
+	})
+	results := resp.Data["batch_results"].([]batchItemResult)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if len(results[0].Ciphertext) != testDimension || results[0].Error != "" {
+		t.Errorf("result 0 = %+v, want ciphertext", results[0])
+	}
+	if results[1].Error == "" {
+		t.Error("result 1 should report a dimension mismatch")
+	}
+	if len(results[2].Ciphertext) != testDimension {
+		t.Errorf("result 2 = %+v, want ciphertext", results[2])
+	}
+}
+
+func TestBackendEncryptMetadata(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	metadata := map[string]interface{}{"request_id": "r-17", "source": "docs"}
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":   testVector(0),
+		"id":       "doc-1",
+		"metadata": metadata,
+	})
+	if got := resp.Data["metadata"].(map[string]string); got["request_id"] != "r-17" || len(got) != 2 {
+		t.Errorf("encrypt/vector metadata = %v, want the request's", got)
+	}
+	stored := testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-1", nil)
+	if got := stored.Data["metadata"].(map[string]string); got["source"] != "docs" {
+		t.Errorf("stored metadata = %v, want the request's", got)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":  []interface{}{testVector(1)},
+		"metadata": metadata,
+	})
+	if got := resp.Data["metadata"].(map[string]string); got["request_id"] != "r-17" {
+		t.Errorf("encrypt/batch metadata = %v, want the request's", got)
+	}
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":  []interface{}{testVector(1)},
+		"metadata": metadata,
+		"format":   formatNDJSON,
+	})
+	if body := string(resp.Data[logical.HTTPRawBody].([]byte)); !strings.Contains(body, `"request_id":"r-17"`) {
+		t.Errorf("ndjson body = %s, want metadata on each line", body)
+	}
+
+	tooMany := make(map[string]interface{})
+	for i := 0; i <= maxMetadataKeys; i++ {
+		tooMany[fmt.Sprintf("k%d", i)] = "v"
+	}
+	for name, md := range map[string]map[string]interface{}{
+		"keys":  tooMany,
+		"bytes": {"k": strings.Repeat("x", maxMetadataBytes)},
+	} {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/vector",
+			Data:      map[string]interface{}{"vector": testVector(0), "metadata": md},
+			Storage:   s,
+		})
+		if err == nil {
+			t.Errorf("%s: oversized metadata was accepted", name)
+		}
+	}
+}
+
+func TestBackendOutputDefaults(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"default_format":   formatNDJSON,
+		"output_precision": precisionFloat32,
+	})
+
+	// Mount defaults apply when the request passes nothing.
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0)},
+	})
+	if resp.Data[logical.HTTPContentType] != contentTypeNDJSON {
+		t.Errorf("content type = %v, want %s", resp.Data[logical.HTTPContentType], contentTypeNDJSON)
+	}
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	for i, c := range resp.Data["ciphertext"].([]float64) {
+		if c != float64(float32(c)) {
+			t.Fatalf("component %d = %v, not rounded to float32", i, c)
+		}
+	}
+
+	// Per-request fields override them.
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":   []interface{}{testVector(0)},
+		"format":    formatJSON,
+		"precision": precisionFloat64,
+	})
+	results := resp.Data["batch_results"].([]batchItemResult)
+	rounded := true
+	for _, c := range results[0].Ciphertext {
+		rounded = rounded && c == float64(float32(c))
+	}
+	if rounded {
+		t.Error("precision=float64 result is rounded to float32")
+	}
+}
+
+func TestBackendEncryptRaw(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	var frame []byte
+	for n := 0; n < 3; n++ {
+		vec, _ := parseVector(testVector(float64(n)))
+		frame = packFloat32(frame, vec)
+	}
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/raw", map[string]interface{}{
+		"frame": base64.StdEncoding.EncodeToString(frame),
+	})
+	body := resp.Data[logical.HTTPRawBody].([]byte)
+	if len(body) != len(frame) {
+		t.Fatalf("response frame length = %d, want %d", len(body), len(frame))
+	}
+	if resp.Data[logical.HTTPContentType] != contentTypeOctetStream {
+		t.Errorf("content type = %v, want %s", resp.Data[logical.HTTPContentType], contentTypeOctetStream)
+	}
+}
+
+func TestBackendPoolStats(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	// Disabled by default: requests are not counted.
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "stats/pool", nil)
+	if resp.Data["enabled"].(bool) || resp.Data["requests"].(uint64) != 0 {
+		t.Fatalf("stats should be disabled and empty: %v", resp.Data)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"pool_stats": true,
+	})
+	// Reading the stats loads the new settings before the first borrow.
+	testRequest(t, b, s, logical.ReadOperation, "stats/pool", nil)
+	for i := 0; i < 5; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector": testVector(float64(i)),
+		})
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, "stats/pool", nil)
+	if got := resp.Data["requests"].(uint64); got != 5 {
+		t.Errorf("requests = %d, want 5", got)
+	}
+	if got := resp.Data["borrows"].(uint64); got != 15 {
+		t.Errorf("borrows = %d, want 15 (3 per request)", got)
+	}
+	if got := resp.Data["max_buffer_elements"].(int64); got < testDimension {
+		t.Errorf("max_buffer_elements = %d, want >= %d", got, testDimension)
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, "stats/pool", nil)
+	resp = testRequest(t, b, s, logical.ReadOperation, "stats/pool", nil)
+	if got := resp.Data["borrows"].(uint64); got != 0 {
+		t.Errorf("borrows after reset = %d, want 0", got)
+	}
+}
+
+func TestBackendWarmOnStartup(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"warm_on_startup": true,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant-a", map[string]interface{}{
+		"derivation_context": "tenant-a",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/per-entity", map[string]interface{}{
+		"derivation_context": "{{identity.entity.id}}",
+	})
+
+	// Simulate a restart: a fresh backend over the same storage.
+	config := logical.TestBackendConfig()
+	config.StorageView = s
+	raw, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := raw.(*vectorBackend)
+	resp := testRequest(t, restarted, s, logical.ReadOperation, "status", nil)
+	if got := resp.Data[logical.HTTPStatusCode]; got != http.StatusServiceUnavailable {
+		t.Errorf("status before initialization = %v, want 503", got)
+	}
+	if err := restarted.Initialize(context.Background(), &logical.InitializationRequest{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.warmDone == nil {
+		t.Fatal("warm-up not started")
+	}
+	<-restarted.warmDone
+
+	restarted.matrixLock.RLock()
+	warmed := restarted.cachedMatrix != nil
+	derived := len(restarted.lru.derivedContexts())
+	_, tenantWarmed := restarted.lru.entries[derivedFlightKey("tenant-a")]
+	restarted.matrixLock.RUnlock()
+	if !warmed {
+		t.Error("matrix not cached after warm-up")
+	}
+	// Only the fixed context is known before a request arrives.
+	if derived != 1 || !tenantWarmed {
+		t.Errorf("derived matrices after warm-up = %d (tenant-a: %v), want tenant-a only", derived, tenantWarmed)
+	}
+	resp = testRequest(t, restarted, s, logical.ReadOperation, "status", nil)
+	if ready, _ := resp.Data["ready"].(bool); !ready {
+		t.Errorf("status after warm-up = %v, want ready", resp.Data)
+	}
+	restarted.Cleanup(context.Background())
+}
+
+func TestBackendStatusReadyWithoutWarmup(t *testing.T) {
+	b, s := getTestBackend(t)
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	resp := testRequest(t, b, s, logical.ReadOperation, "status", nil)
+	if ready, _ := resp.Data["ready"].(bool); !ready {
+		t.Errorf("status = %v, want ready", resp.Data)
+	}
+}
+
+func TestBackendRoleFiltering(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	// Force clipping so responses carry clipped_components and warnings.
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"max_abs_output": 0.001,
+		"clip_policy":    clipPolicyClip,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"hidden_fields":   "clipped_components,warnings",
+		"allowed_formats": "json",
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if _, ok := resp.Data["clipped_components"]; !ok || len(resp.Warnings) == 0 {
+		t.Fatalf("unrestricted response lacks clipping metadata: %+v", resp)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/partners", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if _, ok := resp.Data["clipped_components"]; ok || len(resp.Warnings) != 0 {
+		t.Errorf("role response leaks hidden fields: %+v", resp)
+	}
+	if _, ok := resp.Data["ciphertext"]; !ok {
+		t.Error("role response lacks ciphertext")
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch/partners", map[string]interface{}{
+		"vectors": []interface{}{testVector(1)},
+	})
+	item := resp.Data["batch_results"].([]batchItemResult)[0]
+	if item.ClippedComponents != 0 || item.Warnings != nil {
+		t.Errorf("batch item leaks hidden fields: %+v", item)
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"encrypt/batch/partners": {"vectors": []interface{}{testVector(1)}, "format": formatNDJSON},
+		"encrypt/raw/partners":   {"frame": "AAAAAA=="},
+		"encrypt/vector/missing": {"vector": testVector(1)},
+	} {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+
+	resp = testRequest(t, b, s, logical.ListOperation, "roles/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "partners" {
+		t.Errorf("role list = %v, want [partners]", keys)
+	}
+}
+
+func TestBackendRoleHidesEachField(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	// Clip the output and scale outliers, so responses carry every field.
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"max_abs_output": 0.001,
+		"clip_policy":    clipPolicyClip,
+		"max_abs_input":  10.0,
+		"outlier_policy": outlierPolicyScale,
+	})
+	spike := testVector(1)
+	spike[2] = 1000.0
+	vec, _ := parseVector(testVector(1))
+	frame := base64.StdEncoding.EncodeToString(packFloat32(nil, vec))
+
+	for _, field := range hideableFields {
+		t.Run(field, func(t *testing.T) {
+			testRequest(t, b, s, logical.UpdateOperation, "roles/"+field, map[string]interface{}{
+				"hidden_fields": field,
+			})
+			for _, role := range []string{"", "/" + field} {
+				hidden := role != ""
+				resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector"+role, map[string]interface{}{
+					"vector": spike,
+				})
+				if _, ok := resp.Data[field]; ok == hidden && field != "warnings" {
+					t.Errorf("encrypt/vector%s: %s present = %v", role, field, ok)
+				}
+				if field == "warnings" && (len(resp.Warnings) == 0) != hidden {
+					t.Errorf("encrypt/vector%s: warnings = %v", role, resp.Warnings)
+				}
+
+				// Items are checked through their JSON encoding, which
+				// omits zero fields.
+				resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch"+role, map[string]interface{}{
+					"vectors": []interface{}{spike},
+					"format":  formatNDJSON,
+				})
+				var line map[string]interface{}
+				if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &line); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := line[field]; ok == hidden {
+					t.Errorf("encrypt/batch%s NDJSON: %s present = %v", role, field, ok)
+				}
+
+				header, ok := schemeHeaders[field]
+				if !ok {
+					continue
+				}
+				resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/raw"+role, map[string]interface{}{
+					"frame": frame,
+				})
+				if _, ok := resp.Headers[header]; ok == hidden {
+					t.Errorf("encrypt/raw%s: %s present = %v", role, header, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendRoleAllowedOperations(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/single", map[string]interface{}{
+		"allowed_operations": operationEncrypt,
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/single", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/batch/single",
+		Data:      map[string]interface{}{"vectors": []interface{}{testVector(0)}},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("batch should be refused for a role allowing only encrypt")
+	}
+
+	// Roles stored before allowed_operations existed keep the operations
+	// available at that time.
+	if err := putStorageJSON(context.Background(), s, roleStoragePrefix+"legacy", map[string]interface{}{
+		"hidden_fields":   []string{},
+		"allowed_formats": allFormats,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp := testRequest(t, b, s, logical.ReadOperation, "roles/legacy", nil)
+	if ops := resp.Data["allowed_operations"].([]string); len(ops) != len(legacyOperations) {
+		t.Errorf("legacy role operations = %v, want %v", ops, legacyOperations)
+	}
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/bad",
+		Data:      map[string]interface{}{"allowed_operations": "decrypt"},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("unknown operation should be rejected")
+	}
+}
+
+func TestBackendRoleDerivationContext(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	sysView := &logical.StaticSystemView{
+		EntityVal: &logical.Entity{ID: "entity-a", Metadata: map[string]string{"tenant_id": "acme"}},
+	}
+	config.System = sysView
+	raw, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, s := raw.(*vectorBackend), config.StorageView
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "{{identity.entity.metadata.tenant_id}}",
+	})
+
+	encrypt := func(path, entityID string) ([]float64, error) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      map[string]interface{}{"vector": testVector(1)},
+			Storage:   s,
+			EntityID:  entityID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data["ciphertext"].([]float64), nil
+	}
+
+	// Without noise, ciphertexts compare directly across keys.
+	mount, err := encrypt("encrypt/vector", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, err := encrypt("encrypt/vector/tenant", "entity-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equalFloats(mount, acme) {
+		t.Error("derived key produced the mount key's ciphertext")
+	}
+	again, err := encrypt("encrypt/vector/tenant", "entity-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalFloats(acme, again) {
+		t.Error("derived key is not stable across requests")
+	}
+
+	sysView.EntityVal = &logical.Entity{ID: "entity-b", Metadata: map[string]string{"tenant_id": "globex"}}
+	globex, err := encrypt("encrypt/vector/tenant", "entity-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equalFloats(acme, globex) {
+		t.Error("different tenants share a derived key")
+	}
+
+	if _, err := encrypt("encrypt/vector/tenant", ""); err == nil {
+		t.Error("expected error for a token without an entity")
+	}
+	sysView.EntityVal = &logical.Entity{ID: "entity-c"}
+	if _, err := encrypt("encrypt/vector/tenant", "entity-c"); err == nil {
+		t.Error("expected error for an entity without tenant metadata")
+	}
+}
+
+func TestBackendRequestContext(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/acme", map[string]interface{}{
+		"derivation_context": "acme",
+	})
+
+	encrypt := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		data["vector"] = testVector(1)
+		return testRequest(t, b, s, logical.UpdateOperation, path, data)
+	}
+	mount := encrypt("encrypt/vector", map[string]interface{}{})
+	acme := encrypt("encrypt/vector", map[string]interface{}{"context": "acme", "id": "acme-1"})
+	again := encrypt("encrypt/vector", map[string]interface{}{"context": "acme"})
+	globex := encrypt("encrypt/vector", map[string]interface{}{"context": "globex"})
+	role := encrypt("encrypt/vector/acme", map[string]interface{}{})
+
+	ciphertext := func(resp *logical.Response) []float64 { return resp.Data["ciphertext"].([]float64) }
+	if !equalFloats(ciphertext(acme), ciphertext(again)) {
+		t.Error("context key is not stable across requests")
+	}
+	for name, other := range map[string]*logical.Response{"mount": mount, "globex": globex, "role": role} {
+		if equalFloats(ciphertext(acme), ciphertext(other)) {
+			t.Errorf("context acme shares a key with %s", name)
+		}
+		if acme.Data["transform_id"] == other.Data["transform_id"] {
+			t.Errorf("context acme shares a transform_id with %s", name)
+		}
+	}
+	if acme.Data["context"] != "acme" || acme.Data["key_id"] == nil {
+		t.Errorf("context not recorded: %v", acme.Data)
+	}
+	if _, ok := mount.Data["context"]; ok {
+		t.Error("context recorded without one")
+	}
+
+	stored := testRequest(t, b, s, logical.ReadOperation, "ciphertext/acme-1", nil)
+	if stored.Data["context"] != "acme" || stored.Data["key_id"] != acme.Data["key_id"] {
+		t.Errorf("stored ciphertext = %v", stored.Data)
+	}
+
+	// Only a search under the same context finds the ciphertext.
+	search := func(data map[string]interface{}) []searchHit {
+		t.Helper()
+		data["vector"] = testVector(1)
+		return testRequest(t, b, s, logical.UpdateOperation, "search/knn", data).Data["results"].([]searchHit)
+	}
+	if hits := search(map[string]interface{}{"context": "acme"}); len(hits) != 1 || hits[0].ID != "acme-1" {
+		t.Errorf("search under acme = %v", hits)
+	}
+	if hits := search(map[string]interface{}{"context": "globex"}); len(hits) != 0 {
+		t.Errorf("search under globex = %v", hits)
+	}
+	if hits := search(map[string]interface{}{}); len(hits) != 0 {
+		t.Errorf("search under the mount key = %v", hits)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"key_version": {"context": "acme", "key_version": 1},
+		"nul":         {"context": "ac\x00me"},
+		"too long":    {"context": strings.Repeat("x", maxRequestContextLength+1)},
+	} {
+		data["vector"] = testVector(1)
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/vector",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBackendKeyExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":  testDimension,
+		"expires_at": future,
+	})
+	if resp.Data["expires_at"] != future {
+		t.Fatalf("expires_at = %v, want %s", resp.Data["expires_at"], future)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	// Deadlines in the past are rejected at the API.
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/lifecycle",
+		Data:      map[string]interface{}{"expires_at": "2000-01-01T00:00:00Z"},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatal("past expires_at was accepted")
+	}
+
+	// Simulate the deadline passing.
+	past := time.Now().Add(-time.Minute)
+	if err := b.writeLifecycle(ctx, s, &keyLifecycle{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"encrypt/vector", "encrypt/batch"} {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data: map[string]interface{}{
+				"vector":  testVector(0),
+				"vectors": []interface{}{testVector(0)},
+			},
+			Storage: s,
+		})
+		if !errors.Is(err, errKeyExpired) {
+			t.Errorf("%s: err = %v, want errKeyExpired", path, err)
+		}
+	}
+
+	// Clearing the deadline restores encryption without rotating.
+	testRequest(t, b, s, logical.UpdateOperation, "config/lifecycle", map[string]interface{}{
+		"expires_at": "",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	// Rotation resets the lifecycle for the new key.
+	if err := b.writeLifecycle(ctx, s, &keyLifecycle{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp = testRequest(t, b, s, logical.ReadOperation, "config/lifecycle", nil)
+	if resp.Data["expires_at"] != "" {
+		t.Errorf("expires_at after rotation = %v, want empty", resp.Data["expires_at"])
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+}
+
+func TestBackendKeyDisable(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	// Without noise, encryption is deterministic and the key is observable.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	before := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/disable", nil)
+	if resp.Data["disabled"] != true {
+		t.Fatalf("disabled = %v, want true", resp.Data["disabled"])
+	}
+	b.matrixLock.RLock()
+	cached := b.cachedMatrix
+	b.matrixLock.RUnlock()
+	if cached != nil {
+		t.Error("matrix still cached after disable")
+	}
+
+	for _, path := range []string{"encrypt/vector", "encrypt/batch"} {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data: map[string]interface{}{
+				"vector":  testVector(0),
+				"vectors": []interface{}{testVector(0)},
+			},
+			Storage: s,
+		})
+		if !errors.Is(err, errKeyDisabled) {
+			t.Errorf("%s: err = %v, want errKeyDisabled", path, err)
+		}
+	}
+
+	// Enabling restores the same key.
+	testRequest(t, b, s, logical.UpdateOperation, "config/enable", nil)
+	after := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if !equalFloats(before.Data["ciphertext"].([]float64), after.Data["ciphertext"].([]float64)) {
+		t.Error("key changed across disable/enable")
+	}
+
+	// Rotation resets the kill-switch.
+	testRequest(t, b, s, logical.UpdateOperation, "config/disable", nil)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+}
+
+func TestBackendEmbeddingModel(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":       testDimension,
+		"embedding_model": "text-embedding-3-small",
+	})
+	if resp.Data["embedding_model"] != "text-embedding-3-small" {
+		t.Fatalf("embedding_model = %v, want text-embedding-3-small", resp.Data["embedding_model"])
+	}
+
+	encrypt := func(path string, model interface{}) error {
+		t.Helper()
+		data := map[string]interface{}{
+			"vector":  testVector(0),
+			"vectors": []interface{}{testVector(0)},
+		}
+		if model != nil {
+			data["model"] = model
+		}
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		return err
+	}
+	for _, path := range []string{"encrypt/vector", "encrypt/batch", "search/knn"} {
+		if err := encrypt(path, "all-MiniLM-L6-v2"); err == nil {
+			t.Errorf("%s: mismatched model was accepted", path)
+		}
+		if err := encrypt(path, "text-embedding-3-small"); err != nil {
+			t.Errorf("%s: matching model: %v", path, err)
+		}
+		if err := encrypt(path, nil); err != nil {
+			t.Errorf("%s: no model without require_model: %v", path, err)
+		}
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Data:      map[string]interface{}{"dimension": testDimension, "require_model": true},
+		Storage:   s,
+	}); err == nil {
+		t.Fatal("require_model without embedding_model was accepted")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":       testDimension,
+		"embedding_model": "text-embedding-3-small",
+		"require_model":   true,
+	})
+	if err := encrypt("encrypt/vector", nil); err == nil {
+		t.Error("missing model was accepted with require_model")
+	}
+	if err := encrypt("encrypt/vector", "text-embedding-3-small"); err != nil {
+		t.Errorf("matching model with require_model: %v", err)
+	}
+}
+
+// equalFloats reports whether a and b are element-wise equal.
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSensitivePaths(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	// Bulk, irreversible deletions are sensitive like the key operations.
+	for _, path := range []string{"purge/ciphertext", "erase/subject"} {
+		if !slices.Contains(sensitivePaths, path) {
+			t.Errorf("%s is not a sensitive path", path)
+		}
+	}
+
+	for _, path := range sensitivePaths {
+		t.Run(path, func(t *testing.T) {
+			if !slices.Contains(b.SpecialPaths().Root, path) {
+				t.Error("not registered as a root (sudo) path")
+			}
+
+			var found *framework.Path
+			for _, p := range b.Paths {
+				// The framework anchors patterns on first request.
+				if strings.TrimSuffix(strings.TrimPrefix(p.Pattern, "^"), "$") == path {
+					found = p
+				}
+			}
+			if found == nil {
+				t.Fatal("no dedicated path with this exact pattern")
+			}
+			for op := range found.Operations {
+				if op != logical.CreateOperation && op != logical.UpdateOperation {
+					t.Errorf("exposes %s; sensitive paths accept create/update only", op)
+				}
+			}
+
+			// Control groups deliver responses through a wrapping token,
+			// which requires a JSON (not raw) response.
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      path,
+				Data:      map[string]interface{}{"dimension": testDimension},
+				Storage:   s,
+				WrapInfo:  &logical.RequestWrapInfo{TTL: time.Minute},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || resp.Data == nil {
+				t.Fatal("empty response cannot carry the wrapped result")
+			}
+			if _, raw := resp.Data[logical.HTTPRawBody]; raw {
+				t.Error("raw HTTP responses cannot be response-wrapped")
+			}
+		})
+	}
+}
+
+// putFailingStorage fails every write under prefix.
+type putFailingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *putFailingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestPeriodicRunsEveryJob(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if _, err := entityRequest(b, s, "entity-a", logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	if err := putStorageJSON(ctx, s, approvalPrefix+"stale", &pendingApproval{
+		ID:        "stale",
+		Path:      "config/rotate",
+		CreatedAt: expired.Add(-time.Hour),
+		ExpiresAt: expired,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The activity flush, the first job, fails; the approval sweep, the
+	// last, still runs.
+	err := b.periodic(ctx, &logical.Request{Storage: &putFailingStorage{Storage: s, prefix: activityStoragePrefix}})
+	if err == nil || !strings.Contains(err.Error(), "activity flush") {
+		t.Errorf("periodic = %v, want the activity flush failure", err)
+	}
+	if entry, err := s.Get(ctx, approvalPrefix+"stale"); err != nil || entry != nil {
+		t.Errorf("expired approval left behind after a failed job: %v, %v", entry, err)
+	}
+}
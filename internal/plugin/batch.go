@@ -0,0 +1,570 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// maxBatchSize is the maximum number of vectors accepted in a single batch request.
+	maxBatchSize = 1024
+
+	// maxItemIDLength bounds the caller's IDs in the 'items' input.
+	maxItemIDLength = 512
+
+	// formatJSON returns batch results in the standard Vault JSON response.
+	formatJSON = "json"
+
+	// formatNDJSON returns batch results as newline-delimited JSON, one result per line.
+	formatNDJSON = "ndjson"
+
+	// contentTypeNDJSON is the media type of NDJSON bodies.
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// pathBatch returns the path configuration for encrypt/batch.
+func (b *vectorBackend) pathBatch() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("encrypt/batch"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"vectors": {
+					Type:        framework.TypeSlice,
+					Description: "Embedding vectors to encrypt (array of float arrays).",
+				},
+				"items": {
+					Type:        framework.TypeSlice,
+					Description: "Vectors tagged with the caller's IDs, as [{\"id\": \"doc-1\", \"vector\": [...]}, ...]; each result echoes its id. Alternative to 'vectors'.",
+				},
+				"ndjson": {
+					Type:        framework.TypeString,
+					Description: "Newline-delimited vectors, one JSON array (or {\"vector\": [...]} object) per line. Alternative to 'vectors'.",
+				},
+				"format": {
+					Type:          framework.TypeString,
+					Description:   "Response format: 'json' or 'ndjson' (raw application/x-ndjson body, one result per line). Defaults to the mount's default_format.",
+					AllowedValues: []interface{}{formatJSON, formatNDJSON},
+				},
+				"precision": precisionField,
+				"encoding":  encodingField,
+				"model":     modelField,
+				"ids": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Also store each ciphertext at ciphertext/<id>; one ID per input vector, in order.",
+				},
+				"ttl":                ttlField,
+				"subject":            subjectField,
+				"metadata":           metadataField,
+				"key_version":        keyVersionField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptBatch,
+					Summary:  "Encrypt a batch of vectors using the Scale-And-Perturb scheme.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptBatch,
+					Summary:  "Encrypt a batch of vectors using the Scale-And-Perturb scheme.",
+				},
+			},
+			ExistenceCheck:  b.encryptExists,
+			HelpSynopsis:    pathBatchHelpSyn,
+			HelpDescription: pathBatchHelpDesc,
+		},
+	}
+}
+
+// batchItemResult is the per-vector outcome of a batch request.
+// Exactly one of Ciphertext or Error is set.
+type batchItemResult struct {
+	// ID is the caller's ID of the item, for requests that pass 'items'.
+	ID string `json:"id,omitempty"`
+
+	Ciphertext         []float64      `json:"ciphertext,omitempty"`
+	ClippedComponents  int            `json:"clipped_components,omitempty"`
+	OutlierComponents  int            `json:"outlier_components,omitempty"`
+	InputScale         float64        `json:"input_scale,omitempty"`
+	Warnings           []string       `json:"warnings,omitempty"`
+	StructuredWarnings []typedWarning `json:"structured_warnings,omitempty"`
+	Error              string         `json:"error,omitempty"`
+	Canary             bool           `json:"canary,omitempty"`
+	KeyVersion         int            `json:"key_version,omitempty"`
+
+	// Scheme, Dimension and TransformID are set on NDJSON lines only; JSON
+	// responses carry them once at the top level. See scheme.go.
+	Scheme      string `json:"scheme,omitempty"`
+	Dimension   int    `json:"dimension,omitempty"`
+	TransformID string `json:"transform_id,omitempty"`
+
+	// Metadata echoes the request's metadata on every NDJSON line, which
+	// has no enclosing object to carry it once.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Packed replaces Ciphertext in the encoded result under
+	// encoding=base64; see packCiphertext.
+	Packed string `json:"-"`
+}
+
+// MarshalJSON encodes the result, with the ciphertext as a base64 string
+// when it is packed.
+func (r batchItemResult) MarshalJSON() ([]byte, error) {
+	type plain batchItemResult
+	if r.Packed == "" {
+		return json.Marshal(plain(r))
+	}
+	return json.Marshal(struct {
+		plain
+		Ciphertext string `json:"ciphertext"`
+	}{plain(r), r.Packed})
+}
+
+// handleEncryptBatch encrypts each vector of the batch independently.
+// Per-item failures are reported in the item's result rather than failing the whole batch.
+func (b *vectorBackend) handleEncryptBatch(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	// Panic Safety: Recover from panics (e.g., gonum matrix math or memory issues).
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationBatch); err != nil {
+		return nil, err
+	}
+
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkPressure(settings, operationBatch); err != nil {
+		return nil, err
+	}
+	format := settings.DefaultFormat
+	if raw, ok := data.GetOk("format"); ok {
+		format = raw.(string)
+	}
+	if err := role.checkFormat(format); err != nil {
+		return nil, err
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawItems, itemIDs, err := batchInput(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawItems) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one vector")
+	}
+	if len(rawItems) > maxBatchSize {
+		return nil, fmt.Errorf("batch of %d vectors exceeds maximum %d", len(rawItems), maxBatchSize)
+	}
+
+	ids, err := batchStoreIDs(data, role, len(rawItems))
+	if err != nil {
+		return nil, err
+	}
+	store, err := parseStoreOptions(data, ids != nil)
+	if err != nil {
+		return nil, err
+	}
+	if store.Metadata, err = parseMetadata(data); err != nil {
+		return nil, err
+	}
+
+	matrix, cfg, derivationContext, err := b.matrixForRequest(ctx, req, role, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	versionWarnings, err := b.keyVersionWarnings(ctx, req.Storage, cfg, data.Get("key_version").(int))
+	if err != nil {
+		return nil, err
+	}
+	if store.KeyID, err = contextKeyID(cfg, derivationContext); err != nil {
+		return nil, err
+	}
+	store.KeyVersion = cfg.version()
+	scheme := newSchemeParams(cfg, store.KeyID)
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationBatch, len(rawItems))
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector batch encryption request",
+		"dimension", cfg.Dimension,
+		"batch_size", len(rawItems),
+		"client_id", req.ClientToken)
+
+	// The vectors are encrypted on up to parallelism goroutines, each
+	// reusing one pooled buffer for its plaintexts. Storing and the results
+	// follow in input order.
+	workers := settings.workers()
+	if workers > len(rawItems) {
+		workers = len(rawItems)
+	}
+	vectorBufPtrs := make([]*[]float64, workers)
+	for w := range vectorBufPtrs {
+		vectorBufPtrs[w] = b.borrowFloats()
+		defer b.returnFloats(vectorBufPtrs[w])
+	}
+	encrypted := make([]*encryptResult, len(rawItems))
+	errs := make([]error, len(rawItems))
+	parallelItems(len(rawItems), workers, func(w, i int) {
+		raw := rawItems[i]
+		if settings.strict() {
+			if errs[i] = checkStrictVectorInput(raw); errs[i] != nil {
+				return
+			}
+		}
+		vector, err := parseVectorInto((*vectorBufPtrs[w])[:0], raw)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		b.adoptFloats(vectorBufPtrs[w], vector)
+		encrypted[i], errs[i] = b.encryptVector(matrix, cfg, settings, vector)
+	})
+
+	results := make([]batchItemResult, len(rawItems))
+	for i, result := range encrypted {
+		if itemIDs != nil {
+			results[i].ID = itemIDs[i]
+		}
+		if errs[i] != nil {
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		roundToPrecision(result.Ciphertext, precision)
+		if ids != nil {
+			if err := b.storeCiphertext(ctx, req.Storage, ids[i], result.Ciphertext, store); err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+		}
+		results[i].Ciphertext = result.Ciphertext
+		results[i].KeyVersion = cfg.version()
+		results[i].ClippedComponents = result.Clipped
+		results[i].OutlierComponents = result.Outliers.Components
+		results[i].InputScale = result.Outliers.Scale
+		warnings := result.warnings(settings)
+		results[i].Warnings = warningMessages(warnings)
+		results[i].StructuredWarnings = warnings
+	}
+
+	// Canaries follow the input-aligned results so indices stay stable.
+	draws, err := canaryDraws(settings.CanaryRate, len(rawItems))
+	if err != nil {
+		return nil, err
+	}
+	canaries, err := encryptCanaries(matrix, cfg, settings, draws)
+	if err != nil {
+		return nil, err
+	}
+	for _, ciphertext := range canaries {
+		// Canaries must be indistinguishable from real results.
+		roundToPrecision(ciphertext, precision)
+		results = append(results, batchItemResult{Ciphertext: ciphertext, Canary: true, KeyVersion: cfg.version()})
+	}
+
+	if encoding == encodingBase64 {
+		for i := range results {
+			if results[i].Ciphertext != nil {
+				results[i].Packed = packCiphertext(results[i].Ciphertext, precision)
+			}
+		}
+	}
+
+	// Canaries are filtered like real results, which they must resemble.
+	for i := range results {
+		if format == formatNDJSON {
+			results[i].Metadata = store.Metadata
+			scheme.setItem(&results[i])
+		}
+		role.filterBatchItem(&results[i])
+	}
+	if format == formatNDJSON {
+		return ndjsonResponse(results)
+	}
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"batch_results": results,
+		},
+	}
+	scheme.addTo(resp.Data)
+	addRequestContext(resp.Data, store.Context, store.KeyID)
+	if store.Metadata != nil {
+		resp.Data["metadata"] = store.Metadata
+	}
+	addWarnings(resp, versionWarnings)
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// batchStoreIDs returns the validated 'ids' of a batch of n vectors, or nil
+// if the batch is not stored.
+func batchStoreIDs(data *framework.FieldData, role *vectorRole, n int) ([]string, error) {
+	raw, ok := data.GetOk("ids")
+	if !ok {
+		return nil, nil
+	}
+	if err := role.checkOperation(operationStore); err != nil {
+		return nil, err
+	}
+	ids := raw.([]string)
+	if len(ids) != n {
+		return nil, fmt.Errorf("got %d ids for %d vectors", len(ids), n)
+	}
+	seen := make(map[string]bool, n)
+	for _, id := range ids {
+		if err := checkCiphertextID(id); err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("id %q appears more than once", id)
+		}
+		seen[id] = true
+	}
+	return ids, nil
+}
+
+// batchInput returns the raw batch items from exactly one of 'vectors',
+// 'items' or 'ndjson', and for 'items' the caller's ID of each.
+func batchInput(data *framework.FieldData) ([]interface{}, []string, error) {
+	rawVectors, hasVectors := data.GetOk("vectors")
+	rawItems, hasItems := data.GetOk("items")
+	rawNDJSON, hasNDJSON := data.GetOk("ndjson")
+	supplied := 0
+	for _, has := range []bool{hasVectors, hasItems, hasNDJSON} {
+		if has {
+			supplied++
+		}
+	}
+	if supplied > 1 {
+		return nil, nil, fmt.Errorf("only one of 'vectors', 'items' or 'ndjson' may be supplied")
+	}
+	switch {
+	case hasNDJSON:
+		vectors, err := parseNDJSONVectors(rawNDJSON.(string))
+		return vectors, nil, err
+	case hasItems:
+		items, err := unwrapJSONList("items", rawItems.([]interface{}))
+		if err != nil {
+			return nil, nil, err
+		}
+		return parseBatchItems(items)
+	case hasVectors:
+		vectors, err := unwrapJSONList("vectors", rawVectors.([]interface{}))
+		return vectors, nil, err
+	default:
+		return nil, nil, fmt.Errorf("one of 'vectors', 'items' or 'ndjson' is required")
+	}
+}
+
+// unwrapJSONList decodes a list supplied as a single JSON string (Vault CLI
+// behavior); any other list is returned as is.
+func unwrapJSONList(field string, list []interface{}) ([]interface{}, error) {
+	if len(list) != 1 {
+		return list, nil
+	}
+	str, ok := list[0].(string)
+	if !ok {
+		return list, nil
+	}
+	if len(str) > maxBatchJSONBytes {
+		return nil, fmt.Errorf("%s input is %d bytes, exceeding maximum %d", field, len(str), maxBatchJSONBytes)
+	}
+	var parsed []interface{}
+	if err := json.Unmarshal([]byte(str), &parsed); err != nil {
+		return nil, fmt.Errorf("%s must be a JSON array: %w", field, err)
+	}
+	return parsed, nil
+}
+
+// parseBatchItems splits 'items' entries, {"id": ..., "vector": [...]},
+// into their raw vectors and IDs. IDs must be non-empty and unique, so
+// that every result maps back to exactly one input.
+func parseBatchItems(items []interface{}) ([]interface{}, []string, error) {
+	vectors := make([]interface{}, len(items))
+	ids := make([]string, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("items[%d]: expected an object with 'id' and 'vector'", i)
+		}
+		id, _ := item["id"].(string)
+		if id == "" {
+			return nil, nil, fmt.Errorf("items[%d]: 'id' must be a non-empty string", i)
+		}
+		if len(id) > maxItemIDLength {
+			return nil, nil, fmt.Errorf("items[%d]: id exceeds %d bytes", i, maxItemIDLength)
+		}
+		if seen[id] {
+			return nil, nil, fmt.Errorf("items[%d]: id %q appears more than once", i, id)
+		}
+		seen[id] = true
+		vector, ok := item["vector"]
+		if !ok {
+			return nil, nil, fmt.Errorf("items[%d]: object has no 'vector' field", i)
+		}
+		vectors[i], ids[i] = vector, id
+	}
+	return vectors, ids, nil
+}
+
+// parseNDJSONVectors splits an NDJSON body into raw vectors.
+// Each non-blank line is either a JSON array of numbers or an object with a
+// "vector" field. Lines are decoded one at a time so a malformed line reports
+// its line number.
+func parseNDJSONVectors(body string) ([]interface{}, error) {
+	return parseNDJSONVectorsLimit(body, maxBatchSize)
+}
+
+// parseNDJSONVectorsLimit is parseNDJSONVectors with a caller-chosen bound
+// on the number of vectors.
+func parseNDJSONVectorsLimit(body string, maxVectors int) ([]interface{}, error) {
+	if len(body) > maxBatchJSONBytes {
+		return nil, fmt.Errorf("ndjson input is %d bytes, exceeding maximum %d", len(body), maxBatchJSONBytes)
+	}
+	var items []interface{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var decoded interface{}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		if err := dec.Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("ndjson line %d: %w", line, err)
+		}
+
+		switch v := decoded.(type) {
+		case []interface{}:
+			items = append(items, v)
+		case map[string]interface{}:
+			vec, ok := v["vector"]
+			if !ok {
+				return nil, fmt.Errorf("ndjson line %d: object has no 'vector' field", line)
+			}
+			items = append(items, vec)
+		default:
+			return nil, fmt.Errorf("ndjson line %d: expected array or object", line)
+		}
+
+		if len(items) > maxVectors {
+			return nil, fmt.Errorf("batch exceeds maximum %d vectors", maxVectors)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ndjson: %w", err)
+	}
+	return items, nil
+}
+
+// ndjsonResponse renders batch results as a raw application/x-ndjson body.
+func ndjsonResponse(results []batchItemResult) (*logical.Response, error) {
+	body, err := encodeNDJSON(results)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentTypeNDJSON,
+			logical.HTTPRawBody:     body,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// encodeNDJSON encodes batch results one per line.
+func encodeNDJSON(results []batchItemResult) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range results {
+		if err := enc.Encode(&results[i]); err != nil {
+			return nil, fmt.Errorf("encode ndjson result %d: %w", i, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Help text constants for the batch path.
+const pathBatchHelpSyn = `Encrypt a batch of vector embeddings using Distance-Preserving Encryption.`
+
+const pathBatchHelpDesc = `
+This endpoint encrypts up to 1024 vectors in one request using the same key
+and parameters as encrypt/vector. Each vector receives independent noise.
+
+Input (exactly one of):
+  vectors - Array of float arrays
+  items   - Array of {"id": ..., "vector": [...]} objects; each result
+            carries the item's 'id', so results need not be matched to
+            inputs by position. IDs must be unique.
+  ndjson  - Newline-delimited vectors: one JSON array, or one object with a
+            "vector" field, per line
+
+Output:
+  format=json   - batch_results: one entry per input vector, in order, with
+                  either 'ciphertext' or 'error'
+  format=ndjson - Raw application/x-ndjson body with one result object per
+                  line, in input order
+
+  With 'ids' (one per input vector), each successful ciphertext is also
+  stored at ciphertext/<id>.
+
+  With 'context', every ciphertext is bound to the context's key as in
+  encrypt/vector; the response records the context and its key_id.
+
+  format defaults to the mount's default_format, and precision ('float64'
+  or 'float32') to its output_precision; see config/settings. With
+  encoding=base64 each ciphertext is one base64 string of its packed
+  little-endian components, float32 or float64 per precision.
+
+A failing item does not fail the batch; check each result's 'error'.
+
+When canary_rate is set in config/settings, canary ciphertexts marked
+"canary": true, and without an 'id', are appended after the input-aligned
+results. Store them with the real records; verify/canary detects them in a
+leaked dataset.
+
+Vault core decodes request bodies as JSON before they reach the plugin, so
+NDJSON input is supplied as the 'ndjson' string field rather than as a raw
+request body. NDJSON output is written as a raw response body.
+
+Example:
+  vault write vector/encrypt/batch vectors='[[0.1, 0.2], [0.3, 0.4]]'
+`
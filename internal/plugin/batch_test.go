@@ -0,0 +1,136 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseNDJSONVectors(t *testing.T) {
+	body := "[1, 2, 3]\n\n{\"vector\": [4, 5, 6]}\n  [7.5, 8, 9]  \n"
+
+	items, err := parseNDJSONVectors(body)
+	if err != nil {
+		t.Fatalf("parseNDJSONVectors failed: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+	for i, item := range items {
+		vec, err := parseVector(item)
+		if err != nil {
+			t.Fatalf("item %d: parseVector failed: %v", i, err)
+		}
+		if len(vec) != 3 {
+			t.Errorf("item %d: len = %d, want 3", i, len(vec))
+		}
+	}
+}
+
+func TestParseNDJSONVectorsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"malformed line", "[1, 2]\n[3, \n", "line 2"},
+		{"object without vector", "{\"v\": [1]}\n", "no 'vector' field"},
+		{"scalar line", "42\n", "expected array or object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseNDJSONVectors(tt.body)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONResponse(t *testing.T) {
+	resp, err := ndjsonResponse([]batchItemResult{
+		{Ciphertext: []float64{1, 2}},
+		{Error: "bad vector"},
+	})
+	if err != nil {
+		t.Fatalf("ndjsonResponse failed: %v", err)
+	}
+
+	body := string(resp.Data["http_raw_body"].([]byte))
+	want := "{\"ciphertext\":[1,2]}\n{\"error\":\"bad vector\"}\n"
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+	if resp.Data["http_content_type"] != contentTypeNDJSON {
+		t.Errorf("content type = %v, want %s", resp.Data["http_content_type"], contentTypeNDJSON)
+	}
+}
+
+func TestEncryptBatchItems(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": "doc-1#0", "vector": testVector(1)},
+			map[string]interface{}{"id": "doc-1#1", "vector": []interface{}{1.0}},
+			map[string]interface{}{"id": "doc-2#0", "vector": testVector(2)},
+		},
+	})
+	results := resp.Data["batch_results"].([]batchItemResult)
+	for i, want := range []string{"doc-1#0", "doc-1#1", "doc-2#0"} {
+		if results[i].ID != want {
+			t.Errorf("result %d: id = %q, want %q", i, results[i].ID, want)
+		}
+	}
+	if results[1].Error == "" || results[0].Ciphertext == nil || results[2].Ciphertext == nil {
+		t.Errorf("results = %+v", results)
+	}
+
+	// The CLI form, one JSON string, is accepted too, and the id appears on
+	// NDJSON lines.
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"items":  []interface{}{`[{"id": "a", "vector": [1, 2, 3, 4, 5, 6, 7, 8]}]`},
+		"format": formatNDJSON,
+	})
+	var line map[string]interface{}
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["id"] != "a" || line["ciphertext"] == nil {
+		t.Errorf("ndjson line = %v", line)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"duplicate id": {"items": []interface{}{
+			map[string]interface{}{"id": "a", "vector": testVector(1)},
+			map[string]interface{}{"id": "a", "vector": testVector(2)},
+		}},
+		"missing id":     {"items": []interface{}{map[string]interface{}{"vector": testVector(1)}}},
+		"missing vector": {"items": []interface{}{map[string]interface{}{"id": "a"}}},
+		"not an object":  {"items": []interface{}{testVector(1)}},
+		"with vectors": {
+			"items":   []interface{}{map[string]interface{}{"id": "a", "vector": testVector(1)}},
+			"vectors": []interface{}{testVector(1)},
+		},
+	} {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/batch",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
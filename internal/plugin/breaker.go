@@ -0,0 +1,364 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// Integration names, used in stats/outbound and metric labels.
+	integrationSink = "sink"
+	integrationKV   = "kv"
+
+	// defaultOutboundRetries is the number of retries after a failed
+	// outbound call when config/outbound sets none.
+	defaultOutboundRetries = 2
+
+	// maxOutboundRetries bounds max_retries.
+	maxOutboundRetries = 10
+
+	// retryBaseDelay and retryMaxDelay bound the jittered backoff: retry n
+	// waits a random time up to min(retryMaxDelay, retryBaseDelay * 2^n).
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+
+	// defaultBreakerThreshold is the number of consecutive failed calls
+	// that opens an integration's circuit.
+	defaultBreakerThreshold = 5
+
+	// defaultBreakerCooldown is how long an open circuit rejects calls
+	// before letting a trial call through.
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// outboundMetricPrefix is the metric name prefix for outbound calls.
+var outboundMetricPrefix = []string{"vector_dpe", "outbound"}
+
+// Circuit breaker states.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned, wrapped, for calls rejected by an open circuit.
+var errCircuitOpen = errors.New("circuit open")
+
+// outboundStatusError is a non-2xx response from an outbound call.
+type outboundStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *outboundStatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// retryableStatus reports whether a call answered with code may succeed if
+// repeated: server errors, timeouts and rate limiting.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// retryableError reports whether a failed outbound call may succeed if
+// repeated. Other failures mean the integration is up but refused the
+// call, and neither retry nor count toward opening the circuit.
+func retryableError(err error) bool {
+	var status *outboundStatusError
+	var apiErr *api.ResponseError
+	switch {
+	case errors.As(err, &status):
+		return retryableStatus(status.StatusCode)
+	case errors.As(err, &apiErr):
+		return retryableStatus(apiErr.StatusCode)
+	case errors.Is(err, api.ErrSecretNotFound):
+		return false
+	}
+	// Connection errors and timeouts.
+	return true
+}
+
+// integrationStats holds the counters and circuit state of one integration
+// on this node.
+type integrationStats struct {
+	mu sync.Mutex
+
+	calls, failures, retries, rejected uint64
+	latency                            time.Duration
+
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	trialInFlight       bool
+}
+
+// responseData renders the integration for stats/outbound.
+func (s *integrationStats) responseData() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meanLatency := 0.0
+	if s.calls > 0 {
+		meanLatency = float64(s.latency.Milliseconds()) / float64(s.calls)
+	}
+	data := map[string]interface{}{
+		"calls":                s.calls,
+		"failures":             s.failures,
+		"retries":              s.retries,
+		"rejected":             s.rejected,
+		"mean_latency_ms":      meanLatency,
+		"state":                s.state,
+		"consecutive_failures": s.consecutiveFailures,
+		"opened_at":            "",
+	}
+	if !s.openedAt.IsZero() {
+		data["opened_at"] = s.openedAt.Format(time.RFC3339)
+	}
+	return data
+}
+
+// admit decides whether a call may proceed under the circuit. An open
+// circuit lets a single trial call through once the cooldown has passed;
+// trial reports whether this is that call.
+func (s *integrationStats) admit(now time.Time, cooldown time.Duration) (trial bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state {
+	case circuitOpen:
+		if now.Sub(s.openedAt) < cooldown {
+			s.rejected++
+			return false, fmt.Errorf("%w after %d consecutive failures; next attempt after %s",
+				errCircuitOpen, s.consecutiveFailures, s.openedAt.Add(cooldown).Format(time.RFC3339))
+		}
+		s.state = circuitHalfOpen
+		s.trialInFlight = true
+		return true, nil
+	case circuitHalfOpen:
+		if s.trialInFlight {
+			s.rejected++
+			return false, fmt.Errorf("%w; a trial call is in progress", errCircuitOpen)
+		}
+		s.trialInFlight = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record accounts for one attempt. failed reports an integration failure
+// (a retryable error); threshold 0 never opens the circuit. It returns
+// whether this attempt opened the circuit.
+func (s *integrationStats) record(now time.Time, latency time.Duration, failed, retry bool, threshold int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.latency += latency
+	if retry {
+		s.retries++
+	}
+	if !failed {
+		s.consecutiveFailures = 0
+		s.state = circuitClosed
+		s.openedAt = time.Time{}
+		return false
+	}
+	s.failures++
+	s.consecutiveFailures++
+	// A failed trial reopens the circuit for another cooldown.
+	if s.state == circuitHalfOpen || (threshold > 0 && s.state == circuitClosed && s.consecutiveFailures >= threshold) {
+		s.state = circuitOpen
+		s.openedAt = now
+		return true
+	}
+	return false
+}
+
+// tripped reports whether the circuit is not closed.
+func (s *integrationStats) tripped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state != circuitClosed
+}
+
+// endTrial lets the next trial call through after one finished.
+func (s *integrationStats) endTrial() {
+	s.mu.Lock()
+	s.trialInFlight = false
+	s.mu.Unlock()
+}
+
+// integration returns the stats of the named integration, creating them on
+// first use.
+func (b *vectorBackend) integration(name string) *integrationStats {
+	b.integrationsLock.Lock()
+	defer b.integrationsLock.Unlock()
+	if b.integrations == nil {
+		b.integrations = make(map[string]*integrationStats)
+	}
+	s, ok := b.integrations[name]
+	if !ok {
+		s = &integrationStats{state: circuitClosed}
+		b.integrations[name] = s
+	}
+	return s
+}
+
+// callOutbound runs fn, a call to the named integration, under the retry
+// policy and circuit breaker of config/outbound. Failures that may be
+// transient are retried with full jitter; once the circuit opens, calls
+// fail immediately instead of tying up request workers on a slow or
+// unreachable service.
+func (b *vectorBackend) callOutbound(ctx context.Context, storage logical.Storage, name string, fn func(context.Context) error) error {
+	policy, err := b.getOutboundConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	stats := b.integration(name)
+	labels := []metrics.Label{{Name: "integration", Value: name}}
+
+	trial, err := stats.admit(time.Now(), policy.breakerCooldown())
+	if err != nil {
+		metrics.IncrCounterWithLabels(append(outboundMetricPrefix, "rejected"), 1, labels)
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if trial {
+		defer stats.endTrial()
+	}
+
+	retries := policy.maxRetries()
+	if trial {
+		// A trial call probes the integration once.
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err = fn(ctx)
+		metrics.MeasureSinceWithLabels(append(outboundMetricPrefix, "latency"), start, labels)
+		metrics.IncrCounterWithLabels(append(outboundMetricPrefix, "call"), 1, labels)
+
+		failed := err != nil && retryableError(err) && ctx.Err() == nil
+		if stats.record(time.Now(), time.Since(start), failed, attempt > 0, policy.breakerThreshold()) {
+			b.Logger().Warn("outbound circuit opened", "integration", name, "error", err)
+			b.emitEvent(ctx, "circuit-open", "integration", name)
+		}
+		if failed {
+			metrics.IncrCounterWithLabels(append(outboundMetricPrefix, "failure"), 1, labels)
+		}
+		if !failed || attempt >= retries {
+			return err
+		}
+
+		backoff := retryBaseDelay << attempt
+		if backoff > retryMaxDelay {
+			backoff = retryMaxDelay
+		}
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		// Another call may have opened the circuit during the backoff.
+		if stats.tripped() {
+			return err
+		}
+		metrics.IncrCounterWithLabels(append(outboundMetricPrefix, "retry"), 1, labels)
+	}
+}
+
+// pathOutboundStats returns the path configuration for stats/outbound.
+func (b *vectorBackend) pathOutboundStats() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "stats/outbound",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleOutboundStatsRead,
+					Summary:  "Report outbound call counts, latency and circuit state per integration.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleOutboundStatsReset,
+					Summary:  "Reset the outbound counters and close every circuit.",
+				},
+			},
+			HelpSynopsis:    pathOutboundStatsHelpSyn,
+			HelpDescription: pathOutboundStatsHelpDesc,
+		},
+	}
+}
+
+// handleOutboundStatsRead reports every integration called on this node.
+func (b *vectorBackend) handleOutboundStatsRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.integrationsLock.Lock()
+	names := make([]string, 0, len(b.integrations))
+	for name := range b.integrations {
+		names = append(names, name)
+	}
+	b.integrationsLock.Unlock()
+	sort.Strings(names)
+
+	integrations := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		integrations[name] = b.integration(name).responseData()
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"integrations": integrations,
+		},
+	}, nil
+}
+
+// handleOutboundStatsReset drops the counters and circuit states.
+func (b *vectorBackend) handleOutboundStatsReset(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.integrationsLock.Lock()
+	b.integrations = nil
+	b.integrationsLock.Unlock()
+	return nil, nil
+}
+
+// Help text constants for the outbound statistics path.
+const pathOutboundStatsHelpSyn = `Report outbound call counts, latency and circuit state for this node.`
+
+const pathOutboundStatsHelpDesc = `
+Every outbound call (to the webhook sink, or to the Vault API for
+vector_ref) runs under a retry policy and a circuit breaker per
+integration, configured in config/outbound:
+
+  - A call failing with a connection error, a timeout, or a 5xx, 408 or
+    429 status is retried up to max_retries times (default: 2), after a
+    random wait of up to 100ms, 200ms, 400ms, ... (at most 5s). Other
+    errors mean the service is up and refused the call; they are returned
+    at once.
+  - After breaker_threshold consecutive failures (default: 5) the circuit
+    opens, and calls fail immediately with "circuit open" instead of
+    waiting on the service. After breaker_cooldown (default: 30s) a single
+    trial call goes through: success closes the circuit, failure reopens
+    it.
+
+Output, per integration (sink, kv) called since the last reset:
+  calls, failures, retries - Attempts, failed attempts, and retries
+  rejected                 - Calls refused by the open circuit
+  mean_latency_ms          - Mean attempt latency
+  state                    - closed, open or half-open
+  consecutive_failures     - Failures since the last success
+  opened_at                - When the circuit last opened
+
+The same counters are emitted to Vault's telemetry as
+vector_dpe.outbound.{call,failure,retry,rejected} and the latency as
+vector_dpe.outbound.latency, labelled by integration, and opening a circuit
+emits a vector-dpe/circuit-open event. Counters and circuits are per node;
+DELETE resets them and closes every circuit.
+`
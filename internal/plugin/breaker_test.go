@@ -0,0 +1,207 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// flakyHandler fails the next failures requests with status before
+// passing requests on to next.
+type flakyHandler struct {
+	mu       sync.Mutex
+	next     http.Handler
+	failures int
+	status   int
+	hits     int
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.hits++
+	fail := h.failures > 0
+	if fail {
+		h.failures--
+	}
+	h.mu.Unlock()
+	if fail {
+		http.Error(w, "flaky", h.status)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// setFailures makes the next n requests fail with status.
+func (h *flakyHandler) setFailures(n, status int) {
+	h.mu.Lock()
+	h.failures, h.status, h.hits = n, status, 0
+	h.mu.Unlock()
+}
+
+// newFlakySinkBackend returns a backend whose webhook sink is served
+// through a flakyHandler.
+func newFlakySinkBackend(t *testing.T) (*vectorBackend, logical.Storage, *flakyHandler) {
+	t.Helper()
+	flaky := &flakyHandler{next: &fakeSinkAdapter{records: map[string]sinkRecord{}}}
+	server := httptest.NewServer(flaky)
+	t.Cleanup(server.Close)
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"url":   server.URL + "/vectors",
+		"token": "adapter-token",
+	})
+	return b, s, flaky
+}
+
+// storeThroughSink encrypts and stores one vector, returning any error.
+func storeThroughSink(b *vectorBackend, s logical.Storage, id string) error {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector": testVector(0), "id": id},
+		Storage:   s,
+	})
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+	return err
+}
+
+func TestOutboundRetries(t *testing.T) {
+	b, s, flaky := newFlakySinkBackend(t)
+
+	// Transient failures are retried.
+	flaky.setFailures(2, http.StatusServiceUnavailable)
+	if err := storeThroughSink(b, s, "doc-1"); err != nil {
+		t.Fatalf("store after two transient failures: %v", err)
+	}
+	stats := testRequest(t, b, s, logical.ReadOperation, "stats/outbound", nil).Data["integrations"].(map[string]interface{})
+	sink := stats[integrationSink].(map[string]interface{})
+	if sink["calls"] != uint64(3) || sink["failures"] != uint64(2) || sink["retries"] != uint64(2) || sink["state"] != circuitClosed {
+		t.Errorf("sink stats = %v, want 3 calls, 2 failures and 2 retries", sink)
+	}
+
+	// Refusals are not.
+	flaky.setFailures(1, http.StatusBadRequest)
+	if err := storeThroughSink(b, s, "doc-2"); err == nil {
+		t.Fatal("store succeeded despite a refusal")
+	}
+	if flaky.hits != 1 {
+		t.Errorf("refused call made %d requests, want 1", flaky.hits)
+	}
+
+	// More failures than retries fail the call.
+	flaky.setFailures(3, http.StatusBadGateway)
+	if err := storeThroughSink(b, s, "doc-3"); err == nil {
+		t.Fatal("store succeeded despite three failures")
+	}
+	if flaky.hits != 3 {
+		t.Errorf("failing call made %d requests, want 3", flaky.hits)
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, "stats/outbound", nil)
+	stats = testRequest(t, b, s, logical.ReadOperation, "stats/outbound", nil).Data["integrations"].(map[string]interface{})
+	if len(stats) != 0 {
+		t.Errorf("stats after reset = %v, want none", stats)
+	}
+}
+
+func TestOutboundCircuitBreaker(t *testing.T) {
+	b, s, flaky := newFlakySinkBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/outbound", map[string]interface{}{
+		"max_retries":       0,
+		"breaker_threshold": 2,
+		"breaker_cooldown":  "1m",
+	})
+
+	flaky.setFailures(100, http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		if err := storeThroughSink(b, s, "doc"); err == nil {
+			t.Fatal("store succeeded while the adapter failed")
+		}
+	}
+	err := storeThroughSink(b, s, "doc")
+	if err == nil || flaky.hits != 2 {
+		t.Fatalf("open circuit: err = %v after %d requests, want a rejection after 2", err, flaky.hits)
+	}
+	sink := b.integration(integrationSink).responseData()
+	if sink["state"] != circuitOpen || sink["rejected"] != uint64(1) {
+		t.Errorf("sink stats = %v, want an open circuit with 1 rejection", sink)
+	}
+
+	// Once the cooldown passes, a successful trial call closes the circuit.
+	stats := b.integration(integrationSink)
+	stats.mu.Lock()
+	stats.openedAt = stats.openedAt.Add(-2 * time.Minute)
+	stats.mu.Unlock()
+	flaky.setFailures(0, 0)
+	if err := storeThroughSink(b, s, "doc"); err != nil {
+		t.Fatalf("trial call: %v", err)
+	}
+	if sink := stats.responseData(); sink["state"] != circuitClosed || sink["consecutive_failures"] != 0 {
+		t.Errorf("sink stats = %v, want a closed circuit", sink)
+	}
+}
+
+func TestCircuitTrialFailureReopens(t *testing.T) {
+	s := &integrationStats{state: circuitClosed}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.record(now, 0, true, false, 3)
+	}
+	if _, err := s.admit(now, time.Minute); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("admit = %v, want the circuit open", err)
+	}
+
+	later := now.Add(2 * time.Minute)
+	trial, err := s.admit(later, time.Minute)
+	if !trial || err != nil {
+		t.Fatalf("admit after cooldown = %v, %v; want a trial", trial, err)
+	}
+	if _, err := s.admit(later, time.Minute); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("second caller during the trial = %v, want rejected", err)
+	}
+	if !s.record(later, 0, true, false, 3) {
+		t.Error("failed trial did not reopen the circuit")
+	}
+	s.endTrial()
+	if _, err := s.admit(later.Add(time.Second), time.Minute); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("admit after a failed trial = %v, want a fresh cooldown", err)
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{&outboundStatusError{StatusCode: 503}, true},
+		{fmt.Errorf("sink upsert: %w", &outboundStatusError{StatusCode: 429}), true},
+		{&outboundStatusError{StatusCode: 408}, true},
+		{&outboundStatusError{StatusCode: 400}, false},
+		{&outboundStatusError{StatusCode: 403}, false},
+		{&api.ResponseError{StatusCode: 500}, true},
+		{&api.ResponseError{StatusCode: 404}, false},
+		{fmt.Errorf("read: %w", api.ErrSecretNotFound), false},
+	} {
+		if got := retryableError(tc.err); got != tc.want {
+			t.Errorf("retryableError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
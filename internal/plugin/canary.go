@@ -0,0 +1,275 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	mathrand "math/rand/v2"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// canaryLabel separates canary plaintexts from other uses of the mount seed.
+	canaryLabel = "vector-dpe/canary/v1"
+
+	// canaryCount is the number of distinct canaries per key.
+	canaryCount = 1024
+
+	// canaryJitterFraction scales the per-emission jitter relative to the
+	// configured noise radius, so repeated canaries are not bit-identical.
+	canaryJitterFraction = 0.01
+
+	// canaryTolerance absorbs float32 storage rounding, relative to the
+	// scaling factor.
+	canaryTolerance = 1e-3
+)
+
+// canaryCentres returns the key's canaries before rotation: s·p + μ, where p
+// is a pseudorandom unit vector and μ a pseudorandom sample from the noise
+// ball, both derived from the seed. A canary ciphertext Q·(s·p + μ) is
+// distributed like the encryption of a normalized embedding, so canaries
+// cannot be told apart from real ciphertexts without the key.
+func canaryCentres(seed []byte, cfg *rotationConfig) ([][]float64, error) {
+	key := deriveSeedKey(seed, canaryLabel)
+	defer zeroBytes(key)
+
+	var chachaSeed [32]byte
+	copy(chachaSeed[:], key)
+	rng := mathrand.New(mathrand.NewChaCha8(chachaSeed))
+	zeroBytes(chachaSeed[:])
+
+	dim := cfg.Dimension
+	noise := make([]float64, dim)
+	centres := make([][]float64, canaryCount)
+	for k := range centres {
+		p := make([]float64, dim)
+		var normSq float64
+		for i := range p {
+			p[i] = rng.NormFloat64()
+			normSq += p[i] * p[i]
+		}
+		norm := math.Sqrt(normSq)
+
+		noise, err := GenerateBallNoise(rng, noise, dim, cfg.noiseRadius())
+		if err != nil {
+			return nil, err
+		}
+		for i := range p {
+			p[i] = cfg.ScalingFactor*p[i]/norm + noise[i]
+		}
+		centres[k] = p
+	}
+	return centres, nil
+}
+
+// canaryJitter is the radius of the fresh noise added to each canary.
+func (c *rotationConfig) canaryJitter() float64 {
+	return c.noiseRadius() * canaryJitterFraction
+}
+
+// canaryMatchDistance is the largest distance from a canary at which a
+// ciphertext is reported as that canary. A real ciphertext would need its
+// plaintext and noise to land within this distance of a secret point, which
+// is negligibly unlikely.
+func (c *rotationConfig) canaryMatchDistance() float64 {
+	return c.canaryJitter() + canaryTolerance*c.ScalingFactor
+}
+
+// encryptCanaries produces n canary ciphertexts under matrix. Canaries that
+// would violate the mount's output range are dropped rather than clipped, so
+// a canary is never altered after encryption.
+func encryptCanaries(matrix *mat.Dense, cfg *rotationConfig, settings *mountSettings, n int) ([][]float64, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	centres, err := canaryCentres(seed, cfg)
+	zeroBytes(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	rng, err := NewSecureRNG()
+	if err != nil {
+		return nil, err
+	}
+
+	var canaries [][]float64
+	for range n {
+		centre := centres[rng.IntN(canaryCount)]
+		jitter, err := GenerateBallNoise(rng, nil, cfg.Dimension, cfg.canaryJitter())
+		if err != nil {
+			return nil, err
+		}
+		rotated := mat.NewVecDense(cfg.Dimension, nil)
+		rotated.MulVec(matrix, mat.NewVecDense(cfg.Dimension, centre))
+
+		ciphertext := make([]float64, cfg.Dimension)
+		inRange := true
+		for i := range ciphertext {
+			ciphertext[i] = rotated.AtVec(i) + jitter[i]
+			if settings.MaxAbsOutput > 0 && math.Abs(ciphertext[i]) > settings.MaxAbsOutput {
+				inRange = false
+			}
+		}
+		if inRange {
+			canaries = append(canaries, ciphertext)
+		}
+	}
+	return canaries, nil
+}
+
+// canaryDraws returns how many canaries to add to a batch of n items: each
+// item independently adds one with probability rate.
+func canaryDraws(rate float64, n int) (int, error) {
+	if rate <= 0 {
+		return 0, nil
+	}
+	rng, err := NewSecureRNG()
+	if err != nil {
+		return 0, err
+	}
+	draws := 0
+	for range n {
+		if rng.Float64() < rate {
+			draws++
+		}
+	}
+	return draws, nil
+}
+
+// pathCanary returns the path configuration for verify/canary.
+func (b *vectorBackend) pathCanary() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("verify/canary"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"vectors": {
+					Type:        framework.TypeSlice,
+					Description: fmt.Sprintf("Ciphertext vectors to test (array of float arrays, at most %d).", maxBatchSize),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleVerifyCanary,
+					Summary:  "Test whether ciphertexts contain canaries produced by the key.",
+				},
+			},
+			HelpSynopsis:    pathCanaryHelpSyn,
+			HelpDescription: pathCanaryHelpDesc,
+		},
+	}
+}
+
+// handleVerifyCanary reports which of the supplied ciphertexts are canaries
+// of the key. Each vector is rotated back with Qᵀ, which preserves distances,
+// and compared with every canary centre.
+func (b *vectorBackend) handleVerifyCanary(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := parseVectorList(data.Get("vectors"), maxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("at least one vector is required")
+	}
+
+	// Expired keys may still be checked; disabled ones may not.
+	if err := b.checkKeyEnabled(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	matrix, cfg, err := b.roleMatrix(ctx, req, role)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	centres, err := canaryCentres(seed, cfg)
+	zeroBytes(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	maxDistSq := cfg.canaryMatchDistance() * cfg.canaryMatchDistance()
+	unrotated := mat.NewVecDense(cfg.Dimension, nil)
+	matched := []int{}
+	for i, v := range vectors {
+		if len(v) != cfg.Dimension {
+			return nil, fmt.Errorf("vector %d: dimension %d does not match configured dimension %d", i, len(v), cfg.Dimension)
+		}
+		unrotated.MulVec(matrix.T(), mat.NewVecDense(cfg.Dimension, v))
+		y := unrotated.RawVector().Data
+		for _, centre := range centres {
+			var distSq float64
+			for j := range centre {
+				d := y[j] - centre[j]
+				distSq += d * d
+				if distSq > maxDistSq {
+					break
+				}
+			}
+			if distSq <= maxDistSq {
+				matched = append(matched, i)
+				break
+			}
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"checked":         len(vectors),
+			"matches":         len(matched),
+			"matched_indices": matched,
+			"produced_by_key": len(matched) > 0,
+		},
+	}, nil
+}
+
+// Help text constants for the canary path.
+const pathCanaryHelpSyn = `Test whether a set of ciphertexts contains the key's canaries.`
+
+const pathCanaryHelpDesc = `
+When canary_rate is set in config/settings, encrypt/batch appends canary
+ciphertexts to its output at that rate, marked "canary": true. Store them
+alongside real records. Canaries are encryptions of pseudorandom unit
+vectors with pseudorandom noise, all derived from the key: without the key
+they cannot be told apart from real ciphertexts, and they never match real
+data.
+
+This endpoint tests a (possibly leaked) dataset for those canaries. Each
+vector is compared against the key's canaries; the chance of a real
+ciphertext matching is negligible. A single match is strong evidence that
+the dataset was produced under this key.
+
+Input:
+  vectors - Up to 1024 ciphertext vectors; submit larger datasets in chunks
+
+Output:
+  checked         - Number of vectors tested
+  matches         - Number of canaries found
+  matched_indices - Input positions of the canaries
+  produced_by_key - True if any canary was found
+
+For a role with a derivation context, append the role name to test against
+the role's key. Expired keys can still be tested; disabled keys cannot.
+
+Example:
+  vault write vector/verify/canary vectors=@leaked.json
+`
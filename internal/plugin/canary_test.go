@@ -0,0 +1,87 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackendCanaries(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"canary_rate": 1.0,
+	})
+
+	inputs := []interface{}{testVector(0), testVector(1), testVector(2)}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": inputs,
+	})
+	results := resp.Data["batch_results"].([]batchItemResult)
+	if len(results) != 2*len(inputs) {
+		t.Fatalf("got %d results, want %d inputs plus %d canaries", len(results), len(inputs), len(inputs))
+	}
+
+	var dataset []interface{}
+	var wantIndices []int
+	for i, r := range results {
+		if r.Canary != (i >= len(inputs)) {
+			t.Errorf("result %d: canary = %v", i, r.Canary)
+		}
+		if r.Canary {
+			wantIndices = append(wantIndices, i)
+		}
+		dataset = append(dataset, float32Round(r.Ciphertext))
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "verify/canary", map[string]interface{}{
+		"vectors": dataset,
+	})
+	if resp.Data["produced_by_key"] != true {
+		t.Fatal("canaries not detected")
+	}
+	if got := resp.Data["matched_indices"].([]int); !slices.Equal(got, wantIndices) {
+		t.Errorf("matched_indices = %v, want %v", got, wantIndices)
+	}
+
+	// A different key does not recognise the dataset.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "verify/canary", map[string]interface{}{
+		"vectors": dataset,
+	})
+	if resp.Data["produced_by_key"] != false {
+		t.Errorf("rotated key matched %v", resp.Data["matched_indices"])
+	}
+}
+
+func TestBackendCanariesDisabledByDefault(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), testVector(1)},
+	})
+	if n := len(resp.Data["batch_results"].([]batchItemResult)); n != 2 {
+		t.Errorf("got %d results, want 2", n)
+	}
+}
+
+// float32Round simulates storing ciphertexts in a float32 vector store.
+func float32Round(v []float64) []interface{} {
+	out := make([]interface{}, len(v))
+	for i, x := range v {
+		out[i] = float64(float32(x))
+	}
+	return out
+}
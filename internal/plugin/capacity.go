@@ -0,0 +1,204 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// matrixBytes is the memory used by the backing data of m.
+func matrixBytes(m *mat.Dense) int64 {
+	r, c := m.Dims()
+	return int64(r) * int64(c) * 8
+}
+
+// maxDimensionWithin returns the largest dimension whose matrix fits in
+// the given number of bytes, capped at limit.
+func maxDimensionWithin(bytes int64, limit int) int {
+	if bytes <= 0 {
+		return 0
+	}
+	d := int(math.Sqrt(float64(bytes / 8)))
+	// Correct for floating-point rounding at perfect squares.
+	for int64(d+1)*int64(d+1)*8 <= bytes {
+		d++
+	}
+	for d > 0 && int64(d)*int64(d)*8 > bytes {
+		d--
+	}
+	return min(d, limit)
+}
+
+// pathCapacity returns the path configuration for capacity.
+func (b *vectorBackend) pathCapacity() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "capacity",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleCapacityRead,
+					Summary:  "Report matrix memory use and headroom on this node.",
+				},
+			},
+			HelpSynopsis:    pathCapacityHelpSyn,
+			HelpDescription: pathCapacityHelpDesc,
+		},
+	}
+}
+
+// handleCapacityRead reports the memory held by this node's cached
+// matrices and how much more the mount's memory budget admits.
+func (b *vectorBackend) handleCapacityRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy what is needed under the lock; key IDs are computed after.
+	b.matrixLock.RLock()
+	cfg := b.cachedConfig
+	var mountBytes int64
+	mountDimension := 0
+	if b.cachedMatrix != nil {
+		mountBytes = matrixBytes(b.cachedMatrix)
+		mountDimension, _ = b.cachedMatrix.Dims()
+	}
+	derived := make(map[string]*mat.Dense)
+	var versions []*lruEntry
+	for key, cached := range b.lru.entries {
+		if derivationContext, ok := strings.CutPrefix(key, derivedFlightKey("")); ok {
+			derived[derivationContext] = cached.matrix
+		} else {
+			versions = append(versions, cached)
+		}
+	}
+	b.matrixLock.RUnlock()
+
+	// Retired matrices are out of the cache but still held by requests.
+	var retired int
+	var retiredBytes int64
+	b.refLock.Lock()
+	for m, ref := range b.matrixRefs {
+		if ref.retired {
+			retired++
+			retiredBytes += matrixBytes(m)
+		}
+	}
+	b.refLock.Unlock()
+
+	matrices := make(map[string]interface{})
+	used := retiredBytes
+	if mountBytes > 0 && cfg != nil {
+		keyID, err := contextKeyID(cfg, "")
+		if err != nil {
+			return nil, err
+		}
+		matrices[keyID] = map[string]interface{}{
+			"dimension": mountDimension,
+			"bytes":     mountBytes,
+			"derived":   false,
+		}
+		used += mountBytes
+	}
+	for derivationContext, m := range derived {
+		used += matrixBytes(m)
+		if cfg == nil {
+			continue
+		}
+		keyID, err := contextKeyID(cfg, derivationContext)
+		if err != nil {
+			return nil, err
+		}
+		dimension, _ := m.Dims()
+		matrices[keyID] = map[string]interface{}{
+			"dimension": dimension,
+			"bytes":     matrixBytes(m),
+			"derived":   true,
+		}
+	}
+	for _, cached := range versions {
+		used += matrixBytes(cached.matrix)
+		keyID, err := contextKeyID(cached.cfg, "")
+		if err != nil {
+			return nil, err
+		}
+		dimension, _ := cached.matrix.Dims()
+		matrices[keyID] = map[string]interface{}{
+			"dimension": dimension,
+			"bytes":     matrixBytes(cached.matrix),
+			"derived":   false,
+		}
+	}
+
+	limit := MaxDimension
+	if settings.strict() {
+		limit = strictMaxDimension
+	}
+	data := map[string]interface{}{
+		"matrices":             matrices,
+		"matrix_bytes":         used,
+		"retired_matrices":     retired,
+		"retired_bytes":        retiredBytes,
+		"derived_matrices":     len(derived),
+		"max_derived_matrices": settings.CacheMaxEntries,
+		"buffer_pool": map[string]interface{}{
+			"stats_enabled":       b.poolStats.enabled.Load(),
+			"allocated_bytes":     b.poolStats.allocatedBytes.Load(),
+			"max_buffer_elements": b.poolStats.maxCapacity.Load(),
+		},
+		"memory_budget":            settings.MemoryBudget,
+		"max_admissible_dimension": limit,
+	}
+	if settings.MemoryBudget > 0 {
+		headroom := settings.MemoryBudget - used
+		data["headroom_bytes"] = headroom
+		data["max_admissible_dimension"] = maxDimensionWithin(headroom, limit)
+	}
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// Help text constants for the capacity path.
+const pathCapacityHelpSyn = `Report matrix memory use and headroom on this node.`
+
+const pathCapacityHelpDesc = `
+Reading this endpoint reports the memory held by this node's matrices, for
+deciding whether a new key or derivation context can be onboarded onto a
+shared mount. Matrices are cached per node, so each node reports its own.
+
+A d-dimensional key holds a d×d matrix of 8-byte floats: 18 MB at d=1536,
+128 MB at d=4096. During rotation the old and new matrices are briefly held
+together, so keep headroom for one more matrix of the current dimension.
+
+Output:
+  matrices             - Cached matrices by key_id, with their dimension,
+                         bytes, and whether they belong to a derived key
+  matrix_bytes         - Total bytes of cached and retired matrices
+  retired_matrices     - Matrices dropped from the cache (after rotation or
+  retired_bytes          eviction) still held by in-flight requests
+  derived_matrices     - Derived-key matrices cached; with pinned key
+  max_derived_matrices   versions they share cache_max_entries (see
+                         cache/status)
+  buffer_pool          - Buffer pool sizing from the pool statistics; only
+                         advances while pool_stats is enabled
+  memory_budget        - The memory_budget mount setting (0: none)
+  headroom_bytes       - memory_budget minus matrix_bytes; negative when
+                         over budget. Omitted without a budget.
+  max_admissible_dimension
+                       - Largest dimension whose matrix fits in the
+                         headroom, or the mount's dimension limit (8192, or
+                         4096 under hardening_profile=strict) without a
+                         budget
+
+Example:
+  vault write vector/config/settings memory_budget=536870912
+  vault read vector/capacity
+`
@@ -0,0 +1,91 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCapacityReport(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "tenant",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "mount-1",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/tenant", map[string]interface{}{
+		"vector": testVector(1),
+		"id":     "tenant-1",
+	})
+
+	matrixSize := int64(testDimension * testDimension * 8)
+	budget := 3 * matrixSize
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"memory_budget": budget,
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "capacity", nil)
+	matrices := resp.Data["matrices"].(map[string]interface{})
+	if len(matrices) != 2 {
+		t.Fatalf("matrices = %v, want the mount and tenant keys", matrices)
+	}
+	for id, derived := range map[string]bool{"mount-1": false, "tenant-1": true} {
+		keyID := testRequest(t, b, s, logical.ReadOperation, "ciphertext/"+id, nil).Data["key_id"].(string)
+		m, ok := matrices[keyID].(map[string]interface{})
+		if !ok {
+			t.Fatalf("matrices = %v, missing key_id %s of %s", matrices, keyID, id)
+		}
+		if m["bytes"] != matrixSize || m["dimension"] != testDimension || m["derived"] != derived {
+			t.Errorf("matrices[%s] = %v, want %d bytes, derived=%v", keyID, m, matrixSize, derived)
+		}
+	}
+	if resp.Data["matrix_bytes"] != 2*matrixSize {
+		t.Errorf("matrix_bytes = %v, want %d", resp.Data["matrix_bytes"], 2*matrixSize)
+	}
+	if resp.Data["headroom_bytes"] != matrixSize {
+		t.Errorf("headroom_bytes = %v, want %d", resp.Data["headroom_bytes"], matrixSize)
+	}
+	if resp.Data["max_admissible_dimension"] != testDimension {
+		t.Errorf("max_admissible_dimension = %v, want %d", resp.Data["max_admissible_dimension"], testDimension)
+	}
+}
+
+func TestCapacityWithoutBudget(t *testing.T) {
+	b, s := getTestBackend(t)
+	resp := testRequest(t, b, s, logical.ReadOperation, "capacity", nil)
+	if _, ok := resp.Data["headroom_bytes"]; ok {
+		t.Error("headroom_bytes reported without a budget")
+	}
+	if resp.Data["max_admissible_dimension"] != MaxDimension {
+		t.Errorf("max_admissible_dimension = %v, want %d", resp.Data["max_admissible_dimension"], MaxDimension)
+	}
+}
+
+func TestMaxDimensionWithin(t *testing.T) {
+	for _, tc := range []struct {
+		bytes int64
+		limit int
+		want  int
+	}{
+		{0, MaxDimension, 0},
+		{-8, MaxDimension, 0},
+		{7, MaxDimension, 0},
+		{8, MaxDimension, 1},
+		{1536 * 1536 * 8, MaxDimension, 1536},
+		{1536*1536*8 - 1, MaxDimension, 1535},
+		{1 << 40, MaxDimension, MaxDimension},
+		{1 << 40, strictMaxDimension, strictMaxDimension},
+	} {
+		if got := maxDimensionWithin(tc.bytes, tc.limit); got != tc.want {
+			t.Errorf("maxDimensionWithin(%d, %d) = %d, want %d", tc.bytes, tc.limit, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// ceremonyStoragePath is the Vault storage path for the key ceremony.
+	ceremonyStoragePath = "config/ceremony"
+
+	// minCeremonyContributors and maxCeremonyContributors bound the number
+	// of operators contributing entropy.
+	minCeremonyContributors = 2
+	maxCeremonyContributors = 32
+
+	// minContributionBytes and maxContributionBytes bound one operator's
+	// decoded entropy.
+	minContributionBytes = 32
+	maxContributionBytes = 1024
+
+	// defaultCeremonyWindow is how long contributions are accepted after a
+	// ceremony starts.
+	defaultCeremonyWindow = 24 * time.Hour
+
+	// maxCeremonyWindow bounds window.
+	maxCeremonyWindow = 7 * 24 * time.Hour
+)
+
+// Key ceremony states.
+const (
+	ceremonyCollecting = "collecting"
+	ceremonyComplete   = "complete"
+
+	// ceremonyFailed ends a ceremony whose key stopped passing the key
+	// policy or hardening profile while it was collecting.
+	ceremonyFailed = "failed"
+)
+
+// Domain separation labels for the ceremony hashes.
+const (
+	ceremonyMixLabel  = "vector-dpe ceremony mix v1"
+	ceremonySeedLabel = "vector-dpe ceremony seed v1"
+)
+
+// ceremonyContributor records who contributed to a ceremony, and when.
+type ceremonyContributor struct {
+	Identity    string    `json:"identity"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// keyCeremony derives the next key from entropy contributed by several
+// operators. State is a running hash that starts from the server's RNG and
+// absorbs the digest of each contribution; contributions themselves are
+// never stored.
+type keyCeremony struct {
+	ID           string                `json:"id"`
+	Status       string                `json:"status"`
+	Required     int                   `json:"required"`
+	Config       rotationConfig        `json:"config"`
+	ExpiresAt    *time.Time            `json:"key_expires_at,omitempty"`
+	State        []byte                `json:"state,omitempty"`
+	Contributors []ceremonyContributor `json:"contributors"`
+	StartedAt    time.Time             `json:"started_at"`
+	Deadline     time.Time             `json:"deadline"`
+	CompletedAt  time.Time             `json:"completed_at,omitempty"`
+	Failure      string                `json:"failure,omitempty"`
+}
+
+// responseData renders the ceremony for API responses, without its state.
+func (c *keyCeremony) responseData() map[string]interface{} {
+	contributors := make([]map[string]interface{}, 0, len(c.Contributors))
+	for _, contributor := range c.Contributors {
+		contributors = append(contributors, map[string]interface{}{
+			"identity":     contributor.Identity,
+			"display_name": contributor.DisplayName,
+			"time":         contributor.Time.Format(time.RFC3339),
+		})
+	}
+	data := map[string]interface{}{
+		"ceremony_id":          c.ID,
+		"status":               c.Status,
+		"required":             c.Required,
+		"received":             len(c.Contributors),
+		"contributors":         contributors,
+		"dimension":            c.Config.Dimension,
+		"scaling_factor":       c.Config.ScalingFactor,
+		"approximation_factor": c.Config.ApproximationFactor,
+		"min_noise_radius":     c.Config.MinNoiseRadius,
+		"noise_mask":           c.Config.NoiseMask.String(),
+		"noise_whitened":       len(c.Config.NoiseVariance) > 0,
+		"embedding_model":      c.Config.EmbeddingModel,
+		"require_model":        c.Config.RequireModel,
+		"seed_source":          c.Config.SeedSource,
+		"split":                c.Config.Split,
+		"portable_arithmetic":  c.Config.PortableArithmetic,
+		"derived":              c.Config.Derived,
+		"started_at":           c.StartedAt.Format(time.RFC3339),
+		"deadline":             c.Deadline.Format(time.RFC3339),
+		"completed_at":         "",
+	}
+	if c.Status == ceremonyComplete {
+		data["completed_at"] = c.CompletedAt.Format(time.RFC3339)
+	}
+	if c.Failure != "" {
+		data["failure"] = c.Failure
+	}
+	return data
+}
+
+// contributed reports whether identity already contributed.
+func (c *keyCeremony) contributed(identity string) bool {
+	for _, contributor := range c.Contributors {
+		if contributor.Identity == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// mixContribution absorbs the digest of entropy into the running state:
+// state' = SHA-256(label || state || SHA-256(entropy)).
+func mixContribution(state, entropy []byte) []byte {
+	digest := sha256.Sum256(entropy)
+	h := sha256.New()
+	h.Write([]byte(ceremonyMixLabel))
+	h.Write(state)
+	h.Write(digest[:])
+	return h.Sum(nil)
+}
+
+// ceremonySeed derives the key seed from the final state.
+func ceremonySeed(state []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(ceremonySeedLabel))
+	h.Write(state)
+	return h.Sum(nil)[:seedLength]
+}
+
+// pathCeremony returns the path configuration for config/ceremony.
+func (b *vectorBackend) pathCeremony() []*framework.Path {
+	startFields := keyFields()
+	startFields["contributors"] = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: fmt.Sprintf("Number of distinct operators who must contribute entropy (%d-%d).", minCeremonyContributors, maxCeremonyContributors),
+		Default:     minCeremonyContributors,
+	}
+	startFields["window"] = &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: fmt.Sprintf("How long contributions are accepted (default: %s, max: %s).", defaultCeremonyWindow, maxCeremonyWindow),
+		Default:     int(defaultCeremonyWindow.Seconds()),
+	}
+	return []*framework.Path{
+		{
+			Pattern: "config/ceremony",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleCeremonyRead,
+					Summary:  "Report the progress of the key ceremony.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleCeremonyCancel,
+					Summary:  "Cancel a key ceremony in progress.",
+				},
+			},
+			HelpSynopsis:    pathCeremonyHelpSyn,
+			HelpDescription: pathCeremonyHelpDesc,
+		},
+		{
+			Pattern: "config/ceremony/start",
+			Fields:  startFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCeremonyStart,
+					Summary:  "Start a key ceremony with the parameters of the next key.",
+				},
+			},
+			HelpSynopsis:    pathCeremonyHelpSyn,
+			HelpDescription: pathCeremonyHelpDesc,
+		},
+		{
+			Pattern: "config/ceremony/contribute",
+			Fields: map[string]*framework.FieldSchema{
+				"entropy": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("Base64-encoded entropy from this operator (%d-%d bytes).", minContributionBytes, maxContributionBytes),
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCeremonyContribute,
+					Summary:  "Contribute entropy to the key ceremony; the last contribution installs the key.",
+				},
+			},
+			HelpSynopsis:    pathCeremonyHelpSyn,
+			HelpDescription: pathCeremonyHelpDesc,
+		},
+	}
+}
+
+// handleCeremonyRead reports the current or last ceremony.
+func (b *vectorBackend) handleCeremonyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	ceremony, err := readCeremony(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: ceremony.responseData(),
+	}, nil
+}
+
+// handleCeremonyStart records the parameters of the next key and seeds the
+// running state from the server's RNG.
+func (b *vectorBackend) handleCeremonyStart(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, expiresAt, err := b.parseKeyConfig(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	required := data.Get("contributors").(int)
+	if required < minCeremonyContributors || required > maxCeremonyContributors {
+		return nil, fmt.Errorf("contributors must be between %d and %d", minCeremonyContributors, maxCeremonyContributors)
+	}
+	window := time.Duration(data.Get("window").(int)) * time.Second
+	if window <= 0 || window > maxCeremonyWindow {
+		return nil, fmt.Errorf("window must be between 1s and %s", maxCeremonyWindow)
+	}
+
+	b.ceremonyLock.Lock()
+	defer b.ceremonyLock.Unlock()
+	now := time.Now().UTC()
+	current, err := readCeremony(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.Status == ceremonyCollecting && now.Before(current.Deadline) {
+		return nil, fmt.Errorf("ceremony %s is in progress; cancel it first", current.ID)
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	state, source, err := b.randomBytes(sha256.Size, settings.RequireExternalEntropy)
+	if err != nil {
+		return nil, fmt.Errorf("generate ceremony state: %w", err)
+	}
+	cfg.SeedSource = seedSourceCeremony
+	if source == seedSourceAugmented {
+		cfg.SeedSource = seedSourceCeremonyAugmented
+	}
+	ceremony := &keyCeremony{
+		ID:           id,
+		Status:       ceremonyCollecting,
+		Required:     required,
+		Config:       *cfg,
+		ExpiresAt:    expiresAt,
+		State:        state,
+		Contributors: []ceremonyContributor{},
+		StartedAt:    now,
+		Deadline:     now.Add(window),
+	}
+	if err := putStorageJSON(ctx, req.Storage, ceremonyStoragePath, ceremony); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "ceremony-started", "ceremony_id", id)
+	return &logical.Response{
+		Data: ceremony.responseData(),
+	}, nil
+}
+
+// handleCeremonyContribute hashes one operator's entropy into the ceremony
+// and, once enough distinct operators have contributed, installs the key.
+func (b *vectorBackend) handleCeremonyContribute(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entropy, err := base64.StdEncoding.DecodeString(data.Get("entropy").(string))
+	if err != nil {
+		return logical.ErrorResponse("decode entropy: %s", err), nil
+	}
+	defer zeroBytes(entropy)
+	if len(entropy) < minContributionBytes || len(entropy) > maxContributionBytes {
+		return logical.ErrorResponse("entropy must be %d-%d bytes (got %d)", minContributionBytes, maxContributionBytes, len(entropy)), nil
+	}
+	caller := callerIdentity(req)
+	if caller == "" {
+		return nil, fmt.Errorf("%w: contributing requires a token with an entity or accessor", logical.ErrPermissionDenied)
+	}
+
+	b.ceremonyLock.Lock()
+	defer b.ceremonyLock.Unlock()
+	ceremony, err := readCeremony(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	switch {
+	case ceremony == nil || ceremony.Status != ceremonyCollecting:
+		return logical.ErrorResponse("no key ceremony in progress"), nil
+	case now.After(ceremony.Deadline):
+		return logical.ErrorResponse("key ceremony %s expired at %s", ceremony.ID, ceremony.Deadline.Format(time.RFC3339)), nil
+	case ceremony.contributed(caller):
+		return nil, fmt.Errorf("%w: %s already contributed to ceremony %s", logical.ErrPermissionDenied, caller, ceremony.ID)
+	}
+
+	ceremony.State = mixContribution(ceremony.State, entropy)
+	ceremony.Contributors = append(ceremony.Contributors, ceremonyContributor{
+		Identity:    caller,
+		DisplayName: req.DisplayName,
+		Time:        now,
+	})
+	if len(ceremony.Contributors) < ceremony.Required {
+		if err := putStorageJSON(ctx, req.Storage, ceremonyStoragePath, ceremony); err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: ceremony.responseData(),
+		}, nil
+	}
+
+	cfg := ceremony.Config
+	// The contribution is not stored until the key is installed, so the
+	// last contributor can retry after a conflict.
+	release, err := b.acquireRotationLock(ctx, req, "ceremony", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// The parameters were checked at config/ceremony/start; the profile or
+	// the policy may have been tightened since.
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPolicy(ctx, req.Storage, settings, &cfg); err != nil {
+		zeroBytes(ceremony.State)
+		ceremony.State = nil
+		ceremony.Status = ceremonyFailed
+		ceremony.Failure = err.Error()
+		if err := putStorageJSON(ctx, req.Storage, ceremonyStoragePath, ceremony); err != nil {
+			return nil, err
+		}
+		b.Logger().Warn("key ceremony failed", "ceremony_id", ceremony.ID, "error", err)
+		return logical.ErrorResponse("key ceremony %s failed, no key was installed: its parameters no longer comply (%s); start a new ceremony with compliant parameters",
+			ceremony.ID, err), nil
+	}
+
+	before, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	seed := ceremonySeed(ceremony.State)
+	lifecycle, err := b.installSeed(ctx, req.Storage, &cfg, seed, ceremony.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	zeroBytes(ceremony.State)
+	ceremony.State = nil
+	ceremony.Status = ceremonyComplete
+	ceremony.CompletedAt = now
+	if err := putStorageJSON(ctx, req.Storage, ceremonyStoragePath, ceremony); err != nil {
+		return nil, fmt.Errorf("key installed but ceremony not marked complete: %w", err)
+	}
+	after, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "ceremony", before, after); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "ceremony-complete", "ceremony_id", ceremony.ID)
+
+	resp := keyResponse(&cfg, lifecycle)
+	for name, value := range ceremony.responseData() {
+		if _, ok := resp.Data[name]; !ok {
+			resp.Data[name] = value
+		}
+	}
+	return resp, nil
+}
+
+// handleCeremonyCancel discards a ceremony in progress with its state. A
+// completed ceremony is kept for the record.
+func (b *vectorBackend) handleCeremonyCancel(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.ceremonyLock.Lock()
+	defer b.ceremonyLock.Unlock()
+	ceremony, err := readCeremony(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony == nil || ceremony.Status != ceremonyCollecting {
+		return nil, nil
+	}
+	return nil, req.Storage.Delete(ctx, ceremonyStoragePath)
+}
+
+// readCeremony retrieves the current or last key ceremony, or nil if none
+// was started.
+func readCeremony(ctx context.Context, storage logical.Storage) (*keyCeremony, error) {
+	var ceremony keyCeremony
+	found, err := getStorageJSON(ctx, storage, ceremonyStoragePath, &ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &ceremony, nil
+}
+
+// Help text constants for the key ceremony paths.
+const pathCeremonyHelpSyn = `Generate the next key from entropy contributed by several operators.`
+
+const pathCeremonyHelpDesc = `
+A key ceremony replaces config/rotate for deployments where no single
+party, and not the server's RNG alone, may determine the key:
+
+  1. config/ceremony/start takes the parameters of config/rotate, plus
+     'contributors' (how many distinct operators must contribute, default
+     2) and 'window' (how long contributions are accepted, default 24h).
+     The server seeds a running state from its own RNG.
+  2. Each operator writes config/ceremony/contribute with 'entropy', 32 to
+     1024 random bytes, base64-encoded, generated on their own machine.
+     The digest of each contribution is hashed into the state:
+       state = SHA-256(label || state || SHA-256(entropy))
+     Each entity (or token accessor) may contribute once.
+  3. The last contribution derives the seed as SHA-256(label || state) and
+     installs it exactly like config/rotate, replacing the current key.
+     The parameters are checked again against the hardening profile and
+     config/policy first; if they no longer comply, the ceremony fails
+     with status 'failed', its state is discarded and the key is kept.
+
+Contributions are never stored, and the state is discarded once the key is
+installed. Reading config/ceremony reports who contributed and when;
+DELETE cancels a ceremony in progress.
+
+Both write paths require the sudo capability and can be placed under
+config/dual-control or a control group, like config/rotate.
+`
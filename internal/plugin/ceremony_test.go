@@ -0,0 +1,177 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestKeyCeremony(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	seed := func() string {
+		cfg, err := b.readConfig(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.Seed
+	}
+	original := seed()
+	entropy := func(fill byte) map[string]interface{} {
+		return map[string]interface{}{
+			"entropy": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, minContributionBytes)),
+		}
+	}
+
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/contribute", entropy(1)); err == nil {
+		t.Fatal("contributed without a ceremony")
+	}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/ceremony/start", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 2.0,
+		"contributors":   3,
+	})
+	if resp.Data["status"] != ceremonyCollecting || resp.Data["required"] != 3 {
+		t.Fatalf("start = %v, want a ceremony collecting 3 contributions", resp.Data)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/start", map[string]interface{}{
+		"dimension": testDimension,
+	}); err == nil {
+		t.Error("started a second ceremony while one was in progress")
+	}
+
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/contribute", map[string]interface{}{
+		"entropy": base64.StdEncoding.EncodeToString([]byte("too short")),
+	}); err == nil {
+		t.Error("accepted a contribution shorter than the minimum")
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/contribute", entropy(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/contribute", entropy(2)); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("second contribution by alice = %v, want permission denied", err)
+	}
+	if _, err := entityRequest(b, s, "bob", logical.UpdateOperation, "config/ceremony/contribute", entropy(2)); err != nil {
+		t.Fatal(err)
+	}
+	if seed() != original {
+		t.Fatal("key changed before every operator contributed")
+	}
+
+	resp, err := entityRequest(b, s, "carol", logical.UpdateOperation, "config/ceremony/contribute", entropy(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["status"] != ceremonyComplete || resp.Data["scaling_factor"] != 2.0 {
+		t.Errorf("last contribution = %v, want the key installed", resp.Data)
+	}
+	if seed() == original {
+		t.Error("completed ceremony did not replace the key")
+	}
+	ceremony, err := readCeremony(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ceremony.State != nil || len(ceremony.Contributors) != 3 {
+		t.Errorf("completed ceremony kept state %x with contributors %v", ceremony.State, ceremony.Contributors)
+	}
+
+	// The key encrypts as usual.
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	// A ceremony in progress can be cancelled.
+	testRequest(t, b, s, logical.UpdateOperation, "config/ceremony/start", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.DeleteOperation, "config/ceremony", nil)
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config/ceremony", nil); resp != nil {
+		t.Errorf("ceremony after cancel = %v, want none", resp.Data)
+	}
+}
+
+func TestCeremonySeedDependsOnEveryInput(t *testing.T) {
+	state := bytes.Repeat([]byte{7}, 32)
+	a := bytes.Repeat([]byte{1}, 32)
+	c := bytes.Repeat([]byte{2}, 32)
+	seed := ceremonySeed(mixContribution(mixContribution(state, a), c))
+	if len(seed) != seedLength {
+		t.Fatalf("seed is %d bytes, want %d", len(seed), seedLength)
+	}
+
+	otherState := bytes.Repeat([]byte{8}, 32)
+	otherEntropy := bytes.Repeat([]byte{3}, 32)
+	for name, other := range map[string][]byte{
+		"server state":    ceremonySeed(mixContribution(mixContribution(otherState, a), c)),
+		"first entropy":   ceremonySeed(mixContribution(mixContribution(state, otherEntropy), c)),
+		"second entropy":  ceremonySeed(mixContribution(mixContribution(state, a), otherEntropy)),
+		"missing entropy": ceremonySeed(mixContribution(state, a)),
+	} {
+		if bytes.Equal(seed, other) {
+			t.Errorf("changing the %s left the seed unchanged", name)
+		}
+	}
+}
+
+func TestCeremonyRechecksKeyPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	original, err := b.readConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/ceremony/start", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"contributors":         2,
+	})
+	entropy := map[string]interface{}{
+		"entropy": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, minContributionBytes)),
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/ceremony/contribute", entropy); err != nil {
+		t.Fatal(err)
+	}
+
+	// The policy is tightened while the ceremony collects.
+	testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"allow_zero_noise": false,
+	})
+	if _, err := entityRequest(b, s, "bob", logical.UpdateOperation, "config/ceremony/contribute", entropy); err == nil {
+		t.Fatal("ceremony installed a key below the key policy")
+	}
+	cfg, err := b.readConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seed != original.Seed {
+		t.Error("failed ceremony replaced the key")
+	}
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/ceremony", nil)
+	if resp.Data["status"] != ceremonyFailed || resp.Data["failure"] == nil {
+		t.Errorf("ceremony = %v, want it failed with the reason", resp.Data)
+	}
+	ceremony, err := readCeremony(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ceremony.State != nil {
+		t.Error("failed ceremony kept its state")
+	}
+
+	// A compliant ceremony can start in its place.
+	testRequest(t, b, s, logical.UpdateOperation, "config/ceremony/start", map[string]interface{}{
+		"dimension": testDimension,
+	})
+}
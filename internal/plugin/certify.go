@@ -0,0 +1,141 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// certificationsStoragePath holds the certificates of matrices that
+	// passed ValidateOrthogonality.
+	certificationsStoragePath = "config/certifications"
+
+	// certificationKeyLabel derives the key that certificates are MACed
+	// with from a seed.
+	certificationKeyLabel = "vector-dpe/certification/v1"
+
+	// maxCertifications bounds the stored certificates; the oldest are
+	// dropped first. Only the current key and role-derived keys need one.
+	maxCertifications = 256
+
+	// gonumModule is the module whose version certificates are bound to.
+	gonumModule = "gonum.org/v1/gonum"
+)
+
+// gonumVersion returns the version of gonum built into the plugin, or ""
+// when the build carries no module information.
+var gonumVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == gonumModule {
+			if dep.Replace != nil {
+				return dep.Replace.Path + "@" + dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+})
+
+// certifications is the stored list of certificates, oldest first.
+type certifications struct {
+	Certificates []string `json:"certificates"`
+}
+
+// matrixCertificate returns the certificate of a matrix generated from seed:
+// a MAC, keyed from the seed, over the matrix's dimension and contents and
+// the toolchain that produced it. Any change to the seed, dimension, gonum
+// version, Go version or architecture yields a different certificate, as
+// does a matrix that comes out differently for any other reason.
+func matrixCertificate(seed []byte, matrix *mat.Dense) string {
+	key := deriveSeedKey(seed, certificationKeyLabel)
+	defer zeroBytes(key)
+	mac := hmac.New(sha256.New, key)
+
+	dim, _ := matrix.Dims()
+	fmt.Fprintf(mac, "%d\x00%s\x00%s\x00%s\x00", dim, gonumVersion(), runtime.Version(), runtime.GOARCH)
+	raw := matrix.RawMatrix()
+	var buf [8]byte
+	for i := 0; i < dim; i++ {
+		for _, v := range raw.Data[i*raw.Stride : i*raw.Stride+dim] {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			mac.Write(buf[:])
+		}
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateMatrix checks a freshly generated matrix for orthogonality,
+// skipping the O(d³) check when the same matrix has been certified before.
+// Hashing the matrix for its certificate costs O(d²). Certificates are kept
+// in the mount's storage, so standbys and restarted nodes share them;
+// failing to read or store them only costs the full check. With
+// orthogonality_check=sampled, uncertified matrices get the sampled check
+// instead, and are not certified by it.
+func (b *vectorBackend) validateMatrix(seed []byte, matrix *mat.Dense) error {
+	ctx := context.Background()
+	validate := ValidateOrthogonality
+	sampled := false
+	if b.storage != nil {
+		settings, err := b.getSettings(ctx, b.storage)
+		if err != nil {
+			b.Logger().Warn("failed to read settings; validating in full", "error", err)
+		} else if settings.OrthogonalityCheck == orthogonalityCheckSampled && !settings.strict() {
+			samples := settings.OrthogonalitySamples
+			validate = func(q *mat.Dense) error {
+				rng, err := NewSecureRNG()
+				if err != nil {
+					return err
+				}
+				return ValidateOrthogonalitySampled(q, samples, rng)
+			}
+			sampled = true
+		}
+	}
+	if gonumVersion() == "" || b.storage == nil {
+		return validate(matrix)
+	}
+	certificate := matrixCertificate(seed, matrix)
+
+	b.certificationLock.Lock()
+	defer b.certificationLock.Unlock()
+	var stored certifications
+	if _, err := getStorageJSON(ctx, b.storage, certificationsStoragePath, &stored); err != nil {
+		b.Logger().Warn("failed to read matrix certifications", "error", err)
+	} else if slices.Contains(stored.Certificates, certificate) {
+		return nil
+	}
+
+	if err := validate(matrix); err != nil {
+		return err
+	}
+	if sampled {
+		return nil
+	}
+	stored.Certificates = append(stored.Certificates, certificate)
+	if excess := len(stored.Certificates) - maxCertifications; excess > 0 {
+		stored.Certificates = stored.Certificates[excess:]
+	}
+	if err := putStorageJSON(ctx, b.storage, certificationsStoragePath, &stored); err != nil {
+		// Expected on standbys, which cannot write storage.
+		b.Logger().Debug("failed to store matrix certification", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMatrixCertification(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	certificates := func() []string {
+		var stored certifications
+		if _, err := getStorageJSON(context.Background(), s, certificationsStoragePath, &stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored.Certificates
+	}
+	if got := certificates(); len(got) != 1 {
+		t.Fatalf("certificates after rotation = %v, want one", got)
+	}
+
+	// Regenerating the same matrix reuses its certificate.
+	seed := bytes.Repeat([]byte{1}, seedLength)
+	if _, err := b.loadOrGenerateMatrix(seed, testDimension); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.loadOrGenerateMatrix(seed, testDimension); err != nil {
+		t.Fatal(err)
+	}
+	if got := certificates(); len(got) != 2 {
+		t.Errorf("certificates after regenerating = %v, want two", got)
+	}
+
+	// A matrix that differs from the certified one is validated in full.
+	matrix, err := generateOrthogonalMatrix(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matrix.Set(0, 0, matrix.At(0, 0)+0.1)
+	if err := b.validateMatrix(seed, matrix); err == nil {
+		t.Error("a perturbed matrix passed validation")
+	}
+}
+
+func TestMatrixCertificateBinding(t *testing.T) {
+	seed := bytes.Repeat([]byte{1}, seedLength)
+	matrix, err := generateOrthogonalMatrix(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := matrixCertificate(seed, matrix)
+	if matrixCertificate(seed, matrix) != certificate {
+		t.Fatal("certificate is not deterministic")
+	}
+
+	larger, err := generateOrthogonalMatrix(seed, testDimension+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matrixCertificate(seed, larger) == certificate {
+		t.Error("certificate does not depend on the dimension")
+	}
+	if matrixCertificate(bytes.Repeat([]byte{2}, seedLength), matrix) == certificate {
+		t.Error("certificate does not depend on the seed")
+	}
+	matrix.Set(1, 1, matrix.At(1, 1)+1e-12)
+	if matrixCertificate(seed, matrix) == certificate {
+		t.Error("certificate does not depend on the matrix")
+	}
+}
+
+func TestSampledValidationIsNotCertified(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"orthogonality_check":   orthogonalityCheckSampled,
+		"orthogonality_samples": 100,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	var stored certifications
+	if _, err := getStorageJSON(context.Background(), s, certificationsStoragePath, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Certificates) != 0 {
+		t.Errorf("sampled validation stored certificates %v", stored.Certificates)
+	}
+}
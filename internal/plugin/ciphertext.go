@@ -0,0 +1,675 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/pkg/canonical"
+)
+
+const (
+	// ciphertextStoragePrefix is the Vault storage prefix for stored ciphertexts.
+	ciphertextStoragePrefix = "ciphertext/"
+
+	// maxSubjectLength bounds the length of a stored ciphertext's subject.
+	maxSubjectLength = 256
+
+	// maxCiphertextIDLength bounds the length of a stored ciphertext's ID.
+	maxCiphertextIDLength = 128
+
+	// keyIDLabel derives the public key identifier recorded with stored
+	// ciphertexts. It is distinct from every other use of the seed.
+	keyIDLabel = "vector-dpe/key-id/v1"
+)
+
+// ciphertextIDRegex matches the IDs accepted by ciphertext/:id.
+var ciphertextIDRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
+// ciphertextFilterFields are the fields selecting stored ciphertexts, shared
+// by LIST ciphertext/ and purge/ciphertext.
+func ciphertextFilterFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"prefix": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts whose ID starts with this prefix.",
+		},
+		"key_id": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts produced by this key.",
+		},
+		"role": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts encrypted under this role.",
+		},
+		"subject": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts stored for this subject.",
+		},
+		"created_after": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts stored after this RFC 3339 time.",
+		},
+		"created_before": {
+			Type:        framework.TypeString,
+			Description: "Only ciphertexts stored before this RFC 3339 time.",
+		},
+	}
+}
+
+// ciphertextFilter selects stored ciphertexts. Zero fields match anything.
+type ciphertextFilter struct {
+	Prefix        string
+	KeyID         string
+	Role          string
+	Subject       string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// parseCiphertextFilter reads the filter fields of a request.
+func parseCiphertextFilter(data *framework.FieldData) (*ciphertextFilter, error) {
+	f := &ciphertextFilter{
+		Prefix:  data.Get("prefix").(string),
+		KeyID:   data.Get("key_id").(string),
+		Role:    data.Get("role").(string),
+		Subject: data.Get("subject").(string),
+	}
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &f.CreatedAfter,
+		"created_before": &f.CreatedBefore,
+	} {
+		raw := data.Get(name).(string)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time: %w", name, err)
+		}
+		*dst = t
+	}
+	return f, nil
+}
+
+// empty reports whether the filter matches every ciphertext.
+func (f *ciphertextFilter) empty() bool {
+	return *f == ciphertextFilter{}
+}
+
+// needsEntries reports whether matching requires the stored entries, not
+// just their IDs.
+func (f *ciphertextFilter) needsEntries() bool {
+	return f.KeyID != "" || f.Role != "" || f.Subject != "" || !f.CreatedAfter.IsZero() || !f.CreatedBefore.IsZero()
+}
+
+// matches reports whether the ciphertext stored as id is selected.
+func (f *ciphertextFilter) matches(id string, stored *storedCiphertext) bool {
+	switch {
+	case !strings.HasPrefix(id, f.Prefix):
+		return false
+	case stored == nil:
+		return !f.needsEntries()
+	case f.KeyID != "" && stored.KeyID != f.KeyID:
+		return false
+	case f.Role != "" && stored.Role != f.Role:
+		return false
+	case f.Subject != "" && stored.Subject != f.Subject:
+		return false
+	case !f.CreatedAfter.IsZero() && !stored.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !stored.CreatedAt.Before(f.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+// matchingCiphertextIDs returns the sorted IDs of the stored ciphertexts
+// selected by f. Filters on entry fields are evaluated against the
+// in-memory index; ID-only filters just list storage.
+func (b *vectorBackend) matchingCiphertextIDs(ctx context.Context, storage logical.Storage, f *ciphertextFilter) ([]string, error) {
+	var ids []string
+	if f.needsEntries() {
+		index, err := b.getCiphertextIndex(ctx, storage)
+		if err != nil {
+			return nil, err
+		}
+		b.indexLock.RLock()
+		for id, stored := range index {
+			if f.matches(id, stored) {
+				ids = append(ids, id)
+			}
+		}
+		b.indexLock.RUnlock()
+	} else {
+		keys, err := storage.List(ctx, ciphertextStoragePrefix)
+		if err != nil {
+			return nil, err
+		}
+		// Large entries keep their chunks under "<id>/"; those are not IDs.
+		for _, id := range keys {
+			if !strings.HasSuffix(id, "/") && f.matches(id, nil) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// storedCiphertext is a ciphertext written through to the mount.
+type storedCiphertext struct {
+	Ciphertext []float64 `json:"ciphertext"`
+	Dimension  int       `json:"dimension"`
+
+	// KeyID identifies the key that produced the ciphertext: the mount key,
+	// or the role's derived key. Ciphertexts are only comparable with
+	// others of the same KeyID.
+	KeyID string `json:"key_id"`
+
+	// KeyVersion is the version of the mount key KeyID belongs to. It is
+	// zero for ciphertexts stored before it was recorded.
+	KeyVersion int `json:"key_version,omitempty"`
+
+	// Role is the role the ciphertext was encrypted under, if any.
+	Role string `json:"role,omitempty"`
+
+	// Context is the 'context' parameter of the encrypt request, if any.
+	// It is folded into KeyID, so ciphertexts of one context never match
+	// searches in another.
+	Context string `json:"context,omitempty"`
+
+	// Subject identifies the data subject the vector belongs to, if the
+	// caller supplied one, so that erase/subject can find it.
+	Subject string `json:"subject,omitempty"`
+
+	// Metadata is the opaque metadata of the encrypt request, if any.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt, when set, is when the periodic sweep deletes the
+	// ciphertext. It is hidden from reads and searches from then on.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// responseData renders the stored ciphertext for API responses.
+func (c *storedCiphertext) responseData(id string) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":         id,
+		"ciphertext": c.Ciphertext,
+		"dimension":  c.Dimension,
+		"key_id":     c.KeyID,
+		"role":       c.Role,
+		"context":    c.Context,
+		"subject":    c.Subject,
+		"created_at": c.CreatedAt.Format(time.RFC3339),
+		"expires_at": "",
+		"metadata":   c.Metadata,
+	}
+	if !c.ExpiresAt.IsZero() {
+		data["expires_at"] = c.ExpiresAt.Format(time.RFC3339)
+	}
+	if c.KeyVersion != 0 {
+		data["key_version"] = c.KeyVersion
+	}
+	return data
+}
+
+// pathCiphertext returns the path configuration for ciphertext/ and
+// ciphertext/:id.
+func (b *vectorBackend) pathCiphertext() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "ciphertext/?$",
+			Fields: func() map[string]*framework.FieldSchema {
+				fields := ciphertextFilterFields()
+				fields["after"] = afterField
+				fields["limit"] = limitField
+				return fields
+			}(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleCiphertextList,
+					Summary:  "List the IDs of stored ciphertexts.",
+				},
+			},
+			HelpSynopsis:    pathCiphertextListHelpSyn,
+			HelpDescription: pathCiphertextListHelpDesc,
+		},
+		{
+			Pattern: "ciphertext/" + framework.GenericNameRegex("id"),
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "ID of the stored ciphertext.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleCiphertextRead,
+					Summary:  "Read a stored ciphertext.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleCiphertextDelete,
+					Summary:  "Delete a stored ciphertext.",
+				},
+			},
+			HelpSynopsis:    pathCiphertextHelpSyn,
+			HelpDescription: pathCiphertextHelpDesc,
+		},
+		{
+			Pattern: "purge/ciphertext",
+			Fields: func() map[string]*framework.FieldSchema {
+				fields := ciphertextFilterFields()
+				fields["all"] = &framework.FieldSchema{
+					Type:        framework.TypeBool,
+					Description: "Delete every stored ciphertext. Required when no filter is given.",
+				}
+				fields["dry_run"] = &framework.FieldSchema{
+					Type:        framework.TypeBool,
+					Description: "Report what would be deleted without deleting it.",
+				}
+				return fields
+			}(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCiphertextPurge,
+					Summary:  "Delete the stored ciphertexts matching a filter.",
+				},
+			},
+			HelpSynopsis:    pathCiphertextPurgeHelpSyn,
+			HelpDescription: pathCiphertextPurgeHelpDesc,
+		},
+	}
+}
+
+// handleCiphertextList lists the IDs of stored ciphertexts matching the
+// request's filter, in sorted order, one page at a time.
+func (b *vectorBackend) handleCiphertextList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	filter, err := parseCiphertextFilter(data)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := b.matchingCiphertextIDs(ctx, req.Storage, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids, nextAfter, err := pageKeys(ids, data)
+	if err != nil {
+		return nil, err
+	}
+	resp := logical.ListResponse(ids)
+	if nextAfter != "" {
+		resp.Data["next_after"] = nextAfter
+	}
+	return resp, nil
+}
+
+// handleCiphertextPurge deletes the stored ciphertexts matching the
+// request's filter.
+func (b *vectorBackend) handleCiphertextPurge(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	filter, err := parseCiphertextFilter(data)
+	if err != nil {
+		return nil, err
+	}
+	if filter.empty() && !data.Get("all").(bool) {
+		return logical.ErrorResponse("no filter given; pass all=true to delete every stored ciphertext"), nil
+	}
+	ids, err := b.matchingCiphertextIDs(ctx, req.Storage, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := data.Get("dry_run").(bool)
+	if !dryRun {
+		for i, id := range ids {
+			if err := b.deleteCiphertext(ctx, req.Storage, id); err != nil {
+				return nil, fmt.Errorf("deleted %d of %d ciphertexts: %w", i, len(ids), err)
+			}
+		}
+		b.Logger().Info("purged stored ciphertexts", "count", len(ids))
+		b.emitEvent(ctx, "ciphertexts-purged", "count", strconv.Itoa(len(ids)), "identity", callerIdentity(req))
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"matched": len(ids),
+			"deleted": !dryRun,
+		},
+	}, nil
+}
+
+// handleCiphertextRead returns a stored ciphertext.
+func (b *vectorBackend) handleCiphertextRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	stored, err := readCiphertext(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	// Expired entries read as deleted, even before the sweep removes them.
+	if stored == nil || stored.expired(time.Now()) {
+		return nil, nil
+	}
+	digest, err := ciphertextDigest(stored.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	resp := &logical.Response{
+		Data: stored.responseData(id),
+	}
+	resp.Data["digest"] = digest
+	return resp, nil
+}
+
+// ciphertextDigest returns the hex SHA-256 of the canonical encoding of
+// ciphertext, so clients can check a copy against the stored entry.
+func ciphertextDigest(ciphertext []float64) (string, error) {
+	encoded, err := canonical.Marshal(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// handleCiphertextDelete deletes a stored ciphertext.
+func (b *vectorBackend) handleCiphertextDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := b.deleteCiphertext(ctx, req.Storage, data.Get("id").(string)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// deleteCiphertext removes a stored ciphertext and its index entry, and
+// from the webhook sink if one is configured. The sink goes first: while
+// the mount entry remains, a failed deletion is found and retried.
+func (b *vectorBackend) deleteCiphertext(ctx context.Context, storage logical.Storage, id string) error {
+	webhook, err := b.getWebhookSink(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if webhook != nil {
+		if err := webhook.Delete(ctx, []string{id}); err != nil {
+			return fmt.Errorf("delete ciphertext %q: %w", id, err)
+		}
+	}
+	return (&mountSink{b: b, storage: storage}).Delete(ctx, []string{id})
+}
+
+// readCiphertext retrieves the stored ciphertext with the given ID, or nil.
+func readCiphertext(ctx context.Context, storage logical.Storage, id string) (*storedCiphertext, error) {
+	var stored storedCiphertext
+	found, err := getStorageJSON(ctx, storage, ciphertextStoragePrefix+id, &stored)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &stored, nil
+}
+
+// checkCiphertextID returns an error unless id may name a stored ciphertext.
+func checkCiphertextID(id string) error {
+	if len(id) > maxCiphertextIDLength {
+		return fmt.Errorf("id is %d characters, exceeding maximum %d", len(id), maxCiphertextIDLength)
+	}
+	if !ciphertextIDRegex.MatchString(id) {
+		return fmt.Errorf("id %q must consist of letters, digits, '-', '_' and '.', and start and end with a letter or digit", id)
+	}
+	return nil
+}
+
+// requestKeyID returns the identifier of the key a request made under role
+// encrypts with. It is a one-way function of the key, safe to disclose.
+func (b *vectorBackend) requestKeyID(req *logical.Request, role *vectorRole, cfg *rotationConfig) (string, error) {
+	derivationContext, err := b.resolveDerivationContext(req, role)
+	if err != nil {
+		return "", err
+	}
+	return contextKeyID(cfg, derivationContext)
+}
+
+// contextKeyID returns the identifier of the key derived from cfg for a
+// resolved derivation context, or of the mount key for "".
+func contextKeyID(cfg *rotationConfig, derivationContext string) (string, error) {
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return "", fmt.Errorf("decode seed: %w", err)
+	}
+	defer zeroBytes(seed)
+	if derivationContext != "" {
+		derived := cfg.deriveSeed(seed, derivationContext)
+		defer zeroBytes(derived)
+		seed = derived
+	}
+	return hex.EncodeToString(deriveSeedKey(seed, keyIDLabel)[:8]), nil
+}
+
+// storeOptions carries the request-wide attributes of the ciphertexts an
+// encrypt request stores.
+type storeOptions struct {
+	Role       string
+	Context    string
+	KeyID      string
+	KeyVersion int
+	Subject    string
+
+	// TTL, when positive, schedules the stored ciphertexts' deletion.
+	TTL time.Duration
+
+	// Metadata is the request's opaque metadata. Unlike the other options
+	// it is also echoed when nothing is stored.
+	Metadata map[string]string
+}
+
+// subjectField is the schema of the 'subject' field of encrypt/vector and
+// encrypt/batch.
+var subjectField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Data subject the stored ciphertexts belong to, for erase/subject. Requires storing.",
+}
+
+// parseStoreOptions reads the storing options of an encrypt request that
+// stores ciphertexts if storing is true. KeyID and KeyVersion are left to
+// the caller.
+func parseStoreOptions(data *framework.FieldData, storing bool) (storeOptions, error) {
+	ttl, err := storeTTL(data, storing)
+	if err != nil {
+		return storeOptions{}, err
+	}
+	subject := data.Get("subject").(string)
+	switch {
+	case subject != "" && !storing:
+		return storeOptions{}, fmt.Errorf("subject requires storing the ciphertext")
+	case len(subject) > maxSubjectLength:
+		return storeOptions{}, fmt.Errorf("subject exceeds %d characters", maxSubjectLength)
+	}
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return storeOptions{}, err
+	}
+	return storeOptions{
+		Role:    data.Get("role").(string),
+		Context: requestContext,
+		Subject: subject,
+		TTL:     ttl,
+	}, nil
+}
+
+// storeCiphertext writes ciphertext through to ciphertext/:id, replacing any
+// ciphertext stored under the same ID, then to the webhook sink if one is
+// configured.
+func (b *vectorBackend) storeCiphertext(ctx context.Context, storage logical.Storage, id string, ciphertext []float64, opts storeOptions) error {
+	stored := storedCiphertext{
+		Ciphertext: ciphertext,
+		Dimension:  len(ciphertext),
+		KeyID:      opts.KeyID,
+		KeyVersion: opts.KeyVersion,
+		Role:       opts.Role,
+		Context:    opts.Context,
+		Subject:    opts.Subject,
+		Metadata:   opts.Metadata,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if opts.TTL > 0 {
+		stored.ExpiresAt = stored.CreatedAt.Add(opts.TTL)
+		// Schedule first: a ciphertext must never be stored without its
+		// deletion being scheduled.
+		if err := scheduleExpiry(ctx, storage, id, stored.ExpiresAt); err != nil {
+			return fmt.Errorf("schedule expiry of ciphertext %q: %w", id, err)
+		}
+	}
+	records := []sinkRecord{{ID: id, storedCiphertext: stored}}
+	if err := (&mountSink{b: b, storage: storage}).Upsert(ctx, records); err != nil {
+		return err
+	}
+	webhook, err := b.getWebhookSink(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if webhook != nil {
+		if err := webhook.Upsert(ctx, records); err != nil {
+			return fmt.Errorf("ciphertext %q stored in the mount but not the sink: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// getCiphertextIndex returns the in-memory copy of every stored ciphertext,
+// loading it from storage on first use. It follows the same Check-Lock-Check
+// pattern as getSettings. The returned map is shared; callers MUST hold
+// indexLock for reading while they use it and MUST NOT modify it.
+func (b *vectorBackend) getCiphertextIndex(ctx context.Context, storage logical.Storage) (map[string]*storedCiphertext, error) {
+	b.indexLock.RLock()
+	if index := b.ciphertextIndex; index != nil {
+		b.indexLock.RUnlock()
+		return index, nil
+	}
+	b.indexLock.RUnlock()
+
+	b.indexLock.Lock()
+	defer b.indexLock.Unlock()
+
+	if b.ciphertextIndex != nil {
+		return b.ciphertextIndex, nil
+	}
+	keys, err := storage.List(ctx, ciphertextStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]*storedCiphertext, len(keys))
+	for _, id := range keys {
+		if strings.HasSuffix(id, "/") {
+			continue
+		}
+		stored, err := readCiphertext(ctx, storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			index[id] = stored
+		}
+	}
+	b.ciphertextIndex = index
+	return index, nil
+}
+
+// resetCiphertextIndex drops the in-memory index; the next search reloads it.
+func (b *vectorBackend) resetCiphertextIndex() {
+	b.indexLock.Lock()
+	b.ciphertextIndex = nil
+	b.indexLock.Unlock()
+}
+
+// Help text constants for the ciphertext paths.
+const pathCiphertextListHelpSyn = `List the IDs of ciphertexts stored in the mount.`
+
+const pathCiphertextListHelpDesc = `
+Lists the IDs of ciphertexts written through to the mount by encrypt/vector
+(id) or encrypt/batch (ids), in sorted order.
+
+Filters (all optional, combined with AND):
+  prefix         - ID starts with this prefix
+  key_id         - Produced by this key
+  role           - Encrypted under this role
+  subject        - Stored for this data subject
+  created_after  - Stored after this RFC 3339 time
+  created_before - Stored before this RFC 3339 time
+
+Pagination:
+  limit - Maximum number of IDs (default: 0, all). When more remain, the
+          response includes next_after.
+  after - Return IDs sorting after this one; pass the previous next_after.
+
+Filters other than prefix are evaluated against the in-memory copy of the
+store that search/knn uses, loading it on first use.
+
+Example:
+  curl -X LIST -H "X-Vault-Token: $VAULT_TOKEN" \
+      "$VAULT_ADDR/v1/vector/ciphertext?prefix=user-42-&limit=100"
+`
+
+const pathCiphertextHelpSyn = `Read or delete a ciphertext stored in the mount.`
+
+const pathCiphertextHelpDesc = `
+For small deployments without a separate vector database, encrypt/vector
+and encrypt/batch can store their ciphertexts in the mount: pass 'id' (or
+'ids' for a batch, one per vector) and the ciphertext is written to
+ciphertext/:id, replacing any previous one. Access is governed by Vault ACL
+policies on these paths, and storing by the role's 'store' operation.
+
+Each entry records the key_id of the key that produced it: the mount key or
+a role's derived key. Ciphertexts are only comparable with others of the
+same key_id. After config/rotate, stored ciphertexts keep the old key_id
+and no longer match new ones; re-encrypt and store them again.
+
+With 'ttl' on encrypt, the entry reads as deleted once the TTL passes and
+is removed from storage by the periodic sweep, about once a minute.
+
+Output:
+  id         - The ciphertext's ID
+  ciphertext - The stored encrypted vector
+  dimension  - Its dimension
+  key_id     - Identifier of the producing key (one-way, safe to disclose)
+  role       - Role it was encrypted under, if any
+  subject    - Data subject given to encrypt, if any
+  created_at - When it was stored
+  expires_at - When its TTL passes (empty without a TTL)
+  digest     - Hex SHA-256 of the ciphertext's canonical encoding (read
+               only); see pkg/canonical
+
+Example:
+  vault write vector/encrypt/vector vector='[0.1, 0.2, ...]' id=doc-42
+  vault read vector/ciphertext/doc-42
+  vault delete vector/ciphertext/doc-42
+`
+
+const pathCiphertextPurgeHelpSyn = `Delete the stored ciphertexts matching a filter.`
+
+const pathCiphertextPurgeHelpDesc = `
+Deletes every stored ciphertext matching the filter, with the same filter
+fields as LIST ciphertext/ (prefix, key_id, role, subject, created_after,
+created_before). A request without filters is refused unless all=true.
+
+With dry_run=true nothing is deleted; 'matched' reports how many would be.
+A failure part-way reports how many were deleted; repeating the request
+deletes the rest.
+
+Example:
+  vault write vector/purge/ciphertext key_id=0123456789abcdef dry_run=true
+  vault write vector/purge/ciphertext created_before=2026-01-01T00:00:00Z
+`
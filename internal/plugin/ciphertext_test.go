@@ -0,0 +1,228 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/pkg/canonical"
+)
+
+func TestCiphertextStore(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "doc-1",
+	})
+	ciphertext := resp.Data["ciphertext"].([]float64)
+
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(1), []interface{}{1.0}, testVector(2)},
+		"ids":     "doc-2,doc-bad,doc-3",
+	})
+
+	resp = testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+	if got, want := resp.Data["keys"].([]string), []string{"doc-1", "doc-2", "doc-3"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v (failed items are not stored)", got, want)
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-1", nil)
+	if !equalFloats(resp.Data["ciphertext"].([]float64), ciphertext) {
+		t.Error("stored ciphertext differs from the one returned")
+	}
+	encoded, err := canonical.Marshal(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(encoded); resp.Data["digest"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("digest = %v, want the SHA-256 of the canonical encoding", resp.Data["digest"])
+	}
+	keyID := resp.Data["key_id"].(string)
+	if len(keyID) != 16 {
+		t.Errorf("key_id = %q, want 16 hex characters", keyID)
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-2", nil)
+	if resp.Data["key_id"] != keyID {
+		t.Errorf("batch key_id = %v, want %v", resp.Data["key_id"], keyID)
+	}
+
+	// A new key gets a new key_id.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "doc-1",
+	})
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-1", nil)
+	if resp.Data["key_id"] == keyID {
+		t.Error("key_id unchanged after rotation")
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, "ciphertext/doc-1", nil)
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-1", nil)
+	if resp != nil {
+		t.Errorf("read after delete = %v, want nil", resp.Data)
+	}
+}
+
+func TestCiphertextStoreRejects(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/nostore", map[string]interface{}{
+		"allowed_operations": "encrypt,batch",
+	})
+
+	for name, req := range map[string]struct {
+		path string
+		data map[string]interface{}
+	}{
+		"bad id":        {"encrypt/vector", map[string]interface{}{"vector": testVector(0), "id": "../config/seed"}},
+		"role":          {"encrypt/vector/nostore", map[string]interface{}{"vector": testVector(0), "id": "doc"}},
+		"count":         {"encrypt/batch", map[string]interface{}{"vectors": []interface{}{testVector(0)}, "ids": "a,b"}},
+		"duplicate":     {"encrypt/batch", map[string]interface{}{"vectors": []interface{}{testVector(0), testVector(1)}, "ids": "a,a"}},
+		"batch by role": {"encrypt/batch/nostore", map[string]interface{}{"vectors": []interface{}{testVector(0)}, "ids": "a"}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      req.path,
+			Data:      req.data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: request succeeded, want error", name)
+		}
+	}
+	keys, err := s.List(context.Background(), ciphertextStoragePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("stored %v, want nothing", keys)
+	}
+}
+
+func TestCiphertextListFilters(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "tenant",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), testVector(1), testVector(2)},
+		"ids":     "a-1,a-2,b-1",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/tenant", map[string]interface{}{
+		"vector": testVector(3),
+		"id":     "t-1",
+	})
+
+	list := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		return testRequest(t, b, s, logical.ListOperation, "ciphertext/", data)
+	}
+	for name, tc := range map[string]struct {
+		data map[string]interface{}
+		want []string
+	}{
+		"all":            {nil, []string{"a-1", "a-2", "b-1", "t-1"}},
+		"prefix":         {map[string]interface{}{"prefix": "a-"}, []string{"a-1", "a-2"}},
+		"role":           {map[string]interface{}{"role": "tenant"}, []string{"t-1"}},
+		"created_before": {map[string]interface{}{"created_before": "2000-01-01T00:00:00Z"}, nil},
+		"created_after":  {map[string]interface{}{"prefix": "b", "created_after": "2000-01-01T00:00:00Z"}, []string{"b-1"}},
+	} {
+		got, _ := list(tc.data).Data["keys"].([]string)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: keys = %v, want %v", name, got, tc.want)
+		}
+	}
+
+	keyID := testRequest(t, b, s, logical.ReadOperation, "ciphertext/a-1", nil).Data["key_id"]
+	if got := list(map[string]interface{}{"key_id": keyID}).Data["keys"].([]string); !slices.Equal(got, []string{"a-1", "a-2", "b-1"}) {
+		t.Errorf("key_id: keys = %v", got)
+	}
+
+	// Pages of two cover every ID once.
+	var pages [][]string
+	after := ""
+	for {
+		resp := list(map[string]interface{}{"limit": 2, "after": after})
+		pages = append(pages, resp.Data["keys"].([]string))
+		next, ok := resp.Data["next_after"].(string)
+		if !ok {
+			break
+		}
+		after = next
+	}
+	if len(pages) != 2 || !slices.Equal(slices.Concat(pages...), []string{"a-1", "a-2", "b-1", "t-1"}) {
+		t.Errorf("pages = %v", pages)
+	}
+}
+
+func TestCiphertextPurge(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), testVector(1), testVector(2)},
+		"ids":     "a-1,a-2,b-1",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "purge/ciphertext",
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("purge without a filter succeeded, want error")
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "purge/ciphertext", map[string]interface{}{
+		"prefix":  "a-",
+		"dry_run": true,
+	})
+	if resp.Data["matched"] != 2 || resp.Data["deleted"] != false {
+		t.Errorf("dry run = %v, want 2 matched and nothing deleted", resp.Data)
+	}
+
+	// Load the index so the purge has to keep it current.
+	testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "purge/ciphertext", map[string]interface{}{
+		"prefix": "a-",
+	})
+	resp = testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+	if got := resp.Data["keys"].([]string); !slices.Equal(got, []string{"b-1"}) {
+		t.Errorf("keys after purge = %v, want [b-1]", got)
+	}
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if resp.Data["candidates"] != 1 {
+		t.Errorf("search candidates = %v, want 1", resp.Data["candidates"])
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "purge/ciphertext", map[string]interface{}{
+		"all": true,
+	})
+	if resp.Data["matched"] != 1 {
+		t.Errorf("purge all matched %v, want 1", resp.Data["matched"])
+	}
+}
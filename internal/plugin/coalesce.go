@@ -0,0 +1,159 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/compute"
+)
+
+const (
+	// defaultCoalesceMax is the default number of vectors that flush a
+	// coalescing window early.
+	defaultCoalesceMax = 64
+
+	// maxCoalesceWindow bounds coalesce_window, which every coalesced
+	// request may wait for.
+	maxCoalesceWindow = 50 * time.Millisecond
+
+	// maxCoalesceMax bounds coalesce_max.
+	maxCoalesceMax = 1024
+)
+
+// coalesceMetricPrefix is the metric name prefix for request coalescing.
+var coalesceMetricPrefix = []string{"vector_dpe", "coalesce"}
+
+// coalescer gathers the rotations of single-vector requests that arrive
+// within a short window into one matrix-matrix multiply. Rotations are
+// grouped by matrix, so requests under different keys never share one.
+type coalescer struct {
+	mu     sync.Mutex
+	groups map[*mat.Dense]*coalesceGroup
+
+	// compute, when set, runs the multiplies in a worker process; see
+	// compute.go. It is set when the backend is created.
+	compute *compute.Client
+}
+
+// coalesceGroup is the pending rotations for one matrix.
+type coalesceGroup struct {
+	matrix   *mat.Dense
+	requests []*coalesceRequest
+	timer    *time.Timer
+	flushed  bool
+}
+
+// coalesceRequest is one pending rotation. done is closed once output holds
+// the rotated vector, or err is set.
+type coalesceRequest struct {
+	input  []float64
+	output []float64
+	err    error
+	done   chan struct{}
+}
+
+// rotate sets output to matrix·input. With a positive window, it waits up
+// to window for other rotations under the same matrix and multiplies them
+// together, flushing early once max are pending; without one, it splits the
+// rows of the multiply across up to workers goroutines. The caller must
+// hold a lease on matrix until rotate returns.
+func (c *coalescer) rotate(matrix *mat.Dense, input, output []float64, window time.Duration, max, workers int) error {
+	if window <= 0 {
+		if c.compute != nil {
+			return c.multiply(matrix, [][]float64{input}, [][]float64{output})
+		}
+		parallelMulVec(output, matrix, input, workers, false)
+		return nil
+	}
+
+	req := &coalesceRequest{input: input, output: output, done: make(chan struct{})}
+	c.mu.Lock()
+	if c.groups == nil {
+		c.groups = make(map[*mat.Dense]*coalesceGroup)
+	}
+	group := c.groups[matrix]
+	if group == nil {
+		group = &coalesceGroup{matrix: matrix}
+		c.groups[matrix] = group
+		group.timer = time.AfterFunc(window, func() { c.flush(group) })
+	}
+	group.requests = append(group.requests, req)
+	full := len(group.requests) >= max
+	c.mu.Unlock()
+
+	if full {
+		c.flush(group)
+	}
+	<-req.done
+	return req.err
+}
+
+// flush multiplies a group's pending rotations, once.
+func (c *coalescer) flush(group *coalesceGroup) {
+	c.mu.Lock()
+	if group.flushed {
+		c.mu.Unlock()
+		return
+	}
+	group.flushed = true
+	group.timer.Stop()
+	if c.groups[group.matrix] == group {
+		delete(c.groups, group.matrix)
+	}
+	c.mu.Unlock()
+
+	err := c.multiplyGroup(group)
+	metrics.AddSample(append(coalesceMetricPrefix, "batch_size"), float32(len(group.requests)))
+	for _, req := range group.requests {
+		req.err = err
+		close(req.done)
+	}
+}
+
+// multiplyGroup computes matrix·[v1 … vk] and scatters the columns to the
+// requests' outputs. The packed plaintexts are zeroed afterwards.
+func (c *coalescer) multiplyGroup(group *coalesceGroup) (err error) {
+	// Flushes run on timer goroutines, where a panic would take down the
+	// plugin; turn it into an error for the waiting requests instead.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("coalesced rotation failed: %v", r)
+		}
+	}()
+
+	if c.compute != nil {
+		inputs := make([][]float64, len(group.requests))
+		outputs := make([][]float64, len(group.requests))
+		for i, req := range group.requests {
+			inputs[i], outputs[i] = req.input, req.output
+		}
+		return c.multiply(group.matrix, inputs, outputs)
+	}
+
+	dim, _ := group.matrix.Dims()
+	k := len(group.requests)
+	if k == 1 {
+		req := group.requests[0]
+		mat.NewVecDense(dim, req.output).MulVec(group.matrix, mat.NewVecDense(dim, req.input))
+		return nil
+	}
+	inputs := mat.NewDense(dim, k, nil)
+	for j, req := range group.requests {
+		inputs.SetCol(j, req.input)
+	}
+	var outputs mat.Dense
+	outputs.Mul(group.matrix, inputs)
+	zeroMatrix(inputs)
+	for j, req := range group.requests {
+		mat.Col(req.output, j, &outputs)
+	}
+	zeroMatrix(&outputs)
+	return nil
+}
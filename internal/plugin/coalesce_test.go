@@ -0,0 +1,95 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestCoalescerMatchesMulVec(t *testing.T) {
+	matrix, err := GenerateOrthogonalMatrix(make([]byte, seedLength), testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c coalescer
+	const requests = 10
+	outputs := make([][]float64, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := make([]float64, testDimension)
+			for j := range input {
+				input[j] = float64(i*testDimension + j)
+			}
+			outputs[i] = make([]float64, testDimension)
+			// A long window: groups are flushed by reaching max.
+			if err := c.rotate(matrix, input, outputs[i], time.Second, 5, 1); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, output := range outputs {
+		input := make([]float64, testDimension)
+		for j := range input {
+			input[j] = float64(i*testDimension + j)
+		}
+		var want mat.VecDense
+		want.MulVec(matrix, mat.NewVecDense(testDimension, input))
+		for j := range output {
+			if math.Abs(output[j]-want.AtVec(j)) > 1e-9 {
+				t.Fatalf("request %d: coalesced rotation %v, want %v", i, output, want.RawVector().Data)
+			}
+		}
+	}
+	if len(c.groups) != 0 {
+		t.Errorf("%d groups left pending", len(c.groups))
+	}
+}
+
+func TestCoalescedEncryption(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"coalesce_window": "1s",
+	}); err == nil {
+		t.Error("accepted a coalescing window above the maximum")
+	}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"coalesce_window": "2ms",
+	})
+	if resp.Data["coalesce_window"] != "2ms" || resp.Data["coalesce_max"] != defaultCoalesceMax {
+		t.Errorf("settings = %v", resp.Data)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+				"vector": testVector(0),
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := len(resp.Data["ciphertext"].([]float64)); got != testDimension {
+				t.Errorf("ciphertext has %d components, want %d", got, testDimension)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathCompare returns the path configuration for compare.
+func (b *vectorBackend) pathCompare() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("compare"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"ciphertext_a": {
+					Type:        framework.TypeSlice,
+					Description: "First ciphertext, produced under the request's key.",
+					Required:    true,
+				},
+				"ciphertext_b": {
+					Type:        framework.TypeSlice,
+					Description: "Second ciphertext, produced under the request's key.",
+				},
+				"vector": {
+					Type:        framework.TypeSlice,
+					Description: "Plaintext vector, encrypted before comparing. Alternative to 'ciphertext_b'.",
+				},
+				"model":              modelField,
+				"encoding":           encodingField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCompare,
+					Summary:  "Estimate the plaintext distance and cosine similarity behind two ciphertexts.",
+				},
+			},
+			HelpSynopsis:    pathCompareHelpSyn,
+			HelpDescription: pathCompareHelpDesc,
+		},
+	}
+}
+
+// handleCompare estimates the distance and cosine similarity of the
+// plaintexts behind two ciphertexts, or behind a ciphertext and a plaintext,
+// with worst-case error bounds from the key's noise radius.
+func (b *vectorBackend) handleCompare(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationCompare); err != nil {
+		return nil, err
+	}
+	rawB, hasB := data.GetOk("ciphertext_b")
+	rawVector, hasVector := data.GetOk("vector")
+	if hasB == hasVector {
+		return nil, fmt.Errorf("exactly one of 'ciphertext_b' or 'vector' is required")
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, err
+	}
+	derivationContext, err := b.requestDerivationContext(req, role, requestContext)
+	if err != nil {
+		return nil, err
+	}
+
+	var other []float64
+	var cfg *rotationConfig
+	if hasVector {
+		// Plaintexts are encrypted like any other request, noise and repeat
+		// tracking included, so compare is no shortcut around them.
+		if settings.strict() {
+			if err := checkStrictVectorInput(rawVector); err != nil {
+				return nil, err
+			}
+		}
+		vector, err := parseVector(rawVector)
+		if err != nil {
+			return nil, fmt.Errorf("vector: %w", err)
+		}
+		matrix, c, err := b.matrixForContext(ctx, req, derivationContext)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkModel(data.Get("model").(string)); err != nil {
+			return nil, err
+		}
+		result, err := b.encryptVector(matrix, c, settings, vector)
+		if err != nil {
+			return nil, fmt.Errorf("vector: %w", err)
+		}
+		other, cfg = result.Ciphertext, c
+	} else {
+		if err := b.checkKeyEnabled(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+		if _, cfg, err = b.contextMatrix(ctx, req.Storage, derivationContext); err != nil {
+			return nil, err
+		}
+		if other, err = parseComparedCiphertext(rawB, encoding, settings, cfg); err != nil {
+			return nil, fmt.Errorf("ciphertext_b: %w", err)
+		}
+	}
+	ciphertext, err := parseComparedCiphertext(data.Get("ciphertext_a"), encoding, settings, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext_a: %w", err)
+	}
+
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	b.recordActivity(ctx, req, data, operationCompare, 1)
+
+	encryptedDistance := euclideanDistance(ciphertext, other)
+	r := cfg.maxNoiseNorm()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"encrypted_distance": encryptedDistance,
+			"estimated_distance": encryptedDistance / cfg.ScalingFactor,
+			// Each ciphertext carries noise of norm at most r, so the
+			// corrected distance is off by at most 2r/s.
+			"max_error":            2 * r / cfg.ScalingFactor,
+			"approximation_factor": cfg.ApproximationFactor,
+			"key_id":               keyID,
+		},
+	}
+	normA, normB := euclideanNorm(ciphertext), euclideanNorm(other)
+	if normA == 0 || normB == 0 {
+		addWarning(resp, warnZeroVector, "a ciphertext is the zero vector: cosine similarity is undefined")
+		return resp, nil
+	}
+	var dot float64
+	for i := range ciphertext {
+		dot += ciphertext[i] * other[i]
+	}
+	resp.Data["cosine_similarity"] = math.Max(-1, math.Min(1, dot/(normA*normB)))
+	resp.Data["cosine_max_error"] = math.Min(2, cosineMaxError(normA, r)+cosineMaxError(normB, r))
+	return resp, nil
+}
+
+// parseComparedCiphertext parses one of the ciphertexts compared, checking
+// its dimension against the key's.
+func parseComparedCiphertext(raw interface{}, encoding string, settings *mountSettings, cfg *rotationConfig) ([]float64, error) {
+	if settings.strict() && encoding == encodingArray {
+		if err := checkStrictVectorInput(raw); err != nil {
+			return nil, err
+		}
+	}
+	ciphertext, err := parseCiphertextInto(nil, raw, encoding, cfg.Dimension)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) != cfg.Dimension {
+		return nil, fmt.Errorf("dimension %d does not match configured dimension %d", len(ciphertext), cfg.Dimension)
+	}
+	return ciphertext, nil
+}
+
+// euclideanNorm returns the L2 norm of v.
+func euclideanNorm(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// cosineMaxError bounds how far the noise of norm at most r can turn a
+// ciphertext of the given norm away from its noiseless direction s·Q·v. The
+// noiseless ciphertext has norm at least norm - r, so the angle is at most
+// asin(r / (norm - r)); since cosine is 1-Lipschitz, the angles of the two
+// ciphertexts add up to a bound on the cosine error. It is 2 when the noise
+// may dominate.
+func cosineMaxError(norm, r float64) float64 {
+	if r == 0 {
+		return 0
+	}
+	if norm <= 2*r {
+		return 2
+	}
+	return math.Asin(r / (norm - r))
+}
+
+// Help text constants for the compare path.
+const pathCompareHelpSyn = `Estimate the plaintext distance and cosine similarity behind two ciphertexts.`
+
+const pathCompareHelpDesc = `
+Compares two ciphertexts produced under the request's key, or a ciphertext
+and a plaintext, which is first encrypted exactly as encrypt/vector would.
+Use it to check that a key's approximation_factor preserves enough accuracy
+for a workload.
+
+Input:
+  ciphertext_a - A ciphertext
+  ciphertext_b - A second ciphertext, or
+  vector       - A plaintext to compare ciphertext_a against
+  encoding     - Encoding of the ciphertexts: array (default) or base64
+
+Output:
+  estimated_distance - encrypted_distance / scaling_factor, which estimates
+                       the plaintext distance
+  encrypted_distance - Distance between the ciphertexts
+  max_error          - Worst-case error of estimated_distance from noise,
+                       2 * r / s, where r follows from approximation_factor
+  cosine_similarity  - Cosine of the angle between the ciphertexts, which
+                       estimates the plaintexts' (rotation preserves angles)
+  cosine_max_error   - Worst-case error of cosine_similarity from noise; 2
+                       when the noise may dominate a ciphertext
+  key_id             - The key the ciphertexts must have been produced by
+
+Ciphertexts of a different key, or of a rotated-away key version, compare
+meaninglessly: the endpoint cannot tell. Under a role, the role's key is
+used and 'compare' must be in its allowed_operations.
+`
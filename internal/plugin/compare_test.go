@@ -0,0 +1,103 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCompare(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	a := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	c := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(3),
+	})
+
+	// testVector(0) and testVector(3) are 3·√d apart.
+	plaintextDistance := 3 * math.Sqrt(testDimension)
+	va, vb := testVector(0), testVector(3)
+	var dot, na, nb float64
+	for i := range va {
+		x, y := va[i].(float64), vb[i].(float64)
+		dot, na, nb = dot+x*y, na+x*x, nb+y*y
+	}
+	plaintextCosine := dot / math.Sqrt(na*nb)
+
+	for name, data := range map[string]map[string]interface{}{
+		"ciphertexts": {"ciphertext_a": a.Data["ciphertext"], "ciphertext_b": c.Data["ciphertext"]},
+		"plaintext":   {"ciphertext_a": a.Data["ciphertext"], "vector": testVector(3)},
+	} {
+		resp := testRequest(t, b, s, logical.UpdateOperation, "compare", data)
+		estimated := resp.Data["estimated_distance"].(float64)
+		maxError := resp.Data["max_error"].(float64)
+		if maxError <= 0 || math.Abs(estimated-plaintextDistance) > maxError {
+			t.Errorf("%s: estimated_distance = %v, want %v ± %v", name, estimated, plaintextDistance, maxError)
+		}
+		cosine := resp.Data["cosine_similarity"].(float64)
+		cosineError := resp.Data["cosine_max_error"].(float64)
+		if math.Abs(cosine-plaintextCosine) > cosineError {
+			t.Errorf("%s: cosine_similarity = %v, want %v ± %v", name, cosine, plaintextCosine, cosineError)
+		}
+	}
+
+	// A ciphertext compared with itself is at distance 0.
+	resp := testRequest(t, b, s, logical.UpdateOperation, "compare", map[string]interface{}{
+		"ciphertext_a": a.Data["ciphertext"],
+		"ciphertext_b": a.Data["ciphertext"],
+	})
+	if resp.Data["estimated_distance"] != 0.0 || math.Abs(resp.Data["cosine_similarity"].(float64)-1) > 1e-12 {
+		t.Errorf("self comparison: %v", resp.Data)
+	}
+}
+
+func TestCompareRejects(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/nocompare", map[string]interface{}{
+		"allowed_operations": "encrypt",
+	})
+
+	for name, req := range map[string]struct {
+		path string
+		data map[string]interface{}
+	}{
+		"neither":   {"compare", map[string]interface{}{"ciphertext_a": testVector(0)}},
+		"both":      {"compare", map[string]interface{}{"ciphertext_a": testVector(0), "ciphertext_b": testVector(0), "vector": testVector(0)}},
+		"dimension": {"compare", map[string]interface{}{"ciphertext_a": []interface{}{1.0}, "ciphertext_b": testVector(0)}},
+		"role":      {"compare/nocompare", map[string]interface{}{"ciphertext_a": testVector(0), "vector": testVector(0)}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      req.path,
+			Data:      req.data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: compare succeeded, want error", name)
+		}
+	}
+}
+
+func TestCosineMaxError(t *testing.T) {
+	if got := cosineMaxError(10, 0); got != 0 {
+		t.Errorf("without noise: %v", got)
+	}
+	if got := cosineMaxError(1, 0.5); got != 2 {
+		t.Errorf("noise may dominate: %v", got)
+	}
+	if got, want := cosineMaxError(3, 1), math.Asin(0.5); math.Abs(got-want) > 1e-15 {
+		t.Errorf("cosineMaxError(3, 1) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// compromiseStoragePath holds the most recent compromise incident.
+	compromiseStoragePath = "compromise/incident"
+
+	// Actions for ciphertexts stored under the compromised key.
+	storedKeep  = "keep"
+	storedPurge = "purge"
+
+	// Incident statuses, in order.
+	compromiseDisabled = "disabled"
+	compromiseRekeying = "rekeying"
+	compromiseComplete = "complete"
+)
+
+// compromiseIncident tracks one run of the key-compromise playbook.
+type compromiseIncident struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+	Status string `json:"status"`
+
+	StartedAt   time.Time  `json:"started_at"`
+	RotatedAt   time.Time  `json:"rotated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// PreviousKeyID and NewKeyID are the key_ids of the mount key before
+	// and after rotation.
+	PreviousKeyID string `json:"previous_key_id"`
+	NewKeyID      string `json:"new_key_id"`
+
+	// StoredAction is what the re-keying job does with ciphertexts stored
+	// under the compromised key: storedKeep or storedPurge.
+	StoredAction string `json:"stored_action"`
+	Purged       int    `json:"purged"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// responseData renders the incident for API responses.
+func (c *compromiseIncident) responseData() map[string]interface{} {
+	completedAt := ""
+	if c.CompletedAt != nil {
+		completedAt = c.CompletedAt.Format(time.RFC3339)
+	}
+	rotatedAt := ""
+	if !c.RotatedAt.IsZero() {
+		rotatedAt = c.RotatedAt.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"id":              c.ID,
+		"reason":          c.Reason,
+		"status":          c.Status,
+		"started_at":      c.StartedAt.Format(time.RFC3339),
+		"rotated_at":      rotatedAt,
+		"completed_at":    completedAt,
+		"previous_key_id": c.PreviousKeyID,
+		"new_key_id":      c.NewKeyID,
+		"stored_action":   c.StoredAction,
+		"purged":          c.Purged,
+		"last_error":      c.LastError,
+	}
+}
+
+// pathCompromise returns the path configuration for config/compromise and
+// its status.
+func (b *vectorBackend) pathCompromise() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/compromise",
+			Fields: map[string]*framework.FieldSchema{
+				"reason": {
+					Type:        framework.TypeString,
+					Description: "Free-form description of the incident, recorded with it.",
+				},
+				"stored_ciphertexts": {
+					Type:          framework.TypeString,
+					Description:   "What to do with ciphertexts stored under the compromised key: 'keep' or 'purge'.",
+					Default:       storedKeep,
+					AllowedValues: []interface{}{storedKeep, storedPurge},
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCompromise,
+					Summary:  "Run the key-compromise playbook: disable, rotate and re-key.",
+				},
+			},
+			HelpSynopsis:    pathCompromiseHelpSyn,
+			HelpDescription: pathCompromiseHelpDesc,
+		},
+		{
+			Pattern: "config/compromise/status",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleCompromiseStatus,
+					Summary:  "Report the progress of the most recent compromise playbook.",
+				},
+			},
+			HelpSynopsis:    pathCompromiseStatusHelpSyn,
+			HelpDescription: pathCompromiseStatusHelpDesc,
+		},
+	}
+}
+
+// handleCompromise disables the current key, rotates to a new one with the
+// same parameters, and schedules the re-keying of stored ciphertexts.
+func (b *vectorBackend) handleCompromise(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	action := data.Get("stored_ciphertexts").(string)
+	if action != storedKeep && action != storedPurge {
+		return nil, fmt.Errorf("stored_ciphertexts must be %q or %q", storedKeep, storedPurge)
+	}
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	// Taken before the kill-switch, so a conflict leaves the key as it was.
+	release, err := b.acquireRotationLock(ctx, req, "compromise", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	before, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: the kill-switch. If anything below fails, the compromised
+	// key stays disabled rather than in service.
+	if _, err := b.handleKeyDisable(ctx, req, data); err != nil {
+		return nil, fmt.Errorf("disable key: %w", err)
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	previousKeyID, err := b.requestKeyID(req, nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+	incident := &compromiseIncident{
+		ID:            id,
+		Reason:        data.Get("reason").(string),
+		Status:        compromiseDisabled,
+		StartedAt:     time.Now().UTC(),
+		PreviousKeyID: previousKeyID,
+		StoredAction:  action,
+	}
+	// A purge still pending from an earlier incident must not be dropped;
+	// this incident's cutoff covers everything it would have deleted.
+	if prev, err := b.readCompromise(ctx, req.Storage); err != nil {
+		return nil, err
+	} else if prev != nil && prev.Status == compromiseRekeying && prev.StoredAction == storedPurge {
+		incident.StoredAction = storedPurge
+	}
+	if err := putStorageJSON(ctx, req.Storage, compromiseStoragePath, incident); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-compromised", "incident_id", incident.ID, "previous_key_id", previousKeyID)
+	b.Logger().Warn("key compromise playbook started; key disabled",
+		"incident_id", incident.ID, "client_id", req.ClientToken)
+
+	// Step 2: rotate to a fresh key with the same parameters.
+	next := &rotationConfig{
+		Dimension:           cfg.Dimension,
+		ScalingFactor:       cfg.ScalingFactor,
+		ApproximationFactor: cfg.ApproximationFactor,
+		MinNoiseRadius:      cfg.MinNoiseRadius,
+		NoiseMask:           cfg.NoiseMask,
+		NoiseVariance:       cfg.NoiseVariance,
+		EmbeddingModel:      cfg.EmbeddingModel,
+		RequireModel:        cfg.RequireModel,
+		Split:               cfg.Split,
+		PortableArithmetic:  cfg.PortableArithmetic,
+		Derived:             cfg.Derived,
+	}
+	if err := checkKeyPolicy(ctx, req.Storage, settings, next); err != nil {
+		return nil, err
+	}
+	if _, err := b.installKey(ctx, req.Storage, next, nil); err != nil {
+		incident.LastError = err.Error()
+		if perr := putStorageJSON(ctx, req.Storage, compromiseStoragePath, incident); perr != nil {
+			b.Logger().Warn("failed to record compromise incident", "error", perr)
+		}
+		return nil, fmt.Errorf("rotate key: %w", err)
+	}
+	// The compromised key must not stay available to key_version pins.
+	if err := b.deleteKeyVersion(ctx, req.Storage, cfg.version()); err != nil {
+		return nil, fmt.Errorf("delete compromised key version: %w", err)
+	}
+	incident.RotatedAt = time.Now().UTC()
+	if incident.NewKeyID, err = b.requestKeyID(req, nil, next); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-rotated", "incident_id", incident.ID, "new_key_id", incident.NewKeyID)
+	after, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "compromise", before, after); err != nil {
+		return nil, err
+	}
+
+	// Step 3: re-key stored ciphertexts. The periodic function runs the
+	// job, so it survives restarts and retries on failure.
+	incident.Status = compromiseRekeying
+	if incident.StoredAction == storedKeep {
+		b.completeCompromise(ctx, incident)
+	}
+	if err := putStorageJSON(ctx, req.Storage, compromiseStoragePath, incident); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: incident.responseData(),
+	}
+	addWarning(resp, warnCompromisedCopies, "ciphertexts held outside the mount were produced under the compromised key; re-encrypt them from source plaintext under the new key")
+	return resp, nil
+}
+
+// handleCompromiseStatus returns the most recent incident.
+func (b *vectorBackend) handleCompromiseStatus(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	incident, err := b.readCompromise(ctx, req.Storage)
+	if err != nil || incident == nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: incident.responseData(),
+	}, nil
+}
+
+// readCompromise retrieves the most recent incident, or nil if none.
+func (b *vectorBackend) readCompromise(ctx context.Context, storage logical.Storage) (*compromiseIncident, error) {
+	var incident compromiseIncident
+	found, err := getStorageJSON(ctx, storage, compromiseStoragePath, &incident)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// runCompromiseJob advances a pending re-keying job: it purges the
+// ciphertexts stored before the rotation. Called from the periodic function.
+func (b *vectorBackend) runCompromiseJob(ctx context.Context, storage logical.Storage) error {
+	incident, err := b.readCompromise(ctx, storage)
+	if err != nil || incident == nil || incident.Status != compromiseRekeying {
+		return err
+	}
+
+	// Everything stored before the rotation, under the mount key or any
+	// role's derived key, was produced from the compromised seed.
+	ids, err := b.matchingCiphertextIDs(ctx, storage, &ciphertextFilter{CreatedBefore: incident.RotatedAt})
+	if err == nil {
+		for _, id := range ids {
+			if err = b.deleteCiphertext(ctx, storage, id); err != nil {
+				break
+			}
+			incident.Purged++
+		}
+	}
+	if err != nil {
+		incident.LastError = err.Error()
+	} else {
+		incident.LastError = ""
+		b.completeCompromise(ctx, incident)
+	}
+	if perr := putStorageJSON(ctx, storage, compromiseStoragePath, incident); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// completeCompromise marks the incident complete and announces it.
+func (b *vectorBackend) completeCompromise(ctx context.Context, incident *compromiseIncident) {
+	now := time.Now().UTC()
+	incident.Status = compromiseComplete
+	incident.CompletedAt = &now
+	b.emitEvent(ctx, "key-compromise-complete", "incident_id", incident.ID,
+		"purged", fmt.Sprint(incident.Purged))
+	b.Logger().Info("key compromise playbook complete",
+		"incident_id", incident.ID, "purged", incident.Purged)
+}
+
+// Help text constants for the compromise paths.
+const pathCompromiseHelpSyn = `Run the key-compromise playbook in one operation.`
+
+const pathCompromiseHelpDesc = `
+Implements the key-compromise runbook as a single operation:
+
+  1. disabled - The kill-switch is set on the compromised key, and every
+                node zeroizes its matrices. If a later step fails, the key
+                stays disabled.
+  2. rotated  - A new key is generated with the same dimension and SAP
+                parameters, and starts enabled.
+  3. rekeying - Ciphertexts stored in the mount under the compromised key
+                are handled per stored_ciphertexts:
+                  keep  - Left in place (default). They no longer match
+                          ciphertexts of the new key.
+                  purge - Deleted by a job run from the periodic function,
+                          about once a minute, retrying on failure.
+  4. complete
+
+Events are emitted at each step (vector-dpe/key-compromised,
+vector-dpe/key-rotated and vector-dpe/key-compromise-complete) when Vault
+has events enabled. Track progress with config/compromise/status.
+
+The mount cannot reach ciphertexts held in vector databases. Re-encrypt
+them from source plaintext under the new key; previous_key_id identifies
+the compromised key.
+
+Input:
+  reason             - Recorded with the incident
+  stored_ciphertexts - "keep" (default) or "purge"
+
+This path requires sudo.
+
+Example:
+  vault write vector/config/compromise reason="seed exposed in logs" stored_ciphertexts=purge
+`
+
+const pathCompromiseStatusHelpSyn = `Report the most recent key-compromise incident.`
+
+const pathCompromiseStatusHelpDesc = `
+Returns the most recent incident started with config/compromise:
+
+  id              - Incident ID, also carried by its events
+  status          - disabled, rekeying or complete
+  previous_key_id - key_id of the compromised key
+  new_key_id      - key_id of the replacement key
+  purged          - Stored ciphertexts deleted so far
+  last_error      - Why the re-keying job last failed, if it did
+
+Example:
+  vault read vector/config/compromise/status
+`
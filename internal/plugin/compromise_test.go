@@ -0,0 +1,104 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCompromisePlaybook(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 3.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), testVector(1)},
+		"ids":     "old-1,old-2",
+	})
+	oldKeyID := testRequest(t, b, s, logical.ReadOperation, "ciphertext/old-1", nil).Data["key_id"]
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/compromise", map[string]interface{}{
+		"reason":             "seed exposed",
+		"stored_ciphertexts": "purge",
+	})
+	if resp.Data["status"] != compromiseRekeying {
+		t.Errorf("status = %v, want %s", resp.Data["status"], compromiseRekeying)
+	}
+	if resp.Data["previous_key_id"] != oldKeyID {
+		t.Errorf("previous_key_id = %v, want %v", resp.Data["previous_key_id"], oldKeyID)
+	}
+	if resp.Data["new_key_id"] == oldKeyID {
+		t.Error("new_key_id equals the compromised key's")
+	}
+
+	// The new key is enabled, keeps the parameters, and differs.
+	cfg, err := b.readConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Dimension != testDimension || cfg.ScalingFactor != 3.0 {
+		t.Errorf("rotated config = %+v, want the previous parameters", cfg)
+	}
+	time.Sleep(time.Millisecond)
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(2),
+		"id":     "new-1",
+	})
+
+	if err := b.runCompromiseJob(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "config/compromise/status", nil)
+	if resp.Data["status"] != compromiseComplete || resp.Data["purged"] != 2 {
+		t.Errorf("status = %v, purged = %v; want complete, 2", resp.Data["status"], resp.Data["purged"])
+	}
+	resp = testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+	if got := resp.Data["keys"].([]string); !slices.Equal(got, []string{"new-1"}) {
+		t.Errorf("keys after re-keying = %v, want [new-1]", got)
+	}
+}
+
+func TestCompromiseKeepStored(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "old-1",
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/compromise", nil)
+	if resp.Data["status"] != compromiseComplete {
+		t.Errorf("status = %v, want %s without a purge", resp.Data["status"], compromiseComplete)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Error("no warning about ciphertexts held outside the mount")
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "ciphertext/old-1", nil); resp == nil {
+		t.Error("stored ciphertext deleted with stored_ciphertexts=keep")
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config/versions/1", nil); resp != nil {
+		t.Errorf("compromised key version still available: %v", resp.Data)
+	}
+}
+
+func TestCompromiseRequiresKey(t *testing.T) {
+	b, s := getTestBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/compromise",
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("compromise without a key succeeded, want error")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// computeWorkerEnv names the environment variable that moves the mount's
+// matrix-vector multiplies into a worker process; see internal/compute.
+// Like mlockEnv it is set at plugin registration, never through the API.
+const computeWorkerEnv = "VECTOR_DPE_COMPUTE_WORKER"
+
+// computeWorkerEnabled reports whether the operator asked for a worker.
+func computeWorkerEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(computeWorkerEnv))
+	return enabled
+}
+
+// multiply sets outputs[i] to matrix·inputs[i], in the compute worker when
+// there is one. A worker that keeps failing, for example because it is
+// killed at its memory limit, fails the request with a retriable 503.
+func (c *coalescer) multiply(matrix *mat.Dense, inputs, outputs [][]float64) error {
+	if c.compute == nil {
+		dim, _ := matrix.Dims()
+		for i, input := range inputs {
+			mat.NewVecDense(dim, outputs[i]).MulVec(matrix, mat.NewVecDense(dim, input))
+		}
+		return nil
+	}
+	if err := c.compute.Multiply(matrix, inputs, outputs); err != nil {
+		return logical.CodedError(http.StatusServiceUnavailable, err.Error()+"; retry later")
+	}
+	return nil
+}
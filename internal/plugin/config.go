@@ -5,10 +5,12 @@ package plugin
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -32,29 +34,28 @@ const (
 	memoryWarningThreshold = 100 * 1024 * 1024
 )
 
-// pathConfig returns the path configuration for config/rotate and config/root.
+// pathConfig returns the path configuration for config, config/rotate and
+// config/root.
 func (b *vectorBackend) pathConfig() []*framework.Path {
-	var paths []*framework.Path
+	paths := []*framework.Path{
+		{
+			Pattern: "config$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleConfigRead,
+					Summary:  "Read the current key's parameters, without its seed.",
+				},
+			},
+			HelpSynopsis:    pathConfigReadHelpSyn,
+			HelpDescription: pathConfigReadHelpDesc,
+		},
+	}
+	fields := keyFields()
+	fields["expected_version"] = expectedVersionField
 	for _, pattern := range []string{"config/rotate", "config/root"} {
 		paths = append(paths, &framework.Path{
 			Pattern: pattern,
-			Fields: map[string]*framework.FieldSchema{
-				"dimension": {
-					Type:        framework.TypeInt,
-					Description: "Dimension of the embedding vectors (e.g., 1536 for OpenAI).",
-					Default:     defaultDimension,
-				},
-				"scaling_factor": {
-					Type:        framework.TypeFloat,
-					Description: "Scaling factor (s) for the SAP scheme. Must be positive.",
-					Default:     defaultScale,
-				},
-				"approximation_factor": {
-					Type:        framework.TypeFloat,
-					Description: "Noise factor (β) for the SAP scheme. Higher = more security, less accuracy.",
-					Default:     defaultApproximation,
-				},
-			},
+			Fields:  fields,
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.CreateOperation: &framework.PathOperation{
 					Callback: b.handleConfigRotate,
@@ -73,18 +74,113 @@ func (b *vectorBackend) pathConfig() []*framework.Path {
 	return paths
 }
 
+// keyFields returns the schema of the key parameters accepted by
+// config/rotate and config/ceremony/start.
+func keyFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"dimension": {
+			Type:        framework.TypeInt,
+			Description: "Dimension of the embedding vectors (e.g., 1536 for OpenAI).",
+			Default:     defaultDimension,
+		},
+		"scaling_factor": {
+			Type:        framework.TypeFloat,
+			Description: "Scaling factor (s) for the SAP scheme. Must be positive.",
+			Default:     defaultScale,
+		},
+		"approximation_factor": {
+			Type:        framework.TypeFloat,
+			Description: "Noise factor (β) for the SAP scheme. Higher = more security, less accuracy.",
+			Default:     defaultApproximation,
+		},
+		"min_noise_radius": {
+			Type:        framework.TypeFloat,
+			Description: "Absolute floor on the noise radius, independent of scaling_factor. 0 disables the floor.",
+			Default:     0.0,
+		},
+		"noise_mask": {
+			Type:        framework.TypeString,
+			Description: "Plaintext components that receive noise, as zero-based inclusive ranges (e.g. '0-255'). Empty perturbs every component.",
+		},
+		"noise_variance": {
+			Type:        framework.TypeSlice,
+			Description: "Diagonal covariance estimate of the corpus, one positive variance per component, to scale the noise per component. Empty is isotropic noise.",
+		},
+		"expires_at": {
+			Type:        framework.TypeString,
+			Description: "RFC 3339 time after which the new key refuses encryption. Empty means no deadline.",
+		},
+		"embedding_model": {
+			Type:        framework.TypeString,
+			Description: "Identifier of the embedding model whose vectors the new key encrypts (e.g. 'text-embedding-3-small').",
+		},
+		"require_model": {
+			Type:        framework.TypeBool,
+			Description: "Refuse encryption requests that do not pass a 'model' matching embedding_model.",
+		},
+		"split": {
+			Type:        framework.TypeBool,
+			Description: "Generate the key as the product of two factors that config/split/export hands to two decryption parties.",
+		},
+		"portable_arithmetic": {
+			Type:        framework.TypeBool,
+			Description: "Generate the matrix and encrypt with arithmetic that is bit-identical on every architecture (slower matrix generation). Cannot be combined with split.",
+		},
+		"derived": {
+			Type:        framework.TypeBool,
+			Description: "Only encrypt under a derivation context (the request's 'context', or its alias 'derivation_context', or a role's derivation_context), each with a matrix derived by HKDF from the seed. Cannot be combined with split.",
+		},
+		"exportable": {
+			Type:        framework.TypeBool,
+			Description: "Allow config/export to return the new key's seed, for backup. Cannot be changed once the key exists.",
+		},
+	}
+}
+
 // handleConfigRotate generates a new seed and stores the configuration.
 func (b *vectorBackend) handleConfigRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	dimension, err := parseDimension(data.Get("dimension"))
+	cfg, expiresAt, err := b.parseKeyConfig(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	release, err := b.acquireRotationLock(ctx, req, "rotate", data.Get("expected_version").(int))
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	before, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	lifecycle, err := b.installKey(ctx, req.Storage, cfg, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	after, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "rotate", before, after); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-rotated", "key_version", strconv.Itoa(cfg.version()), "identity", callerIdentity(req))
+	return keyResponse(cfg, lifecycle), nil
+}
+
+// parseKeyConfig validates the key parameters of a request, returning the
+// key configuration without a seed and the key's expiry.
+func (b *vectorBackend) parseKeyConfig(ctx context.Context, req *logical.Request, data *framework.FieldData) (*rotationConfig, *time.Time, error) {
+	dimension, err := parseDimension(data.Get("dimension"))
+	if err != nil {
+		return nil, nil, err
+	}
 	if dimension <= 0 {
-		return nil, fmt.Errorf("dimension must be positive")
+		return nil, nil, fmt.Errorf("dimension must be positive")
 	}
 	// Enforce DoS protection limit.
 	if dimension > MaxDimension {
-		return nil, fmt.Errorf("dimension %d exceeds maximum allowed %d", dimension, MaxDimension)
+		return nil, nil, fmt.Errorf("dimension %d exceeds maximum allowed %d", dimension, MaxDimension)
 	}
 
 	// Resource Awareness: Check estimated memory usage.
@@ -97,56 +193,235 @@ func (b *vectorBackend) handleConfigRotate(ctx context.Context, req *logical.Req
 
 	scalingFactor, err := coerceFloat(data.Get("scaling_factor"))
 	if err != nil {
-		return nil, fmt.Errorf("invalid scaling_factor: %w", err)
+		return nil, nil, fmt.Errorf("invalid scaling_factor: %w", err)
 	}
 	if scalingFactor <= 0 {
-		return nil, fmt.Errorf("scaling_factor must be positive (got %v)", scalingFactor)
+		return nil, nil, fmt.Errorf("scaling_factor must be positive (got %v)", scalingFactor)
 	}
 
 	approximationFactor, err := coerceFloat(data.Get("approximation_factor"))
 	if err != nil {
-		return nil, fmt.Errorf("invalid approximation_factor: %w", err)
+		return nil, nil, fmt.Errorf("invalid approximation_factor: %w", err)
 	}
 	if approximationFactor < 0 {
-		return nil, fmt.Errorf("approximation_factor must be non-negative (got %v)", approximationFactor)
+		return nil, nil, fmt.Errorf("approximation_factor must be non-negative (got %v)", approximationFactor)
 	}
 
-	// Generate cryptographically secure seed.
-	seed := make([]byte, seedLength)
-	if _, err := rand.Read(seed); err != nil {
-		return nil, fmt.Errorf("generate seed: %w", err)
+	minNoiseRadius, err := coerceFloat(data.Get("min_noise_radius"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid min_noise_radius: %w", err)
+	}
+	if minNoiseRadius < 0 || math.IsNaN(minNoiseRadius) || math.IsInf(minNoiseRadius, 0) {
+		return nil, nil, fmt.Errorf("min_noise_radius must be a non-negative finite number (got %v)", minNoiseRadius)
+	}
+
+	noiseMask, err := parseComponentRanges(data.Get("noise_mask").(string), dimension)
+	if err != nil {
+		return nil, nil, err
+	}
+	noiseVariance, err := parseNoiseVariance(data.Get("noise_variance"), dimension)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(noiseMask) > 0 && len(noiseVariance) > 0 {
+		return nil, nil, fmt.Errorf("noise_mask and noise_variance cannot be combined")
+	}
+
+	expiresAt, err := parseExpiresAt(data.Get("expires_at").(string), time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	embeddingModel := strings.TrimSpace(data.Get("embedding_model").(string))
+	requireModel := data.Get("require_model").(bool)
+	if requireModel && embeddingModel == "" {
+		return nil, nil, fmt.Errorf("require_model requires embedding_model")
+	}
+
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	cfg := &rotationConfig{
-		Seed:                base64.StdEncoding.EncodeToString(seed),
 		Dimension:           dimension,
 		ScalingFactor:       scalingFactor,
 		ApproximationFactor: approximationFactor,
+		MinNoiseRadius:      minNoiseRadius,
+		NoiseMask:           noiseMask,
+		NoiseVariance:       noiseVariance,
+		EmbeddingModel:      embeddingModel,
+		RequireModel:        requireModel,
+		Split:               data.Get("split").(bool),
+		PortableArithmetic:  data.Get("portable_arithmetic").(bool),
+		Derived:             data.Get("derived").(bool),
+		Exportable:          data.Get("exportable").(bool),
+	}
+	if cfg.Split && cfg.PortableArithmetic {
+		return nil, nil, fmt.Errorf("split and portable_arithmetic cannot be combined")
+	}
+	if cfg.Split && cfg.Derived {
+		return nil, nil, fmt.Errorf("split and derived cannot be combined")
+	}
+	if err := checkKeyPolicy(ctx, req.Storage, settings, cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, expiresAt, nil
+}
+
+// keyResponse reports the parameters of a newly installed key.
+func keyResponse(cfg *rotationConfig, lifecycle *keyLifecycle) *logical.Response {
+	resp := &logical.Response{
+		Data: keyData(cfg, lifecycle),
+	}
+	if estimatedMemory := int64(cfg.Dimension) * int64(cfg.Dimension) * 8; estimatedMemory > memoryWarningThreshold {
+		addWarning(resp, warnLargeMemoryConfig, fmt.Sprintf(
+			"Dimension %d requires approx %d MB of memory for the matrix.",
+			cfg.Dimension, estimatedMemory/1024/1024))
+	}
+	return resp
+}
+
+// keyData renders the parameters of the key cfg, never its seed.
+func keyData(cfg *rotationConfig, lifecycle *keyLifecycle) map[string]interface{} {
+	return map[string]interface{}{
+		"dimension":            cfg.Dimension,
+		"scaling_factor":       cfg.ScalingFactor,
+		"approximation_factor": cfg.ApproximationFactor,
+		"min_noise_radius":     cfg.MinNoiseRadius,
+		"noise_radius":         cfg.noiseRadius(),
+		"noise_mask":           cfg.NoiseMask.String(),
+		"noise_whitened":       len(cfg.NoiseVariance) > 0,
+		"expires_at":           lifecycle.responseData()["expires_at"],
+		"embedding_model":      cfg.EmbeddingModel,
+		"require_model":        cfg.RequireModel,
+		"seed_source":          cfg.SeedSource,
+		"split":                cfg.Split,
+		"portable_arithmetic":  cfg.PortableArithmetic,
+		"derived":              cfg.Derived,
+		"exportable":           cfg.Exportable,
+		"key_version":          cfg.version(),
+		"created_at":           cfg.createdAt(),
+	}
+}
+
+// handleConfigRead returns the current key's parameters, so clients can
+// check them without attempting an encryption. The seed is never returned.
+func (b *vectorBackend) handleConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	lifecycle, err := b.readLifecycle(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := b.requestKeyID(req, nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+	data := keyData(cfg, lifecycle)
+	data["key_id"] = keyID
+	data["disabled"] = lifecycle.Disabled
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// installKey generates a fresh seed for cfg and stores it as the current
+// key, with a new lifecycle expiring at expiresAt (nil for never).
+//
+// The new matrix is generated before the key is stored and without holding
+// matrixLock, so requests keep being served under the old key meanwhile.
+// The switch then happens under one lock acquisition, and the first request
+// after rotation pays no generation cost. The old matrix is retired, so
+// requests still holding it finish before it is zeroed.
+func (b *vectorBackend) installKey(ctx context.Context, storage logical.Storage, cfg *rotationConfig, expiresAt *time.Time) (*keyLifecycle, error) {
+	settings, err := b.readSettings(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	seed, source, err := b.randomBytes(seedLength, settings.RequireExternalEntropy)
+	if err != nil {
+		return nil, fmt.Errorf("generate seed: %w", err)
+	}
+	cfg.SeedSource = source
+	return b.installSeed(ctx, storage, cfg, seed, expiresAt)
+}
+
+// installSeed stores seed, which it zeroes, as the current key with the
+// parameters of cfg; see installKey.
+func (b *vectorBackend) installSeed(ctx context.Context, storage logical.Storage, cfg *rotationConfig, seed []byte, expiresAt *time.Time) (*keyLifecycle, error) {
+	cfg.Seed = base64.StdEncoding.EncodeToString(seed)
+	matrix, err := b.loadOrGenerateKeyMatrix(cfg, seed)
+	zeroBytes(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.rotateSinks(ctx, storage, cfg); err != nil {
+		zeroMatrix(matrix)
+		return nil, err
 	}
 
-	if err := b.writeConfig(ctx, req.Storage, cfg); err != nil {
+	now := time.Now().UTC()
+	cfg.CreatedAt = &now
+
+	// The replaced key stays in the keyring for clients pinning it.
+	b.keyringLock.Lock()
+	defer b.keyringLock.Unlock()
+	if err := b.archiveCurrentKey(ctx, storage, cfg); err != nil {
+		zeroMatrix(matrix)
+		return nil, err
+	}
+	if err := b.writeConfig(ctx, storage, cfg); err != nil {
+		zeroMatrix(matrix)
+		return nil, err
+	}
+	// Lifecycle state belongs to the previous key; start the new key afresh.
+	lifecycle := &keyLifecycle{ExpiresAt: expiresAt}
+	if err := b.writeLifecycle(ctx, storage, lifecycle); err != nil {
+		zeroMatrix(matrix)
 		return nil, err
 	}
 
-	// Invalidate cache - the Invalidate callback will also be triggered by Vault,
-	// but we do it explicitly here for immediate effect.
+	// Other nodes learn of the new key through the Invalidate callback;
+	// this node switches to it directly.
 	b.matrixLock.Lock()
 	b.invalidateCacheLocked()
+	b.cachedMatrix = matrix
+	b.cachedConfig = cfg
 	b.matrixLock.Unlock()
+	return lifecycle, nil
+}
 
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"dimension":            dimension,
-			"scaling_factor":       scalingFactor,
-			"approximation_factor": approximationFactor,
-		},
+// KeyParams are the SAP parameters of a key stored with WriteKey.
+type KeyParams struct {
+	Dimension           int
+	ScalingFactor       float64
+	ApproximationFactor float64
+	MinNoiseRadius      float64
+}
+
+// WriteKey stores a key with a caller-chosen seed, replacing any current key.
+// config/rotate always generates a fresh random seed; WriteKey exists so test
+// fixtures can be deterministic. Call it before the backend serves requests
+// against storage, since cached keys are not invalidated.
+func WriteKey(ctx context.Context, storage logical.Storage, seed []byte, params KeyParams) error {
+	cfg := &rotationConfig{
+		Seed:                base64.StdEncoding.EncodeToString(seed),
+		Dimension:           params.Dimension,
+		ScalingFactor:       params.ScalingFactor,
+		ApproximationFactor: params.ApproximationFactor,
+		MinNoiseRadius:      params.MinNoiseRadius,
 	}
-	if estimatedMemory > memoryWarningThreshold {
-		resp.AddWarning(fmt.Sprintf(
-			"Dimension %d requires approx %d MB of memory for the matrix.",
-			dimension, estimatedMemory/1024/1024))
+	if err := cfg.validate(); err != nil {
+		return err
 	}
-	return resp, nil
+	return putStorageJSON(ctx, storage, configStoragePath, cfg)
 }
 
 // configExists checks if configuration already exists (for ExistenceCheck).
@@ -175,6 +450,19 @@ func parseDimension(raw interface{}) (int, error) {
 }
 
 // Help text constants for the config path.
+const pathConfigReadHelpSyn = `Read the current key's parameters.`
+
+const pathConfigReadHelpDesc = `
+Returns the parameters of the current key, so clients can check the
+dimension and SAP parameters without attempting an encryption: dimension,
+scaling_factor, approximation_factor, min_noise_radius, noise_radius,
+noise_mask, noise_whitened, embedding_model, require_model, key_id, key_version, created_at (empty for
+keys created before it was recorded), expires_at, disabled, seed_source,
+split and exportable. The seed is never returned.
+
+Returns nothing until the first config/rotate.
+`
+
 const pathConfigHelpSyn = `Configure the encryption key and Scale-And-Perturb (SAP) parameters.`
 
 const pathConfigHelpDesc = `
@@ -189,14 +477,61 @@ Parameters:
   dimension           - Vector dimension (default: 1536, max: 8192)
   scaling_factor      - Scalar multiplier s (default: 1.0, must be > 0)
   approximation_factor - Noise factor β (default: 5.0, must be >= 0)
+  min_noise_radius    - Absolute noise radius floor (default: 0, disabled)
+  noise_mask          - Plaintext components that receive noise, as
+                        zero-based inclusive ranges such as '0-255,512-767'
+                        (default: all)
+  noise_variance      - Diagonal covariance estimate of the corpus, one
+                        variance per component, for whitened noise
+                        (default: none, isotropic; see config/fit-scale)
+  expires_at          - RFC 3339 time after which the new key refuses
+                        encryption (default: none)
+  embedding_model     - Identifier of the embedding model whose vectors
+                        the key encrypts (default: none)
+  require_model       - Refuse encryption requests that do not pass a
+                        'model' (default: false)
+  split               - Generate the key as the product of two factors,
+                        for threshold decryption (default: false; see
+                        config/split/export)
+  exportable          - Allow config/export to return the key's seed for
+                        backup (default: false; fixed for the key's life)
+  expected_version    - Only rotate if the current key version is this
+                        one (default: 0, no check; see
+                        config/rotation-lock)
 
 The encryption formula is: C = s * Q * v + λ
 
 Where λ is a random noise vector sampled uniformly from a ball of
-radius (s * β) / 4, providing probabilistic encryption.
+radius max((s * β) / 4, min_noise_radius), providing probabilistic
+encryption. To keep the noise fixed while tuning s for numeric headroom,
+set approximation_factor=0 and min_noise_radius to the desired radius.
+
+With noise_mask, the same ball is sampled over the masked plaintext
+components only: C = s * Q * v + Q * μ, where μ is zero outside the mask.
+The unmasked components (padding, say) are encrypted without noise, and
+the whole noise budget lands on the masked ones, so each is perturbed
+more heavily than without a mask. Differences between encryptions of one
+plaintext stay in a fixed subspace, which a holder of many such pairs can
+learn; the strict hardening profile refuses masks.
+
+With noise_variance, the ball is stretched per plaintext component by
+w_i = sqrt(v_i / mean(v)): C = s * Q * v + Q * W * u. Components that vary
+widely across the corpus receive more noise and nearly constant ones less,
+which suits strongly anisotropic embedding models better than the round
+ball. The scales have mean square 1, so the expected squared noise norm is
+unchanged; the largest error grows by the largest scale, which the error
+bounds reported by other endpoints include. The variance cannot be
+combined with noise_mask.
+
+When embedding_model is set, encryption requests that pass a 'model' field
+naming a different model are refused, so vectors from one model are never
+encrypted under a key meant for another model of the same dimension. With
+require_model, requests must pass 'model'.
 
 WARNING: Calling this endpoint rotates the key. All previously encrypted
-vectors will no longer be searchable with the new key.
+vectors will no longer be searchable with the new key. Because it is
+destructive, it requires the sudo capability; attach a control_group or
+mfa_methods stanza to its policy to require approval.
 `
 
 var _ = strings.TrimSpace // Ensure strings import is used
@@ -0,0 +1,393 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math"
+	mathrand "math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// devModeEnv names the environment variable that enables the debug/
+	// endpoints. They accept plaintext and echo measurements derived from
+	// it, so they are never registered on production servers.
+	devModeEnv = "VECTOR_DPE_DEV_MODE"
+
+	// defaultStressDuration and maxStressDuration bound a debug/stress run.
+	defaultStressDuration = 5 * time.Second
+	maxStressDuration     = time.Minute
+
+	// defaultStressWorkers and maxStressWorkers bound its concurrency.
+	defaultStressWorkers = 4
+	maxStressWorkers     = 64
+
+	// stressInvalidateInterval is the pause between cache invalidations.
+	stressInvalidateInterval = 5 * time.Millisecond
+)
+
+// devModeEnabled reports whether the plugin process runs in dev mode.
+func devModeEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(devModeEnv))
+	return enabled
+}
+
+// pathDebug returns the path configuration for debug/compare, or nil
+// outside dev mode.
+func (b *vectorBackend) pathDebug() []*framework.Path {
+	if !devModeEnabled() {
+		return nil
+	}
+	return []*framework.Path{
+		{
+			Pattern: "debug/compare",
+			Fields: map[string]*framework.FieldSchema{
+				"vector_a": {
+					Type:        framework.TypeSlice,
+					Description: "First plaintext vector (array of floats).",
+				},
+				"vector_b": {
+					Type:        framework.TypeSlice,
+					Description: "Second plaintext vector (array of floats).",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleDebugCompare,
+					Summary:  "Compare plaintext and encrypted distances of two vectors.",
+				},
+			},
+			HelpSynopsis:    pathDebugCompareHelpSyn,
+			HelpDescription: pathDebugCompareHelpDesc,
+		},
+		{
+			Pattern: "debug/stress",
+			Fields: map[string]*framework.FieldSchema{
+				"duration": {
+					Type:        framework.TypeDurationSecond,
+					Description: fmt.Sprintf("How long to run (default: %s, max: %s).", defaultStressDuration, maxStressDuration),
+					Default:     int(defaultStressDuration.Seconds()),
+				},
+				"workers": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Number of concurrent encrypting goroutines (max %d).", maxStressWorkers),
+					Default:     defaultStressWorkers,
+				},
+				"rotate": {
+					Type:        framework.TypeBool,
+					Description: "Also rotate the key (same parameters, new seed) between invalidations. Destroys the current key.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleDebugStress,
+					Summary:  "Encrypt concurrently while invalidating the matrix cache.",
+				},
+			},
+			HelpSynopsis:    pathDebugStressHelpSyn,
+			HelpDescription: pathDebugStressHelpDesc,
+		},
+	}
+}
+
+// handleDebugCompare encrypts two plaintexts and reports how well the
+// encrypted distance, corrected for the scaling factor, estimates the
+// plaintext distance.
+func (b *vectorBackend) handleDebugCompare(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return nil, fmt.Errorf("hardening_profile=strict: debug endpoints are disabled")
+	}
+
+	vectorA, err := parseVector(data.Get("vector_a"))
+	if err != nil {
+		return nil, fmt.Errorf("vector_a: %w", err)
+	}
+	vectorB, err := parseVector(data.Get("vector_b"))
+	if err != nil {
+		return nil, fmt.Errorf("vector_b: %w", err)
+	}
+
+	matrix, cfg, err := b.matrixForRole(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	encA, err := b.encryptVector(matrix, cfg, settings, vectorA)
+	if err != nil {
+		return nil, fmt.Errorf("vector_a: %w", err)
+	}
+	encB, err := b.encryptVector(matrix, cfg, settings, vectorB)
+	if err != nil {
+		return nil, fmt.Errorf("vector_b: %w", err)
+	}
+
+	plaintextDistance := euclideanDistance(vectorA, vectorB)
+	encryptedDistance := euclideanDistance(encA.Ciphertext, encB.Ciphertext)
+	estimated := encryptedDistance / cfg.ScalingFactor
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"plaintext_distance": plaintextDistance,
+			"encrypted_distance": encryptedDistance,
+			"estimated_distance": estimated,
+			"absolute_error":     math.Abs(estimated - plaintextDistance),
+			// Each ciphertext carries noise of norm at most r, so the
+			// corrected estimate is off by at most 2r/s.
+			"max_error": 2 * cfg.maxNoiseNorm() / cfg.ScalingFactor,
+		},
+	}, nil
+}
+
+// stressReport counts the outcomes of a debug/stress run.
+type stressReport struct {
+	encryptions   atomic.Int64
+	anomalies     atomic.Int64
+	invalidations atomic.Int64
+	rotations     atomic.Int64
+}
+
+// handleDebugStress hammers the encrypt path from several goroutines while
+// another invalidates the matrix cache, and optionally rotates the key, as
+// fast as it can. Run the plugin under the race detector to surface unsafe
+// sharing; the run itself reports encryptions that saw a matrix mid-zeroing.
+func (b *vectorBackend) handleDebugStress(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return nil, fmt.Errorf("hardening_profile=strict: debug endpoints are disabled")
+	}
+
+	duration := time.Duration(data.Get("duration").(int)) * time.Second
+	if duration <= 0 || duration > maxStressDuration {
+		return nil, fmt.Errorf("duration must be between 1s and %s (got %s)", maxStressDuration, duration)
+	}
+	workers := data.Get("workers").(int)
+	if workers <= 0 || workers > maxStressWorkers {
+		return nil, fmt.Errorf("workers must be between 1 and %d (got %d)", maxStressWorkers, workers)
+	}
+	rotate := data.Get("rotate").(bool)
+
+	if err := b.checkKeyUsable(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	if _, _, err := b.getMatrixAndConfig(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		report   stressReport
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		if ctx.Err() != nil {
+			// Storage calls fail once the run's deadline passes; that is
+			// the normal end of a run, not an error.
+			return
+		}
+		errOnce.Do(func() { firstErr = err })
+		cancel()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng, err := NewSecureRNG()
+			if err != nil {
+				fail(err)
+				return
+			}
+			for ctx.Err() == nil {
+				if err := b.stressEncrypt(ctx, req.Storage, rng, &report); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(stressInvalidateInterval)
+		defer ticker.Stop()
+		for n := 0; ; n++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			switch {
+			case rotate && n%2 == 1:
+				if err := b.stressRotate(ctx, req.Storage); err != nil {
+					fail(err)
+					return
+				}
+				report.rotations.Add(1)
+			case n%4 == 2:
+				// A lifecycle change on another node zeroizes without
+				// touching the disk cache.
+				b.invalidate(ctx, lifecycleStoragePath)
+				report.invalidations.Add(1)
+			default:
+				b.invalidate(ctx, configStoragePath)
+				report.invalidations.Add(1)
+			}
+		}
+	}()
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"duration":      duration.String(),
+			"workers":       workers,
+			"encryptions":   report.encryptions.Load(),
+			"anomalies":     report.anomalies.Load(),
+			"invalidations": report.invalidations.Load(),
+			"rotations":     report.rotations.Load(),
+		},
+	}
+	if n := report.anomalies.Load(); n > 0 {
+		b.Logger().Error("stress run observed inconsistent encryptions", "anomalies", n)
+		addWarning(resp, warnStressAnomalies, fmt.Sprintf("%d encryptions used a matrix that changed underneath them", n))
+	}
+	return resp, nil
+}
+
+// stressEncrypt performs one encryption under its own matrix lease, as a
+// separate request would, and checks that the matrix stayed intact: an
+// orthogonal Q preserves norms exactly, so ‖Q·v‖ ≠ ‖v‖ means the matrix was
+// zeroed or swapped while in use.
+func (b *vectorBackend) stressEncrypt(ctx context.Context, storage logical.Storage, rng *mathrand.Rand, report *stressReport) error {
+	leaseCtx, lease := withMatrixLease(ctx)
+	defer b.releaseLease(lease)
+
+	matrix, cfg, err := b.getMatrixAndConfig(leaseCtx, storage)
+	if err != nil {
+		return err
+	}
+
+	v := make([]float64, cfg.Dimension)
+	randomVector(rng, v, 1)
+	if _, err := b.encryptVector(matrix, cfg, defaultSettings(), v); err != nil {
+		return err
+	}
+	var rotated mat.VecDense
+	rotated.MulVec(matrix, mat.NewVecDense(len(v), v))
+	if math.Abs(mat.Norm(&rotated, 2)-1) > 1e-9 {
+		report.anomalies.Add(1)
+	}
+	report.encryptions.Add(1)
+	return nil
+}
+
+// stressRotate replaces the key with a fresh seed and the same parameters,
+// as config/rotate does.
+func (b *vectorBackend) stressRotate(ctx context.Context, storage logical.Storage) error {
+	cfg, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return errConfigNotInitialized
+	}
+	seed := make([]byte, seedLength)
+	if _, err := rand.Read(seed); err != nil {
+		return fmt.Errorf("generate seed: %w", err)
+	}
+	rotated := *cfg
+	rotated.Seed = base64.StdEncoding.EncodeToString(seed)
+	if err := b.writeConfig(ctx, storage, &rotated); err != nil {
+		return err
+	}
+	b.matrixLock.Lock()
+	b.invalidateCacheLocked()
+	b.matrixLock.Unlock()
+	return nil
+}
+
+// euclideanDistance returns the L2 distance between equal-length vectors.
+func euclideanDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// Help text constants for the debug path.
+const pathDebugCompareHelpSyn = `Compare plaintext and encrypted distances of two vectors (dev mode only).`
+
+const pathDebugCompareHelpDesc = `
+This endpoint is a one-call sanity check for QA. It takes two plaintext
+vectors, encrypts both under the mount key, and returns:
+
+  plaintext_distance - L2 distance between the plaintexts
+  encrypted_distance - L2 distance between the ciphertexts
+  estimated_distance - encrypted_distance / scaling_factor
+  absolute_error     - |estimated_distance - plaintext_distance|
+  max_error          - Worst-case error from noise, 2 * noise_radius / s
+
+The endpoint exists only when the plugin process runs with
+VECTOR_DPE_DEV_MODE=true. It handles plaintext directly and must never be
+enabled on a production server.
+
+Example:
+  vault write vector/debug/compare vector_a='[0.1, 0.2]' vector_b='[0.3, 0.4]'
+`
+
+const pathDebugStressHelpSyn = `Encrypt concurrently while invalidating the matrix cache (dev mode only).`
+
+const pathDebugStressHelpDesc = `
+This endpoint stress-tests the matrix cache. For the given duration it runs
+several goroutines that each fetch the matrix and encrypt a random vector,
+as independent requests would, while another goroutine invalidates the
+cache every few milliseconds, alternating between a key change and a
+lifecycle change. With rotate=true it also rotates the key between
+invalidations, keeping the current parameters.
+
+Each encryption checks that the matrix preserved the norm of its input. A
+matrix zeroed or replaced while in use fails the check and is counted in
+"anomalies", which should always be 0. Build the plugin with -race to have
+the race detector report unsafe sharing as well.
+
+Parameters:
+  duration - How long to run (default: 5s, max: 1m)
+  workers  - Concurrent encrypting goroutines (default: 4, max: 64)
+  rotate   - Also rotate the key; previous ciphertexts become unsearchable
+
+The endpoint exists only when the plugin process runs with
+VECTOR_DPE_DEV_MODE=true.
+
+Example:
+  vault write vector/debug/stress duration=10s workers=8
+`
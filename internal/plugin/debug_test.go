@@ -0,0 +1,76 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDebugCompareRequiresDevMode(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "debug/compare",
+		Data: map[string]interface{}{
+			"vector_a": testVector(0),
+			"vector_b": testVector(1),
+		},
+		Storage: s,
+	})
+	if !errors.Is(err, logical.ErrUnsupportedPath) {
+		t.Errorf("err = %v, want %v", err, logical.ErrUnsupportedPath)
+	}
+}
+
+func TestDebugCompare(t *testing.T) {
+	t.Setenv(devModeEnv, "true")
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 2.0,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "debug/compare", map[string]interface{}{
+		"vector_a": testVector(0),
+		"vector_b": testVector(1),
+	})
+
+	// Every component differs by 1.
+	if got, want := resp.Data["plaintext_distance"].(float64), math.Sqrt(testDimension); math.Abs(got-want) > 1e-9 {
+		t.Errorf("plaintext_distance = %v, want %v", got, want)
+	}
+	if got, bound := resp.Data["absolute_error"].(float64), resp.Data["max_error"].(float64); got > bound {
+		t.Errorf("absolute_error %v exceeds max_error %v", got, bound)
+	}
+}
+
+func TestDebugStress(t *testing.T) {
+	t.Setenv(devModeEnv, "true")
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "debug/stress", map[string]interface{}{
+		"duration": 1,
+		"workers":  4,
+		"rotate":   true,
+	})
+
+	if got := resp.Data["anomalies"].(int64); got != 0 {
+		t.Errorf("anomalies = %d, want 0", got)
+	}
+	if resp.Data["encryptions"].(int64) == 0 || resp.Data["invalidations"].(int64) == 0 || resp.Data["rotations"].(int64) == 0 {
+		t.Errorf("stress run did no work: %v", resp.Data)
+	}
+}
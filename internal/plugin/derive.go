@@ -0,0 +1,287 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/hkdf"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// deriveLabel separates derived seeds from other uses of the mount seed.
+	deriveLabel = "vector-dpe/derive/v1"
+
+	// hkdfDeriveLabel is the HKDF info prefix of the derived seeds of keys
+	// created with derived=true.
+	hkdfDeriveLabel = "vector-dpe/derive/hkdf/v1"
+
+	// maxRequestContextLength bounds the 'context' parameter of a request.
+	maxRequestContextLength = 256
+)
+
+// contextField is the per-request derivation context accepted by the
+// endpoints that encrypt or compare ciphertexts.
+var contextField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Derivation context (e.g. a tenant ID) the ciphertexts are bound to. Ciphertexts for different contexts are not comparable.",
+}
+
+// derivationContextField is 'derivation_context', an alias of 'context'
+// under the name that keys created with derived=true use for it.
+var derivationContextField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Alias of 'context'.",
+}
+
+// errDerivedKeyContext refuses requests without a derivation context under
+// a derived key.
+var errDerivedKeyContext = fmt.Errorf("the key is derived: requests need a derivation context, from 'context' (or its alias 'derivation_context') or the role's derivation_context")
+
+// requestContextParam returns the request's 'context', or its alias
+// 'derivation_context'. Passing both with different values is an error.
+func requestContextParam(data *framework.FieldData) (string, error) {
+	requestContext := data.Get("context").(string)
+	alias := data.Get("derivation_context").(string)
+	switch {
+	case alias == "":
+		return requestContext, nil
+	case requestContext == "":
+		return alias, nil
+	case alias != requestContext:
+		return "", fmt.Errorf("context and derivation_context differ; pass one of them")
+	}
+	return requestContext, nil
+}
+
+// deriveSeed derives the seed for a derivation context from the seed of
+// the key c. Distinct contexts yield independent matrices, so ciphertexts
+// from one tenant are not comparable with another's. Keys created with
+// derived=true use HKDF-SHA256; older keys keep the HMAC derivation their
+// ciphertexts were made with.
+func (c *rotationConfig) deriveSeed(seed []byte, derivationContext string) []byte {
+	if c.Derived {
+		return hkdfDeriveSeed(seed, derivationContext)
+	}
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(deriveLabel))
+	mac.Write([]byte{0})
+	mac.Write([]byte(derivationContext))
+	return mac.Sum(nil)
+}
+
+// derivedMatrixKind returns how the matrices of the key c's derivation
+// contexts are generated from their seeds. The matrices derived from a
+// split key are not split themselves.
+func (c *rotationConfig) derivedMatrixKind() matrixKind {
+	if c.PortableArithmetic {
+		return matrixPortable
+	}
+	return matrixOrthogonal
+}
+
+// hkdfDeriveSeed derives a 32-byte seed with HKDF-SHA256 from seed, with
+// the derivation context in the info string.
+func hkdfDeriveSeed(seed []byte, derivationContext string) []byte {
+	info := make([]byte, 0, len(hkdfDeriveLabel)+1+len(derivationContext))
+	info = append(info, hkdfDeriveLabel...)
+	info = append(info, 0)
+	info = append(info, derivationContext...)
+	derived := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, nil, info), derived); err != nil {
+		// HKDF-SHA256 only fails beyond 255 × 32 bytes of output.
+		panic(err)
+	}
+	return derived
+}
+
+// resolveDerivationContext returns the derivation context for a request made
+// under role, or "" for the mount key. Identity templates in the role's
+// context are populated from the requesting entity, so the binding comes from
+// the identity system and cannot be chosen by the client.
+func (b *vectorBackend) resolveDerivationContext(req *logical.Request, role *vectorRole) (string, error) {
+	if role == nil || role.DerivationContext == "" {
+		return "", nil
+	}
+	hasTemplating, err := framework.ValidateIdentityTemplate(role.DerivationContext)
+	if err != nil {
+		return "", err
+	}
+	if !hasTemplating {
+		return role.DerivationContext, nil
+	}
+	if req.EntityID == "" {
+		return "", fmt.Errorf("role derivation context requires a token with an identity entity")
+	}
+	resolved, err := framework.PopulateIdentityTemplate(role.DerivationContext, req.EntityID, b.System())
+	if err != nil {
+		return "", fmt.Errorf("populate derivation context: %w", err)
+	}
+	if resolved == "" {
+		// An empty context would silently collapse every tenant onto one key.
+		return "", fmt.Errorf("derivation context resolved to an empty value for this entity")
+	}
+	return resolved, nil
+}
+
+// requestDerivationContext returns the derivation context for a request
+// made under role that supplied requestContext as its 'context' parameter.
+// The request's context extends the role's rather than replacing it, so a
+// client can narrow the key its role grants but never select another
+// role's key.
+func (b *vectorBackend) requestDerivationContext(req *logical.Request, role *vectorRole, requestContext string) (string, error) {
+	derivationContext, err := b.resolveDerivationContext(req, role)
+	if err != nil || requestContext == "" {
+		return derivationContext, err
+	}
+	if len(requestContext) > maxRequestContextLength {
+		return "", fmt.Errorf("context exceeds %d bytes", maxRequestContextLength)
+	}
+	if strings.ContainsRune(requestContext, 0) {
+		return "", fmt.Errorf("context must not contain NUL characters")
+	}
+	// Role contexts cannot contain NUL, so the separator keeps a role
+	// context and a request context apart.
+	return derivationContext + "\x00" + requestContext, nil
+}
+
+// addRequestContext records a request's context on a JSON response, with
+// the key it selected, so the caller can keep both with the ciphertexts.
+func addRequestContext(data map[string]interface{}, requestContext, keyID string) {
+	if requestContext == "" {
+		return
+	}
+	data["context"] = requestContext
+	data["key_id"] = keyID
+}
+
+// matrixForRole returns the matrix and config to encrypt with for a request
+// made under role, refusing keys that may not encrypt.
+func (b *vectorBackend) matrixForRole(ctx context.Context, req *logical.Request, role *vectorRole) (*mat.Dense, *rotationConfig, error) {
+	if err := b.checkKeyUsable(ctx, req.Storage); err != nil {
+		return nil, nil, err
+	}
+	return b.roleMatrix(ctx, req, role)
+}
+
+// roleMatrix returns the matrix and config for a request made under role:
+// the mount matrix, or the role's derived matrix. Callers check the key's
+// lifecycle first.
+func (b *vectorBackend) roleMatrix(ctx context.Context, req *logical.Request, role *vectorRole) (*mat.Dense, *rotationConfig, error) {
+	derivationContext, err := b.resolveDerivationContext(req, role)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.contextMatrix(ctx, req.Storage, derivationContext)
+}
+
+// matrixForContext returns the matrix and config to encrypt with for a
+// resolved derivation context, refusing keys that may not encrypt.
+func (b *vectorBackend) matrixForContext(ctx context.Context, req *logical.Request, derivationContext string) (*mat.Dense, *rotationConfig, error) {
+	if err := b.checkKeyUsable(ctx, req.Storage); err != nil {
+		return nil, nil, err
+	}
+	return b.contextMatrix(ctx, req.Storage, derivationContext)
+}
+
+// contextMatrix returns the mount matrix for "", or the derived matrix of a
+// resolved derivation context. Callers check the key's lifecycle first.
+func (b *vectorBackend) contextMatrix(ctx context.Context, storage logical.Storage, derivationContext string) (*mat.Dense, *rotationConfig, error) {
+	if derivationContext != "" {
+		return b.getDerivedMatrix(ctx, storage, derivationContext)
+	}
+	matrix, cfg, err := b.getMatrixAndConfig(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Derived {
+		return nil, nil, errDerivedKeyContext
+	}
+	return matrix, cfg, nil
+}
+
+// getDerivedMatrix returns the cached matrix for a derivation context,
+// generating it on first use. It follows the same Check-Lock-Check pattern
+// as getMatrixAndConfig.
+func (b *vectorBackend) getDerivedMatrix(ctx context.Context, storage logical.Storage, derivationContext string) (*mat.Dense, *rotationConfig, error) {
+	key := derivedFlightKey(derivationContext)
+	b.matrixLock.RLock()
+	if cfg := b.cachedConfig; cfg != nil {
+		if cached := b.lru.get(key); cached != nil {
+			b.holdMatrixLocked(ctx, cached.matrix)
+			b.matrixLock.RUnlock()
+			recordMatrixCache(matrixCacheMemory, "derived", true)
+			return cached.matrix, cfg, nil
+		}
+	}
+	b.matrixLock.RUnlock()
+
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.matrixLock.Lock()
+	defer b.matrixLock.Unlock()
+
+	for {
+		if cfg := b.cachedConfig; cfg != nil {
+			if cached := b.lru.get(key); cached != nil {
+				b.holdMatrixLocked(ctx, cached.matrix)
+				recordMatrixCache(matrixCacheMemory, "derived", true)
+				return cached.matrix, cfg, nil
+			}
+		}
+		recordMatrixCache(matrixCacheMemory, "derived", false)
+
+		cfg := b.cachedConfig
+		matrix, retry, err := b.generateSharedLocked(ctx, storage, key, func() (*mat.Dense, error) {
+			if cfg == nil {
+				var err error
+				if cfg, err = b.readConfig(ctx, storage); err != nil {
+					return nil, err
+				}
+				if cfg == nil {
+					return nil, errConfigNotInitialized
+				}
+			}
+			seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+			if err != nil {
+				return nil, fmt.Errorf("decode seed: %w", err)
+			}
+			derived := cfg.deriveSeed(seed, derivationContext)
+			defer zeroBytes(derived)
+			return b.loadOrGenerate(derived, cfg.derivedMatrixKind(), cfg.Dimension)
+		}, func(matrix *mat.Dense) {
+			if b.cachedConfig == nil {
+				b.cachedConfig = cfg
+			}
+			b.lru.put(key, matrix, nil, settings.CacheMaxEntries, settings.CacheMaxBytes, b.retireMatrixLocked)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !retry {
+			return matrix, cfg, nil
+		}
+	}
+}
+
+// zeroMatrix overwrites the matrix's backing data.
+func zeroMatrix(m *mat.Dense) {
+	data := m.RawMatrix().Data
+	for i := range data {
+		data[i] = 0
+	}
+	unlockMatrix(m)
+}
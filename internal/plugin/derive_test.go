@@ -0,0 +1,133 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDeriveSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 32)
+	legacy := &rotationConfig{}
+	derived := &rotationConfig{Derived: true}
+
+	if !bytes.Equal(derived.deriveSeed(seed, "acme"), derived.deriveSeed(seed, "acme")) {
+		t.Error("HKDF derivation is not deterministic")
+	}
+	if bytes.Equal(derived.deriveSeed(seed, "acme"), derived.deriveSeed(seed, "globex")) {
+		t.Error("contexts share a derived seed")
+	}
+	// Keys created before derived=true keep their HMAC derivation.
+	if bytes.Equal(derived.deriveSeed(seed, "acme"), legacy.deriveSeed(seed, "acme")) {
+		t.Error("HKDF and HMAC derivations agree")
+	}
+	if got := len(derived.deriveSeed(seed, "acme")); got != 32 {
+		t.Errorf("derived seed of %d bytes, want 32", got)
+	}
+}
+
+func TestBackendDerivedKey(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+		"derived":   true,
+	})
+	if resp.Data["derived"] != true {
+		t.Errorf("derived not reported: %v", resp.Data)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "roles/acme", map[string]interface{}{
+		"derivation_context": "acme",
+	})
+
+	// Requests without a derivation context are refused.
+	for _, path := range []string{"encrypt/vector", "encrypt/batch"} {
+		data := map[string]interface{}{"vector": testVector(1), "vectors": []interface{}{testVector(1)}}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s without a context succeeded", path)
+		}
+	}
+
+	byContext := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":  testVector(1),
+		"context": "globex",
+	})
+	byRole := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/acme", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if byContext.Data["key_id"] == nil || byRole.Data["transform_id"] == byContext.Data["transform_id"] {
+		t.Errorf("tenants share a key: %v %v", byRole.Data, byContext.Data)
+	}
+
+	// derivation_context is an alias of context.
+	byAlias := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":             testVector(1),
+		"derivation_context": "globex",
+	})
+	if byAlias.Data["transform_id"] != byContext.Data["transform_id"] {
+		t.Errorf("derivation_context and context select different keys: %v %v", byAlias.Data, byContext.Data)
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector": testVector(1), "context": "globex", "derivation_context": "initech"},
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("differing context and derivation_context accepted")
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Data:      map[string]interface{}{"dimension": testDimension, "derived": true, "split": true},
+		Storage:   s,
+	}); err == nil {
+		t.Error("split and derived combined")
+	}
+}
+
+func TestDerivedMatrixLRU(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	for i := 0; i < defaultCacheMaxEntries; i++ {
+		if _, _, err := b.getDerivedMatrix(ctx, s, fmt.Sprintf("tenant-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// tenant-0 is used again, so tenant-1 is now the least recently used.
+	if _, _, err := b.getDerivedMatrix(ctx, s, "tenant-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.getDerivedMatrix(ctx, s, "tenant-new"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.matrixLock.RLock()
+	defer b.matrixLock.RUnlock()
+	if len(b.lru.entries) != defaultCacheMaxEntries {
+		t.Errorf("%d derived matrices cached, want %d", len(b.lru.entries), defaultCacheMaxEntries)
+	}
+	if _, ok := b.lru.entries[derivedFlightKey("tenant-0")]; !ok {
+		t.Error("recently used tenant-0 evicted")
+	}
+	if _, ok := b.lru.entries[derivedFlightKey("tenant-1")]; ok {
+		t.Error("least recently used tenant-1 kept")
+	}
+}
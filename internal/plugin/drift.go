@@ -0,0 +1,455 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// referenceStoragePrefix is the Vault storage prefix for reference vectors.
+	referenceStoragePrefix = "reference/"
+
+	// driftHistoryPath holds the recent drift check results.
+	driftHistoryPath = "reference-drift/history"
+
+	// maxReferences bounds the number of reference vectors; checks compare
+	// every pair.
+	maxReferences = 16
+
+	// maxDriftSamples is the number of drift check results kept.
+	maxDriftSamples = 100
+
+	// driftInterval is the minimum time between periodic drift checks.
+	driftInterval = time.Hour
+)
+
+// referenceVector is a registered plaintext with known pairwise distances.
+type referenceVector struct {
+	Vector    []float64 `json:"vector"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// driftSample is the result of one drift check.
+type driftSample struct {
+	At    time.Time `json:"at"`
+	KeyID string    `json:"key_id"`
+
+	// The key's non-secret parameters at the time of the check.
+	Dimension           int     `json:"dimension"`
+	ScalingFactor       float64 `json:"scaling_factor"`
+	ApproximationFactor float64 `json:"approximation_factor"`
+	MinNoiseRadius      float64 `json:"min_noise_radius"`
+
+	References int      `json:"references"`
+	Skipped    []string `json:"skipped,omitempty"`
+	Pairs      int      `json:"pairs"`
+
+	// MaxDeviation and MeanDeviation are the observed |d_enc/s − d_plain|
+	// over all pairs; Bound is 2R/s.
+	MaxDeviation  float64 `json:"max_deviation"`
+	MeanDeviation float64 `json:"mean_deviation"`
+	Bound         float64 `json:"bound"`
+
+	// KeyChanged is set when the key differs from the previous sample's,
+	// i.e. it was rotated in between.
+	KeyChanged bool     `json:"key_changed"`
+	Alerts     []string `json:"alerts,omitempty"`
+}
+
+// sameParameters reports whether two samples saw the same key parameters.
+func (s *driftSample) sameParameters(o *driftSample) bool {
+	return s.Dimension == o.Dimension &&
+		s.ScalingFactor == o.ScalingFactor &&
+		s.ApproximationFactor == o.ApproximationFactor &&
+		s.MinNoiseRadius == o.MinNoiseRadius
+}
+
+// pathDrift returns the path configuration for references/ and verify/drift.
+func (b *vectorBackend) pathDrift() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "references/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleReferenceList,
+					Summary:  "List reference vectors.",
+				},
+			},
+			HelpSynopsis:    pathReferencesHelpSyn,
+			HelpDescription: pathReferencesHelpDesc,
+		},
+		{
+			Pattern: "references/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the reference vector.",
+					Required:    true,
+				},
+				"vector": {
+					Type:        framework.TypeSlice,
+					Description: "Synthetic plaintext vector (array of floats). Never register real data.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleReferenceRead,
+					Summary:  "Read a reference vector.",
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleReferenceWrite,
+					Summary:  "Register a reference vector.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleReferenceWrite,
+					Summary:  "Replace a reference vector.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleReferenceDelete,
+					Summary:  "Delete a reference vector.",
+				},
+			},
+			ExistenceCheck:  b.referenceExists,
+			HelpSynopsis:    pathReferencesHelpSyn,
+			HelpDescription: pathReferencesHelpDesc,
+		},
+		{
+			Pattern: "verify/drift",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleDriftRead,
+					Summary:  "Report recent reference drift checks.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleDriftCheck,
+					Summary:  "Run a reference drift check now.",
+				},
+			},
+			HelpSynopsis:    pathDriftHelpSyn,
+			HelpDescription: pathDriftHelpDesc,
+		},
+	}
+}
+
+// handleReferenceList lists the names of reference vectors.
+func (b *vectorBackend) handleReferenceList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, referenceStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(names), nil
+}
+
+// handleReferenceRead returns a reference vector.
+func (b *vectorBackend) handleReferenceRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var ref referenceVector
+	found, err := getStorageJSON(ctx, req.Storage, referenceStoragePrefix+data.Get("name").(string), &ref)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"vector":     ref.Vector,
+			"dimension":  len(ref.Vector),
+			"created_at": ref.CreatedAt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// handleReferenceWrite registers or replaces a reference vector.
+func (b *vectorBackend) handleReferenceWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	raw, ok := data.GetOk("vector")
+	if !ok {
+		return nil, fmt.Errorf("vector is required")
+	}
+	vector, err := parseVector(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(vector) == 0 {
+		return nil, fmt.Errorf("vector must not be empty")
+	}
+	if len(vector) > MaxDimension {
+		return nil, fmt.Errorf("vector dimension %d exceeds maximum allowed %d", len(vector), MaxDimension)
+	}
+
+	key := referenceStoragePrefix + name
+	if req.Operation == logical.CreateOperation {
+		names, err := req.Storage.List(ctx, referenceStoragePrefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) >= maxReferences {
+			return nil, fmt.Errorf("at most %d reference vectors may be registered", maxReferences)
+		}
+	}
+	ref := &referenceVector{Vector: vector, CreatedAt: time.Now().UTC()}
+	return nil, putStorageJSON(ctx, req.Storage, key, ref)
+}
+
+// referenceExists checks if a reference vector has been stored (for
+// ExistenceCheck).
+func (b *vectorBackend) referenceExists(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	entry, err := req.Storage.Get(ctx, referenceStoragePrefix+data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+// handleReferenceDelete removes a reference vector.
+func (b *vectorBackend) handleReferenceDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, deleteStorageEntry(ctx, req.Storage, referenceStoragePrefix+data.Get("name").(string))
+}
+
+// handleDriftRead returns the recorded drift checks, oldest first.
+func (b *vectorBackend) handleDriftRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	history, err := readDriftHistory(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	healthy := len(history) == 0 || len(history[len(history)-1].Alerts) == 0
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"samples": history,
+			"healthy": healthy,
+		},
+	}, nil
+}
+
+// handleDriftCheck runs a drift check immediately and returns its result.
+func (b *vectorBackend) handleDriftCheck(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if err := b.checkKeyEnabled(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	sample, err := b.checkDrift(ctx, req.Storage, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if sample == nil {
+		return nil, fmt.Errorf("at least two reference vectors of the key's dimension are required")
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"sample":  sample,
+			"healthy": len(sample.Alerts) == 0,
+		},
+	}, nil
+}
+
+// runDriftCheck runs a drift check from the periodic function, at most
+// once per driftInterval. Disabled keys and mounts without references are
+// skipped.
+func (b *vectorBackend) runDriftCheck(ctx context.Context, storage logical.Storage, now time.Time) error {
+	last := b.lastDriftCheck.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < driftInterval {
+		return nil
+	}
+	if !b.lastDriftCheck.CompareAndSwap(last, now.UnixNano()) {
+		return nil
+	}
+	err := b.checkKeyEnabled(ctx, storage)
+	if errors.Is(err, errKeyDisabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = b.checkDrift(ctx, storage, now)
+	if errors.Is(err, errConfigNotInitialized) {
+		return nil
+	}
+	return err
+}
+
+// checkDrift encrypts every reference vector under the current key,
+// compares the corrected encrypted distance of each pair with its plaintext
+// distance, and records the result. It returns nil if there are fewer than
+// two usable references.
+//
+// Two conditions raise alerts: a pair outside the SAP bound, which means the
+// transform is not what the parameters say; and parameters that changed
+// while the key did not, which no supported operation does.
+func (b *vectorBackend) checkDrift(ctx context.Context, storage logical.Storage, now time.Time) (*driftSample, error) {
+	matrix, cfg, err := b.getMatrixAndConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := b.requestKeyID(&logical.Request{}, nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := storage.List(ctx, referenceStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	sample := &driftSample{
+		At:                  now.UTC(),
+		KeyID:               keyID,
+		Dimension:           cfg.Dimension,
+		ScalingFactor:       cfg.ScalingFactor,
+		ApproximationFactor: cfg.ApproximationFactor,
+		MinNoiseRadius:      cfg.MinNoiseRadius,
+		Bound:               2 * cfg.maxNoiseNorm() / cfg.ScalingFactor,
+	}
+
+	// Repeat tracking and clipping alter ciphertexts by design; check the
+	// pure scheme, as verify/invariants does.
+	settings := defaultSettings()
+	var plain, encrypted [][]float64
+	var maxNorm float64
+	for _, name := range names {
+		var ref referenceVector
+		found, err := getStorageJSON(ctx, storage, referenceStoragePrefix+name, &ref)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if len(ref.Vector) != cfg.Dimension {
+			sample.Skipped = append(sample.Skipped, name)
+			continue
+		}
+		result, err := b.encryptVector(matrix, cfg, settings, ref.Vector)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt reference %q: %w", name, err)
+		}
+		plain = append(plain, ref.Vector)
+		encrypted = append(encrypted, result.Ciphertext)
+		maxNorm = math.Max(maxNorm, vectorNorm(ref.Vector))
+	}
+	sample.References = len(plain)
+	if len(plain) < 2 {
+		return nil, nil
+	}
+
+	var sumDeviation float64
+	outside := 0
+	for i := range plain {
+		for j := i + 1; j < len(plain); j++ {
+			dPlain := euclideanDistance(plain[i], plain[j])
+			deviation := math.Abs(euclideanDistance(encrypted[i], encrypted[j])/cfg.ScalingFactor - dPlain)
+			if deviation > sample.Bound+invariantSlack*(1+2*maxNorm) {
+				outside++
+			}
+			sample.MaxDeviation = math.Max(sample.MaxDeviation, deviation)
+			sumDeviation += deviation
+			sample.Pairs++
+		}
+	}
+	sample.MeanDeviation = sumDeviation / float64(sample.Pairs)
+	if outside > 0 {
+		sample.Alerts = append(sample.Alerts, fmt.Sprintf(
+			"%d of %d reference pairs exceed the distance bound %v (max deviation %v); the transform does not match the parameters",
+			outside, sample.Pairs, sample.Bound, sample.MaxDeviation))
+	}
+
+	history, err := readDriftHistory(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > 0 {
+		prev := history[len(history)-1]
+		sample.KeyChanged = prev.KeyID != sample.KeyID
+		if !sample.KeyChanged && !sample.sameParameters(prev) {
+			sample.Alerts = append(sample.Alerts,
+				"key parameters changed without a rotation; the stored configuration may have been altered")
+		}
+	}
+
+	history = append(history, sample)
+	if len(history) > maxDriftSamples {
+		history = history[len(history)-maxDriftSamples:]
+	}
+	if err := putStorageJSON(ctx, storage, driftHistoryPath, history); err != nil {
+		return nil, err
+	}
+
+	if len(sample.Alerts) > 0 {
+		b.Logger().Error("reference drift detected", "key_id", keyID, "alerts", strings.Join(sample.Alerts, "; "))
+		b.emitEvent(ctx, "reference-drift", "key_id", keyID, "alerts", strings.Join(sample.Alerts, "; "))
+	}
+	return sample, nil
+}
+
+// readDriftHistory returns the recorded drift samples, oldest first.
+func readDriftHistory(ctx context.Context, storage logical.Storage) ([]*driftSample, error) {
+	var history []*driftSample
+	if _, err := getStorageJSON(ctx, storage, driftHistoryPath, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// vectorNorm returns the L2 norm of v.
+func vectorNorm(v []float64) float64 {
+	var sumSq float64
+	for _, x := range v {
+		sumSq += x * x
+	}
+	return math.Sqrt(sumSq)
+}
+
+// Help text constants for the drift paths.
+const pathReferencesHelpSyn = `Register reference vectors for drift detection.`
+
+const pathReferencesHelpDesc = `
+Reference vectors are a handful (up to 16) of named, synthetic plaintexts
+whose pairwise distances are known. verify/drift encrypts them under the
+current key and checks that the encrypted distances still match, which
+catches a misconfigured or altered transform before search quality
+silently degrades.
+
+References are stored in plaintext. Use synthetic vectors, never real
+embeddings. References whose dimension differs from the key's are skipped.
+
+Example:
+  vault write vector/references/axis-x vector='[1, 0, 0, ...]'
+  vault list vector/references
+`
+
+const pathDriftHelpSyn = `Track the drift of reference vector distances over time and rotations.`
+
+const pathDriftHelpDesc = `
+Once an hour, from the periodic function, the reference vectors are
+encrypted under the current key and, for every pair, the corrected
+encrypted distance d_enc / s is compared with the plaintext distance. Each
+check is recorded, keeping the last 100.
+
+An alert is logged as an error, and emitted as a vector-dpe/reference-drift
+event, when:
+  - A pair exceeds the SAP bound 2R/s, so the transform does not match
+    the configured parameters (e.g. a corrupted matrix).
+  - The key parameters differ from the previous check while the key_id is
+    the same. Rotation changes the key_id; nothing else changes parameters.
+
+Read returns the recorded samples, oldest first, and 'healthy' for the
+latest. Write runs a check immediately.
+
+Sample fields:
+  key_id, dimension, scaling_factor, approximation_factor, min_noise_radius
+  references, skipped, pairs
+  max_deviation, mean_deviation - Observed |d_enc/s − d_plain|
+  bound                         - 2R/s
+  key_changed                   - The key was rotated since the previous check
+  alerts
+
+Example:
+  vault write -f vector/verify/drift
+  vault read vector/verify/drift
+`
@@ -0,0 +1,109 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReferenceDrift(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	// Without noise the bound is tight, so any corruption shows.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	for i, name := range []string{"a", "b", "c"} {
+		testRequest(t, b, s, logical.UpdateOperation, "references/"+name, map[string]interface{}{
+			"vector": testVector(float64(i)),
+		})
+	}
+	// References of another dimension are skipped, not failed.
+	testRequest(t, b, s, logical.UpdateOperation, "references/short", map[string]interface{}{
+		"vector": []interface{}{1.0, 0.0},
+	})
+
+	check := func() *driftSample {
+		t.Helper()
+		sample, err := b.checkDrift(ctx, s, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sample
+	}
+
+	sample := check()
+	if sample.Pairs != 3 || len(sample.Alerts) != 0 || sample.MaxDeviation > sample.Bound+1e-9 {
+		t.Errorf("healthy key: %+v", sample)
+	}
+	if len(sample.Skipped) != 1 || sample.Skipped[0] != "short" {
+		t.Errorf("skipped = %v, want [short]", sample.Skipped)
+	}
+
+	// Parameters altered in storage under the same seed.
+	cfg, err := b.readConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ScalingFactor *= 2
+	if err := b.writeConfig(ctx, s, cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(ctx, configStoragePath)
+	sample = check()
+	if len(sample.Alerts) != 1 || !strings.Contains(sample.Alerts[0], "without a rotation") {
+		t.Errorf("alerts after parameter change = %v", sample.Alerts)
+	}
+
+	// A corrupted matrix breaks the distance bound.
+	b.matrixLock.Lock()
+	b.cachedMatrix.Set(0, 0, b.cachedMatrix.At(0, 0)+1)
+	b.matrixLock.Unlock()
+	sample = check()
+	if len(sample.Alerts) != 1 || !strings.Contains(sample.Alerts[0], "exceed the distance bound") {
+		t.Errorf("alerts after matrix corruption = %v", sample.Alerts)
+	}
+
+	// Rotation changes the key, which is expected.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 5.0,
+	})
+	sample = check()
+	if !sample.KeyChanged || len(sample.Alerts) != 0 {
+		t.Errorf("after rotation: key_changed = %v, alerts = %v", sample.KeyChanged, sample.Alerts)
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "verify/drift", nil)
+	if got := len(resp.Data["samples"].([]*driftSample)); got != 4 {
+		t.Errorf("recorded %d samples, want 4", got)
+	}
+	if resp.Data["healthy"] != true {
+		t.Error("healthy = false after a clean check")
+	}
+}
+
+func TestReferenceDriftNeedsTwo(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "references/a", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "verify/drift",
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("drift check with one reference succeeded, want error")
+	}
+}
@@ -0,0 +1,572 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// dualControlStoragePath is the Vault storage path for the dual
+	// control settings.
+	dualControlStoragePath = "config/dual-control"
+
+	// approvalPrefix is the storage prefix of pending approvals.
+	approvalPrefix = "approval/"
+
+	// approvalIDField is the request field that executes an approved
+	// operation.
+	approvalIDField = "approval_id"
+
+	// defaultApprovalWindow is how long an operation may wait for approval
+	// and execution when config/dual-control sets no window.
+	defaultApprovalWindow = time.Hour
+
+	// maxApprovalWindow bounds window.
+	maxApprovalWindow = 24 * time.Hour
+)
+
+// dualControlConfig lists the sensitive operations that need a second
+// caller's approval before they run.
+type dualControlConfig struct {
+	Paths  []string      `json:"paths"`
+	Window time.Duration `json:"window"`
+}
+
+// responseData renders the dual control settings for API responses.
+func (c *dualControlConfig) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"paths":  c.Paths,
+		"window": int64(c.Window.Seconds()),
+	}
+}
+
+// validate checks the dual control settings before they are stored.
+func (c *dualControlConfig) validate() error {
+	for _, path := range c.Paths {
+		if !slices.Contains(sensitivePaths, path) {
+			return fmt.Errorf("paths: %q is not a sensitive path (one of %s)", path, strings.Join(sensitivePaths, ", "))
+		}
+	}
+	if c.Window <= 0 || c.Window > maxApprovalWindow {
+		return fmt.Errorf("window must be between 1s and %s", maxApprovalWindow)
+	}
+	return nil
+}
+
+// controls reports whether writes to path need approval. While any path is
+// controlled, so is config/dual-control itself, so that a single caller
+// cannot switch dual control off.
+func (c *dualControlConfig) controls(path string) bool {
+	if c == nil || len(c.Paths) == 0 {
+		return false
+	}
+	return path == dualControlStoragePath || slices.Contains(c.Paths, path)
+}
+
+// pendingApproval is an operation waiting for approval or, once approved,
+// for its initiator to execute it. Only a digest of the request parameters
+// is kept, so seeds passed to config/root are never stored here.
+type pendingApproval struct {
+	ID               string    `json:"id"`
+	Path             string    `json:"path"`
+	Parameters       []string  `json:"parameters"`
+	ParametersDigest string    `json:"parameters_digest"`
+	Initiator        string    `json:"initiator"`
+	InitiatorName    string    `json:"initiator_name,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	Approver         string    `json:"approver,omitempty"`
+	ApproverName     string    `json:"approver_name,omitempty"`
+	ApprovedAt       time.Time `json:"approved_at,omitempty"`
+}
+
+// approved reports whether a second caller approved the operation.
+func (a *pendingApproval) approved() bool {
+	return a.Approver != ""
+}
+
+// responseData renders the approval for API responses.
+func (a *pendingApproval) responseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"approval_id":       a.ID,
+		"path":              a.Path,
+		"parameters":        a.Parameters,
+		"parameters_sha256": a.ParametersDigest,
+		"initiator":         a.Initiator,
+		"initiator_name":    a.InitiatorName,
+		"created_at":        a.CreatedAt.Format(time.RFC3339),
+		"expires_at":        a.ExpiresAt.Format(time.RFC3339),
+		"approved":          a.approved(),
+		"approver":          a.Approver,
+		"approver_name":     a.ApproverName,
+		"approved_at":       "",
+	}
+	if a.approved() {
+		data["approved_at"] = a.ApprovedAt.Format(time.RFC3339)
+	}
+	return data
+}
+
+// callerIdentity identifies the caller for dual control: its entity, or its
+// token accessor for tokens without one. It is empty if Vault passed
+// neither.
+func callerIdentity(req *logical.Request) string {
+	switch {
+	case req.EntityID != "":
+		return "entity:" + req.EntityID
+	case req.ClientTokenAccessor != "":
+		return "accessor:" + req.ClientTokenAccessor
+	}
+	return ""
+}
+
+// parametersDigest returns the sorted parameter names of data and a SHA-256
+// digest of their values, ignoring approval_id.
+func parametersDigest(data map[string]interface{}) ([]string, string, error) {
+	params := make(map[string]interface{}, len(data))
+	names := make([]string, 0, len(data))
+	for name, value := range data {
+		if name == approvalIDField {
+			continue
+		}
+		params[name] = value
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// encoding/json sorts map keys, so equal parameters encode equally.
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, "", fmt.Errorf("encode parameters: %w", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return names, hex.EncodeToString(sum[:]), nil
+}
+
+// checkDualControl runs before every request. A write to a controlled path
+// without approval_id is not executed: it is recorded as a pending approval,
+// whose ID is returned. Once a different caller has approved it, the
+// initiator repeats the write with the same parameters plus approval_id,
+// and the request goes through. handled reports whether resp and err answer
+// the request; otherwise it proceeds, with approval_id removed.
+func (b *vectorBackend) checkDualControl(ctx context.Context, req *logical.Request) (resp *logical.Response, handled bool, err error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, false, nil
+	}
+	// Only sensitive paths can be controlled; skip the storage read for
+	// everything else.
+	if req.Path != dualControlStoragePath && !slices.Contains(sensitivePaths, req.Path) {
+		return nil, false, nil
+	}
+	cfg, err := readDualControlConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, true, err
+	}
+	if !cfg.controls(req.Path) {
+		return nil, false, nil
+	}
+
+	caller := callerIdentity(req)
+	if caller == "" {
+		return nil, true, fmt.Errorf("%w: %s requires approval, and dual control requires a token with an entity or accessor", logical.ErrPermissionDenied, req.Path)
+	}
+	names, digest, err := parametersDigest(req.Data)
+	if err != nil {
+		return nil, true, err
+	}
+	approvalID, _ := req.Data[approvalIDField].(string)
+	if approvalID == "" {
+		resp, err := b.requestApproval(ctx, req, cfg, caller, names, digest)
+		return resp, true, err
+	}
+
+	b.approvalLock.Lock()
+	defer b.approvalLock.Unlock()
+	approval, err := readApproval(ctx, req.Storage, approvalID)
+	if err != nil {
+		return nil, true, err
+	}
+	now := time.Now()
+	switch {
+	case approval == nil || now.After(approval.ExpiresAt):
+		return nil, true, fmt.Errorf("%w: approval %q not found or expired", logical.ErrPermissionDenied, approvalID)
+	case approval.Path != req.Path:
+		return nil, true, fmt.Errorf("%w: approval %q is for %s", logical.ErrPermissionDenied, approvalID, approval.Path)
+	case approval.Initiator != caller:
+		return nil, true, fmt.Errorf("%w: only the initiator of approval %q may execute it", logical.ErrPermissionDenied, approvalID)
+	case !approval.approved():
+		return nil, true, fmt.Errorf("%w: approval %q has not been approved yet", logical.ErrPermissionDenied, approvalID)
+	case approval.ParametersDigest != digest:
+		return nil, true, fmt.Errorf("%w: parameters differ from those of approval %q", logical.ErrPermissionDenied, approvalID)
+	}
+	// Approvals are single-use: consume it before the operation runs.
+	if err := req.Storage.Delete(ctx, approvalPrefix+approvalID); err != nil {
+		return nil, true, err
+	}
+	b.Logger().Info("executing approved operation", "path", req.Path, "approval_id", approvalID,
+		"initiator", approval.Initiator, "approver", approval.Approver)
+
+	stripped := make(map[string]interface{}, len(req.Data))
+	for name, value := range req.Data {
+		if name != approvalIDField {
+			stripped[name] = value
+		}
+	}
+	req.Data = stripped
+	return nil, false, nil
+}
+
+// requestApproval records a pending approval for req.
+func (b *vectorBackend) requestApproval(ctx context.Context, req *logical.Request, cfg *dualControlConfig, caller string, names []string, digest string) (*logical.Response, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	approval := &pendingApproval{
+		ID:               id,
+		Path:             req.Path,
+		Parameters:       names,
+		ParametersDigest: digest,
+		Initiator:        caller,
+		InitiatorName:    req.DisplayName,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(cfg.Window),
+	}
+	if err := putStorageJSON(ctx, req.Storage, approvalPrefix+id, approval); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "approval-requested", "approval_id", id, "path", req.Path)
+	resp := &logical.Response{
+		Data: approval.responseData(),
+	}
+	addWarning(resp, warnApprovalRequired, fmt.Sprintf("%s requires approval and was not executed. Have a different caller write approvals/%s/approve, then repeat this request with approval_id=%s before %s.",
+		req.Path, id, id, approval.ExpiresAt.Format(time.RFC3339)))
+	return resp, nil
+}
+
+// pathDualControl returns the path configuration for config/dual-control
+// and approvals/.
+func (b *vectorBackend) pathDualControl() []*framework.Path {
+	approvalIDSchema := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Approval ID, as returned by the controlled operation.",
+		Required:    true,
+	}
+	return []*framework.Path{
+		{
+			Pattern: "config/dual-control",
+			Fields: map[string]*framework.FieldSchema{
+				"paths": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: fmt.Sprintf("Sensitive paths whose writes need a second caller's approval (any of %s). Empty disables dual control.", strings.Join(sensitivePaths, ", ")),
+				},
+				"window": {
+					Type:        framework.TypeDurationSecond,
+					Description: fmt.Sprintf("How long an operation may wait for approval and execution (default: %s, max: %s).", defaultApprovalWindow, maxApprovalWindow),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleDualControlRead,
+					Summary:  "Read the dual control settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleDualControlWrite,
+					Summary:  "Configure which sensitive operations need a second caller's approval.",
+				},
+			},
+			HelpSynopsis:    pathDualControlHelpSyn,
+			HelpDescription: pathDualControlHelpDesc,
+		},
+		{
+			Pattern: "approvals/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"after": afterField,
+				"limit": limitField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleApprovalList,
+					Summary:  "List pending approvals.",
+				},
+			},
+			HelpSynopsis:    pathDualControlHelpSyn,
+			HelpDescription: pathDualControlHelpDesc,
+		},
+		{
+			Pattern: "approvals/" + framework.GenericNameRegex("approval_id") + "/approve",
+			Fields: map[string]*framework.FieldSchema{
+				"approval_id": approvalIDSchema,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleApprovalApprove,
+					Summary:  "Approve another caller's pending operation.",
+				},
+			},
+			HelpSynopsis:    pathDualControlHelpSyn,
+			HelpDescription: pathDualControlHelpDesc,
+		},
+		{
+			Pattern: "approvals/" + framework.GenericNameRegex("approval_id"),
+			Fields: map[string]*framework.FieldSchema{
+				"approval_id": approvalIDSchema,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleApprovalRead,
+					Summary:  "Read a pending approval.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleApprovalDelete,
+					Summary:  "Cancel a pending approval.",
+				},
+			},
+			HelpSynopsis:    pathDualControlHelpSyn,
+			HelpDescription: pathDualControlHelpDesc,
+		},
+	}
+}
+
+// handleDualControlRead returns the dual control settings.
+func (b *vectorBackend) handleDualControlRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readDualControlConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleDualControlWrite merges the supplied fields into the stored dual
+// control settings. While dual control is enabled, checkDualControl has
+// already required an approval for this write.
+func (b *vectorBackend) handleDualControlWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readDualControlConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = &dualControlConfig{Window: defaultApprovalWindow}
+	}
+
+	if raw, ok := data.GetOk("paths"); ok {
+		cfg.Paths = raw.([]string)
+	}
+	if raw, ok := data.GetOk("window"); ok {
+		cfg.Window = time.Duration(raw.(int)) * time.Second
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, dualControlStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "dual-control-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleApprovalList lists unexpired approvals with their path, initiator
+// and state.
+func (b *vectorBackend) handleApprovalList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ids, err := req.Storage.List(ctx, approvalPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	ids, nextAfter, err := pageKeys(ids, data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(ids))
+	keyInfo := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		approval, err := readApproval(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if approval == nil || now.After(approval.ExpiresAt) {
+			continue
+		}
+		keys = append(keys, id)
+		keyInfo[id] = map[string]interface{}{
+			"path":       approval.Path,
+			"initiator":  approval.Initiator,
+			"approved":   approval.approved(),
+			"expires_at": approval.ExpiresAt.Format(time.RFC3339),
+		}
+	}
+	resp := logical.ListResponseWithInfo(keys, keyInfo)
+	if nextAfter != "" {
+		resp.Data["next_after"] = nextAfter
+	}
+	return resp, nil
+}
+
+// handleApprovalRead returns a pending approval, for the approver to review
+// before approving it.
+func (b *vectorBackend) handleApprovalRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	approval, err := readApproval(ctx, req.Storage, data.Get("approval_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if approval == nil || time.Now().After(approval.ExpiresAt) {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: approval.responseData(),
+	}, nil
+}
+
+// handleApprovalApprove records the caller's approval. The initiator cannot
+// approve their own operation.
+func (b *vectorBackend) handleApprovalApprove(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("approval_id").(string)
+	caller := callerIdentity(req)
+	if caller == "" {
+		return nil, fmt.Errorf("%w: approving requires a token with an entity or accessor", logical.ErrPermissionDenied)
+	}
+
+	b.approvalLock.Lock()
+	defer b.approvalLock.Unlock()
+	approval, err := readApproval(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case approval == nil || time.Now().After(approval.ExpiresAt):
+		return logical.ErrorResponse("approval not found or expired"), nil
+	case approval.Initiator == caller:
+		return nil, fmt.Errorf("%w: the initiator cannot approve their own operation", logical.ErrPermissionDenied)
+	case approval.approved():
+		return nil, fmt.Errorf("approval %q was already approved by %s", id, approval.Approver)
+	}
+	approval.Approver = caller
+	approval.ApproverName = req.DisplayName
+	approval.ApprovedAt = time.Now().UTC()
+	if err := putStorageJSON(ctx, req.Storage, approvalPrefix+id, approval); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "approval-granted", "approval_id", id, "path", approval.Path)
+	return &logical.Response{
+		Data: approval.responseData(),
+	}, nil
+}
+
+// handleApprovalDelete cancels a pending approval.
+func (b *vectorBackend) handleApprovalDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.approvalLock.Lock()
+	defer b.approvalLock.Unlock()
+	return nil, deleteStorageEntry(ctx, req.Storage, approvalPrefix+data.Get("approval_id").(string))
+}
+
+// readDualControlConfig retrieves the dual control settings, or nil if
+// unset.
+func readDualControlConfig(ctx context.Context, storage logical.Storage) (*dualControlConfig, error) {
+	var cfg dualControlConfig
+	found, err := getStorageJSON(ctx, storage, dualControlStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// readApproval retrieves a pending approval, or nil if there is none.
+func readApproval(ctx context.Context, storage logical.Storage, id string) (*pendingApproval, error) {
+	var approval pendingApproval
+	found, err := getStorageJSON(ctx, storage, approvalPrefix+id, &approval)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &approval, nil
+}
+
+// expireApprovals deletes approvals past their window.
+func (b *vectorBackend) expireApprovals(ctx context.Context, storage logical.Storage, now time.Time) error {
+	ids, err := storage.List(ctx, approvalPrefix)
+	if err != nil {
+		return err
+	}
+	b.approvalLock.Lock()
+	defer b.approvalLock.Unlock()
+	for _, id := range ids {
+		approval, err := readApproval(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if approval != nil && now.After(approval.ExpiresAt) {
+			if err := storage.Delete(ctx, approvalPrefix+id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Help text constants for the dual control paths.
+const pathDualControlHelpSyn = `Require a second caller's approval for sensitive operations.`
+
+const pathDualControlHelpDesc = `
+Dual control makes writes to the listed sensitive paths a two-step flow,
+for deployments without Vault Enterprise control groups:
+
+  1. The initiator writes the operation as usual. It is not executed;
+     the response holds an approval_id and the window's expiry.
+  2. A different caller reads approvals/<approval_id> to review the path,
+     parameter names and parameter digest, and writes
+     approvals/<approval_id>/approve.
+  3. Within the window, the initiator repeats the write with the same
+     parameters plus approval_id, and it runs. Approvals are single-use.
+
+Callers are told apart by entity, or by token accessor for tokens without
+an entity. The plugin cannot see token policies: grant update on
+approvals/+/approve to the approvers' policy only, so that approval takes a
+differently-policied token as well as a different caller.
+
+While any path is controlled, writes to config/dual-control need approval
+too; write 'paths' empty (with approval) to disable dual control. Only a
+digest of the parameters is stored with a pending approval, so seeds are
+never persisted there.
+
+Parameters:
+  paths  - Sensitive paths to control
+  window - How long an operation may wait for approval and execution
+           (default: 1h, max: 24h)
+
+LIST approvals/ returns the unexpired approvals; DELETE approvals/<id>
+cancels one.
+`
@@ -0,0 +1,133 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// entityRequest issues a request as the given entity, returning error
+// responses as errors.
+func entityRequest(b *vectorBackend, s logical.Storage, entityID string, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:   op,
+		Path:        path,
+		Data:        data,
+		Storage:     s,
+		EntityID:    entityID,
+		DisplayName: entityID,
+	})
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+	return resp, err
+}
+
+func TestDualControl(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/dual-control", map[string]interface{}{
+		"paths": "config/rotate",
+	})
+	seed := func() string {
+		cfg, err := b.readConfig(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.Seed
+	}
+	original := seed()
+	params := map[string]interface{}{"dimension": testDimension, "scaling_factor": 2.0}
+
+	// The initiator's write is held for approval.
+	resp, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/rotate", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := resp.Data["approval_id"].(string)
+	if id == "" || len(resp.Warnings) != 1 || seed() != original {
+		t.Fatalf("controlled write = %v, want a pending approval and no rotation", resp.Data)
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/rotate", params); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("write without identity = %v, want permission denied", err)
+	}
+
+	execute := func(entityID string, data map[string]interface{}) error {
+		withID := map[string]interface{}{"approval_id": id}
+		for name, value := range data {
+			withID[name] = value
+		}
+		_, err := entityRequest(b, s, entityID, logical.UpdateOperation, "config/rotate", withID)
+		return err
+	}
+	if err := execute("alice", params); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("execute before approval = %v, want permission denied", err)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "approvals/"+id+"/approve", nil); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("self-approval = %v, want permission denied", err)
+	}
+
+	resp, err = entityRequest(b, s, "bob", logical.ReadOperation, "approvals/"+id, nil)
+	if err != nil || resp.Data["path"] != "config/rotate" || resp.Data["initiator"] != "entity:alice" {
+		t.Fatalf("read approval = %v, %v", resp, err)
+	}
+	if _, err := entityRequest(b, s, "bob", logical.UpdateOperation, "approvals/"+id+"/approve", nil); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if _, err := entityRequest(b, s, "carol", logical.UpdateOperation, "approvals/"+id+"/approve", nil); err == nil {
+		t.Error("approved twice")
+	}
+
+	// Only the initiator may execute, and only with the approved parameters.
+	if err := execute("bob", params); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("execute by the approver = %v, want permission denied", err)
+	}
+	if err := execute("alice", map[string]interface{}{"dimension": testDimension, "scaling_factor": 3.0}); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("execute with other parameters = %v, want permission denied", err)
+	}
+	if err := execute("alice", params); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if seed() == original {
+		t.Error("approved rotation did not rotate the key")
+	}
+	if err := execute("alice", params); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("second execution = %v, want permission denied", err)
+	}
+
+	// Dual control protects its own settings.
+	resp, err = entityRequest(b, s, "alice", logical.UpdateOperation, "config/dual-control", map[string]interface{}{
+		"paths": "",
+	})
+	if err != nil || resp.Data["approval_id"] == nil {
+		t.Fatalf("disabling dual control = %v, %v; want a pending approval", resp, err)
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "config/dual-control", nil)
+	if paths := resp.Data["paths"].([]string); len(paths) != 1 {
+		t.Errorf("paths = %v after an unapproved change, want unchanged", paths)
+	}
+	resp = testRequest(t, b, s, logical.ListOperation, "approvals/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 {
+		t.Errorf("pending approvals = %v, want the dual control change", keys)
+	}
+}
+
+func TestDualControlConfigValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+	for name, data := range map[string]map[string]interface{}{
+		"not sensitive": {"paths": "encrypt/vector"},
+		"zero window":   {"paths": "config/rotate", "window": 0},
+		"long window":   {"paths": "config/rotate", "window": "48h"},
+	} {
+		if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/dual-control", data); err == nil {
+			t.Errorf("%s: config/dual-control accepted %v", name, data)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// embeddingsStoragePath is the Vault storage path for the upstream
+	// embeddings API behind the OpenAI-compatible endpoint.
+	embeddingsStoragePath = "config/embeddings"
+
+	// integrationEmbeddings names the upstream in stats/outbound.
+	integrationEmbeddings = "embeddings"
+
+	// maxEmbeddingsInputs is the most inputs one request may embed, the
+	// limit of the OpenAI API.
+	maxEmbeddingsInputs = 2048
+
+	// maxEmbeddingsResponseBytes bounds the upstream responses read.
+	maxEmbeddingsResponseBytes = 64 << 20
+
+	// Values of the OpenAI encoding_format field.
+	encodingFloat  = "float"
+	encodingBase64 = "base64"
+)
+
+// embeddingsConfig holds the upstream embeddings API settings.
+type embeddingsConfig struct {
+	// URL is the base URL of an OpenAI-compatible API; requests go to
+	// <url>/embeddings.
+	URL    string `json:"url"`
+	APIKey string `json:"api_key,omitempty"`
+	CACert string `json:"ca_cert,omitempty"`
+
+	// Model, when set, replaces the model of every request, so clients
+	// cannot choose a model whose vectors the key was not made for.
+	Model string `json:"model,omitempty"`
+
+	// Timeout, when zero, is the config/outbound timeout.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// responseData renders the settings for API responses. The API key is
+// never returned.
+func (c *embeddingsConfig) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"url":         c.URL,
+		"api_key_set": c.APIKey != "",
+		"ca_cert":     c.CACert,
+		"model":       c.Model,
+		"timeout":     int64(c.Timeout.Seconds()),
+	}
+}
+
+// validate checks the settings before they are stored.
+func (c *embeddingsConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// embeddingsUpstream calls the upstream embeddings API.
+type embeddingsUpstream struct {
+	cfg    *embeddingsConfig
+	client *http.Client
+
+	// call runs each request under the outbound retry policy and circuit
+	// breaker; nil runs it directly.
+	call func(context.Context, func(context.Context) error) error
+}
+
+// newEmbeddingsUpstream builds the HTTP client for cfg under the mount's
+// outbound settings, which may be nil.
+func newEmbeddingsUpstream(cfg *embeddingsConfig, outbound *outboundConfig) (*embeddingsUpstream, error) {
+	client, err := outbound.httpClient(cfg.CACert, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &embeddingsUpstream{cfg: cfg, client: client}, nil
+}
+
+// embeddingsRequest is the body forwarded upstream. Input is passed
+// through unchanged: a string, a list of strings, or token arrays.
+type embeddingsRequest struct {
+	Input          interface{} `json:"input"`
+	Model          string      `json:"model"`
+	EncodingFormat string      `json:"encoding_format"`
+	Dimensions     int         `json:"dimensions,omitempty"`
+	User           string      `json:"user,omitempty"`
+}
+
+// embeddingsResponse is the upstream response, and the shape of ours.
+type embeddingsResponse struct {
+	Object string            `json:"object"`
+	Data   []embeddingsItem  `json:"data"`
+	Model  string            `json:"model"`
+	Usage  json.RawMessage   `json:"usage,omitempty"`
+	Scheme *embeddingsScheme `json:"vector_dpe,omitempty"`
+}
+
+// embeddingsItem is one embedding. Upstream it is always a float array;
+// ours is a float array or base64 float32, as the client asked.
+type embeddingsItem struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+// embeddingsScheme reports the scheme parameters in the response, in a
+// field that OpenAI clients ignore.
+type embeddingsScheme struct {
+	Scheme      string `json:"scheme"`
+	KeyVersion  int    `json:"key_version"`
+	Dimension   int    `json:"dimension"`
+	TransformID string `json:"transform_id"`
+}
+
+// embed posts the request to <url>/embeddings and returns the embeddings
+// in input order.
+func (u *embeddingsUpstream) embed(ctx context.Context, body *embeddingsRequest) (*embeddingsResponse, [][]float64, error) {
+	var out *embeddingsResponse
+	var vectors [][]float64
+	post := func(ctx context.Context) error {
+		var err error
+		out, vectors, err = u.post(ctx, body)
+		return err
+	}
+	if u.call == nil {
+		return out, vectors, post(ctx)
+	}
+	err := u.call(ctx, post)
+	return out, vectors, err
+}
+
+// post sends one request. Any status other than 2xx is an error.
+func (u *embeddingsUpstream) post(ctx context.Context, body *embeddingsRequest) (*embeddingsResponse, [][]float64, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(u.cfg.URL, "/")+"/embeddings", bytes.NewReader(encoded))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if u.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+u.cfg.APIKey)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxEmbeddingsResponseBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("embeddings: read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(respBody) > 256 {
+			respBody = respBody[:256]
+		}
+		return nil, nil, fmt.Errorf("embeddings: %w", &outboundStatusError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		})
+	}
+
+	var out embeddingsResponse
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, nil, fmt.Errorf("embeddings: decode response: %w", err)
+	}
+	vectors := make([][]float64, len(out.Data))
+	for _, item := range out.Data {
+		if item.Index < 0 || item.Index >= len(vectors) || vectors[item.Index] != nil {
+			return nil, nil, fmt.Errorf("embeddings: response has an invalid or repeated index %d", item.Index)
+		}
+		vector, err := parseVector(item.Embedding)
+		if err != nil {
+			return nil, nil, fmt.Errorf("embeddings: item %d: %w", item.Index, err)
+		}
+		vectors[item.Index] = vector
+	}
+	return &out, vectors, nil
+}
+
+// pathEmbeddings returns the path configuration for config/embeddings and
+// the OpenAI-compatible openai/[:role/]embeddings.
+func (b *vectorBackend) pathEmbeddings() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/embeddings",
+			Fields: map[string]*framework.FieldSchema{
+				"url": {
+					Type:        framework.TypeString,
+					Description: "Base URL of an OpenAI-compatible embeddings API, e.g. https://api.openai.com/v1.",
+				},
+				"api_key": {
+					Type:        framework.TypeString,
+					Description: "Bearer token sent to the API. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded CA certificate for the API, trusted with those of config/outbound. Defaults to the system roots.",
+				},
+				"model": {
+					Type:        framework.TypeString,
+					Description: "Model used for every request, whatever the client asks for (default: the client's).",
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Timeout of each request to the API (default: the config/outbound timeout).",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleEmbeddingsConfigRead,
+					Summary:  "Read the upstream embeddings API settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEmbeddingsConfigWrite,
+					Summary:  "Configure the upstream embeddings API of openai/embeddings.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleEmbeddingsConfigDelete,
+					Summary:  "Remove the upstream embeddings API, disabling openai/embeddings.",
+				},
+			},
+			HelpSynopsis:    pathEmbeddingsConfigHelpSyn,
+			HelpDescription: pathEmbeddingsConfigHelpDesc,
+		},
+		{
+			Pattern: "openai/(" + framework.GenericNameRegex("role") + "/)?embeddings$",
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"input": {
+					Type:        framework.TypeSlice,
+					Description: "Text to embed: a string, a list of strings or token arrays, passed to the upstream API unchanged.",
+					Required:    true,
+				},
+				"model": {
+					Type:        framework.TypeString,
+					Description: "Model to embed with, unless config/embeddings fixes one.",
+				},
+				"encoding_format": {
+					Type:        framework.TypeString,
+					Description: "Encoding of the returned embeddings: float, or base64 of little-endian float32.",
+					Default:     encodingFloat,
+				},
+				"dimensions": {
+					Type:        framework.TypeInt,
+					Description: "Dimensions requested from the upstream API; must equal the key's.",
+				},
+				"user": {
+					Type:        framework.TypeString,
+					Description: "End-user identifier, passed to the upstream API.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEmbeddings,
+					Summary:  "Embed text upstream and return the encrypted embeddings in the OpenAI response shape.",
+				},
+			},
+			HelpSynopsis:    pathEmbeddingsHelpSyn,
+			HelpDescription: pathEmbeddingsHelpDesc,
+		},
+	}
+}
+
+// handleEmbeddingsConfigRead returns the upstream settings, without the
+// API key.
+func (b *vectorBackend) handleEmbeddingsConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readEmbeddingsConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleEmbeddingsConfigWrite merges the supplied fields into the stored
+// upstream settings.
+func (b *vectorBackend) handleEmbeddingsConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readEmbeddingsConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = &embeddingsConfig{}
+	}
+
+	if raw, ok := data.GetOk("url"); ok {
+		cfg.URL = raw.(string)
+	}
+	if raw, ok := data.GetOk("api_key"); ok {
+		cfg.APIKey = raw.(string)
+	}
+	if raw, ok := data.GetOk("ca_cert"); ok {
+		cfg.CACert = raw.(string)
+	}
+	if raw, ok := data.GetOk("model"); ok {
+		cfg.Model = raw.(string)
+	}
+	if raw, ok := data.GetOk("timeout"); ok {
+		cfg.Timeout = time.Duration(raw.(int)) * time.Second
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Build the client once now so a bad CA certificate fails the write.
+	outbound, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newEmbeddingsUpstream(cfg, outbound); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, embeddingsStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	b.resetEmbeddingsUpstream()
+	if err := b.recordHistory(ctx, req, "embeddings-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleEmbeddingsConfigDelete removes the upstream settings.
+func (b *vectorBackend) handleEmbeddingsConfigDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readEmbeddingsConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, embeddingsStoragePath); err != nil {
+		return nil, err
+	}
+	b.resetEmbeddingsUpstream()
+	if cfg == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "embeddings-delete", cfg.responseData(), map[string]interface{}{})
+}
+
+// handleEmbeddings embeds the input upstream, encrypts every embedding and
+// returns them in the OpenAI response shape, as a raw JSON body so that
+// OpenAI clients can parse it. Plaintext embeddings never leave the plugin.
+func (b *vectorBackend) handleEmbeddings(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationEmbeddings); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	encoding := data.Get("encoding_format").(string)
+	if encoding != encodingFloat && encoding != encodingBase64 {
+		return nil, fmt.Errorf("encoding_format must be %q or %q", encodingFloat, encodingBase64)
+	}
+	input := data.Raw["input"]
+	if items, ok := input.([]interface{}); ok && len(items) > maxEmbeddingsInputs {
+		return nil, fmt.Errorf("input exceeds maximum %d items", maxEmbeddingsInputs)
+	}
+
+	upstream, err := b.getEmbeddingsUpstream(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if upstream == nil {
+		return nil, fmt.Errorf("no embeddings API configured; write config/embeddings first")
+	}
+	model := data.Get("model").(string)
+	if upstream.cfg.Model != "" {
+		model = upstream.cfg.Model
+	}
+	if model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	matrix, cfg, err := b.matrixForRole(ctx, req, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(model); err != nil {
+		return nil, err
+	}
+	if d := data.Get("dimensions").(int); d != 0 && d != cfg.Dimension {
+		return nil, fmt.Errorf("dimensions %d does not match configured dimension %d", d, cfg.Dimension)
+	}
+	scheme, err := b.requestSchemeParams(req, role, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	out, vectors, err := upstream.embed(ctx, &embeddingsRequest{
+		Input:          input,
+		Model:          model,
+		EncodingFormat: encodingFloat,
+		Dimensions:     data.Get("dimensions").(int),
+		User:           data.Get("user").(string),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, vector := range vectors {
+			clear(vector)
+		}
+	}()
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationEmbeddings, len(vectors))
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("embeddings encryption request",
+		"dimension", cfg.Dimension,
+		"batch_size", len(vectors),
+		"client_id", req.ClientToken)
+
+	items := make([]embeddingsItem, len(vectors))
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embeddings: response is missing index %d", i)
+		}
+		result, err := b.encryptVector(matrix, cfg, settings, vector)
+		if err != nil {
+			return nil, fmt.Errorf("embedding %d: %w", i, err)
+		}
+		roundToPrecision(result.Ciphertext, settings.OutputPrecision)
+		items[i] = embeddingsItem{Object: "embedding", Index: i, Embedding: result.Ciphertext}
+		if encoding == encodingBase64 {
+			items[i].Embedding = base64.StdEncoding.EncodeToString(packFloat32(nil, result.Ciphertext))
+		}
+	}
+
+	body, err := json.Marshal(&embeddingsResponse{
+		Object: "list",
+		Data:   items,
+		Model:  out.Model,
+		Usage:  out.Usage,
+		Scheme: &embeddingsScheme{
+			Scheme:      scheme.Scheme,
+			KeyVersion:  scheme.KeyVersion,
+			Dimension:   scheme.Dimension,
+			TransformID: scheme.TransformID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/json",
+			logical.HTTPRawBody:     body,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// readEmbeddingsConfig retrieves the upstream settings, or nil if unset.
+func readEmbeddingsConfig(ctx context.Context, storage logical.Storage) (*embeddingsConfig, error) {
+	var cfg embeddingsConfig
+	found, err := getStorageJSON(ctx, storage, embeddingsStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// getEmbeddingsUpstream returns the configured upstream, or nil if there is
+// none, building it on first use. It follows the same Check-Lock-Check
+// pattern as getWebhookSink.
+func (b *vectorBackend) getEmbeddingsUpstream(ctx context.Context, storage logical.Storage) (*embeddingsUpstream, error) {
+	b.embeddingsLock.RLock()
+	if b.embeddingsLoaded {
+		upstream := b.embeddingsUpstream
+		b.embeddingsLock.RUnlock()
+		return upstream, nil
+	}
+	b.embeddingsLock.RUnlock()
+
+	b.embeddingsLock.Lock()
+	defer b.embeddingsLock.Unlock()
+
+	if b.embeddingsLoaded {
+		return b.embeddingsUpstream, nil
+	}
+	cfg, err := readEmbeddingsConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	var upstream *embeddingsUpstream
+	if cfg != nil {
+		outbound, err := readOutboundConfig(ctx, storage)
+		if err != nil {
+			return nil, err
+		}
+		if upstream, err = newEmbeddingsUpstream(cfg, outbound); err != nil {
+			return nil, err
+		}
+		upstream.call = func(ctx context.Context, fn func(context.Context) error) error {
+			return b.callOutbound(ctx, storage, integrationEmbeddings, fn)
+		}
+	}
+	b.embeddingsUpstream, b.embeddingsLoaded = upstream, true
+	return upstream, nil
+}
+
+// resetEmbeddingsUpstream drops the cached upstream so the next use
+// rereads config/embeddings.
+func (b *vectorBackend) resetEmbeddingsUpstream() {
+	b.embeddingsLock.Lock()
+	b.embeddingsUpstream, b.embeddingsLoaded = nil, false
+	b.embeddingsLock.Unlock()
+}
+
+// Help text constants for the embeddings paths.
+const pathEmbeddingsConfigHelpSyn = `Configure the upstream embeddings API behind openai/embeddings.`
+
+const pathEmbeddingsConfigHelpDesc = `
+openai/embeddings embeds text with an OpenAI-compatible API and returns the
+embeddings encrypted. This path configures that API: its base URL (requests
+go to <url>/embeddings), the API key sent as a bearer token, an optional CA
+certificate and timeout, and optionally a model that replaces the one
+clients ask for. TLS, proxy, retries and the circuit breaker follow
+config/outbound; calls are reported in stats/outbound as "embeddings".
+
+The API key is write-only. Deleting the configuration disables
+openai/embeddings.
+`
+
+const pathEmbeddingsHelpSyn = `Embed text and return encrypted embeddings in the OpenAI API's response shape.`
+
+const pathEmbeddingsHelpDesc = `
+A drop-in replacement for the OpenAI embeddings endpoint, so that RAG
+frameworks store only ciphertexts without code changes: point the client's
+base URL at this mount's openai/ path (or openai/<role>/ for a role's key)
+through Vault Agent, which adds the Vault token.
+
+The request is forwarded to the API of config/embeddings, and each returned
+embedding is encrypted before it is sent back. The response body is the
+OpenAI shape itself, not wrapped in Vault's "data", with a "vector_dpe"
+field carrying the scheme, key_version, dimension and transform_id:
+
+  {"object": "list", "model": "...", "usage": {...},
+   "data": [{"object": "embedding", "index": 0, "embedding": [...]}],
+   "vector_dpe": {...}}
+
+Errors keep Vault's error shape. Requests under a role require the
+'embeddings' operation in the role's allowed_operations.
+
+Parameters:
+  input           - String, list of strings or token arrays (max 2048 items).
+  model           - Model, unless config/embeddings fixes one. Checked
+                    against the key's embedding_model.
+  encoding_format - "float" (default) or "base64" (little-endian float32).
+  dimensions      - Passed upstream; must equal the key's dimension.
+  user            - Passed upstream.
+`
@@ -0,0 +1,165 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeEmbeddingsAPI answers OpenAI embeddings requests with testVector(i)
+// for the i-th input, listing the items in reverse to exercise 'index'.
+type fakeEmbeddingsAPI struct {
+	last embeddingsRequest
+}
+
+func (f *fakeEmbeddingsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer sk-test" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	f.last = embeddingsRequest{}
+	json.NewDecoder(r.Body).Decode(&f.last)
+	n := 1
+	if items, ok := f.last.Input.([]interface{}); ok {
+		n = len(items)
+	}
+	var data []map[string]interface{}
+	for i := n - 1; i >= 0; i-- {
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": testVector(float64(i))})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  f.last.Model,
+		"usage":  map[string]int{"prompt_tokens": n, "total_tokens": n},
+	})
+}
+
+// openAIEmbeddings calls openai/embeddings and decodes the raw body.
+func openAIEmbeddings(t *testing.T, b *vectorBackend, s logical.Storage, path string, data map[string]interface{}) *embeddingsResponse {
+	t.Helper()
+	resp := testRequest(t, b, s, logical.UpdateOperation, path, data)
+	if resp.Data[logical.HTTPContentType] != "application/json" {
+		t.Fatalf("content type = %v", resp.Data[logical.HTTPContentType])
+	}
+	var out embeddingsResponse
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &out); err != nil {
+		t.Fatal(err)
+	}
+	return &out
+}
+
+func TestOpenAIEmbeddings(t *testing.T) {
+	b, s := getTestBackend(t)
+	api := &fakeEmbeddingsAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "openai/embeddings", map[string]interface{}{
+		"input": "hello", "model": "text-embedding-3-small",
+	}); err == nil {
+		t.Error("embedded without config/embeddings")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/embeddings", map[string]interface{}{
+		"url":     server.URL + "/v1",
+		"api_key": "sk-test",
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/embeddings", nil)
+	if _, ok := resp.Data["api_key"]; ok || resp.Data["api_key_set"] != true {
+		t.Errorf("config/embeddings read = %v; the key must be write-only", resp.Data)
+	}
+
+	out := openAIEmbeddings(t, b, s, "openai/embeddings", map[string]interface{}{
+		"input": []interface{}{"first", "second"},
+		"model": "text-embedding-3-small",
+	})
+	if api.last.Model != "text-embedding-3-small" || api.last.EncodingFormat != encodingFloat {
+		t.Errorf("upstream request = %+v", api.last)
+	}
+	if out.Object != "list" || out.Model != "text-embedding-3-small" || len(out.Data) != 2 || len(out.Usage) == 0 {
+		t.Fatalf("response = %+v", out)
+	}
+	for i, item := range out.Data {
+		ciphertext, err := parseVector(item.Embedding)
+		if err != nil || item.Index != i || len(ciphertext) != testDimension {
+			t.Errorf("item %d = %+v", i, item)
+		}
+		if equalFloats(ciphertext, mustParseVector(t, testVector(float64(i)))) {
+			t.Errorf("item %d is the plaintext embedding", i)
+		}
+	}
+	if out.Scheme == nil || out.Scheme.Scheme != schemeSAP || out.Scheme.KeyVersion != 1 || out.Scheme.TransformID == "" {
+		t.Errorf("vector_dpe = %+v", out.Scheme)
+	}
+
+	// A single string, with base64 output as the OpenAI Python client asks.
+	out = openAIEmbeddings(t, b, s, "openai/embeddings", map[string]interface{}{
+		"input":           "hello",
+		"model":           "text-embedding-3-small",
+		"encoding_format": encodingBase64,
+	})
+	encoded, _ := out.Data[0].Embedding.(string)
+	frame, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(out.Data) != 1 || len(frame) != testDimension*float32Size {
+		t.Errorf("base64 embedding = %v (%v)", out.Data, err)
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "openai/embeddings", map[string]interface{}{
+		"input": "hello", "model": "text-embedding-3-small", "dimensions": testDimension * 2,
+	}); err == nil {
+		t.Error("embedded with dimensions other than the key's")
+	}
+}
+
+func TestOpenAIEmbeddingsRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := httptest.NewServer(&fakeEmbeddingsAPI{})
+	defer server.Close()
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/embeddings", map[string]interface{}{
+		"url":     server.URL + "/v1",
+		"api_key": "sk-test",
+		"model":   "fixed-model",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/rag", map[string]interface{}{
+		"allowed_operations": "embeddings",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"allowed_operations": "encrypt",
+	})
+
+	out := openAIEmbeddings(t, b, s, "openai/rag/embeddings", map[string]interface{}{
+		"input": "hello",
+		"model": "client-choice",
+	})
+	if out.Model != "fixed-model" {
+		t.Errorf("model = %q; config/embeddings must override the client's", out.Model)
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "openai/partners/embeddings", map[string]interface{}{
+		"input": "hello",
+	}); err == nil {
+		t.Error("embedded under a role without the embeddings operation")
+	}
+}
+
+func mustParseVector(t *testing.T, raw interface{}) []float64 {
+	t.Helper()
+	v, err := parseVector(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -5,26 +5,128 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
 	"math"
-	"strconv"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"gonum.org/v1/gonum/mat"
 )
 
+const (
+	// precisionFloat64 returns ciphertext components at full precision.
+	precisionFloat64 = "float64"
+
+	// precisionFloat32 rounds ciphertext components to float32.
+	precisionFloat32 = "float32"
+)
+
+// precisionField selects the ciphertext precision of an encrypt request.
+var precisionField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Ciphertext precision: 'float64' or 'float32'. Defaults to the mount's output_precision.",
+}
+
+// encodingArray returns ciphertexts as arrays of numbers. The alternative,
+// encodingBase64, returns one base64 string of packed little-endian
+// components; see packCiphertext.
+const encodingArray = "array"
+
+// encodingField selects how an endpoint's ciphertexts are encoded. Paths
+// that take a ciphertext read it in the same encoding.
+var encodingField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Ciphertext encoding: 'array' of numbers (default), or 'base64' of packed little-endian floats, float32 with precision=float32 and float64 otherwise.",
+	Default:     encodingArray,
+}
+
+// modelField declares the embedding model that produced the plaintext
+// vectors of a request, checked against the key's embedding_model.
+var modelField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Embedding model that produced the vectors. Refused if it differs from the key's embedding_model.",
+}
+
+const (
+	// maxMetadataKeys bounds the number of keys in a request's metadata.
+	maxMetadataKeys = 32
+
+	// maxMetadataBytes bounds the total length of a request's metadata
+	// keys and values.
+	maxMetadataBytes = 4096
+)
+
+// maxPlaintextNormSq bounds the squared norm of a plaintext vector,
+// guarding the transform against numeric overflow.
+const maxPlaintextNormSq = 1e12
+
+// metadataField carries opaque caller metadata, echoed in the response
+// and kept with stored ciphertexts.
+var metadataField = &framework.FieldSchema{
+	Type:        framework.TypeKVPairs,
+	Description: fmt.Sprintf("Opaque string key-value pairs echoed in the response and kept with stored ciphertexts (at most %d keys, %d bytes).", maxMetadataKeys, maxMetadataBytes),
+}
+
+// parseMetadata returns the request's metadata, or nil if it has none.
+func parseMetadata(data *framework.FieldData) (map[string]string, error) {
+	raw, ok := data.GetOk("metadata")
+	if !ok {
+		return nil, nil
+	}
+	metadata := raw.(map[string]string)
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	if len(metadata) > maxMetadataKeys {
+		return nil, fmt.Errorf("metadata has %d keys, exceeding maximum %d", len(metadata), maxMetadataKeys)
+	}
+	size := 0
+	for k, v := range metadata {
+		if k == "" {
+			return nil, fmt.Errorf("metadata keys must be non-empty")
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataBytes {
+		return nil, fmt.Errorf("metadata is %d bytes, exceeding maximum %d", size, maxMetadataBytes)
+	}
+	return metadata, nil
+}
+
 // pathEncrypt returns the path configuration for encrypt/vector.
 func (b *vectorBackend) pathEncrypt() []*framework.Path {
 	return []*framework.Path{
 		{
-			Pattern: "encrypt/vector",
+			Pattern: withOptionalRole("encrypt/vector"),
 			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
 				"vector": {
 					Type:        framework.TypeSlice,
 					Description: "Embedding vector to encrypt (array of floats).",
 				},
+				"precision": precisionField,
+				"encoding":  encodingField,
+				"model":     modelField,
+				"vector_ref": {
+					Type:        framework.TypeString,
+					Description: "Path of a KV v2 secret holding the vector, instead of 'vector'. Requires config/kv.",
+				},
+				"delete_ref": {
+					Type:        framework.TypeBool,
+					Description: "Destroy the version of vector_ref that was read once it is encrypted.",
+				},
+				"id": {
+					Type:        framework.TypeString,
+					Description: "Also store the ciphertext in the mount at ciphertext/<id>, replacing any previous one.",
+				},
+				"ttl":                ttlField,
+				"subject":            subjectField,
+				"metadata":           metadataField,
+				"key_version":        keyVersionField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.CreateOperation: &framework.PathOperation{
@@ -43,6 +145,46 @@ func (b *vectorBackend) pathEncrypt() []*framework.Path {
 	}
 }
 
+// encryptResult is the outcome of encrypting a single vector.
+type encryptResult struct {
+	Ciphertext []float64
+
+	// Clipped is the number of components saturated by the clip policy.
+	Clipped int
+
+	// Outliers describes the plaintext components beyond max_abs_input.
+	Outliers outlierResult
+
+	// RepeatCount is the estimated number of encryptions of this plaintext,
+	// or zero when repeat tracking is disabled.
+	RepeatCount uint32
+
+	// NearNormLimit reports a plaintext norm within reach of
+	// maxPlaintextNormSq, which a slightly larger vector would exceed.
+	NearNormLimit bool
+}
+
+// warnings returns the response warnings for the result under the given settings.
+func (r *encryptResult) warnings(settings *mountSettings) []typedWarning {
+	out := r.Outliers.warnings(settings)
+	if r.NearNormLimit {
+		out = append(out, typedWarning{Code: warnNearNormLimit, Message: fmt.Sprintf(
+			"Plaintext norm is above %v%% of the limit %v; larger vectors will be refused.",
+			nearNormFraction*100, math.Sqrt(maxPlaintextNormSq))})
+	}
+	if settings.RepeatLimit > 0 && int64(r.RepeatCount) > int64(settings.RepeatLimit) {
+		out = append(out, typedWarning{Code: warnRepeatedPlaintext, Message: fmt.Sprintf(
+			"Plaintext has been encrypted approximately %d times (repeat_limit %d); repeated encryptions allow noise averaging.",
+			r.RepeatCount, settings.RepeatLimit)})
+	}
+	if r.Clipped > 0 {
+		out = append(out, typedWarning{Code: warnClippedOutput, Message: fmt.Sprintf(
+			"%d ciphertext components were clipped to ±%v; distances involving this vector are distorted.",
+			r.Clipped, settings.MaxAbsOutput)})
+	}
+	return out
+}
+
 // handleEncryptVector encrypts a vector using the SAP scheme.
 // The encryption formula is: C = s * Q * v + λ
 // Where Q is the orthogonal matrix, s is the scaling factor, and λ is noise.
@@ -55,19 +197,166 @@ func (b *vectorBackend) handleEncryptVector(ctx context.Context, req *logical.Re
 		}
 	}()
 
-	// Parse and validate input vector.
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationEncrypt); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	storeID := data.Get("id").(string)
+	if storeID != "" {
+		if err := role.checkOperation(operationStore); err != nil {
+			return nil, err
+		}
+		if err := checkCiphertextID(storeID); err != nil {
+			return nil, err
+		}
+	}
+	store, err := parseStoreOptions(data, storeID != "")
+	if err != nil {
+		return nil, err
+	}
+	if store.Metadata, err = parseMetadata(data); err != nil {
+		return nil, err
+	}
+
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse and validate input vector directly into a pooled buffer.
 	rawVector := data.Get("vector")
-	vector, err := parseVector(rawVector)
+	vectorRef := data.Get("vector_ref").(string)
+	deleteRef := data.Get("delete_ref").(bool)
+	refVersion := 0
+	if vectorRef != "" {
+		if _, ok := data.GetOk("vector"); ok {
+			return nil, fmt.Errorf("only one of 'vector' or 'vector_ref' may be supplied")
+		}
+		if rawVector, refVersion, err = b.readVectorRef(ctx, req, vectorRef); err != nil {
+			return nil, err
+		}
+	} else if deleteRef {
+		return nil, fmt.Errorf("delete_ref requires vector_ref")
+	}
+	if settings.strict() {
+		if err := checkStrictVectorInput(rawVector); err != nil {
+			return nil, err
+		}
+	}
+	vectorBufPtr := b.borrowFloats()
+	defer b.returnFloats(vectorBufPtr)
+	vector, err := parseVectorInto(*vectorBufPtr, rawVector)
 	if err != nil {
 		return nil, err
 	}
+	b.adoptFloats(vectorBufPtr, vector)
 
 	// Get cached matrix and config (narrow lock scope - lock released after pointer copy).
-	matrix, cfg, err := b.getMatrixAndConfig(ctx, req.Storage)
+	matrix, cfg, derivationContext, err := b.matrixForRequest(ctx, req, role, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	versionWarnings, err := b.keyVersionWarnings(ctx, req.Storage, cfg, data.Get("key_version").(int))
+	if err != nil {
+		return nil, err
+	}
+	if store.KeyID, err = contextKeyID(cfg, derivationContext); err != nil {
+		return nil, err
+	}
+	store.KeyVersion = cfg.version()
+	scheme := newSchemeParams(cfg, store.KeyID)
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationEncrypt, 1)
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector encryption request",
+		"dimension", cfg.Dimension,
+		"client_id", req.ClientToken)
+
+	result, err := b.encrypt(matrix, cfg, settings, vector, encryptOptions{coalesce: true})
 	if err != nil {
 		return nil, err
 	}
+	roundToPrecision(result.Ciphertext, precision)
+
+	if storeID != "" {
+		if err := b.storeCiphertext(ctx, req.Storage, storeID, result.Ciphertext, store); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fail closed: a caller that asked for deletion must not get a
+	// ciphertext while the plaintext lingers. Retrying is harmless.
+	if deleteRef {
+		if err := b.destroyVectorRef(ctx, req, vectorRef, refVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"ciphertext": encodeCiphertext(result.Ciphertext, encoding, precision),
+		},
+	}
+	scheme.addTo(resp.Data)
+	addRequestContext(resp.Data, store.Context, store.KeyID)
+	if result.Clipped > 0 {
+		resp.Data["clipped_components"] = result.Clipped
+	}
+	result.Outliers.addTo(resp.Data)
+	if store.Metadata != nil {
+		resp.Data["metadata"] = store.Metadata
+	}
+	addWarnings(resp, versionWarnings)
+	addWarnings(resp, result.warnings(settings))
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// encryptVector validates a single plaintext vector and encrypts it under the
+// given matrix and config, applying the mount's repeat and clip policies.
+func (b *vectorBackend) encryptVector(matrix *mat.Dense, cfg *rotationConfig, settings *mountSettings, vector []float64) (*encryptResult, error) {
+	return b.encrypt(matrix, cfg, settings, vector, encryptOptions{})
+}
+
+// encryptOptions select the variants of encrypt.
+type encryptOptions struct {
+	// coalesce batches the rotation with those of concurrent requests
+	// (coalesce_window), or without a window splits its rows across
+	// parallelism goroutines. Only single-vector endpoints coalesce: a
+	// batch loop would wait out the window for every item, and spreads its
+	// items over the goroutines instead.
+	coalesce bool
+
+	// noiseless omits the perturbation, for search queries; see query.go.
+	// There is no noise to average, so repeats are not tracked either.
+	noiseless bool
+
+	// noise, if set, is the perturbation instead of a fresh draw, for
+	// query sets that share one; see handleEncryptQueries.
+	noise []float64
+}
 
+// encrypt is encryptVector with options.
+func (b *vectorBackend) encrypt(matrix *mat.Dense, cfg *rotationConfig, settings *mountSettings, vector []float64, opts encryptOptions) (*encryptResult, error) {
 	// Dimension check.
 	if len(vector) != cfg.Dimension {
 		return nil, fmt.Errorf("vector dimension %d does not match configured dimension %d",
@@ -81,110 +370,117 @@ func (b *vectorBackend) handleEncryptVector(ctx context.Context, req *logical.Re
 		}
 	}
 
+	result := &encryptResult{}
+
+	// Outlier policy: components beyond max_abs_input are clipped, scaled
+	// or rejected before they reach the transform.
+	if settings.MaxAbsInput > 0 {
+		adjustedPtr := b.borrowFloats()
+		defer b.returnFloats(adjustedPtr)
+		b.sizeFloats(adjustedPtr, cfg.Dimension)
+		var err error
+		if vector, result.Outliers, err = applyOutlierPolicy(*adjustedPtr, vector, settings); err != nil {
+			return nil, err
+		}
+	}
+
 	// Validate vector norm (DoS mitigation for numeric overflow).
 	var normSq float64
 	for _, v := range vector {
 		normSq += v * v
 	}
-	if normSq > 1e12 {
+	if normSq > maxPlaintextNormSq {
 		return nil, fmt.Errorf("vector magnitude too large")
 	}
+	result.NearNormLimit = normSq > nearNormFraction*nearNormFraction*maxPlaintextNormSq
 
-	// Audit Logging: Log request metadata (NOT the vector content).
-	b.Logger().Info("vector encryption request",
-		"dimension", cfg.Dimension,
-		"client_id", req.ClientToken)
-
-	// === Memory Pooling: Get buffers from pool ===
-
-	// Input buffer.
-	inputSlicePtr := b.floatSlicePool.Get().(*[]float64)
-	defer func() {
-		for i := range *inputSlicePtr {
-			(*inputSlicePtr)[i] = 0
+	// Averaging-attack mitigation: count encryptions of the same plaintext.
+	if settings.RepeatLimit > 0 && !opts.noiseless {
+		seedBytes, err := base64.StdEncoding.DecodeString(cfg.Seed)
+		if err != nil {
+			return nil, fmt.Errorf("decode seed: %w", err)
 		}
-		b.floatSlicePool.Put(inputSlicePtr)
-	}()
-	if cap(*inputSlicePtr) < cfg.Dimension {
-		*inputSlicePtr = make([]float64, cfg.Dimension)
-	} else {
-		*inputSlicePtr = (*inputSlicePtr)[:cfg.Dimension]
-	}
-	copy(*inputSlicePtr, vector)
-
-	// Rotated vector buffer.
-	rotatedSlicePtr := b.floatSlicePool.Get().(*[]float64)
-	defer func() {
-		for i := range *rotatedSlicePtr {
-			(*rotatedSlicePtr)[i] = 0
+		result.RepeatCount = b.repeatSketch.add(fingerprintVector(seedBytes, vector))
+		for i := range seedBytes {
+			seedBytes[i] = 0
 		}
-		b.floatSlicePool.Put(rotatedSlicePtr)
-	}()
-	if cap(*rotatedSlicePtr) < cfg.Dimension {
-		*rotatedSlicePtr = make([]float64, cfg.Dimension)
-	} else {
-		*rotatedSlicePtr = (*rotatedSlicePtr)[:cfg.Dimension]
-	}
-
-	// Noise buffer.
-	noiseSlicePtr := b.floatSlicePool.Get().(*[]float64)
-	defer func() {
-		for i := range *noiseSlicePtr {
-			(*noiseSlicePtr)[i] = 0
+		if int64(result.RepeatCount) > int64(settings.RepeatLimit) && settings.RepeatAction == repeatActionRefuse {
+			b.Logger().Warn("refusing repeated plaintext encryption",
+				"count", result.RepeatCount,
+				"limit", settings.RepeatLimit)
+			return nil, fmt.Errorf("plaintext has been encrypted %d times, exceeding repeat_limit %d",
+				result.RepeatCount, settings.RepeatLimit)
 		}
-		b.floatSlicePool.Put(noiseSlicePtr)
-	}()
-	if cap(*noiseSlicePtr) < cfg.Dimension {
-		*noiseSlicePtr = make([]float64, cfg.Dimension)
-	} else {
-		*noiseSlicePtr = (*noiseSlicePtr)[:cfg.Dimension]
 	}
 
-	// Ciphertext buffer.
-	ciphertextBufPtr := b.floatSlicePool.Get().(*[]float64)
-	defer func() {
-		for i := range *ciphertextBufPtr {
-			(*ciphertextBufPtr)[i] = 0
-		}
-		b.floatSlicePool.Put(ciphertextBufPtr)
-	}()
-	if cap(*ciphertextBufPtr) < cfg.Dimension {
-		*ciphertextBufPtr = make([]float64, cfg.Dimension)
-	} else {
-		*ciphertextBufPtr = (*ciphertextBufPtr)[:cfg.Dimension]
-	}
+	// === Memory Pooling: Get buffers from pool ===
+	// The plaintext is read in place by MulVec and the ciphertext is written
+	// straight into the returned slice, so only intermediates need buffers.
+	rotatedSlicePtr := b.borrowFloats()
+	defer b.returnFloats(rotatedSlicePtr)
+	b.sizeFloats(rotatedSlicePtr, cfg.Dimension)
+
+	noiseSlicePtr := b.borrowFloats()
+	defer b.returnFloats(noiseSlicePtr)
+	b.sizeFloats(noiseSlicePtr, cfg.Dimension)
 
 	// === Step 1: Apply Orthogonal Rotation: v' = Q * v ===
-	input := mat.NewVecDense(cfg.Dimension, *inputSlicePtr)
-	rotatedVec := mat.NewVecDense(cfg.Dimension, *rotatedSlicePtr)
-	rotatedVec.MulVec(matrix, input)
+	// Portable keys rotate in a fixed order and never coalesce, since a
+	// matrix-matrix multiply sums differently.
+	var window time.Duration
+	workers := 1
+	if opts.coalesce {
+		window = settings.CoalesceWindow
+		workers = settings.workers()
+	}
+	if cfg.PortableArithmetic {
+		parallelMulVec(*rotatedSlicePtr, matrix, vector, workers, true)
+	} else if err := b.coalescer.rotate(matrix, vector, *rotatedSlicePtr, window, settings.CoalesceMax, workers); err != nil {
+		return nil, err
+	}
 
 	// === Step 2: Generate Noise (Perturbation): λ ===
-	noise, err := GenerateSecureNoise(*noiseSlicePtr, cfg.Dimension, cfg.ScalingFactor, cfg.ApproximationFactor)
-	if err != nil {
+	noise := (*noiseSlicePtr)[:cfg.Dimension]
+	if opts.noiseless {
+		clear(noise)
+	} else if opts.noise != nil {
+		copy(noise, opts.noise)
+	} else if err := b.generateNoise(matrix, cfg, noise); err != nil {
 		return nil, fmt.Errorf("failed to generate noise: %w", err)
 	}
 
 	// === Step 3: Scale and Add Noise: C = s * v' + λ ===
-	ciphertextBuf := (*ciphertextBufPtr)[:cfg.Dimension]
-	rotatedData := rotatedVec.RawVector().Data
+	ciphertext := make([]float64, cfg.Dimension)
+	rotatedData := *rotatedSlicePtr
+	clipped := 0
 	for i := 0; i < cfg.Dimension; i++ {
-		val := cfg.ScalingFactor*rotatedData[i] + noise[i]
+		// The conversion keeps the compiler from fusing this into an FMA,
+		// whose rounding differs across architectures.
+		val := float64(cfg.ScalingFactor*rotatedData[i]) + noise[i]
 		if math.IsNaN(val) || math.IsInf(val, 0) {
 			return nil, fmt.Errorf("encryption resulted in invalid value at index %d", i)
 		}
-		ciphertextBuf[i] = val
+		// Output range policy for downstream stores with limited numeric range.
+		if settings.MaxAbsOutput > 0 && math.Abs(val) > settings.MaxAbsOutput {
+			if settings.ClipPolicy != clipPolicyClip {
+				return nil, fmt.Errorf("ciphertext component %d exceeds max_abs_output %v", i, settings.MaxAbsOutput)
+			}
+			val = math.Copysign(settings.MaxAbsOutput, val)
+			clipped++
+		}
+		ciphertext[i] = val
+	}
+	if clipped > 0 {
+		b.Logger().Warn("clipped ciphertext components",
+			"clipped", clipped,
+			"dimension", cfg.Dimension,
+			"max_abs_output", settings.MaxAbsOutput)
 	}
 
-	// Copy to result slice (safe to return outside pool lifecycle).
-	resultCiphertext := make([]float64, cfg.Dimension)
-	copy(resultCiphertext, ciphertextBuf)
+	result.Ciphertext = ciphertext
+	result.Clipped = clipped
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"ciphertext": resultCiphertext,
-		},
-	}, nil
+	return result, nil
 }
 
 // encryptExists is the ExistenceCheck for the encrypt path.
@@ -193,92 +489,56 @@ func (b *vectorBackend) encryptExists(context.Context, *logical.Request, *framew
 	return true, nil
 }
 
-// parseVector converts various input formats to []float64.
-// Supports: []float64, []interface{}, JSON string, []string.
-func parseVector(raw interface{}) ([]float64, error) {
-	if raw == nil {
-		return nil, fmt.Errorf("vector is required")
+// requestPrecision returns the precision requested by the 'precision'
+// field, or the mount default.
+func requestPrecision(data *framework.FieldData, settings *mountSettings) (string, error) {
+	raw, ok := data.GetOk("precision")
+	if !ok {
+		return settings.OutputPrecision, nil
 	}
+	precision := raw.(string)
+	if err := checkPrecision(precision); err != nil {
+		return "", fmt.Errorf("precision %w", err)
+	}
+	return precision, nil
+}
 
-	switch v := raw.(type) {
-	case []interface{}:
-		// Handle single JSON string wrapped in slice (Vault CLI behavior).
-		if len(v) == 1 {
-			if str, ok := v[0].(string); ok {
-				return parseVector(str)
-			}
-		}
-		result := make([]float64, len(v))
-		for i, val := range v {
-			num, err := coerceFloat(val)
-			if err != nil {
-				return nil, fmt.Errorf("vector element %d is not a float: %w", i, err)
-			}
-			if math.IsNaN(num) || math.IsInf(num, 0) {
-				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
-			}
-			result[i] = num
-		}
-		return result, nil
+// requestEncoding returns the ciphertext encoding requested by the
+// 'encoding' field.
+func requestEncoding(data *framework.FieldData) (string, error) {
+	encoding := data.Get("encoding").(string)
+	switch encoding {
+	case encodingArray, encodingBase64:
+		return encoding, nil
+	}
+	return "", fmt.Errorf("encoding must be %q or %q (got %q)", encodingArray, encodingBase64, encoding)
+}
 
-	case []float64:
-		for i, num := range v {
-			if math.IsNaN(num) || math.IsInf(num, 0) {
-				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
-			}
-		}
-		result := make([]float64, len(v))
-		copy(result, v)
-		return result, nil
-
-	case string:
-		var parsed []float64
-		if err := json.Unmarshal([]byte(v), &parsed); err != nil {
-			return nil, fmt.Errorf("vector must be JSON array of floats: %w", err)
-		}
-		for i, num := range parsed {
-			if math.IsNaN(num) || math.IsInf(num, 0) {
-				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
-			}
-		}
-		return parsed, nil
-
-	case []string:
-		result := make([]float64, len(v))
-		for i, val := range v {
-			num, err := strconv.ParseFloat(val, 64)
-			if err != nil {
-				return nil, fmt.Errorf("vector element %d is not a float: %w", i, err)
-			}
-			if math.IsNaN(num) || math.IsInf(num, 0) {
-				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
-			}
-			result[i] = num
-		}
-		return result, nil
+// encodeCiphertext renders a ciphertext for a response in the requested
+// encoding.
+func encodeCiphertext(ciphertext []float64, encoding, precision string) interface{} {
+	if encoding == encodingBase64 {
+		return packCiphertext(ciphertext, precision)
+	}
+	return ciphertext
+}
 
-	default:
-		return nil, fmt.Errorf("vector must be an array of floats")
+// checkPrecision returns an error unless precision is a known value.
+func checkPrecision(precision string) error {
+	switch precision {
+	case precisionFloat64, precisionFloat32:
+		return nil
 	}
+	return fmt.Errorf("must be %q or %q (got %q)", precisionFloat64, precisionFloat32, precision)
 }
 
-// coerceFloat converts various numeric types to float64.
-func coerceFloat(val interface{}) (float64, error) {
-	switch t := val.(type) {
-	case float64:
-		return t, nil
-	case float32:
-		return float64(t), nil
-	case int:
-		return float64(t), nil
-	case int64:
-		return float64(t), nil
-	case json.Number:
-		return t.Float64()
-	case string:
-		return strconv.ParseFloat(t, 64)
-	default:
-		return 0, fmt.Errorf("unsupported type %T", val)
+// roundToPrecision rounds ciphertext components in place.
+func roundToPrecision(ciphertext []float64, precision string) {
+	if precision != precisionFloat32 {
+		return
+	}
+	for i, c := range ciphertext {
+		ciphertext[i] = float64(float32(c))
 	}
 }
 
@@ -301,7 +561,25 @@ different ciphertexts on each call. However, the approximate distance
 between any two encrypted vectors is preserved.
 
 Input:
-  vector - Array of floats (must match configured dimension)
+  vector    - Array of floats (must match configured dimension)
+  precision - 'float64' or 'float32' (default: the mount's output_precision);
+              float32 moves each component by at most 2^-24 of its
+              magnitude, far below the noise.
+  encoding  - 'array' (default) or 'base64': one string of the packed
+              little-endian components, float32 or float64 per precision.
+
+  id        - Also store the ciphertext at ciphertext/<id> (optional)
+  context   - Bind the ciphertext to a context, such as a tenant ID
+              (optional). The context is mixed into the derivation of
+              the key, so ciphertexts of different contexts are not
+              comparable and one tenant's ciphertexts cannot be reused
+              in another's index. The response records the context and
+              the key_id it selected. Each distinct context generates a
+              matrix on first use.
+
+  Instead of 'vector', 'vector_ref' may name a KV v2 secret holding the
+  vector (see config/kv); with delete_ref=true the version read is
+  destroyed after encryption.
 
 Output:
   ciphertext - Array of floats (encrypted vector)
@@ -309,4 +587,3 @@ Output:
 Example:
   vault write vector/encrypt/vector vector='[0.1, 0.2, 0.3, ...]'
 `
-
@@ -0,0 +1,67 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/hashicorp/go-kms-wrapping/entropy/v2"
+)
+
+// Seed sources recorded in rotationConfig.SeedSource.
+const (
+	// seedSourceRand is the plugin process's crypto/rand alone.
+	seedSourceRand = "crypto/rand"
+
+	// seedSourceAugmented is crypto/rand combined with Vault's external
+	// entropy source (entropy augmentation).
+	seedSourceAugmented = "crypto/rand+external"
+
+	// seedSourceCeremony and seedSourceCeremonyAugmented are key
+	// ceremonies (see ceremony.go) whose server state came from
+	// seedSourceRand and seedSourceAugmented respectively.
+	seedSourceCeremony          = "ceremony"
+	seedSourceCeremonyAugmented = "ceremony+external"
+
+	// seedSourceImported is a seed supplied by the caller to config/import.
+	seedSourceImported = "imported"
+)
+
+// randomBytes returns n bytes for key material and the name of their source.
+//
+// Vault Enterprise passes mounts with external_entropy_access a system view
+// that draws from the seal's entropy source (e.g. an HSM). When it is
+// available, crypto/rand output is XORed with n bytes from it, so the result
+// is at least as unpredictable as the stronger of the two. With
+// requireExternal, its absence is an error instead of a fallback to
+// crypto/rand alone.
+func (b *vectorBackend) randomBytes(n int, requireExternal bool) ([]byte, string, error) {
+	sourcer, external := b.System().(entropy.Sourcer)
+	if !external && requireExternal {
+		return nil, "", fmt.Errorf("require_external_entropy is set, but Vault offers this mount no external entropy source")
+	}
+
+	out := make([]byte, n)
+	if _, err := rand.Read(out); err != nil {
+		return nil, "", err
+	}
+	if !external {
+		return out, seedSourceRand, nil
+	}
+	augment, err := sourcer.GetRandom(n)
+	if err != nil {
+		zeroBytes(out)
+		return nil, "", fmt.Errorf("read external entropy: %w", err)
+	}
+	defer zeroBytes(augment)
+	if len(augment) != n {
+		zeroBytes(out)
+		return nil, "", fmt.Errorf("external entropy source returned %d bytes, expected %d", len(augment), n)
+	}
+	for i := range out {
+		out[i] ^= augment[i]
+	}
+	return out, seedSourceAugmented, nil
+}
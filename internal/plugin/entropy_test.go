@@ -0,0 +1,83 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// entropySystemView is a system view with an external entropy source, as
+// Vault Enterprise passes to mounts with external_entropy_access.
+type entropySystemView struct {
+	*logical.StaticSystemView
+	calls int
+}
+
+func (v *entropySystemView) GetRandom(n int) ([]byte, error) {
+	v.calls++
+	return bytes.Repeat([]byte{0xA5}, n), nil
+}
+
+func TestSeedSource(t *testing.T) {
+	// Without entropy augmentation, keys come from crypto/rand.
+	b, s := getTestBackend(t)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if resp.Data["seed_source"] != seedSourceRand {
+		t.Errorf("seed_source = %v, want %s", resp.Data["seed_source"], seedSourceRand)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"require_external_entropy": true,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	}); err == nil {
+		t.Error("rotated without external entropy although it is required")
+	}
+
+	// With it, both sources are combined.
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	view := &entropySystemView{StaticSystemView: logical.TestSystemView()}
+	config.System = view
+	backend, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, s = backend.(*vectorBackend), config.StorageView
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"require_external_entropy": true,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if resp.Data["seed_source"] != seedSourceAugmented || view.calls != 1 {
+		t.Errorf("seed_source = %v after %d entropy reads, want %s", resp.Data["seed_source"], view.calls, seedSourceAugmented)
+	}
+	cfg, err := b.readConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SeedSource != seedSourceAugmented {
+		t.Errorf("stored seed_source = %q, want %s", cfg.SeedSource, seedSourceAugmented)
+	}
+
+	// The external source alone does not determine the seed.
+	first, _, err := b.randomBytes(seedLength, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := b.randomBytes(seedLength, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("seeds repeat with a constant external source")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathErasure returns the path configuration for erase/subject.
+func (b *vectorBackend) pathErasure() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "erase/subject",
+			Fields: map[string]*framework.FieldSchema{
+				"subject": {
+					Type:        framework.TypeString,
+					Description: "Subject whose stored ciphertexts are erased.",
+					Required:    true,
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Report what would be erased without erasing it.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEraseSubject,
+					Summary:  "Erase everything stored for a data subject.",
+				},
+			},
+			HelpSynopsis:    pathErasureHelpSyn,
+			HelpDescription: pathErasureHelpDesc,
+		},
+	}
+}
+
+// handleEraseSubject deletes every stored ciphertext of a subject and
+// reports the keys and roles they were produced under, so that copies
+// written to vector databases can be erased too.
+func (b *vectorBackend) handleEraseSubject(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	subject := data.Get("subject").(string)
+	if subject == "" {
+		return logical.ErrorResponse("subject is required"), nil
+	}
+	ids, err := b.matchingCiphertextIDs(ctx, req.Storage, &ciphertextFilter{Subject: subject})
+	if err != nil {
+		return nil, err
+	}
+
+	keyIDs := make(map[string]bool)
+	roles := make(map[string]bool)
+	b.indexLock.RLock()
+	for _, id := range ids {
+		if stored := b.ciphertextIndex[id]; stored != nil {
+			keyIDs[stored.KeyID] = true
+			if stored.Role != "" {
+				roles[stored.Role] = true
+			}
+		}
+	}
+	b.indexLock.RUnlock()
+
+	dryRun := data.Get("dry_run").(bool)
+	if !dryRun {
+		for i, id := range ids {
+			if err := b.deleteCiphertext(ctx, req.Storage, id); err != nil {
+				return nil, fmt.Errorf("erased %d of %d ciphertexts: %w", i, len(ids), err)
+			}
+		}
+		// The subject itself is personal data; keep it out of the logs.
+		b.Logger().Info("erased stored ciphertexts for a subject", "count", len(ids))
+		b.emitEvent(ctx, "subject-erased", "count", strconv.Itoa(len(ids)), "identity", callerIdentity(req))
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ids":     ids,
+			"key_ids": sortedKeys(keyIDs),
+			"roles":   sortedKeys(roles),
+			"erased":  !dryRun,
+		},
+	}
+	// Repeat tracking keeps only aggregate counters, which cannot be tied
+	// back to a subject; say so rather than leave the caller guessing.
+	addWarning(resp, warnErasureAggregates, "plaintext repeat-tracking fingerprints are aggregated in memory and not attributable to a subject; nothing further to erase")
+	if len(ids) > 0 {
+		addWarning(resp, warnErasureCopies, "ciphertexts returned to callers are not tracked by the mount; erase copies in vector databases using the listed ids, key_ids and roles")
+	}
+	return resp, nil
+}
+
+// sortedKeys returns the keys of set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Help text constants for the erasure path.
+const pathErasureHelpSyn = `Erase everything stored for a data subject (right to be forgotten).`
+
+const pathErasureHelpDesc = `
+Supports right-to-be-forgotten workflows. Ciphertexts stored with
+encrypt's 'subject' field are deleted, and the response reports what was
+affected so copies elsewhere can be erased as well.
+
+Input:
+  subject - The subject identifier given to encrypt (required)
+  dry_run - Report without erasing (default: false)
+
+Output:
+  ids     - IDs of the ciphertexts erased from ciphertext/
+  key_ids - Keys they were produced under
+  roles   - Roles they were encrypted under
+  erased  - false for a dry run
+
+The mount does not know where callers wrote the ciphertexts it returned.
+Use ids, key_ids and roles to find and delete the copies in each vector
+database. Repeat tracking stores no per-vector fingerprints, only aggregate
+counters, so there is nothing to erase there. The subject is not logged.
+
+Example:
+  vault write vector/erase/subject subject=user-8c1f dry_run=true
+  vault write vector/erase/subject subject=user-8c1f
+`
@@ -0,0 +1,69 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestEraseSubject(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "tenant",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(0), testVector(1)},
+		"ids":     "alice-1,alice-2",
+		"subject": "alice",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/tenant", map[string]interface{}{
+		"vector":  testVector(2),
+		"id":      "alice-3",
+		"subject": "alice",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":  testVector(3),
+		"id":      "bob-1",
+		"subject": "bob",
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "erase/subject", map[string]interface{}{
+		"subject": "alice",
+		"dry_run": true,
+	})
+	if got := resp.Data["ids"].([]string); !slices.Equal(got, []string{"alice-1", "alice-2", "alice-3"}) {
+		t.Errorf("dry run ids = %v", got)
+	}
+	if got := resp.Data["key_ids"].([]string); len(got) != 2 {
+		t.Errorf("key_ids = %v, want the mount key and the tenant key", got)
+	}
+	if got := resp.Data["roles"].([]string); !slices.Equal(got, []string{"tenant"}) {
+		t.Errorf("roles = %v, want [tenant]", got)
+	}
+	resp = testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+	if got := resp.Data["keys"].([]string); len(got) != 4 {
+		t.Errorf("dry run deleted ciphertexts: keys = %v", got)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "erase/subject", map[string]interface{}{
+		"subject": "alice",
+	})
+	if resp.Data["erased"] != true {
+		t.Errorf("erased = %v, want true", resp.Data["erased"])
+	}
+	resp = testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+	if got := resp.Data["keys"].([]string); !slices.Equal(got, []string{"bob-1"}) {
+		t.Errorf("keys after erasure = %v, want [bob-1]", got)
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/bob-1", nil)
+	if resp.Data["subject"] != "bob" {
+		t.Errorf("subject = %v, want bob", resp.Data["subject"])
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// eventTypePrefix namespaces the event types the plugin emits.
+const eventTypePrefix = "vector-dpe/"
+
+// emitEvent sends a Vault event of the given type with string metadata
+// pairs, and queues it for the SIEM exporter of config/siem. Events are
+// best-effort notifications: failures are logged, never returned, and a
+// Vault without events enabled is not an error.
+func (b *vectorBackend) emitEvent(ctx context.Context, eventType string, metadataPairs ...string) {
+	b.forwardSIEM(ctx, eventType, metadataPairs)
+	err := logical.SendEvent(ctx, b.Backend, eventTypePrefix+eventType, metadataPairs...)
+	switch {
+	case errors.Is(err, framework.ErrNoEvents):
+		b.Logger().Debug("events unavailable; not sent", "event_type", eventTypePrefix+eventType)
+	case err != nil:
+		b.Logger().Warn("failed to send event", "event_type", eventTypePrefix+eventType, "error", err)
+	}
+}
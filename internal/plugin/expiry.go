@@ -0,0 +1,107 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// ciphertextExpiryPrefix holds the expiry schedule of stored
+	// ciphertexts: one empty entry per TTL, named so that listing returns
+	// them in expiry order.
+	ciphertextExpiryPrefix = "ciphertext-expiry/"
+
+	// expiryTimeWidth is the width of the zero-padded Unix-nanosecond time
+	// leading each schedule entry name.
+	expiryTimeWidth = 20
+)
+
+// ttlField is the schema of the 'ttl' field of encrypt/vector and
+// encrypt/batch.
+var ttlField = &framework.FieldSchema{
+	Type:        framework.TypeDurationSecond,
+	Description: "Delete the stored ciphertext after this duration (e.g. '30m'). Requires storing; 0 keeps it until deleted.",
+}
+
+// storeTTL returns the validated 'ttl' of a request that stores ciphertexts
+// if storing is true.
+func storeTTL(data *framework.FieldData, storing bool) (time.Duration, error) {
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	switch {
+	case ttl < 0:
+		return 0, fmt.Errorf("ttl must be non-negative")
+	case ttl > 0 && !storing:
+		return 0, fmt.Errorf("ttl requires storing the ciphertext")
+	}
+	return ttl, nil
+}
+
+// expired reports whether the ciphertext's TTL has passed at now.
+func (c *storedCiphertext) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
+// expiryEntry returns the schedule entry name for id expiring at t.
+func expiryEntry(id string, t time.Time) string {
+	return fmt.Sprintf("%0*d_%s", expiryTimeWidth, t.UnixNano(), id)
+}
+
+// parseExpiryEntry splits a schedule entry name into its time and ID.
+func parseExpiryEntry(name string) (time.Time, string, bool) {
+	if len(name) < expiryTimeWidth+2 || name[expiryTimeWidth] != '_' {
+		return time.Time{}, "", false
+	}
+	nanos, err := strconv.ParseInt(name[:expiryTimeWidth], 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(0, nanos), name[expiryTimeWidth+1:], true
+}
+
+// scheduleExpiry records that id expires at t.
+func scheduleExpiry(ctx context.Context, storage logical.Storage, id string, t time.Time) error {
+	return storage.Put(ctx, &logical.StorageEntry{Key: ciphertextExpiryPrefix + expiryEntry(id, t)})
+}
+
+// expireCiphertexts deletes the stored ciphertexts whose TTL has passed at
+// now and returns how many were deleted. Schedule entries are only hints:
+// an ID stored again or deleted since keeps its current entry's expiry.
+func (b *vectorBackend) expireCiphertexts(ctx context.Context, storage logical.Storage, now time.Time) (int, error) {
+	names, err := storage.List(ctx, ciphertextExpiryPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, name := range names {
+		at, id, ok := parseExpiryEntry(name)
+		if ok && at.After(now) {
+			// Names sort by expiry, so the rest are due later.
+			break
+		}
+		if ok {
+			stored, err := readCiphertext(ctx, storage, id)
+			if err != nil {
+				return deleted, err
+			}
+			if stored != nil && stored.expired(now) {
+				if err := b.deleteCiphertext(ctx, storage, id); err != nil {
+					return deleted, err
+				}
+				deleted++
+			}
+		}
+		if err := storage.Delete(ctx, ciphertextExpiryPrefix+name); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCiphertextExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "session-1",
+		"ttl":    "1h",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(1), testVector(2)},
+		"ids":     "session-2,kept",
+		"ttl":     "2h",
+	})
+	// Storing again without a TTL cancels the earlier one.
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(2),
+		"id":     "kept",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(3),
+		"id":     "permanent",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "ciphertext/session-1", nil)
+	if resp.Data["expires_at"] == "" {
+		t.Error("expires_at not set on a ciphertext stored with a ttl")
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "ciphertext/permanent", nil)
+	if resp.Data["expires_at"] != "" {
+		t.Errorf("expires_at = %v, want empty without a ttl", resp.Data["expires_at"])
+	}
+
+	// Load the index so the sweep has to keep it current.
+	testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	now := time.Now()
+	for _, tc := range []struct {
+		at      time.Time
+		deleted int
+		keys    []string
+	}{
+		{now, 0, []string{"kept", "permanent", "session-1", "session-2"}},
+		{now.Add(90 * time.Minute), 1, []string{"kept", "permanent", "session-2"}},
+		{now.Add(3 * time.Hour), 1, []string{"kept", "permanent"}},
+	} {
+		deleted, err := b.expireCiphertexts(ctx, s, tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != tc.deleted {
+			t.Errorf("sweep at %v deleted %d, want %d", tc.at.Sub(now), deleted, tc.deleted)
+		}
+		resp := testRequest(t, b, s, logical.ListOperation, "ciphertext/", nil)
+		if got := resp.Data["keys"].([]string); !slices.Equal(got, tc.keys) {
+			t.Errorf("keys after sweep at %v = %v, want %v", tc.at.Sub(now), got, tc.keys)
+		}
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if resp.Data["candidates"] != 2 {
+		t.Errorf("search candidates = %v, want 2", resp.Data["candidates"])
+	}
+	schedule, err := s.List(ctx, ciphertextExpiryPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule) != 0 {
+		t.Errorf("expiry schedule = %v, want empty", schedule)
+	}
+}
+
+func TestCiphertextTTLRequiresStore(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	for path, data := range map[string]map[string]interface{}{
+		"encrypt/vector": {"vector": testVector(0), "ttl": "1h"},
+		"encrypt/batch":  {"vectors": []interface{}{testVector(0)}, "ttl": "1h"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: ttl without storing succeeded, want error", path)
+		}
+	}
+}
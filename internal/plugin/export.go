@@ -0,0 +1,195 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathExport returns the path configuration for config/export.
+func (b *vectorBackend) pathExport() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/export",
+			Fields: map[string]*framework.FieldSchema{
+				"key_version": {
+					Type:        framework.TypeInt,
+					Description: "Key version to export. Defaults to the current key.",
+				},
+				"public_key": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded RSA public key to wrap the seed for, such as another mount's config/import/wrapping-key.",
+				},
+				"hash_function": {
+					Type:        framework.TypeString,
+					Description: "Hash function of the RSA-OAEP wrapping: SHA1, SHA224, SHA256, SHA384 or SHA512.",
+					Default:     "SHA256",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleConfigExport,
+					Summary:  "Export an exportable key's seed and parameters for backup.",
+				},
+			},
+			HelpSynopsis:    pathExportHelpSyn,
+			HelpDescription: pathExportHelpDesc,
+		},
+	}
+}
+
+// handleConfigExport returns the seed and parameters of a key created with
+// exportable=true, in the shape config/import accepts.
+func (b *vectorBackend) handleConfigExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return logical.ErrorResponse("hardening_profile=strict: key export is disabled"), nil
+	}
+	publicKeyPEM := strings.TrimSpace(data.Get("public_key").(string))
+	newHash, err := oaepHash(data.Get("hash_function").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var publicKey *rsa.PublicKey
+	if publicKeyPEM != "" {
+		if publicKey, err = parseRSAPublicKey(publicKeyPEM); err != nil {
+			return logical.ErrorResponse("public_key: %s", err), nil
+		}
+	}
+
+	version := data.Get("key_version").(int)
+	if version < 0 {
+		return logical.ErrorResponse("key_version must not be negative"), nil
+	}
+	var cfg *rotationConfig
+	if version == 0 {
+		cfg, err = b.readConfig(ctx, req.Storage)
+	} else {
+		cfg, err = b.readKeyVersion(ctx, req.Storage, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		if version == 0 {
+			return logical.ErrorResponse(errConfigNotInitialized.Error()), nil
+		}
+		return logical.ErrorResponse("key version %d does not exist", version), nil
+	}
+	if !cfg.Exportable {
+		return logical.ErrorResponse("key version %d was not created with exportable=true", cfg.version()), nil
+	}
+	keyID, err := contextKeyID(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	defer zeroBytes(seed)
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"key_id":               keyID,
+			"key_version":          cfg.version(),
+			"dimension":            cfg.Dimension,
+			"scaling_factor":       cfg.ScalingFactor,
+			"approximation_factor": cfg.ApproximationFactor,
+			"min_noise_radius":     cfg.MinNoiseRadius,
+			"noise_mask":           cfg.NoiseMask.String(),
+			"noise_variance":       []float64(cfg.NoiseVariance),
+			"embedding_model":      cfg.EmbeddingModel,
+			"require_model":        cfg.RequireModel,
+			"split":                cfg.Split,
+			"portable_arithmetic":  cfg.PortableArithmetic,
+			"derived":              cfg.Derived,
+			"exportable":           cfg.Exportable,
+		},
+	}
+	if publicKey != nil {
+		wrapped, err := wrapSeed(publicKey, newHash, seed)
+		if err != nil {
+			return nil, err
+		}
+		resp.Data["ciphertext"] = base64.StdEncoding.EncodeToString(wrapped)
+		resp.Data["hash_function"] = strings.ToUpper(data.Get("hash_function").(string))
+	} else {
+		resp.Data["seed"] = base64.StdEncoding.EncodeToString(seed)
+	}
+
+	b.Logger().Warn("exported key seed", "key_id", keyID, "key_version", cfg.version(),
+		"wrapped", publicKey != nil, "identity", callerIdentity(req))
+	b.emitEvent(ctx, "key-exported", "key_id", keyID, "identity", callerIdentity(req))
+	return resp, nil
+}
+
+// parseRSAPublicKey parses a PEM-encoded PKIX or PKCS #1 RSA public key.
+func parseRSAPublicKey(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, fmt.Errorf("not PEM-encoded")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return checkRSAPublicKey(key)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("a %T, not an RSA key", parsed)
+	}
+	return checkRSAPublicKey(key)
+}
+
+// checkRSAPublicKey refuses keys too small to protect a 256-bit seed.
+func checkRSAPublicKey(key *rsa.PublicKey) (*rsa.PublicKey, error) {
+	if key.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key of %d bits is too small; use at least 2048", key.N.BitLen())
+	}
+	return key, nil
+}
+
+// Help text constants for the export endpoint.
+const pathExportHelpSyn = `Export an exportable key's seed and parameters for backup.`
+
+const pathExportHelpDesc = `
+Returns the seed and parameters of a key created with exportable=true
+(by config/rotate, config/root, config/import or a key ceremony), so that
+the loss of Vault's storage does not make every ciphertext under the key
+unusable. The response is in the shape config/import accepts: importing it
+into any mount reproduces the key, with the same key_id and transform_id.
+
+Pass public_key, a PEM-encoded RSA public key, to receive the seed wrapped
+for its holder instead of in the clear, in the format of transit's BYOK
+import: 'ciphertext' is base64(RSA-OAEP(ephemeral AES-256 key) || RFC 5649
+key wrap of the seed). Another mount's config/import/wrapping-key is such a
+key, so a seed can move between clusters without ever being exposed. Mounts
+under the strict hardening profile refuse every export.
+
+exportable is fixed when a key is created; a key created without it can
+never be exported. Export requires the sudo capability and is logged and
+emitted as an event. The holder of an exported seed holds the key: keep
+exports offline, under at least the protection of Vault itself.
+
+Parameters:
+  key_version   - Key version to export (default: the current key).
+  public_key    - RSA public key to wrap the seed for.
+  hash_function - Hash of the RSA-OAEP wrapping (default: SHA256).
+`
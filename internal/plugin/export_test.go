@@ -0,0 +1,112 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// importFields returns the fields of an export response that config/import
+// takes.
+func importFields(data map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, name := range []string{"seed", "ciphertext", "hash_function", "dimension", "scaling_factor",
+		"approximation_factor", "min_noise_radius", "embedding_model", "require_model", "split", "exportable"} {
+		if v, ok := data[name]; ok {
+			fields[name] = v
+		}
+	}
+	return fields
+}
+
+func TestConfigExport_RoundTrip(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"exportable":           true,
+	})
+	exported := testRequest(t, b, s, logical.UpdateOperation, "config/export", nil)
+	if _, ok := exported.Data["seed"]; !ok {
+		t.Fatal("export without public_key returned no seed")
+	}
+
+	// A second mount imports the backup and encrypts identically.
+	b2, s2 := getTestBackend(t)
+	imported := testRequest(t, b2, s2, logical.UpdateOperation, "config/import", importFields(exported.Data))
+	if imported.Data["key_id"] != exported.Data["key_id"] || imported.Data["exportable"] != true {
+		t.Errorf("imported key_id %v exportable %v, exported key_id %v",
+			imported.Data["key_id"], imported.Data["exportable"], exported.Data["key_id"])
+	}
+	vector := map[string]interface{}{"vector": testVector(0.5)}
+	c1 := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", vector)
+	c2 := testRequest(t, b2, s2, logical.UpdateOperation, "encrypt/vector", vector)
+	if !equalFloats(c1.Data["ciphertext"].([]float64), c2.Data["ciphertext"].([]float64)) {
+		t.Error("the restored key encrypts differently")
+	}
+
+	// Wrapped for the second mount's wrapping key, the seed is not exposed.
+	wrappingKey := testRequest(t, b2, s2, logical.ReadOperation, "config/import/wrapping-key", nil)
+	wrapped := testRequest(t, b, s, logical.UpdateOperation, "config/export", map[string]interface{}{
+		"public_key": wrappingKey.Data["public_key"],
+	})
+	if _, ok := wrapped.Data["seed"]; ok {
+		t.Error("wrapped export returned the seed in the clear")
+	}
+	rewrapped := testRequest(t, b2, s2, logical.UpdateOperation, "config/import", importFields(wrapped.Data))
+	if rewrapped.Data["key_id"] != exported.Data["key_id"] {
+		t.Errorf("wrapped import key_id %v, want %v", rewrapped.Data["key_id"], exported.Data["key_id"])
+	}
+
+	// Older versions stay exportable after rotation.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	old := testRequest(t, b, s, logical.UpdateOperation, "config/export", map[string]interface{}{
+		"key_version": exported.Data["key_version"],
+	})
+	if old.Data["key_id"] != exported.Data["key_id"] {
+		t.Errorf("export of version %v returned key_id %v", exported.Data["key_version"], old.Data["key_id"])
+	}
+}
+
+func TestConfigExport_Refusals(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp, _ := entityRequest(b, s, "", logical.UpdateOperation, "config/export", nil)
+	if resp == nil || !resp.IsError() {
+		t.Errorf("exported a key created without exportable: %v", resp)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":  testDimension,
+		"exportable": true,
+	})
+	resp, _ = entityRequest(b, s, "", logical.UpdateOperation, "config/export", map[string]interface{}{
+		"public_key": "not a key",
+	})
+	if resp == nil || !resp.IsError() {
+		t.Errorf("export accepted an invalid public_key: %v", resp)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+	resp, _ = entityRequest(b, s, "", logical.UpdateOperation, "config/export", nil)
+	if resp == nil || !resp.IsError() {
+		t.Errorf("strict mount exported a seed in the clear: %v", resp)
+	}
+	b2, s2 := getTestBackend(t)
+	wrappingKey := testRequest(t, b2, s2, logical.ReadOperation, "config/import/wrapping-key", nil)
+	resp, _ = entityRequest(b, s, "", logical.UpdateOperation, "config/export", map[string]interface{}{
+		"public_key": wrappingKey.Data["public_key"],
+	})
+	if resp == nil || !resp.IsError() {
+		t.Errorf("strict mount exported a wrapped seed: %v", resp)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultTargetMaxAbs is the largest finite IEEE 754 half-precision value,
+	// the tightest range among common vector DB storage formats.
+	defaultTargetMaxAbs = 65504.0
+
+	// defaultCorpusSize is the corpus size assumed for the typical-case bound.
+	defaultCorpusSize = 1000000
+
+	// maxFitSampleSize bounds the number of vectors accepted by config/fit-scale.
+	maxFitSampleSize = 10000
+)
+
+// scaleFit is the result of fitting a scaling factor to a sample of vectors.
+type scaleFit struct {
+	SampleSize     int
+	MinNorm        float64
+	MeanNorm       float64
+	MaxNorm        float64
+	WorstCase      float64
+	Typical        float64
+	TypicalZScore  float64
+	ApproxFactor   float64
+	MinNoiseRadius float64
+}
+
+// pathFitScale returns the path configuration for config/fit-scale.
+func (b *vectorBackend) pathFitScale() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/fit-scale",
+			Fields: map[string]*framework.FieldSchema{
+				"vectors": {
+					Type:        framework.TypeSlice,
+					Description: "Sample of plaintext vectors (array of float arrays).",
+				},
+				"target_max_abs": {
+					Type:        framework.TypeFloat,
+					Description: "Largest absolute ciphertext component the downstream store can hold (default: float16 max, 65504).",
+					Default:     defaultTargetMaxAbs,
+				},
+				"corpus_size": {
+					Type:        framework.TypeInt,
+					Description: "Expected corpus size, used for the typical-case bound.",
+					Default:     defaultCorpusSize,
+				},
+				"fit_variance": {
+					Type:        framework.TypeBool,
+					Description: "Also return the sample's per-component variance, for config/rotate noise_variance, and fit s to whitened noise.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleFitScale,
+					Summary:  "Recommend a scaling factor from a sample of plaintext vectors.",
+				},
+			},
+			HelpSynopsis:    pathFitScaleHelpSyn,
+			HelpDescription: pathFitScaleHelpDesc,
+		},
+	}
+}
+
+// handleFitScale recommends a scaling factor for the sample.
+// It uses the configured approximation_factor and min_noise_radius when a key
+// exists, otherwise the config/rotate defaults. Nothing is written to storage.
+func (b *vectorBackend) handleFitScale(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vectors, err := parseVectorList(data.Get("vectors"), maxFitSampleSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("vectors must contain at least one vector")
+	}
+
+	target, err := coerceFloat(data.Get("target_max_abs"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid target_max_abs: %w", err)
+	}
+	if target <= 0 || math.IsNaN(target) || math.IsInf(target, 0) {
+		return nil, fmt.Errorf("target_max_abs must be a positive finite number (got %v)", target)
+	}
+
+	corpusSize := data.Get("corpus_size").(int)
+	if corpusSize <= 0 {
+		return nil, fmt.Errorf("corpus_size must be positive (got %d)", corpusSize)
+	}
+
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	dimension := len(vectors[0])
+	beta, floor := defaultApproximation, 0.0
+	if cfg != nil {
+		dimension = cfg.Dimension
+		beta, floor = cfg.ApproximationFactor, cfg.MinNoiseRadius
+	}
+	for i, v := range vectors {
+		if len(v) != dimension {
+			return nil, fmt.Errorf("vector %d has dimension %d, expected %d", i, len(v), dimension)
+		}
+	}
+
+	// Whitened noise reaches R times the largest scale on one component.
+	var variance noiseVariance
+	stretch := 1.0
+	if data.Get("fit_variance").(bool) {
+		variance = fitNoiseVariance(vectors)
+		stretch = variance.maxScale()
+	}
+
+	fit, err := fitScalingFactor(vectors, target, corpusSize, beta*stretch, floor*stretch)
+	if err != nil {
+		return nil, err
+	}
+	fit.ApproxFactor, fit.MinNoiseRadius = beta, floor
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"scaling_factor":         fit.WorstCase,
+			"scaling_factor_typical": fit.Typical,
+			"typical_z_score":        fit.TypicalZScore,
+			"target_max_abs":         target,
+			"approximation_factor":   fit.ApproxFactor,
+			"min_noise_radius":       fit.MinNoiseRadius,
+			"sample_size":            fit.SampleSize,
+			"norm_min":               fit.MinNorm,
+			"norm_mean":              fit.MeanNorm,
+			"norm_max":               fit.MaxNorm,
+		},
+	}
+	if variance != nil {
+		resp.Data["noise_variance"] = []float64(variance)
+		resp.Data["noise_scale_max"] = stretch
+	}
+	return resp, nil
+}
+
+// fitScalingFactor picks s so that every ciphertext component stays within ±target.
+//
+// A component of C = s·Q·v + λ satisfies |c_i| ≤ k·(s·||v|| + R) with
+// R = max(s·β/4, floor). For the worst case k = 1, which guarantees no
+// component ever exceeds the target. Because Q is a Haar-random rotation,
+// components of Q·v behave like N(0, ||v||²/d), so for the typical case
+// k = z/√d where z is the expected maximum z-score over corpusSize·d draws.
+//
+// Solving s·||v||max·k + k·max(s·β/4, floor) ≤ target for s gives
+// s = min(target / (k·(||v||max + β/4)), (target − k·floor) / (k·||v||max)).
+func fitScalingFactor(vectors [][]float64, target float64, corpusSize int, beta, floor float64) (*scaleFit, error) {
+	fit := &scaleFit{
+		SampleSize:     len(vectors),
+		MinNorm:        math.Inf(1),
+		ApproxFactor:   beta,
+		MinNoiseRadius: floor,
+	}
+
+	var sum float64
+	for _, v := range vectors {
+		var normSq float64
+		for _, x := range v {
+			normSq += x * x
+		}
+		norm := math.Sqrt(normSq)
+		sum += norm
+		fit.MinNorm = math.Min(fit.MinNorm, norm)
+		fit.MaxNorm = math.Max(fit.MaxNorm, norm)
+	}
+	fit.MeanNorm = sum / float64(len(vectors))
+
+	if fit.MaxNorm == 0 {
+		return nil, fmt.Errorf("sample vectors are all zero; cannot fit a scaling factor")
+	}
+
+	solve := func(k float64) (float64, error) {
+		if k*floor >= target {
+			return 0, fmt.Errorf("min_noise_radius %v alone exceeds target_max_abs %v", floor, target)
+		}
+		byBeta := target / (k * (fit.MaxNorm + beta/4.0))
+		byFloor := (target - k*floor) / (k * fit.MaxNorm)
+		return math.Min(byBeta, byFloor), nil
+	}
+
+	var err error
+	if fit.WorstCase, err = solve(1); err != nil {
+		return nil, err
+	}
+
+	d := float64(len(vectors[0]))
+	fit.TypicalZScore = math.Sqrt(2 * math.Log(2*d*float64(corpusSize)))
+	k := math.Min(1, fit.TypicalZScore/math.Sqrt(d))
+	if fit.Typical, err = solve(k); err != nil {
+		return nil, err
+	}
+
+	return fit, nil
+}
+
+// Help text constants for the fit-scale path.
+const pathFitScaleHelpSyn = `Recommend a scaling factor that keeps ciphertexts within a numeric range.`
+
+const pathFitScaleHelpDesc = `
+This endpoint takes a sample of plaintext vectors and recommends a scaling
+factor s such that ciphertext components stay within ±target_max_abs, for
+downstream stores with a limited numeric range (e.g. float16 indexes).
+
+It uses the configured approximation_factor and min_noise_radius (or the
+config/rotate defaults when no key exists). Nothing is written to storage;
+pass the recommendation to config/rotate.
+
+Parameters:
+  vectors        - Sample of plaintext vectors (max 10000)
+  target_max_abs - Largest absolute component allowed (default: 65504)
+  corpus_size    - Expected corpus size for the typical bound (default: 1000000)
+  fit_variance   - Also fit a diagonal covariance for whitened noise
+                   (default: false)
+
+Output:
+  scaling_factor         - Guarantees |c_i| <= target_max_abs for any vector
+                           no longer than the sample maximum
+  scaling_factor_typical - Larger s that holds with high probability across
+                           corpus_size vectors, using the rotation's spreading
+  norm_min/mean/max      - Norm statistics of the sample
+  noise_variance         - With fit_variance: the sample's variance per
+                           component, each at least 1% of the mean, to pass
+                           to config/rotate; the scaling factors above then
+                           allow for the largest noise scale
+  noise_scale_max        - With fit_variance: that largest scale
+
+Example:
+  vault write vector/config/fit-scale vectors=@sample.json target_max_abs=65504
+`
@@ -0,0 +1,52 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitScalingFactor(t *testing.T) {
+	vectors := [][]float64{
+		{3, 4, 0, 0}, // norm 5
+		{1, 0, 0, 0}, // norm 1
+	}
+
+	fit, err := fitScalingFactor(vectors, 100, 1000, 4.0, 0)
+	if err != nil {
+		t.Fatalf("fitScalingFactor failed: %v", err)
+	}
+	if fit.MaxNorm != 5 || fit.MinNorm != 1 || fit.MeanNorm != 3 {
+		t.Errorf("norm stats = %v/%v/%v, want 1/3/5", fit.MinNorm, fit.MeanNorm, fit.MaxNorm)
+	}
+
+	// Worst case: s * (5 + 4/4) = 100.
+	if want := 100.0 / 6.0; math.Abs(fit.WorstCase-want) > 1e-9 {
+		t.Errorf("WorstCase = %v, want %v", fit.WorstCase, want)
+	}
+	if fit.Typical < fit.WorstCase {
+		t.Errorf("Typical %v must not be smaller than WorstCase %v", fit.Typical, fit.WorstCase)
+	}
+}
+
+func TestFitScalingFactorNoiseFloor(t *testing.T) {
+	vectors := [][]float64{{1, 0}}
+
+	// The floor dominates: s * 1 + 50 <= 100.
+	fit, err := fitScalingFactor(vectors, 100, 1, 0, 50)
+	if err != nil {
+		t.Fatalf("fitScalingFactor failed: %v", err)
+	}
+	if math.Abs(fit.WorstCase-50) > 1e-9 {
+		t.Errorf("WorstCase = %v, want 50", fit.WorstCase)
+	}
+
+	if _, err := fitScalingFactor(vectors, 10, 1, 0, 50); err == nil {
+		t.Error("expected error when the noise floor exceeds the target")
+	}
+	if _, err := fitScalingFactor([][]float64{{0, 0}}, 10, 1, 1, 0); err == nil {
+		t.Error("expected error for all-zero sample")
+	}
+}
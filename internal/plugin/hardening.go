@@ -0,0 +1,70 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+)
+
+const (
+	// hardeningProfileNone applies no restrictions beyond the individual settings.
+	hardeningProfileNone = "none"
+
+	// hardeningProfileStrict enforces the curated safe defaults below.
+	hardeningProfileStrict = "strict"
+
+	// strictMaxDimension is the largest dimension a strict mount may configure.
+	strictMaxDimension = 4096
+
+	// strictMinNoiseRatio is the smallest noise radius a strict mount accepts,
+	// relative to the scaling factor. It corresponds to approximation_factor 1.
+	strictMinNoiseRatio = 0.25
+)
+
+// strict reports whether the strict hardening profile is in effect.
+func (s *mountSettings) strict() bool {
+	return s.HardeningProfile == hardeningProfileStrict
+}
+
+// checkStrictConfig returns an error if cfg violates the strict profile:
+// an oversized dimension, a noise radius below the mandatory floor (which
+// also rules out deterministic, noiseless encryption) on any component, or
+// a noise mask.
+func checkStrictConfig(cfg *rotationConfig) error {
+	if cfg.Dimension > strictMaxDimension {
+		return fmt.Errorf("hardening_profile=strict: dimension %d exceeds %d", cfg.Dimension, strictMaxDimension)
+	}
+	// Whitened noise must clear the floor on its least perturbed component.
+	if radius := cfg.noiseRadius() * cfg.NoiseVariance.minScale(); radius < strictMinNoiseRatio*cfg.ScalingFactor {
+		return fmt.Errorf("hardening_profile=strict: noise radius %v is below the floor %v (%v × scaling_factor)",
+			radius, strictMinNoiseRatio*cfg.ScalingFactor, strictMinNoiseRatio)
+	}
+	if len(cfg.NoiseMask) > 0 {
+		return fmt.Errorf("hardening_profile=strict: noise_mask leaves components without noise")
+	}
+	return nil
+}
+
+// checkStrictVectorInput returns an error if raw relies on lenient parsing:
+// numbers supplied as strings are rejected. A whole vector supplied as one
+// JSON string (the Vault CLI form) is still accepted, since it is parsed
+// with the strict JSON number grammar.
+func checkStrictVectorInput(raw interface{}) error {
+	switch v := raw.(type) {
+	case []string:
+		return fmt.Errorf("hardening_profile=strict: vector elements must be numbers, not strings")
+	case []interface{}:
+		if len(v) == 1 {
+			if _, ok := v[0].(string); ok {
+				return nil
+			}
+		}
+		for i, val := range v {
+			if _, ok := val.(string); ok {
+				return fmt.Errorf("hardening_profile=strict: vector element %d must be a number, not a string", i)
+			}
+		}
+	}
+	return nil
+}
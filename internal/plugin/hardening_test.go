@@ -0,0 +1,88 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCheckStrictVectorInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     interface{}
+		wantErr bool
+	}{
+		{"numbers", []interface{}{0.1, 0.2}, false},
+		{"cli json string", []interface{}{"[0.1, 0.2]"}, false},
+		{"string elements", []interface{}{"0.1", "0.2"}, true},
+		{"mixed elements", []interface{}{0.1, "0x1p-2"}, true},
+		{"string slice", []string{"0.1", "0.2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkStrictVectorInput(tt.raw); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackendStrictProfile(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	// A noiseless key cannot coexist with the strict profile.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/settings",
+		Data:      map[string]interface{}{"hardening_profile": hardeningProfileStrict},
+		Storage:   s,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rotate it first") {
+		t.Fatalf("enabling strict with a noiseless key: err = %v", err)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+
+	for name, data := range map[string]map[string]interface{}{
+		"noiseless": {"dimension": testDimension, "approximation_factor": 0.0},
+		"oversized": {"dimension": strictMaxDimension + 1},
+	} {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/rotate",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil {
+			t.Errorf("%s rotation accepted under strict profile", name)
+		}
+	}
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector": []interface{}{"0.1", "0.2", "0.3", "0.4", "0.5", "0.6", "0.7", "0.8"}},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("string elements accepted under strict profile")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// historyPrefix is the storage prefix of the configuration audit trail.
+// Entries are named so that they sort chronologically.
+const historyPrefix = "history/"
+
+// historyChange is the old and new value of one changed parameter. A nil
+// value means the parameter was unset.
+type historyChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// historyEntry records one configuration or key lifecycle change. It holds
+// only non-secret parameters; seeds and tokens never appear in it.
+type historyEntry struct {
+	Time        time.Time                `json:"time"`
+	Operation   string                   `json:"operation"`
+	Path        string                   `json:"path"`
+	EntityID    string                   `json:"entity_id,omitempty"`
+	DisplayName string                   `json:"display_name,omitempty"`
+	Changes     map[string]historyChange `json:"changes"`
+}
+
+// responseData renders the entry for API responses.
+func (e *historyEntry) responseData() map[string]interface{} {
+	changes := make(map[string]interface{}, len(e.Changes))
+	for name, change := range e.Changes {
+		changes[name] = map[string]interface{}{
+			"old": change.Old,
+			"new": change.New,
+		}
+	}
+	return map[string]interface{}{
+		"time":         e.Time.Format(time.RFC3339Nano),
+		"operation":    e.Operation,
+		"path":         e.Path,
+		"entity_id":    e.EntityID,
+		"display_name": e.DisplayName,
+		"changes":      changes,
+	}
+}
+
+// pathHistory returns the path configuration for history/.
+func (b *vectorBackend) pathHistory() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "history/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"after": afterField,
+				"limit": limitField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleHistoryList,
+					Summary:  "List configuration changes, oldest first.",
+				},
+			},
+			HelpSynopsis:    pathHistoryHelpSyn,
+			HelpDescription: pathHistoryHelpDesc,
+		},
+		{
+			Pattern: "history/" + framework.GenericNameRegex("id"),
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "History entry ID, as returned by LIST history/.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleHistoryRead,
+					Summary:  "Read a configuration change.",
+				},
+			},
+			HelpSynopsis:    pathHistoryHelpSyn,
+			HelpDescription: pathHistoryHelpDesc,
+		},
+	}
+}
+
+// handleHistoryList lists history entries in chronological order, with
+// who made each change and what kind of change it was.
+func (b *vectorBackend) handleHistoryList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ids, err := req.Storage.List(ctx, historyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	ids, nextAfter, err := pageKeys(ids, data)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		var entry historyEntry
+		found, err := getStorageJSON(ctx, req.Storage, historyPrefix+id, &entry)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		keyInfo[id] = map[string]interface{}{
+			"time":         entry.Time.Format(time.RFC3339Nano),
+			"operation":    entry.Operation,
+			"entity_id":    entry.EntityID,
+			"display_name": entry.DisplayName,
+		}
+	}
+	resp := logical.ListResponseWithInfo(ids, keyInfo)
+	if nextAfter != "" {
+		resp.Data["next_after"] = nextAfter
+	}
+	return resp, nil
+}
+
+// handleHistoryRead returns one history entry with its changes.
+func (b *vectorBackend) handleHistoryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var entry historyEntry
+	found, err := getStorageJSON(ctx, req.Storage, historyPrefix+data.Get("id").(string), &entry)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: entry.responseData(),
+	}, nil
+}
+
+// recordHistory appends an entry for operation with the parameters that
+// differ between before and after. Nothing is recorded if none differ.
+//
+// The change itself has already been applied when this is called, so a
+// failure is reported as such rather than as a failed change.
+func (b *vectorBackend) recordHistory(ctx context.Context, req *logical.Request, operation string, before, after map[string]interface{}) error {
+	changes := make(map[string]historyChange)
+	for name, old := range before {
+		if updated, ok := after[name]; !ok || !reflect.DeepEqual(old, updated) {
+			changes[name] = historyChange{Old: old, New: after[name]}
+		}
+	}
+	for name, updated := range after {
+		if _, ok := before[name]; !ok {
+			changes[name] = historyChange{New: updated}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	entry := &historyEntry{
+		Time:        now,
+		Operation:   operation,
+		Path:        req.Path,
+		EntityID:    req.EntityID,
+		DisplayName: req.DisplayName,
+		Changes:     changes,
+	}
+	// The random suffix keeps entries written in the same nanosecond, on
+	// different nodes, from overwriting each other.
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return fmt.Errorf("change applied but not recorded in history: %w", err)
+	}
+	id := fmt.Sprintf("%020d-%08x", now.UnixNano(), binary.BigEndian.Uint32(suffix[:]))
+	if err := putStorageJSON(ctx, req.Storage, historyPrefix+id, entry); err != nil {
+		return fmt.Errorf("change applied but not recorded in history: %w", err)
+	}
+	return nil
+}
+
+// keyHistoryState returns the non-secret parameters of the mount key and
+// its lifecycle, for recordHistory. It is empty if no key exists.
+func (b *vectorBackend) keyHistoryState(ctx context.Context, storage logical.Storage) (map[string]interface{}, error) {
+	cfg, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return map[string]interface{}{}, nil
+	}
+	keyID, err := b.requestKeyID(&logical.Request{}, nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+	lifecycle, err := b.readLifecycle(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	state := lifecycle.responseData()
+	state["key_id"] = keyID
+	state["dimension"] = cfg.Dimension
+	state["scaling_factor"] = cfg.ScalingFactor
+	state["approximation_factor"] = cfg.ApproximationFactor
+	state["min_noise_radius"] = cfg.MinNoiseRadius
+	state["noise_mask"] = cfg.NoiseMask.String()
+	state["noise_whitened"] = len(cfg.NoiseVariance) > 0
+	state["embedding_model"] = cfg.EmbeddingModel
+	state["require_model"] = cfg.RequireModel
+	state["seed_source"] = cfg.SeedSource
+	state["split"] = cfg.Split
+	state["portable_arithmetic"] = cfg.PortableArithmetic
+	state["derived"] = cfg.Derived
+	state["exportable"] = cfg.Exportable
+	state["key_version"] = cfg.version()
+	return state, nil
+}
+
+// Help text constants for the history paths.
+const pathHistoryHelpSyn = `Review the history of configuration and key lifecycle changes.`
+
+const pathHistoryHelpDesc = `
+Every change to the key (rotation, compromise, lifecycle, disable and
+enable), the mount settings, roles and the KV reference settings appends an
+entry to an append-only history stored in the mount. Each entry records when
+the change was made, by whom (entity ID and display name), the path and
+operation, and the old and new value of every parameter that changed.
+
+Seeds, tokens and other secrets are never recorded; a key rotation shows up
+as a change of key_id. Writes that change nothing are not recorded.
+
+LIST history/ returns entry IDs oldest first, with their time, operation and
+caller in key_info. Pass 'limit' to page through them, and the returned
+'next_after' as 'after' to get the next page. READ history/<id> returns the
+entry's changes.
+`
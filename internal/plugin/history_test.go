@@ -0,0 +1,136 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHistoryRecordsChanges(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "config/settings",
+		Data:        map[string]interface{}{"repeat_limit": 5},
+		Storage:     s,
+		EntityID:    "entity-1",
+		DisplayName: "token-alice",
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("write settings: %v %v", err, resp)
+	}
+	// A write that changes nothing is not recorded.
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"repeat_limit": 5,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"hidden_fields": "warnings",
+	})
+	testRequest(t, b, s, logical.DeleteOperation, "roles/app", nil)
+	testRequest(t, b, s, logical.UpdateOperation, "config/disable", nil)
+
+	list := testRequest(t, b, s, logical.ListOperation, "history/", nil)
+	ids := list.Data["keys"].([]string)
+	var operations []string
+	for _, id := range ids {
+		info := list.Data["key_info"].(map[string]interface{})[id].(map[string]interface{})
+		operations = append(operations, info["operation"].(string))
+	}
+	want := []string{"rotate", "settings", "role-write", "role-delete", "disable"}
+	if len(operations) != len(want) {
+		t.Fatalf("operations = %v, want %v", operations, want)
+	}
+	for i := range want {
+		if operations[i] != want[i] {
+			t.Fatalf("operations = %v, want %v", operations, want)
+		}
+	}
+
+	rotate := testRequest(t, b, s, logical.ReadOperation, "history/"+ids[0], nil)
+	changes := rotate.Data["changes"].(map[string]interface{})
+	if changes["key_id"].(map[string]interface{})["old"] != nil {
+		t.Errorf("initial key_id change = %v, want no old value", changes["key_id"])
+	}
+	if _, ok := changes["seed"]; ok {
+		t.Error("history records the seed")
+	}
+
+	settings := testRequest(t, b, s, logical.ReadOperation, "history/"+ids[1], nil)
+	if settings.Data["entity_id"] != "entity-1" || settings.Data["display_name"] != "token-alice" {
+		t.Errorf("caller = %v/%v, want entity-1/token-alice", settings.Data["entity_id"], settings.Data["display_name"])
+	}
+	changes = settings.Data["changes"].(map[string]interface{})
+	if len(changes) != 1 {
+		t.Errorf("settings changes = %v, want only repeat_limit", changes)
+	}
+	if change := changes["repeat_limit"].(map[string]interface{}); change["new"] != float64(5) {
+		t.Errorf("repeat_limit change = %v, want new 5", change)
+	}
+}
+
+func TestHistoryRotationChangesKeyID(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 2.0,
+	})
+
+	ids := testRequest(t, b, s, logical.ListOperation, "history/", nil).Data["keys"].([]string)
+	if len(ids) != 2 {
+		t.Fatalf("history has %d entries, want 2", len(ids))
+	}
+	changes := testRequest(t, b, s, logical.ReadOperation, "history/"+ids[1], nil).Data["changes"].(map[string]interface{})
+	for _, name := range []string{"key_id", "scaling_factor"} {
+		if _, ok := changes[name]; !ok {
+			t.Errorf("rotation changes = %v, missing %s", changes, name)
+		}
+	}
+	if _, ok := changes["dimension"]; ok {
+		t.Errorf("rotation changes = %v, include the unchanged dimension", changes)
+	}
+}
+
+func TestHistoryPagination(t *testing.T) {
+	b, s := getTestBackend(t)
+	for i := 1; i <= 5; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+			"repeat_limit": i,
+		})
+	}
+
+	var all []string
+	after := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination does not terminate")
+		}
+		resp := testRequest(t, b, s, logical.ListOperation, "history/", map[string]interface{}{
+			"limit": 2,
+			"after": after,
+		})
+		all = append(all, resp.Data["keys"].([]string)...)
+		next, ok := resp.Data["next_after"].(string)
+		if !ok {
+			break
+		}
+		after = next
+	}
+	if len(all) != 5 {
+		t.Fatalf("paged through %d entries, want 5", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i] <= all[i-1] {
+			t.Fatalf("entries %v are not in order", all)
+		}
+	}
+}
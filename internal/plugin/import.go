@@ -0,0 +1,440 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// wrappingKeyStoragePath is the Vault storage path for the RSA key
+	// that wraps seeds imported through config/import.
+	wrappingKeyStoragePath = "config/import/wrapping-key"
+
+	// wrappingKeyBits is the size of the wrapping key, as in Vault's
+	// transit secrets engine.
+	wrappingKeyBits = 4096
+)
+
+// wrappingKeyEntry is the stored wrapping key.
+type wrappingKeyEntry struct {
+	// Key is the PKCS #8 DER encoding of the private key.
+	Key []byte `json:"key"`
+}
+
+// pathImport returns the path configuration for config/import and
+// config/import/wrapping-key.
+func (b *vectorBackend) pathImport() []*framework.Path {
+	fields := keyFields()
+	fields["seed"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Base64 of the 32-byte seed to install. Exclusive with ciphertext.",
+	}
+	fields["ciphertext"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Base64 of the seed wrapped for config/import/wrapping-key, as for transit's import. Exclusive with seed.",
+	}
+	fields["hash_function"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Hash function of the RSA-OAEP wrapping of ciphertext: SHA1, SHA224, SHA256, SHA384 or SHA512.",
+		Default:     "SHA256",
+	}
+	fields["expected_version"] = expectedVersionField
+
+	return []*framework.Path{
+		{
+			Pattern: "config/import",
+			Fields:  fields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleConfigImport,
+					Summary:  "Install a caller-supplied seed as the encryption key.",
+				},
+			},
+			HelpSynopsis:    pathImportHelpSyn,
+			HelpDescription: pathImportHelpDesc,
+		},
+		{
+			Pattern: "config/import/wrapping-key",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleWrappingKeyRead,
+					Summary:  "Read the public key that wraps seeds for config/import.",
+				},
+			},
+			HelpSynopsis:    pathWrappingKeyHelpSyn,
+			HelpDescription: pathWrappingKeyHelpDesc,
+		},
+	}
+}
+
+// handleConfigImport installs a caller-supplied seed as the current key,
+// with the parameters of config/rotate. Mounts given the same seed and
+// parameters derive the same matrix and produce comparable ciphertexts.
+func (b *vectorBackend) handleConfigImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.RequireExternalEntropy {
+		return logical.ErrorResponse("require_external_entropy is set, and an imported seed's entropy cannot be verified"), nil
+	}
+	cfg, expiresAt, err := b.parseKeyConfig(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawSeed := strings.TrimSpace(data.Get("seed").(string))
+	ciphertext := strings.TrimSpace(data.Get("ciphertext").(string))
+	var seed []byte
+	switch {
+	case rawSeed != "" && ciphertext != "":
+		return logical.ErrorResponse("seed and ciphertext are mutually exclusive"), nil
+	case rawSeed != "":
+		if seed, err = base64.StdEncoding.DecodeString(rawSeed); err != nil {
+			return logical.ErrorResponse("seed is not valid base64: %s", err), nil
+		}
+	case ciphertext != "":
+		if seed, err = b.unwrapSeed(ctx, req.Storage, ciphertext, data.Get("hash_function").(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	default:
+		return logical.ErrorResponse("seed or ciphertext is required"), nil
+	}
+	if err := checkImportedSeed(seed); err != nil {
+		zeroBytes(seed)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	cfg.SeedSource = seedSourceImported
+
+	release, err := b.acquireRotationLock(ctx, req, "import", data.Get("expected_version").(int))
+	if err != nil {
+		zeroBytes(seed)
+		return nil, err
+	}
+	defer release()
+	before, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		zeroBytes(seed)
+		return nil, err
+	}
+	lifecycle, err := b.installSeed(ctx, req.Storage, cfg, seed, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	after, err := b.keyHistoryState(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "import", before, after); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("imported key seed", "dimension", cfg.Dimension, "key_version", cfg.version())
+	resp := keyResponse(cfg, lifecycle)
+	keyID, err := contextKeyID(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-imported", "key_id", keyID, "identity", callerIdentity(req))
+	resp.Data["key_id"] = keyID
+	return resp, nil
+}
+
+// checkImportedSeed rejects seeds of the wrong length and the constant
+// seeds (all zero bytes, say) that a broken export script tends to send.
+func checkImportedSeed(seed []byte) error {
+	if len(seed) != seedLength {
+		return fmt.Errorf("seed must be %d bytes, got %d", seedLength, len(seed))
+	}
+	for _, c := range seed[1:] {
+		if c != seed[0] {
+			return nil
+		}
+	}
+	return fmt.Errorf("seed is a single repeated byte; use a random seed")
+}
+
+// unwrapSeed recovers a seed wrapped as for transit's BYOK import: the
+// RSA-OAEP encryption of an ephemeral AES-256 key under the wrapping key,
+// followed by the seed wrapped with that key by AES key wrap with padding
+// (RFC 5649).
+func (b *vectorBackend) unwrapSeed(ctx context.Context, storage logical.Storage, ciphertext, hashName string) ([]byte, error) {
+	newHash, err := oaepHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext is not valid base64: %w", err)
+	}
+	key, err := b.wrappingKey(ctx, storage, false)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no wrapping key; read config/import/wrapping-key first")
+	}
+	if len(blob) <= key.Size() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+
+	ephemeral, err := rsa.DecryptOAEP(newHash(), nil, key, blob[:key.Size()], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap ephemeral key: %w", err)
+	}
+	defer zeroBytes(ephemeral)
+	if len(ephemeral) != 32 {
+		return nil, fmt.Errorf("ephemeral key must be AES-256, got %d bytes", len(ephemeral))
+	}
+	seed, err := unwrapKeyWithPadding(ephemeral, blob[key.Size():])
+	if err != nil {
+		return nil, fmt.Errorf("unwrap seed: %w", err)
+	}
+	return seed, nil
+}
+
+// oaepHash returns the hash named by a transit hash_function value.
+func oaepHash(name string) (func() hash.Hash, error) {
+	switch strings.ToUpper(name) {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA224":
+		return sha256.New224, nil
+	case "", "SHA256":
+		return sha256.New, nil
+	case "SHA384":
+		return sha512.New384, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash_function %q", name)
+	}
+}
+
+// kwpIV is the alternative initial value of RFC 5649.
+var kwpIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// unwrapKeyWithPadding unwraps ciphertext with AES key wrap with padding
+// (RFC 5649).
+func unwrapKeyWithPadding(kek, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 16 || len(ciphertext)%8 != 0 {
+		return nil, fmt.Errorf("wrapped key length %d is invalid", len(ciphertext))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(ciphertext)/8 - 1
+	a := make([]byte, 8)
+	r := make([]byte, 8*n)
+	buf := make([]byte, 16)
+	if n == 1 {
+		block.Decrypt(buf, ciphertext)
+		copy(a, buf[:8])
+		copy(r, buf[8:])
+	} else {
+		copy(a, ciphertext[:8])
+		copy(r, ciphertext[8:])
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i)
+				binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^t)
+				copy(buf[8:], r[8*(i-1):8*i])
+				block.Decrypt(buf, buf)
+				copy(a, buf[:8])
+				copy(r[8*(i-1):], buf[8:])
+			}
+		}
+	}
+	zeroBytes(buf)
+
+	length := int(binary.BigEndian.Uint32(a[4:]))
+	valid := subtle.ConstantTimeCompare(a[:4], kwpIV) == 1 && length > 8*(n-1) && length <= 8*n
+	if valid {
+		for _, c := range r[length:] {
+			valid = valid && c == 0
+		}
+	}
+	if !valid {
+		zeroBytes(r)
+		return nil, fmt.Errorf("integrity check failed")
+	}
+	return r[:length], nil
+}
+
+// wrapKeyWithPadding wraps key with AES key wrap with padding (RFC 5649).
+func wrapKeyWithPadding(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	a := make([]byte, 8)
+	copy(a, kwpIV)
+	binary.BigEndian.PutUint32(a[4:], uint32(len(key)))
+	r := make([]byte, (len(key)+7)/8*8)
+	copy(r, key)
+	n := len(r) / 8
+
+	buf := make([]byte, 16)
+	if n == 1 {
+		copy(buf, a)
+		copy(buf[8:], r)
+		block.Encrypt(buf, buf)
+		zeroBytes(r)
+		return buf, nil
+	}
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[8*(i-1):8*i])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^uint64(n*j+i))
+			copy(r[8*(i-1):], buf[8:])
+		}
+	}
+	zeroBytes(buf)
+	return append(a, r...), nil
+}
+
+// wrapSeed wraps seed for the holder of key in the format config/import
+// accepts: see unwrapSeed.
+func wrapSeed(key *rsa.PublicKey, newHash func() hash.Hash, seed []byte) ([]byte, error) {
+	ephemeral := make([]byte, 32)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+	defer zeroBytes(ephemeral)
+	wrappedKey, err := rsa.EncryptOAEP(newHash(), rand.Reader, key, ephemeral, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap ephemeral key: %w", err)
+	}
+	wrappedSeed, err := wrapKeyWithPadding(ephemeral, seed)
+	if err != nil {
+		return nil, err
+	}
+	return append(wrappedKey, wrappedSeed...), nil
+}
+
+// handleWrappingKeyRead returns the public half of the wrapping key,
+// generating the key on first use.
+func (b *vectorBackend) handleWrappingKeyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	key, err := b.wrappingKey(ctx, req.Storage, true)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	}, nil
+}
+
+// wrappingKey returns the stored wrapping key. With create, a missing key
+// is generated and stored; without, nil is returned.
+func (b *vectorBackend) wrappingKey(ctx context.Context, storage logical.Storage, create bool) (*rsa.PrivateKey, error) {
+	b.importLock.Lock()
+	defer b.importLock.Unlock()
+
+	var entry wrappingKeyEntry
+	found, err := getStorageJSON(ctx, storage, wrappingKeyStoragePath, &entry)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		parsed, err := x509.ParsePKCS8PrivateKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("parse wrapping key: %w", err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("wrapping key is a %T, not an RSA key", parsed)
+		}
+		return key, nil
+	}
+	if !create {
+		return nil, nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, wrappingKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("generate wrapping key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := putStorageJSON(ctx, storage, wrappingKeyStoragePath, &wrappingKeyEntry{Key: der}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Help text constants for the import endpoints.
+const pathImportHelpSyn = `Install a caller-supplied seed as the encryption key (BYOK).`
+
+const pathImportHelpDesc = `
+Installs a 256-bit seed supplied by the caller as the current key, in
+place of the random seed config/rotate generates. The matrix is derived
+from the seed exactly as for a generated key, so two mounts given the same
+seed and the same dimension, scaling_factor, approximation_factor,
+min_noise_radius and split produce comparable ciphertexts and report the
+same key_id and transform_id: a second cluster, or a dev/prod pair.
+
+The seed is sent either in the clear, as base64 in 'seed', or wrapped as
+for the transit secrets engine's BYOK import, in 'ciphertext':
+
+  1. Read config/import/wrapping-key for the mount's RSA-4096 public key.
+  2. Generate an ephemeral AES-256 key and wrap the seed with it using AES
+     key wrap with padding (RFC 5649, KWP).
+  3. Encrypt the ephemeral key with RSA-OAEP under the public key, using
+     hash_function (default SHA256).
+  4. Send base64(RSA ciphertext || KWP ciphertext).
+
+Tools that wrap keys for transit/keys/:name/import produce this format.
+
+Like config/rotate, this replaces the current key (the old one is kept in
+config/versions/), requires the sudo capability, and takes the same key
+parameters. The key's seed_source is 'imported'. Seeds of the wrong length
+or made of one repeated byte are refused, and so is every import while
+require_external_entropy is set, since an imported seed's entropy cannot
+be verified. Anyone holding the seed can derive the key: generate it where
+it will be kept, and destroy copies once imported.
+
+Parameters:
+  seed          - Base64 of the 32-byte seed. Exclusive with ciphertext.
+  ciphertext    - The seed wrapped for config/import/wrapping-key.
+  hash_function - Hash of the RSA-OAEP wrapping (default: SHA256).
+  (and the key parameters and expected_version of config/rotate)
+`
+
+const pathWrappingKeyHelpSyn = `Read the public key that wraps seeds for config/import.`
+
+const pathWrappingKeyHelpDesc = `
+Returns the PEM-encoded public half of the mount's RSA-4096 wrapping key,
+generating the key on first read. Seeds sent to config/import as
+'ciphertext' are wrapped for this key, as for transit's BYOK import. The
+private half never leaves the mount's storage.
+`
@@ -0,0 +1,183 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUnwrapKeyWithPadding(t *testing.T) {
+	// The test vectors of RFC 5649, section 6.
+	kek := mustHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	for _, tc := range []struct{ key, wrapped string }{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	} {
+		wrapped := mustHex(t, tc.wrapped)
+		if got, err := wrapKeyWithPadding(kek, mustHex(t, tc.key)); err != nil || !bytes.Equal(got, wrapped) {
+			t.Errorf("wrap(%s) = %x, want %s", tc.key, got, tc.wrapped)
+		}
+		key, err := unwrapKeyWithPadding(kek, wrapped)
+		if err != nil {
+			t.Fatalf("unwrap(%s): %v", tc.wrapped, err)
+		}
+		if hex.EncodeToString(key) != tc.key {
+			t.Errorf("unwrap(%s) = %x, want %s", tc.wrapped, key, tc.key)
+		}
+
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := unwrapKeyWithPadding(kek, wrapped); err == nil {
+			t.Errorf("unwrap of a corrupted %s succeeded", tc.wrapped)
+		}
+	}
+}
+
+// importSeed imports seed into a fresh noiseless mount and returns it.
+func importSeed(t *testing.T, seed []byte) (*vectorBackend, logical.Storage, *logical.Response) {
+	t.Helper()
+	b, s := getTestBackend(t)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/import", map[string]interface{}{
+		"seed":                 base64.StdEncoding.EncodeToString(seed),
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	return b, s, resp
+}
+
+func TestConfigImport_SameSeedSameKey(t *testing.T) {
+	seed := make([]byte, seedLength)
+	if _, err := rand.Read(seed); err != nil {
+		t.Fatal(err)
+	}
+	b1, s1, resp1 := importSeed(t, seed)
+	b2, s2, resp2 := importSeed(t, seed)
+
+	if resp1.Data["seed_source"] != seedSourceImported {
+		t.Errorf("seed_source = %v, want %s", resp1.Data["seed_source"], seedSourceImported)
+	}
+	if resp1.Data["key_id"] != resp2.Data["key_id"] {
+		t.Errorf("key_id %v != %v for the same seed", resp1.Data["key_id"], resp2.Data["key_id"])
+	}
+
+	// Without noise, both mounts must produce identical ciphertexts.
+	vector := map[string]interface{}{"vector": testVector(0.5)}
+	c1 := testRequest(t, b1, s1, logical.UpdateOperation, "encrypt/vector", vector)
+	c2 := testRequest(t, b2, s2, logical.UpdateOperation, "encrypt/vector", vector)
+	if !equalFloats(c1.Data["ciphertext"].([]float64), c2.Data["ciphertext"].([]float64)) {
+		t.Error("mounts with the same imported seed produced different ciphertexts")
+	}
+	if c1.Data["transform_id"] != c2.Data["transform_id"] {
+		t.Errorf("transform_id %v != %v", c1.Data["transform_id"], c2.Data["transform_id"])
+	}
+}
+
+func TestConfigImport_Wrapped(t *testing.T) {
+	seed := make([]byte, seedLength)
+	if _, err := rand.Read(seed); err != nil {
+		t.Fatal(err)
+	}
+	b, s := getTestBackend(t)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/import/wrapping-key", nil)
+	block, _ := pem.Decode([]byte(resp.Data["public_key"].(string)))
+	if block == nil {
+		t.Fatal("public_key is not PEM")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := parsed.(*rsa.PublicKey)
+	if pub.N.BitLen() != wrappingKeyBits {
+		t.Errorf("wrapping key has %d bits, want %d", pub.N.BitLen(), wrappingKeyBits)
+	}
+	again := testRequest(t, b, s, logical.ReadOperation, "config/import/wrapping-key", nil)
+	if again.Data["public_key"] != resp.Data["public_key"] {
+		t.Error("wrapping key changed between reads")
+	}
+
+	ciphertext, err := wrapSeed(pub, sha256.New, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := testRequest(t, b, s, logical.UpdateOperation, "config/import", map[string]interface{}{
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+		"dimension":  testDimension,
+	})
+	_, _, plain := importSeed(t, seed)
+	if imported.Data["key_id"] != plain.Data["key_id"] {
+		t.Errorf("wrapped import key_id %v, plain import %v", imported.Data["key_id"], plain.Data["key_id"])
+	}
+
+	// Wrapping for the wrong hash fails the OAEP check.
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/import",
+		Data: map[string]interface{}{
+			"ciphertext":    base64.StdEncoding.EncodeToString(ciphertext),
+			"hash_function": "SHA512",
+			"dimension":     testDimension,
+		},
+		Storage: s,
+	})
+	if resp == nil || !resp.IsError() {
+		t.Errorf("import with the wrong hash_function: resp=%v err=%v, want an error response", resp, err)
+	}
+}
+
+func TestConfigImport_Refusals(t *testing.T) {
+	b, s := getTestBackend(t)
+	good := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1, 2}, seedLength/2))
+
+	cases := map[string]map[string]interface{}{
+		"missing":     {},
+		"both":        {"seed": good, "ciphertext": good},
+		"not base64":  {"seed": "!!!"},
+		"short":       {"seed": base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		"constant":    {"seed": base64.StdEncoding.EncodeToString(make([]byte, seedLength))},
+		"no wrap key": {"ciphertext": good},
+	}
+	for name, data := range cases {
+		data["dimension"] = testDimension
+		resp, err := entityRequest(b, s, "", logical.UpdateOperation, "config/import", data)
+		if resp == nil || !resp.IsError() {
+			t.Errorf("%s: resp=%v err=%v, want an error response", name, resp, err)
+		}
+	}
+	if cfg, _ := b.readConfig(context.Background(), s); cfg != nil {
+		t.Error("a refused import installed a key")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"require_external_entropy": true,
+	})
+	resp, err := entityRequest(b, s, "", logical.UpdateOperation, "config/import", map[string]interface{}{
+		"seed":      good,
+		"dimension": testDimension,
+	})
+	if resp == nil || !resp.IsError() {
+		t.Errorf("import under require_external_entropy: resp=%v err=%v, want an error response", resp, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	mathrand "math/rand/v2"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// defaultInvariantTrials is the number of vector pairs tested by default.
+	defaultInvariantTrials = 100
+
+	// maxInvariantTrials bounds the work of a single verify/invariants request.
+	maxInvariantTrials = 1000
+
+	// invariantSlack absorbs floating-point rounding in the distance bound,
+	// relative to the plaintext norms.
+	invariantSlack = 1e-9
+)
+
+// invariantReport summarizes a property-test run.
+type invariantReport struct {
+	Trials    int
+	Failed    int
+	Bound     float64
+	MaxError  float64
+	MeanError float64
+}
+
+// checkDistanceInvariant encrypts trials random pairs of vectors of norm
+// referenceNorm and checks the SAP distance guarantee for each:
+//
+//	|d_enc/s − d_plain| ≤ 2R/s
+//
+// Pairs are drawn from the rng and encrypted with the mount's pure scheme,
+// without repeat tracking or output clipping, which alter ciphertexts by design.
+func (b *vectorBackend) checkDistanceInvariant(matrix *mat.Dense, cfg *rotationConfig, rng *mathrand.Rand, trials int, referenceNorm float64) (*invariantReport, error) {
+	settings := defaultSettings()
+	report := &invariantReport{
+		Trials: trials,
+		Bound:  2 * cfg.maxNoiseNorm() / cfg.ScalingFactor,
+	}
+	var sumError float64
+	x := make([]float64, cfg.Dimension)
+	y := make([]float64, cfg.Dimension)
+	for i := 0; i < trials; i++ {
+		randomVector(rng, x, referenceNorm)
+		if i%10 == 0 {
+			// Identical plaintexts exercise the bound at distance zero.
+			copy(y, x)
+		} else {
+			randomVector(rng, y, referenceNorm)
+		}
+
+		encX, err := b.encryptVector(matrix, cfg, settings, x)
+		if err != nil {
+			return nil, err
+		}
+		encY, err := b.encryptVector(matrix, cfg, settings, y)
+		if err != nil {
+			return nil, err
+		}
+
+		dPlain := euclideanDistance(x, y)
+		dErr := math.Abs(euclideanDistance(encX.Ciphertext, encY.Ciphertext)/cfg.ScalingFactor - dPlain)
+		if dErr > report.Bound+invariantSlack*(1+2*referenceNorm) {
+			report.Failed++
+		}
+		report.MaxError = math.Max(report.MaxError, dErr)
+		sumError += dErr
+	}
+	if trials > 0 {
+		report.MeanError = sumError / float64(trials)
+	}
+	return report, nil
+}
+
+// randomVector fills v with a uniformly random direction of the given norm.
+func randomVector(rng *mathrand.Rand, v []float64, norm float64) {
+	var normSq float64
+	for i := range v {
+		v[i] = rng.NormFloat64()
+		normSq += v[i] * v[i]
+	}
+	scale := norm / math.Sqrt(normSq)
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+// pathInvariants returns the path configuration for verify/invariants.
+func (b *vectorBackend) pathInvariants() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "verify/invariants",
+			Fields: map[string]*framework.FieldSchema{
+				"trials": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Number of random vector pairs to test (max %d).", maxInvariantTrials),
+					Default:     defaultInvariantTrials,
+				},
+				"reference_norm": {
+					Type:        framework.TypeFloat,
+					Description: "L2 norm of the random plaintext vectors (default: 1.0 for normalized embeddings).",
+					Default:     defaultReferenceNorm,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleVerifyInvariants,
+					Summary:  "Property-test distance preservation against the live key.",
+				},
+			},
+			HelpSynopsis:    pathVerifyInvariantsHelpSyn,
+			HelpDescription: pathVerifyInvariantsHelpDesc,
+		},
+	}
+}
+
+// handleVerifyInvariants runs the distance property test against the live key.
+func (b *vectorBackend) handleVerifyInvariants(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	trials := data.Get("trials").(int)
+	if trials <= 0 || trials > maxInvariantTrials {
+		return nil, fmt.Errorf("trials must be between 1 and %d (got %d)", maxInvariantTrials, trials)
+	}
+	referenceNorm, err := coerceFloat(data.Get("reference_norm"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference_norm: %w", err)
+	}
+	if referenceNorm <= 0 || math.IsNaN(referenceNorm) || math.IsInf(referenceNorm, 0) {
+		return nil, fmt.Errorf("reference_norm must be a positive finite number (got %v)", referenceNorm)
+	}
+
+	// Expired keys may still be verified; disabled ones may not.
+	if err := b.checkKeyEnabled(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	matrix, cfg, err := b.getMatrixAndConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	rng, err := NewSecureRNG()
+	if err != nil {
+		return nil, err
+	}
+	report, err := b.checkDistanceInvariant(matrix, cfg, rng, trials, referenceNorm)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"trials":     report.Trials,
+			"passed":     report.Trials - report.Failed,
+			"failed":     report.Failed,
+			"pass":       report.Failed == 0,
+			"bound":      report.Bound,
+			"max_error":  report.MaxError,
+			"mean_error": report.MeanError,
+		},
+	}
+	if report.Failed > 0 {
+		b.Logger().Error("distance invariant violated",
+			"failed", report.Failed,
+			"trials", report.Trials,
+			"max_error", report.MaxError,
+			"bound", report.Bound)
+	}
+	return resp, nil
+}
+
+// Help text constants for the invariants path.
+const pathVerifyInvariantsHelpSyn = `Property-test the distance-preservation guarantee against the live key.`
+
+const pathVerifyInvariantsHelpDesc = `
+This endpoint encrypts random pairs of vectors under the current key and
+checks the Scale-And-Perturb guarantee for every pair:
+
+  |d_enc / s − d_plain| ≤ 2R / s
+
+where R is the noise radius. One pair in ten is identical, testing the bound
+at distance zero. The pure scheme is tested: repeat tracking and output
+clipping are not applied, and no caller data is involved.
+
+Parameters:
+  trials         - Number of vector pairs (default: 100, max: 1000)
+  reference_norm - Norm of the random plaintexts (default: 1.0)
+
+Output:
+  trials, passed, failed - Trial counts
+  pass                   - True if every trial satisfied the bound
+  bound                  - The bound 2R/s
+  max_error, mean_error  - Observed |d_enc/s − d_plain|
+
+A failure indicates a defect (e.g. a corrupted cached matrix) and is logged
+as an error. Run it after upgrades or as a periodic health check.
+
+Example:
+  vault read vector/verify/invariants trials=500
+`
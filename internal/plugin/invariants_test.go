@@ -0,0 +1,82 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	mathrand "math/rand/v2"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDistanceInvariantProperty(t *testing.T) {
+	b, _ := getTestBackend(t)
+	seed := make([]byte, seedLength)
+
+	configs := []rotationConfig{
+		{Dimension: 8, ScalingFactor: 1, ApproximationFactor: 5},
+		{Dimension: 64, ScalingFactor: 0.5, ApproximationFactor: 1},
+		{Dimension: 128, ScalingFactor: 100, ApproximationFactor: 0, MinNoiseRadius: 3},
+		{Dimension: 16, ScalingFactor: 2, ApproximationFactor: 0},
+	}
+	for _, cfg := range configs {
+		cfg.Seed = base64.StdEncoding.EncodeToString(seed)
+		matrix, err := GenerateOrthogonalMatrix(seed, cfg.Dimension)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, norm := range []float64{0.01, 1, 50} {
+			rng := mathrand.New(mathrand.NewPCG(uint64(cfg.Dimension), uint64(norm*100)))
+			report, err := b.checkDistanceInvariant(matrix, &cfg, rng, 200, norm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Failed > 0 {
+				t.Errorf("dim=%d s=%v β=%v norm=%v: %d/%d trials exceed bound %v (max error %v)",
+					cfg.Dimension, cfg.ScalingFactor, cfg.ApproximationFactor, norm,
+					report.Failed, report.Trials, report.Bound, report.MaxError)
+			}
+		}
+	}
+}
+
+func TestDistanceInvariantDetectsCorruptMatrix(t *testing.T) {
+	b, _ := getTestBackend(t)
+	seed := make([]byte, seedLength)
+	cfg := &rotationConfig{
+		Seed:                base64.StdEncoding.EncodeToString(seed),
+		Dimension:           16,
+		ScalingFactor:       1,
+		ApproximationFactor: 0,
+	}
+	matrix, err := GenerateOrthogonalMatrix(seed, cfg.Dimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A scaled matrix is no longer orthogonal and stretches distances.
+	matrix.Scale(1.5, matrix)
+
+	report, err := b.checkDistanceInvariant(matrix, cfg, mathrand.New(mathrand.NewPCG(1, 2)), 50, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed == 0 {
+		t.Error("corrupt matrix passed the invariant check")
+	}
+}
+
+func TestBackendVerifyInvariants(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "verify/invariants", map[string]interface{}{
+		"trials": 50,
+	})
+	if resp.Data["pass"] != true || resp.Data["passed"] != 50 {
+		t.Errorf("unexpected result: %v", resp.Data)
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// keyVersionStoragePrefix is the Vault storage prefix of the keyring:
+	// the keys rotated away from, by version. The current key stays at
+	// configStoragePath.
+	keyVersionStoragePrefix = "config/versions/"
+)
+
+// keyVersionField is the request field pinning encryption to a key version.
+var keyVersionField = &framework.FieldSchema{
+	Type:        framework.TypeInt,
+	Description: "Key version to encrypt with, for clients pinned to an older key during a migration. Default: the current version.",
+}
+
+// version returns the key's version. Keys stored before versioning are
+// version 1.
+func (c *rotationConfig) version() int {
+	if c.Version == 0 {
+		return 1
+	}
+	return c.Version
+}
+
+// archiveCurrentKey moves the current key, if any, into the keyring and
+// numbers next as the version after it. Callers then store next as the
+// current key.
+func (b *vectorBackend) archiveCurrentKey(ctx context.Context, storage logical.Storage, next *rotationConfig) error {
+	current, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		next.Version = 1
+		return nil
+	}
+	archived := *current
+	archived.Version = current.version()
+	if err := putStorageJSON(ctx, storage, keyVersionPath(archived.Version), &archived); err != nil {
+		return fmt.Errorf("archive key version %d: %w", archived.Version, err)
+	}
+	next.Version = archived.Version + 1
+	return nil
+}
+
+// keyVersionPath returns the storage path of an archived key version.
+func keyVersionPath(version int) string {
+	return keyVersionStoragePrefix + strconv.Itoa(version)
+}
+
+// readKeyVersion returns the configuration of a key version, current or
+// archived, or nil if it does not exist.
+func (b *vectorBackend) readKeyVersion(ctx context.Context, storage logical.Storage, version int) (*rotationConfig, error) {
+	current, err := b.readConfig(ctx, storage)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	if version == current.version() {
+		return current, nil
+	}
+	var cfg rotationConfig
+	found, err := getStorageJSON(ctx, storage, keyVersionPath(version), &cfg)
+	if err != nil || !found {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("stored key version %d is invalid: %w", version, err)
+	}
+	return &cfg, nil
+}
+
+// matrixForVersion is matrixForRole for a request that pins a key version.
+// Version 0 selects the current key. Older versions are only available
+// for the mount key, not for role derivation contexts.
+func (b *vectorBackend) matrixForVersion(ctx context.Context, req *logical.Request, role *vectorRole, version int) (*mat.Dense, *rotationConfig, error) {
+	if version == 0 {
+		return b.matrixForRole(ctx, req, role)
+	}
+	if version < 0 {
+		return nil, nil, fmt.Errorf("key_version must be positive (got %d)", version)
+	}
+	if err := b.checkKeyUsable(ctx, req.Storage); err != nil {
+		return nil, nil, err
+	}
+	if role != nil && role.DerivationContext != "" {
+		return nil, nil, fmt.Errorf("key_version is not supported for roles with a derivation context")
+	}
+	matrix, cfg, err := b.versionMatrix(ctx, req.Storage, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Derived {
+		return nil, nil, errDerivedKeyContext
+	}
+	return matrix, cfg, nil
+}
+
+// matrixForRequest is matrixForVersion for an encrypt request, honouring
+// its 'key_version' and 'context' fields. It also returns the resolved
+// derivation context, which identifies the key.
+//
+// A pinned older version must still pass the hardening profile and key
+// policy, which only vetted it, if at all, when it was current: otherwise
+// pinning would reopen the noiseless encryption they forbid. Rewrapping
+// away from such a version remains possible.
+func (b *vectorBackend) matrixForRequest(ctx context.Context, req *logical.Request, role *vectorRole, data *framework.FieldData) (*mat.Dense, *rotationConfig, string, error) {
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	version := data.Get("key_version").(int)
+	if requestContext != "" && version != 0 {
+		return nil, nil, "", fmt.Errorf("key_version is not supported with a context")
+	}
+	derivationContext, err := b.requestDerivationContext(req, role, requestContext)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if version == 0 {
+		matrix, cfg, err := b.matrixForContext(ctx, req, derivationContext)
+		return matrix, cfg, derivationContext, err
+	}
+	matrix, cfg, err := b.matrixForVersion(ctx, req, role, version)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := checkKeyPolicy(ctx, req.Storage, settings, cfg); err != nil {
+		return nil, nil, "", fmt.Errorf("key version %d cannot be pinned: %w", version, err)
+	}
+	return matrix, cfg, derivationContext, nil
+}
+
+// fanOutVersion is a key version a query is fanned out to.
+type fanOutVersion struct {
+	matrix *mat.Dense
+	cfg    *rotationConfig
+	keyID  string
+}
+
+// fanOutVersions returns the versions of the mount key, newest first, that
+// can answer a query of the given model and dimension, for requests with
+// all_versions: during a migration, a vector database may keep an index
+// per version, and a query must be encrypted for each. Versions of another
+// model or dimension are skipped, since their index cannot answer it. As
+// with key_version, the versions are only available for the mount key, and
+// each must pass the hardening profile and key policy.
+func (b *vectorBackend) fanOutVersions(ctx context.Context, req *logical.Request, role *vectorRole, model string, dimension int) ([]fanOutVersion, error) {
+	if role.hides("key_version") {
+		return nil, fmt.Errorf("all_versions is not available under a role that hides key_version")
+	}
+	versions, current, err := b.keyVersions(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, errConfigNotInitialized
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var fanOut []fanOutVersion
+	var mismatch error
+	for i := len(versions) - 1; i >= 0; i-- {
+		matrix, cfg, err := b.matrixForVersion(ctx, req, role, versions[i])
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", versions[i], err)
+		}
+		if err := cfg.checkModel(model); err != nil {
+			mismatch = err
+			continue
+		}
+		if cfg.Dimension != dimension {
+			mismatch = fmt.Errorf("vector dimension %d does not match configured dimension %d", dimension, cfg.Dimension)
+			continue
+		}
+		if err := checkKeyPolicy(ctx, req.Storage, settings, cfg); err != nil {
+			return nil, fmt.Errorf("key version %d cannot be queried: %w", versions[i], err)
+		}
+		keyID, err := contextKeyID(cfg, "")
+		if err != nil {
+			return nil, err
+		}
+		fanOut = append(fanOut, fanOutVersion{matrix: matrix, cfg: cfg, keyID: keyID})
+	}
+	if len(fanOut) == 0 {
+		return nil, fmt.Errorf("no key version can answer the query: %w", mismatch)
+	}
+	return fanOut, nil
+}
+
+// versionMatrix returns the matrix of a key version, generating and caching
+// it on first use. It follows the Check-Lock-Check pattern of
+// getMatrixAndConfig.
+func (b *vectorBackend) versionMatrix(ctx context.Context, storage logical.Storage, version int) (*mat.Dense, *rotationConfig, error) {
+	key := versionFlightKey(version)
+	b.matrixLock.RLock()
+	if cached := b.lru.get(key); cached != nil {
+		b.holdMatrixLocked(ctx, cached.matrix)
+		b.matrixLock.RUnlock()
+		return cached.matrix, cached.cfg, nil
+	}
+	b.matrixLock.RUnlock()
+
+	cfg, err := b.readKeyVersion(ctx, storage, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg == nil {
+		return nil, nil, fmt.Errorf("key version %d does not exist", version)
+	}
+	current, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if current != nil && current.version() == version {
+		return b.getMatrixAndConfig(ctx, storage)
+	}
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.matrixLock.Lock()
+	defer b.matrixLock.Unlock()
+	for {
+		if cached := b.lru.get(key); cached != nil {
+			b.holdMatrixLocked(ctx, cached.matrix)
+			recordMatrixCache(matrixCacheMemory, "version", true)
+			return cached.matrix, cached.cfg, nil
+		}
+		recordMatrixCache(matrixCacheMemory, "version", false)
+
+		matrix, retry, err := b.generateSharedLocked(ctx, storage, key, func() (*mat.Dense, error) {
+			seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+			if err != nil {
+				return nil, fmt.Errorf("decode seed: %w", err)
+			}
+			defer zeroBytes(seed)
+			return b.loadOrGenerateKeyMatrix(cfg, seed)
+		}, func(matrix *mat.Dense) {
+			b.lru.put(key, matrix, cfg, settings.CacheMaxEntries, settings.CacheMaxBytes, b.retireMatrixLocked)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !retry {
+			return matrix, cfg, nil
+		}
+	}
+}
+
+// resetVersionMatrixLocked drops the cached matrix of a key version.
+// MUST be called while holding matrixLock.
+func (b *vectorBackend) resetVersionMatrixLocked(version int) {
+	b.cacheEpoch++
+	b.lru.remove(versionFlightKey(version), b.retireMatrixLocked)
+}
+
+// deleteKeyVersion removes an archived key version from the keyring.
+func (b *vectorBackend) deleteKeyVersion(ctx context.Context, storage logical.Storage, version int) error {
+	if err := storage.Delete(ctx, keyVersionPath(version)); err != nil {
+		return err
+	}
+	b.matrixLock.Lock()
+	b.resetVersionMatrixLocked(version)
+	b.matrixLock.Unlock()
+	return nil
+}
+
+// pathKeyVersions returns the path configuration for config/versions/.
+func (b *vectorBackend) pathKeyVersions() []*framework.Path {
+	return []*framework.Path{
+		// Deleting destroys key material, so it is a sensitive path of its
+		// own rather than DELETE on the version. It precedes the version
+		// pattern, which would match it.
+		{
+			Pattern: "config/versions/delete",
+			Fields: map[string]*framework.FieldSchema{
+				"version": {
+					Type:        framework.TypeInt,
+					Description: "Older key version to delete.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleKeyVersionDelete,
+					Summary:  "Delete an older key version.",
+				},
+			},
+			HelpSynopsis:    pathKeyVersionsHelpSyn,
+			HelpDescription: pathKeyVersionsHelpDesc,
+		},
+		{
+			Pattern: "config/versions/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleKeyVersionList,
+					Summary:  "List the key versions available for encryption.",
+				},
+			},
+			HelpSynopsis:    pathKeyVersionsHelpSyn,
+			HelpDescription: pathKeyVersionsHelpDesc,
+		},
+		{
+			Pattern: "config/versions/" + framework.GenericNameRegex("version"),
+			Fields: map[string]*framework.FieldSchema{
+				"version": {
+					Type:        framework.TypeInt,
+					Description: "Key version.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleKeyVersionRead,
+					Summary:  "Read the parameters of a key version.",
+				},
+			},
+			HelpSynopsis:    pathKeyVersionsHelpSyn,
+			HelpDescription: pathKeyVersionsHelpDesc,
+		},
+	}
+}
+
+// keyVersions returns the current key's version and those of the archived
+// keys, in ascending order, with the current key's config. Both are empty
+// if the mount has no key.
+func (b *vectorBackend) keyVersions(ctx context.Context, storage logical.Storage) ([]int, *rotationConfig, error) {
+	current, err := b.readConfig(ctx, storage)
+	if err != nil || current == nil {
+		return nil, nil, err
+	}
+	names, err := storage.List(ctx, keyVersionStoragePrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions := []int{current.version()}
+	for _, name := range names {
+		if version, err := strconv.Atoi(name); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+	return versions, current, nil
+}
+
+// handleKeyVersionList lists the current and archived key versions.
+func (b *vectorBackend) handleKeyVersionList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	versions, current, err := b.keyVersions(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return logical.ListResponse(nil), nil
+	}
+
+	keys := make([]string, 0, len(versions))
+	keyInfo := make(map[string]interface{}, len(versions))
+	for _, version := range versions {
+		cfg, err := b.readKeyVersion(ctx, req.Storage, version)
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			continue
+		}
+		info, err := b.keyVersionData(req, cfg, current)
+		if err != nil {
+			return nil, err
+		}
+		key := strconv.Itoa(version)
+		keys = append(keys, key)
+		keyInfo[key] = info
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// handleKeyVersionRead returns the parameters of a key version, never its
+// seed.
+func (b *vectorBackend) handleKeyVersionRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readKeyVersion(ctx, req.Storage, data.Get("version").(int))
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	current, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.keyVersionData(req, cfg, current)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: info,
+	}, nil
+}
+
+// handleKeyVersionDelete removes an older key version. Ciphertexts under it
+// stay comparable with each other, but nothing new can be encrypted under
+// it. The current version cannot be deleted; rotate first.
+func (b *vectorBackend) handleKeyVersionDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	version := data.Get("version").(int)
+	if version <= 0 {
+		return logical.ErrorResponse("version must be a positive key version"), nil
+	}
+	release, err := b.acquireRotationLock(ctx, req, "version-delete", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cfg, err := b.readKeyVersion(ctx, req.Storage, version)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return logical.ErrorResponse("key version %d does not exist", version), nil
+	}
+	current, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.version() == version {
+		return logical.ErrorResponse("version %d is the current key; rotate before deleting it", version), nil
+	}
+	before, err := b.keyVersionData(req, cfg, current)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.deleteKeyVersion(ctx, req.Storage, version); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-version-deleted", "key_version", strconv.Itoa(version), "identity", callerIdentity(req))
+	if err := b.recordHistory(ctx, req, "version-delete", before, map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_version": version,
+			"deleted":     true,
+		},
+	}, nil
+}
+
+// keyVersionData renders a key version for API responses.
+func (b *vectorBackend) keyVersionData(req *logical.Request, cfg, current *rotationConfig) (map[string]interface{}, error) {
+	keyID, err := b.requestKeyID(req, nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"key_version":          cfg.version(),
+		"key_id":               keyID,
+		"current":              current != nil && cfg.version() == current.version(),
+		"dimension":            cfg.Dimension,
+		"scaling_factor":       cfg.ScalingFactor,
+		"approximation_factor": cfg.ApproximationFactor,
+		"noise_radius":         cfg.noiseRadius(),
+		"noise_mask":           cfg.NoiseMask.String(),
+		"noise_whitened":       len(cfg.NoiseVariance) > 0,
+		"embedding_model":      cfg.EmbeddingModel,
+		"split":                cfg.Split,
+		"portable_arithmetic":  cfg.PortableArithmetic,
+		"derived":              cfg.Derived,
+		"exportable":           cfg.Exportable,
+		"created_at":           cfg.createdAt(),
+	}, nil
+}
+
+// parseKeyVersionKey returns the version of a keyring storage key, or false
+// if key is not one.
+func parseKeyVersionKey(key string) (int, bool) {
+	name, ok := strings.CutPrefix(key, keyVersionStoragePrefix)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(name)
+	return version, err == nil
+}
+
+// Help text constants for the key version paths.
+const pathKeyVersionsHelpSyn = `List, read and delete the versions of the mount key.`
+
+const pathKeyVersionsHelpDesc = `
+Every rotation (config/rotate, a completed key ceremony, the compromise
+playbook) numbers the new key with the next version and keeps the key it
+replaces. Encryption responses report the key_version of each ciphertext.
+
+During a migration, clients may keep encrypting under an older version by
+passing key_version to encrypt/vector or encrypt/batch, so that new
+ciphertexts remain comparable with the ones already in their vector
+database. Pinning is only available for the mount key, not for roles with
+a derivation context, and is subject to the kill-switch like any other
+encryption.
+
+LIST config/versions/ returns the versions with their key_id and
+parameters. Writing version=<version> to config/versions/delete removes an
+older version once no client needs it; the current version cannot be
+deleted. Every ciphertext under a deleted version can no longer be
+rewrapped, so deleting requires sudo, can be put under dual control, and
+holds the rotation lock. The compromise playbook deletes the compromised
+version itself.
+`
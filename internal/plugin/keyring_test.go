@@ -0,0 +1,202 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestKeyVersions(t *testing.T) {
+	b, s := getTestBackend(t)
+	// Noiseless keys encrypt deterministically, so pinned ciphertexts can
+	// be compared exactly.
+	rotate := func() *logical.Response {
+		return testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+			"dimension":            testDimension,
+			"approximation_factor": 0.0,
+		})
+	}
+	encrypt := func(data map[string]interface{}) *logical.Response {
+		data["vector"] = testVector(1)
+		return testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", data)
+	}
+	equal := func(a, b []float64) bool {
+		for i := range a {
+			if math.Abs(a[i]-b[i]) > 1e-9 {
+				return false
+			}
+		}
+		return true
+	}
+
+	if resp := rotate(); resp.Data["key_version"] != 1 {
+		t.Fatalf("first key version = %v, want 1", resp.Data["key_version"])
+	}
+	resp := encrypt(map[string]interface{}{})
+	if resp.Data["key_version"] != 1 {
+		t.Errorf("ciphertext key_version = %v, want 1", resp.Data["key_version"])
+	}
+	original := resp.Data["ciphertext"].([]float64)
+
+	if resp := rotate(); resp.Data["key_version"] != 2 {
+		t.Fatalf("rotated key version = %v, want 2", resp.Data["key_version"])
+	}
+	resp = encrypt(map[string]interface{}{})
+	if resp.Data["key_version"] != 2 || equal(resp.Data["ciphertext"].([]float64), original) {
+		t.Errorf("encryption after rotation = %v, want a version 2 ciphertext", resp.Data)
+	}
+	resp = encrypt(map[string]interface{}{"key_version": 1})
+	if resp.Data["key_version"] != 1 || !equal(resp.Data["ciphertext"].([]float64), original) {
+		t.Errorf("pinned encryption = %v, want the version 1 ciphertext", resp.Data)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":     []interface{}{testVector(1)},
+		"key_version": 1,
+	})
+	if item := resp.Data["batch_results"].([]batchItemResult)[0]; item.KeyVersion != 1 || !equal(item.Ciphertext, original) {
+		t.Errorf("pinned batch item = %+v, want the version 1 ciphertext", item)
+	}
+
+	resp = testRequest(t, b, s, logical.ListOperation, "config/versions/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+		t.Errorf("versions = %v, want [1 2]", keys)
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/versions/delete", map[string]interface{}{
+		"version": 2,
+	}); err == nil {
+		t.Error("deleted the current key version")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/versions/delete", map[string]interface{}{
+		"version": 1,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 1,
+	}); err == nil {
+		t.Error("encrypted under a deleted key version")
+	}
+}
+
+func TestKeyVersionWithDerivationContext(t *testing.T) {
+	b, s := getTestBackend(t)
+	for i := 0; i < 2; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+			"dimension": testDimension,
+		})
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "tenant-a",
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/vector/tenant", map[string]interface{}{
+		"vector":      testVector(0),
+		"key_version": 1,
+	}); err == nil {
+		t.Error("pinned a key version under a derivation context")
+	}
+}
+
+func TestKeyVersionDelete_Controls(t *testing.T) {
+	b, s := getTestBackend(t)
+	for i := 0; i < 2; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+			"dimension": testDimension,
+		})
+	}
+	exists := func() bool {
+		return testRequest(t, b, s, logical.ReadOperation, "config/versions/1", nil) != nil
+	}
+	deleteVersion := map[string]interface{}{"version": 1}
+
+	// Another node is changing the key.
+	now := time.Now().UTC()
+	if err := putStorageJSON(context.Background(), s, rotationLockStoragePath, &rotationLease{
+		ID:        "other",
+		Operation: "rotate",
+		StartedAt: now,
+		ExpiresAt: now.Add(rotationLockTTL),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := entityRequest(b, s, "", logical.UpdateOperation, "config/versions/delete", deleteVersion)
+	wantConflict(t, err)
+	testRequest(t, b, s, logical.DeleteOperation, "config/rotation-lock", nil)
+
+	// Under dual control, an unapproved delete is only recorded.
+	testRequest(t, b, s, logical.UpdateOperation, "config/dual-control", map[string]interface{}{
+		"paths": "config/versions/delete",
+	})
+	resp, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/versions/delete", deleteVersion)
+	if err != nil || resp.Data["approval_id"] == nil {
+		t.Fatalf("unapproved delete = %v, %v; want a pending approval", resp, err)
+	}
+	if !exists() {
+		t.Error("unapproved delete removed the key version")
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/versions/delete", map[string]interface{}{
+		"version":     1,
+		"approval_id": resp.Data["approval_id"],
+	}); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("delete before approval = %v, want permission denied", err)
+	}
+	if !exists() {
+		t.Error("delete before approval removed the key version")
+	}
+}
+
+func TestKeyVersionPinRespectsHardening(t *testing.T) {
+	b, s := getTestBackend(t)
+	// Version 1 is noiseless; version 2 complies with the strict profile.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	old := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	}).Data["ciphertext"].([]float64)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	pinned := map[string]interface{}{"vector": testVector(1), "key_version": 1}
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", pinned)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"allow_zero_noise": false,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/vector", pinned); err == nil {
+		t.Error("pinned a version below the key policy")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"allow_zero_noise": true,
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+	for _, path := range []string{"encrypt/vector", "encrypt/batch"} {
+		data := map[string]interface{}{"key_version": 1, "vectors": []interface{}{testVector(1)}}
+		if path == "encrypt/vector" {
+			data = pinned
+		}
+		if _, err := entityRequest(b, s, "", logical.UpdateOperation, path, data); err == nil {
+			t.Errorf("%s: pinned a noiseless version under the strict profile", path)
+		}
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 2,
+	})
+
+	// Ciphertexts can still be migrated off the version.
+	testRequest(t, b, s, logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  old,
+		"key_version": 1,
+	})
+}
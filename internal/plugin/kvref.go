@@ -0,0 +1,414 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// kvStoragePath is the Vault storage path for the KV reference settings.
+	kvStoragePath = "config/kv"
+
+	// defaultKVMount is the KV v2 mount read when none is configured.
+	defaultKVMount = "secret"
+
+	// defaultKVField is the secret field holding the plaintext vector.
+	defaultKVField = "vector"
+)
+
+var (
+	// errKVNotConfigured is returned when vector_ref is used before config/kv.
+	errKVNotConfigured = errors.New("vector_ref requires config/kv to be configured")
+)
+
+// kvConfig holds the connection used to resolve vector_ref. Vault does not
+// pass the caller's token to plugins, so references are read with a token
+// of the plugin's own, scoped by AllowedPrefixes.
+type kvConfig struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	CACert  string `json:"ca_cert,omitempty"`
+	Mount   string `json:"mount"`
+	Field   string `json:"field"`
+
+	// AllowedPrefixes are the paths within Mount that vector_ref may name.
+	// They may contain identity templates such as {{identity.entity.id}},
+	// populated per request. An empty list allows nothing.
+	AllowedPrefixes []string `json:"allowed_prefixes"`
+}
+
+// responseData renders the KV settings for API responses. The token is
+// never returned.
+func (c *kvConfig) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"address":          c.Address,
+		"token_set":        c.Token != "",
+		"ca_cert":          c.CACert,
+		"mount":            c.Mount,
+		"field":            c.Field,
+		"allowed_prefixes": c.AllowedPrefixes,
+	}
+}
+
+// validate checks the KV settings before they are stored.
+func (c *kvConfig) validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if c.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	if c.Mount == "" {
+		return fmt.Errorf("mount must not be empty")
+	}
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
+	}
+	for _, prefix := range c.AllowedPrefixes {
+		if _, err := framework.ValidateIdentityTemplate(prefix); err != nil {
+			return fmt.Errorf("allowed_prefixes: %w", err)
+		}
+	}
+	return nil
+}
+
+// pathKV returns the path configuration for config/kv.
+func (b *vectorBackend) pathKV() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/kv",
+			Fields: map[string]*framework.FieldSchema{
+				"address": {
+					Type:        framework.TypeString,
+					Description: "Address of the Vault API used to read vector_ref, e.g. https://127.0.0.1:8200.",
+				},
+				"token": {
+					Type:        framework.TypeString,
+					Description: "Token used to read (and destroy) referenced secrets. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded CA certificate for the Vault API, trusted with those of config/outbound. Defaults to the system roots.",
+				},
+				"mount": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("KV v2 mount holding the referenced vectors (default: %q).", defaultKVMount),
+				},
+				"field": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("Secret field holding the vector (default: %q).", defaultKVField),
+				},
+				"allowed_prefixes": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Paths within the mount that vector_ref may name. May contain identity templates. Empty allows nothing.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleKVRead,
+					Summary:  "Read the KV reference settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleKVWrite,
+					Summary:  "Configure reading plaintext vectors from KV v2.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleKVDelete,
+					Summary:  "Remove the KV reference settings, disabling vector_ref.",
+				},
+			},
+			HelpSynopsis:    pathKVHelpSyn,
+			HelpDescription: pathKVHelpDesc,
+		},
+	}
+}
+
+// handleKVRead returns the KV reference settings, without the token.
+func (b *vectorBackend) handleKVRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readKVConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleKVWrite merges the supplied fields into the stored KV settings.
+func (b *vectorBackend) handleKVWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readKVConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = &kvConfig{Mount: defaultKVMount, Field: defaultKVField}
+	}
+
+	if raw, ok := data.GetOk("address"); ok {
+		cfg.Address = raw.(string)
+	}
+	if raw, ok := data.GetOk("token"); ok {
+		cfg.Token = raw.(string)
+	}
+	if raw, ok := data.GetOk("ca_cert"); ok {
+		cfg.CACert = raw.(string)
+	}
+	if raw, ok := data.GetOk("mount"); ok {
+		cfg.Mount = strings.Trim(raw.(string), "/")
+	}
+	if raw, ok := data.GetOk("field"); ok {
+		cfg.Field = raw.(string)
+	}
+	if raw, ok := data.GetOk("allowed_prefixes"); ok {
+		cfg.AllowedPrefixes = raw.([]string)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Build the client once now so a bad CA certificate fails the write.
+	outbound, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newKVClient(cfg, outbound); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, kvStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	b.resetKVClient()
+	if err := b.recordHistory(ctx, req, "kv-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleKVDelete removes the KV reference settings.
+func (b *vectorBackend) handleKVDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readKVConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, kvStoragePath); err != nil {
+		return nil, err
+	}
+	b.resetKVClient()
+	if cfg == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "kv-delete", cfg.responseData(), map[string]interface{}{})
+}
+
+// readKVConfig retrieves the KV reference settings, or nil if unset.
+func (b *vectorBackend) readKVConfig(ctx context.Context, storage logical.Storage) (*kvConfig, error) {
+	var cfg kvConfig
+	found, err := getStorageJSON(ctx, storage, kvStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// newKVClient builds a Vault API client for cfg under the mount's outbound
+// settings, which may be nil.
+func newKVClient(cfg *kvConfig, outbound *outboundConfig) (*api.Client, error) {
+	clientConfig := api.DefaultConfig()
+	if clientConfig.Error != nil {
+		return nil, clientConfig.Error
+	}
+	clientConfig.Address = cfg.Address
+	httpClient, err := outbound.httpClient(cfg.CACert, 0)
+	if err != nil {
+		return nil, fmt.Errorf("configure TLS: %w", err)
+	}
+	clientConfig.HttpClient = httpClient
+	clientConfig.Timeout = httpClient.Timeout
+	// Retries follow config/outbound, in callOutbound.
+	clientConfig.MaxRetries = 0
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("create Vault client: %w", err)
+	}
+	// The environment of the plugin process must not redirect requests.
+	client.ClearNamespace()
+	client.SetToken(cfg.Token)
+	return client, nil
+}
+
+// getKVClient returns the cached client and settings, building them on
+// first use. It follows the same Check-Lock-Check pattern as getSettings.
+func (b *vectorBackend) getKVClient(ctx context.Context, storage logical.Storage) (*api.Client, *kvConfig, error) {
+	b.kvLock.RLock()
+	if b.kvClient != nil {
+		client, cfg := b.kvClient, b.cachedKVConfig
+		b.kvLock.RUnlock()
+		return client, cfg, nil
+	}
+	b.kvLock.RUnlock()
+
+	b.kvLock.Lock()
+	defer b.kvLock.Unlock()
+
+	if b.kvClient != nil {
+		return b.kvClient, b.cachedKVConfig, nil
+	}
+	cfg, err := b.readKVConfig(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg == nil {
+		return nil, nil, errKVNotConfigured
+	}
+	outbound, err := readOutboundConfig(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newKVClient(cfg, outbound)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.kvClient, b.cachedKVConfig = client, cfg
+	return client, cfg, nil
+}
+
+// resetKVClient drops the cached client so the next use rereads config/kv.
+func (b *vectorBackend) resetKVClient() {
+	b.kvLock.Lock()
+	b.kvClient, b.cachedKVConfig = nil, nil
+	b.kvLock.Unlock()
+}
+
+// checkVectorRef returns the cleaned reference if the request's entity may
+// name it under cfg.AllowedPrefixes.
+func (b *vectorBackend) checkVectorRef(req *logical.Request, cfg *kvConfig, ref string) (string, error) {
+	cleaned := path.Clean("/" + ref)[1:]
+	if cleaned == "" || cleaned != strings.Trim(ref, "/") {
+		return "", fmt.Errorf("vector_ref %q is not a clean path", ref)
+	}
+	for _, prefix := range cfg.AllowedPrefixes {
+		if strings.Contains(prefix, "{{") {
+			if req.EntityID == "" {
+				continue
+			}
+			resolved, err := framework.PopulateIdentityTemplate(prefix, req.EntityID, b.System())
+			if err != nil || resolved == "" {
+				continue
+			}
+			prefix = resolved
+		}
+		if strings.HasPrefix(cleaned, prefix) {
+			return cleaned, nil
+		}
+	}
+	return "", fmt.Errorf("vector_ref %q is not under an allowed prefix", ref)
+}
+
+// readVectorRef reads the plaintext vector stored at ref in the configured
+// KV v2 mount. It returns the raw field value for parseVectorInto, and the
+// secret version read, for destroyVectorRef.
+func (b *vectorBackend) readVectorRef(ctx context.Context, req *logical.Request, ref string) (interface{}, int, error) {
+	client, cfg, err := b.getKVClient(ctx, req.Storage)
+	if err != nil {
+		return nil, 0, err
+	}
+	ref, err = b.checkVectorRef(req, cfg, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	var secret *api.KVSecret
+	err = b.callOutbound(ctx, req.Storage, integrationKV, func(ctx context.Context) error {
+		secret, err = client.KVv2(cfg.Mount).Get(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("read vector_ref: %w", err)
+	}
+	raw, ok := secret.Data[cfg.Field]
+	if !ok {
+		return nil, 0, fmt.Errorf("vector_ref has no %q field", cfg.Field)
+	}
+	if secret.VersionMetadata == nil {
+		return nil, 0, fmt.Errorf("vector_ref: %s is not a KV v2 mount", cfg.Mount)
+	}
+	return raw, secret.VersionMetadata.Version, nil
+}
+
+// destroyVectorRef permanently destroys the given version of ref, so the
+// plaintext does not linger in KV once encrypted.
+func (b *vectorBackend) destroyVectorRef(ctx context.Context, req *logical.Request, ref string, version int) error {
+	client, cfg, err := b.getKVClient(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	ref, err = b.checkVectorRef(req, cfg, ref)
+	if err != nil {
+		return err
+	}
+	err = b.callOutbound(ctx, req.Storage, integrationKV, func(ctx context.Context) error {
+		return client.KVv2(cfg.Mount).Destroy(ctx, ref, []int{version})
+	})
+	if err != nil {
+		return fmt.Errorf("destroy vector_ref: %w", err)
+	}
+	return nil
+}
+
+// Help text constants for the KV reference path.
+const pathKVHelpSyn = `Configure reading plaintext vectors from a KV v2 mount (vector_ref).`
+
+const pathKVHelpDesc = `
+With this configured, encrypt/vector accepts 'vector_ref', the path of a KV
+v2 secret holding the plaintext vector, instead of 'vector'. The plugin
+reads the secret, encrypts the vector, and with delete_ref=true destroys the
+version it read. Plaintext embeddings then never travel through the
+application or its message bus; one pipeline stage writes them to KV and
+another receives only ciphertexts.
+
+Vault does not pass the caller's token to plugins, so the plugin reads with
+its own token. Any client allowed to encrypt can therefore have the plugin
+read paths under allowed_prefixes, whatever its own KV policy says. Keep the
+token's policy and allowed_prefixes narrow, and use identity templates to
+give each entity its own prefix.
+
+Parameters:
+  address          - Vault API address the plugin can reach (required)
+  token            - Token with read (and, for delete_ref, destroy) on the
+                     referenced paths (required, write-only)
+  ca_cert          - PEM CA certificate for the API (default: system roots)
+  mount            - KV v2 mount (default: secret)
+  field            - Secret field holding the vector (default: vector)
+  allowed_prefixes - Paths within the mount that vector_ref may name, e.g.
+                     embeddings/{{identity.entity.id}}/ (default: none)
+
+Client certificates, proxies and timeouts are set for every outbound
+connection in config/outbound.
+
+Example:
+  vault write vector/config/kv address=https://127.0.0.1:8200 \
+      token=@kv-token allowed_prefixes='embeddings/{{identity.entity.id}}/'
+`
@@ -0,0 +1,138 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeKV serves the KV v2 read and destroy endpoints for one mount.
+type fakeKV struct {
+	mu        sync.Mutex
+	secrets   map[string]interface{}
+	destroyed map[string][]int
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Vault-Token") != "kv-token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		value, ok := f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"vector": value},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/secret/destroy/"):
+		var body struct {
+			Versions []int `json:"versions"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.destroyed[strings.TrimPrefix(r.URL.Path, "/v1/secret/destroy/")] = body.Versions
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEncryptVectorRef(t *testing.T) {
+	kv := &fakeKV{
+		secrets: map[string]interface{}{
+			"embeddings/doc1":  testVector(0),
+			"embeddings/doc2":  `[1, 2, 3, 4, 5, 6, 7, 8]`,
+			"private/document": testVector(0),
+		},
+		destroyed: map[string][]int{},
+	}
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/kv", map[string]interface{}{
+		"address":          server.URL,
+		"token":            "kv-token",
+		"allowed_prefixes": "embeddings/",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/kv", nil)
+	if _, ok := resp.Data["token"]; ok || resp.Data["token_set"] != true {
+		t.Errorf("config/kv read = %v, want token_set without token", resp.Data)
+	}
+
+	// A JSON array and a CLI-style JSON string are both accepted.
+	for _, ref := range []string{"embeddings/doc1", "embeddings/doc2"} {
+		resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector_ref": ref,
+		})
+		if got := len(resp.Data["ciphertext"].([]float64)); got != testDimension {
+			t.Errorf("%s: ciphertext has %d components, want %d", ref, got, testDimension)
+		}
+	}
+	if len(kv.destroyed) != 0 {
+		t.Errorf("destroyed %v without delete_ref", kv.destroyed)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector_ref": "embeddings/doc1",
+		"delete_ref": true,
+	})
+	if got := kv.destroyed["embeddings/doc1"]; len(got) != 1 || got[0] != 3 {
+		t.Errorf("destroyed versions = %v, want [3]", got)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"outside prefix":   {"vector_ref": "private/document"},
+		"path traversal":   {"vector_ref": "embeddings/../private/document"},
+		"both inputs":      {"vector_ref": "embeddings/doc1", "vector": testVector(0)},
+		"delete without":   {"vector": testVector(0), "delete_ref": true},
+		"missing referent": {"vector_ref": "embeddings/missing"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/vector",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: encrypt succeeded, want error", name)
+		}
+	}
+}
+
+func TestEncryptVectorRefRequiresConfig(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector_ref": "embeddings/doc1"},
+		Storage:   s,
+	})
+	if err == nil || !strings.Contains(err.Error(), errKVNotConfigured.Error()) {
+		t.Errorf("err = %v, want %v", err, errKVNotConfigured)
+	}
+}
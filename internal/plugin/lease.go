@@ -0,0 +1,118 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// matrixLeaseKey is the context key under which a request's lease is stored.
+type matrixLeaseKey struct{}
+
+// matrixLease records the cached matrices a request has been handed. A
+// matrix stays valid until every lease holding it is released, even if the
+// cache is invalidated meanwhile: invalidation retires the matrix, and the
+// last release zeroes it.
+type matrixLease struct {
+	mu       sync.Mutex
+	matrices []*mat.Dense
+}
+
+// matrixRef counts the leases holding a cached matrix.
+type matrixRef struct {
+	users   int
+	retired bool
+}
+
+// withMatrixLease returns a context carrying a new, empty lease.
+func withMatrixLease(ctx context.Context) (context.Context, *matrixLease) {
+	lease := &matrixLease{}
+	return context.WithValue(ctx, matrixLeaseKey{}, lease), lease
+}
+
+// HandleRequest wraps the framework's dispatch so each request holds a
+// lease on the matrices it uses until its handler returns, and reports the
+// request to telemetry. Writes to paths under dual control are held for
+// approval first.
+func (b *vectorBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	if resp, handled, err := b.checkDualControl(ctx, req); handled {
+		return resp, err
+	}
+	ctx, lease := withMatrixLease(ctx)
+	defer b.releaseLease(lease)
+	ctx, measured := withRequestMetrics(ctx)
+	resp, err := b.Backend.HandleRequest(ctx, req)
+	measured.emit(lease, resp, err)
+	return resp, err
+}
+
+// holdMatrixLocked adds m to the lease carried by ctx, if any.
+// MUST be called while holding matrixLock (read or write), so that the
+// matrix cannot be retired between lookup and hold.
+func (b *vectorBackend) holdMatrixLocked(ctx context.Context, m *mat.Dense) {
+	lease, ok := ctx.Value(matrixLeaseKey{}).(*matrixLease)
+	if !ok {
+		return
+	}
+	b.refLock.Lock()
+	if b.matrixRefs == nil {
+		b.matrixRefs = make(map[*mat.Dense]*matrixRef)
+	}
+	ref := b.matrixRefs[m]
+	if ref == nil {
+		ref = &matrixRef{}
+		b.matrixRefs[m] = ref
+	}
+	ref.users++
+	b.refLock.Unlock()
+
+	lease.mu.Lock()
+	lease.matrices = append(lease.matrices, m)
+	lease.mu.Unlock()
+}
+
+// releaseLease drops the lease's holds, zeroing any retired matrix whose
+// last holder this was.
+func (b *vectorBackend) releaseLease(lease *matrixLease) {
+	lease.mu.Lock()
+	matrices := lease.matrices
+	lease.matrices = nil
+	lease.mu.Unlock()
+
+	b.refLock.Lock()
+	defer b.refLock.Unlock()
+	for _, m := range matrices {
+		ref := b.matrixRefs[m]
+		if ref == nil {
+			continue
+		}
+		ref.users--
+		if ref.users > 0 {
+			continue
+		}
+		if ref.retired {
+			b.coalescer.compute.Forget(m)
+			zeroMatrix(m)
+		}
+		delete(b.matrixRefs, m)
+	}
+}
+
+// retireMatrixLocked zeroes a matrix that has been dropped from the cache,
+// or defers that to its last lease holder if requests still use it.
+// MUST be called while holding matrixLock.
+func (b *vectorBackend) retireMatrixLocked(m *mat.Dense) {
+	b.refLock.Lock()
+	defer b.refLock.Unlock()
+	if ref := b.matrixRefs[m]; ref != nil && ref.users > 0 {
+		ref.retired = true
+		return
+	}
+	b.coalescer.compute.Forget(m)
+	zeroMatrix(m)
+}
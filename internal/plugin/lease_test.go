@@ -0,0 +1,123 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestMatrixLeaseDefersZeroing(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	ctx, lease := withMatrixLease(context.Background())
+	matrix, _, err := b.getMatrixAndConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalidation while the lease is held must leave the matrix intact.
+	b.invalidate(context.Background(), configStoragePath)
+	if mat.Norm(matrix, 2) == 0 {
+		t.Fatal("matrix zeroed while a lease held it")
+	}
+
+	// The last release zeroes the retired matrix.
+	b.releaseLease(lease)
+	if mat.Norm(matrix, 2) != 0 {
+		t.Error("retired matrix not zeroed on release")
+	}
+	if len(b.matrixRefs) != 0 {
+		t.Errorf("matrixRefs has %d entries after release, want 0", len(b.matrixRefs))
+	}
+}
+
+func TestMatrixWithoutLeaseZeroedImmediately(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	matrix, _, err := b.getMatrixAndConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(context.Background(), configStoragePath)
+	if mat.Norm(matrix, 2) != 0 {
+		t.Error("matrix not zeroed on invalidation")
+	}
+}
+
+func TestRotationSwitchesToPrefetchedMatrix(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	ctx, lease := withMatrixLease(context.Background())
+	old, _, err := b.getMatrixAndConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	// The new key's matrix is already cached: the first request after
+	// rotation does not generate it.
+	b.matrixLock.RLock()
+	next, cfg := b.cachedMatrix, b.cachedConfig
+	b.matrixLock.RUnlock()
+	if next == nil || next == old {
+		t.Fatal("rotation did not install the new key's matrix")
+	}
+	stored, err := b.readConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seed != stored.Seed {
+		t.Error("cached config is not the stored key")
+	}
+
+	// The old matrix survives until the request holding it finishes.
+	if mat.Norm(old, 2) == 0 {
+		t.Fatal("old matrix zeroed while a lease held it")
+	}
+	b.releaseLease(lease)
+	if mat.Norm(old, 2) != 0 {
+		t.Error("old matrix not zeroed on release")
+	}
+}
+
+func TestInvalidatePrefetchesMatrix(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	// Another node rotates: the stored key changes and this node is told.
+	cfg, err := b.readConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Seed = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	if err := b.writeConfig(ctx, s, cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(ctx, configStoragePath)
+	b.prefetches.Wait()
+
+	b.matrixLock.RLock()
+	defer b.matrixLock.RUnlock()
+	if b.cachedMatrix == nil || b.cachedConfig.Seed != cfg.Seed {
+		t.Error("new key's matrix not prefetched after invalidation")
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// lifecycleStoragePath is the Vault storage path for the key's lifecycle
+	// state. It is kept apart from the seed so lifecycle changes never
+	// invalidate the cached matrix.
+	lifecycleStoragePath = "config/lifecycle"
+)
+
+var (
+	// errKeyExpired is returned when encrypting under a key past its expires_at.
+	errKeyExpired = errors.New("key has expired; rotate to a new key")
+
+	// errKeyDisabled is returned for any operation on a disabled key.
+	errKeyDisabled = errors.New("key is disabled; call config/enable to restore it")
+)
+
+// keyLifecycle holds lifecycle state for the current key. It is reset on
+// every rotation.
+type keyLifecycle struct {
+	// ExpiresAt is when the key stops accepting encryptions. Nil means never.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Disabled refuses every operation on the key until it is re-enabled.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// checkEncrypt returns an error if the key may not encrypt at now.
+func (l *keyLifecycle) checkEncrypt(now time.Time) error {
+	if l.Disabled {
+		return errKeyDisabled
+	}
+	if l.ExpiresAt != nil && !now.Before(*l.ExpiresAt) {
+		return fmt.Errorf("%w (expired at %s)", errKeyExpired, l.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// responseData renders the lifecycle for API responses.
+func (l *keyLifecycle) responseData() map[string]interface{} {
+	expiresAt := ""
+	if l.ExpiresAt != nil {
+		expiresAt = l.ExpiresAt.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"expires_at": expiresAt,
+		"disabled":   l.Disabled,
+	}
+}
+
+// parseExpiresAt parses an expires_at value: "" for none, otherwise an
+// RFC 3339 time in the future.
+func parseExpiresAt(raw string, now time.Time) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("expires_at must be an RFC 3339 time: %w", err)
+	}
+	if !t.After(now) {
+		return nil, fmt.Errorf("expires_at %s is not in the future", raw)
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// pathLifecycle returns the path configuration for config/lifecycle.
+func (b *vectorBackend) pathLifecycle() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/lifecycle",
+			Fields: map[string]*framework.FieldSchema{
+				"expires_at": {
+					Type:        framework.TypeString,
+					Description: "RFC 3339 time after which the key refuses encryption. Empty clears the deadline.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleLifecycleRead,
+					Summary:  "Read the key's lifecycle state.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleLifecycleWrite,
+					Summary:  "Update the key's lifecycle state.",
+				},
+			},
+			HelpSynopsis:    pathLifecycleHelpSyn,
+			HelpDescription: pathLifecycleHelpDesc,
+		},
+		{
+			Pattern: "config/disable",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleKeyDisable,
+					Summary:  "Immediately refuse all operations with the key.",
+				},
+			},
+			HelpSynopsis:    pathDisableHelpSyn,
+			HelpDescription: pathDisableHelpDesc,
+		},
+		{
+			Pattern: "config/enable",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleKeyEnable,
+					Summary:  "Restore a disabled key.",
+				},
+			},
+			HelpSynopsis:    pathDisableHelpSyn,
+			HelpDescription: pathDisableHelpDesc,
+		},
+	}
+}
+
+// handleLifecycleRead returns the key's lifecycle state.
+func (b *vectorBackend) handleLifecycleRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	lifecycle, err := b.readLifecycle(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: lifecycle.responseData(),
+	}, nil
+}
+
+// handleLifecycleWrite updates the key's lifecycle state. Fields that are
+// not supplied keep their current value.
+func (b *vectorBackend) handleLifecycleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+
+	lifecycle, err := b.readLifecycle(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := lifecycle.responseData()
+	if raw, ok := data.GetOk("expires_at"); ok {
+		if lifecycle.ExpiresAt, err = parseExpiresAt(raw.(string), time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.writeLifecycle(ctx, req.Storage, lifecycle); err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "lifecycle", before, lifecycle.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: lifecycle.responseData(),
+	}, nil
+}
+
+// handleKeyDisable sets the kill-switch and zeroizes the cached matrices, so
+// no key material stays in memory while the key is disabled.
+func (b *vectorBackend) handleKeyDisable(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	resp, err := b.setKeyDisabled(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	b.matrixLock.Lock()
+	b.zeroizeCacheLocked()
+	b.matrixLock.Unlock()
+
+	b.Logger().Warn("key disabled; all operations are refused", "client_id", req.ClientToken)
+	return resp, nil
+}
+
+// handleKeyEnable clears the kill-switch. The matrix is regenerated (or
+// loaded from the disk cache) on the next request.
+func (b *vectorBackend) handleKeyEnable(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	resp, err := b.setKeyDisabled(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	b.Logger().Info("key enabled", "client_id", req.ClientToken)
+	return resp, nil
+}
+
+// setKeyDisabled persists the kill-switch state.
+func (b *vectorBackend) setKeyDisabled(ctx context.Context, req *logical.Request, disabled bool) (*logical.Response, error) {
+	storage := req.Storage
+	cfg, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+
+	lifecycle, err := b.readLifecycle(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	before := lifecycle.responseData()
+	lifecycle.Disabled = disabled
+	if err := b.writeLifecycle(ctx, storage, lifecycle); err != nil {
+		return nil, err
+	}
+	operation := "enable"
+	if disabled {
+		operation = "disable"
+	}
+	if err := b.recordHistory(ctx, req, operation, before, lifecycle.responseData()); err != nil {
+		return nil, err
+	}
+	b.emitEvent(ctx, "key-"+operation+"d", "identity", callerIdentity(req))
+	return &logical.Response{
+		Data: lifecycle.responseData(),
+	}, nil
+}
+
+// readLifecycle retrieves the lifecycle state from storage. A key without a
+// stored lifecycle has no deadline.
+func (b *vectorBackend) readLifecycle(ctx context.Context, storage logical.Storage) (*keyLifecycle, error) {
+	lifecycle := &keyLifecycle{}
+	if _, err := getStorageJSON(ctx, storage, lifecycleStoragePath, lifecycle); err != nil {
+		return nil, err
+	}
+	return lifecycle, nil
+}
+
+// writeLifecycle persists the lifecycle state and clears the cached copy.
+func (b *vectorBackend) writeLifecycle(ctx context.Context, storage logical.Storage, lifecycle *keyLifecycle) error {
+	if err := putStorageJSON(ctx, storage, lifecycleStoragePath, lifecycle); err != nil {
+		return err
+	}
+	b.lifecycleLock.Lock()
+	b.cachedLifecycle = nil
+	b.lifecycleLock.Unlock()
+	return nil
+}
+
+// getLifecycle returns the cached lifecycle state, loading it on first use.
+// The returned value is shared and MUST NOT be modified by callers.
+func (b *vectorBackend) getLifecycle(ctx context.Context, storage logical.Storage) (*keyLifecycle, error) {
+	b.lifecycleLock.RLock()
+	if b.cachedLifecycle != nil {
+		lifecycle := b.cachedLifecycle
+		b.lifecycleLock.RUnlock()
+		return lifecycle, nil
+	}
+	b.lifecycleLock.RUnlock()
+
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	if b.cachedLifecycle != nil {
+		return b.cachedLifecycle, nil
+	}
+	lifecycle, err := b.readLifecycle(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	b.cachedLifecycle = lifecycle
+	return lifecycle, nil
+}
+
+// checkKeyUsable returns an error if the key may not encrypt right now.
+func (b *vectorBackend) checkKeyUsable(ctx context.Context, storage logical.Storage) error {
+	lifecycle, err := b.getLifecycle(ctx, storage)
+	if err != nil {
+		return err
+	}
+	return lifecycle.checkEncrypt(time.Now())
+}
+
+// checkKeyEnabled returns an error if the kill-switch is set. Unlike
+// checkKeyUsable it ignores expiry, for operations other than encryption.
+func (b *vectorBackend) checkKeyEnabled(ctx context.Context, storage logical.Storage) error {
+	lifecycle, err := b.getLifecycle(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if lifecycle.Disabled {
+		return errKeyDisabled
+	}
+	return nil
+}
+
+// keyDisabled reports whether the kill-switch is set.
+func (b *vectorBackend) keyDisabled(ctx context.Context, storage logical.Storage) (bool, error) {
+	lifecycle, err := b.getLifecycle(ctx, storage)
+	if err != nil {
+		return false, err
+	}
+	return lifecycle.Disabled, nil
+}
+
+// Help text constants for the lifecycle path.
+const pathLifecycleHelpSyn = `Manage the current key's lifecycle, such as its encryption deadline.`
+
+const pathLifecycleHelpDesc = `
+This endpoint manages lifecycle state for the current key without rotating
+it. Lifecycle state is reset whenever the key is rotated; config/rotate
+accepts expires_at to set the new key's deadline.
+
+Parameters:
+  expires_at - RFC 3339 time after which encryption under the key is
+               refused, to enforce maximum key lifetimes. Empty clears it.
+
+Once expired, every encrypt endpoint returns an error until the key is
+rotated. Roles with a derivation context share the mount key's deadline.
+
+The disabled flag is managed with config/disable and config/enable.
+
+Example:
+  vault write vector/config/lifecycle expires_at=2027-01-01T00:00:00Z
+`
+
+// Help text constants for the disable and enable paths.
+const pathDisableHelpSyn = `Disable or re-enable the key (emergency kill-switch).`
+
+const pathDisableHelpDesc = `
+config/disable immediately refuses every operation that uses the key and
+zeroizes the in-memory matrices on each node. Use it when a key is suspected
+compromised: it takes effect as soon as the storage write is replicated,
+without waiting for policy changes or token revocations to propagate.
+
+config/enable restores the key. Nothing is lost while disabled; the matrix is
+rebuilt from the stored seed on the next request.
+
+A disabled key can still be rotated. Rotation resets the lifecycle, so the
+new key starts enabled.
+
+Example:
+  vault write -f vector/config/disable
+  vault write -f vector/config/enable
+`
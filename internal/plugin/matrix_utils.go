@@ -31,25 +31,27 @@ const MaxDimension = 8192
 // The seed must be exactly 32 bytes (256 bits) and is used to initialize
 // a ChaCha8 CSPRNG for deterministic but cryptographically secure generation.
 func GenerateOrthogonalMatrix(seed []byte, dim int) (*mat.Dense, error) {
-	if dim <= 0 {
-		return nil, fmt.Errorf("dimension must be positive")
-	}
-	if dim > MaxDimension {
-		return nil, fmt.Errorf("dimension %d exceeds maximum allowed %d", dim, MaxDimension)
-	}
-	if len(seed) != 32 {
-		return nil, fmt.Errorf("seed must be exactly 32 bytes (got %d)", len(seed))
+	q, err := generateOrthogonalMatrix(seed, dim)
+	if err != nil {
+		return nil, err
 	}
 
-	// Warn if dimension is large (matrix generation can be slow).
-	if dim > 2048 {
-		log.Printf("[WARN] vault-dpe: generating %dx%d orthogonal matrix – this can be slow", dim, dim)
+	// Validate orthogonality before returning.
+	if err := ValidateOrthogonality(q); err != nil {
+		return nil, fmt.Errorf("generated matrix failed orthogonality check: %w", err)
 	}
 
-	// Use ChaCha8 for high-performance CSPRNG seeded from the key.
-	var seed32 [32]byte
-	copy(seed32[:], seed)
-	rng := mathrand.New(mathrand.NewChaCha8(seed32))
+	return q, nil
+}
+
+// generateOrthogonalMatrix is GenerateOrthogonalMatrix without the
+// orthogonality check, for callers that validate (or certify) the result
+// themselves.
+func generateOrthogonalMatrix(seed []byte, dim int) (*mat.Dense, error) {
+	rng, err := matrixRNG(seed, dim)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate random Gaussian matrix.
 	data := make([]float64, dim*dim)
@@ -64,13 +66,31 @@ func GenerateOrthogonalMatrix(seed []byte, dim int) (*mat.Dense, error) {
 
 	var q mat.Dense
 	qr.QTo(&q)
+	return &q, nil
+}
 
-	// Validate orthogonality before returning.
-	if err := ValidateOrthogonality(&q); err != nil {
-		return nil, fmt.Errorf("generated matrix failed orthogonality check: %w", err)
+// matrixRNG checks the arguments of a matrix generator and returns the
+// ChaCha8 CSPRNG, seeded from the key, that it draws the matrix from.
+func matrixRNG(seed []byte, dim int) (*mathrand.Rand, error) {
+	if dim <= 0 {
+		return nil, fmt.Errorf("dimension must be positive")
+	}
+	if dim > MaxDimension {
+		return nil, fmt.Errorf("dimension %d exceeds maximum allowed %d", dim, MaxDimension)
+	}
+	if len(seed) != 32 {
+		return nil, fmt.Errorf("seed must be exactly 32 bytes (got %d)", len(seed))
 	}
 
-	return &q, nil
+	// Warn if dimension is large (matrix generation can be slow).
+	if dim > 2048 {
+		log.Printf("[WARN] vault-dpe: generating %dx%d orthogonal matrix – this can be slow", dim, dim)
+	}
+
+	// Use ChaCha8 for high-performance CSPRNG seeded from the key.
+	var seed32 [32]byte
+	copy(seed32[:], seed)
+	return mathrand.New(mathrand.NewChaCha8(seed32)), nil
 }
 
 // ValidateOrthogonality verifies that Q^T * Q ≈ I (identity matrix).
@@ -104,6 +124,52 @@ func ValidateOrthogonality(q *mat.Dense) error {
 	return nil
 }
 
+// ValidateOrthogonalitySampled is a statistical variant of
+// ValidateOrthogonality for large dimensions. It checks the norm of every
+// column, which costs O(d²), and the inner products of samples column pairs
+// drawn from rng, which cost O(d) each, against a tighter tolerance than the
+// full check. The full check costs O(d³).
+func ValidateOrthogonalitySampled(q *mat.Dense, samples int, rng *mathrand.Rand) error {
+	r, c := q.Dims()
+	if r != c {
+		return fmt.Errorf("matrix is not square: %dx%d", r, c)
+	}
+
+	const epsilon = 1e-9
+	col := make([]float64, r)
+	for j := 0; j < c; j++ {
+		mat.Col(col, j, q)
+		var norm float64
+		for _, v := range col {
+			norm += v * v
+		}
+		if math.IsNaN(norm) || math.Abs(norm-1) > epsilon {
+			return fmt.Errorf("orthogonality check failed at (%d, %d): got %v, expected 1", j, j, norm)
+		}
+	}
+	if c < 2 {
+		return nil
+	}
+	other := make([]float64, r)
+	for n := 0; n < samples; n++ {
+		i := rng.IntN(c)
+		j := rng.IntN(c - 1)
+		if j >= i {
+			j++
+		}
+		mat.Col(col, i, q)
+		mat.Col(other, j, q)
+		var dot float64
+		for k := range col {
+			dot += col[k] * other[k]
+		}
+		if math.IsNaN(dot) || math.Abs(dot) > epsilon {
+			return fmt.Errorf("orthogonality check failed at (%d, %d): got %v, expected 0", i, j, dot)
+		}
+	}
+	return nil
+}
+
 // NewSecureRNG creates a new CSPRNG seeded with 32 bytes of entropy from crypto/rand.
 // It uses the ChaCha8 algorithm from math/rand/v2 for high performance.
 func NewSecureRNG() (*mathrand.Rand, error) {
@@ -120,11 +186,17 @@ func NewSecureRNG() (*mathrand.Rand, error) {
 // The buffer parameter allows reuse of allocated memory; if nil or too small,
 // a new slice will be allocated.
 func GenerateSecureNoise(buffer []float64, dim int, scalingFactor, approximationFactor float64) ([]float64, error) {
+	return GenerateSecureBallNoise(buffer, dim, (scalingFactor*approximationFactor)/4.0)
+}
+
+// GenerateSecureBallNoise generates a perturbation vector uniformly distributed
+// within a ball of the given absolute radius, using a freshly seeded CSPRNG.
+func GenerateSecureBallNoise(buffer []float64, dim int, radius float64) ([]float64, error) {
 	rng, err := NewSecureRNG()
 	if err != nil {
 		return nil, err
 	}
-	return GenerateNormalizedVector(rng, buffer, dim, scalingFactor, approximationFactor)
+	return GenerateBallNoise(rng, buffer, dim, radius)
 }
 
 // GenerateNormalizedVector generates the perturbation vector λ for the SAP scheme.
@@ -138,6 +210,14 @@ func GenerateSecureNoise(buffer []float64, dim int, scalingFactor, approximation
 // This produces a vector uniformly distributed within a ball of radius (s·β)/4,
 // which provides the probabilistic noise component of the SAP scheme.
 func GenerateNormalizedVector(rng *mathrand.Rand, buffer []float64, dim int, scalingFactor, approximationFactor float64) ([]float64, error) {
+	// R = (s · β) / 4
+	return GenerateBallNoise(rng, buffer, dim, (scalingFactor*approximationFactor)/4.0)
+}
+
+// GenerateBallNoise samples a vector uniformly from the d-ball of the given radius.
+// It implements steps 1-4 of GenerateNormalizedVector with an explicit radius R,
+// so callers can apply a noise floor that is independent of the scaling factor.
+func GenerateBallNoise(rng *mathrand.Rand, buffer []float64, dim int, radius float64) ([]float64, error) {
 	// Use provided buffer or allocate.
 	lambdaM := buffer
 	if cap(lambdaM) < dim {
@@ -165,9 +245,7 @@ func GenerateNormalizedVector(rng *mathrand.Rand, buffer []float64, dim int, sca
 	xPrime := rng.Float64()
 
 	// Step 3: Compute radius for uniform ball sampling.
-	// R = (s · β) / 4
 	// x = R · (x')^(1/d)
-	radius := (scalingFactor * approximationFactor) / 4.0
 	x := radius * math.Pow(xPrime, 1.0/float64(dim))
 
 	// Step 4: Normalize and scale: λ = u · x / ||u||.
@@ -178,4 +256,3 @@ func GenerateNormalizedVector(rng *mathrand.Rand, buffer []float64, dim int, sca
 
 	return lambdaM, nil
 }
-
@@ -106,3 +106,65 @@ func TestGenerateNormalizedVector(t *testing.T) {
 	}
 }
 
+
+func TestGenerateBallNoiseRadius(t *testing.T) {
+	rng, err := NewSecureRNG()
+	if err != nil {
+		t.Fatalf("NewSecureRNG failed: %v", err)
+	}
+
+	dim := 64
+	radius := 0.75
+	for i := 0; i < 100; i++ {
+		noise, err := GenerateBallNoise(rng, nil, dim, radius)
+		if err != nil {
+			t.Fatalf("GenerateBallNoise failed: %v", err)
+		}
+		norm := mat.Norm(mat.NewVecDense(dim, noise), 2)
+		if norm > radius+1e-12 {
+			t.Fatalf("noise norm %v exceeds radius %v", norm, radius)
+		}
+	}
+}
+
+func TestNoiseRadiusFloor(t *testing.T) {
+	cfg := &rotationConfig{ScalingFactor: 100, ApproximationFactor: 0, MinNoiseRadius: 0.5}
+	if got := cfg.noiseRadius(); got != 0.5 {
+		t.Errorf("noiseRadius() = %v, want floor 0.5", got)
+	}
+
+	cfg.ApproximationFactor = 4
+	if got := cfg.noiseRadius(); got != 100 {
+		t.Errorf("noiseRadius() = %v, want s*β/4 = 100", got)
+	}
+}
+
+func TestValidateOrthogonalitySampled(t *testing.T) {
+	q, err := GenerateOrthogonalMatrix(make([]byte, 32), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng, err := NewSecureRNG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateOrthogonalitySampled(q, 64, rng); err != nil {
+		t.Fatalf("orthogonal matrix failed the sampled check: %v", err)
+	}
+
+	// Tilt column 1 towards column 0, keeping its norm: only the inner
+	// products reveal it, and 1000 samples of 240 ordered pairs find it.
+	tilted := mat.DenseCopyOf(q)
+	for i := 0; i < 16; i++ {
+		tilted.Set(i, 1, 0.8*q.At(i, 1)+0.6*q.At(i, 0))
+	}
+	if err := ValidateOrthogonalitySampled(tilted, 1000, rng); err == nil {
+		t.Error("sampled check missed non-orthogonal columns")
+	}
+
+	scaled := mat.DenseCopyOf(q)
+	scaled.Set(3, 5, scaled.At(3, 5)*1.001)
+	if err := ValidateOrthogonalitySampled(scaled, 1, rng); err == nil {
+		t.Error("sampled check missed a column with the wrong norm")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// matrixCacheDirEnv names the environment variable that enables the local
+	// matrix cache. It is set by the operator at plugin registration
+	// (vault plugin register -env), never through the API, so a Vault token
+	// cannot direct the plugin to write to arbitrary host paths.
+	matrixCacheDirEnv = "VECTOR_DPE_MATRIX_CACHE_DIR"
+
+	// matrixCacheKeyLabel and matrixCacheNameLabel separate the derived cache
+	// encryption key and file name from other uses of the seed.
+	matrixCacheKeyLabel  = "vector-dpe/matrix-cache/key/v1"
+	matrixCacheNameLabel = "vector-dpe/matrix-cache/name/v1"
+)
+
+// matrixCacheFormat is the version of the cache file format, part of both
+// the file name and the magic. Version 2 added the matrix kind; version 1
+// files are never read, and a restart regenerates their matrices.
+const matrixCacheFormat = 2
+
+// matrixCacheMagic identifies version 2 of the cache file format:
+// magic (8) | kind (1) | dimension uint32 LE (4) | nonce (12) | AES-256-GCM
+// ciphertext. The header is authenticated as additional data.
+var matrixCacheMagic = [8]byte{'V', 'D', 'P', 'E', 'M', 'C', '0' + matrixCacheFormat, 0}
+
+// matrixKind is how a key's matrix is generated from its seed. One seed
+// yields a different matrix under each kind, so the kind is part of a cache
+// file's name and authenticated header: a matrix cached for one kind is
+// never loaded for a key of another.
+type matrixKind uint8
+
+const (
+	matrixOrthogonal matrixKind = iota + 1
+	matrixSplit
+	matrixPortable
+)
+
+// String returns the kind as it appears in cache file names.
+func (k matrixKind) String() string {
+	switch k {
+	case matrixOrthogonal:
+		return "orthogonal"
+	case matrixSplit:
+		return "split"
+	case matrixPortable:
+		return "portable"
+	}
+	return fmt.Sprintf("kind%d", uint8(k))
+}
+
+// generate generates the matrix of kind k for seed and dim, leaving
+// validation to the caller.
+func (k matrixKind) generate(seed []byte, dim int) (*mat.Dense, error) {
+	switch k {
+	case matrixOrthogonal:
+		return generateOrthogonalMatrix(seed, dim)
+	case matrixSplit:
+		return generateSplitMatrix(seed, dim)
+	case matrixPortable:
+		return generatePortableMatrix(seed, dim)
+	}
+	return nil, fmt.Errorf("unknown matrix kind %d", uint8(k))
+}
+
+// errMatrixCacheCorrupt is returned when a cache file fails authentication.
+var errMatrixCacheCorrupt = errors.New("matrix cache file failed integrity check")
+
+// matrixDiskCache persists derived matrices across plugin restarts.
+//
+// Vault does not expose its seal or barrier key to plugins, so each file is
+// encrypted with a key derived from the seed. The seed lives only in
+// barrier-encrypted Vault storage, so a cache file is unreadable without
+// access to the unsealed Vault that owns it, and is useless once the key
+// is rotated.
+type matrixDiskCache struct {
+	dir string
+}
+
+// newMatrixDiskCache returns a cache rooted at dir, creating it with
+// owner-only permissions if needed.
+func newMatrixDiskCache(dir string) (*matrixDiskCache, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("%s must be an absolute path (got %q)", matrixCacheDirEnv, dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create matrix cache directory: %w", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &matrixDiskCache{dir: filepath.Clean(dir)}, nil
+}
+
+// load returns the cached matrix of kind for seed and dim, or nil if none is
+// cached. A file that fails authentication returns errMatrixCacheCorrupt.
+func (c *matrixDiskCache) load(seed []byte, kind matrixKind, dim int) (*mat.Dense, error) {
+	file, err := os.ReadFile(c.path(seed, kind, dim))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := matrixCacheHeader(kind, dim)
+	if len(file) < len(header) || !bytes.Equal(file[:len(header)], header) {
+		return nil, errMatrixCacheCorrupt
+	}
+	aead, err := matrixCacheAEAD(seed)
+	if err != nil {
+		return nil, err
+	}
+	rest := file[len(header):]
+	if len(rest) < aead.NonceSize() {
+		return nil, errMatrixCacheCorrupt
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, errMatrixCacheCorrupt
+	}
+	defer zeroBytes(plain)
+	if len(plain) != dim*dim*8 {
+		return nil, errMatrixCacheCorrupt
+	}
+
+	data := make([]float64, dim*dim)
+	for i := range data {
+		data[i] = math.Float64frombits(binary.LittleEndian.Uint64(plain[i*8:]))
+	}
+	return mat.NewDense(dim, dim, data), nil
+}
+
+// store encrypts matrix, of kind, and writes it atomically to the cache.
+func (c *matrixDiskCache) store(seed []byte, kind matrixKind, matrix *mat.Dense) error {
+	dim, _ := matrix.Dims()
+	raw := matrix.RawMatrix()
+
+	plain := make([]byte, dim*dim*8)
+	defer zeroBytes(plain)
+	for i := 0; i < dim; i++ {
+		row := raw.Data[i*raw.Stride : i*raw.Stride+dim]
+		for j, v := range row {
+			binary.LittleEndian.PutUint64(plain[(i*dim+j)*8:], math.Float64bits(v))
+		}
+	}
+
+	aead, err := matrixCacheAEAD(seed)
+	if err != nil {
+		return err
+	}
+	header := matrixCacheHeader(kind, dim)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	file := make([]byte, 0, len(header)+len(nonce)+len(plain)+aead.Overhead())
+	file = append(file, header...)
+	file = append(file, nonce...)
+	file = aead.Seal(file, nonce, plain, header)
+
+	tmp, err := os.CreateTemp(c.dir, ".matrix-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(seed, kind, dim))
+}
+
+// remove deletes the cached matrix of kind for seed and dim, if present.
+func (c *matrixDiskCache) remove(seed []byte, kind matrixKind, dim int) error {
+	err := os.Remove(c.path(seed, kind, dim))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path returns the cache file for seed, kind and dim. The name starts with
+// an HMAC of the seed so it identifies the key without revealing anything
+// about it.
+func (c *matrixDiskCache) path(seed []byte, kind matrixKind, dim int) string {
+	name := deriveSeedKey(seed, matrixCacheNameLabel)
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%s-%d.v%d.matrix",
+		hex.EncodeToString(name[:16]), kind, dim, matrixCacheFormat))
+}
+
+// matrixCacheHeader returns the authenticated file header for kind and dim.
+func matrixCacheHeader(kind matrixKind, dim int) []byte {
+	header := make([]byte, len(matrixCacheMagic)+5)
+	copy(header, matrixCacheMagic[:])
+	header[len(matrixCacheMagic)] = byte(kind)
+	binary.LittleEndian.PutUint32(header[len(matrixCacheMagic)+1:], uint32(dim))
+	return header
+}
+
+// matrixCacheAEAD returns the AES-256-GCM cipher keyed from seed.
+func matrixCacheAEAD(seed []byte) (cipher.AEAD, error) {
+	key := deriveSeedKey(seed, matrixCacheKeyLabel)
+	defer zeroBytes(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveSeedKey derives a 32-byte subkey from seed for the given label.
+func deriveSeedKey(seed []byte, label string) []byte {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// zeroBytes overwrites b so key material and plaintext do not linger in memory.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
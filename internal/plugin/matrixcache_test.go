@@ -0,0 +1,158 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestMatrixDiskCacheRoundTrip(t *testing.T) {
+	cache, err := newMatrixDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := bytes.Repeat([]byte{7}, seedLength)
+
+	if m, err := cache.load(seed, matrixOrthogonal, testDimension); m != nil || err != nil {
+		t.Fatalf("load on empty cache = %v, %v; want nil, nil", m, err)
+	}
+
+	want, err := GenerateOrthogonalMatrix(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.store(seed, matrixOrthogonal, want); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	info, err := os.Stat(cache.path(seed, matrixOrthogonal, testDimension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("cache file permissions = %o, want owner-only", perm)
+	}
+
+	got, err := cache.load(seed, matrixOrthogonal, testDimension)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !mat.Equal(got, want) {
+		t.Error("loaded matrix differs from stored matrix")
+	}
+
+	// A different seed neither finds nor decrypts the file.
+	other := bytes.Repeat([]byte{8}, seedLength)
+	if m, err := cache.load(other, matrixOrthogonal, testDimension); m != nil || err != nil {
+		t.Errorf("load with other seed = %v, %v; want nil, nil", m, err)
+	}
+
+	if err := cache.remove(seed, matrixOrthogonal, testDimension); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := cache.load(seed, matrixOrthogonal, testDimension); m != nil {
+		t.Error("matrix still cached after remove")
+	}
+}
+
+func TestMatrixDiskCacheSeparatesKinds(t *testing.T) {
+	cache, err := newMatrixDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := bytes.Repeat([]byte{7}, seedLength)
+	orthogonal, err := matrixOrthogonal.generate(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.store(seed, matrixOrthogonal, orthogonal); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same seed under another kind misses the cache.
+	for _, kind := range []matrixKind{matrixSplit, matrixPortable} {
+		if m, err := cache.load(seed, kind, testDimension); m != nil || err != nil {
+			t.Errorf("load as %s = %v, %v; want nil, nil", kind, m, err)
+		}
+	}
+
+	// A file renamed to another kind fails authentication.
+	split := cache.path(seed, matrixSplit, testDimension)
+	if err := os.Rename(cache.path(seed, matrixOrthogonal, testDimension), split); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.load(seed, matrixSplit, testDimension); !errors.Is(err, errMatrixCacheCorrupt) {
+		t.Errorf("load of a renamed file = %v, want errMatrixCacheCorrupt", err)
+	}
+}
+
+func TestMatrixDiskCacheDetectsTampering(t *testing.T) {
+	cache, err := newMatrixDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := bytes.Repeat([]byte{7}, seedLength)
+	matrix, err := GenerateOrthogonalMatrix(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.store(seed, matrixOrthogonal, matrix); err != nil {
+		t.Fatal(err)
+	}
+
+	path := cache.path(seed, matrixOrthogonal, testDimension)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file[len(file)-1] ^= 1
+	if err := os.WriteFile(path, file, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.load(seed, matrixOrthogonal, testDimension); !errors.Is(err, errMatrixCacheCorrupt) {
+		t.Errorf("load of tampered file = %v, want errMatrixCacheCorrupt", err)
+	}
+}
+
+func TestBackendUsesMatrixDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(matrixCacheDirEnv, dir)
+
+	b, s := getTestBackend(t)
+	if b.matrixCache == nil {
+		t.Fatal("matrix cache not enabled from environment")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("cache directory has %d entries, want 1", len(entries))
+	}
+
+	// Rotation removes the previous key's file and caches the new key's,
+	// which is prefetched.
+	previous := entries[0].Name()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() == previous {
+		t.Errorf("cache directory after rotation = %v, want only the new key's file", entries)
+	}
+}
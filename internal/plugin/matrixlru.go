@@ -0,0 +1,302 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// defaultCacheMaxEntries is the default number of derived and key
+	// version matrices cached.
+	defaultCacheMaxEntries = 64
+
+	// maxCacheMaxEntries bounds cache_max_entries.
+	maxCacheMaxEntries = 4096
+)
+
+// lruEntry is a cached derived or key version matrix.
+type lruEntry struct {
+	matrix *mat.Dense
+
+	// cfg is the key version's config; nil for derived matrices, which
+	// belong to the current key.
+	cfg *rotationConfig
+
+	// lastUsed is the UnixNano time of the last lookup. It is updated under
+	// matrixLock's read lock, hence atomic.
+	lastUsed atomic.Int64
+}
+
+// touch records a use of the entry and returns its matrix.
+func (e *lruEntry) touch() *mat.Dense {
+	e.lastUsed.Store(time.Now().UnixNano())
+	return e.matrix
+}
+
+// matrixLRU caches the matrices of derivation contexts and older key
+// versions, keyed like their generations (derivedFlightKey,
+// versionFlightKey). Beyond cache_max_entries matrices or
+// cache_max_bytes, the least recently used are evicted and zeroed once no
+// request holds them. The current key's matrix is cached outside it.
+// It is protected by matrixLock; the counters are atomic.
+type matrixLRU struct {
+	entries map[string]*lruEntry
+	bytes   int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// get returns the entry for key, recording the hit, or nil. Misses are
+// counted by put, once per matrix loaded, not once per waiting request.
+// MUST be called while holding matrixLock.
+func (c *matrixLRU) get(key string) *lruEntry {
+	entry := c.entries[key]
+	if entry == nil {
+		return nil
+	}
+	c.hits.Add(1)
+	entry.touch()
+	return entry
+}
+
+// put caches a matrix under key, first evicting the least recently used
+// entries until it fits within maxEntries and maxBytes (0: no byte limit).
+// The new entry is always kept, even alone beyond maxBytes. retire is
+// called with each evicted matrix.
+// MUST be called while holding matrixLock for writing.
+func (c *matrixLRU) put(key string, matrix *mat.Dense, cfg *rotationConfig, maxEntries int, maxBytes int64, retire func(*mat.Dense)) {
+	if old := c.entries[key]; old != nil {
+		c.bytes -= matrixBytes(old.matrix)
+		retire(old.matrix)
+		delete(c.entries, key)
+	}
+	c.misses.Add(1)
+	size := matrixBytes(matrix)
+	for len(c.entries) > 0 && (len(c.entries) >= maxEntries || (maxBytes > 0 && c.bytes+size > maxBytes)) {
+		c.evictOldest(retire)
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*lruEntry)
+	}
+	entry := &lruEntry{matrix: matrix, cfg: cfg}
+	entry.touch()
+	c.entries[key] = entry
+	c.bytes += size
+}
+
+// evictOldest drops the least recently used entry.
+// MUST be called while holding matrixLock for writing.
+func (c *matrixLRU) evictOldest(retire func(*mat.Dense)) {
+	var oldestKey string
+	var oldest *lruEntry
+	for key, entry := range c.entries {
+		if oldest == nil || entry.lastUsed.Load() < oldest.lastUsed.Load() {
+			oldestKey, oldest = key, entry
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	c.remove(oldestKey, retire)
+	c.evictions.Add(1)
+	kind, _, _ := strings.Cut(oldestKey, "\x00")
+	metrics.IncrCounterWithLabels(append(matrixMetricPrefix, "cache", "evict"), 1, []metrics.Label{{Name: "key", Value: kind}})
+}
+
+// remove drops the entry for key, if any.
+// MUST be called while holding matrixLock for writing.
+func (c *matrixLRU) remove(key string, retire func(*mat.Dense)) {
+	if entry := c.entries[key]; entry != nil {
+		c.bytes -= matrixBytes(entry.matrix)
+		retire(entry.matrix)
+		delete(c.entries, key)
+	}
+}
+
+// clear drops every entry and returns how many there were.
+// MUST be called while holding matrixLock for writing.
+func (c *matrixLRU) clear(retire func(*mat.Dense)) int {
+	n := len(c.entries)
+	for _, entry := range c.entries {
+		retire(entry.matrix)
+	}
+	c.entries = nil
+	c.bytes = 0
+	return n
+}
+
+// derivedContexts returns the derivation contexts of the cached derived
+// matrices.
+// MUST be called while holding matrixLock.
+func (c *matrixLRU) derivedContexts() []string {
+	var contexts []string
+	for key := range c.entries {
+		if derivationContext, ok := strings.CutPrefix(key, derivedFlightKey("")); ok {
+			contexts = append(contexts, derivationContext)
+		}
+	}
+	return contexts
+}
+
+// pathCache returns the path configuration for cache/status and cache/clear.
+func (b *vectorBackend) pathCache() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "cache/status",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleCacheStatus,
+					Summary:  "Report the matrix cache's entries, limits and statistics on this node.",
+				},
+			},
+			HelpSynopsis:    pathCacheHelpSyn,
+			HelpDescription: pathCacheHelpDesc,
+		},
+		{
+			Pattern: "cache/clear",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleCacheClear,
+					Summary:  "Drop the cached derived and key version matrices on this node.",
+				},
+			},
+			HelpSynopsis:    pathCacheHelpSyn,
+			HelpDescription: pathCacheHelpDesc,
+		},
+	}
+}
+
+// handleCacheStatus reports the matrix cache on this node. Entries are
+// identified by key_id, never by derivation context, which may name a
+// tenant.
+func (b *vectorBackend) handleCacheStatus(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	type snapshot struct {
+		key      string
+		cfg      *rotationConfig
+		matrix   *mat.Dense
+		lastUsed int64
+	}
+	b.matrixLock.RLock()
+	current := b.cachedConfig
+	mountBytes := int64(0)
+	if b.cachedMatrix != nil {
+		mountBytes = matrixBytes(b.cachedMatrix)
+	}
+	entries := make([]snapshot, 0, len(b.lru.entries))
+	for key, entry := range b.lru.entries {
+		entries = append(entries, snapshot{key: key, cfg: entry.cfg, matrix: entry.matrix, lastUsed: entry.lastUsed.Load()})
+	}
+	cachedBytes := b.lru.bytes
+	b.matrixLock.RUnlock()
+
+	// Most recently used first.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed > entries[j].lastUsed })
+	list := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		dimension, _ := e.matrix.Dims()
+		item := map[string]interface{}{
+			"bytes":     matrixBytes(e.matrix),
+			"dimension": dimension,
+			"last_used": time.Unix(0, e.lastUsed).UTC().Format(time.RFC3339),
+		}
+		kind, rest, _ := strings.Cut(e.key, "\x00")
+		item["type"] = kind
+		switch {
+		case e.cfg != nil:
+			item["key_version"], _ = strconv.Atoi(rest)
+		case current != nil:
+			keyID, err := contextKeyID(current, rest)
+			if err != nil {
+				return nil, err
+			}
+			item["key_id"] = keyID
+		}
+		list = append(list, item)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"entries":           list,
+			"entry_count":       len(entries),
+			"bytes":             cachedBytes,
+			"max_entries":       settings.CacheMaxEntries,
+			"max_bytes":         settings.CacheMaxBytes,
+			"hits":              b.lru.hits.Load(),
+			"misses":            b.lru.misses.Load(),
+			"evictions":         b.lru.evictions.Load(),
+			"current_key_bytes": mountBytes,
+		},
+	}, nil
+}
+
+// handleCacheClear drops the cached derived and key version matrices. The
+// current key's matrix is kept, so the node stays ready; rotate to replace
+// it.
+func (b *vectorBackend) handleCacheClear(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.matrixLock.Lock()
+	// Generations in flight must not repopulate the cache.
+	b.cacheEpoch++
+	cleared := b.lru.clear(b.retireMatrixLocked)
+	b.matrixLock.Unlock()
+
+	b.Logger().Info("matrix cache cleared", "entries", cleared)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"cleared": cleared,
+		},
+	}, nil
+}
+
+// Help text constants for the cache paths.
+const pathCacheHelpSyn = `Inspect or clear the matrix cache on this node.`
+
+const pathCacheHelpDesc = `
+The matrices of derivation contexts and of pinned older key versions are
+cached in memory per node, up to the cache_max_entries and cache_max_bytes
+mount settings. Beyond either, the least recently used matrix is evicted and
+zeroed as soon as no request uses it; it is regenerated (or reloaded from
+the local disk cache) on its next use. The most recently added matrix is
+always kept, even alone beyond cache_max_bytes. The current key's matrix is
+cached separately and is not counted.
+
+cache/status reports:
+  entries           - Cached matrices, most recently used first: type
+                      ('derived' or 'version'), key_id (derived) or
+                      key_version (version), dimension, bytes, last_used
+  entry_count       - Number of cached matrices
+  bytes             - Their total size
+  max_entries       - The cache_max_entries setting
+  max_bytes         - The cache_max_bytes setting (0: no limit)
+  hits              - Lookups served from the cache since the plugin was
+                      loaded
+  misses            - Matrices generated or loaded from disk into it
+  evictions         - Matrices evicted to stay within the limits
+  current_key_bytes - Size of the current key's matrix, not counted above
+
+cache/clear drops every cached derived and key version matrix and reports
+how many were 'cleared'. The current key's matrix is kept.
+
+Example:
+  vault write vector/config/settings cache_max_entries=16 cache_max_bytes=1073741824
+  vault read vector/cache/status
+  vault write -f vector/cache/clear
+`
@@ -0,0 +1,119 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMatrixLRU_ByteLimit(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	// Two matrices of testDimension fit.
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"cache_max_bytes": 2 * testDimension * testDimension * 8,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	first, _, err := b.getDerivedMatrix(ctx, s, "tenant-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 3; i++ {
+		if _, _, err := b.getDerivedMatrix(ctx, s, fmt.Sprintf("tenant-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.matrixLock.RLock()
+	entries, size := len(b.lru.entries), b.lru.bytes
+	_, evicted := b.lru.entries[derivedFlightKey("tenant-0")]
+	b.matrixLock.RUnlock()
+	if entries != 2 || size != 2*testDimension*testDimension*8 {
+		t.Errorf("cache holds %d matrices of %d bytes, want 2 within the limit", entries, size)
+	}
+	if evicted {
+		t.Error("least recently used tenant-0 kept")
+	}
+	if first.At(0, 0) != 0 || first.At(testDimension-1, testDimension-1) != 0 {
+		t.Error("evicted matrix not zeroed")
+	}
+	if got := b.lru.evictions.Load(); got != 1 {
+		t.Errorf("evictions = %d, want 1", got)
+	}
+
+	// A matrix larger than the limit is still cached, alone.
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"cache_max_bytes": 1,
+	})
+	if _, _, err := b.getDerivedMatrix(ctx, s, "tenant-3"); err != nil {
+		t.Fatal(err)
+	}
+	b.matrixLock.RLock()
+	entries = len(b.lru.entries)
+	b.matrixLock.RUnlock()
+	if entries != 1 {
+		t.Errorf("cache holds %d matrices, want 1", entries)
+	}
+}
+
+func TestBackendCacheStatusAndClear(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+			"dimension": testDimension,
+		})
+	}
+	if _, _, err := b.versionMatrix(ctx, s, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.getDerivedMatrix(ctx, s, "tenant-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "cache/status", nil)
+	if resp.Data["entry_count"] != 2 || resp.Data["bytes"] != int64(2*testDimension*testDimension*8) {
+		t.Errorf("status = %v, want 2 matrices", resp.Data)
+	}
+	if resp.Data["max_entries"] != defaultCacheMaxEntries || resp.Data["misses"] != uint64(2) {
+		t.Errorf("status = %v", resp.Data)
+	}
+	entries := resp.Data["entries"].([]map[string]interface{})
+	// The derived matrix was used last.
+	if entries[0]["type"] != "derived" || entries[0]["key_id"] == nil {
+		t.Errorf("first entry = %v, want the derived matrix by key_id", entries[0])
+	}
+	if entries[1]["type"] != "version" || entries[1]["key_version"] != 1 {
+		t.Errorf("second entry = %v, want key version 1", entries[1])
+	}
+	if strings.Contains(fmt.Sprint(resp.Data), "tenant-secret") {
+		t.Error("status reveals a derivation context")
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "cache/clear", nil)
+	if resp.Data["cleared"] != 2 {
+		t.Errorf("cleared = %v, want 2", resp.Data["cleared"])
+	}
+	resp = testRequest(t, b, s, logical.ReadOperation, "cache/status", nil)
+	if resp.Data["entry_count"] != 0 || resp.Data["bytes"] != int64(0) {
+		t.Errorf("status after clear = %v, want empty", resp.Data)
+	}
+	if resp.Data["current_key_bytes"] != int64(testDimension*testDimension*8) {
+		t.Errorf("current key matrix dropped by clear: %v", resp.Data["current_key_bytes"])
+	}
+
+	if resp, err := entityRequest(b, s, "", logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"cache_max_entries": 0,
+	}); err == nil && !resp.IsError() {
+		t.Error("cache_max_entries=0 accepted")
+	}
+}
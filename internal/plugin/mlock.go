@@ -0,0 +1,79 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// mlockEnv names the environment variable that locks cached matrices into
+// memory. Vault's own mlock covers only the Vault process: the plugin runs
+// in a process of its own, whose pages the kernel may swap out unless it
+// locks them itself. Like matrixCacheDirEnv it is set at plugin
+// registration, never through the API.
+const mlockEnv = "VECTOR_DPE_MLOCK"
+
+// errMlockUnsupported is returned by mlock on platforms without it.
+var errMlockUnsupported = errors.New("mlock is not supported on this platform")
+
+// lockedMatrices records the matrices whose buffers are locked, so that
+// zeroMatrix unlocks them. mlock is not reference counted, so each buffer
+// is locked at most once.
+var lockedMatrices struct {
+	sync.Mutex
+	m map[*mat.Dense]struct{}
+}
+
+// mlockEnabled reports whether the operator asked for locked matrices.
+func mlockEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(mlockEnv))
+	return enabled
+}
+
+// matrixBuffer returns the bytes backing m's elements.
+func matrixBuffer(m *mat.Dense) []byte {
+	data := m.RawMatrix().Data
+	if len(data) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&data[0])), len(data)*8)
+}
+
+// lockMatrix locks m's buffer into memory so it is never written to swap.
+// Matrices of 64 dimensions and more fill whole pages of their own; smaller
+// ones may share a page, which unlocking one of them unlocks.
+func lockMatrix(m *mat.Dense) error {
+	lockedMatrices.Lock()
+	defer lockedMatrices.Unlock()
+	if _, ok := lockedMatrices.m[m]; ok {
+		return nil
+	}
+	if err := mlock(matrixBuffer(m)); err != nil {
+		return err
+	}
+	if lockedMatrices.m == nil {
+		lockedMatrices.m = make(map[*mat.Dense]struct{})
+	}
+	lockedMatrices.m[m] = struct{}{}
+	return nil
+}
+
+// unlockMatrix unlocks m's buffer if lockMatrix locked it. Locked pages
+// stay locked after the garbage collector frees them, so every locked
+// matrix MUST be unlocked before it is dropped; zeroMatrix does so.
+func unlockMatrix(m *mat.Dense) {
+	lockedMatrices.Lock()
+	defer lockedMatrices.Unlock()
+	if _, ok := lockedMatrices.m[m]; !ok {
+		return
+	}
+	delete(lockedMatrices.m, m)
+	_ = munlock(matrixBuffer(m))
+}
@@ -0,0 +1,12 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package plugin
+
+// mlock is unavailable on this platform.
+func mlock([]byte) error { return errMlockUnsupported }
+
+// munlock is unavailable on this platform.
+func munlock([]byte) error { return errMlockUnsupported }
@@ -0,0 +1,76 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMlockMatrices(t *testing.T) {
+	t.Setenv(mlockEnv, "true")
+	b, s := getTestBackend(t)
+	if !b.mlockMatrices {
+		t.Fatal("VECTOR_DPE_MLOCK not honored")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	matrix, _, err := b.getMatrixAndConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedMatrices.Lock()
+	_, locked := lockedMatrices.m[matrix]
+	lockedMatrices.Unlock()
+	if !locked {
+		// mlock fails without CAP_IPC_LOCK under a tiny RLIMIT_MEMLOCK.
+		if err := mlock(matrixBuffer(matrix)); err != nil {
+			t.Skipf("mlock unavailable: %v", err)
+		}
+		t.Fatal("cached matrix not locked")
+	}
+
+	// Rotation retires the matrix, which unlocks it.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	lockedMatrices.Lock()
+	_, locked = lockedMatrices.m[matrix]
+	lockedMatrices.Unlock()
+	if locked {
+		t.Error("retired matrix still locked")
+	}
+}
+
+func TestSealWrappedStorage(t *testing.T) {
+	b, _ := getTestBackend(t)
+	wrapped := func(path string) bool {
+		for _, p := range b.Backend.PathsSpecial.SealWrapStorage {
+			if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, path := range []string{
+		configStoragePath,
+		chunkPath(configStoragePath, "g1", 0),
+		keyVersionPath(3),
+		chunkPath(keyVersionPath(3), "g1", 0),
+		wrappingKeyStoragePath,
+		ceremonyStoragePath,
+		splitFactorStoragePath,
+	} {
+		if !wrapped(path) {
+			t.Errorf("%s is not seal-wrapped", path)
+		}
+	}
+	if wrapped(settingsStoragePath) || wrapped(ciphertextStoragePrefix+"x") {
+		t.Error("entries without key material are seal-wrapped")
+	}
+}
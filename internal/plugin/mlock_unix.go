@@ -0,0 +1,24 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package plugin
+
+import "syscall"
+
+// mlock locks b's pages into memory.
+func mlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Mlock(b)
+}
+
+// munlock unlocks b's pages.
+func munlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Munlock(b)
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// componentRange is an inclusive range of zero-based vector components.
+type componentRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// componentRanges is a key's noise mask: the plaintext components that
+// receive perturbation, as sorted, disjoint ranges. Empty means every
+// component.
+type componentRanges []componentRange
+
+// parseComponentRanges parses a mask such as "0-255,512-767" for vectors of
+// dim components. Ranges are zero-based and inclusive; a single index is a
+// range of one. Overlapping and adjacent ranges are merged.
+func parseComponentRanges(spec string, dim int) (componentRanges, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	covered := make([]bool, dim)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("noise_mask: invalid range %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("noise_mask: invalid range %q", part)
+			}
+		}
+		if start < 0 || end < start || end >= dim {
+			return nil, fmt.Errorf("noise_mask: range %q is outside components 0-%d", part, dim-1)
+		}
+		for i := start; i <= end; i++ {
+			covered[i] = true
+		}
+	}
+
+	var ranges componentRanges
+	for i := 0; i < dim; i++ {
+		if !covered[i] {
+			continue
+		}
+		start := i
+		for i+1 < dim && covered[i+1] {
+			i++
+		}
+		ranges = append(ranges, componentRange{Start: start, End: i})
+	}
+	if len(ranges) == 1 && ranges[0].Start == 0 && ranges[0].End == dim-1 {
+		// A mask of every component is no mask.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// String renders the mask in the syntax parseComponentRanges accepts.
+func (r componentRanges) String() string {
+	parts := make([]string, len(r))
+	for i, c := range r {
+		if c.Start == c.End {
+			parts[i] = strconv.Itoa(c.Start)
+		} else {
+			parts[i] = fmt.Sprintf("%d-%d", c.Start, c.End)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// count returns the number of components in the mask.
+func (r componentRanges) count() int {
+	n := 0
+	for _, c := range r {
+		n += c.End - c.Start + 1
+	}
+	return n
+}
+
+// validate checks the mask of a stored key of dim components.
+func (r componentRanges) validate(dim int) error {
+	next := 0
+	for _, c := range r {
+		if c.Start < next || c.End < c.Start || c.End >= dim {
+			return fmt.Errorf("noise_mask %q is not a sorted list of ranges within components 0-%d", r.String(), dim-1)
+		}
+		next = c.End + 2
+	}
+	return nil
+}
+
+// generateNoise draws a fresh perturbation λ for cfg into noise. Without a
+// noise mask or variance it is uniform in the ball of radius noiseRadius;
+// whitened noise is drawn by generateWhitenedNoise. With a mask, the
+// same ball is sampled over the masked plaintext components only and
+// rotated into ciphertext space, λ = Q * μ, so that C = Q * (s * v + μ)
+// perturbs exactly the masked components of the plaintext. The norm of λ
+// is unchanged, and so are the distance error bounds.
+func (b *vectorBackend) generateNoise(matrix *mat.Dense, cfg *rotationConfig, noise []float64) error {
+	if len(cfg.NoiseVariance) > 0 {
+		return b.generateWhitenedNoise(matrix, cfg, noise)
+	}
+	if len(cfg.NoiseMask) == 0 {
+		_, err := GenerateSecureBallNoise(noise, cfg.Dimension, cfg.noiseRadius())
+		return err
+	}
+
+	maskedPtr := b.borrowFloats()
+	defer b.returnFloats(maskedPtr)
+	b.sizeFloats(maskedPtr, cfg.NoiseMask.count())
+	masked, err := GenerateSecureBallNoise(*maskedPtr, len(*maskedPtr), cfg.noiseRadius())
+	if err != nil {
+		return err
+	}
+
+	planePtr := b.borrowFloats()
+	defer b.returnFloats(planePtr)
+	b.sizeFloats(planePtr, cfg.Dimension)
+	plane := *planePtr
+	clear(plane)
+	for _, c := range cfg.NoiseMask {
+		masked = masked[copy(plane[c.Start:c.End+1], masked):]
+	}
+
+	mat.NewVecDense(cfg.Dimension, noise[:cfg.Dimension]).MulVec(matrix, mat.NewVecDense(cfg.Dimension, plane))
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParseComponentRanges(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":            "",
+		"0-3":         "0-3",
+		" 5, 0-1 ":    "0-1,5",
+		"0-2,2-4":     "0-4",
+		"0-1,2-3":     "0-3",
+		"0-7":         "",
+		"7":           "7",
+		"1-2,4-5,3-3": "1-5",
+		"0,2,4,6":     "0,2,4,6",
+		"6-7, 0-0, 3": "0,3,6-7",
+		"0-6,7":       "",
+		"3-3,3-3,3-3": "3",
+	} {
+		got, err := parseComponentRanges(spec, testDimension)
+		if err != nil {
+			t.Errorf("%q: %v", spec, err)
+			continue
+		}
+		if got.String() != want {
+			t.Errorf("%q = %q, want %q", spec, got.String(), want)
+		}
+		if err := got.validate(testDimension); err != nil {
+			t.Errorf("%q: parsed mask does not validate: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"8", "0-8", "3-1", "-1", "a-b", "1-", "3,"} {
+		if _, err := parseComponentRanges(spec, testDimension); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestNoiseMask(t *testing.T) {
+	b, s := getTestBackend(t)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       2.0,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     1.0,
+		"noise_mask":           "1-3",
+	})
+	if resp.Data["noise_mask"] != "1-3" {
+		t.Fatalf("noise_mask = %v, want 1-3", resp.Data["noise_mask"])
+	}
+	matrix, _, err := b.getMatrixAndConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The query is s * Q * v exactly, so Qᵀ (C - query) is the noise in
+	// plaintext space.
+	query := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(1),
+	}).Data["ciphertext"].([]float64)
+	for n := 0; n < 20; n++ {
+		ciphertext := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector": testVector(1),
+		}).Data["ciphertext"].([]float64)
+		diff := make([]float64, testDimension)
+		for i := range diff {
+			diff[i] = ciphertext[i] - query[i]
+		}
+		var mu mat.VecDense
+		mu.MulVec(matrix.T(), mat.NewVecDense(testDimension, diff))
+
+		var norm float64
+		for i := 0; i < testDimension; i++ {
+			v := mu.AtVec(i)
+			norm += v * v
+			if (i < 1 || i > 3) && math.Abs(v) > 1e-9 {
+				t.Fatalf("component %d outside the mask was perturbed by %v", i, v)
+			}
+		}
+		if norm == 0 || math.Sqrt(norm) > 1+1e-9 {
+			t.Fatalf("noise norm %v, want in (0, 1]", math.Sqrt(norm))
+		}
+	}
+
+	// Masks change the transform and are refused by the strict profile.
+	unmasked := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if unmasked.Data["noise_mask"] != "" {
+		t.Errorf("noise_mask = %v, want empty", unmasked.Data["noise_mask"])
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Data:      map[string]interface{}{"dimension": testDimension, "noise_mask": "0-3"},
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("expected the strict profile to refuse a noise_mask")
+	}
+}
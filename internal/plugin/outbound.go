@@ -0,0 +1,419 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// outboundStoragePath is the Vault storage path for the settings shared
+	// by every outbound connection.
+	outboundStoragePath = "config/outbound"
+
+	// defaultOutboundTimeout bounds each outbound request when neither the
+	// integration nor config/outbound sets a timeout.
+	defaultOutboundTimeout = 10 * time.Second
+)
+
+// outboundConfig holds the mount-level settings applied to every outbound
+// connection: the webhook sink, the SIEM exporter, the KV reads of
+// vector_ref and the upstream embeddings API. Settings of an integration itself, such as its ca_cert or
+// timeout, add to or take precedence over these.
+type outboundConfig struct {
+	CACert     string        `json:"ca_cert,omitempty"`
+	ClientCert string        `json:"client_cert,omitempty"`
+	ClientKey  string        `json:"client_key,omitempty"`
+	ProxyURL   string        `json:"proxy_url,omitempty"`
+	Timeout    time.Duration `json:"timeout"`
+
+	// MaxRetries is the number of retries of a call failing transiently.
+	MaxRetries int `json:"max_retries"`
+
+	// BreakerThreshold is the number of consecutive failures that opens an
+	// integration's circuit; 0 disables the breaker. BreakerCooldown is how
+	// long an open circuit rejects calls.
+	BreakerThreshold int           `json:"breaker_threshold"`
+	BreakerCooldown  time.Duration `json:"breaker_cooldown"`
+}
+
+// defaultOutboundConfig returns the outbound settings used while
+// config/outbound is unset, and as the base of a new one.
+func defaultOutboundConfig() *outboundConfig {
+	return &outboundConfig{
+		MaxRetries:       defaultOutboundRetries,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+}
+
+// maxRetries, breakerThreshold and breakerCooldown return the retry and
+// circuit breaker policy.
+func (c *outboundConfig) maxRetries() int {
+	return c.MaxRetries
+}
+
+func (c *outboundConfig) breakerThreshold() int {
+	return c.BreakerThreshold
+}
+
+func (c *outboundConfig) breakerCooldown() time.Duration {
+	if c.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return c.BreakerCooldown
+}
+
+// responseData renders the outbound settings for API responses. The client
+// key and any proxy password are never returned.
+func (c *outboundConfig) responseData() map[string]interface{} {
+	proxyURL := c.ProxyURL
+	if u, err := url.Parse(c.ProxyURL); err == nil {
+		proxyURL = u.Redacted()
+	}
+	return map[string]interface{}{
+		"ca_cert":        c.CACert,
+		"client_cert":    c.ClientCert,
+		"client_key_set": c.ClientKey != "",
+		"proxy_url":      proxyURL,
+		"timeout":        int64(c.Timeout.Seconds()),
+
+		"max_retries":       c.MaxRetries,
+		"breaker_threshold": c.BreakerThreshold,
+		"breaker_cooldown":  int64(c.breakerCooldown().Seconds()),
+	}
+}
+
+// validate checks the outbound settings before they are stored.
+func (c *outboundConfig) validate() error {
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return fmt.Errorf("proxy_url must be an absolute http, https or socks5 URL")
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if c.MaxRetries < 0 || c.MaxRetries > maxOutboundRetries {
+		return fmt.Errorf("max_retries must be between 0 and %d", maxOutboundRetries)
+	}
+	if c.BreakerThreshold < 0 {
+		return fmt.Errorf("breaker_threshold must not be negative")
+	}
+	if c.BreakerCooldown < 0 {
+		return fmt.Errorf("breaker_cooldown must not be negative")
+	}
+	// Parse the certificates now so a bad one fails the write.
+	_, err := c.httpClient("", 0)
+	return err
+}
+
+// httpClient builds an HTTP client for an integration with its own CA
+// certificate and timeout, either of which may be empty. c may be nil when
+// config/outbound is unset. The proxy is only ever the configured one: the
+// environment of the plugin process must not redirect requests.
+func (c *outboundConfig) httpClient(caCert string, timeout time.Duration) (*http.Client, error) {
+	if c == nil {
+		c = &outboundConfig{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy_url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig, err := c.tlsConfig(caCert)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	switch {
+	case timeout > 0:
+	case c.Timeout > 0:
+		timeout = c.Timeout
+	default:
+		timeout = defaultOutboundTimeout
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// tlsConfig builds the TLS client settings for an integration with its own
+// CA certificate, which may be empty. c may be nil when config/outbound is
+// unset.
+func (c *outboundConfig) tlsConfig(caCert string) (*tls.Config, error) {
+	if c == nil {
+		c = &outboundConfig{}
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CACert != "" || caCert != "" {
+		pool := x509.NewCertPool()
+		for _, pem := range []string{c.CACert, caCert} {
+			if pem != "" && !pool.AppendCertsFromPEM([]byte(pem)) {
+				return nil, fmt.Errorf("ca_cert contains no PEM certificates")
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// pathOutbound returns the path configuration for config/outbound.
+func (b *vectorBackend) pathOutbound() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/outbound",
+			Fields: map[string]*framework.FieldSchema{
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded CA certificates trusted by every outbound connection, instead of the system roots.",
+				},
+				"client_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded client certificate presented by every outbound connection (mTLS).",
+				},
+				"client_key": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded private key of client_cert. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"proxy_url": {
+					Type:        framework.TypeString,
+					Description: "Proxy for every outbound connection (http, https or socks5). Empty connects directly.",
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: fmt.Sprintf("Timeout of outbound requests whose integration sets none (default: %s).", defaultOutboundTimeout),
+				},
+				"max_retries": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Retries of a call failing transiently, with jittered backoff (default: %d, max: %d).", defaultOutboundRetries, maxOutboundRetries),
+				},
+				"breaker_threshold": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Consecutive failures that open an integration's circuit; 0 disables circuit breaking (default: %d).", defaultBreakerThreshold),
+				},
+				"breaker_cooldown": {
+					Type:        framework.TypeDurationSecond,
+					Description: fmt.Sprintf("How long an open circuit rejects calls before a trial call (default: %s).", defaultBreakerCooldown),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleOutboundRead,
+					Summary:  "Read the outbound connection settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleOutboundWrite,
+					Summary:  "Configure TLS, proxy and timeouts of outbound connections.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleOutboundDelete,
+					Summary:  "Remove the outbound connection settings.",
+				},
+			},
+			HelpSynopsis:    pathOutboundHelpSyn,
+			HelpDescription: pathOutboundHelpDesc,
+		},
+	}
+}
+
+// handleOutboundRead returns the outbound settings, without the client key.
+func (b *vectorBackend) handleOutboundRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleOutboundWrite merges the supplied fields into the stored outbound
+// settings.
+func (b *vectorBackend) handleOutboundWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = defaultOutboundConfig()
+	}
+
+	if raw, ok := data.GetOk("ca_cert"); ok {
+		cfg.CACert = raw.(string)
+	}
+	if raw, ok := data.GetOk("client_cert"); ok {
+		cfg.ClientCert = raw.(string)
+	}
+	if raw, ok := data.GetOk("client_key"); ok {
+		cfg.ClientKey = raw.(string)
+	}
+	if raw, ok := data.GetOk("proxy_url"); ok {
+		cfg.ProxyURL = raw.(string)
+	}
+	if raw, ok := data.GetOk("timeout"); ok {
+		cfg.Timeout = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := data.GetOk("max_retries"); ok {
+		cfg.MaxRetries = raw.(int)
+	}
+	if raw, ok := data.GetOk("breaker_threshold"); ok {
+		cfg.BreakerThreshold = raw.(int)
+	}
+	if raw, ok := data.GetOk("breaker_cooldown"); ok {
+		cfg.BreakerCooldown = time.Duration(raw.(int)) * time.Second
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, outboundStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	b.resetOutboundClients()
+	if err := b.recordHistory(ctx, req, "outbound-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleOutboundDelete removes the outbound settings.
+func (b *vectorBackend) handleOutboundDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, outboundStoragePath); err != nil {
+		return nil, err
+	}
+	b.resetOutboundClients()
+	if cfg == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "outbound-delete", cfg.responseData(), map[string]interface{}{})
+}
+
+// readOutboundConfig retrieves the outbound settings, or nil if unset.
+func readOutboundConfig(ctx context.Context, storage logical.Storage) (*outboundConfig, error) {
+	var cfg outboundConfig
+	found, err := getStorageJSON(ctx, storage, outboundStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// getOutboundConfig returns the outbound settings, or the defaults while
+// config/outbound is unset, caching them. It follows the same
+// Check-Lock-Check pattern as getSettings.
+func (b *vectorBackend) getOutboundConfig(ctx context.Context, storage logical.Storage) (*outboundConfig, error) {
+	b.outboundLock.RLock()
+	if cfg := b.cachedOutbound; cfg != nil {
+		b.outboundLock.RUnlock()
+		return cfg, nil
+	}
+	b.outboundLock.RUnlock()
+
+	b.outboundLock.Lock()
+	defer b.outboundLock.Unlock()
+
+	if b.cachedOutbound != nil {
+		return b.cachedOutbound, nil
+	}
+	cfg, err := readOutboundConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = defaultOutboundConfig()
+	}
+	b.cachedOutbound = cfg
+	return cfg, nil
+}
+
+// resetOutboundClients drops the cached outbound settings and every client
+// built with them, so the next use rebuilds it.
+func (b *vectorBackend) resetOutboundClients() {
+	b.outboundLock.Lock()
+	b.cachedOutbound = nil
+	b.outboundLock.Unlock()
+	b.resetKVClient()
+	b.resetSink()
+	b.resetEmbeddingsUpstream()
+	b.resetSIEM()
+}
+
+// Help text constants for the outbound path.
+const pathOutboundHelpSyn = `Configure TLS, proxy and timeouts of the plugin's outbound connections.`
+
+const pathOutboundHelpDesc = `
+The plugin connects out to the webhook sink (config/sink), the SIEM
+exporter (config/siem) and the Vault API for vector_ref (config/kv). These
+settings apply to all of them, so the plugin can run where egress is
+mTLS-only through a corporate proxy. Syslog connections of the SIEM
+exporter do not go through the proxy.
+
+Parameters:
+  ca_cert     - PEM CA certificates trusted instead of the system roots. An
+                integration's own ca_cert is trusted in addition.
+  client_cert - PEM client certificate presented to every server (mTLS)
+  client_key  - Its PEM private key (write-only; required with client_cert)
+  proxy_url   - http, https or socks5 proxy for every connection. The
+                HTTP_PROXY and HTTPS_PROXY variables of the plugin process
+                are never used.
+  timeout     - Timeout of each request, where the integration sets none
+                (default: 10s)
+  max_retries       - Retries of a call failing transiently (default: 2)
+  breaker_threshold - Consecutive failures that open an integration's
+                      circuit; 0 disables the breaker (default: 5)
+  breaker_cooldown  - How long an open circuit fails calls immediately
+                      before a trial call (default: 30s)
+
+See stats/outbound for how retries and circuits behave, and their counters.
+
+Changes take effect on the next outbound request, on every node.
+
+Example:
+  vault write vector/config/outbound ca_cert=@corp-ca.pem \
+      client_cert=@plugin.pem client_key=@plugin-key.pem \
+      proxy_url=http://proxy.corp:3128
+`
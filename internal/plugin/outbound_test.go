@@ -0,0 +1,222 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client
+// certificate, all PEM-encoded.
+type testPKI struct {
+	caCert                string
+	serverCert, serverKey string
+	clientCert, clientKey string
+	pool                  *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, ips []net.IP) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  ips,
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	}
+
+	p := &testPKI{
+		caCert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		pool:   x509.NewCertPool(),
+	}
+	p.pool.AddCert(ca)
+	p.serverCert, p.serverKey = issue(2, x509.ExtKeyUsageServerAuth, []net.IP{net.ParseIP("127.0.0.1")})
+	p.clientCert, p.clientKey = issue(3, x509.ExtKeyUsageClientAuth, nil)
+	return p
+}
+
+func TestOutboundMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert, err := tls.X509KeyPair([]byte(pki.serverCert), []byte(pki.serverKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &fakeSinkAdapter{records: map[string]sinkRecord{}}
+	server := httptest.NewUnstartedServer(adapter)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"url":   server.URL + "/vectors",
+		"token": "adapter-token",
+	})
+	encrypt := func() error {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/vector",
+			Data:      map[string]interface{}{"vector": testVector(0), "id": "doc-1"},
+			Storage:   s,
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+
+	// Without the mount's CA and client certificate, the handshake fails.
+	if err := encrypt(); err == nil {
+		t.Fatal("stored through an mTLS adapter without a client certificate")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/outbound", map[string]interface{}{
+		"ca_cert":     pki.caCert,
+		"client_cert": pki.clientCert,
+		"client_key":  pki.clientKey,
+		"timeout":     "5s",
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/outbound", nil)
+	if _, ok := resp.Data["client_key"]; ok || resp.Data["client_key_set"] != true || resp.Data["timeout"] != int64(5) {
+		t.Errorf("config/outbound read = %v, want client_key_set without the key", resp.Data)
+	}
+	if err := encrypt(); err != nil {
+		t.Fatalf("store through the mTLS adapter: %v", err)
+	}
+	if got := adapter.ids(); len(got) != 1 {
+		t.Errorf("adapter holds %v, want [doc-1]", got)
+	}
+}
+
+func TestOutboundProxy(t *testing.T) {
+	adapter := &fakeSinkAdapter{records: map[string]sinkRecord{}}
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	// A forward proxy receives absolute-form requests for plain HTTP.
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			proxied.Add(1)
+		},
+	})
+	defer proxy.Close()
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/outbound", map[string]interface{}{
+		"proxy_url": "http://user:secret@" + proxy.Listener.Addr().String(),
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/outbound", nil)
+	if got := resp.Data["proxy_url"].(string); got != "http://user:xxxxx@"+proxy.Listener.Addr().String() {
+		t.Errorf("proxy_url = %q, want the password redacted", got)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"url":   server.URL + "/vectors",
+		"token": "adapter-token",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+		"id":     "doc-1",
+	})
+	if proxied.Load() != 1 || len(adapter.ids()) != 1 {
+		t.Errorf("%d proxied requests and adapter holds %v, want the upsert through the proxy", proxied.Load(), adapter.ids())
+	}
+
+	// Deleting config/outbound connects directly again.
+	testRequest(t, b, s, logical.DeleteOperation, "config/outbound", nil)
+	testRequest(t, b, s, logical.DeleteOperation, "ciphertext/doc-1", nil)
+	if proxied.Load() != 1 || len(adapter.ids()) != 0 {
+		t.Errorf("%d proxied requests and adapter holds %v, want a direct delete", proxied.Load(), adapter.ids())
+	}
+}
+
+func TestOutboundConfigValidation(t *testing.T) {
+	pki := newTestPKI(t)
+	b, s := getTestBackend(t)
+	for name, data := range map[string]map[string]interface{}{
+		"cert without key": {"client_cert": pki.clientCert},
+		"key without cert": {"client_key": pki.clientKey},
+		"mismatched pair":  {"client_cert": pki.clientCert, "client_key": pki.serverKey},
+		"bad ca_cert":      {"ca_cert": "not a certificate"},
+		"bad proxy":        {"proxy_url": "ftp://proxy:21"},
+		"relative proxy":   {"proxy_url": "proxy:3128"},
+		"negative timeout": {"timeout": -1},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/outbound",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && (resp == nil || !resp.IsError()) {
+			t.Errorf("%s: config/outbound accepted %v", name, data)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"math"
+)
+
+// outlierResult describes the outlier components of a plaintext and what
+// the mount's outlier_policy did about them.
+type outlierResult struct {
+	// Components is the number of components beyond max_abs_input.
+	Components int
+
+	// Scale is the factor the plaintext was multiplied by under the scale
+	// policy, or zero when it was not scaled.
+	Scale float64
+}
+
+// applyOutlierPolicy enforces max_abs_input on vector. Under the clip and
+// scale policies the adjusted plaintext is written to dst, which must have
+// len(vector) components, and returned; otherwise vector itself is
+// returned unchanged. Under the reject policy a vector with outliers is an
+// error.
+//
+// A single huge component dominates every distance to the vector, and its
+// encryption needs a scaling factor fitted to it rather than to the corpus,
+// so outliers are handled before the plaintext reaches the transform.
+func applyOutlierPolicy(dst, vector []float64, settings *mountSettings) ([]float64, outlierResult, error) {
+	var result outlierResult
+	if settings.MaxAbsInput <= 0 {
+		return vector, result, nil
+	}
+	peak := 0.0
+	for _, v := range vector {
+		if a := math.Abs(v); a > settings.MaxAbsInput {
+			result.Components++
+			peak = math.Max(peak, a)
+		}
+	}
+	if result.Components == 0 {
+		return vector, result, nil
+	}
+
+	switch settings.OutlierPolicy {
+	case outlierPolicyClip:
+		for i, v := range vector {
+			if math.Abs(v) > settings.MaxAbsInput {
+				v = math.Copysign(settings.MaxAbsInput, v)
+			}
+			dst[i] = v
+		}
+		return dst, result, nil
+	case outlierPolicyScale:
+		result.Scale = settings.MaxAbsInput / peak
+		for i, v := range vector {
+			dst[i] = v * result.Scale
+		}
+		return dst, result, nil
+	default:
+		return nil, result, fmt.Errorf("%d vector components exceed max_abs_input %v", result.Components, settings.MaxAbsInput)
+	}
+}
+
+// addTo reports the outliers on a JSON response: outlier_components, and
+// input_scale when the plaintext was scaled.
+func (r outlierResult) addTo(data map[string]interface{}) {
+	if r.Components == 0 {
+		return
+	}
+	data["outlier_components"] = r.Components
+	if r.Scale > 0 {
+		data["input_scale"] = r.Scale
+	}
+}
+
+// warnings returns the response warnings for the outliers handled under
+// the given settings.
+func (r outlierResult) warnings(settings *mountSettings) []typedWarning {
+	if r.Components == 0 {
+		return nil
+	}
+	if r.Scale > 0 {
+		return []typedWarning{{Code: warnScaledInput, Message: fmt.Sprintf(
+			"%d plaintext components exceeded max_abs_input %v; the vector was scaled by %v, shrinking its distances.",
+			r.Components, settings.MaxAbsInput, r.Scale)}}
+	}
+	return []typedWarning{{Code: warnClippedInput, Message: fmt.Sprintf(
+		"%d plaintext components were clipped to ±%v (max_abs_input); distances involving this vector are distorted.",
+		r.Components, settings.MaxAbsInput)}}
+}
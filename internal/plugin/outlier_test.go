@@ -0,0 +1,118 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestApplyOutlierPolicy(t *testing.T) {
+	vector := []float64{0.5, -4, 1, 2}
+	for policy, want := range map[string][]float64{
+		outlierPolicyClip:  {0.5, -1, 1, 1},
+		outlierPolicyScale: {0.125, -1, 0.25, 0.5},
+	} {
+		settings := &mountSettings{MaxAbsInput: 1, OutlierPolicy: policy}
+		got, result, err := applyOutlierPolicy(make([]float64, len(vector)), vector, settings)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if !equalFloats(got, want) {
+			t.Errorf("%s: got %v, want %v", policy, got, want)
+		}
+		if result.Components != 2 {
+			t.Errorf("%s: %d outliers, want 2", policy, result.Components)
+		}
+		if (policy == outlierPolicyScale) != (result.Scale == 0.25) {
+			t.Errorf("%s: scale = %v", policy, result.Scale)
+		}
+		if vector[1] != -4 {
+			t.Fatalf("%s: input was modified", policy)
+		}
+	}
+
+	reject := &mountSettings{MaxAbsInput: 1, OutlierPolicy: outlierPolicyReject}
+	if _, _, err := applyOutlierPolicy(make([]float64, len(vector)), vector, reject); err == nil {
+		t.Error("reject: expected an error")
+	}
+	inRange := []float64{0.5, -1, 1}
+	if got, result, err := applyOutlierPolicy(nil, inRange, reject); err != nil || result.Components != 0 || &got[0] != &inRange[0] {
+		t.Errorf("in range: got %v, %+v, %v", got, result, err)
+	}
+}
+
+func TestEncryptOutlierPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	spike := testVector(1)
+	spike[2] = 1000.0
+
+	// Without a bound, the spike is encrypted as is.
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{"vector": spike})
+	if _, ok := resp.Data["outlier_components"]; ok {
+		t.Error("outliers reported without max_abs_input")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"max_abs_input": 10.0,
+	})
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/vector",
+		Data:      map[string]interface{}{"vector": spike},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatal("expected the default reject policy to refuse the spike")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"outlier_policy": outlierPolicyClip,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{"vector": spike})
+	if resp.Data["outlier_components"] != 1 || len(resp.Warnings) != 1 {
+		t.Errorf("clip: outlier_components = %v, warnings = %v", resp.Data["outlier_components"], resp.Warnings)
+	}
+	clipped := testVector(1)
+	clipped[2] = 10.0
+	want := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{"vector": clipped})
+	if d := euclideanDistance(resp.Data["ciphertext"].([]float64), want.Data["ciphertext"].([]float64)); d > 1e-9 {
+		t.Errorf("clip: ciphertext is %v from that of the clipped vector", d)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"outlier_policy": outlierPolicyScale,
+	})
+	batch := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{spike, testVector(2)},
+	})
+	results := batch.Data["batch_results"].([]batchItemResult)
+	if results[0].OutlierComponents != 1 || math.Abs(results[0].InputScale-0.01) > 1e-12 {
+		t.Errorf("scale: item 0 = %+v", results[0])
+	}
+	if results[1].OutlierComponents != 0 || results[1].InputScale != 0 {
+		t.Errorf("scale: item 1 = %+v", results[1])
+	}
+
+	settings := testRequest(t, b, s, logical.ReadOperation, "config/settings", nil)
+	if settings.Data["max_abs_input"] != 10.0 || settings.Data["outlier_policy"] != outlierPolicyScale {
+		t.Errorf("settings = %v", settings.Data)
+	}
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/settings",
+		Data:      map[string]interface{}{"max_abs_input": -1.0},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("expected an error for a negative max_abs_input")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// float32Size is the size of a packed float32 component in bytes.
+	float32Size = 4
+
+	// float64Size is the size of a packed float64 component in bytes.
+	float64Size = 8
+)
+
+// packFloat32 appends vector to dst as little-endian IEEE 754 float32 values.
+func packFloat32(dst []byte, vector []float64) []byte {
+	var buf [float32Size]byte
+	for _, v := range vector {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v)))
+		dst = append(dst, buf[:]...)
+	}
+	return dst
+}
+
+// unpackFloat32Frame splits a packed little-endian float32 frame into vectors of dim components.
+// The frame length must be a non-zero multiple of dim*4 bytes.
+func unpackFloat32Frame(frame []byte, dim int) ([][]float64, error) {
+	stride := dim * float32Size
+	if len(frame) == 0 || len(frame)%stride != 0 {
+		return nil, fmt.Errorf("frame length %d is not a positive multiple of %d bytes (dimension %d * 4)",
+			len(frame), stride, dim)
+	}
+
+	vectors := make([][]float64, len(frame)/stride)
+	for n := range vectors {
+		vec := make([]float64, dim)
+		offset := n * stride
+		for i := range vec {
+			bits := binary.LittleEndian.Uint32(frame[offset+i*float32Size:])
+			vec[i] = float64(math.Float32frombits(bits))
+		}
+		vectors[n] = vec
+	}
+	return vectors, nil
+}
+
+// packFloat64 appends vector to dst as little-endian IEEE 754 float64 values.
+func packFloat64(dst []byte, vector []float64) []byte {
+	var buf [float64Size]byte
+	for _, v := range vector {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		dst = append(dst, buf[:]...)
+	}
+	return dst
+}
+
+// packCiphertext returns a ciphertext as standard base64 of its packed
+// components: float32 at precision float32, float64 otherwise.
+func packCiphertext(ciphertext []float64, precision string) string {
+	if precision == precisionFloat32 {
+		return base64.StdEncoding.EncodeToString(packFloat32(make([]byte, 0, len(ciphertext)*float32Size), ciphertext))
+	}
+	return base64.StdEncoding.EncodeToString(packFloat64(make([]byte, 0, len(ciphertext)*float64Size), ciphertext))
+}
+
+// unpackCiphertext decodes a ciphertext of dim components packed by
+// packCiphertext. The component width follows from the length, which is
+// dim*4 bytes for float32 and dim*8 for float64.
+func unpackCiphertext(dst []float64, s string, dim int) ([]float64, error) {
+	packed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext is not valid base64: %w", err)
+	}
+	out := resizeFloats(dst, dim)
+	switch len(packed) {
+	case dim * float32Size:
+		for i := range out {
+			out[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(packed[i*float32Size:])))
+		}
+	case dim * float64Size:
+		for i := range out {
+			out[i] = math.Float64frombits(binary.LittleEndian.Uint64(packed[i*float64Size:]))
+		}
+	default:
+		return nil, fmt.Errorf("packed ciphertext is %d bytes; dimension %d needs %d (float32) or %d (float64)",
+			len(packed), dim, dim*float32Size, dim*float64Size)
+	}
+	for i, v := range out {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("ciphertext element %d is invalid (NaN or Inf)", i)
+		}
+	}
+	return out, nil
+}
+
+// parseCiphertextInto parses a ciphertext of dim components supplied in
+// the given encoding, decoding into dst when it has enough capacity.
+// Array ciphertexts are parsed like plaintext vectors by parseVectorInto.
+func parseCiphertextInto(dst []float64, raw interface{}, encoding string, dim int) ([]float64, error) {
+	if encoding != encodingBase64 {
+		return parseVectorInto(dst, raw)
+	}
+	// Vault wraps a string sent for a slice field in a one-element slice.
+	if v, ok := raw.([]interface{}); ok && len(v) == 1 {
+		raw = v[0]
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("with encoding=base64 the ciphertext must be a base64 string")
+	}
+	return unpackCiphertext(dst, s, dim)
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+)
+
+func TestPackUnpackFloat32(t *testing.T) {
+	vectors := [][]float64{{1, -2.5, 0.125}, {3, 4, 5}}
+
+	var frame []byte
+	for _, v := range vectors {
+		frame = packFloat32(frame, v)
+	}
+	if len(frame) != 2*3*float32Size {
+		t.Fatalf("frame length = %d, want %d", len(frame), 2*3*float32Size)
+	}
+
+	got, err := unpackFloat32Frame(frame, 3)
+	if err != nil {
+		t.Fatalf("unpackFloat32Frame failed: %v", err)
+	}
+	for i := range vectors {
+		for j := range vectors[i] {
+			if got[i][j] != vectors[i][j] {
+				t.Errorf("got[%d][%d] = %v, want %v", i, j, got[i][j], vectors[i][j])
+			}
+		}
+	}
+
+	if _, err := unpackFloat32Frame(frame[:len(frame)-1], 3); err == nil {
+		t.Error("expected error for truncated frame")
+	}
+	if _, err := unpackFloat32Frame(nil, 3); err == nil {
+		t.Error("expected error for empty frame")
+	}
+}
+
+func TestPackUnpackCiphertext(t *testing.T) {
+	ciphertext := []float64{1.1, -2.5, 3e-9}
+
+	for _, precision := range []string{precisionFloat64, precisionFloat32} {
+		packed := packCiphertext(ciphertext, precision)
+		got, err := unpackCiphertext(nil, packed, len(ciphertext))
+		if err != nil {
+			t.Fatalf("%s: unpackCiphertext failed: %v", precision, err)
+		}
+		for i, c := range ciphertext {
+			want := c
+			if precision == precisionFloat32 {
+				want = float64(float32(c))
+			}
+			if got[i] != want {
+				t.Errorf("%s: component %d = %v, want %v", precision, i, got[i], want)
+			}
+		}
+	}
+
+	if _, err := unpackCiphertext(nil, packCiphertext(ciphertext, precisionFloat64), 4); err == nil {
+		t.Error("expected error for a packed ciphertext of the wrong dimension")
+	}
+	if _, err := unpackCiphertext(nil, "not base64!", 3); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+	if _, err := parseCiphertextInto(nil, []interface{}{1.0, 2.0, 3.0}, encodingBase64, 3); err == nil {
+		t.Error("expected error for an array ciphertext with encoding=base64")
+	}
+}
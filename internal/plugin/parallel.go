@@ -0,0 +1,131 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"runtime"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// defaultParallelism keeps each request on one goroutine, as before
+	// the setting existed.
+	defaultParallelism = 1
+
+	// maxParallelism bounds the parallelism setting.
+	maxParallelism = 256
+
+	// minParallelRows is the fewest matrix rows worth a goroutine of their
+	// own. Below it, starting and joining goroutines costs more than the
+	// rows' share of the multiply: only dimensions of 2·minParallelRows
+	// and up are split.
+	minParallelRows = 512
+)
+
+// workers returns the goroutines a request may use: the parallelism
+// setting, or GOMAXPROCS for 0.
+func (s *mountSettings) workers() int {
+	if s.Parallelism == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return s.Parallelism
+}
+
+// parallelRows calls fn on contiguous blocks [lo, hi) covering rows, from
+// up to workers goroutines, each block at least minParallelRows long. It
+// returns once every call has.
+func parallelRows(rows, workers int, fn func(lo, hi int)) {
+	if n := rows / minParallelRows; workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		fn(0, rows)
+		return
+	}
+	var g panicGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := rows*w/workers, rows*(w+1)/workers
+		g.Go(func() { fn(lo, hi) })
+	}
+	g.Wait()
+}
+
+// parallelMulVec sets dst to m·x, splitting the rows of m across up to
+// workers goroutines. Each row is still one dot product, so the result is
+// bit-identical to an unsplit multiply; portable keys keep their fixed
+// summation order.
+func parallelMulVec(dst []float64, m *mat.Dense, x []float64, workers int, portable bool) {
+	rows, cols := m.Dims()
+	xv := mat.NewVecDense(cols, x)
+	parallelRows(rows, workers, func(lo, hi int) {
+		block := m.Slice(lo, hi, 0, cols).(*mat.Dense)
+		if portable {
+			portableMulVec(dst[lo:hi], block, x)
+			return
+		}
+		mat.NewVecDense(hi-lo, dst[lo:hi]).MulVec(block, xv)
+	})
+}
+
+// parallelItems calls fn(worker, i) for every i in [0, n), spreading the
+// items over up to workers goroutines numbered from 0. Each goroutine
+// takes a contiguous range of items, in order.
+func parallelItems(n, workers int, fn func(worker, i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(0, i)
+		}
+		return
+	}
+	var g panicGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		g.Go(func() {
+			for i := lo; i < hi; i++ {
+				fn(w, i)
+			}
+		})
+	}
+	g.Wait()
+}
+
+// panicGroup runs goroutines and waits for them. A panic in one is
+// re-raised by Wait in the calling goroutine, where the request handlers'
+// recover turns it into an error; unrecovered, it would end the plugin.
+type panicGroup struct {
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	recovered interface{}
+}
+
+// Go runs fn in a new goroutine.
+func (g *panicGroup) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.mu.Lock()
+				if g.recovered == nil {
+					g.recovered = r
+				}
+				g.mu.Unlock()
+			}
+		}()
+		fn()
+	}()
+}
+
+// Wait waits for the goroutines, then re-raises the first panic, if any.
+func (g *panicGroup) Wait() {
+	g.wg.Wait()
+	if g.recovered != nil {
+		panic(g.recovered)
+	}
+}
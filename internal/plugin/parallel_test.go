@@ -0,0 +1,116 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParallelMulVec(t *testing.T) {
+	const dim = 4*minParallelRows + 3
+	rng := rand.New(rand.NewSource(1))
+	data := make([]float64, dim*dim)
+	for i := range data {
+		data[i] = rng.NormFloat64()
+	}
+	m := mat.NewDense(dim, dim, data)
+	x := make([]float64, dim)
+	for i := range x {
+		x[i] = rng.NormFloat64()
+	}
+
+	want := make([]float64, dim)
+	mat.NewVecDense(dim, want).MulVec(m, mat.NewVecDense(dim, x))
+	wantPortable := make([]float64, dim)
+	portableMulVec(wantPortable, m, x)
+
+	for _, workers := range []int{1, 3, 4, 64} {
+		got := make([]float64, dim)
+		parallelMulVec(got, m, x, workers, false)
+		gotPortable := make([]float64, dim)
+		parallelMulVec(gotPortable, m, x, workers, true)
+		for i := range want {
+			if got[i] != want[i] || gotPortable[i] != wantPortable[i] {
+				t.Fatalf("workers=%d: row %d = %v (portable %v), want bit-identical %v (%v)",
+					workers, i, got[i], gotPortable[i], want[i], wantPortable[i])
+			}
+		}
+	}
+}
+
+func TestParallelItems(t *testing.T) {
+	const n, workers = 103, 8
+	var seen [n]atomic.Int32
+	var bad atomic.Bool
+	parallelItems(n, workers, func(w, i int) {
+		if w < 0 || w >= workers {
+			bad.Store(true)
+		}
+		seen[i].Add(1)
+	})
+	if bad.Load() {
+		t.Error("worker number out of range")
+	}
+	for i := range seen {
+		if got := seen[i].Load(); got != 1 {
+			t.Fatalf("item %d visited %d times", i, got)
+		}
+	}
+}
+
+func TestParallelItems_Panic(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the worker's panic", r)
+		}
+	}()
+	parallelItems(4, 4, func(_, i int) {
+		if i == 2 {
+			panic("boom")
+		}
+	})
+	t.Error("panic not re-raised")
+}
+
+func TestBackendParallelBatch(t *testing.T) {
+	b, s := getTestBackend(t)
+	// Noiseless keys encrypt deterministically.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"parallelism": 4,
+	})
+
+	vectors := make([]interface{}, 10)
+	for i := range vectors {
+		vectors[i] = testVector(float64(i))
+	}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": vectors,
+	})
+	results := resp.Data["batch_results"].([]batchItemResult)
+	for i, vector := range vectors {
+		single := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector": vector,
+		}).Data["ciphertext"].([]float64)
+		for j := range single {
+			if results[i].Ciphertext[j] != single[j] {
+				t.Fatalf("batch item %d = %v, want %v", i, results[i].Ciphertext, single)
+			}
+		}
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"parallelism": maxParallelism + 1,
+	}); err == nil {
+		t.Error("parallelism beyond the maximum accepted")
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxVectorElements bounds the element count of a single input vector.
+	// It is checked before any float conversion so oversized inputs are
+	// rejected without allocating a converted copy.
+	maxVectorElements = MaxDimension
+
+	// maxVectorJSONBytes bounds a vector supplied as a JSON string (32 bytes per element).
+	maxVectorJSONBytes = maxVectorElements * 32
+
+	// maxNumberStringLength bounds a single number supplied as a string.
+	maxNumberStringLength = 64
+
+	// maxBatchJSONBytes bounds batch input supplied as a single string
+	// (JSON or NDJSON). It matches Vault's default max_request_size.
+	maxBatchJSONBytes = 32 << 20
+
+	// utf8BOM is the UTF-8 byte order mark, which some editors and Windows
+	// tools prepend to files passed as vector=@file.
+	utf8BOM = "\uFEFF"
+)
+
+// parseVector converts various input formats to []float64.
+// Supports: []float64, []interface{}, JSON string, []string.
+func parseVector(raw interface{}) ([]float64, error) {
+	return parseVectorInto(nil, raw)
+}
+
+// parseVectorInto converts various input formats to []float64, decoding
+// directly into dst when it has enough capacity. Callers pass a pooled buffer
+// (truncated to length zero) to avoid a per-request allocation; the returned
+// slice may be dst or, if dst was too small, a new allocation.
+func parseVectorInto(dst []float64, raw interface{}) ([]float64, error) {
+	if raw == nil {
+		return nil, fmt.Errorf("vector is required")
+	}
+	if err := checkVectorInputSize(raw); err != nil {
+		return nil, err
+	}
+
+	switch v := raw.(type) {
+	case []interface{}:
+		// Handle single JSON string wrapped in slice (Vault CLI behavior).
+		if len(v) == 1 {
+			if str, ok := v[0].(string); ok {
+				return parseVectorInto(dst, str)
+			}
+		}
+		result := resizeFloats(dst, len(v))
+		for i, val := range v {
+			switch val.(type) {
+			case []interface{}, []float64, map[string]interface{}, nil:
+				return nil, fmt.Errorf("vector element %d is %s; vector must be a flat array of numbers", i, describeValue(val))
+			}
+			num, err := coerceFloat(val)
+			if err != nil {
+				return nil, fmt.Errorf("vector element %d is not a float: %w", i, err)
+			}
+			if math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
+			}
+			result[i] = num
+		}
+		return result, nil
+
+	case []float64:
+		for i, num := range v {
+			if math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
+			}
+		}
+		result := resizeFloats(dst, len(v))
+		copy(result, v)
+		return result, nil
+
+	case string:
+		result, err := scanFloatArray(dst[:0], v)
+		if err != nil {
+			return nil, fmt.Errorf("vector must be JSON array of floats: %w", err)
+		}
+		return result, nil
+
+	case []string:
+		result := resizeFloats(dst, len(v))
+		for i, val := range v {
+			num, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("vector element %d is not a float: %w", i, err)
+			}
+			if math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, fmt.Errorf("vector element %d is invalid (NaN or Inf)", i)
+			}
+			result[i] = num
+		}
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("vector must be an array of floats")
+	}
+}
+
+// resizeFloats returns dst resized to n elements, allocating only if dst is too small.
+func resizeFloats(dst []float64, n int) []float64 {
+	if cap(dst) < n {
+		return make([]float64, n)
+	}
+	return dst[:n]
+}
+
+// scanFloatArray parses a JSON array of numbers, appending each element to dst.
+// Unlike json.Unmarshal it allocates no intermediate values: numbers are
+// parsed in place from substrings of s. It accepts exactly the JSON grammar
+// for a flat array of numbers surrounded by optional whitespace, and names
+// the common malformations (byte order mark, truncation, duplicate or
+// trailing commas, nesting, non-numeric elements) in its errors.
+func scanFloatArray(dst []float64, s string) ([]float64, error) {
+	if strings.HasPrefix(s, utf8BOM) {
+		return nil, fmt.Errorf("input begins with a UTF-8 byte order mark; remove it")
+	}
+	i := skipJSONSpace(s, 0)
+	if i >= len(s) {
+		return nil, fmt.Errorf("input is empty")
+	}
+	if s[i] != '[' {
+		return nil, fmt.Errorf("expected '[' at offset %d, found %s", i, snippet(s, i))
+	}
+	i = skipJSONSpace(s, i+1)
+	if i < len(s) && s[i] == ']' {
+		if j := skipJSONSpace(s, i+1); j != len(s) {
+			return nil, fmt.Errorf("unexpected data after array at offset %d", j)
+		}
+		return dst, nil
+	}
+
+	for {
+		if len(dst) >= maxVectorElements {
+			return nil, fmt.Errorf("vector has more than %d elements", maxVectorElements)
+		}
+		end := scanJSONNumber(s, i)
+		if end == i {
+			return nil, scanElementError(s, i, len(dst))
+		}
+		num, err := strconv.ParseFloat(s[i:end], 64)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, fmt.Errorf("vector element %d: %s is out of float64 range", len(dst), s[i:end])
+			}
+			return nil, fmt.Errorf("vector element %d: %w", len(dst), err)
+		}
+		dst = append(dst, num)
+
+		i = skipJSONSpace(s, end)
+		if i >= len(s) {
+			return nil, fmt.Errorf("truncated input: missing ']' after element %d", len(dst)-1)
+		}
+		switch s[i] {
+		case ',':
+			i = skipJSONSpace(s, i+1)
+		case ']':
+			if j := skipJSONSpace(s, i+1); j != len(s) {
+				return nil, fmt.Errorf("unexpected data after array at offset %d", j)
+			}
+			return dst, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' after element %d at offset %d, found %s", len(dst)-1, i, snippet(s, i))
+		}
+	}
+}
+
+// scanElementError explains why no number starts at offset i of s, where
+// element n was expected.
+func scanElementError(s string, i, n int) error {
+	if i >= len(s) {
+		return fmt.Errorf("truncated input: expected element %d", n)
+	}
+	switch s[i] {
+	case ',':
+		return fmt.Errorf("empty vector element %d at offset %d (duplicate comma)", n, i)
+	case ']':
+		return fmt.Errorf("trailing comma before ']' at offset %d", i)
+	case '[':
+		return fmt.Errorf("vector element %d is a nested array; vector must be a flat array of numbers", n)
+	case '{':
+		return fmt.Errorf("vector element %d is an object; vector must be a flat array of numbers", n)
+	case '"':
+		return fmt.Errorf("vector element %d is a string; numbers must not be quoted", n)
+	}
+	return fmt.Errorf("vector element %d: expected a number at offset %d, found %s", n, i, snippet(s, i))
+}
+
+// snippet quotes the character at offset i of s for error messages. Only
+// one character is quoted so errors never echo plaintext vector content.
+func snippet(s string, i int) string {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return strconv.QuoteRune(r)
+}
+
+// describeValue names the JSON type of a decoded value for error messages.
+func describeValue(val interface{}) string {
+	switch val.(type) {
+	case []interface{}, []float64:
+		return "a nested array"
+	case map[string]interface{}:
+		return "an object"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("a %T", val)
+	}
+}
+
+// skipJSONSpace returns the offset of the first non-whitespace byte at or after i.
+func skipJSONSpace(s string, i int) int {
+	for i < len(s) {
+		switch s[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// scanJSONNumber returns the end offset of the JSON number starting at i,
+// or i itself if no valid number starts there.
+// Grammar: -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+func scanJSONNumber(s string, i int) int {
+	start := i
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		i = skipDigits(s, i)
+	default:
+		return start
+	}
+	if i < len(s) && s[i] == '.' {
+		j := skipDigits(s, i+1)
+		if j == i+1 {
+			return start
+		}
+		i = j
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		j := skipDigits(s, i)
+		if j == i {
+			return start
+		}
+		i = j
+	}
+	return i
+}
+
+// skipDigits returns the offset of the first non-digit byte at or after i.
+func skipDigits(s string, i int) int {
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// checkVectorInputSize rejects oversized vector input before it is parsed or
+// converted, bounding per-request memory independent of the input format.
+func checkVectorInputSize(raw interface{}) error {
+	switch v := raw.(type) {
+	case []interface{}:
+		if len(v) > maxVectorElements {
+			return fmt.Errorf("vector has %d elements, exceeding maximum %d", len(v), maxVectorElements)
+		}
+		for i, val := range v {
+			if str, ok := val.(string); ok && len(v) > 1 && len(str) > maxNumberStringLength {
+				return fmt.Errorf("vector element %d is %d bytes, exceeding maximum %d", i, len(str), maxNumberStringLength)
+			}
+		}
+	case []float64:
+		if len(v) > maxVectorElements {
+			return fmt.Errorf("vector has %d elements, exceeding maximum %d", len(v), maxVectorElements)
+		}
+	case []string:
+		if len(v) > maxVectorElements {
+			return fmt.Errorf("vector has %d elements, exceeding maximum %d", len(v), maxVectorElements)
+		}
+		for i, str := range v {
+			if len(str) > maxNumberStringLength {
+				return fmt.Errorf("vector element %d is %d bytes, exceeding maximum %d", i, len(str), maxNumberStringLength)
+			}
+		}
+	case string:
+		if len(v) > maxVectorJSONBytes {
+			return fmt.Errorf("vector input is %d bytes, exceeding maximum %d", len(v), maxVectorJSONBytes)
+		}
+	}
+	return nil
+}
+
+// parseVectorList converts a list of at most maxItems vectors to [][]float64.
+// Supports: []interface{} of vectors, a JSON string holding an array of arrays,
+// and a single JSON string wrapped in a slice (Vault CLI behavior).
+// The item count and input size are checked before any vector is converted.
+func parseVectorList(raw interface{}, maxItems int) ([][]float64, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, fmt.Errorf("vectors is required")
+
+	case string:
+		if len(v) > maxBatchJSONBytes {
+			return nil, fmt.Errorf("vectors input is %d bytes, exceeding maximum %d", len(v), maxBatchJSONBytes)
+		}
+		var parsed [][]float64
+		if err := json.Unmarshal([]byte(v), &parsed); err != nil {
+			return nil, fmt.Errorf("vectors must be JSON array of float arrays: %w", err)
+		}
+		if len(parsed) > maxItems {
+			return nil, fmt.Errorf("%d vectors exceed maximum %d", len(parsed), maxItems)
+		}
+		for i, vec := range parsed {
+			if _, err := parseVector(vec); err != nil {
+				return nil, fmt.Errorf("vector %d: %w", i, err)
+			}
+		}
+		return parsed, nil
+
+	case []interface{}:
+		if len(v) == 1 {
+			if str, ok := v[0].(string); ok {
+				return parseVectorList(str, maxItems)
+			}
+		}
+		if len(v) > maxItems {
+			return nil, fmt.Errorf("%d vectors exceed maximum %d", len(v), maxItems)
+		}
+		result := make([][]float64, len(v))
+		for i, item := range v {
+			vec, err := parseVector(item)
+			if err != nil {
+				return nil, fmt.Errorf("vector %d: %w", i, err)
+			}
+			result[i] = vec
+		}
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("vectors must be an array of float arrays")
+	}
+}
+
+// coerceFloat converts various numeric types to float64.
+func coerceFloat(val interface{}) (float64, error) {
+	switch t := val.(type) {
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case json.Number:
+		return t.Float64()
+	case string:
+		return strconv.ParseFloat(t, 64)
+	default:
+		return 0, fmt.Errorf("unsupported type %T", val)
+	}
+}
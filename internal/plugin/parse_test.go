@@ -0,0 +1,263 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseVector(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   interface{}
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name:    "valid float slice",
+			input:   []float64{1.0, 2.0, 3.0},
+			wantLen: 3,
+			wantErr: false,
+		},
+		{
+			name:    "valid int slice",
+			input:   []interface{}{1, 2, 3},
+			wantLen: 3,
+			wantErr: false,
+		},
+		{
+			name:    "NaN value",
+			input:   []float64{1.0, math.NaN()},
+			wantLen: 0,
+			wantErr: true,
+		},
+		{
+			name:    "Inf value",
+			input:   []float64{1.0, math.Inf(1)},
+			wantLen: 0,
+			wantErr: true,
+		},
+		{
+			name:    "JSON string",
+			input:   "[1.1, 2.2]",
+			wantLen: 2,
+			wantErr: false,
+		},
+		{
+			name:    "String array",
+			input:   []string{"1.1", "2.2"},
+			wantLen: 2,
+			wantErr: false,
+		},
+		{
+			name:    "too many elements",
+			input:   make([]interface{}, maxVectorElements+1),
+			wantLen: 0,
+			wantErr: true,
+		},
+		{
+			name:    "oversized JSON string",
+			input:   "[" + strings.Repeat(" ", maxVectorJSONBytes) + "1]",
+			wantLen: 0,
+			wantErr: true,
+		},
+		{
+			name:    "oversized number string",
+			input:   []string{"1", strings.Repeat("1", maxNumberStringLength+1)},
+			wantLen: 0,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVector(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseVector() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got) != tt.wantLen {
+				t.Errorf("parseVector() len = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestScanFloatArray(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []float64
+		wantErr bool
+	}{
+		{name: "simple", input: "[1, 2.5, -3e2]", want: []float64{1, 2.5, -300}},
+		{name: "whitespace", input: " \n[ 0 ,\t-0.125 ]\r\n", want: []float64{0, -0.125}},
+		{name: "empty", input: "[]", want: []float64{}},
+		{name: "trailing comma", input: "[1, 2,]", wantErr: true},
+		{name: "double comma", input: "[1,,2]", wantErr: true},
+		{name: "truncated", input: "[1, 2", wantErr: true},
+		{name: "trailing data", input: "[1] x", wantErr: true},
+		{name: "leading zero", input: "[01]", wantErr: true},
+		{name: "bare dot", input: "[1.]", wantErr: true},
+		{name: "null element", input: "[1, null]", wantErr: true},
+		{name: "string element", input: "[\"1\"]", wantErr: true},
+		{name: "out of range", input: "[1e400]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scanFloatArray(nil, tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scanFloatArray(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("scanFloatArray(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("element %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseVectorIntoReusesBuffer(t *testing.T) {
+	buf := make([]float64, 0, 8)
+
+	got, err := parseVectorInto(buf, "[1, 2, 3]")
+	if err != nil {
+		t.Fatalf("parseVectorInto failed: %v", err)
+	}
+	if &got[0] != &buf[:1][0] {
+		t.Error("parseVectorInto should decode into the supplied buffer")
+	}
+
+	got, err = parseVectorInto(buf, []interface{}{1.0, 2.0})
+	if err != nil {
+		t.Fatalf("parseVectorInto failed: %v", err)
+	}
+	if &got[0] != &buf[:1][0] {
+		t.Error("parseVectorInto should reuse the supplied buffer for []interface{} input")
+	}
+}
+
+func BenchmarkParseVectorJSONString(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i := 0; i < 1536; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("0.0123456789")
+	}
+	sb.WriteByte(']')
+	input := sb.String()
+	buf := make([]float64, 0, 1536)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseVectorInto(buf, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestScanFloatArrayErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "input is empty"},
+		{" \n", "input is empty"},
+		{"\uFEFF[1, 2]", "byte order mark"},
+		{"[1, 2", "truncated input"},
+		{"[1,", "truncated input"},
+		{"[1,,2]", "duplicate comma"},
+		{"[1, 2,]", "trailing comma"},
+		{"[[1, 2]]", "nested array"},
+		{"[1, {\"a\": 2}]", "object"},
+		{"[\"1\"]", "must not be quoted"},
+		{"[1, NaN]", "expected a number at offset 4, found 'N'"},
+		{"[1e400]", "out of float64 range"},
+		{"[1 2]", "expected ',' or ']' after element 0"},
+		{"{}", "expected '[' at offset 0"},
+	}
+	for _, tt := range tests {
+		_, err := scanFloatArray(nil, tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("scanFloatArray(%q) error = %v, want it to mention %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestScanFloatArrayLimit(t *testing.T) {
+	full := "[" + strings.Repeat("0,", maxVectorElements-1) + "0]"
+	if got, err := scanFloatArray(nil, full); err != nil || len(got) != maxVectorElements {
+		t.Fatalf("array of %d elements: %d elements, error %v", maxVectorElements, len(got), err)
+	}
+	over := "[" + strings.Repeat("0,", maxVectorElements) + "0]"
+	want := fmt.Sprintf("vector has more than %d elements", maxVectorElements)
+	if _, err := scanFloatArray(nil, over); err == nil || err.Error() != want {
+		t.Errorf("array of %d elements: error %v, want %q", maxVectorElements+1, err, want)
+	}
+}
+
+func TestParseVectorRejectsNesting(t *testing.T) {
+	for _, input := range []interface{}{
+		[]interface{}{1.0, []interface{}{2.0}},
+		[]interface{}{1.0, map[string]interface{}{"v": 2.0}},
+		[]interface{}{1.0, nil},
+	} {
+		_, err := parseVector(input)
+		if err == nil || !strings.Contains(err.Error(), "flat array") {
+			t.Errorf("parseVector(%v) error = %v, want a flat array error", input, err)
+		}
+	}
+}
+
+// FuzzScanFloatArray checks scanFloatArray against encoding/json: anything it
+// accepts must decode to the same values, and it must accept every flat array
+// of numbers that encoding/json accepts.
+func FuzzScanFloatArray(f *testing.F) {
+	for _, seed := range []string{
+		"[1, 2.5, -3e2]", "[]", " [ 0 ] ", "[1,,2]", "[1, 2,]", "[1, 2",
+		"\uFEFF[1]", "[[1]]", "[1e400]", "[-0.0]", "[01]", "[1.]", "[\"1\"]",
+		"[null]", "[1E+2, 1e-2]", "[\t1\r\n]",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		got, scanErr := scanFloatArray(nil, s)
+
+		var want []float64
+		jsonErr := json.Unmarshal([]byte(s), &want)
+		// encoding/json decodes null (and null elements) leniently.
+		if jsonErr == nil && strings.Contains(s, "null") {
+			return
+		}
+
+		if (scanErr == nil) != (jsonErr == nil) {
+			t.Fatalf("scanFloatArray(%q) error = %v, encoding/json error = %v", s, scanErr, jsonErr)
+		}
+		if scanErr != nil {
+			return
+		}
+		if len(got) != len(want) {
+			t.Fatalf("scanFloatArray(%q) = %v, encoding/json = %v", s, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("scanFloatArray(%q)[%d] = %v, encoding/json = %v", s, i, got[i], want[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// policyStoragePath is the Vault storage path for the key policy.
+const policyStoragePath = "config/policy"
+
+// keyPolicy holds floors on the parameters of every key the mount
+// installs. It lets a security team, through an ACL on config/policy,
+// bound what operators may pass to config/rotate. The zero policy, bar
+// AllowZeroNoise, restricts nothing.
+type keyPolicy struct {
+	// MinApproximationFactor is the smallest approximation_factor (β) a key
+	// may have.
+	MinApproximationFactor float64 `json:"min_approximation_factor"`
+
+	// MinDimension is the smallest dimension a key may have.
+	MinDimension int `json:"min_dimension"`
+
+	// AllowZeroNoise permits keys whose noise radius is zero, which encrypt
+	// deterministically.
+	AllowZeroNoise bool `json:"allow_zero_noise"`
+}
+
+// defaultKeyPolicy returns the policy of a mount that has none.
+func defaultKeyPolicy() *keyPolicy {
+	return &keyPolicy{AllowZeroNoise: true}
+}
+
+// validate checks the policy itself.
+func (p *keyPolicy) validate() error {
+	if p.MinApproximationFactor < 0 || math.IsNaN(p.MinApproximationFactor) || math.IsInf(p.MinApproximationFactor, 0) {
+		return fmt.Errorf("min_approximation_factor must be a non-negative finite number (got %v)", p.MinApproximationFactor)
+	}
+	if p.MinDimension < 0 || p.MinDimension > MaxDimension {
+		return fmt.Errorf("min_dimension must be between 0 and %d (got %d)", MaxDimension, p.MinDimension)
+	}
+	return nil
+}
+
+// check returns an error if the key cfg violates the policy.
+func (p *keyPolicy) check(cfg *rotationConfig) error {
+	if cfg.ApproximationFactor < p.MinApproximationFactor {
+		return fmt.Errorf("config/policy: approximation_factor %v is below min_approximation_factor %v",
+			cfg.ApproximationFactor, p.MinApproximationFactor)
+	}
+	if cfg.Dimension < p.MinDimension {
+		return fmt.Errorf("config/policy: dimension %d is below min_dimension %d", cfg.Dimension, p.MinDimension)
+	}
+	if !p.AllowZeroNoise && cfg.noiseRadius() == 0 {
+		return fmt.Errorf("config/policy: zero-noise keys are not allowed; set approximation_factor or min_noise_radius")
+	}
+	return nil
+}
+
+// responseData renders the policy for API responses.
+func (p *keyPolicy) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"min_approximation_factor": p.MinApproximationFactor,
+		"min_dimension":            p.MinDimension,
+		"allow_zero_noise":         p.AllowZeroNoise,
+	}
+}
+
+// pathPolicy returns the path configuration for config/policy.
+func (b *vectorBackend) pathPolicy() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/policy",
+			Fields: map[string]*framework.FieldSchema{
+				"min_approximation_factor": {
+					Type:        framework.TypeFloat,
+					Description: "Smallest approximation_factor (β) a new key may have (0 sets no floor).",
+				},
+				"min_dimension": {
+					Type:        framework.TypeInt,
+					Description: "Smallest dimension a new key may have (0 sets no floor).",
+				},
+				"allow_zero_noise": {
+					Type:        framework.TypeBool,
+					Description: "Allow keys with a zero noise radius, which encrypt deterministically. Default: true.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handlePolicyRead,
+					Summary:  "Read the key policy.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handlePolicyWrite,
+					Summary:  "Update the key policy.",
+				},
+			},
+			HelpSynopsis:    pathPolicyHelpSyn,
+			HelpDescription: pathPolicyHelpDesc,
+		},
+	}
+}
+
+// handlePolicyRead returns the effective key policy.
+func (b *vectorBackend) handlePolicyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	policy, err := readKeyPolicy(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: policy.responseData(),
+	}, nil
+}
+
+// handlePolicyWrite merges the supplied fields into the stored policy.
+// Fields that are not supplied keep their current value.
+func (b *vectorBackend) handlePolicyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy, err := readKeyPolicy(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := policy.responseData()
+
+	if raw, ok := data.GetOk("min_approximation_factor"); ok {
+		minFactor, err := coerceFloat(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_approximation_factor: %w", err)
+		}
+		policy.MinApproximationFactor = minFactor
+	}
+	if raw, ok := data.GetOk("min_dimension"); ok {
+		policy.MinDimension = raw.(int)
+	}
+	if raw, ok := data.GetOk("allow_zero_noise"); ok {
+		policy.AllowZeroNoise = raw.(bool)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	// As with the strict profile, the current key must already comply;
+	// otherwise rotate first.
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		if err := policy.check(cfg); err != nil {
+			return nil, fmt.Errorf("current key does not comply, rotate it first: %w", err)
+		}
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, policyStoragePath, policy); err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "policy", before, policy.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: policy.responseData(),
+	}, nil
+}
+
+// readKeyPolicy retrieves and validates the key policy from storage,
+// falling back to the default.
+func readKeyPolicy(ctx context.Context, storage logical.Storage) (*keyPolicy, error) {
+	policy := defaultKeyPolicy()
+	if _, err := getStorageJSON(ctx, storage, policyStoragePath, policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("stored policy is invalid: %w", err)
+	}
+	return policy, nil
+}
+
+// checkKeyPolicy returns an error if the key cfg violates the mount's key
+// policy or, under the strict hardening profile, the profile's rules.
+func checkKeyPolicy(ctx context.Context, storage logical.Storage, settings *mountSettings, cfg *rotationConfig) error {
+	if settings.strict() {
+		if err := checkStrictConfig(cfg); err != nil {
+			return err
+		}
+	}
+	policy, err := readKeyPolicy(ctx, storage)
+	if err != nil {
+		return err
+	}
+	return policy.check(cfg)
+}
+
+// Help text constants for the policy endpoint.
+const pathPolicyHelpSyn = `Configure floors on the parameters of new keys.`
+
+const pathPolicyHelpDesc = `
+Sets floors that config/rotate, config/import, config/ceremony and the
+re-key of config/compromise check every new key against, so that operators
+who may rotate cannot silently configure weak or noise-free encryption.
+Grant write access to this path separately from config/rotate.
+
+The policy can only be tightened while the current key complies; otherwise
+rotate with compliant parameters first. Keys already in the keyring are
+kept, but encrypt requests can no longer pin one that fails the policy or
+the hardening profile with key_version; rewrap/vector still migrates its
+ciphertexts.
+
+Parameters:
+  min_approximation_factor - Smallest approximation_factor (β) (default: 0).
+  min_dimension            - Smallest dimension (default: 0).
+  allow_zero_noise         - Allow keys whose noise radius is zero, which
+                             encrypt deterministically (default: true).
+`
@@ -0,0 +1,88 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	write := func(path string, data map[string]interface{}) error {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/policy", nil)
+	if resp.Data["min_approximation_factor"] != 0.0 || resp.Data["min_dimension"] != 0 || resp.Data["allow_zero_noise"] != true {
+		t.Fatalf("default policy = %v", resp.Data)
+	}
+
+	// A zero-noise key cannot be kept while the policy forbids it.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	if err := write("config/policy", map[string]interface{}{"allow_zero_noise": false}); err == nil {
+		t.Fatal("expected the policy to be refused while the current key violates it")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 2.0,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"min_approximation_factor": 1.0,
+		"min_dimension":            testDimension,
+		"allow_zero_noise":         false,
+	})
+	if resp.Data["min_approximation_factor"] != 1.0 || resp.Data["min_dimension"] != testDimension || resp.Data["allow_zero_noise"] != false {
+		t.Fatalf("policy = %v", resp.Data)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"low approximation_factor": {"dimension": testDimension, "approximation_factor": 0.5},
+		"low dimension":            {"dimension": testDimension / 2, "approximation_factor": 2.0},
+		"zero noise":               {"dimension": testDimension, "approximation_factor": 0.0},
+	} {
+		if err := write("config/rotate", data); err == nil {
+			t.Errorf("%s: expected config/rotate to be refused", name)
+		}
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            2 * testDimension,
+		"approximation_factor": 1.0,
+	})
+
+	// The floor on β is separate from the zero-noise rule: min_noise_radius
+	// alone satisfies allow_zero_noise=false.
+	testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"min_approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	})
+
+	for _, data := range []map[string]interface{}{
+		{"min_approximation_factor": -1.0},
+		{"min_dimension": MaxDimension + 1},
+	} {
+		if err := write("config/policy", data); err == nil {
+			t.Errorf("%v: expected an error", data)
+		}
+	}
+}
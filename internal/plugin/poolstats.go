@@ -0,0 +1,209 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// poolMetricPrefix is the metric name prefix for buffer pool statistics.
+var poolMetricPrefix = []string{"vector_dpe", "pool"}
+
+// poolStats tracks buffer pool efficiency. Counters only advance while
+// enabled, which mirrors the pool_stats mount setting.
+type poolStats struct {
+	enabled atomic.Bool
+
+	// borrows counts buffers taken from the pool.
+	borrows atomic.Uint64
+	// misses counts borrows that found the pool empty and allocated.
+	misses atomic.Uint64
+	// grows counts borrowed buffers that were too small and reallocated.
+	grows atomic.Uint64
+	// allocatedBytes is the total size of buffers allocated by misses and grows.
+	allocatedBytes atomic.Uint64
+	// requests counts encrypt requests served while enabled.
+	requests atomic.Uint64
+	// maxCapacity is the largest buffer capacity (in elements) returned to the pool.
+	maxCapacity atomic.Int64
+}
+
+// recordMiss records an empty pool.
+func (s *poolStats) recordMiss() {
+	if !s.enabled.Load() {
+		return
+	}
+	s.misses.Add(1)
+	metrics.IncrCounter(append(poolMetricPrefix, "miss"), 1)
+}
+
+// recordBorrow records a buffer taken from the pool.
+func (s *poolStats) recordBorrow() {
+	if !s.enabled.Load() {
+		return
+	}
+	s.borrows.Add(1)
+}
+
+// recordGrow records a buffer reallocated to capacity elements.
+func (s *poolStats) recordGrow(capacity int) {
+	if !s.enabled.Load() {
+		return
+	}
+	s.grows.Add(1)
+	s.allocatedBytes.Add(uint64(capacity) * 8)
+	metrics.IncrCounter(append(poolMetricPrefix, "grow"), 1)
+}
+
+// recordReturn records the capacity of a buffer handed back to the pool.
+func (s *poolStats) recordReturn(capacity int) {
+	if !s.enabled.Load() {
+		return
+	}
+	for {
+		cur := s.maxCapacity.Load()
+		if int64(capacity) <= cur || s.maxCapacity.CompareAndSwap(cur, int64(capacity)) {
+			break
+		}
+	}
+}
+
+// recordRequest records an encrypt request and emits the per-request gauges.
+func (s *poolStats) recordRequest() {
+	if !s.enabled.Load() {
+		return
+	}
+	requests := s.requests.Add(1)
+	metrics.IncrCounter(append(poolMetricPrefix, "request"), 1)
+	metrics.SetGauge(append(poolMetricPrefix, "allocs_per_request"),
+		float32(s.misses.Load()+s.grows.Load())/float32(requests))
+}
+
+// reset clears all counters.
+func (s *poolStats) reset() {
+	s.borrows.Store(0)
+	s.misses.Store(0)
+	s.grows.Store(0)
+	s.allocatedBytes.Store(0)
+	s.requests.Store(0)
+	s.maxCapacity.Store(0)
+}
+
+// responseData renders the statistics for API responses.
+func (s *poolStats) responseData() map[string]interface{} {
+	borrows := s.borrows.Load()
+	misses := s.misses.Load()
+	grows := s.grows.Load()
+	requests := s.requests.Load()
+
+	hitRatio := 0.0
+	if borrows > 0 {
+		hitRatio = float64(borrows-min(misses, borrows)) / float64(borrows)
+	}
+	allocsPerRequest, bytesPerRequest := 0.0, 0.0
+	if requests > 0 {
+		allocsPerRequest = float64(misses+grows) / float64(requests)
+		bytesPerRequest = float64(s.allocatedBytes.Load()) / float64(requests)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return map[string]interface{}{
+		"enabled":              s.enabled.Load(),
+		"borrows":              borrows,
+		"hits":                 borrows - min(misses, borrows),
+		"misses":               misses,
+		"grows":                grows,
+		"hit_ratio":            hitRatio,
+		"requests":             requests,
+		"allocated_bytes":      s.allocatedBytes.Load(),
+		"allocs_per_request":   allocsPerRequest,
+		"bytes_per_request":    bytesPerRequest,
+		"max_buffer_elements":  s.maxCapacity.Load(),
+		"gc_cycles":            mem.NumGC,
+		"gc_pause_total_ns":    mem.PauseTotalNs,
+		"heap_alloc_bytes":     mem.HeapAlloc,
+		"total_alloc_bytes":    mem.TotalAlloc,
+		"process_mallocs":      mem.Mallocs,
+		"process_frees":        mem.Frees,
+		"goroutines":           runtime.NumGoroutine(),
+		"heap_objects":         mem.HeapObjects,
+		"next_gc_target_bytes": mem.NextGC,
+	}
+}
+
+// pathStats returns the path configuration for stats/pool.
+func (b *vectorBackend) pathStats() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "stats/pool",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleStatsPoolRead,
+					Summary:  "Report buffer pool efficiency and GC pressure.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleStatsPoolReset,
+					Summary:  "Reset the buffer pool counters.",
+				},
+			},
+			HelpSynopsis:    pathStatsPoolHelpSyn,
+			HelpDescription: pathStatsPoolHelpDesc,
+		},
+	}
+}
+
+// handleStatsPoolRead returns the pool counters and a runtime memory snapshot.
+func (b *vectorBackend) handleStatsPoolRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	// Load settings so the enabled flag reflects storage on a fresh node.
+	if _, err := b.getSettings(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: b.poolStats.responseData(),
+	}, nil
+}
+
+// handleStatsPoolReset clears the pool counters on this node.
+func (b *vectorBackend) handleStatsPoolReset(context.Context, *logical.Request, *framework.FieldData) (*logical.Response, error) {
+	b.poolStats.reset()
+	return nil, nil
+}
+
+// Help text constants for the stats path.
+const pathStatsPoolHelpSyn = `Report buffer pool efficiency and GC pressure for this node.`
+
+const pathStatsPoolHelpDesc = `
+Reading this endpoint returns counters for the []float64 buffer pool used by
+the encrypt paths, plus a snapshot of the plugin process's Go runtime memory
+statistics. Deleting it resets the counters.
+
+Counters only advance while the pool_stats mount setting is true:
+  vault write vector/config/settings pool_stats=true
+
+Pool counters:
+  borrows / hits / misses - Buffers taken from the pool, and whether the pool
+                            had one ready
+  grows                   - Pooled buffers too small for the request that
+                            had to be reallocated
+  allocs_per_request      - (misses + grows) / requests; approaches 0 in
+                            steady state
+  bytes_per_request       - Buffer bytes allocated per request
+  max_buffer_elements     - Largest buffer returned to the pool
+
+Runtime snapshot:
+  gc_cycles, gc_pause_total_ns, heap_alloc_bytes, total_alloc_bytes,
+  heap_objects, goroutines, next_gc_target_bytes
+
+While enabled, the counters are also emitted to the go-metrics sink under
+vector_dpe.pool.* (miss, grow, request, allocs_per_request). Counters are per
+Vault node and reset when the plugin restarts.
+`
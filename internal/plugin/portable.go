@@ -0,0 +1,307 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	mathrand "math/rand/v2"
+	"runtime"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Keys with portable_arithmetic compute their matrix and rotations with
+// IEEE 754 basic operations and square roots only, in a fixed order, so
+// that every node derives a bit-identical matrix and, for the same
+// plaintext and noise, a bit-identical ciphertext, whatever its
+// architecture. gonum's kernels are assembly on some architectures and Go
+// on others, sum in different orders, and Go may fuse x*y+z into an FMA
+// instruction on arm64, ppc64 and s390x; math.Exp and math.Log, which
+// math/rand's normal sampler uses, have architecture-specific versions.
+// Every product below that feeds a sum is therefore wrapped in an explicit
+// float64 conversion, which the Go specification guarantees rounds, and so
+// prevents fusion.
+
+// matrixFingerprintLabel derives the key that verify/determinism MACs
+// matrices and probe ciphertexts with from a seed.
+const matrixFingerprintLabel = "vector-dpe/matrix-fingerprint/v1"
+
+// portableNormals draws standard normal variates from a CSPRNG by the
+// Marsaglia polar method, which needs only a logarithm and a square root.
+type portableNormals struct {
+	rng      *mathrand.Rand
+	spare    float64
+	hasSpare bool
+}
+
+// next returns the next variate.
+func (n *portableNormals) next() float64 {
+	if n.hasSpare {
+		n.hasSpare = false
+		return n.spare
+	}
+	for {
+		// Float64 divides a 53-bit integer by 2^53, exactly.
+		u := float64(2*n.rng.Float64()) - 1
+		v := float64(2*n.rng.Float64()) - 1
+		s := float64(u*u) + float64(v*v)
+		if s >= 1 || s == 0 {
+			continue
+		}
+		m := math.Sqrt(float64(-2*portableLog(s)) / s)
+		n.spare, n.hasSpare = float64(v*m), true
+		return float64(u * m)
+	}
+}
+
+// portableLog returns the natural logarithm of x, for 0 < x < +Inf. It is
+// the pure Go algorithm of the math package (from FreeBSD's e_log.c),
+// without fused operations.
+func portableLog(x float64) float64 {
+	const (
+		ln2Hi = 6.93147180369123816490e-01
+		ln2Lo = 1.90821492927058770002e-10
+		l1    = 6.666666666666735130e-01
+		l2    = 3.999999999940941908e-01
+		l3    = 2.857142874366239149e-01
+		l4    = 2.222219843214978396e-01
+		l5    = 1.818357216161805012e-01
+		l6    = 1.531383769920937332e-01
+		l7    = 1.479819860511658591e-01
+	)
+	f1, ki := math.Frexp(x)
+	if f1 < math.Sqrt2/2 {
+		f1 *= 2
+		ki--
+	}
+	f := f1 - 1
+	k := float64(ki)
+
+	s := f / (2 + f)
+	s2 := float64(s * s)
+	s4 := float64(s2 * s2)
+	t1 := float64(s2 * (l1 + float64(s4*(l3+float64(s4*(l5+float64(s4*l7)))))))
+	t2 := float64(s4 * (l2 + float64(s4*(l4+float64(s4*l6)))))
+	r := t1 + t2
+	hfsq := float64(0.5 * float64(f*f))
+	return float64(k*ln2Hi) - ((hfsq - (float64(s*(hfsq+r)) + float64(k*ln2Lo))) - f)
+}
+
+// portableDot returns the inner product of x and y, summed in order.
+func portableDot(x, y []float64) float64 {
+	var sum float64
+	for i := range x {
+		sum += float64(x[i] * y[i])
+	}
+	return sum
+}
+
+// applyReflector overwrites y with H·y, where H = I - 2·v·vᵀ/vv is the
+// Householder reflector of v and vv = vᵀv.
+func applyReflector(v []float64, vv float64, y []float64) {
+	f := float64(2*portableDot(v, y)) / vv
+	for i := range y {
+		y[i] -= float64(f * v[i])
+	}
+}
+
+// generatePortableMatrix generates the orthogonal matrix of a key with
+// portable_arithmetic: the Q factor of the Householder QR decomposition of
+// a Gaussian matrix, with its columns' signs chosen so that R has a
+// positive diagonal, which makes Q Haar-distributed. Like
+// generateOrthogonalMatrix, it leaves validation to the caller.
+func generatePortableMatrix(seed []byte, dim int) (*mat.Dense, error) {
+	rng, err := matrixRNG(seed, dim)
+	if err != nil {
+		return nil, err
+	}
+	normals := &portableNormals{rng: rng}
+
+	// Columns are kept contiguous, since reflectors are applied to them.
+	cols := make([][]float64, dim)
+	for j := range cols {
+		cols[j] = make([]float64, dim)
+	}
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			cols[j][i] = normals.next()
+		}
+	}
+	defer func() {
+		for _, col := range cols {
+			clear(col)
+		}
+	}()
+
+	reflectors := make([][]float64, dim)
+	norms := make([]float64, dim)
+	signs := make([]float64, dim)
+	for k := 0; k < dim; k++ {
+		x := cols[k][k:]
+		norm := math.Sqrt(portableDot(x, x))
+		signs[k] = 1
+		if norm == 0 {
+			continue
+		}
+		// R[k][k] = alpha; the column of Q is negated when it is negative.
+		alpha := -math.Copysign(norm, x[0])
+		signs[k] = math.Copysign(1, alpha)
+		v := append([]float64(nil), x...)
+		v[0] -= alpha
+		vv := portableDot(v, v)
+		if vv == 0 {
+			continue
+		}
+		for j := k + 1; j < dim; j++ {
+			applyReflector(v, vv, cols[j][k:])
+		}
+		reflectors[k], norms[k] = v, vv
+	}
+
+	// Q = H₀·H₁·…·H₍d₋₁₎·I, accumulated from the last reflector, which
+	// only touches the trailing block.
+	q := cols
+	for j := range q {
+		clear(q[j])
+		q[j][j] = 1
+	}
+	for k := dim - 1; k >= 0; k-- {
+		if reflectors[k] == nil {
+			continue
+		}
+		for j := k; j < dim; j++ {
+			applyReflector(reflectors[k], norms[k], q[j][k:])
+		}
+		clear(reflectors[k])
+	}
+
+	data := make([]float64, dim*dim)
+	for j, col := range q {
+		for i, x := range col {
+			data[i*dim+j] = float64(signs[j] * x)
+		}
+	}
+	return mat.NewDense(dim, dim, data), nil
+}
+
+// portableMulVec sets dst to m·x, summing each row in order.
+func portableMulVec(dst []float64, m *mat.Dense, x []float64) {
+	raw := m.RawMatrix()
+	for i := 0; i < raw.Rows; i++ {
+		dst[i] = portableDot(raw.Data[i*raw.Stride:i*raw.Stride+raw.Cols], x)
+	}
+}
+
+// determinismProbe returns the plaintext that verify/determinism encrypts
+// without noise: components spread over [-1, 1] that are exact in binary.
+func determinismProbe(dim int) []float64 {
+	probe := make([]float64, dim)
+	for i := range probe {
+		probe[i] = float64((i*37)%64-32) / 32
+	}
+	return probe
+}
+
+// writeFloats writes the IEEE 754 bits of values to h.
+func writeFloats(h hash.Hash, values []float64) {
+	var buf [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		h.Write(buf[:])
+	}
+}
+
+// pathDeterminism returns the path configuration for verify/determinism.
+func (b *vectorBackend) pathDeterminism() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "verify/determinism",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleVerifyDeterminism,
+					Summary:  "Report fingerprints of this node's matrix and of a probe ciphertext, to compare across nodes.",
+				},
+			},
+			HelpSynopsis:    pathDeterminismHelpSyn,
+			HelpDescription: pathDeterminismHelpDesc,
+		},
+	}
+}
+
+// handleVerifyDeterminism fingerprints the node's matrix of the current key
+// and its noiseless encryption of a fixed probe. The fingerprints are MACs
+// keyed from the seed, so they reveal nothing about the matrix.
+func (b *vectorBackend) handleVerifyDeterminism(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	matrix, cfg, err := b.getMatrixAndConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	key := deriveSeedKey(seed, matrixFingerprintLabel)
+	zeroBytes(seed)
+	defer zeroBytes(key)
+
+	mac := hmac.New(sha256.New, key)
+	raw := matrix.RawMatrix()
+	for i := 0; i < raw.Rows; i++ {
+		writeFloats(mac, raw.Data[i*raw.Stride:i*raw.Stride+raw.Cols])
+	}
+	matrixFingerprint := hex.EncodeToString(mac.Sum(nil)[:16])
+
+	// Under the default settings, without clipping or repeat tracking, so
+	// that only arithmetic can tell two nodes apart.
+	result, err := b.encrypt(matrix, cfg, defaultSettings(), determinismProbe(cfg.Dimension), encryptOptions{noiseless: true})
+	if err != nil {
+		return nil, err
+	}
+	mac.Reset()
+	writeFloats(mac, result.Ciphertext)
+	probeFingerprint := hex.EncodeToString(mac.Sum(nil)[:16])
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"portable_arithmetic": cfg.PortableArithmetic,
+			"key_version":         cfg.version(),
+			"arch":                runtime.GOARCH,
+			"go_version":          runtime.Version(),
+			"matrix_fingerprint":  matrixFingerprint,
+			"probe_fingerprint":   probeFingerprint,
+		},
+	}
+	if !cfg.PortableArithmetic {
+		addWarning(resp, warnNonPortableKey, "the key does not use portable_arithmetic: nodes of different architectures or builds may report different fingerprints")
+	}
+	return resp, nil
+}
+
+// Help text constants for the determinism path.
+const pathDeterminismHelpSyn = `Fingerprint this node's matrix and probe ciphertext, to compare across nodes.`
+
+const pathDeterminismHelpDesc = `
+Reports two fingerprints computed by the node serving the request:
+  matrix_fingerprint - of the current key's matrix, bit for bit
+  probe_fingerprint  - of the noiseless ciphertext of a fixed probe vector
+
+Both are MACs keyed from the seed and reveal nothing about the matrix.
+Read this path from every node of the cluster (performance standbys serve
+reads locally): with portable_arithmetic set on the key, every node must
+report the same fingerprints, whatever its architecture. Without it, nodes
+of different architectures, or with plugin binaries built by different Go
+or gonum versions, may not.
+
+The response also reports the node's arch and go_version.
+`
@@ -0,0 +1,139 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math"
+	mathrand "math/rand/v2"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestPortableLog(t *testing.T) {
+	rng := mathrand.New(mathrand.NewPCG(1, 2))
+	for n := 0; n < 10000; n++ {
+		x := rng.Float64()
+		if x == 0 {
+			continue
+		}
+		got, want := portableLog(x), math.Log(x)
+		// Both are the same algorithm; an architecture's math.Log may
+		// differ in the last bit.
+		if math.Abs(got-want) > 2*math.Abs(math.Nextafter(want, 0)-want) {
+			t.Fatalf("portableLog(%v) = %v, math.Log = %v", x, got, want)
+		}
+	}
+}
+
+func TestGeneratePortableMatrix(t *testing.T) {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	q, err := generatePortableMatrix(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateOrthogonality(q); err != nil {
+		t.Fatal(err)
+	}
+
+	// The golden digest pins the matrix bit for bit: it must come out the
+	// same on every architecture.
+	h := sha256.New()
+	writeFloats(h, q.RawMatrix().Data)
+	const golden = "aeca342b04d787ddc56c68978e901c70bf78239cd2187d4a513725d96b1dda46"
+	if got := hex.EncodeToString(h.Sum(nil)); got != golden {
+		t.Errorf("matrix digest = %s, want %s", got, golden)
+	}
+	probe := make([]float64, testDimension)
+	portableMulVec(probe, q, determinismProbe(testDimension))
+	h.Reset()
+	writeFloats(h, probe)
+	const goldenProbe = "d0df9cbdcd83494a300cc7e5c2d08f259922fc3af0d5d80e078b5c4c704b2bc1"
+	if got := hex.EncodeToString(h.Sum(nil)); got != goldenProbe {
+		t.Errorf("probe digest = %s, want %s", got, goldenProbe)
+	}
+
+	// Q is the Q factor of the Gaussian matrix with a positive diagonal in
+	// R = Qᵀ·A, which makes it Haar-distributed.
+	rng, err := matrixRNG(seed, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normals := &portableNormals{rng: rng}
+	a := mat.NewDense(testDimension, testDimension, nil)
+	for i := 0; i < testDimension; i++ {
+		for j := 0; j < testDimension; j++ {
+			a.Set(i, j, normals.next())
+		}
+	}
+	var r mat.Dense
+	r.Mul(q.T(), a)
+	for i := 0; i < testDimension; i++ {
+		if r.At(i, i) <= 0 {
+			t.Errorf("R[%d][%d] = %v, want positive", i, i, r.At(i, i))
+		}
+		for j := 0; j < i; j++ {
+			if math.Abs(r.At(i, j)) > 1e-9 {
+				t.Errorf("R[%d][%d] = %v, want 0", i, j, r.At(i, j))
+			}
+		}
+	}
+}
+
+func TestPortableArithmeticKey(t *testing.T) {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(255 - i)
+	}
+	fingerprints := func() map[string]interface{} {
+		b, s := getTestBackend(t)
+		resp := testRequest(t, b, s, logical.UpdateOperation, "config/import", map[string]interface{}{
+			"seed":                base64.StdEncoding.EncodeToString(seed),
+			"dimension":           testDimension,
+			"portable_arithmetic": true,
+		})
+		if resp.Data["portable_arithmetic"] != true {
+			t.Fatalf("portable_arithmetic = %v", resp.Data["portable_arithmetic"])
+		}
+		resp = testRequest(t, b, s, logical.ReadOperation, "verify/determinism", nil)
+		if len(resp.Warnings) != 0 {
+			t.Errorf("warnings for a portable key: %v", resp.Warnings)
+		}
+		return resp.Data
+	}
+	first, second := fingerprints(), fingerprints()
+	for _, field := range []string{"matrix_fingerprint", "probe_fingerprint"} {
+		if first[field] == "" || first[field] != second[field] {
+			t.Errorf("%s: %v and %v", field, first[field], second[field])
+		}
+	}
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/import", map[string]interface{}{
+		"seed":      base64.StdEncoding.EncodeToString(seed),
+		"dimension": testDimension,
+	})
+	native := testRequest(t, b, s, logical.ReadOperation, "verify/determinism", nil)
+	if native.Data["matrix_fingerprint"] == first["matrix_fingerprint"] || len(native.Warnings) == 0 {
+		t.Errorf("native key: %v, warnings %v", native.Data, native.Warnings)
+	}
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Data:      map[string]interface{}{"dimension": testDimension, "split": true, "portable_arithmetic": true},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("expected split with portable_arithmetic to be refused")
+	}
+}
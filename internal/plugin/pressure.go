@@ -0,0 +1,141 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimemetrics "runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// pressureSampleInterval is how long a resource sample is reused, so
+	// that busy mounts do not read cgroup files on every request.
+	pressureSampleInterval = time.Second
+
+	// heapObjectsMetric is the runtime metric of live and unswept heap
+	// objects, read without stopping the world.
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// cgroupRoot is where the process's cgroup filesystem is mounted. The
+// plugin runs as a child of Vault and normally shares its cgroup, so the
+// cgroup's usage includes Vault's own.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// shedMetricPrefix is the metric name of requests refused under pressure.
+var shedMetricPrefix = []string{"vector_dpe", "shed"}
+
+// pressureSample is a snapshot of the plugin process's resource use.
+type pressureSample struct {
+	at         time.Time
+	heapBytes  uint64
+	goroutines int
+
+	// cgroupUsed and cgroupLimit are the cgroup's memory use and limit in
+	// bytes; cgroupLimit is 0 without a cgroup limit.
+	cgroupUsed  uint64
+	cgroupLimit uint64
+}
+
+// pressureMonitor caches the latest pressureSample.
+type pressureMonitor struct {
+	mu   sync.Mutex
+	last pressureSample
+}
+
+// sample returns a resource sample at most pressureSampleInterval old.
+func (m *pressureMonitor) sample(now time.Time, withCgroup bool) pressureSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.last.at.IsZero() && now.Sub(m.last.at) < pressureSampleInterval && (!withCgroup || m.last.cgroupLimit > 0) {
+		return m.last
+	}
+	samples := []runtimemetrics.Sample{{Name: heapObjectsMetric}}
+	runtimemetrics.Read(samples)
+	s := pressureSample{at: now, goroutines: runtime.NumGoroutine()}
+	if samples[0].Value.Kind() == runtimemetrics.KindUint64 {
+		s.heapBytes = samples[0].Value.Uint64()
+	}
+	if withCgroup {
+		s.cgroupUsed, s.cgroupLimit = readCgroupMemory(cgroupRoot)
+	}
+	m.last = s
+	return s
+}
+
+// readCgroupMemory returns the memory use and limit of the cgroup mounted
+// at root, for cgroup v2 or v1. The limit is 0 when there is none or it
+// cannot be read.
+func readCgroupMemory(root string) (used, limit uint64) {
+	read := func(name string) (uint64, bool) {
+		raw, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return 0, false
+		}
+		v, err := strconv.ParseUint(string(bytes.TrimSpace(raw)), 10, 64)
+		return v, err == nil
+	}
+	if limit, ok := read("memory.max"); ok {
+		used, _ := read("memory.current")
+		return used, limit
+	}
+	if limit, ok := read("memory/memory.limit_in_bytes"); ok && limit < math.MaxInt64/2 {
+		// v1 reports "no limit" as a huge number rather than "max".
+		used, _ := read("memory/memory.usage_in_bytes")
+		return used, limit
+	}
+	return 0, 0
+}
+
+// shedding reports whether any load-shedding threshold is set.
+func (s *mountSettings) shedding() bool {
+	return s.ShedHeapBytes > 0 || s.ShedGoroutines > 0 || s.ShedCgroupMemoryPercent > 0
+}
+
+// pressureReason returns why the sample exceeds the settings' thresholds,
+// and a metric label for it, or "" when it does not.
+func (s *mountSettings) pressureReason(sample pressureSample) (reason, label string) {
+	switch {
+	case s.ShedHeapBytes > 0 && sample.heapBytes > uint64(s.ShedHeapBytes):
+		return fmt.Sprintf("heap of %d bytes exceeds shed_heap_bytes=%d", sample.heapBytes, s.ShedHeapBytes), "heap"
+	case s.ShedGoroutines > 0 && sample.goroutines > s.ShedGoroutines:
+		return fmt.Sprintf("%d goroutines exceed shed_goroutines=%d", sample.goroutines, s.ShedGoroutines), "goroutines"
+	case s.ShedCgroupMemoryPercent > 0 && sample.cgroupLimit > 0 &&
+		sample.cgroupUsed*100 > sample.cgroupLimit*uint64(s.ShedCgroupMemoryPercent):
+		return fmt.Sprintf("cgroup memory at %d of %d bytes exceeds shed_cgroup_memory_percent=%d",
+			sample.cgroupUsed, sample.cgroupLimit, s.ShedCgroupMemoryPercent), "cgroup_memory"
+	}
+	return "", ""
+}
+
+// checkPressure refuses low-priority batch traffic, with a retriable 503,
+// while the process is above a load-shedding threshold of the mount.
+// Single-vector requests are never shed.
+func (b *vectorBackend) checkPressure(settings *mountSettings, operation string) error {
+	if !settings.shedding() {
+		return nil
+	}
+	reason, label := settings.pressureReason(b.pressure.sample(time.Now(), settings.ShedCgroupMemoryPercent > 0))
+	if reason == "" {
+		return nil
+	}
+	metrics.IncrCounterWithLabels(shedMetricPrefix, 1, []metrics.Label{
+		{Name: "operation", Value: operation},
+		{Name: "reason", Value: label},
+	})
+	return logical.CodedError(http.StatusServiceUnavailable,
+		fmt.Sprintf("the plugin is under resource pressure (%s) and is shedding batch requests; retry later", reason))
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReadCgroupMemory(t *testing.T) {
+	write := func(t *testing.T, root, name, value string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("v2", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "memory.max", "1000")
+		write(t, root, "memory.current", "900")
+		if used, limit := readCgroupMemory(root); used != 900 || limit != 1000 {
+			t.Errorf("got %d of %d, want 900 of 1000", used, limit)
+		}
+	})
+	t.Run("v2 unlimited", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "memory.max", "max")
+		write(t, root, "memory.current", "900")
+		if _, limit := readCgroupMemory(root); limit != 0 {
+			t.Errorf("limit = %d, want 0", limit)
+		}
+	})
+	t.Run("v1", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "memory/memory.limit_in_bytes", "2000")
+		write(t, root, "memory/memory.usage_in_bytes", "500")
+		if used, limit := readCgroupMemory(root); used != 500 || limit != 2000 {
+			t.Errorf("got %d of %d, want 500 of 2000", used, limit)
+		}
+	})
+	t.Run("v1 unlimited", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "memory/memory.limit_in_bytes", "9223372036854771712")
+		if _, limit := readCgroupMemory(root); limit != 0 {
+			t.Errorf("limit = %d, want 0", limit)
+		}
+	})
+}
+
+func TestPressureReason(t *testing.T) {
+	settings := defaultSettings()
+	settings.ShedHeapBytes = 1000
+	settings.ShedGoroutines = 10
+	settings.ShedCgroupMemoryPercent = 90
+
+	for _, tc := range []struct {
+		name   string
+		sample pressureSample
+		label  string
+	}{
+		{"idle", pressureSample{heapBytes: 10, goroutines: 1, cgroupUsed: 10, cgroupLimit: 100}, ""},
+		{"heap", pressureSample{heapBytes: 1001}, "heap"},
+		{"goroutines", pressureSample{goroutines: 11}, "goroutines"},
+		{"cgroup", pressureSample{cgroupUsed: 91, cgroupLimit: 100}, "cgroup_memory"},
+		{"no cgroup limit", pressureSample{cgroupUsed: 91}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, label := settings.pressureReason(tc.sample)
+			if label != tc.label || (reason == "") != (tc.label == "") {
+				t.Errorf("got %q (%q), want label %q", reason, label, tc.label)
+			}
+		})
+	}
+}
+
+func TestPressureSampleCached(t *testing.T) {
+	var m pressureMonitor
+	now := time.Now()
+	first := m.sample(now, false)
+	if first.goroutines == 0 || first.heapBytes == 0 {
+		t.Fatalf("empty sample %+v", first)
+	}
+	if got := m.sample(now.Add(pressureSampleInterval/2), false); got.at != first.at {
+		t.Error("sample not reused within the interval")
+	}
+	if got := m.sample(now.Add(pressureSampleInterval), false); got.at == first.at {
+		t.Error("sample reused after the interval")
+	}
+}
+
+func TestBatchShedUnderPressure(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"shed_goroutines": 1,
+	})
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/batch",
+		Data:      map[string]interface{}{"vectors": []interface{}{testVector(0)}},
+		Storage:   s,
+	})
+	var coded logical.HTTPCodedError
+	if !errors.As(err, &coded) || coded.Code() != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want a 503", err)
+	}
+
+	// Single-vector requests are never shed.
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+}
+
+func TestSettingsShedValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/settings",
+		Data:      map[string]interface{}{"shed_cgroup_memory_percent": 101},
+		Storage:   s,
+	})
+	if err == nil && !resp.IsError() {
+		t.Error("shed_cgroup_memory_percent=101 accepted")
+	}
+}
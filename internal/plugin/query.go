@@ -0,0 +1,424 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxQuerySetSize bounds the vectors of one encrypt/queries request.
+const maxQuerySetSize = 64
+
+// pathQuery returns the path configuration for encrypt/query and
+// encrypt/queries.
+func (b *vectorBackend) pathQuery() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("encrypt/query"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"vector": {
+					Type:        framework.TypeSlice,
+					Description: "Query embedding to encrypt (array of floats).",
+					Required:    true,
+				},
+				"precision":          precisionField,
+				"encoding":           encodingField,
+				"model":              modelField,
+				"key_version":        keyVersionField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+				"all_versions": {
+					Type:        framework.TypeBool,
+					Description: "Encrypt the query under every key version, newest first, for vector databases with an index per version during a migration.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptQuery,
+					Summary:  "Encrypt a search query without noise (C = s * Q * v).",
+				},
+			},
+			HelpSynopsis:    pathQueryHelpSyn,
+			HelpDescription: pathQueryHelpDesc,
+		},
+		{
+			Pattern: withOptionalRole("encrypt/queries"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"vectors": {
+					Type:        framework.TypeSlice,
+					Description: fmt.Sprintf("Related query embeddings to encrypt under one noise draw (array of float arrays, at most %d).", maxQuerySetSize),
+					Required:    true,
+				},
+				"precision":          precisionField,
+				"encoding":           encodingField,
+				"model":              modelField,
+				"key_version":        keyVersionField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptQueries,
+					Summary:  "Encrypt a set of related queries under shared noise (C_i = s * Q * v_i + λ).",
+				},
+			},
+			HelpSynopsis:    pathQueriesHelpSyn,
+			HelpDescription: pathQueriesHelpDesc,
+		},
+	}
+}
+
+// handleEncryptQuery encrypts a query vector deterministically: the
+// rotation and scaling of encrypt/vector, without the perturbation λ.
+func (b *vectorBackend) handleEncryptQuery(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationQuery); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return nil, fmt.Errorf("hardening_profile=strict: noiseless query encryption is not allowed")
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+
+	vectorBufPtr := b.borrowFloats()
+	defer b.returnFloats(vectorBufPtr)
+	vector, err := parseVectorInto(*vectorBufPtr, data.Get("vector"))
+	if err != nil {
+		return nil, err
+	}
+	b.adoptFloats(vectorBufPtr, vector)
+
+	if data.Get("all_versions").(bool) {
+		return b.encryptQueryAllVersions(ctx, req, data, role, settings, vector, precision, encoding)
+	}
+
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, err
+	}
+	matrix, cfg, derivationContext, err := b.matrixForRequest(ctx, req, role, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	versionWarnings, err := b.keyVersionWarnings(ctx, req.Storage, cfg, data.Get("key_version").(int))
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	scheme := newSchemeParams(cfg, keyID)
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationQuery, 1)
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector query encryption request",
+		"dimension", cfg.Dimension,
+		"client_id", req.ClientToken)
+
+	result, err := b.encrypt(matrix, cfg, settings, vector, encryptOptions{coalesce: true, noiseless: true})
+	if err != nil {
+		return nil, err
+	}
+	roundToPrecision(result.Ciphertext, precision)
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"ciphertext": encodeCiphertext(result.Ciphertext, encoding, precision),
+		},
+	}
+	scheme.addTo(resp.Data)
+	addRequestContext(resp.Data, requestContext, keyID)
+	if result.Clipped > 0 {
+		resp.Data["clipped_components"] = result.Clipped
+	}
+	result.Outliers.addTo(resp.Data)
+	addWarnings(resp, versionWarnings)
+	addWarnings(resp, result.warnings(settings))
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// encryptQueryAllVersions is handleEncryptQuery with all_versions: it
+// encrypts vector under each key version that can answer it, newest first.
+// Each version's ciphertext comes with its distance_scale, s_newest / s_v,
+// by which distances in that version's index are multiplied to merge them
+// with the newest version's results.
+func (b *vectorBackend) encryptQueryAllVersions(ctx context.Context, req *logical.Request, data *framework.FieldData, role *vectorRole, settings *mountSettings, vector []float64, precision, encoding string) (*logical.Response, error) {
+	switch {
+	case data.Get("key_version").(int) != 0:
+		return nil, fmt.Errorf("all_versions and key_version are mutually exclusive")
+	case data.Get("context").(string) != "" || data.Get("derivation_context").(string) != "":
+		return nil, fmt.Errorf("all_versions is not supported with a context")
+	}
+	fanOut, err := b.fanOutVersions(ctx, req, role, data.Get("model").(string), len(vector))
+	if err != nil {
+		return nil, err
+	}
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationQuery, len(fanOut))
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector query encryption request",
+		"dimension", len(vector),
+		"key_versions", len(fanOut),
+		"client_id", req.ClientToken)
+
+	newest := fanOut[0].cfg
+	versions := make([]map[string]interface{}, len(fanOut))
+	var warnings []typedWarning
+	for i, v := range fanOut {
+		result, err := b.encrypt(v.matrix, v.cfg, settings, vector, encryptOptions{coalesce: true, noiseless: true})
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", v.cfg.version(), err)
+		}
+		roundToPrecision(result.Ciphertext, precision)
+		item := map[string]interface{}{
+			"ciphertext":     encodeCiphertext(result.Ciphertext, encoding, precision),
+			"distance_scale": newest.ScalingFactor / v.cfg.ScalingFactor,
+		}
+		newSchemeParams(v.cfg, v.keyID).addTo(item)
+		if result.Clipped > 0 {
+			item["clipped_components"] = result.Clipped
+		}
+		result.Outliers.addTo(item)
+		role.filterResponse(&logical.Response{Data: item})
+		versions[i] = item
+		for _, w := range result.warnings(settings) {
+			warnings = append(warnings, w.forItem(i))
+		}
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"versions": versions,
+		},
+	}
+	addWarnings(resp, warnings)
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// handleEncryptQueries encrypts a set of related queries, such as a query
+// and its expansions, with one perturbation λ drawn for the whole set.
+// Differences within the set are exact, C_i - C_j = s * Q * (v_i - v_j),
+// while every ciphertext stays perturbed relative to the corpus.
+func (b *vectorBackend) handleEncryptQueries(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationQuery); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkPressure(settings, operationQuery); err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return nil, fmt.Errorf("hardening_profile=strict: shared-noise query encryption is not allowed")
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := parseVectorList(data.Get("vectors"), maxQuerySetSize)
+	if err != nil {
+		return nil, err
+	}
+
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, err
+	}
+	matrix, cfg, derivationContext, err := b.matrixForRequest(ctx, req, role, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	versionWarnings, err := b.keyVersionWarnings(ctx, req.Storage, cfg, data.Get("key_version").(int))
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	scheme := newSchemeParams(cfg, keyID)
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationQuery, len(vectors))
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector query set encryption request",
+		"dimension", cfg.Dimension,
+		"queries", len(vectors),
+		"client_id", req.ClientToken)
+
+	noise := make([]float64, cfg.Dimension)
+	defer clear(noise)
+	if err := b.generateNoise(matrix, cfg, noise); err != nil {
+		return nil, fmt.Errorf("failed to generate noise: %w", err)
+	}
+
+	ciphertexts := make([]interface{}, len(vectors))
+	clipped, outliers := 0, 0
+	warnings := versionWarnings
+	for i, vector := range vectors {
+		result, err := b.encrypt(matrix, cfg, settings, vector, encryptOptions{noise: noise})
+		if err != nil {
+			return nil, fmt.Errorf("vector %d: %w", i, err)
+		}
+		roundToPrecision(result.Ciphertext, precision)
+		ciphertexts[i] = encodeCiphertext(result.Ciphertext, encoding, precision)
+		clipped += result.Clipped
+		outliers += result.Outliers.Components
+		for _, w := range result.warnings(settings) {
+			warnings = append(warnings, w.forItem(i))
+		}
+	}
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"ciphertexts": ciphertexts,
+		},
+	}
+	scheme.addTo(resp.Data)
+	addRequestContext(resp.Data, requestContext, keyID)
+	if clipped > 0 {
+		resp.Data["clipped_components"] = clipped
+	}
+	if outliers > 0 {
+		resp.Data["outlier_components"] = outliers
+	}
+	addWarnings(resp, warnings)
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// Help text constants for the query endpoint.
+const pathQueryHelpSyn = `Encrypt a search query without noise.`
+
+const pathQueryHelpDesc = `
+Encrypts a query vector with the rotation and scaling of encrypt/vector but
+without the perturbation: C = s * Q * v. Distances between a query encrypted
+here and documents encrypted by encrypt/vector carry the documents' noise
+only, which improves recall over noisy queries.
+
+Query ciphertexts are deterministic: the same query under the same key
+always yields the same ciphertext, so anyone who sees two of them can tell
+whether the queries were equal, and a query ciphertext reveals s * Q * v
+exactly. Use this endpoint for queries only, never for vectors that are
+stored, and keep query ciphertexts out of logs and storage. The strict
+hardening profile refuses it.
+
+Requests under a role use the role's key, and require the 'query'
+operation in the role's allowed_operations.
+
+Parameters:
+  vector      - Query embedding to encrypt.
+  precision   - Output rounding (default: the mount's output_precision).
+  encoding    - 'array' (default) or 'base64' of the packed components.
+  model       - Embedding model of the query, checked against the key's.
+  key_version - Encrypt under an older key version (mount key only).
+  context     - Encrypt under the key of a context, as encrypt/vector.
+  all_versions - Encrypt under every key version instead (see below).
+
+While a vector database is migrated to a new key with an index per key
+version, a query must search every index. With all_versions=true, the
+response holds 'versions' instead of a single ciphertext: one entry per
+key version of the query's model and dimension, newest first, with its
+ciphertext, key_version and scheme parameters, and a distance_scale.
+Multiply the distances returned by each version's index by its
+distance_scale to put them on the newest version's scale, then merge.
+Like key_version, it is available for the mount key only, and every
+version must pass the hardening profile and config/policy.
+`
+
+// Help text constants for the query set endpoint.
+const pathQueriesHelpSyn = `Encrypt a set of related queries under one shared noise draw.`
+
+const pathQueriesHelpDesc = `
+Encrypts a set of related query vectors, such as a query and its
+expansions, with one perturbation drawn for the whole set:
+C_i = s * Q * v_i + λ. The set's internal geometry is preserved exactly,
+C_i - C_j = s * Q * (v_i - v_j), so expansions keep their relation to the
+query, while every ciphertext is still perturbed relative to the corpus.
+Independent noise per expansion, as from separate encrypt/vector calls,
+scatters the set and degrades query-expansion retrieval.
+
+The shared noise reveals s * Q * (v_i - v_j) exactly for every pair in the
+set. Use it for queries only, never for vectors that are stored, and keep
+the ciphertexts out of logs and storage. The strict hardening profile
+refuses it.
+
+Requests under a role use the role's key, and require the 'query'
+operation in the role's allowed_operations.
+
+Parameters:
+  vectors     - Query embeddings (at most 64).
+  precision   - Output rounding (default: the mount's output_precision).
+  encoding    - 'array' (default) or 'base64' of the packed components.
+  model       - Embedding model of the queries, checked against the key's.
+  key_version - Encrypt under an older key version (mount key only).
+  context     - Encrypt under the key of a context, as encrypt/vector.
+
+Output:
+  ciphertexts - One ciphertext per vector, in input order.
+`
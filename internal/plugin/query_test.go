@@ -0,0 +1,240 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestEncryptQuery(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       2.0,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	})
+
+	first := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	second := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	query := first.Data["ciphertext"].([]float64)
+	if !equalFloats(query, second.Data["ciphertext"].([]float64)) {
+		t.Error("query encryption is not deterministic")
+	}
+	if first.Data["transform_id"] == nil {
+		t.Error("query response lacks the scheme parameters")
+	}
+
+	// Rotation and scaling preserve norms exactly: ||C|| = s ||v||.
+	var vNorm, cNorm float64
+	for i, v := range testVector(1) {
+		vNorm += v.(float64) * v.(float64)
+		cNorm += query[i] * query[i]
+	}
+	if math.Abs(math.Sqrt(cNorm)-2*math.Sqrt(vNorm)) > 1e-9 {
+		t.Errorf("||C|| = %v, want s·||v|| = %v", math.Sqrt(cNorm), 2*math.Sqrt(vNorm))
+	}
+
+	// A document encryption of the same vector is within the noise radius.
+	doc := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if d := euclideanDistance(query, doc.Data["ciphertext"].([]float64)); d > 0.5+1e-9 {
+		t.Errorf("document is %v from the noiseless query, want at most the noise radius 0.5", d)
+	}
+}
+
+func TestEncryptQueryRefusals(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"allowed_operations": "encrypt",
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/query/partners", map[string]interface{}{
+		"vector": testVector(0),
+	}); err == nil {
+		t.Error("encrypted a query under a role without the query operation")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(0),
+	}); err == nil {
+		t.Error("strict profile allowed noiseless query encryption")
+	}
+}
+
+func TestEncryptQueryPrecision(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 3.0,
+	})
+
+	// Query encryption is deterministic, so the two precisions of one
+	// vector can be compared directly.
+	full := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(2),
+	}).Data["ciphertext"].([]float64)
+	rounded := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector":    testVector(2),
+		"precision": precisionFloat32,
+	}).Data["ciphertext"].([]float64)
+
+	var norm float64
+	for i, c := range full {
+		if rounded[i] != float64(float32(c)) {
+			t.Errorf("component %d = %v, want %v rounded to float32", i, rounded[i], c)
+		}
+		norm += c * c
+	}
+	// The documented bound: ||C32 - C|| <= 2^-24 ||C||.
+	if d := euclideanDistance(full, rounded); d > math.Ldexp(math.Sqrt(norm), -24) {
+		t.Errorf("rounding moved the ciphertext by %v, more than 2^-24·||C||", d)
+	}
+}
+
+func TestEncryptQueries(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       2.0,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	})
+
+	vectors := []interface{}{testVector(1), testVector(2), testVector(3)}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/queries", map[string]interface{}{
+		"vectors": vectors,
+	})
+	ciphertexts := resp.Data["ciphertexts"].([]interface{})
+	if len(ciphertexts) != len(vectors) || resp.Data["transform_id"] == nil {
+		t.Fatalf("response = %v", resp.Data)
+	}
+
+	// Each ciphertext is its noiseless query plus the same λ, so the set's
+	// differences are exact.
+	var noise []float64
+	for i, v := range vectors {
+		query := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+			"vector": v,
+		}).Data["ciphertext"].([]float64)
+		c := ciphertexts[i].([]float64)
+		diff := make([]float64, len(c))
+		for j := range c {
+			diff[j] = c[j] - query[j]
+		}
+		if noise == nil {
+			noise = diff
+			var norm float64
+			for _, x := range noise {
+				norm += x * x
+			}
+			if norm == 0 || math.Sqrt(norm) > 0.5+1e-9 {
+				t.Fatalf("shared noise has norm %v, want within (0, 0.5]", math.Sqrt(norm))
+			}
+			continue
+		}
+		if d := euclideanDistance(diff, noise); d > 1e-9 {
+			t.Errorf("vector %d carries different noise (off by %v)", i, d)
+		}
+	}
+
+	// A second set draws fresh noise.
+	again := testRequest(t, b, s, logical.UpdateOperation, "encrypt/queries", map[string]interface{}{
+		"vectors": vectors[:1],
+	}).Data["ciphertexts"].([]interface{})
+	if equalFloats(again[0].([]float64), ciphertexts[0].([]float64)) {
+		t.Error("two sets share noise")
+	}
+
+	tooMany := make([]interface{}, maxQuerySetSize+1)
+	for i := range tooMany {
+		tooMany[i] = testVector(float64(i))
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/queries", map[string]interface{}{
+		"vectors": tooMany,
+	}); err == nil {
+		t.Errorf("encrypted a set of %d queries", len(tooMany))
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": "strict",
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/queries", map[string]interface{}{
+		"vectors": vectors,
+	}); err == nil {
+		t.Error("strict hardening profile allowed shared-noise query encryption")
+	}
+}
+
+func TestEncryptQueryAllVersions(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"scaling_factor": 4.0,
+	})
+	pinned := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 1,
+	}).Data["ciphertext"].([]float64)
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector":       testVector(1),
+		"all_versions": true,
+	})
+	versions := resp.Data["versions"].([]map[string]interface{})
+	if len(versions) != 2 || versions[0]["key_version"] != 2 || versions[1]["key_version"] != 1 {
+		t.Fatalf("versions = %v, want versions 2 and 1", versions)
+	}
+	if versions[0]["distance_scale"] != 1.0 || versions[1]["distance_scale"] != 4.0 {
+		t.Errorf("distance scales = %v, %v; want 1 and s_2 / s_1 = 4", versions[0]["distance_scale"], versions[1]["distance_scale"])
+	}
+	if !equalFloats(versions[1]["ciphertext"].([]float64), pinned) {
+		t.Error("version 1 ciphertext differs from the query pinned to version 1")
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector":       testVector(1),
+		"all_versions": true,
+		"key_version":  1,
+	}); err == nil {
+		t.Error("accepted all_versions with key_version")
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"hidden_fields": "key_version",
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/query/partners", map[string]interface{}{
+		"vector":       testVector(1),
+		"all_versions": true,
+	}); err == nil {
+		t.Error("fanned out under a role that hides key_version")
+	}
+
+	// Every version must pass the key policy, as when pinned.
+	testRequest(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"allow_zero_noise": false,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector":       testVector(1),
+		"all_versions": true,
+	}); err == nil {
+		t.Error("fanned out to a version below the key policy")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// contentTypeOctetStream is the media type of packed binary frames.
+	contentTypeOctetStream = "application/octet-stream"
+
+	// maxRawFrameVectors bounds the number of vectors in a single raw frame.
+	maxRawFrameVectors = 4096
+)
+
+// pathRaw returns the path configuration for encrypt/raw.
+func (b *vectorBackend) pathRaw() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("encrypt/raw"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"frame": {
+					Type:        framework.TypeString,
+					Description: "Base64-encoded frame of N vectors packed as little-endian float32.",
+				},
+				"model": modelField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptRaw,
+					Summary:  "Encrypt a packed float32 frame and return a packed frame.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleEncryptRaw,
+					Summary:  "Encrypt a packed float32 frame and return a packed frame.",
+				},
+			},
+			ExistenceCheck:  b.encryptExists,
+			HelpSynopsis:    pathRawHelpSyn,
+			HelpDescription: pathRawHelpDesc,
+		},
+	}
+}
+
+// handleEncryptRaw encrypts every vector of a packed float32 frame and returns
+// the ciphertexts as a raw application/octet-stream body in the same layout.
+// Unlike encrypt/batch, any failing vector fails the whole frame, since a
+// packed frame has no room for per-item errors.
+func (b *vectorBackend) handleEncryptRaw(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	// Panic Safety: Recover from panics (e.g., gonum matrix math or memory issues).
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationRaw); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatRaw); err != nil {
+		return nil, err
+	}
+
+	encoded := data.Get("frame").(string)
+	if encoded == "" {
+		return nil, fmt.Errorf("frame is required")
+	}
+
+	matrix, cfg, err := b.matrixForRole(ctx, req, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	scheme, err := b.requestSchemeParams(req, role, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkPressure(settings, operationRaw); err != nil {
+		return nil, err
+	}
+
+	maxEncoded := base64.StdEncoding.EncodedLen(maxRawFrameVectors * cfg.Dimension * float32Size)
+	if len(encoded) > maxEncoded {
+		return nil, fmt.Errorf("frame exceeds maximum of %d vectors", maxRawFrameVectors)
+	}
+
+	frame, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+	vectors, err := unpackFloat32Frame(frame, cfg.Dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationRaw, len(vectors))
+
+	// Audit Logging: Log request metadata (NOT the vector content).
+	b.Logger().Info("vector raw frame encryption request",
+		"dimension", cfg.Dimension,
+		"batch_size", len(vectors),
+		"client_id", req.ClientToken)
+
+	// The vectors are encrypted on up to parallelism goroutines and packed
+	// in frame order.
+	encrypted := make([]*encryptResult, len(vectors))
+	errs := make([]error, len(vectors))
+	parallelItems(len(vectors), settings.workers(), func(_, i int) {
+		encrypted[i], errs[i] = b.encryptVector(matrix, cfg, settings, vectors[i])
+	})
+	out := make([]byte, 0, len(frame))
+	for i, result := range encrypted {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("vector %d: %w", i, errs[i])
+		}
+		out = packFloat32(out, result.Ciphertext)
+	}
+
+	headers := scheme.headers()
+	role.filterHeaders(headers)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentTypeOctetStream,
+			logical.HTTPRawBody:     out,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+		Headers: headers,
+	}, nil
+}
+
+// Help text constants for the raw path.
+const pathRawHelpSyn = `Encrypt a packed float32 frame of vectors, bypassing JSON arrays.`
+
+const pathRawHelpDesc = `
+This endpoint is intended for bulk migrations where JSON float arrays
+dominate the cost. It accepts N vectors packed back to back as little-endian
+IEEE 754 float32 values (N * dimension * 4 bytes), base64-encoded in the
+'frame' field, and returns the ciphertexts as a raw application/octet-stream
+body in the same layout.
+
+Requests are authenticated and authorized by Vault like any other path.
+Vault core decodes request bodies as JSON before they reach the plugin, so
+the input frame is base64-encoded; the response body is raw bytes.
+
+Limits:
+  At most 4096 vectors per frame. Any invalid vector fails the whole frame,
+  with the failing vector index in the error.
+
+Ciphertext components are rounded to float32.
+
+Example:
+  base64 -w0 vectors.f32 | jq -Rs '{frame: .}' | \
+    curl -s -H "X-Vault-Token: $VAULT_TOKEN" -d @- \
+      $VAULT_ADDR/v1/vector/encrypt/raw -o ciphertexts.f32
+`
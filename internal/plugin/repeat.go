@@ -0,0 +1,79 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+const (
+	// sketchDepth is the number of hash rows in the count-min sketch.
+	sketchDepth = 4
+
+	// sketchWidth is the number of counters per row.
+	// 4 rows * 16384 counters * 4 bytes = 256KB regardless of traffic.
+	sketchWidth = 1 << 14
+
+	// repeatHMACContext separates the fingerprint key from other uses of the seed.
+	repeatHMACContext = "vector-dpe/repeat-tracking/v1"
+)
+
+// countMinSketch is a fixed-size frequency estimator.
+// Estimates never undercount; collisions can only inflate them.
+type countMinSketch struct {
+	mu       sync.Mutex
+	counters [sketchDepth][sketchWidth]uint32
+}
+
+// add increments the counters for the fingerprint and returns the new estimate.
+func (s *countMinSketch) add(fingerprint [sha256.Size]byte) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := uint32(math.MaxUint32)
+	for row := 0; row < sketchDepth; row++ {
+		idx := binary.LittleEndian.Uint64(fingerprint[row*8:]) % sketchWidth
+		if s.counters[row][idx] < math.MaxUint32 {
+			s.counters[row][idx]++
+		}
+		if s.counters[row][idx] < estimate {
+			estimate = s.counters[row][idx]
+		}
+	}
+	return estimate
+}
+
+// reset clears all counters.
+func (s *countMinSketch) reset() {
+	s.mu.Lock()
+	s.counters = [sketchDepth][sketchWidth]uint32{}
+	s.mu.Unlock()
+}
+
+// fingerprintVector computes a keyed fingerprint of a plaintext vector.
+// The HMAC key is derived from the seed so fingerprints are unlinkable across
+// keys and reveal nothing about the plaintext without the seed.
+func fingerprintVector(seed []byte, vector []float64) [sha256.Size]byte {
+	key := deriveSeedKey(seed, repeatHMACContext)
+
+	mac := hmac.New(sha256.New, key)
+	var buf [8]byte
+	for _, v := range vector {
+		// Normalize -0 to +0 so numerically equal vectors share a fingerprint.
+		if v == 0 {
+			v = 0
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		mac.Write(buf[:])
+	}
+
+	var out [sha256.Size]byte
+	copy(out[:], mac.Sum(nil))
+	zeroBytes(key)
+	return out
+}
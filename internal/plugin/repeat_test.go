@@ -0,0 +1,52 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFingerprintVector(t *testing.T) {
+	seedA := make([]byte, 32)
+	seedB := make([]byte, 32)
+	seedB[0] = 1
+
+	v := []float64{0.1, -0.2, 0.3}
+
+	if fingerprintVector(seedA, v) != fingerprintVector(seedA, []float64{0.1, -0.2, 0.3}) {
+		t.Error("equal vectors under the same seed must share a fingerprint")
+	}
+	if fingerprintVector(seedA, v) == fingerprintVector(seedB, v) {
+		t.Error("fingerprints must differ across seeds")
+	}
+	if fingerprintVector(seedA, v) == fingerprintVector(seedA, []float64{0.1, -0.2, 0.30000001}) {
+		t.Error("different vectors must not share a fingerprint")
+	}
+	if fingerprintVector(seedA, []float64{0}) != fingerprintVector(seedA, []float64{math.Copysign(0, -1)}) {
+		t.Error("negative zero must fingerprint like positive zero")
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	var s countMinSketch
+	seed := make([]byte, 32)
+
+	fpA := fingerprintVector(seed, []float64{1, 2, 3})
+	fpB := fingerprintVector(seed, []float64{4, 5, 6})
+
+	for i := 1; i <= 5; i++ {
+		if got := s.add(fpA); got < uint32(i) {
+			t.Fatalf("add #%d returned %d; count-min must never undercount", i, got)
+		}
+	}
+	if got := s.add(fpB); got != 1 {
+		t.Errorf("first add of a distinct fingerprint = %d, want 1", got)
+	}
+
+	s.reset()
+	if got := s.add(fpA); got != 1 {
+		t.Errorf("add after reset = %d, want 1", got)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// pathRerandomize returns the path configuration for rerandomize/vector.
+func (b *vectorBackend) pathRerandomize() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("rerandomize/vector"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"ciphertext": {
+					Type:        framework.TypeSlice,
+					Description: "Ciphertext to re-randomize, produced under the current key (and the role's key, if any).",
+					Required:    true,
+				},
+				"precision":          precisionField,
+				"encoding":           encodingField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRerandomize,
+					Summary:  "Re-encrypt a ciphertext's plaintext with fresh noise, without changing the key.",
+				},
+			},
+			HelpSynopsis:    pathRerandomizeHelpSyn,
+			HelpDescription: pathRerandomizeHelpDesc,
+		},
+	}
+}
+
+// handleRerandomize decrypts a ciphertext internally and encrypts the result
+// again under the same key. The plaintext never leaves the plugin.
+func (b *vectorBackend) handleRerandomize(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationRerandomize); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawCiphertext := data.Get("ciphertext")
+	if settings.strict() && encoding == encodingArray {
+		if err := checkStrictVectorInput(rawCiphertext); err != nil {
+			return nil, err
+		}
+	}
+
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, err
+	}
+	derivationContext, err := b.requestDerivationContext(req, role, requestContext)
+	if err != nil {
+		return nil, err
+	}
+	matrix, cfg, err := b.matrixForContext(ctx, req, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	// A packed ciphertext's component width follows from the dimension.
+	ciphertextBufPtr := b.borrowFloats()
+	defer b.returnFloats(ciphertextBufPtr)
+	ciphertext, err := parseCiphertextInto(*ciphertextBufPtr, rawCiphertext, encoding, cfg.Dimension)
+	if err != nil {
+		return nil, err
+	}
+	b.adoptFloats(ciphertextBufPtr, ciphertext)
+	if len(ciphertext) != cfg.Dimension {
+		return nil, fmt.Errorf("ciphertext dimension %d does not match configured dimension %d",
+			len(ciphertext), cfg.Dimension)
+	}
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	scheme := newSchemeParams(cfg, keyID)
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationRerandomize, 1)
+
+	result, err := b.rerandomizeVector(matrix, cfg, settings, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	roundToPrecision(result.Ciphertext, precision)
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"ciphertext": encodeCiphertext(result.Ciphertext, encoding, precision),
+		},
+	}
+	scheme.addTo(resp.Data)
+	addRequestContext(resp.Data, requestContext, keyID)
+	if result.Clipped > 0 {
+		resp.Data["clipped_components"] = result.Clipped
+	}
+	addWarnings(resp, result.warnings(settings))
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// rerandomizeVector recovers the noisy plaintext v' = Qᵀc / s and encrypts it
+// again, so the result is c plus fresh noise.
+func (b *vectorBackend) rerandomizeVector(matrix *mat.Dense, cfg *rotationConfig, settings *mountSettings, ciphertext []float64) (*encryptResult, error) {
+	return b.reencrypt(matrix, cfg, matrix, cfg, settings, ciphertext)
+}
+
+// reencrypt recovers the noisy plaintext of a ciphertext under one key and
+// encrypts it under another, which may be the same key. The recovered
+// plaintext is zeroed before returning. Both keys must have the same
+// dimension.
+func (b *vectorBackend) reencrypt(from *mat.Dense, fromCfg *rotationConfig, to *mat.Dense, toCfg *rotationConfig, settings *mountSettings, ciphertext []float64) (*encryptResult, error) {
+	plaintextPtr := b.borrowFloats()
+	defer b.returnFloats(plaintextPtr)
+	b.sizeFloats(plaintextPtr, fromCfg.Dimension)
+	plaintext := *plaintextPtr
+	defer clear(plaintext)
+
+	recovered := mat.NewVecDense(fromCfg.Dimension, plaintext)
+	recovered.MulVec(from.T(), mat.NewVecDense(fromCfg.Dimension, ciphertext))
+	recovered.ScaleVec(1/fromCfg.ScalingFactor, recovered)
+
+	// The recovered plaintext is noisy and differs on every call, so it
+	// would only pollute the repeat sketch. Its outliers were handled when
+	// it was first encrypted.
+	noRepeat := *settings
+	noRepeat.RepeatLimit = 0
+	noRepeat.MaxAbsInput = 0
+	return b.encrypt(to, toCfg, &noRepeat, plaintext, encryptOptions{coalesce: true})
+}
+
+// Help text constants for the rerandomize endpoint.
+const pathRerandomizeHelpSyn = `Refresh a ciphertext's noise without changing the key.`
+
+const pathRerandomizeHelpDesc = `
+Takes a ciphertext produced under the current key and returns a new
+ciphertext of the same plaintext, with fresh noise. The plaintext is
+recovered and re-encrypted inside the plugin and never returned.
+
+Use it before copying ciphertexts to another system, so that the copies
+cannot be linked to the originals by comparing values, without rotating the
+key. Because the recovered plaintext still carries the original noise, the
+result is the original ciphertext plus fresh noise: each re-randomization
+adds noise, degrading distances as a second encryption would. The copies
+remain close to the originals, as distance preservation requires.
+
+Requests under a role use the role's key, and require the 'rerandomize'
+operation in the role's allowed_operations.
+
+Parameters:
+  ciphertext - Ciphertext to re-randomize.
+  precision  - Output rounding (default: the mount's output_precision).
+  encoding   - 'array' (default) or 'base64', for the ciphertext given
+               and the one returned.
+  context    - The context the ciphertext was encrypted with, if any.
+`
@@ -0,0 +1,65 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRerandomize(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	original := resp.Data["ciphertext"].([]float64)
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "rerandomize/vector", map[string]interface{}{
+		"ciphertext": original,
+	})
+	fresh := resp.Data["ciphertext"].([]float64)
+	var distance float64
+	for i := range original {
+		d := fresh[i] - original[i]
+		if d == 0 {
+			t.Errorf("component %d was not re-randomized", i)
+		}
+		distance += d * d
+	}
+	// The result is the original plus fresh noise within the noise radius.
+	if distance = math.Sqrt(distance); distance > 0.5+1e-9 {
+		t.Errorf("re-randomized ciphertext is %v from the original, want at most the noise radius 0.5", distance)
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rerandomize/vector", map[string]interface{}{
+		"ciphertext": testVector(0)[:testDimension-1],
+	}); err == nil {
+		t.Error("re-randomized a ciphertext of the wrong dimension")
+	}
+}
+
+func TestRerandomizeRoleOperation(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"allowed_operations": "encrypt",
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/partners", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rerandomize/vector/partners", map[string]interface{}{
+		"ciphertext": resp.Data["ciphertext"],
+	}); err == nil {
+		t.Error("re-randomized under a role without the rerandomize operation")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultStatsRetention is how long activity buckets are kept by default.
+	defaultStatsRetention = 365 * 24 * time.Hour
+
+	// retentionInterval is the minimum time between retention sweeps. The
+	// periodic function runs about once a minute; sweeping that often would
+	// only re-list storage.
+	retentionInterval = time.Hour
+)
+
+// runRetention deletes stored artifacts older than the configured retention.
+// It runs from the periodic function at most once per retentionInterval.
+func (b *vectorBackend) runRetention(ctx context.Context, storage logical.Storage, now time.Time) error {
+	last := b.lastRetention.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < retentionInterval {
+		return nil
+	}
+	if !b.lastRetention.CompareAndSwap(last, now.UnixNano()) {
+		// Another sweep started concurrently.
+		return nil
+	}
+
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if settings.StatsRetention <= 0 {
+		return nil
+	}
+
+	cutoff := now.Add(-time.Duration(settings.StatsRetention) * time.Second)
+	deleted, err := pruneActivity(ctx, storage, cutoff)
+	if deleted > 0 {
+		b.Logger().Info("pruned expired activity buckets", "deleted", deleted, "cutoff", cutoff)
+	}
+	return err
+}
+
+// pruneActivity deletes the hourly activity buckets that ended before cutoff
+// and returns how many were deleted.
+func pruneActivity(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	days, err := storage.List(ctx, activityStoragePrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, day := range days {
+		day = strings.TrimSuffix(day, "/")
+		dayStart, err := time.Parse(activityDayLayout, day)
+		if err != nil || !dayStart.Before(cutoff) {
+			continue
+		}
+		hours, err := storage.List(ctx, activityStoragePrefix+day+"/")
+		if err != nil {
+			return deleted, err
+		}
+		for _, hour := range hours {
+			if strings.HasSuffix(hour, "/") {
+				continue
+			}
+			hourStart, err := time.Parse(activityDayLayout+activityHourLayout, day+hour)
+			if err != nil || hourStart.Add(time.Hour).After(cutoff) {
+				continue
+			}
+			if err := deleteStorageEntry(ctx, storage, activityStoragePrefix+day+"/"+hour); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
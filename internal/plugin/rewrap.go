@@ -0,0 +1,172 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathRewrap returns the path configuration for rewrap/vector.
+func (b *vectorBackend) pathRewrap() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("rewrap/vector"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"ciphertext": {
+					Type:        framework.TypeSlice,
+					Description: "Ciphertext to move to the current key.",
+					Required:    true,
+				},
+				"key_version": {
+					Type:        framework.TypeInt,
+					Description: "Key version the ciphertext was produced under, as returned with it.",
+					Required:    true,
+				},
+				"precision": precisionField,
+				"encoding":  encodingField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRewrap,
+					Summary:  "Re-encrypt a ciphertext from an older key version under the current key.",
+				},
+			},
+			HelpSynopsis:    pathRewrapHelpSyn,
+			HelpDescription: pathRewrapHelpDesc,
+		},
+	}
+}
+
+// handleRewrap decrypts a ciphertext internally under the key version it
+// was produced with and encrypts the result under the current key. The
+// plaintext never leaves the plugin.
+func (b *vectorBackend) handleRewrap(ctx context.Context, req *logical.Request, data *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = fmt.Errorf("internal plugin error")
+		}
+	}()
+
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationRewrap); err != nil {
+		return nil, err
+	}
+	if err := role.checkFormat(formatJSON); err != nil {
+		return nil, err
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := requestPrecision(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+	version := data.Get("key_version").(int)
+	if version <= 0 {
+		return nil, fmt.Errorf("key_version is required and must be positive")
+	}
+
+	rawCiphertext := data.Get("ciphertext")
+	if settings.strict() && encoding == encodingArray {
+		if err := checkStrictVectorInput(rawCiphertext); err != nil {
+			return nil, err
+		}
+	}
+	fromMatrix, fromCfg, err := b.matrixForVersion(ctx, req, role, version)
+	if err != nil {
+		return nil, err
+	}
+	// A packed ciphertext's component width follows from the dimension.
+	ciphertextBufPtr := b.borrowFloats()
+	defer b.returnFloats(ciphertextBufPtr)
+	ciphertext, err := parseCiphertextInto(*ciphertextBufPtr, rawCiphertext, encoding, fromCfg.Dimension)
+	if err != nil {
+		return nil, err
+	}
+	b.adoptFloats(ciphertextBufPtr, ciphertext)
+
+	matrix, cfg, err := b.matrixForRole(ctx, req, role)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) != fromCfg.Dimension {
+		return nil, fmt.Errorf("ciphertext dimension %d does not match key version %d's dimension %d",
+			len(ciphertext), version, fromCfg.Dimension)
+	}
+	if fromCfg.Dimension != cfg.Dimension {
+		return nil, fmt.Errorf("key version %d has dimension %d but the current key has %d; rewrapping cannot change the dimension",
+			version, fromCfg.Dimension, cfg.Dimension)
+	}
+	scheme, err := b.requestSchemeParams(req, role, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	b.poolStats.recordRequest()
+	b.recordActivity(ctx, req, data, operationRewrap, 1)
+
+	result, err := b.reencrypt(fromMatrix, fromCfg, matrix, cfg, settings, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	roundToPrecision(result.Ciphertext, precision)
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"ciphertext":           encodeCiphertext(result.Ciphertext, encoding, precision),
+			"previous_key_version": version,
+		},
+	}
+	scheme.addTo(resp.Data)
+	if result.Clipped > 0 {
+		resp.Data["clipped_components"] = result.Clipped
+	}
+	addWarnings(resp, result.warnings(settings))
+	role.filterResponse(resp)
+	return resp, nil
+}
+
+// Help text constants for the rewrap endpoint.
+const pathRewrapHelpSyn = `Move a ciphertext from an older key version to the current key.`
+
+const pathRewrapHelpDesc = `
+Takes a ciphertext and the key version it was produced under (as returned
+with it by encrypt/vector and encrypt/batch) and returns a ciphertext of the
+same plaintext under the current key. The plaintext is recovered and
+re-encrypted inside the plugin and never returned.
+
+Together with the keyring, this migrates a vector database to a new key
+without re-embedding the source documents: rotate, then rewrap every stored
+ciphertext, then delete the old version with config/versions/delete.
+Ciphertexts under different keys are not comparable, so queries must use the
+old version (key_version on encrypt/vector) until the migration completes.
+
+The recovered plaintext still carries the old encryption's noise, so a
+rewrapped ciphertext has the noise of two encryptions. Rewrapping cannot
+change the dimension. Roles with a derivation context have no older
+versions and are refused. Requests under a role require the 'rewrap'
+operation in the role's allowed_operations.
+
+Parameters:
+  ciphertext  - Ciphertext to rewrap.
+  key_version - Key version the ciphertext was produced under.
+  precision   - Output rounding (default: the mount's output_precision).
+  encoding    - 'array' (default) or 'base64', for the ciphertext given
+                and the one returned. Packed float32 and float64 input are
+                told apart by length.
+`
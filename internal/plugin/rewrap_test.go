@@ -0,0 +1,156 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRewrap(t *testing.T) {
+	b, s := getTestBackend(t)
+	params := map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", params)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	old := resp.Data["ciphertext"].([]float64)
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", params)
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	fresh := resp.Data["ciphertext"].([]float64)
+	transform := resp.Data["transform_id"]
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  old,
+		"key_version": 1,
+	})
+	rewrapped := resp.Data["ciphertext"].([]float64)
+	if resp.Data["key_version"] != 2 || resp.Data["previous_key_version"] != 1 || resp.Data["transform_id"] != transform {
+		t.Errorf("rewrap parameters = %v, want those of version 2", resp.Data)
+	}
+
+	// The rewrapped ciphertext carries the noise of two encryptions, and a
+	// fresh encryption its own: at most three noise radii apart.
+	if d := euclideanDistance(rewrapped, fresh); d > 1.5+1e-9 {
+		t.Errorf("rewrapped ciphertext is %v from a fresh encryption under the current key, want at most 1.5", d)
+	}
+	if d := euclideanDistance(rewrapped, old); d < 1.5 {
+		t.Errorf("rewrapped ciphertext is only %v from the old one; it was not moved to the new key", d)
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext": old,
+	}); err == nil {
+		t.Error("rewrapped without a key_version")
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  old,
+		"key_version": 7,
+	}); err == nil {
+		t.Error("rewrapped from a key version that does not exist")
+	}
+}
+
+func TestRewrapRefusesDimensionChange(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension * 2,
+	})
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  resp.Data["ciphertext"],
+		"key_version": 1,
+	}); err == nil {
+		t.Error("rewrapped across a dimension change")
+	}
+}
+
+func TestRewrapBase64(t *testing.T) {
+	b, s := getTestBackend(t)
+	params := map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.5,
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", params)
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":    testVector(1),
+		"encoding":  encodingBase64,
+		"precision": precisionFloat32,
+	})
+	packed, ok := resp.Data["ciphertext"].(string)
+	if !ok {
+		t.Fatalf("ciphertext = %T, want a base64 string", resp.Data["ciphertext"])
+	}
+	if raw, err := base64.StdEncoding.DecodeString(packed); err != nil || len(raw) != testDimension*float32Size {
+		t.Fatalf("packed ciphertext is %d bytes (%v), want %d", len(raw), err, testDimension*float32Size)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", params)
+	resp = testRequest(t, b, s, logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  packed,
+		"key_version": 1,
+		"encoding":    encodingBase64,
+	})
+	rewrapped, err := unpackCiphertext(nil, resp.Data["ciphertext"].(string), testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	}).Data["ciphertext"].([]float64)
+	// Three noise radii, as in TestRewrap, plus the float32 rounding.
+	if d := euclideanDistance(rewrapped, fresh); d > 1.5+1e-4 {
+		t.Errorf("rewrapped base64 ciphertext is %v from a fresh encryption, want at most 1.5", d)
+	}
+
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "rewrap/vector", map[string]interface{}{
+		"ciphertext":  packed,
+		"key_version": 1,
+	}); err == nil {
+		t.Error("rewrapped a base64 ciphertext without encoding=base64")
+	}
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":   testVector(1),
+		"encoding": "hex",
+	}); err == nil {
+		t.Error("accepted an unknown encoding")
+	}
+
+	// Batch results pack each ciphertext in place of the array.
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":  []interface{}{testVector(1), testVector(2)},
+		"encoding": encodingBase64,
+	})
+	encoded, err := json.Marshal(resp.Data["batch_results"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(encoded, &results); err != nil {
+		t.Fatalf("batch results %s: %v", encoded, err)
+	}
+	for i, r := range results {
+		if _, err := unpackCiphertext(nil, r.Ciphertext, testDimension); err != nil {
+			t.Errorf("batch result %d: %v", i, err)
+		}
+	}
+}
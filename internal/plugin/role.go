@@ -0,0 +1,486 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// roleStoragePrefix is the Vault storage prefix for roles.
+	roleStoragePrefix = "roles/"
+
+	// formatRaw is the packed binary frame format served by encrypt/raw.
+	formatRaw = "raw"
+
+	// operationEncrypt, operationBatch and operationRaw name the operations
+	// served by encrypt/vector, encrypt/batch and encrypt/raw.
+	operationEncrypt = "encrypt"
+	operationBatch   = "batch"
+	operationRaw     = "raw"
+
+	// operationStore names writing ciphertexts through to ciphertext/:id.
+	operationStore = "store"
+
+	// operationSearch names the search served by search/knn.
+	operationSearch = "search"
+
+	// operationUpload names the multi-request uploads served by upload/.
+	operationUpload = "upload"
+
+	// operationRerandomize names the re-randomization served by
+	// rerandomize/vector.
+	operationRerandomize = "rerandomize"
+
+	// operationRewrap names the migration to the current key served by
+	// rewrap/vector.
+	operationRewrap = "rewrap"
+
+	// operationEmbeddings names the OpenAI-compatible endpoint served by
+	// openai/embeddings.
+	operationEmbeddings = "embeddings"
+
+	// operationQuery names the noiseless query encryption served by
+	// encrypt/query.
+	operationQuery = "query"
+
+	// operationCompare names the comparison served by compare.
+	operationCompare = "compare"
+)
+
+// hideableFields are the response metadata fields a role may withhold:
+// the distance-correction metadata of clipping and outliers, the scheme
+// parameters (see scheme.go) and warnings. The ciphertext itself can never
+// be hidden.
+var hideableFields = []string{
+	"clipped_components", "outlier_components", "input_scale",
+	"scheme", "key_version", "dimension", "transform_id",
+	"warnings",
+}
+
+// allFormats are the output formats a role may allow.
+var allFormats = []string{formatJSON, formatNDJSON, formatRaw}
+
+// allOperations are the operations a role may allow. New operations (e.g.
+// decrypt) MUST be appended here and are never granted to existing roles
+// implicitly: every stored role carries an explicit list.
+var allOperations = []string{operationEncrypt, operationBatch, operationRaw, operationStore, operationSearch, operationUpload, operationRerandomize, operationRewrap, operationEmbeddings, operationQuery, operationCompare}
+
+// legacyOperations are granted to roles stored before allowed_operations
+// existed. It is frozen; do not add operations to it.
+var legacyOperations = []string{operationEncrypt, operationBatch, operationRaw}
+
+// vectorRole restricts what a client using the role may request and receive.
+// Roles are selected by the trailing path segment of the encrypt endpoints
+// (e.g. encrypt/vector/partners), so Vault ACL policies can bind a client to
+// a role by path.
+type vectorRole struct {
+	// HiddenFields are removed from every response served under the role.
+	HiddenFields []string `json:"hidden_fields"`
+
+	// AllowedFormats are the output formats the role may request.
+	AllowedFormats []string `json:"allowed_formats"`
+
+	// AllowedOperations are the operations the role may perform.
+	AllowedOperations []string `json:"allowed_operations"`
+
+	// DerivationContext, when set, selects a key derived from the mount key
+	// for this context. It may contain identity templates such as
+	// {{identity.entity.metadata.tenant_id}}, populated per request.
+	DerivationContext string `json:"derivation_context,omitempty"`
+}
+
+// roleNameField is the path field selecting a role on the encrypt endpoints.
+var roleNameField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Name of the role whose restrictions apply. Taken from the path.",
+}
+
+// withOptionalRole appends an optional trailing role segment to pattern.
+func withOptionalRole(pattern string) string {
+	return pattern + "(/" + framework.GenericNameRegex("role") + ")?$"
+}
+
+// pathRoles returns the path configuration for roles/ and roles/:name.
+func (b *vectorBackend) pathRoles() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleRoleList,
+					Summary:  "List the configured roles.",
+				},
+			},
+			HelpSynopsis:    pathRoleListHelpSyn,
+			HelpDescription: pathRoleListHelpDesc,
+		},
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"hidden_fields": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: fmt.Sprintf("Response fields withheld from clients using the role. Any of: %s.", strings.Join(hideableFields, ", ")),
+				},
+				"allowed_formats": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: fmt.Sprintf("Output formats the role may request. Any of: %s. Defaults to all.", strings.Join(allFormats, ", ")),
+				},
+				"derivation_context": {
+					Type:        framework.TypeString,
+					Description: "Context for a key derived from the mount key. May contain identity templates, e.g. {{identity.entity.metadata.tenant_id}}.",
+				},
+				"allowed_operations": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: fmt.Sprintf("Operations the role may perform. Any of: %s. Defaults to all current operations.", strings.Join(allOperations, ", ")),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRoleRead,
+					Summary:  "Read a role.",
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleRoleWrite,
+					Summary:  "Create a role.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRoleWrite,
+					Summary:  "Update a role.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleRoleDelete,
+					Summary:  "Delete a role.",
+				},
+			},
+			ExistenceCheck:  b.roleExists,
+			HelpSynopsis:    pathRoleHelpSyn,
+			HelpDescription: pathRoleHelpDesc,
+		},
+	}
+}
+
+// handleRoleList lists the configured roles.
+func (b *vectorBackend) handleRoleList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	// Chunk subtrees of oversized roles show up as "name/"; skip them.
+	names = slices.DeleteFunc(names, func(n string) bool { return strings.HasSuffix(n, "/") })
+	return logical.ListResponse(names), nil
+}
+
+// handleRoleRead returns a role.
+func (b *vectorBackend) handleRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.getRole(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: role.responseData(),
+	}, nil
+}
+
+// handleRoleWrite creates or updates a role. Fields that are not supplied
+// keep their current value, or their default for a new role.
+func (b *vectorBackend) handleRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if role != nil {
+		before = role.responseData()
+	}
+	if role == nil {
+		role = &vectorRole{
+			HiddenFields:      []string{},
+			AllowedFormats:    slices.Clone(allFormats),
+			AllowedOperations: slices.Clone(allOperations),
+		}
+	}
+
+	if raw, ok := data.GetOk("hidden_fields"); ok {
+		role.HiddenFields = raw.([]string)
+	}
+	if raw, ok := data.GetOk("allowed_formats"); ok {
+		role.AllowedFormats = raw.([]string)
+	}
+	if raw, ok := data.GetOk("allowed_operations"); ok {
+		role.AllowedOperations = raw.([]string)
+	}
+	if raw, ok := data.GetOk("derivation_context"); ok {
+		role.DerivationContext = raw.(string)
+	}
+
+	if err := role.validate(); err != nil {
+		return nil, err
+	}
+	if err := putStorageJSON(ctx, req.Storage, roleStoragePrefix+name, role); err != nil {
+		return nil, err
+	}
+	if err := b.recordHistory(ctx, req, "role-write", before, role.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: role.responseData(),
+	}, nil
+}
+
+// handleRoleDelete removes a role.
+func (b *vectorBackend) handleRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, roleStoragePrefix+name); err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "role-delete", role.responseData(), map[string]interface{}{})
+}
+
+// roleExists checks if a role has been stored (for ExistenceCheck).
+func (b *vectorBackend) roleExists(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	role, err := b.getRole(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+// getRole retrieves and validates a role from storage. It returns nil if the
+// role does not exist.
+func (b *vectorBackend) getRole(ctx context.Context, storage logical.Storage, name string) (*vectorRole, error) {
+	var role vectorRole
+	found, err := getStorageJSON(ctx, storage, roleStoragePrefix+name, &role)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	if role.AllowedOperations == nil {
+		role.AllowedOperations = slices.Clone(legacyOperations)
+	}
+	if err := role.validate(); err != nil {
+		return nil, fmt.Errorf("stored role %q is invalid: %w", name, err)
+	}
+	return &role, nil
+}
+
+// requestRole returns the role named by the request path, or nil if the
+// request did not name one. Naming a role that does not exist is an error.
+func (b *vectorBackend) requestRole(ctx context.Context, req *logical.Request, data *framework.FieldData) (*vectorRole, error) {
+	name := data.Get("role").(string)
+	if name == "" {
+		return nil, nil
+	}
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role %q not found", name)
+	}
+	return role, nil
+}
+
+// validate checks the role's lists against the known values.
+func (r *vectorRole) validate() error {
+	for _, f := range r.HiddenFields {
+		if !slices.Contains(hideableFields, f) {
+			return fmt.Errorf("hidden_fields: %q cannot be hidden (allowed: %s)", f, strings.Join(hideableFields, ", "))
+		}
+	}
+	for _, f := range r.AllowedFormats {
+		if !slices.Contains(allFormats, f) {
+			return fmt.Errorf("allowed_formats: unknown format %q (allowed: %s)", f, strings.Join(allFormats, ", "))
+		}
+	}
+	for _, op := range r.AllowedOperations {
+		if !slices.Contains(allOperations, op) {
+			return fmt.Errorf("allowed_operations: unknown operation %q (allowed: %s)", op, strings.Join(allOperations, ", "))
+		}
+	}
+	if _, err := framework.ValidateIdentityTemplate(r.DerivationContext); err != nil {
+		return fmt.Errorf("derivation_context: %w", err)
+	}
+	if strings.ContainsRune(r.DerivationContext, 0) {
+		return fmt.Errorf("derivation_context must not contain NUL characters")
+	}
+	return nil
+}
+
+// checkOperation returns an error if the role may not perform op.
+// A nil role permits everything.
+func (r *vectorRole) checkOperation(op string) error {
+	if r == nil || slices.Contains(r.AllowedOperations, op) {
+		return nil
+	}
+	return fmt.Errorf("role does not permit operation %q", op)
+}
+
+// checkFormat returns an error if the role may not request format.
+// A nil role permits everything.
+func (r *vectorRole) checkFormat(format string) error {
+	if r == nil || slices.Contains(r.AllowedFormats, format) {
+		return nil
+	}
+	return fmt.Errorf("role does not permit output format %q", format)
+}
+
+// hides reports whether the role withholds the named response field.
+// A nil role hides nothing.
+func (r *vectorRole) hides(field string) bool {
+	return r != nil && slices.Contains(r.HiddenFields, field)
+}
+
+// filterResponse removes the role's hidden fields from resp.
+func (r *vectorRole) filterResponse(resp *logical.Response) {
+	if r == nil || resp == nil {
+		return
+	}
+	for _, f := range r.HiddenFields {
+		switch f {
+		case "warnings":
+			resp.Warnings = nil
+			delete(resp.Data, "structured_warnings")
+			continue
+		case "key_version":
+			delete(resp.Data, "previous_key_version")
+		}
+		delete(resp.Data, f)
+	}
+}
+
+// filterBatchItem removes the role's hidden fields from a batch result.
+func (r *vectorRole) filterBatchItem(item *batchItemResult) {
+	if r.hides("clipped_components") {
+		item.ClippedComponents = 0
+	}
+	if r.hides("outlier_components") {
+		item.OutlierComponents = 0
+	}
+	if r.hides("input_scale") {
+		item.InputScale = 0
+	}
+	if r.hides("scheme") {
+		item.Scheme = ""
+	}
+	if r.hides("key_version") {
+		item.KeyVersion = 0
+	}
+	if r.hides("dimension") {
+		item.Dimension = 0
+	}
+	if r.hides("transform_id") {
+		item.TransformID = ""
+	}
+	if r.hides("warnings") {
+		item.Warnings = nil
+		item.StructuredWarnings = nil
+	}
+}
+
+// filterHeaders removes the role's hidden scheme parameters from the
+// headers of a raw response.
+func (r *vectorRole) filterHeaders(headers map[string][]string) {
+	for field, header := range schemeHeaders {
+		if r.hides(field) {
+			delete(headers, header)
+		}
+	}
+}
+
+// responseData renders the role for API responses.
+func (r *vectorRole) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"hidden_fields":      r.HiddenFields,
+		"allowed_formats":    r.AllowedFormats,
+		"allowed_operations": r.AllowedOperations,
+		"derivation_context": r.DerivationContext,
+	}
+}
+
+// Help text constants for the role paths.
+const pathRoleListHelpSyn = `List the configured roles.`
+
+const pathRoleListHelpDesc = `
+This endpoint lists the names of the roles configured on this mount.
+`
+
+const pathRoleHelpSyn = `Manage roles that restrict what encrypt clients may do and receive.`
+
+const pathRoleHelpDesc = `
+A role restricts the operations, responses and output formats available to
+clients that encrypt through it. A role is selected by appending its name to an encrypt
+path, so Vault ACL policies can bind a client to a role:
+
+  path "vector/encrypt/vector/partners" { capabilities = ["update"] }
+  path "vector/encrypt/batch/partners"  { capabilities = ["update"] }
+
+Requests that name a role which does not exist are rejected. Requests that
+name no role are unrestricted; grant partner policies role paths only.
+
+Parameters:
+  hidden_fields      - Response fields withheld from clients using the
+                       role (default: none): the distance-correction
+                       metadata clipped_components, outlier_components and
+                       input_scale; the scheme parameters scheme,
+                       key_version, dimension and transform_id; and
+                       warnings, with structured_warnings
+  allowed_formats    - Output formats the role may request: json, ndjson,
+                       raw (default: all)
+  allowed_operations - Operations the role may perform (default: all
+                       current operations): encrypt (encrypt/vector),
+                       batch (encrypt/batch), raw (encrypt/raw), store
+                       (id/ids on encrypt), search (search/), upload
+                       (upload/), rerandomize (rerandomize/vector),
+                       rewrap (rewrap/vector), embeddings
+                       (openai/embeddings), query (encrypt/query,
+                       encrypt/queries), compare (compare)
+
+  derivation_context - Encrypt with a key derived from the mount key for
+                       this context (default: none, the mount key). May
+                       contain identity templates, populated from the
+                       requesting token's entity on every request.
+
+A templated derivation context binds each client to its tenant through the
+identity system rather than request parameters, for example:
+
+  vault write vector/roles/tenant \
+      derivation_context='{{identity.entity.metadata.tenant_id}}'
+
+Ciphertexts under different contexts are not comparable. Requests whose
+token has no entity, or whose template resolves to an empty value, are
+rejected.
+
+The allowed operations of a role are stored explicitly. Operations added to
+the plugin later (such as decryption) are never granted to existing roles
+implicitly; they must be added to allowed_operations, so a policy granting a
+role path cannot be escalated by a plugin upgrade.
+
+Example (minimal metadata for external partners):
+  vault write vector/roles/partners hidden_fields=clipped_components,warnings \
+      allowed_formats=json
+`
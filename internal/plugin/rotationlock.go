@@ -0,0 +1,235 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// rotationLockStoragePath holds the lease of the key change in progress.
+	rotationLockStoragePath = "rotation/lock"
+
+	// rotationLockTTL bounds how long a lease outlives the node holding it.
+	// It covers generating and validating a matrix of MaxDimension.
+	rotationLockTTL = 10 * time.Minute
+)
+
+// expectedVersionField makes a key change conditional on the current key
+// version, like KV v2's cas.
+var expectedVersionField = &framework.FieldSchema{
+	Type:        framework.TypeInt,
+	Description: "Only change the key if the current key version is this one, so a change made meanwhile by someone else is not silently replaced (0: no check).",
+}
+
+// rotationLease is the storage lease held for the duration of a key change:
+// a rotation, import, ceremony completion or compromise re-key.
+type rotationLease struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Identity  string    `json:"identity,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// responseData renders the lease for API responses.
+func (l *rotationLease) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"operation":  l.Operation,
+		"identity":   l.Identity,
+		"request_id": l.RequestID,
+		"started_at": l.StartedAt.Format(time.RFC3339),
+		"expires_at": l.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// conflict is the retriable error returned to a key change that finds l
+// held.
+func (l *rotationLease) conflict() error {
+	holder := l.Identity
+	if holder == "" {
+		holder = "an unidentified caller"
+	}
+	return logical.CodedError(http.StatusConflict, fmt.Sprintf(
+		"a key %s by %s has been in progress since %s; retry once it completes (its lock expires at %s)",
+		l.Operation, holder, l.StartedAt.Format(time.RFC3339), l.ExpiresAt.Format(time.RFC3339)))
+}
+
+// acquireRotationLock takes the mount's rotation lease for operation, and
+// with expectedVersion > 0 checks that the current key version is still
+// expectedVersion. The returned release drops the lease; call it once the
+// key change, including its history record, is done.
+//
+// Vault forwards writes to the active node, where rotationLockMu makes the
+// check and the write of the lease atomic. The lease itself outlives the
+// request's hold on that mutex: it spans the generation of the new matrix,
+// which runs unlocked, and survives a change of active node, until
+// rotationLockTTL.
+func (b *vectorBackend) acquireRotationLock(ctx context.Context, req *logical.Request, operation string, expectedVersion int) (func(), error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	lease := &rotationLease{
+		ID:        id,
+		Operation: operation,
+		Identity:  callerIdentity(req),
+		RequestID: req.ID,
+		StartedAt: now,
+		ExpiresAt: now.Add(rotationLockTTL),
+	}
+
+	b.rotationLockMu.Lock()
+	defer b.rotationLockMu.Unlock()
+	held, err := readRotationLease(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil && now.Before(held.ExpiresAt) {
+		return nil, held.conflict()
+	}
+	if expectedVersion > 0 {
+		cfg, err := b.readConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if current := configVersion(cfg); current != expectedVersion {
+			return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf(
+				"the current key version is %d, not expected_version %d; review the change made meanwhile before retrying",
+				current, expectedVersion))
+		}
+	}
+	if held != nil {
+		b.Logger().Warn("taking over expired rotation lock",
+			"operation", held.Operation, "identity", held.Identity, "started_at", held.StartedAt)
+	}
+	if err := putStorageJSON(ctx, req.Storage, rotationLockStoragePath, lease); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		b.rotationLockMu.Lock()
+		defer b.rotationLockMu.Unlock()
+		// The request's context may be done by now; release regardless.
+		releaseCtx := context.Background()
+		held, err := readRotationLease(releaseCtx, req.Storage)
+		if err != nil || held == nil || held.ID != lease.ID {
+			// Broken or taken over after expiry: not ours to delete.
+			return
+		}
+		if err := req.Storage.Delete(releaseCtx, rotationLockStoragePath); err != nil {
+			b.Logger().Warn("failed to release rotation lock; it expires on its own", "error", err, "expires_at", lease.ExpiresAt)
+		}
+	}, nil
+}
+
+// configVersion returns the version of the key cfg, 0 if there is none.
+func configVersion(cfg *rotationConfig) int {
+	if cfg == nil {
+		return 0
+	}
+	return cfg.version()
+}
+
+// readRotationLease retrieves the rotation lease, or nil if none is held.
+func readRotationLease(ctx context.Context, storage logical.Storage) (*rotationLease, error) {
+	var lease rotationLease
+	found, err := getStorageJSON(ctx, storage, rotationLockStoragePath, &lease)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// pathRotationLock returns the path configuration for config/rotation-lock.
+func (b *vectorBackend) pathRotationLock() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/rotation-lock",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRotationLockRead,
+					Summary:  "Report the key change in progress, if any.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleRotationLockDelete,
+					Summary:  "Break the rotation lock of a key change that will not complete.",
+				},
+			},
+			HelpSynopsis:    pathRotationLockHelpSyn,
+			HelpDescription: pathRotationLockHelpDesc,
+		},
+	}
+}
+
+// handleRotationLockRead reports the current lease; nothing when none is
+// held or it expired.
+func (b *vectorBackend) handleRotationLockRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	lease, err := readRotationLease(ctx, req.Storage)
+	if err != nil || lease == nil || !time.Now().Before(lease.ExpiresAt) {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: lease.responseData(),
+	}, nil
+}
+
+// handleRotationLockDelete breaks the lease. The key change holding it, if
+// still running, completes; the lock only keeps others from starting.
+func (b *vectorBackend) handleRotationLockDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.rotationLockMu.Lock()
+	defer b.rotationLockMu.Unlock()
+	lease, err := readRotationLease(ctx, req.Storage)
+	if err != nil || lease == nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, rotationLockStoragePath); err != nil {
+		return nil, err
+	}
+	b.Logger().Warn("rotation lock broken",
+		"operation", lease.Operation, "identity", lease.Identity, "broken_by", callerIdentity(req))
+	return nil, nil
+}
+
+// Help text constants for the rotation lock path.
+const pathRotationLockHelpSyn = `Inspect or break the lock serializing key changes.`
+
+const pathRotationLockHelpDesc = `
+Key changes (config/rotate, config/root, config/import, the completion of
+a config/ceremony and config/compromise) take a lock in storage for their
+duration, so two operators or automations on different nodes cannot
+interleave them and leave the keyring inconsistent. A key change that finds
+the lock held fails with HTTP 409 naming the change in progress, and can be
+retried once it completes. A lock left by a node that died mid-change
+expires after 10 minutes.
+
+To also catch a change that completed between reading the key and changing
+it, pass expected_version to config/rotate or config/import: the change
+fails with HTTP 409 unless the current key version is still that one.
+
+Reading returns the change in progress, if any:
+  operation  - rotate, import, ceremony or compromise
+  identity   - The entity or token accessor that started it
+  request_id - Its Vault request ID, to find it in the audit log
+  started_at - When it started
+  expires_at - When the lock lapses if it is not released
+
+Deleting breaks the lock before it expires. Only do so once the node that
+held it is known to be gone; the change, if still running, is not stopped.
+
+Example:
+  vault read vector/config
+  vault write vector/config/rotate dimension=1536 expected_version=3
+  vault read vector/config/rotation-lock
+  vault delete vector/config/rotation-lock
+`
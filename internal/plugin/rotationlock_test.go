@@ -0,0 +1,106 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// wantConflict fails the test unless err is a 409.
+func wantConflict(t *testing.T, err error) {
+	t.Helper()
+	var coded logical.HTTPCodedError
+	if !errors.As(err, &coded) || coded.Code() != http.StatusConflict {
+		t.Fatalf("err = %v, want a 409", err)
+	}
+}
+
+func TestRotationLock(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	rotate := map[string]interface{}{"dimension": testDimension}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", rotate)
+
+	// A rotation released its lock.
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config/rotation-lock", nil); resp != nil {
+		t.Fatalf("lock held after rotation: %v", resp.Data)
+	}
+
+	// Another node is rotating.
+	now := time.Now().UTC()
+	if err := putStorageJSON(ctx, s, rotationLockStoragePath, &rotationLease{
+		ID:        "other",
+		Operation: "import",
+		Identity:  "entity:ops",
+		StartedAt: now,
+		ExpiresAt: now.Add(rotationLockTTL),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := entityRequest(b, s, "", logical.UpdateOperation, "config/rotate", rotate)
+	wantConflict(t, err)
+	_, err = entityRequest(b, s, "", logical.UpdateOperation, "config/compromise", nil)
+	wantConflict(t, err)
+	if cfg, _ := b.readConfig(ctx, s); cfg.version() != 1 {
+		t.Errorf("key version = %d after refused changes, want 1", cfg.version())
+	}
+	if lifecycle, _ := b.readLifecycle(ctx, s); lifecycle.Disabled {
+		t.Error("refused compromise disabled the key")
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/rotation-lock", nil)
+	if resp.Data["operation"] != "import" || resp.Data["identity"] != "entity:ops" {
+		t.Errorf("lock = %v", resp.Data)
+	}
+	testRequest(t, b, s, logical.DeleteOperation, "config/rotation-lock", nil)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", rotate)
+
+	// An expired lock is taken over.
+	if err := putStorageJSON(ctx, s, rotationLockStoragePath, &rotationLease{
+		ID:        "dead",
+		Operation: "rotate",
+		StartedAt: now.Add(-2 * rotationLockTTL),
+		ExpiresAt: now.Add(-rotationLockTTL),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config/rotation-lock", nil); resp != nil {
+		t.Errorf("expired lock reported: %v", resp.Data)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", rotate)
+	if held, _ := readRotationLease(ctx, s); held != nil {
+		t.Errorf("lock left behind: %+v", held)
+	}
+}
+
+func TestRotationLock_ExpectedVersion(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	// The operator read version 1; version 2 was installed meanwhile.
+	_, err := entityRequest(b, s, "", logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":        testDimension,
+		"expected_version": 1,
+	})
+	wantConflict(t, err)
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":        testDimension,
+		"expected_version": 2,
+	})
+	if resp.Data["key_version"] != 3 {
+		t.Errorf("key version = %v, want 3", resp.Data["key_version"])
+	}
+}
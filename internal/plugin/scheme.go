@@ -0,0 +1,113 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// schemeSAP names the Scale-And-Perturb scheme every key implements.
+	schemeSAP = "sap"
+
+	// transformIDLabel domain-separates the transform digest.
+	transformIDLabel = "vector-dpe/transform-id/v1"
+)
+
+// schemeParams are the effective parameters behind a ciphertext, returned
+// with every encrypt and decrypt response so that downstream storage can
+// record exactly what produced each vector.
+type schemeParams struct {
+	Scheme     string
+	KeyVersion int
+	Dimension  int
+
+	// TransformID digests the key identifier and every parameter that
+	// changes the ciphertext. Equal IDs mean equal transforms; the seed
+	// cannot be recovered from it.
+	TransformID string
+}
+
+// newSchemeParams returns the parameters of cfg's key, identified by keyID
+// (which differs per derivation context).
+func newSchemeParams(cfg *rotationConfig, keyID string) *schemeParams {
+	return &schemeParams{
+		Scheme:      schemeSAP,
+		KeyVersion:  cfg.version(),
+		Dimension:   cfg.Dimension,
+		TransformID: transformID(keyID, cfg.Dimension, cfg.ScalingFactor, cfg.ApproximationFactor, cfg.MinNoiseRadius, cfg.Split, cfg.PortableArithmetic, cfg.noiseShape()),
+	}
+}
+
+// requestSchemeParams returns the parameters of the key a request encrypts
+// under, after resolving its derivation context.
+func (b *vectorBackend) requestSchemeParams(req *logical.Request, role *vectorRole, cfg *rotationConfig) (*schemeParams, error) {
+	keyID, err := b.requestKeyID(req, role, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newSchemeParams(cfg, keyID), nil
+}
+
+// transformID returns a short SHA-256 digest of the transform parameters.
+// An empty noise shape (no mask, no whitening) and native arithmetic are
+// left out, so keys without them keep their IDs.
+func transformID(keyID string, dimension int, scalingFactor, approximationFactor, minNoiseRadius float64, split, portable bool, noiseShape string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s\x00%s\x00%s\x00%t",
+		transformIDLabel, schemeSAP, keyID, dimension,
+		strconv.FormatFloat(scalingFactor, 'g', -1, 64),
+		strconv.FormatFloat(approximationFactor, 'g', -1, 64),
+		strconv.FormatFloat(minNoiseRadius, 'g', -1, 64),
+		split)
+	if noiseShape != "" {
+		fmt.Fprintf(h, "\x00%s", noiseShape)
+	}
+	if portable {
+		fmt.Fprintf(h, "\x00portable")
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// addTo sets the parameters on a JSON response.
+func (p *schemeParams) addTo(data map[string]interface{}) {
+	data["scheme"] = p.Scheme
+	data["key_version"] = p.KeyVersion
+	data["dimension"] = p.Dimension
+	data["transform_id"] = p.TransformID
+}
+
+// setItem sets the parameters on a batch item, for NDJSON lines, which have
+// no enclosing object to carry them once.
+func (p *schemeParams) setItem(item *batchItemResult) {
+	item.Scheme = p.Scheme
+	item.KeyVersion = p.KeyVersion
+	item.Dimension = p.Dimension
+	item.TransformID = p.TransformID
+}
+
+// schemeHeaders are the HTTP headers of raw responses carrying the
+// parameters, by response field.
+var schemeHeaders = map[string]string{
+	"scheme":       "X-Vector-Dpe-Scheme",
+	"key_version":  "X-Vector-Dpe-Key-Version",
+	"dimension":    "X-Vector-Dpe-Dimension",
+	"transform_id": "X-Vector-Dpe-Transform-Id",
+}
+
+// headers returns the parameters as HTTP headers, for raw responses whose
+// body has no room for them.
+func (p *schemeParams) headers() map[string][]string {
+	return map[string][]string{
+		schemeHeaders["scheme"]:       {p.Scheme},
+		schemeHeaders["key_version"]:  {strconv.Itoa(p.KeyVersion)},
+		schemeHeaders["dimension"]:    {strconv.Itoa(p.Dimension)},
+		schemeHeaders["transform_id"]: {p.TransformID},
+	}
+}
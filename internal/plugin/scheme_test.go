@@ -0,0 +1,80 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSchemeParamsInResponses(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if resp.Data["scheme"] != schemeSAP || resp.Data["key_version"] != 1 || resp.Data["dimension"] != testDimension {
+		t.Fatalf("encrypt/vector scheme parameters = %v", resp.Data)
+	}
+	id, _ := resp.Data["transform_id"].(string)
+	if len(id) != 32 {
+		t.Fatalf("transform_id = %q, want 32 hex digits", id)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(1)},
+	})
+	if resp.Data["transform_id"] != id {
+		t.Errorf("batch transform_id = %v, want %s", resp.Data["transform_id"], id)
+	}
+
+	vec, _ := parseVector(testVector(2))
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/raw", map[string]interface{}{
+		"frame": base64.StdEncoding.EncodeToString(packFloat32(nil, vec)),
+	})
+	if got := resp.Headers["X-Vector-Dpe-Transform-Id"]; len(got) != 1 || got[0] != id {
+		t.Errorf("raw transform header = %v, want %s", got, id)
+	}
+
+	// The same parameters under a new seed are a different transform.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if resp.Data["transform_id"] == id {
+		t.Error("transform_id did not change after rotation")
+	}
+	if resp.Data["key_version"] != 2 {
+		t.Errorf("key_version = %v, want 2", resp.Data["key_version"])
+	}
+}
+
+func TestTransformIDCoversParameters(t *testing.T) {
+	base := transformID("00112233", 8, 10, 2, 0, false, false, "")
+	for name, other := range map[string]string{
+		"key_id":               transformID("00112234", 8, 10, 2, 0, false, false, ""),
+		"dimension":            transformID("00112233", 16, 10, 2, 0, false, false, ""),
+		"scaling_factor":       transformID("00112233", 8, 11, 2, 0, false, false, ""),
+		"approximation_factor": transformID("00112233", 8, 10, 3, 0, false, false, ""),
+		"min_noise_radius":     transformID("00112233", 8, 10, 2, 0.5, false, false, ""),
+		"split":                transformID("00112233", 8, 10, 2, 0, true, false, ""),
+		"noise_mask":           transformID("00112233", 8, 10, 2, 0, false, false, "0-3"),
+		"portable_arithmetic":  transformID("00112233", 8, 10, 2, 0, false, true, ""),
+		"noise_variance":       transformID("00112233", 8, 10, 2, 0, false, false, noiseVariance{4, 1, 1, 1, 1, 1, 1, 1}.digest()),
+	} {
+		if other == base {
+			t.Errorf("changing %s did not change the transform_id", name)
+		}
+	}
+	if transformID("00112233", 8, 10, 2, 0, false, false, "") != base {
+		t.Error("transform_id is not deterministic")
+	}
+}
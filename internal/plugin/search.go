@@ -0,0 +1,316 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultSearchK is the number of neighbors returned by default.
+	defaultSearchK = 10
+
+	// maxSearchK bounds the number of neighbors a search may return.
+	maxSearchK = 1000
+)
+
+// searchHit is one neighbor returned by search/knn.
+type searchHit struct {
+	ID string `json:"id"`
+
+	// Distance is the estimated plaintext distance, EncryptedDistance / s.
+	Distance          float64 `json:"distance"`
+	EncryptedDistance float64 `json:"encrypted_distance"`
+
+	// KeyVersion is the key version the hit was found under, in searches
+	// with all_versions.
+	KeyVersion int `json:"key_version,omitempty"`
+}
+
+// pathSearch returns the path configuration for search/knn.
+func (b *vectorBackend) pathSearch() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("search/knn"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"query": {
+					Type:        framework.TypeSlice,
+					Description: "Encrypted query vector (a ciphertext under the same key).",
+				},
+				"vector": {
+					Type:        framework.TypeSlice,
+					Description: "Plaintext query vector, encrypted before searching. Alternative to 'query'.",
+				},
+				"model":              modelField,
+				"context":            contextField,
+				"derivation_context": derivationContextField,
+				"k": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Number of neighbors to return (max %d).", maxSearchK),
+					Default:     defaultSearchK,
+				},
+				"all_versions": {
+					Type:        framework.TypeBool,
+					Description: "Search the ciphertexts of every key version, not only the current one's. Requires 'vector'.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSearchKNN,
+					Summary:  "Find the nearest stored ciphertexts to a query.",
+				},
+			},
+			HelpSynopsis:    pathSearchHelpSyn,
+			HelpDescription: pathSearchHelpDesc,
+		},
+	}
+}
+
+// handleSearchKNN returns the k stored ciphertexts nearest to the query,
+// among those produced by the same key as the request's.
+func (b *vectorBackend) handleSearchKNN(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationSearch); err != nil {
+		return nil, err
+	}
+	k := data.Get("k").(int)
+	if k <= 0 || k > maxSearchK {
+		return nil, fmt.Errorf("k must be between 1 and %d (got %d)", maxSearchK, k)
+	}
+	rawQuery, hasQuery := data.GetOk("query")
+	rawVector, hasVector := data.GetOk("vector")
+	if hasQuery == hasVector {
+		return nil, fmt.Errorf("exactly one of 'query' or 'vector' is required")
+	}
+
+	requestContext, err := requestContextParam(data)
+	if err != nil {
+		return nil, err
+	}
+	derivationContext, err := b.requestDerivationContext(req, role, requestContext)
+	if err != nil {
+		return nil, err
+	}
+	if data.Get("all_versions").(bool) {
+		switch {
+		case !hasVector:
+			return nil, fmt.Errorf("all_versions requires 'vector': a query ciphertext is bound to one key version")
+		case derivationContext != "":
+			return nil, fmt.Errorf("all_versions is not supported with a context")
+		}
+		return b.searchAllVersions(ctx, req, data, role, rawVector, k)
+	}
+
+	var query []float64
+	var cfg *rotationConfig
+	if hasVector {
+		// Plaintext queries are encrypted like any other request, noise and
+		// repeat tracking included, so search is no shortcut around them.
+		settings, err := b.getSettings(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if settings.strict() {
+			if err := checkStrictVectorInput(rawVector); err != nil {
+				return nil, err
+			}
+		}
+		vector, err := parseVector(rawVector)
+		if err != nil {
+			return nil, err
+		}
+		matrix, c, err := b.matrixForContext(ctx, req, derivationContext)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkModel(data.Get("model").(string)); err != nil {
+			return nil, err
+		}
+		result, err := b.encryptVector(matrix, c, settings, vector)
+		if err != nil {
+			return nil, err
+		}
+		query, cfg = result.Ciphertext, c
+	} else {
+		if err := b.checkKeyEnabled(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+		if query, err = parseVector(rawQuery); err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+		if _, cfg, err = b.contextMatrix(ctx, req.Storage, derivationContext); err != nil {
+			return nil, err
+		}
+		if len(query) != cfg.Dimension {
+			return nil, fmt.Errorf("query dimension %d does not match configured dimension %d", len(query), cfg.Dimension)
+		}
+	}
+
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := b.searchSink(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	b.recordActivity(ctx, req, data, operationSearch, 1)
+
+	hits, candidates, err := sink.Query(ctx, sinkQuery{KeyID: keyID, Vector: query, K: k})
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Distance = hits[i].EncryptedDistance / cfg.ScalingFactor
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"results":    hits,
+			"key_id":     keyID,
+			"candidates": candidates,
+			// Query and stored ciphertext each carry noise of norm at most
+			// r, so each corrected distance is off by at most 2r/s.
+			"max_error": 2 * cfg.maxNoiseNorm() / cfg.ScalingFactor,
+		},
+	}, nil
+}
+
+// searchAllVersions is handleSearchKNN with all_versions: it encrypts the
+// plaintext query under each key version that can answer it, queries the
+// sink for each version's ciphertexts, and merges the hits by their
+// corrected distances, which each version's own scaling factor puts on a
+// common scale.
+func (b *vectorBackend) searchAllVersions(ctx context.Context, req *logical.Request, data *framework.FieldData, role *vectorRole, rawVector interface{}, k int) (*logical.Response, error) {
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		if err := checkStrictVectorInput(rawVector); err != nil {
+			return nil, err
+		}
+	}
+	vector, err := parseVector(rawVector)
+	if err != nil {
+		return nil, err
+	}
+	fanOut, err := b.fanOutVersions(ctx, req, role, data.Get("model").(string), len(vector))
+	if err != nil {
+		return nil, err
+	}
+	sink, err := b.searchSink(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	b.recordActivity(ctx, req, data, operationSearch, len(fanOut))
+
+	var hits []searchHit
+	versions := make([]int, len(fanOut))
+	candidates, maxError := 0, 0.0
+	for i, v := range fanOut {
+		result, err := b.encryptVector(v.matrix, v.cfg, settings, vector)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", v.cfg.version(), err)
+		}
+		versionHits, n, err := sink.Query(ctx, sinkQuery{KeyID: v.keyID, Vector: result.Ciphertext, K: k})
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", v.cfg.version(), err)
+		}
+		for j := range versionHits {
+			versionHits[j].Distance = versionHits[j].EncryptedDistance / v.cfg.ScalingFactor
+			versionHits[j].KeyVersion = v.cfg.version()
+		}
+		hits = append(hits, versionHits...)
+		versions[i] = v.cfg.version()
+		candidates += n
+		maxError = math.Max(maxError, 2*v.cfg.maxNoiseNorm()/v.cfg.ScalingFactor)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"results":      hits,
+			"key_versions": versions,
+			"candidates":   candidates,
+			"max_error":    maxError,
+		},
+	}, nil
+}
+
+// Help text constants for the search path.
+const pathSearchHelpSyn = `Find the nearest ciphertexts stored in the mount (brute-force kNN).`
+
+const pathSearchHelpDesc = `
+This endpoint makes the engine usable standalone for small collections
+(up to roughly 100k vectors). It compares the query against every
+ciphertext stored with encrypt's 'id'/'ids' under the same key, and returns
+the k nearest, closest first.
+
+Input (exactly one of):
+  query  - A ciphertext produced under the request's key
+  vector - A plaintext, encrypted first exactly as encrypt/vector would
+
+  k      - Number of neighbors (default: 10, max: 1000)
+
+Output:
+  results    - [{id, distance, encrypted_distance}], nearest first, where
+               distance = encrypted_distance / scaling_factor estimates the
+               plaintext distance
+  key_id     - The key searched; only ciphertexts with this key_id are
+               candidates
+  candidates - Number of stored ciphertexts compared
+  max_error  - Worst-case error of each distance from noise, 2 * r / s
+
+Append a role name (search/knn/:role) to search under the role's key; the
+role must allow the 'search' operation. Pass the 'context' the ciphertexts
+were encrypted with to search under that context's key; ciphertexts of
+other contexts are never candidates. The first search loads every stored
+ciphertext into memory, which takes dimension * 8 bytes per vector.
+
+With config/sink search=true, the query goes to the webhook sink's adapter
+instead, which returns the nearest records of the key_id from its database.
+
+During a migration to a new key, stored ciphertexts are split between key
+versions. With all_versions=true and a plaintext 'vector', the query is
+encrypted under every key version of its model and dimension, each
+version's ciphertexts are searched, and the hits are merged by corrected
+distance, each divided by its own version's scaling factor. Each result
+then carries its key_version; 'key_versions' lists those searched, and
+'max_error' is the largest of theirs. Like key_version on encrypt, it is
+available for the mount key only, and every version must pass the
+hardening profile and config/policy.
+
+Example:
+  vault write vector/search/knn vector='[0.1, 0.2, ...]' k=5
+`
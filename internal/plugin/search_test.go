@@ -0,0 +1,155 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSearchKNN(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	for i, id := range []string{"zero", "one", "two", "five"} {
+		offset := []float64{0, 1, 2, 5}[i]
+		testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector": testVector(offset),
+			"id":     id,
+		})
+	}
+
+	// A plaintext query; without noise the distances are exact.
+	resp := testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(1.9),
+		"k":      2,
+	})
+	hits := resp.Data["results"].([]searchHit)
+	if len(hits) != 2 || hits[0].ID != "two" || hits[1].ID != "one" {
+		t.Fatalf("results = %+v, want two, one", hits)
+	}
+	if resp.Data["candidates"] != 4 {
+		t.Errorf("candidates = %v, want 4", resp.Data["candidates"])
+	}
+
+	// An encrypted query finds the stored copy of itself first.
+	stored := testRequest(t, b, s, logical.ReadOperation, "ciphertext/five", nil)
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"query": stored.Data["ciphertext"],
+	})
+	hits = resp.Data["results"].([]searchHit)
+	if len(hits) != 4 || hits[0].ID != "five" || hits[0].Distance > 1e-9 {
+		t.Errorf("results = %+v, want five at distance 0 first", hits)
+	}
+
+	// Deletion is reflected without reloading the index.
+	testRequest(t, b, s, logical.DeleteOperation, "ciphertext/five", nil)
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"query": stored.Data["ciphertext"],
+	})
+	if hits = resp.Data["results"].([]searchHit); hits[0].ID == "five" {
+		t.Error("deleted ciphertext still returned")
+	}
+
+	// After rotation nothing stored matches the new key.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	if resp.Data["candidates"] != 0 {
+		t.Errorf("candidates after rotation = %v, want 0", resp.Data["candidates"])
+	}
+}
+
+func TestSearchKNNRejects(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/nosearch", map[string]interface{}{
+		"allowed_operations": "encrypt",
+	})
+
+	for name, req := range map[string]struct {
+		path string
+		data map[string]interface{}
+	}{
+		"no query":  {"search/knn", map[string]interface{}{}},
+		"both":      {"search/knn", map[string]interface{}{"vector": testVector(0), "query": testVector(0)}},
+		"bad k":     {"search/knn", map[string]interface{}{"vector": testVector(0), "k": 0}},
+		"dimension": {"search/knn", map[string]interface{}{"query": []interface{}{1.0}}},
+		"role":      {"search/knn/nosearch", map[string]interface{}{"vector": testVector(0)}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      req.path,
+			Data:      req.data,
+			Storage:   s,
+		})
+		if err == nil && !resp.IsError() {
+			t.Errorf("%s: search succeeded, want error", name)
+		}
+	}
+}
+
+func TestSearchKNNAllVersions(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+		"id":     "old",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       3.0,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(3),
+		"id":     "new",
+	})
+
+	// The current version alone misses the migration's older half.
+	resp := testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector": testVector(1.5),
+	})
+	if hits := resp.Data["results"].([]searchHit); len(hits) != 1 || hits[0].ID != "new" {
+		t.Fatalf("results = %+v, want only new", hits)
+	}
+
+	// Corrected per version, the distances are comparable: old is nearer.
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"vector":       testVector(1.5),
+		"all_versions": true,
+	})
+	hits := resp.Data["results"].([]searchHit)
+	if len(hits) != 2 || hits[0].ID != "old" || hits[0].KeyVersion != 1 || hits[1].ID != "new" || hits[1].KeyVersion != 2 {
+		t.Fatalf("results = %+v, want old (version 1) then new (version 2)", hits)
+	}
+	if resp.Data["candidates"] != 2 || len(resp.Data["key_versions"].([]int)) != 2 {
+		t.Errorf("search = %v, want 2 candidates over 2 versions", resp.Data)
+	}
+	want := math.Sqrt(testDimension) * 0.5
+	if math.Abs(hits[0].Distance-want) > 1e-9 || math.Abs(hits[1].Distance-3*want) > 1e-9 {
+		t.Errorf("distances = %v, %v; want %v and %v", hits[0].Distance, hits[1].Distance, want, 3*want)
+	}
+
+	stored := testRequest(t, b, s, logical.ReadOperation, "ciphertext/new", nil)
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"query":        stored.Data["ciphertext"],
+		"all_versions": true,
+	}); err == nil {
+		t.Error("fanned out an encrypted query")
+	}
+}
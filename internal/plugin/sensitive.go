@@ -0,0 +1,37 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+// sensitivePaths are the path patterns of operations that destroy key
+// material or stored ciphertexts in bulk, or could exfiltrate key material:
+// rotation, export, purge and import, and others as they are added.
+//
+// Sensitive operations are built so they compose with Vault's approval
+// controls, which are configured in ACL policies rather than in the plugin:
+//   - Each lives on a dedicated path, so a policy can attach a control_group
+//     or mfa_methods stanza to exactly that operation.
+//   - They are registered as root paths, so callers also need the sudo
+//     capability.
+//   - They only accept create/update, never read or list, so a read-only
+//     policy cannot reach them.
+//   - They respond with JSON data, never a raw HTTP body. Control groups
+//     deliver the response through a response-wrapping token, and Vault
+//     cannot wrap raw bodies.
+//
+// New sensitive paths MUST be added here; TestSensitivePaths enforces the
+// rules above for every entry.
+var sensitivePaths = []string{
+	"config/rotate",
+	"config/root",
+	"config/compromise",
+	"config/ceremony/start",
+	"config/ceremony/contribute",
+	"config/split/export",
+	"config/split/factor",
+	"config/import",
+	"config/export",
+	"config/versions/delete",
+	"purge/ciphertext",
+	"erase/subject",
+}
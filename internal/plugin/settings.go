@@ -0,0 +1,768 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// settingsStoragePath is the Vault storage path for non-secret mount settings.
+	settingsStoragePath = "config/settings"
+
+	// repeatActionWarn adds a response warning when the repeat limit is exceeded.
+	repeatActionWarn = "warn"
+
+	// repeatActionRefuse rejects the request when the repeat limit is exceeded.
+	repeatActionRefuse = "refuse"
+
+	// clipPolicyError rejects ciphertexts with out-of-range components.
+	clipPolicyError = "error"
+
+	// clipPolicyClip saturates out-of-range components and adds a warning.
+	clipPolicyClip = "clip"
+
+	// outlierPolicyReject rejects plaintexts with outlier components.
+	outlierPolicyReject = "reject"
+
+	// outlierPolicyClip saturates outlier components at ±max_abs_input.
+	outlierPolicyClip = "clip"
+
+	// outlierPolicyScale scales the whole plaintext down until its largest
+	// component is max_abs_input, preserving its direction.
+	outlierPolicyScale = "scale"
+
+	// orthogonalityCheckFull computes QᵀQ in full when a matrix is
+	// generated; orthogonalityCheckSampled checks a random sample of it.
+	orthogonalityCheckFull    = "full"
+	orthogonalityCheckSampled = "sampled"
+
+	// defaultOrthogonalitySamples is the default number of column pairs
+	// checked in sampled mode.
+	defaultOrthogonalitySamples = 4096
+
+	// maxOrthogonalitySamples bounds orthogonality_samples.
+	maxOrthogonalitySamples = 1 << 20
+)
+
+// mountSettings holds operational, non-secret tunables for the mount.
+// Unlike rotationConfig, changing these never requires a key rotation.
+type mountSettings struct {
+	// RepeatLimit is the number of encryptions of the same plaintext allowed
+	// before RepeatAction applies. Zero disables tracking.
+	RepeatLimit  int    `json:"repeat_limit"`
+	RepeatAction string `json:"repeat_action"`
+
+	// MaxAbsOutput bounds the absolute value of every ciphertext component.
+	// Zero disables the bound; ClipPolicy decides what happens beyond it.
+	MaxAbsOutput float64 `json:"max_abs_output"`
+	ClipPolicy   string  `json:"clip_policy"`
+
+	// MaxAbsInput bounds the absolute value of every plaintext component.
+	// Zero disables the bound; OutlierPolicy decides what happens to the
+	// outlier components beyond it. See outlier.go.
+	MaxAbsInput   float64 `json:"max_abs_input"`
+	OutlierPolicy string  `json:"outlier_policy"`
+
+	// PoolStats enables buffer pool statistics (stats/pool and metrics).
+	PoolStats bool `json:"pool_stats"`
+
+	// WarmOnStartup generates the matrix in the background at mount or
+	// unseal instead of on the first request.
+	WarmOnStartup bool `json:"warm_on_startup"`
+
+	// StatsRetention is how long stored activity statistics are kept, in
+	// seconds. Zero keeps them forever.
+	StatsRetention int64 `json:"stats_retention"`
+
+	// CanaryRate is the probability, per batch item, of appending a canary
+	// ciphertext to the batch output. Zero disables canaries.
+	CanaryRate float64 `json:"canary_rate"`
+
+	// HardeningProfile selects a curated set of enforced safe defaults:
+	// "none" or "strict".
+	HardeningProfile string `json:"hardening_profile"`
+
+	// DefaultFormat is the encrypt/batch response format used when a
+	// request does not pass one: "json" or "ndjson".
+	DefaultFormat string `json:"default_format"`
+
+	// OutputPrecision is the ciphertext precision used when a request does
+	// not pass one: "float64" or "float32".
+	OutputPrecision string `json:"output_precision"`
+
+	// MemoryBudget is the memory, in bytes, the operator allots to this
+	// mount's matrices. capacity reports headroom against it. Zero means
+	// no budget.
+	MemoryBudget int64 `json:"memory_budget"`
+
+	// RequireExternalEntropy refuses to generate key material unless Vault
+	// offers the mount an external entropy source.
+	RequireExternalEntropy bool `json:"require_external_entropy"`
+
+	// CoalesceWindow is how long a single-vector request waits for others
+	// to share its matrix multiply. Zero disables coalescing. CoalesceMax
+	// pending requests flush the window early.
+	CoalesceWindow time.Duration `json:"coalesce_window"`
+	CoalesceMax    int           `json:"coalesce_max"`
+
+	// OrthogonalityCheck selects how generated matrices are validated:
+	// "full" or "sampled", which checks every column's norm and
+	// OrthogonalitySamples random column pairs.
+	OrthogonalityCheck   string `json:"orthogonality_check"`
+	OrthogonalitySamples int    `json:"orthogonality_samples"`
+
+	// WarmingWait is how long a request that needs a matrix another request
+	// is generating waits for it before failing with a retriable 503.
+	WarmingWait time.Duration `json:"warming_wait"`
+
+	// ShedHeapBytes, ShedGoroutines and ShedCgroupMemoryPercent are the
+	// resource pressure thresholds above which batch requests are refused
+	// with a retriable 503. Zero disables a threshold. See pressure.go.
+	ShedHeapBytes           int64 `json:"shed_heap_bytes"`
+	ShedGoroutines          int   `json:"shed_goroutines"`
+	ShedCgroupMemoryPercent int   `json:"shed_cgroup_memory_percent"`
+
+	// CacheMaxEntries and CacheMaxBytes bound the in-memory cache of
+	// derived and key version matrices; beyond either the least recently
+	// used is evicted. CacheMaxBytes 0 is no byte limit. See matrixlru.go.
+	CacheMaxEntries int   `json:"cache_max_entries"`
+	CacheMaxBytes   int64 `json:"cache_max_bytes"`
+
+	// Parallelism is the goroutines a request multiplies with: the rows of
+	// a single vector's multiply, or the vectors of a batch. 0 is
+	// GOMAXPROCS. See parallel.go.
+	Parallelism int `json:"parallelism"`
+}
+
+// defaultSettings returns the settings used when none have been stored.
+func defaultSettings() *mountSettings {
+	return &mountSettings{
+		RepeatLimit:  0,
+		RepeatAction: repeatActionWarn,
+		MaxAbsOutput: 0,
+		ClipPolicy:   clipPolicyError,
+
+		OutlierPolicy: outlierPolicyReject,
+
+		StatsRetention: int64(defaultStatsRetention / time.Second),
+
+		HardeningProfile: hardeningProfileNone,
+
+		DefaultFormat:   formatJSON,
+		OutputPrecision: precisionFloat64,
+
+		CoalesceMax: defaultCoalesceMax,
+
+		OrthogonalityCheck:   orthogonalityCheckFull,
+		OrthogonalitySamples: defaultOrthogonalitySamples,
+
+		WarmingWait: defaultWarmingWait,
+
+		CacheMaxEntries: defaultCacheMaxEntries,
+
+		Parallelism: defaultParallelism,
+	}
+}
+
+// pathSettings returns the path configuration for config/settings.
+func (b *vectorBackend) pathSettings() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/settings",
+			Fields: map[string]*framework.FieldSchema{
+				"repeat_limit": {
+					Type:        framework.TypeInt,
+					Description: "Encryptions of the same plaintext allowed per key before repeat_action applies (0 disables tracking).",
+				},
+				"repeat_action": {
+					Type:          framework.TypeString,
+					Description:   "Action when repeat_limit is exceeded: 'warn' or 'refuse'.",
+					AllowedValues: []interface{}{repeatActionWarn, repeatActionRefuse},
+				},
+				"max_abs_output": {
+					Type:        framework.TypeFloat,
+					Description: "Maximum absolute value of any ciphertext component (0 disables the bound).",
+				},
+				"clip_policy": {
+					Type:          framework.TypeString,
+					Description:   "Action when a component exceeds max_abs_output: 'error' or 'clip'.",
+					AllowedValues: []interface{}{clipPolicyError, clipPolicyClip},
+				},
+				"max_abs_input": {
+					Type:        framework.TypeFloat,
+					Description: "Maximum absolute value of any plaintext component (0 disables the bound).",
+				},
+				"outlier_policy": {
+					Type:          framework.TypeString,
+					Description:   "Action when a plaintext component exceeds max_abs_input: 'reject', 'clip' or 'scale'.",
+					AllowedValues: []interface{}{outlierPolicyReject, outlierPolicyClip, outlierPolicyScale},
+				},
+				"pool_stats": {
+					Type:        framework.TypeBool,
+					Description: "Collect buffer pool statistics (stats/pool and vector_dpe.pool.* metrics).",
+				},
+				"stats_retention": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long stored activity statistics are kept (0 keeps them forever). Default: 8760h.",
+				},
+				"canary_rate": {
+					Type:        framework.TypeFloat,
+					Description: "Probability per batch item of appending a canary ciphertext for leak detection (0 disables).",
+				},
+				"hardening_profile": {
+					Type:          framework.TypeString,
+					Description:   "Enforced safe defaults: 'none' or 'strict'.",
+					AllowedValues: []interface{}{hardeningProfileNone, hardeningProfileStrict},
+				},
+				"warm_on_startup": {
+					Type:        framework.TypeBool,
+					Description: "Generate the matrix, and those of roles with a fixed derivation_context, in the background at mount or unseal instead of on the first request.",
+				},
+				"default_format": {
+					Type:          framework.TypeString,
+					Description:   "encrypt/batch response format when the request passes none: 'json' or 'ndjson'.",
+					AllowedValues: []interface{}{formatJSON, formatNDJSON},
+				},
+				"output_precision": {
+					Type:          framework.TypeString,
+					Description:   "Ciphertext precision when the request passes none: 'float64' or 'float32'.",
+					AllowedValues: []interface{}{precisionFloat64, precisionFloat32},
+				},
+				"memory_budget": {
+					Type:        framework.TypeInt,
+					Description: "Memory in bytes allotted to this mount's matrices, for the capacity report (0 means no budget).",
+				},
+				"require_external_entropy": {
+					Type:        framework.TypeBool,
+					Description: "Refuse to generate keys unless Vault's entropy augmentation is available to the mount.",
+				},
+				"coalesce_window": {
+					Type:        framework.TypeString,
+					Description: "How long single-vector encryptions wait to be multiplied together, e.g. '2ms' (0 disables, max 50ms).",
+				},
+				"coalesce_max": {
+					Type:        framework.TypeInt,
+					Description: "Pending encryptions that end a coalescing window early. Default: 64.",
+				},
+				"orthogonality_check": {
+					Type:          framework.TypeString,
+					Description:   "Validation of generated matrices: 'full' (QᵀQ, O(d³)) or 'sampled' (column norms and random column pairs).",
+					AllowedValues: []interface{}{orthogonalityCheckFull, orthogonalityCheckSampled},
+				},
+				"orthogonality_samples": {
+					Type:        framework.TypeInt,
+					Description: "Column pairs checked in sampled mode. Default: 4096.",
+				},
+				"warming_wait": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("How long a request waits for another request generating the same matrix before failing with a retriable 503, e.g. '5s' (0 fails at once; default: %s, max %s).", defaultWarmingWait, maxWarmingWait),
+				},
+				"shed_heap_bytes": {
+					Type:        framework.TypeInt,
+					Description: "Heap size in bytes of the plugin process above which batch requests are shed with a retriable 503 (0 disables).",
+				},
+				"shed_goroutines": {
+					Type:        framework.TypeInt,
+					Description: "Goroutine count of the plugin process above which batch requests are shed with a retriable 503 (0 disables).",
+				},
+				"shed_cgroup_memory_percent": {
+					Type:        framework.TypeInt,
+					Description: "Percentage of the cgroup memory limit in use above which batch requests are shed with a retriable 503 (0 disables).",
+				},
+				"cache_max_entries": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Derived and key version matrices cached in memory per node before the least recently used is evicted (default: %d, max %d).", defaultCacheMaxEntries, maxCacheMaxEntries),
+				},
+				"cache_max_bytes": {
+					Type:        framework.TypeInt,
+					Description: "Total bytes of derived and key version matrices cached in memory per node before the least recently used is evicted (0: no limit).",
+				},
+				"parallelism": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Goroutines each request multiplies with: splitting the matrix rows of single-vector requests of dimension %d and up, and the vectors of encrypt/batch and encrypt/raw (0: GOMAXPROCS; default: %d, max %d).", 2*minParallelRows, defaultParallelism, maxParallelism),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSettingsRead,
+					Summary:  "Read the mount settings.",
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleSettingsWrite,
+					Summary:  "Configure the mount settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSettingsWrite,
+					Summary:  "Update the mount settings.",
+				},
+			},
+			ExistenceCheck:  b.settingsExists,
+			HelpSynopsis:    pathSettingsHelpSyn,
+			HelpDescription: pathSettingsHelpDesc,
+		},
+	}
+}
+
+// handleSettingsRead returns the effective mount settings.
+func (b *vectorBackend) handleSettingsRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: settings.responseData(),
+	}, nil
+}
+
+// handleSettingsWrite merges the supplied fields into the stored settings.
+// Fields that are not supplied keep their current value.
+func (b *vectorBackend) handleSettingsWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := settings.responseData()
+
+	if raw, ok := data.GetOk("repeat_limit"); ok {
+		settings.RepeatLimit = raw.(int)
+	}
+	if raw, ok := data.GetOk("repeat_action"); ok {
+		settings.RepeatAction = raw.(string)
+	}
+	if raw, ok := data.GetOk("max_abs_output"); ok {
+		maxAbs, err := coerceFloat(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_abs_output: %w", err)
+		}
+		settings.MaxAbsOutput = maxAbs
+	}
+	if raw, ok := data.GetOk("clip_policy"); ok {
+		settings.ClipPolicy = raw.(string)
+	}
+	if raw, ok := data.GetOk("max_abs_input"); ok {
+		maxAbs, err := coerceFloat(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_abs_input: %w", err)
+		}
+		settings.MaxAbsInput = maxAbs
+	}
+	if raw, ok := data.GetOk("outlier_policy"); ok {
+		settings.OutlierPolicy = raw.(string)
+	}
+	if raw, ok := data.GetOk("pool_stats"); ok {
+		settings.PoolStats = raw.(bool)
+	}
+	if raw, ok := data.GetOk("warm_on_startup"); ok {
+		settings.WarmOnStartup = raw.(bool)
+	}
+	if raw, ok := data.GetOk("stats_retention"); ok {
+		settings.StatsRetention = int64(raw.(int))
+	}
+	if raw, ok := data.GetOk("canary_rate"); ok {
+		rate, err := coerceFloat(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary_rate: %w", err)
+		}
+		settings.CanaryRate = rate
+	}
+	if raw, ok := data.GetOk("hardening_profile"); ok {
+		settings.HardeningProfile = raw.(string)
+	}
+	if raw, ok := data.GetOk("default_format"); ok {
+		settings.DefaultFormat = raw.(string)
+	}
+	if raw, ok := data.GetOk("output_precision"); ok {
+		settings.OutputPrecision = raw.(string)
+	}
+	if raw, ok := data.GetOk("memory_budget"); ok {
+		settings.MemoryBudget = int64(raw.(int))
+	}
+	if raw, ok := data.GetOk("require_external_entropy"); ok {
+		settings.RequireExternalEntropy = raw.(bool)
+	}
+	if raw, ok := data.GetOk("coalesce_window"); ok {
+		window, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid coalesce_window: %w", err)
+		}
+		settings.CoalesceWindow = window
+	}
+	if raw, ok := data.GetOk("coalesce_max"); ok {
+		settings.CoalesceMax = raw.(int)
+	}
+	if raw, ok := data.GetOk("orthogonality_check"); ok {
+		settings.OrthogonalityCheck = raw.(string)
+	}
+	if raw, ok := data.GetOk("orthogonality_samples"); ok {
+		settings.OrthogonalitySamples = raw.(int)
+	}
+	if raw, ok := data.GetOk("warming_wait"); ok {
+		wait, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid warming_wait: %w", err)
+		}
+		settings.WarmingWait = wait
+	}
+	if raw, ok := data.GetOk("shed_heap_bytes"); ok {
+		settings.ShedHeapBytes = int64(raw.(int))
+	}
+	if raw, ok := data.GetOk("shed_goroutines"); ok {
+		settings.ShedGoroutines = raw.(int)
+	}
+	if raw, ok := data.GetOk("shed_cgroup_memory_percent"); ok {
+		settings.ShedCgroupMemoryPercent = raw.(int)
+	}
+	if raw, ok := data.GetOk("cache_max_entries"); ok {
+		settings.CacheMaxEntries = raw.(int)
+	}
+	if raw, ok := data.GetOk("cache_max_bytes"); ok {
+		settings.CacheMaxBytes = int64(raw.(int))
+	}
+	if raw, ok := data.GetOk("parallelism"); ok {
+		settings.Parallelism = raw.(int)
+	}
+
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		// The current key must already comply; otherwise rotate first.
+		cfg, err := b.readConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != nil {
+			if err := checkStrictConfig(cfg); err != nil {
+				return nil, fmt.Errorf("current key does not comply, rotate it first: %w", err)
+			}
+		}
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, settingsStoragePath, settings); err != nil {
+		return nil, err
+	}
+
+	b.settingsLock.Lock()
+	b.cachedSettings = nil
+	b.settingsLock.Unlock()
+
+	if err := b.recordHistory(ctx, req, "settings", before, settings.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: settings.responseData(),
+	}, nil
+}
+
+// settingsExists checks if settings have been stored (for ExistenceCheck).
+func (b *vectorBackend) settingsExists(ctx context.Context, req *logical.Request, _ *framework.FieldData) (bool, error) {
+	entry, err := req.Storage.Get(ctx, settingsStoragePath)
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+// readSettings retrieves and validates the mount settings from storage,
+// falling back to defaults.
+func (b *vectorBackend) readSettings(ctx context.Context, storage logical.Storage) (*mountSettings, error) {
+	settings := defaultSettings()
+	if _, err := getStorageJSON(ctx, storage, settingsStoragePath, settings); err != nil {
+		return nil, err
+	}
+	if err := settings.validate(); err != nil {
+		return nil, fmt.Errorf("stored settings are invalid: %w", err)
+	}
+	return settings, nil
+}
+
+// getSettings returns the cached mount settings, loading them from storage on first use.
+// The returned value is shared and MUST NOT be modified by callers.
+func (b *vectorBackend) getSettings(ctx context.Context, storage logical.Storage) (*mountSettings, error) {
+	b.settingsLock.RLock()
+	if b.cachedSettings != nil {
+		settings := b.cachedSettings
+		b.settingsLock.RUnlock()
+		return settings, nil
+	}
+	b.settingsLock.RUnlock()
+
+	b.settingsLock.Lock()
+	defer b.settingsLock.Unlock()
+
+	if b.cachedSettings != nil {
+		return b.cachedSettings, nil
+	}
+
+	settings, err := b.readSettings(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	b.cachedSettings = settings
+	b.poolStats.enabled.Store(settings.PoolStats)
+	return settings, nil
+}
+
+// validate checks the settings for internal consistency.
+func (s *mountSettings) validate() error {
+	if s.RepeatLimit < 0 {
+		return fmt.Errorf("repeat_limit must be non-negative (got %d)", s.RepeatLimit)
+	}
+	switch s.RepeatAction {
+	case repeatActionWarn, repeatActionRefuse:
+	default:
+		return fmt.Errorf("repeat_action must be %q or %q (got %q)", repeatActionWarn, repeatActionRefuse, s.RepeatAction)
+	}
+	if s.MaxAbsOutput < 0 || math.IsNaN(s.MaxAbsOutput) || math.IsInf(s.MaxAbsOutput, 0) {
+		return fmt.Errorf("max_abs_output must be a non-negative finite number (got %v)", s.MaxAbsOutput)
+	}
+	switch s.ClipPolicy {
+	case clipPolicyError, clipPolicyClip:
+	default:
+		return fmt.Errorf("clip_policy must be %q or %q (got %q)", clipPolicyError, clipPolicyClip, s.ClipPolicy)
+	}
+	if s.MaxAbsInput < 0 || math.IsNaN(s.MaxAbsInput) || math.IsInf(s.MaxAbsInput, 0) {
+		return fmt.Errorf("max_abs_input must be a non-negative finite number (got %v)", s.MaxAbsInput)
+	}
+	switch s.OutlierPolicy {
+	case outlierPolicyReject, outlierPolicyClip, outlierPolicyScale:
+	default:
+		return fmt.Errorf("outlier_policy must be %q, %q or %q (got %q)",
+			outlierPolicyReject, outlierPolicyClip, outlierPolicyScale, s.OutlierPolicy)
+	}
+	if s.StatsRetention < 0 {
+		return fmt.Errorf("stats_retention must be non-negative (got %d)", s.StatsRetention)
+	}
+	if !(s.CanaryRate >= 0 && s.CanaryRate <= 1) {
+		return fmt.Errorf("canary_rate must be between 0 and 1 (got %v)", s.CanaryRate)
+	}
+	switch s.HardeningProfile {
+	case hardeningProfileNone, hardeningProfileStrict:
+	default:
+		return fmt.Errorf("hardening_profile must be %q or %q (got %q)", hardeningProfileNone, hardeningProfileStrict, s.HardeningProfile)
+	}
+	switch s.DefaultFormat {
+	case formatJSON, formatNDJSON:
+	default:
+		return fmt.Errorf("default_format must be %q or %q (got %q)", formatJSON, formatNDJSON, s.DefaultFormat)
+	}
+	if err := checkPrecision(s.OutputPrecision); err != nil {
+		return fmt.Errorf("output_precision %w", err)
+	}
+	if s.MemoryBudget < 0 {
+		return fmt.Errorf("memory_budget must be non-negative (got %d)", s.MemoryBudget)
+	}
+	if s.CoalesceWindow < 0 || s.CoalesceWindow > maxCoalesceWindow {
+		return fmt.Errorf("coalesce_window must be between 0 and %s (got %s)", maxCoalesceWindow, s.CoalesceWindow)
+	}
+	if s.CoalesceMax < 1 || s.CoalesceMax > maxCoalesceMax {
+		return fmt.Errorf("coalesce_max must be between 1 and %d (got %d)", maxCoalesceMax, s.CoalesceMax)
+	}
+	switch s.OrthogonalityCheck {
+	case orthogonalityCheckFull, orthogonalityCheckSampled:
+	default:
+		return fmt.Errorf("orthogonality_check must be %q or %q (got %q)", orthogonalityCheckFull, orthogonalityCheckSampled, s.OrthogonalityCheck)
+	}
+	if s.OrthogonalitySamples < 1 || s.OrthogonalitySamples > maxOrthogonalitySamples {
+		return fmt.Errorf("orthogonality_samples must be between 1 and %d (got %d)", maxOrthogonalitySamples, s.OrthogonalitySamples)
+	}
+	if s.WarmingWait < 0 || s.WarmingWait > maxWarmingWait {
+		return fmt.Errorf("warming_wait must be between 0 and %s (got %s)", maxWarmingWait, s.WarmingWait)
+	}
+	if s.ShedHeapBytes < 0 {
+		return fmt.Errorf("shed_heap_bytes must be non-negative (got %d)", s.ShedHeapBytes)
+	}
+	if s.ShedGoroutines < 0 {
+		return fmt.Errorf("shed_goroutines must be non-negative (got %d)", s.ShedGoroutines)
+	}
+	if s.ShedCgroupMemoryPercent < 0 || s.ShedCgroupMemoryPercent > 100 {
+		return fmt.Errorf("shed_cgroup_memory_percent must be between 0 and 100 (got %d)", s.ShedCgroupMemoryPercent)
+	}
+	if s.CacheMaxEntries < 1 || s.CacheMaxEntries > maxCacheMaxEntries {
+		return fmt.Errorf("cache_max_entries must be between 1 and %d (got %d)", maxCacheMaxEntries, s.CacheMaxEntries)
+	}
+	if s.CacheMaxBytes < 0 {
+		return fmt.Errorf("cache_max_bytes must be non-negative (got %d)", s.CacheMaxBytes)
+	}
+	if s.Parallelism < 0 || s.Parallelism > maxParallelism {
+		return fmt.Errorf("parallelism must be between 0 and %d (got %d)", maxParallelism, s.Parallelism)
+	}
+	return nil
+}
+
+// responseData renders the settings for API responses.
+func (s *mountSettings) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"repeat_limit":    s.RepeatLimit,
+		"repeat_action":   s.RepeatAction,
+		"max_abs_output":  s.MaxAbsOutput,
+		"clip_policy":     s.ClipPolicy,
+		"max_abs_input":   s.MaxAbsInput,
+		"outlier_policy":  s.OutlierPolicy,
+		"pool_stats":      s.PoolStats,
+		"warm_on_startup": s.WarmOnStartup,
+		"stats_retention": s.StatsRetention,
+		"canary_rate":     s.CanaryRate,
+
+		"hardening_profile": s.HardeningProfile,
+
+		"default_format":   s.DefaultFormat,
+		"output_precision": s.OutputPrecision,
+
+		"memory_budget": s.MemoryBudget,
+
+		"require_external_entropy": s.RequireExternalEntropy,
+
+		"coalesce_window": s.CoalesceWindow.String(),
+		"coalesce_max":    s.CoalesceMax,
+
+		"orthogonality_check":   s.OrthogonalityCheck,
+		"orthogonality_samples": s.OrthogonalitySamples,
+
+		"warming_wait": s.WarmingWait.String(),
+
+		"shed_heap_bytes":            s.ShedHeapBytes,
+		"shed_goroutines":            s.ShedGoroutines,
+		"shed_cgroup_memory_percent": s.ShedCgroupMemoryPercent,
+
+		"cache_max_entries": s.CacheMaxEntries,
+		"cache_max_bytes":   s.CacheMaxBytes,
+
+		"parallelism": s.Parallelism,
+	}
+}
+
+// Help text constants for the settings path.
+const pathSettingsHelpSyn = `Configure operational settings for the mount.`
+
+const pathSettingsHelpDesc = `
+This endpoint manages non-secret, operational settings. Changing them never
+rotates the key or invalidates previously encrypted vectors.
+
+Parameters:
+  repeat_limit  - Encryptions of the same plaintext allowed per key before
+                  repeat_action applies (default: 0, disabled)
+  repeat_action - 'warn' (add a response warning) or 'refuse' (reject the
+                  request) once repeat_limit is exceeded (default: warn)
+
+  max_abs_output - Maximum absolute value of any ciphertext component
+                   (default: 0, disabled)
+  clip_policy    - 'error' (reject the request) or 'clip' (saturate the
+                   component at ±max_abs_output and add a warning) when a
+                   component is out of range (default: error)
+
+  max_abs_input  - Maximum absolute value of any plaintext component; set
+                   it from the corpus distribution (default: 0, disabled)
+  outlier_policy - What happens to a plaintext with outlier components
+                   beyond max_abs_input (default: reject):
+                     reject - refuse the vector
+                     clip   - saturate the outliers at ±max_abs_input
+                     scale  - scale the whole vector down so its largest
+                              component is max_abs_input, keeping its
+                              direction; input_scale reports the factor
+                   Responses report outlier_components and add a warning.
+
+  pool_stats     - Collect buffer pool statistics, readable at stats/pool
+                   and emitted as vector_dpe.pool.* metrics (default: false)
+
+  warm_on_startup - Generate the matrix in the background when the mount is
+                    initialized (mount, unseal, plugin reload) instead of
+                    on the first request, then the derived matrices of
+                    roles with a fixed derivation_context (default: false)
+
+  stats_retention - How long stats/activity buckets are kept; older ones
+                    are deleted by an hourly sweep (default: 8760h, 0
+                    keeps them forever)
+
+  canary_rate     - Probability per batch item of appending a canary
+                    ciphertext to encrypt/batch output, for leak detection
+                    with verify/canary (default: 0, disabled)
+
+  hardening_profile - 'none' or 'strict' (default: none). Strict enforces:
+                        dimension at most 4096; a noise radius of at least
+                        0.25 × scaling_factor, so deterministic (noiseless)
+                        encryption is impossible; vector elements as
+                        numbers, never strings; no debug endpoints; and
+                        no key export (config/export,
+                        config/split/export).
+                      It can only be enabled while the current key complies.
+
+  default_format   - encrypt/batch response format used when a request
+                     passes no 'format': 'json' or 'ndjson' (default: json)
+  output_precision - Ciphertext precision used when a request passes no
+                     'precision': 'float64' or 'float32' (default: float64).
+                     float32 rounds each component, matching what most
+                     vector stores keep, and shortens JSON responses.
+
+  memory_budget    - Memory in bytes allotted to this mount's matrices.
+                     capacity reports headroom against it and the largest
+                     dimension that still fits (default: 0, no budget)
+
+  require_external_entropy - Refuse to rotate unless Vault's entropy
+                     augmentation (Enterprise, external_entropy_access on
+                     the mount) is available (default: false)
+
+  coalesce_window  - How long an encrypt/vector or rerandomize/vector
+                     request waits for others under the same key, so their
+                     rotations run as one matrix-matrix multiply, e.g. 2ms
+                     (default: 0, disabled; max 50ms)
+  coalesce_max     - Pending requests that end a window early (default: 64)
+
+  orthogonality_check   - How generated matrices are validated: 'full'
+                          computes QᵀQ, O(d³); 'sampled' checks every
+                          column's norm and a random sample of column
+                          pairs, O(d²), to a tighter tolerance. Sampled
+                          results are never certified, and strict mounts
+                          always use full (default: full)
+  orthogonality_samples - Column pairs checked in sampled mode
+                          (default: 4096)
+
+  warming_wait     - How long a request waits when another request is
+                     generating the matrix it needs. Only one request
+                     generates each matrix; the others wait, and fail with
+                     a retriable 503 after warming_wait, e.g. 5s (default:
+                     30s, max 60s; 0 fails at once)
+
+  shed_heap_bytes            - Heap size of the plugin process, in bytes,
+  shed_goroutines            - goroutine count of the plugin process, and
+  shed_cgroup_memory_percent - share of the cgroup memory limit in use,
+                               above which encrypt/batch, encrypt/queries,
+                               encrypt/raw and upload/part are refused
+                               with a retriable 503 (default: 0, disabled).
+                               The plugin shares Vault's cgroup, so the
+                               cgroup threshold also counts Vault's memory.
+                               Single-vector requests are never shed.
+
+  cache_max_entries - Derived and key version matrices cached in memory
+  cache_max_bytes     per node, and their total size in bytes, beyond
+                      which the least recently used is evicted and zeroed
+                      (default: 64 entries, no byte limit; max 4096
+                      entries). The current key's matrix is not counted.
+                      New limits apply as matrices are added; see
+                      cache/status
+
+  parallelism - Goroutines each request multiplies with (default: 1;
+                0 is GOMAXPROCS; max 256). Single-vector requests split
+                the rows of the multiply, from dimension 1024 up and
+                without a coalesce_window; the result is bit-identical.
+                encrypt/batch and encrypt/raw spread their vectors
+                instead. The compute worker does not split rows, and
+                its GOMAXPROCS bounds the batch vectors it multiplies
+
+Clipping alters distances for the affected vectors. Use config/fit-scale to
+pick a scaling factor that keeps clipping rare.
+
+Repeated encryptions of the same plaintext allow an attacker to average
+away the noise. When repeat_limit is set, the plugin counts encryptions per
+plaintext using an HMAC fingerprint in a fixed-size count-min sketch. The
+counts are kept in memory only, are per Vault node, and reset on rotation.
+Count-min sketches never undercount but may overcount under heavy traffic.
+`
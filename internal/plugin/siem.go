@@ -0,0 +1,707 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// siemStoragePath is the Vault storage path for the SIEM exporter.
+	siemStoragePath = "config/siem"
+
+	// integrationSIEM names the exporter in stats/outbound.
+	integrationSIEM = "siem"
+
+	// siemQueueSize bounds the events waiting to be forwarded. Events
+	// emitted while the queue is full are dropped, so a slow SIEM never
+	// holds up requests.
+	siemQueueSize = 1024
+
+	// SIEM record formats.
+	siemFormatJSON = "json"
+	siemFormatCEF  = "cef"
+
+	// syslogFacilityAuthPriv is the syslog facility of forwarded events,
+	// security/authorization messages.
+	syslogFacilityAuthPriv = 10
+
+	// syslogAppName is the APP-NAME of forwarded syslog messages and the
+	// device product of CEF records.
+	syslogAppName = "vault-vector-dpe"
+)
+
+// siemMetricPrefix is the metric name prefix of the SIEM exporter.
+var siemMetricPrefix = []string{"vector_dpe", "siem"}
+
+// Syslog severities of forwarded events.
+const (
+	syslogCritical = 2
+	syslogWarning  = 4
+	syslogNotice   = 5
+)
+
+// siemSensitiveEvents are the event types forwarded at warning severity:
+// key material leaving or entering the mount, and stored data destroyed.
+var siemSensitiveEvents = map[string]bool{
+	"key-exported":          true,
+	"key-imported":          true,
+	"key-disabled":          true,
+	"key-version-deleted":   true,
+	"split-factor-exported": true,
+	"ciphertexts-purged":    true,
+	"subject-erased":        true,
+}
+
+// siemConfig holds the SIEM exporter settings.
+type siemConfig struct {
+	// URL is an http(s) endpoint that receives one POST per event, or a
+	// syslog collector as syslog+tcp://, syslog+tls:// or syslog+udp://
+	// host:port.
+	URL    string `json:"url"`
+	Token  string `json:"token,omitempty"`
+	CACert string `json:"ca_cert,omitempty"`
+	Format string `json:"format"`
+
+	// Events lists the event types to forward, each exact or ending in
+	// "*" to match a prefix; empty forwards every event.
+	Events []string `json:"events,omitempty"`
+
+	// Timeout, when zero, is the config/outbound timeout.
+	Timeout time.Duration `json:"timeout"`
+
+	// Mount is the mount path recorded in every event, taken from the
+	// request that configured the exporter.
+	Mount string `json:"mount"`
+}
+
+// responseData renders the exporter settings for API responses. The token
+// is never returned.
+func (c *siemConfig) responseData() map[string]interface{} {
+	events := c.Events
+	if events == nil {
+		events = []string{}
+	}
+	return map[string]interface{}{
+		"url":       c.URL,
+		"token_set": c.Token != "",
+		"ca_cert":   c.CACert,
+		"format":    c.Format,
+		"events":    events,
+		"timeout":   int64(c.Timeout.Seconds()),
+		"mount":     c.Mount,
+	}
+}
+
+// validate checks the exporter settings before they are stored.
+func (c *siemConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s), syslog+tcp, syslog+tls or syslog+udp URL")
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "syslog+tcp", "syslog+tls", "syslog+udp":
+		if u.Port() == "" {
+			return fmt.Errorf("url must include the syslog port")
+		}
+		if c.Token != "" {
+			return fmt.Errorf("token is only sent to http(s) endpoints")
+		}
+	default:
+		return fmt.Errorf("url must be an absolute http(s), syslog+tcp, syslog+tls or syslog+udp URL")
+	}
+	if c.Format != siemFormatJSON && c.Format != siemFormatCEF {
+		return fmt.Errorf("format must be %q or %q", siemFormatJSON, siemFormatCEF)
+	}
+	for _, pattern := range c.Events {
+		if strings.TrimSuffix(pattern, "*") == "" && pattern != "*" {
+			return fmt.Errorf("events must not contain empty entries")
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// wants reports whether events of eventType, without the prefix, are
+// forwarded.
+func (c *siemConfig) wants(eventType string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, pattern := range c.Events {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(eventType, prefix) {
+				return true
+			}
+		} else if pattern == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// siemEvent is an event as forwarded to the SIEM.
+type siemEvent struct {
+	Time      time.Time         `json:"time"`
+	EventType string            `json:"event_type"`
+	Mount     string            `json:"mount,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// newSIEMEvent builds the record of an event of eventType, without the
+// prefix, from its metadata pairs.
+func newSIEMEvent(now time.Time, mount, eventType string, metadataPairs []string) *siemEvent {
+	event := &siemEvent{
+		Time:      now.UTC(),
+		EventType: eventTypePrefix + eventType,
+		Mount:     mount,
+	}
+	if len(metadataPairs) > 0 {
+		event.Metadata = make(map[string]string, len(metadataPairs)/2)
+		for i := 0; i+1 < len(metadataPairs); i += 2 {
+			event.Metadata[metadataPairs[i]] = metadataPairs[i+1]
+		}
+	}
+	return event
+}
+
+// severity returns the syslog severity of the event.
+func (e *siemEvent) severity() int {
+	eventType := strings.TrimPrefix(e.EventType, eventTypePrefix)
+	switch {
+	case strings.HasPrefix(eventType, "key-compromise"):
+		return syslogCritical
+	case siemSensitiveEvents[eventType]:
+		return syslogWarning
+	default:
+		return syslogNotice
+	}
+}
+
+// encode renders the event in format: a JSON object, or an ArcSight CEF
+// record whose extension holds rt, the mount as cs1 and the metadata.
+func (e *siemEvent) encode(format string) ([]byte, error) {
+	if format == siemFormatJSON {
+		return json.Marshal(e)
+	}
+
+	// CEF severity runs from 0 to 10; map critical, warning and notice
+	// onto 9, 6 and 3.
+	cefSeverity := 3
+	switch e.severity() {
+	case syslogCritical:
+		cefSeverity = 9
+	case syslogWarning:
+		cefSeverity = 6
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CEF:0|%s|%s|1|%s|%s|%d|rt=%d",
+		cefHeader(syslogAppName), cefHeader(syslogAppName),
+		cefHeader(e.EventType), cefHeader(e.EventType), cefSeverity, e.Time.UnixMilli())
+	if e.Mount != "" {
+		fmt.Fprintf(&buf, " cs1Label=mount cs1=%s", cefValue(e.Mount))
+	}
+	keys := make([]string, 0, len(e.Metadata))
+	for k := range e.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, " %s=%s", cefKey(k), cefValue(e.Metadata[k]))
+	}
+	return buf.Bytes(), nil
+}
+
+// cefHeader escapes a CEF header field.
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(s)
+}
+
+// cefValue escapes a CEF extension value.
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
+
+// cefKey reduces a metadata key to the characters CEF allows in extension
+// keys.
+func cefKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// siemExporter forwards queued events to the SIEM from a single worker.
+type siemExporter struct {
+	cfg       *siemConfig
+	client    *http.Client
+	tlsConfig *tls.Config
+	hostname  string
+
+	queue chan *siemEvent
+	done  chan struct{}
+
+	// conn is the open syslog connection, used only by the worker.
+	conn net.Conn
+
+	// call runs each delivery under the outbound retry policy and circuit
+	// breaker; nil runs it directly.
+	call func(context.Context, func(context.Context) error) error
+}
+
+// newSIEMExporter builds the exporter for cfg under the mount's outbound
+// settings, which may be nil. Its worker is not started.
+func newSIEMExporter(cfg *siemConfig, outbound *outboundConfig) (*siemExporter, error) {
+	client, err := outbound.httpClient(cfg.CACert, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := outbound.tlsConfig(cfg.CACert)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &siemExporter{
+		cfg:       cfg,
+		client:    client,
+		tlsConfig: tlsConfig,
+		hostname:  hostname,
+		queue:     make(chan *siemEvent, siemQueueSize),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// deliver sends one event under e.call.
+func (e *siemExporter) deliver(ctx context.Context, event *siemEvent) error {
+	send := func(ctx context.Context) error {
+		if strings.HasPrefix(e.cfg.URL, "syslog+") {
+			return e.sendSyslog(event)
+		}
+		return e.post(ctx, event)
+	}
+	if e.call == nil {
+		return send(ctx)
+	}
+	return e.call(ctx, send)
+}
+
+// post sends the event as the body of a POST to the configured URL. Any
+// status other than 2xx is an error.
+func (e *siemExporter) post(ctx context.Context, event *siemEvent) error {
+	body, err := event.encode(e.cfg.Format)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if e.cfg.Format == siemFormatJSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("siem: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("siem: %w", &outboundStatusError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		})
+	}
+	return nil
+}
+
+// sendSyslog writes the event as an RFC 5424 message, octet-counted
+// (RFC 6587) over TCP and TLS, one datagram over UDP. The connection is
+// kept open between events and redialed after a failure.
+func (e *siemExporter) sendSyslog(event *siemEvent) error {
+	body, err := event.encode(e.cfg.Format)
+	if err != nil {
+		return err
+	}
+	msgID := strings.TrimPrefix(event.EventType, eventTypePrefix)
+	if len(msgID) > 32 {
+		msgID = msgID[:32]
+	}
+	message := fmt.Sprintf("<%d>1 %s %s %s - %s - %s",
+		syslogFacilityAuthPriv*8+event.severity(), event.Time.Format(time.RFC3339Nano),
+		e.hostname, syslogAppName, msgID, body)
+
+	u, err := url.Parse(e.cfg.URL)
+	if err != nil {
+		return err
+	}
+	if e.conn == nil {
+		dialer := &net.Dialer{Timeout: e.client.Timeout}
+		switch u.Scheme {
+		case "syslog+tls":
+			tlsConfig := e.tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+			e.conn, err = tls.DialWithDialer(dialer, "tcp", u.Host, tlsConfig)
+		case "syslog+udp":
+			e.conn, err = dialer.Dial("udp", u.Host)
+		default:
+			e.conn, err = dialer.Dial("tcp", u.Host)
+		}
+		if err != nil {
+			e.conn = nil
+			return fmt.Errorf("siem: %w", err)
+		}
+	}
+
+	frame := message
+	if u.Scheme != "syslog+udp" {
+		frame = strconv.Itoa(len(message)) + " " + message
+	}
+	if err := e.conn.SetWriteDeadline(time.Now().Add(e.client.Timeout)); err == nil {
+		_, err = io.WriteString(e.conn, frame)
+	}
+	if err != nil {
+		e.closeConn()
+		return fmt.Errorf("siem: %w", err)
+	}
+	return nil
+}
+
+// closeConn closes the syslog connection, if open.
+func (e *siemExporter) closeConn() {
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// run forwards queued events until the queue is closed, then closes done.
+// Failed deliveries are logged and dropped; the SIEM exporter is not a
+// durable log, Vault's audit devices are.
+func (b *vectorBackend) runSIEM(e *siemExporter) {
+	defer close(e.done)
+	defer e.closeConn()
+	for event := range e.queue {
+		if err := e.deliver(context.Background(), event); err != nil {
+			metrics.IncrCounter(append(siemMetricPrefix, "failed"), 1)
+			b.Logger().Warn("failed to forward event to SIEM", "event_type", event.EventType, "error", err)
+			continue
+		}
+		metrics.IncrCounter(append(siemMetricPrefix, "sent"), 1)
+	}
+}
+
+// forwardSIEM queues an event for the SIEM exporter, if one is configured
+// and wants it. emitEvent calls it for every event; the mount's storage is
+// used since events carry no request.
+func (b *vectorBackend) forwardSIEM(ctx context.Context, eventType string, metadataPairs []string) {
+	if b.storage == nil {
+		return
+	}
+	exporter, err := b.getSIEMExporter(ctx, b.storage)
+	if err != nil {
+		b.Logger().Warn("failed to load SIEM exporter", "error", err)
+		return
+	}
+	if exporter == nil || !exporter.cfg.wants(eventType) {
+		return
+	}
+	event := newSIEMEvent(time.Now(), exporter.cfg.Mount, eventType, metadataPairs)
+
+	// The queue is closed under the write lock, so only send to it while
+	// it is still the current exporter's.
+	b.siemLock.RLock()
+	defer b.siemLock.RUnlock()
+	if b.siemExporter != exporter {
+		return
+	}
+	select {
+	case exporter.queue <- event:
+	default:
+		metrics.IncrCounter(append(siemMetricPrefix, "dropped"), 1)
+		b.Logger().Warn("SIEM queue full; event dropped", "event_type", event.EventType)
+	}
+}
+
+// getSIEMExporter returns the configured SIEM exporter, or nil if there is
+// none, building it and starting its worker on first use. It follows the
+// same Check-Lock-Check pattern as getWebhookSink.
+func (b *vectorBackend) getSIEMExporter(ctx context.Context, storage logical.Storage) (*siemExporter, error) {
+	b.siemLock.RLock()
+	if b.siemLoaded {
+		exporter := b.siemExporter
+		b.siemLock.RUnlock()
+		return exporter, nil
+	}
+	b.siemLock.RUnlock()
+
+	b.siemLock.Lock()
+	defer b.siemLock.Unlock()
+
+	if b.siemLoaded {
+		return b.siemExporter, nil
+	}
+	cfg, err := readSIEMConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	var exporter *siemExporter
+	if cfg != nil {
+		outbound, err := readOutboundConfig(ctx, storage)
+		if err != nil {
+			return nil, err
+		}
+		if exporter, err = newSIEMExporter(cfg, outbound); err != nil {
+			return nil, err
+		}
+		exporter.call = func(ctx context.Context, fn func(context.Context) error) error {
+			return b.callOutbound(ctx, storage, integrationSIEM, fn)
+		}
+		go b.runSIEM(exporter)
+	}
+	b.siemExporter, b.siemLoaded = exporter, true
+	return exporter, nil
+}
+
+// resetSIEM drops the cached SIEM exporter so the next event rereads
+// config/siem. The old exporter's worker delivers the events already
+// queued and exits; the returned channel, nil without an exporter, is
+// closed when it has.
+func (b *vectorBackend) resetSIEM() <-chan struct{} {
+	b.siemLock.Lock()
+	defer b.siemLock.Unlock()
+	exporter := b.siemExporter
+	b.siemExporter, b.siemLoaded = nil, false
+	if exporter == nil {
+		return nil
+	}
+	close(exporter.queue)
+	return exporter.done
+}
+
+// pathSIEM returns the path configuration for config/siem.
+func (b *vectorBackend) pathSIEM() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/siem",
+			Fields: map[string]*framework.FieldSchema{
+				"url": {
+					Type:        framework.TypeString,
+					Description: "SIEM endpoint: an http(s) URL that receives one POST per event, or syslog+tcp://, syslog+tls:// or syslog+udp://host:port.",
+				},
+				"token": {
+					Type:        framework.TypeString,
+					Description: "Bearer token sent to an http(s) endpoint. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded CA certificate for the endpoint, trusted with those of config/outbound. Defaults to the system roots.",
+				},
+				"format": {
+					Type:        framework.TypeString,
+					Description: "Record format: json or cef.",
+					Default:     siemFormatJSON,
+				},
+				"events": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Event types to forward, without the vector-dpe/ prefix; an entry ending in * matches a prefix. Empty forwards every event.",
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Timeout of each delivery (default: the config/outbound timeout).",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSIEMRead,
+					Summary:  "Read the SIEM exporter settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSIEMWrite,
+					Summary:  "Forward key lifecycle and sensitive-operation events to a SIEM.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleSIEMDelete,
+					Summary:  "Stop forwarding events to the SIEM.",
+				},
+			},
+			HelpSynopsis:    pathSIEMHelpSyn,
+			HelpDescription: pathSIEMHelpDesc,
+		},
+	}
+}
+
+// handleSIEMRead returns the SIEM exporter settings, without the token.
+func (b *vectorBackend) handleSIEMRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSIEMConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleSIEMWrite merges the supplied fields into the stored exporter
+// settings.
+func (b *vectorBackend) handleSIEMWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSIEMConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = &siemConfig{Format: siemFormatJSON}
+	}
+
+	if raw, ok := data.GetOk("url"); ok {
+		cfg.URL = raw.(string)
+	}
+	if raw, ok := data.GetOk("token"); ok {
+		cfg.Token = raw.(string)
+	}
+	if raw, ok := data.GetOk("ca_cert"); ok {
+		cfg.CACert = raw.(string)
+	}
+	if raw, ok := data.GetOk("format"); ok {
+		cfg.Format = strings.ToLower(raw.(string))
+	}
+	if raw, ok := data.GetOk("events"); ok {
+		cfg.Events = raw.([]string)
+	}
+	if raw, ok := data.GetOk("timeout"); ok {
+		cfg.Timeout = time.Duration(raw.(int)) * time.Second
+	}
+	cfg.Mount = req.MountPoint
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Build the exporter once now so a bad CA certificate fails the write.
+	outbound, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newSIEMExporter(cfg, outbound); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, siemStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	b.resetSIEM()
+	if err := b.recordHistory(ctx, req, "siem-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleSIEMDelete removes the SIEM exporter settings.
+func (b *vectorBackend) handleSIEMDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSIEMConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, siemStoragePath); err != nil {
+		return nil, err
+	}
+	b.resetSIEM()
+	if cfg == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "siem-delete", cfg.responseData(), map[string]interface{}{})
+}
+
+// readSIEMConfig retrieves the SIEM exporter settings, or nil if unset.
+func readSIEMConfig(ctx context.Context, storage logical.Storage) (*siemConfig, error) {
+	var cfg siemConfig
+	found, err := getStorageJSON(ctx, storage, siemStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// Help text constants for the SIEM path.
+const pathSIEMHelpSyn = `Forward key lifecycle and sensitive-operation events to a SIEM.`
+
+const pathSIEMHelpDesc = `
+Sends every event the plugin emits (see the Vault event types below) to a
+SIEM, over HTTPS or syslog, as JSON or ArcSight CEF. Unlike an audit
+device, which records every request to every mount of the cluster, this
+forwards only this mount's key lifecycle and sensitive operations, already
+named, so a SOC can ingest them per mount.
+
+Events are queued and delivered in the background by each node, under the
+retry policy and circuit breaker of config/outbound; see stats/outbound.
+Delivery is best-effort: events that cannot be delivered, or that arrive
+while 1024 are queued, are logged and dropped. Keep an audit device as the
+record of what happened.
+
+Parameters:
+  url     - https://... (one POST per event, 2xx expected), or
+            syslog+tcp://, syslog+tls:// or syslog+udp://host:port
+            (RFC 5424, octet-counted over TCP and TLS, facility authpriv)
+  token   - Sent as "Authorization: Bearer <token>" to http(s) endpoints
+            (write-only)
+  ca_cert - PEM CA certificate for the endpoint (default: system roots)
+  format  - json (default) or cef
+  events  - Event types to forward, e.g. key-*,ciphertexts-purged
+            (default: all)
+  timeout - Timeout of each delivery (default: the config/outbound
+            timeout, 10s unless set)
+
+Events include key-rotated, key-imported, key-exported, key-disabled,
+key-enabled, key-version-deleted, key-compromised,
+key-compromise-complete, split-factor-exported, ciphertexts-purged,
+subject-erased, ceremony-started, ceremony-complete, approval-requested,
+approval-granted and circuit-open. Each record carries the time, the event
+type, the mount and the event's metadata, including the identity of the
+caller where there is one.
+
+Example:
+  vault write vector/config/siem url=syslog+tls://siem.internal:6514 \
+      format=cef ca_cert=@siem-ca.pem
+`
@@ -0,0 +1,177 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// waitSIEM returns the next record received, failing the test after a
+// few seconds.
+func waitSIEM(t *testing.T, received <-chan string) string {
+	t.Helper()
+	select {
+	case record := <-received:
+		return record
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event reached the SIEM")
+		return ""
+	}
+}
+
+func TestSIEMExportHTTPJSON(t *testing.T) {
+	b, s := getTestBackend(t)
+	received := make(chan string, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer soc-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	t.Cleanup(server.Close)
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/siem", map[string]interface{}{
+		"url":    server.URL,
+		"token":  "soc-token",
+		"events": "key-*,ciphertexts-purged",
+	})
+	if _, ok := resp.Data["token"]; ok || resp.Data["token_set"] != true {
+		t.Fatalf("token returned or not recorded: %v", resp.Data)
+	}
+	if resp.Data["format"] != siemFormatJSON {
+		t.Fatalf("format = %v", resp.Data["format"])
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	var event siemEvent
+	if err := json.Unmarshal([]byte(waitSIEM(t, received)), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "vector-dpe/key-rotated" || event.Metadata["key_version"] != "1" || event.Time.IsZero() {
+		t.Errorf("event = %+v", event)
+	}
+
+	// Events outside the filter are not forwarded.
+	b.emitEvent(context.Background(), "ceremony-started", "ceremony_id", "c1")
+	b.emitEvent(context.Background(), "key-exported", "key_id", "k1")
+	if err := json.Unmarshal([]byte(waitSIEM(t, received)), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "vector-dpe/key-exported" || event.Metadata["key_id"] != "k1" {
+		t.Errorf("event = %+v", event)
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, "config/siem", nil)
+	b.emitEvent(context.Background(), "key-exported", "key_id", "k2")
+	select {
+	case record := <-received:
+		t.Errorf("event forwarded after config/siem was deleted: %s", record)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSIEMExportSyslogCEF(t *testing.T) {
+	b, s := getTestBackend(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	received := make(chan string, 16)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			// Octet counting: "<length> <message>".
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil {
+				return
+			}
+			message := make([]byte, n)
+			if _, err := io.ReadFull(r, message); err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	}()
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/siem", map[string]interface{}{
+		"url":    "syslog+tcp://" + lis.Addr().String(),
+		"format": "CEF",
+	})
+	b.emitEvent(context.Background(), "key-compromised", "incident_id", "i=1", "previous_key_id", "k|1")
+	b.emitEvent(context.Background(), "ceremony-started", "ceremony_id", "c1")
+
+	message := waitSIEM(t, received)
+	// authpriv (10) * 8 + critical (2)
+	if !strings.HasPrefix(message, "<82>1 ") || !strings.Contains(message, " vault-vector-dpe - key-compromised - ") {
+		t.Errorf("syslog header: %q", message)
+	}
+	if !strings.Contains(message, "CEF:0|vault-vector-dpe|vault-vector-dpe|1|vector-dpe/key-compromised|vector-dpe/key-compromised|9|rt=") ||
+		!strings.Contains(message, ` incident_id=i\=1 previous_key_id=k|1`) {
+		t.Errorf("CEF record: %q", message)
+	}
+	if message = waitSIEM(t, received); !strings.HasPrefix(message, "<85>1 ") || !strings.Contains(message, "|3|rt=") {
+		t.Errorf("notice event: %q", message)
+	}
+}
+
+func TestSIEMConfigValidate(t *testing.T) {
+	b, s := getTestBackend(t)
+	for name, data := range map[string]map[string]interface{}{
+		"missing url":         {"format": "json"},
+		"unknown scheme":      {"url": "ftp://siem.internal"},
+		"syslog without port": {"url": "syslog+udp://siem.internal"},
+		"token over syslog":   {"url": "syslog+tls://siem.internal:6514", "token": "t"},
+		"unknown format":      {"url": "https://siem.internal/events", "format": "leef"},
+		"empty event":         {"url": "https://siem.internal/events", "events": "*,,"},
+	} {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/siem",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg := &siemConfig{Events: []string{"key-*", "subject-erased"}}
+	for eventType, want := range map[string]bool{
+		"key-exported":     true,
+		"key-compromised":  true,
+		"subject-erased":   true,
+		"subject-erased-2": false,
+		"ceremony-started": false,
+	} {
+		if got := cfg.wants(eventType); got != want {
+			t.Errorf("wants(%q) = %v", eventType, got)
+		}
+	}
+}
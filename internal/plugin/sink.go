@@ -0,0 +1,591 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// sinkStoragePath is the Vault storage path for the external sink.
+	sinkStoragePath = "config/sink"
+
+	// maxSinkResponseBytes bounds the webhook responses read.
+	maxSinkResponseBytes = 16 << 20
+)
+
+// Sink is a destination for the ciphertexts encrypt stores with 'id' or
+// 'ids'. The mount itself is the built-in sink; webhookSink forwards to an
+// adapter in front of any other database. Implementations must make each
+// method idempotent, since failed requests are retried.
+type Sink interface {
+	// Upsert stores records, replacing any with the same ID.
+	Upsert(ctx context.Context, records []sinkRecord) error
+
+	// Query returns up to q.K records of q.KeyID nearest to q.Vector,
+	// with their EncryptedDistance set, and the number of candidates.
+	Query(ctx context.Context, q sinkQuery) ([]searchHit, int, error)
+
+	// Delete removes the records with the given IDs; unknown IDs are
+	// not an error.
+	Delete(ctx context.Context, ids []string) error
+
+	// Rotate prepares the sink for a new key version, before the key is
+	// installed, so that no record of the version reaches it unprepared.
+	Rotate(ctx context.Context, r sinkRotation) error
+}
+
+// sinkRecord is a stored ciphertext as handed to a sink.
+type sinkRecord struct {
+	ID string `json:"id"`
+	storedCiphertext
+}
+
+// sinkRotation announces a new key version to a sink.
+type sinkRotation struct {
+	KeyVersion int    `json:"key_version"`
+	KeyID      string `json:"key_id"`
+
+	// PreviousKeyVersion and PreviousKeyID identify the key being
+	// replaced; they are empty for the mount's first key.
+	PreviousKeyVersion int    `json:"previous_key_version,omitempty"`
+	PreviousKeyID      string `json:"previous_key_id,omitempty"`
+}
+
+// sinkQuery is a nearest-neighbor query against a sink.
+type sinkQuery struct {
+	KeyID  string    `json:"key_id"`
+	Vector []float64 `json:"vector"`
+	K      int       `json:"k"`
+}
+
+// mountSink is the built-in sink: ciphertext/ in the mount's storage, with
+// the in-memory index that search/knn scans.
+type mountSink struct {
+	b       *vectorBackend
+	storage logical.Storage
+}
+
+// Upsert writes the records to ciphertext/:id.
+func (s *mountSink) Upsert(ctx context.Context, records []sinkRecord) error {
+	for i := range records {
+		stored := records[i].storedCiphertext
+		if err := putStorageJSON(ctx, s.storage, ciphertextStoragePrefix+records[i].ID, &stored); err != nil {
+			return fmt.Errorf("store ciphertext %q: %w", records[i].ID, err)
+		}
+		s.b.indexLock.Lock()
+		if s.b.ciphertextIndex != nil {
+			s.b.ciphertextIndex[records[i].ID] = &stored
+		}
+		s.b.indexLock.Unlock()
+	}
+	return nil
+}
+
+// Query compares q against every unexpired stored ciphertext of q.KeyID.
+func (s *mountSink) Query(ctx context.Context, q sinkQuery) ([]searchHit, int, error) {
+	index, err := s.b.getCiphertextIndex(ctx, s.storage)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	s.b.indexLock.RLock()
+	hits := make([]searchHit, 0, len(index))
+	for id, stored := range index {
+		if stored.KeyID != q.KeyID || len(stored.Ciphertext) != len(q.Vector) || stored.expired(now) {
+			continue
+		}
+		hits = append(hits, searchHit{ID: id, EncryptedDistance: euclideanDistance(q.Vector, stored.Ciphertext)})
+	}
+	s.b.indexLock.RUnlock()
+	return hits, len(hits), nil
+}
+
+// Delete removes the records from ciphertext/ and the index.
+func (s *mountSink) Delete(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		if err := deleteStorageEntry(ctx, s.storage, ciphertextStoragePrefix+id); err != nil {
+			return err
+		}
+		s.b.indexLock.Lock()
+		if s.b.ciphertextIndex != nil {
+			delete(s.b.ciphertextIndex, id)
+		}
+		s.b.indexLock.Unlock()
+	}
+	return nil
+}
+
+// Rotate does nothing: the mount keeps the ciphertexts of every key version
+// together, told apart by their key_id.
+func (s *mountSink) Rotate(context.Context, sinkRotation) error {
+	return nil
+}
+
+// sinkConfig holds the webhook sink settings.
+type sinkConfig struct {
+	URL    string `json:"url"`
+	Token  string `json:"token,omitempty"`
+	CACert string `json:"ca_cert,omitempty"`
+
+	// Timeout, when zero, is the config/outbound timeout.
+	Timeout time.Duration `json:"timeout"`
+
+	// Search routes search/knn to the webhook instead of the mount.
+	Search bool `json:"search"`
+
+	// IndexPerKeyVersion announces each rotation to the webhook, for
+	// adapters that keep an index per key version.
+	IndexPerKeyVersion bool `json:"index_per_key_version"`
+}
+
+// responseData renders the sink settings for API responses. The token is
+// never returned.
+func (c *sinkConfig) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"url":       c.URL,
+		"token_set": c.Token != "",
+		"ca_cert":   c.CACert,
+		"timeout":   int64(c.Timeout.Seconds()),
+		"search":    c.Search,
+
+		"index_per_key_version": c.IndexPerKeyVersion,
+	}
+}
+
+// validate checks the sink settings before they are stored.
+func (c *sinkConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// webhookSink forwards sink operations as JSON POSTs to an adapter:
+// <url>/upsert, <url>/query and <url>/delete.
+type webhookSink struct {
+	cfg    *sinkConfig
+	client *http.Client
+
+	// call runs each request under the outbound retry policy and circuit
+	// breaker; nil runs it directly.
+	call func(context.Context, func(context.Context) error) error
+}
+
+// newWebhookSink builds the HTTP client for cfg under the mount's
+// outbound settings, which may be nil.
+func newWebhookSink(cfg *sinkConfig, outbound *outboundConfig) (*webhookSink, error) {
+	client, err := outbound.httpClient(cfg.CACert, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{cfg: cfg, client: client}, nil
+}
+
+// Upsert posts {"records": [...]} to <url>/upsert.
+func (s *webhookSink) Upsert(ctx context.Context, records []sinkRecord) error {
+	return s.do(ctx, "upsert", map[string]interface{}{"records": records}, nil)
+}
+
+// Query posts the query to <url>/query and expects
+// {"results": [{"id", "encrypted_distance"}], "candidates": n}.
+func (s *webhookSink) Query(ctx context.Context, q sinkQuery) ([]searchHit, int, error) {
+	var out struct {
+		Results    []searchHit `json:"results"`
+		Candidates int         `json:"candidates"`
+	}
+	if err := s.do(ctx, "query", q, &out); err != nil {
+		return nil, 0, err
+	}
+	return out.Results, out.Candidates, nil
+}
+
+// Delete posts {"ids": [...]} to <url>/delete.
+func (s *webhookSink) Delete(ctx context.Context, ids []string) error {
+	return s.do(ctx, "delete", map[string]interface{}{"ids": ids}, nil)
+}
+
+// Rotate posts the rotation to <url>/rotate with index_per_key_version, and
+// does nothing otherwise.
+func (s *webhookSink) Rotate(ctx context.Context, r sinkRotation) error {
+	if !s.cfg.IndexPerKeyVersion {
+		return nil
+	}
+	return s.do(ctx, "rotate", r, nil)
+}
+
+// do runs post under s.call.
+func (s *webhookSink) do(ctx context.Context, op string, body, out interface{}) error {
+	if s.call == nil {
+		return s.post(ctx, op, body, out)
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.post(ctx, op, body, out)
+	})
+}
+
+// post sends body to <url>/<op> and decodes a JSON response into out, if
+// given. Any status other than 2xx is an error.
+func (s *webhookSink) post(ctx context.Context, op string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/"+op, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sink %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSinkResponseBytes))
+	if err != nil {
+		return fmt.Errorf("sink %s: read response: %w", op, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(respBody) > 256 {
+			respBody = respBody[:256]
+		}
+		return fmt.Errorf("sink %s: %w", op, &outboundStatusError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		})
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("sink %s: decode response: %w", op, err)
+	}
+	return nil
+}
+
+// pathSink returns the path configuration for config/sink.
+func (b *vectorBackend) pathSink() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/sink",
+			Fields: map[string]*framework.FieldSchema{
+				"url": {
+					Type:        framework.TypeString,
+					Description: "Base URL of the sink adapter, e.g. https://adapter.internal:8443/vectors.",
+				},
+				"token": {
+					Type:        framework.TypeString,
+					Description: "Bearer token sent to the adapter. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM-encoded CA certificate for the adapter, trusted with those of config/outbound. Defaults to the system roots.",
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Timeout of each request to the adapter (default: the config/outbound timeout).",
+				},
+				"search": {
+					Type:        framework.TypeBool,
+					Description: "Send search/knn queries to the adapter instead of searching the mount.",
+				},
+				"index_per_key_version": {
+					Type:        framework.TypeBool,
+					Description: "Announce each key rotation to the adapter, before the new key is installed, so it can create an index for the new key version.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSinkRead,
+					Summary:  "Read the webhook sink settings.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSinkWrite,
+					Summary:  "Forward stored ciphertexts to a webhook sink.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleSinkDelete,
+					Summary:  "Remove the webhook sink; ciphertexts are stored in the mount only.",
+				},
+			},
+			HelpSynopsis:    pathSinkHelpSyn,
+			HelpDescription: pathSinkHelpDesc,
+		},
+	}
+}
+
+// handleSinkRead returns the webhook sink settings, without the token.
+func (b *vectorBackend) handleSinkRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSinkConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleSinkWrite merges the supplied fields into the stored sink settings.
+func (b *vectorBackend) handleSinkWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSinkConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{}
+	if cfg != nil {
+		before = cfg.responseData()
+	} else {
+		cfg = &sinkConfig{}
+	}
+
+	if raw, ok := data.GetOk("url"); ok {
+		cfg.URL = raw.(string)
+	}
+	if raw, ok := data.GetOk("token"); ok {
+		cfg.Token = raw.(string)
+	}
+	if raw, ok := data.GetOk("ca_cert"); ok {
+		cfg.CACert = raw.(string)
+	}
+	if raw, ok := data.GetOk("timeout"); ok {
+		cfg.Timeout = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := data.GetOk("search"); ok {
+		cfg.Search = raw.(bool)
+	}
+	if raw, ok := data.GetOk("index_per_key_version"); ok {
+		cfg.IndexPerKeyVersion = raw.(bool)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Build the client once now so a bad CA certificate fails the write.
+	outbound, err := readOutboundConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newWebhookSink(cfg, outbound); err != nil {
+		return nil, err
+	}
+
+	if err := putStorageJSON(ctx, req.Storage, sinkStoragePath, cfg); err != nil {
+		return nil, err
+	}
+	b.resetSink()
+	if err := b.recordHistory(ctx, req, "sink-write", before, cfg.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: cfg.responseData(),
+	}, nil
+}
+
+// handleSinkDelete removes the webhook sink settings.
+func (b *vectorBackend) handleSinkDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := readSinkConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, sinkStoragePath); err != nil {
+		return nil, err
+	}
+	b.resetSink()
+	if cfg == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "sink-delete", cfg.responseData(), map[string]interface{}{})
+}
+
+// readSinkConfig retrieves the webhook sink settings, or nil if unset.
+func readSinkConfig(ctx context.Context, storage logical.Storage) (*sinkConfig, error) {
+	var cfg sinkConfig
+	found, err := getStorageJSON(ctx, storage, sinkStoragePath, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// getWebhookSink returns the configured webhook sink, or nil if there is
+// none, building it on first use. It follows the same Check-Lock-Check
+// pattern as getSettings.
+func (b *vectorBackend) getWebhookSink(ctx context.Context, storage logical.Storage) (*webhookSink, error) {
+	b.sinkLock.RLock()
+	if b.sinkLoaded {
+		sink := b.webhookSink
+		b.sinkLock.RUnlock()
+		return sink, nil
+	}
+	b.sinkLock.RUnlock()
+
+	b.sinkLock.Lock()
+	defer b.sinkLock.Unlock()
+
+	if b.sinkLoaded {
+		return b.webhookSink, nil
+	}
+	cfg, err := readSinkConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	var sink *webhookSink
+	if cfg != nil {
+		outbound, err := readOutboundConfig(ctx, storage)
+		if err != nil {
+			return nil, err
+		}
+		if sink, err = newWebhookSink(cfg, outbound); err != nil {
+			return nil, err
+		}
+		sink.call = func(ctx context.Context, fn func(context.Context) error) error {
+			return b.callOutbound(ctx, storage, integrationSink, fn)
+		}
+	}
+	b.webhookSink, b.sinkLoaded = sink, true
+	return sink, nil
+}
+
+// resetSink drops the cached webhook sink so the next use rereads
+// config/sink.
+func (b *vectorBackend) resetSink() {
+	b.sinkLock.Lock()
+	b.webhookSink, b.sinkLoaded = nil, false
+	b.sinkLock.Unlock()
+}
+
+// searchSink returns the sink search/knn queries: the webhook sink if it
+// is configured to serve searches, otherwise the mount.
+func (b *vectorBackend) searchSink(ctx context.Context, storage logical.Storage) (Sink, error) {
+	webhook, err := b.getWebhookSink(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if webhook != nil && webhook.cfg.Search {
+		return webhook, nil
+	}
+	return &mountSink{b: b, storage: storage}, nil
+}
+
+// rotateSinks announces next, about to replace the current key, to the
+// sinks. It runs under the rotation lock before the key is stored, so an
+// adapter keeping an index per key version has created the new version's
+// before any ciphertext under it is written; if it cannot, the rotation
+// fails and the current key stays in place.
+func (b *vectorBackend) rotateSinks(ctx context.Context, storage logical.Storage, next *rotationConfig) error {
+	webhook, err := b.getWebhookSink(ctx, storage)
+	if err != nil {
+		return err
+	}
+	sinks := []Sink{&mountSink{b: b, storage: storage}}
+	if webhook != nil {
+		sinks = append(sinks, webhook)
+	}
+
+	rotation := sinkRotation{KeyVersion: 1}
+	if rotation.KeyID, err = contextKeyID(next, ""); err != nil {
+		return err
+	}
+	current, err := b.readConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		rotation.KeyVersion = current.version() + 1
+		rotation.PreviousKeyVersion = current.version()
+		if rotation.PreviousKeyID, err = contextKeyID(current, ""); err != nil {
+			return err
+		}
+	}
+	for _, sink := range sinks {
+		if err := sink.Rotate(ctx, rotation); err != nil {
+			return fmt.Errorf("key version %d not installed, the sink refused it: %w", rotation.KeyVersion, err)
+		}
+	}
+	return nil
+}
+
+// Help text constants for the sink path.
+const pathSinkHelpSyn = `Forward stored ciphertexts to an external database through a webhook.`
+
+const pathSinkHelpDesc = `
+Ciphertexts stored with encrypt's 'id' or 'ids' are kept in the mount (the
+built-in sink). With a webhook sink configured, each is also sent to an
+adapter, a small HTTP service in front of a database the plugin does not
+support, and every deletion (ciphertext/:id, purge/ciphertext,
+erase/subject, TTL expiry and the compromise re-keying) is forwarded too.
+The mount remains the record of what was stored, so those operations work
+as before.
+
+The adapter receives JSON POSTs, and must answer each with a 2xx status:
+  <url>/upsert - {"records": [{"id", "ciphertext", "dimension", "key_id",
+                 "key_version", "role", "subject", "metadata",
+                 "created_at", "expires_at"}]}; replace records with the
+                 same id
+  <url>/delete - {"ids": [...]}; unknown ids are not an error
+  <url>/query  - {"key_id", "vector", "k"}; respond with {"results":
+                 [{"id", "encrypted_distance"}], "candidates": n}, the k
+                 records of key_id nearest to vector (only with search=true)
+  <url>/rotate - {"key_version", "key_id", "previous_key_version",
+                 "previous_key_id"}; prepare for a new key version (only
+                 with index_per_key_version=true)
+
+A ciphertext is written to the mount before the adapter, and deleted from
+the adapter before the mount, so a failed request leaves the mount holding
+a superset of the adapter and can simply be retried.
+
+Index per key version: records carry the key_version they were encrypted
+under. With index_per_key_version=true, every rotation (config/rotate,
+config/import, a completed ceremony, the compromise re-key) first posts to
+<url>/rotate, and only installs the new key once the adapter answers 2xx.
+The adapter creates an index or collection for the new version, say
+vectors_v<key_version>, and writes each record to its version's index.
+Clients pinned to an older version with key_version keep writing to that
+version's index, and rewrap/vector moves records across. Once an old
+index is empty, drop it and delete the version with config/versions/delete.
+If the adapter fails, the rotation fails and the current key stays.
+
+Parameters:
+  url     - Base URL of the adapter (required)
+  token   - Sent as "Authorization: Bearer <token>" (write-only)
+  ca_cert - PEM CA certificate for the adapter (default: system roots)
+  timeout - Timeout of each request (default: the config/outbound
+            timeout, 10s unless set)
+  search  - Send search/knn queries to the adapter (default: false)
+  index_per_key_version - Announce rotations to <url>/rotate (default:
+            false)
+
+Client certificates, proxies and the default timeout are set for every
+outbound connection in config/outbound.
+
+Example:
+  vault write vector/config/sink url=https://adapter.internal:8443/vectors \
+      token=@adapter-token search=true
+`
@@ -0,0 +1,283 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeSinkAdapter is an in-memory webhook sink adapter.
+type fakeSinkAdapter struct {
+	mu        sync.Mutex
+	records   map[string]sinkRecord
+	queries   int
+	rotations []sinkRotation
+	down      bool
+}
+
+func (f *fakeSinkAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer adapter-token" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.URL.Path {
+	case "/vectors/upsert":
+		var body struct {
+			Records []sinkRecord `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, rec := range body.Records {
+			f.records[rec.ID] = rec
+		}
+	case "/vectors/delete":
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, id := range body.IDs {
+			delete(f.records, id)
+		}
+	case "/vectors/query":
+		var q sinkQuery
+		json.NewDecoder(r.Body).Decode(&q)
+		f.queries++
+		var hits []searchHit
+		for id, rec := range f.records {
+			if rec.KeyID == q.KeyID {
+				hits = append(hits, searchHit{ID: id, EncryptedDistance: euclideanDistance(q.Vector, rec.Ciphertext)})
+			}
+		}
+		sort.Slice(hits, func(i, j int) bool { return hits[i].EncryptedDistance < hits[j].EncryptedDistance })
+		candidates := len(hits)
+		if len(hits) > q.K {
+			hits = hits[:q.K]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": hits, "candidates": candidates})
+	case "/vectors/rotate":
+		var rotation sinkRotation
+		json.NewDecoder(r.Body).Decode(&rotation)
+		f.rotations = append(f.rotations, rotation)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSinkAdapter) ids() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for id := range f.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestWebhookSink(t *testing.T) {
+	adapter := &fakeSinkAdapter{records: map[string]sinkRecord{}}
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"url":   server.URL + "/vectors",
+		"token": "adapter-token",
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, "config/sink", nil)
+	if _, ok := resp.Data["token"]; ok || resp.Data["token_set"] != true || resp.Data["timeout"] != int64(0) {
+		t.Errorf("config/sink read = %v, want token_set without token and the default timeout", resp.Data)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":   testVector(0),
+		"id":       "doc-1",
+		"metadata": map[string]interface{}{"source": "wiki"},
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(1), testVector(2)},
+		"ids":     []interface{}{"doc-2", "doc-3"},
+	})
+	if got := adapter.ids(); len(got) != 3 {
+		t.Fatalf("adapter holds %v, want doc-1 to doc-3", got)
+	}
+	rec := adapter.records["doc-1"]
+	stored := testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-1", nil)
+	if rec.KeyID != stored.Data["key_id"] || rec.Metadata["source"] != "wiki" || !equalFloats(rec.Ciphertext, stored.Data["ciphertext"].([]float64)) {
+		t.Errorf("adapter record = %+v, want the stored ciphertext with its key_id and metadata", rec)
+	}
+
+	// Searches stay in the mount until the adapter is asked to serve them.
+	mountResults := testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"query": stored.Data["ciphertext"],
+		"k":     2,
+	}).Data["results"].([]searchHit)
+	if adapter.queries != 0 {
+		t.Fatalf("adapter queried %d times without search=true", adapter.queries)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"search": true,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "search/knn", map[string]interface{}{
+		"query": stored.Data["ciphertext"],
+		"k":     2,
+	})
+	sinkResults := resp.Data["results"].([]searchHit)
+	if adapter.queries != 1 || resp.Data["candidates"] != 3 || len(sinkResults) != 2 {
+		t.Fatalf("search = %v after %d adapter queries, want 2 of 3 candidates from the adapter", resp.Data, adapter.queries)
+	}
+	for i := range mountResults {
+		if sinkResults[i].ID != mountResults[i].ID || sinkResults[i].Distance != mountResults[i].Distance {
+			t.Errorf("adapter hit %d = %+v, want %+v as from the mount", i, sinkResults[i], mountResults[i])
+		}
+	}
+
+	// Deletions are forwarded.
+	testRequest(t, b, s, logical.DeleteOperation, "ciphertext/doc-1", nil)
+	testRequest(t, b, s, logical.UpdateOperation, "purge/ciphertext", map[string]interface{}{
+		"prefix": "doc-3",
+	})
+	if got := adapter.ids(); len(got) != 1 || got[0] != "doc-2" {
+		t.Errorf("adapter holds %v after deletions, want [doc-2]", got)
+	}
+
+	// While the adapter is down, deletions fail and leave the mount entry
+	// for a retry.
+	adapter.mu.Lock()
+	adapter.down = true
+	adapter.mu.Unlock()
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "ciphertext/doc-2",
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatal("delete succeeded while the adapter was down")
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-2", nil); resp == nil {
+		t.Error("mount entry deleted although the adapter deletion failed")
+	}
+
+	// Without the sink, the mount alone is used again.
+	testRequest(t, b, s, logical.DeleteOperation, "config/sink", nil)
+	testRequest(t, b, s, logical.DeleteOperation, "ciphertext/doc-2", nil)
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(4),
+		"id":     "doc-4",
+	})
+}
+
+func TestSinkConfigValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+	for name, data := range map[string]map[string]interface{}{
+		"missing url":  {},
+		"relative url": {"url": "/vectors"},
+		"bad scheme":   {"url": "ftp://adapter/vectors"},
+		"bad timeout":  {"url": "https://adapter/vectors", "timeout": -1},
+		"bad ca_cert":  {"url": "https://adapter/vectors", "ca_cert": "not a certificate"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/sink",
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && (resp == nil || !resp.IsError()) {
+			t.Errorf("%s: config/sink accepted %v", name, data)
+		}
+	}
+}
+
+func TestSinkRotationHook(t *testing.T) {
+	adapter := &fakeSinkAdapter{records: map[string]sinkRecord{}}
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"url":   server.URL + "/vectors",
+		"token": "adapter-token",
+	})
+	keyID := func() string {
+		cfg, err := b.readConfig(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := contextKeyID(cfg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// Rotations are only announced to adapters that asked for them.
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if len(adapter.rotations) != 0 {
+		t.Fatalf("adapter told of %v without index_per_key_version", adapter.rotations)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/sink", map[string]interface{}{
+		"index_per_key_version": true,
+	})
+	previous := keyID()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	want := sinkRotation{KeyVersion: 3, KeyID: keyID(), PreviousKeyVersion: 2, PreviousKeyID: previous}
+	if len(adapter.rotations) != 1 || adapter.rotations[0] != want {
+		t.Fatalf("adapter rotations = %+v, want [%+v]", adapter.rotations, want)
+	}
+
+	// Records carry their key version, pinned or current.
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+		"id":     "doc-1",
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":     []interface{}{testVector(2)},
+		"ids":         []interface{}{"doc-2"},
+		"key_version": 2,
+	})
+	if v1, v2 := adapter.records["doc-1"].KeyVersion, adapter.records["doc-2"].KeyVersion; v1 != 3 || v2 != 2 {
+		t.Errorf("record key versions = %d, %d; want 3, 2", v1, v2)
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "ciphertext/doc-2", nil); resp.Data["key_version"] != 2 {
+		t.Errorf("stored ciphertext = %v, want key_version 2", resp.Data)
+	}
+
+	// An adapter that cannot prepare the new version stops the rotation.
+	adapter.mu.Lock()
+	adapter.down = true
+	adapter.mu.Unlock()
+	current := keyID()
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	}); err == nil {
+		t.Fatal("rotated while the adapter was down")
+	}
+	if keyID() != current {
+		t.Error("key replaced although the adapter refused the rotation")
+	}
+}
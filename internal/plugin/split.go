@@ -0,0 +1,556 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// splitExportsStoragePath records which factors of the current split
+	// key have been exported, and to whom.
+	splitExportsStoragePath = "config/split/exports"
+
+	// splitFactorStoragePath is the Vault storage path for a factor
+	// imported into a decryption mount.
+	splitFactorStoragePath = "config/split/factor"
+
+	// splitOuterLabel and splitInnerLabel derive the factor seeds from the
+	// key seed.
+	splitOuterLabel = "vector-dpe/split/outer/v1"
+	splitInnerLabel = "vector-dpe/split/inner/v1"
+)
+
+// Split key factors. A split key's matrix is Q = Q_outer · Q_inner, so a
+// ciphertext c = s·Q·v + λ decrypts approximately as
+// v ≈ Q_innerᵀ · (Q_outerᵀ · c) / s, applying the outer factor first.
+const (
+	factorOuter = "outer"
+	factorInner = "inner"
+)
+
+// splitFactorSeed derives the seed of factor from a split key's seed.
+func splitFactorSeed(seed []byte, factor string) []byte {
+	label := splitOuterLabel
+	if factor == factorInner {
+		label = splitInnerLabel
+	}
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// generateSplitMatrix generates the matrix of a split key: the product of
+// its two factors, each a Haar-random orthogonal matrix. Like
+// generateOrthogonalMatrix, it leaves validation to the caller.
+func generateSplitMatrix(seed []byte, dim int) (*mat.Dense, error) {
+	outerSeed := splitFactorSeed(seed, factorOuter)
+	defer zeroBytes(outerSeed)
+	innerSeed := splitFactorSeed(seed, factorInner)
+	defer zeroBytes(innerSeed)
+
+	outer, err := generateOrthogonalMatrix(outerSeed, dim)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroMatrix(outer)
+	inner, err := generateOrthogonalMatrix(innerSeed, dim)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroMatrix(inner)
+
+	var q mat.Dense
+	q.Mul(outer, inner)
+	return &q, nil
+}
+
+// splitExports records the factors of the current split key handed out by
+// config/split/export, keyed by factor.
+type splitExports struct {
+	KeyID   string                       `json:"key_id"`
+	Factors map[string]splitExportRecord `json:"factors"`
+}
+
+// splitExportRecord is one exported factor.
+type splitExportRecord struct {
+	Identity    string    `json:"identity"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// splitFactor is a factor of another mount's split key, imported into this
+// mount for decrypt/split.
+type splitFactor struct {
+	Factor        string  `json:"factor"`
+	Seed          string  `json:"seed"`
+	Dimension     int     `json:"dimension"`
+	ScalingFactor float64 `json:"scaling_factor"`
+	KeyID         string  `json:"key_id"`
+
+	// KeyVersion and TransformID identify the split key, as returned by
+	// config/split/export. Zero and empty for factors imported without
+	// them; see scheme.go.
+	KeyVersion  int    `json:"key_version,omitempty"`
+	TransformID string `json:"transform_id,omitempty"`
+}
+
+// responseData renders the factor for API responses. The seed is never
+// returned.
+func (f *splitFactor) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"factor":         f.Factor,
+		"dimension":      f.Dimension,
+		"scaling_factor": f.ScalingFactor,
+		"key_id":         f.KeyID,
+		"key_version":    f.KeyVersion,
+		"transform_id":   f.TransformID,
+	}
+}
+
+// validate checks an imported factor before it is stored.
+func (f *splitFactor) validate() error {
+	if f.Factor != factorOuter && f.Factor != factorInner {
+		return fmt.Errorf("factor must be %q or %q", factorOuter, factorInner)
+	}
+	seed, err := base64.StdEncoding.DecodeString(f.Seed)
+	if err != nil {
+		return fmt.Errorf("decode seed: %w", err)
+	}
+	zeroBytes(seed)
+	if len(seed) != seedLength {
+		return fmt.Errorf("seed is %d bytes, expected %d", len(seed), seedLength)
+	}
+	if f.Dimension <= 0 || f.Dimension > MaxDimension {
+		return fmt.Errorf("dimension %d out of range (1-%d)", f.Dimension, MaxDimension)
+	}
+	if !(f.ScalingFactor > 0) || math.IsInf(f.ScalingFactor, 0) {
+		return fmt.Errorf("scaling_factor must be a positive finite number (got %v)", f.ScalingFactor)
+	}
+	if f.KeyVersion < 0 {
+		return fmt.Errorf("key_version must not be negative")
+	}
+	return nil
+}
+
+// pathSplit returns the path configuration for config/split/ and
+// decrypt/split.
+func (b *vectorBackend) pathSplit() []*framework.Path {
+	factorField := &framework.FieldSchema{
+		Type:          framework.TypeString,
+		Description:   "Factor of the split key: 'outer' (applied first when decrypting) or 'inner'.",
+		AllowedValues: []interface{}{factorOuter, factorInner},
+	}
+	return []*framework.Path{
+		// Importing a factor installs key material, so config/split/factor
+		// is a write-only sensitive path; the imported factor is read and
+		// removed here, like a ceremony at config/ceremony.
+		{
+			Pattern: "config/split",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSplitFactorRead,
+					Summary:  "Read the imported factor, without its seed.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleSplitFactorDelete,
+					Summary:  "Remove the imported factor.",
+				},
+			},
+			HelpSynopsis:    pathSplitHelpSyn,
+			HelpDescription: pathSplitHelpDesc,
+		},
+		{
+			Pattern: "config/split/export",
+			Fields: map[string]*framework.FieldSchema{
+				"factor": factorField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSplitExport,
+					Summary:  "Export one factor of the split key, once, for a decryption party.",
+				},
+			},
+			HelpSynopsis:    pathSplitHelpSyn,
+			HelpDescription: pathSplitHelpDesc,
+		},
+		{
+			Pattern: "config/split/factor",
+			Fields: map[string]*framework.FieldSchema{
+				"factor": factorField,
+				"seed": {
+					Type:        framework.TypeString,
+					Description: "Factor seed, as returned by config/split/export. Write-only.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"dimension": {
+					Type:        framework.TypeInt,
+					Description: "Dimension of the split key.",
+				},
+				"scaling_factor": {
+					Type:        framework.TypeFloat,
+					Description: "Scaling factor of the split key, divided out by the inner factor.",
+				},
+				"key_id": {
+					Type:        framework.TypeString,
+					Description: "Identifier of the split key, checked against requests that pass one.",
+				},
+				"key_version": {
+					Type:        framework.TypeInt,
+					Description: "Version of the split key, reported by decrypt/split.",
+				},
+				"transform_id": {
+					Type:        framework.TypeString,
+					Description: "Transform digest of the split key, reported by decrypt/split.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSplitFactorWrite,
+					Summary:  "Import a factor of another mount's split key.",
+				},
+			},
+			HelpSynopsis:    pathSplitHelpSyn,
+			HelpDescription: pathSplitHelpDesc,
+		},
+		{
+			Pattern: "decrypt/split",
+			Fields: map[string]*framework.FieldSchema{
+				"vector": {
+					Type:        framework.TypeSlice,
+					Description: "Ciphertext (outer factor) or the outer factor's output (inner factor).",
+					Required:    true,
+				},
+				"key_id": {
+					Type:        framework.TypeString,
+					Description: "Key the ciphertext was encrypted under; refused if it is not the imported factor's key.",
+				},
+				"encoding": encodingField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleSplitDecrypt,
+					Summary:  "Apply the imported factor's share of the inverse transform.",
+				},
+			},
+			HelpSynopsis:    pathSplitHelpSyn,
+			HelpDescription: pathSplitHelpDesc,
+		},
+	}
+}
+
+// handleSplitExport returns the seed of one factor of the current split
+// key. Each factor is exported once per key, and the two factors to
+// different callers, so no single caller can undo the transform.
+func (b *vectorBackend) handleSplitExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	factor, ok := data.GetOk("factor")
+	if !ok {
+		return logical.ErrorResponse("factor is required"), nil
+	}
+	settings, err := b.readSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if settings.strict() {
+		return logical.ErrorResponse("hardening_profile=strict: key export is disabled"), nil
+	}
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return logical.ErrorResponse(errConfigNotInitialized.Error()), nil
+	}
+	if !cfg.Split {
+		return logical.ErrorResponse("the current key is not split; rotate with split=true"), nil
+	}
+	caller := callerIdentity(req)
+	if caller == "" {
+		return nil, fmt.Errorf("%w: exporting a factor requires a token with an entity or accessor", logical.ErrPermissionDenied)
+	}
+	keyID, err := contextKeyID(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+
+	b.splitLock.Lock()
+	defer b.splitLock.Unlock()
+	var exports splitExports
+	if _, err := getStorageJSON(ctx, req.Storage, splitExportsStoragePath, &exports); err != nil {
+		return nil, err
+	}
+	if exports.KeyID != keyID {
+		// Exports of a previous key do not count against this one.
+		exports = splitExports{KeyID: keyID, Factors: map[string]splitExportRecord{}}
+	}
+	if record, ok := exports.Factors[factor.(string)]; ok {
+		return nil, fmt.Errorf("%w: the %s factor of key %s was already exported at %s", logical.ErrPermissionDenied,
+			factor, keyID, record.Time.Format(time.RFC3339))
+	}
+	for other, record := range exports.Factors {
+		if record.Identity == caller {
+			return nil, fmt.Errorf("%w: %s already exported the %s factor; the factors must go to different parties", logical.ErrPermissionDenied, caller, other)
+		}
+	}
+	exports.Factors[factor.(string)] = splitExportRecord{
+		Identity:    caller,
+		DisplayName: req.DisplayName,
+		Time:        time.Now().UTC(),
+	}
+	if err := putStorageJSON(ctx, req.Storage, splitExportsStoragePath, &exports); err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode seed: %w", err)
+	}
+	factorSeed := splitFactorSeed(seed, factor.(string))
+	zeroBytes(seed)
+	defer zeroBytes(factorSeed)
+	b.Logger().Warn("exported split key factor", "factor", factor, "key_id", keyID, "identity", caller)
+	b.emitEvent(ctx, "split-factor-exported", "factor", factor.(string), "key_id", keyID)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"factor":         factor,
+			"seed":           base64.StdEncoding.EncodeToString(factorSeed),
+			"dimension":      cfg.Dimension,
+			"scaling_factor": cfg.ScalingFactor,
+			"key_id":         keyID,
+			"key_version":    cfg.version(),
+			"transform_id":   newSchemeParams(cfg, keyID).TransformID,
+		},
+	}, nil
+}
+
+// handleSplitFactorRead returns the imported factor, without its seed.
+func (b *vectorBackend) handleSplitFactorRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	factor, err := readSplitFactor(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if factor == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: factor.responseData(),
+	}, nil
+}
+
+// handleSplitFactorWrite imports a factor, replacing any imported before.
+func (b *vectorBackend) handleSplitFactorWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	before := map[string]interface{}{}
+	existing, err := readSplitFactor(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		before = existing.responseData()
+	}
+
+	scalingFactor, err := coerceFloat(data.Get("scaling_factor"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid scaling_factor: %w", err)
+	}
+	factor := &splitFactor{
+		Factor:        data.Get("factor").(string),
+		Seed:          data.Get("seed").(string),
+		Dimension:     data.Get("dimension").(int),
+		ScalingFactor: scalingFactor,
+		KeyID:         data.Get("key_id").(string),
+		KeyVersion:    data.Get("key_version").(int),
+		TransformID:   data.Get("transform_id").(string),
+	}
+	if err := factor.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := putStorageJSON(ctx, req.Storage, splitFactorStoragePath, factor); err != nil {
+		return nil, err
+	}
+	b.matrixLock.Lock()
+	b.resetSplitFactorLocked()
+	b.matrixLock.Unlock()
+	if err := b.recordHistory(ctx, req, "split-factor-write", before, factor.responseData()); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: factor.responseData(),
+	}, nil
+}
+
+// handleSplitFactorDelete removes the imported factor.
+func (b *vectorBackend) handleSplitFactorDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	factor, err := readSplitFactor(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, splitFactorStoragePath); err != nil {
+		return nil, err
+	}
+	b.matrixLock.Lock()
+	b.resetSplitFactorLocked()
+	b.matrixLock.Unlock()
+	if factor == nil {
+		return nil, nil
+	}
+	return nil, b.recordHistory(ctx, req, "split-factor-delete", factor.responseData(), map[string]interface{}{})
+}
+
+// handleSplitDecrypt multiplies the input by the transpose of the imported
+// factor, and the inner factor also divides out the scaling factor. Neither
+// output alone is the plaintext: the outer factor's output is still a
+// ciphertext under the inner factor.
+func (b *vectorBackend) handleSplitDecrypt(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	matrix, factor, err := b.getSplitFactor(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keyID := data.Get("key_id").(string); keyID != "" && factor.KeyID != "" && keyID != factor.KeyID {
+		return logical.ErrorResponse("key_id %q does not match the imported factor's key %q", keyID, factor.KeyID), nil
+	}
+	encoding, err := requestEncoding(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	vector, err := parseCiphertextInto(nil, data.Get("vector"), encoding, factor.Dimension)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if len(vector) != factor.Dimension {
+		return logical.ErrorResponse("vector has %d elements, the factor's dimension is %d", len(vector), factor.Dimension), nil
+	}
+
+	out := make([]float64, factor.Dimension)
+	result := mat.NewVecDense(factor.Dimension, out)
+	result.MulVec(matrix.T(), mat.NewVecDense(len(vector), vector))
+	if factor.Factor == factorInner {
+		result.ScaleVec(1/factor.ScalingFactor, result)
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"vector":   encodeCiphertext(out, encoding, precisionFloat64),
+			"factor":   factor.Factor,
+			"key_id":   factor.KeyID,
+			"complete": factor.Factor == factorInner,
+		},
+	}
+	(&schemeParams{
+		Scheme:      schemeSAP,
+		KeyVersion:  factor.KeyVersion,
+		Dimension:   factor.Dimension,
+		TransformID: factor.TransformID,
+	}).addTo(resp.Data)
+	return resp, nil
+}
+
+// getSplitFactor returns the imported factor's matrix, generating it on
+// first use, following the Check-Lock-Check pattern of getMatrixAndConfig.
+func (b *vectorBackend) getSplitFactor(ctx context.Context, storage logical.Storage) (*mat.Dense, *splitFactor, error) {
+	factor, err := readSplitFactor(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if factor == nil {
+		return nil, nil, fmt.Errorf("no split key factor imported; write config/split/factor first")
+	}
+
+	b.matrixLock.RLock()
+	if matrix := b.splitFactor; matrix != nil {
+		b.holdMatrixLocked(ctx, matrix)
+		b.matrixLock.RUnlock()
+		return matrix, factor, nil
+	}
+	b.matrixLock.RUnlock()
+
+	b.matrixLock.Lock()
+	defer b.matrixLock.Unlock()
+	if matrix := b.splitFactor; matrix != nil {
+		b.holdMatrixLocked(ctx, matrix)
+		return matrix, factor, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(factor.Seed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode seed: %w", err)
+	}
+	defer zeroBytes(seed)
+	matrix, err := b.loadOrGenerateMatrix(seed, factor.Dimension)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.splitFactor = matrix
+	b.holdMatrixLocked(ctx, matrix)
+	return matrix, factor, nil
+}
+
+// resetSplitFactorLocked drops the cached factor matrix.
+// MUST be called while holding matrixLock.
+func (b *vectorBackend) resetSplitFactorLocked() {
+	if b.splitFactor != nil {
+		b.retireMatrixLocked(b.splitFactor)
+		b.splitFactor = nil
+	}
+}
+
+// readSplitFactor retrieves the imported factor, or nil if none.
+func readSplitFactor(ctx context.Context, storage logical.Storage) (*splitFactor, error) {
+	var factor splitFactor
+	found, err := getStorageJSON(ctx, storage, splitFactorStoragePath, &factor)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &factor, nil
+}
+
+// Help text constants for the split key paths.
+const pathSplitHelpSyn = `Split approximate decryption between two parties.`
+
+const pathSplitHelpDesc = `
+The plugin never decrypts with its own key. For workflows that need to
+recover approximate plaintext embeddings, but where no single service may
+do so alone, a key can be split between two decryption parties.
+
+A key rotated with split=true is the product of two factors,
+Q = Q_outer · Q_inner, each derived from the seed. Encryption is unchanged.
+config/split/export returns one factor's seed, with the key's dimension,
+scaling_factor and key_id. It requires sudo, each factor can be exported
+once per key, and the two factors must go to different callers. Mounts
+under the strict hardening profile refuse it.
+
+Each party imports its factor into its own mount of this plugin with
+config/split/factor, which like the export requires sudo, and decrypts its
+step with decrypt/split:
+
+  1. The outer party computes u = Q_outerᵀ · c.
+  2. The inner party computes v = Q_innerᵀ · u / s.
+
+With encoding=base64, decrypt/split takes a packed ciphertext as returned
+by the encrypt endpoints and returns its output packed as float64, which
+the other party passes on with encoding=base64 in turn. config/split reads
+the imported factor, without its seed, and DELETE removes it.
+
+v is the plaintext plus the encryption noise (scaled by 1/s). The outer
+step's output u is still a ciphertext under Q_inner, and the inner factor
+alone does nothing useful to a ciphertext, so neither party can recover
+embeddings without the other. The mount holding the split key itself could,
+since it holds the seed; restrict it to encryption.
+
+Ciphertexts under role derivation contexts use derived, unsplit keys and
+cannot be decrypted this way.
+`
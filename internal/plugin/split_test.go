@@ -0,0 +1,157 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSplitKeyDecryption(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       2.0,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     0.0,
+		"split":                true,
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	ciphertext := resp.Data["ciphertext"]
+	transform := resp.Data["transform_id"]
+
+	// Each factor goes to a different party, once.
+	if _, err := entityRequest(b, s, "", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorOuter,
+	}); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("export without identity = %v, want permission denied", err)
+	}
+	outer, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorOuter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorInner,
+	}); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("both factors to one caller = %v, want permission denied", err)
+	}
+	if _, err := entityRequest(b, s, "carol", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorOuter,
+	}); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("second export of a factor = %v, want permission denied", err)
+	}
+	inner, err := entityRequest(b, s, "bob", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorInner,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.Data["transform_id"] != transform {
+		t.Errorf("exported transform_id = %v, want the ciphertext's %v", inner.Data["transform_id"], transform)
+	}
+
+	// Two decryption mounts each apply their factor.
+	decrypt := func(export *logical.Response, input interface{}) []float64 {
+		t.Helper()
+		mount, storage := getTestBackend(t)
+		resp := testRequest(t, mount, storage, logical.UpdateOperation, "config/split/factor", export.Data)
+		if _, ok := resp.Data["seed"]; ok {
+			t.Error("config/split/factor returned the seed")
+		}
+		if resp := testRequest(t, mount, storage, logical.ReadOperation, "config/split", nil); resp == nil || resp.Data["factor"] != export.Data["factor"] {
+			t.Errorf("config/split = %v, want the imported factor", resp)
+		}
+		resp = testRequest(t, mount, storage, logical.UpdateOperation, "decrypt/split", map[string]interface{}{
+			"vector": input,
+			"key_id": export.Data["key_id"],
+		})
+		if resp.Data["transform_id"] != export.Data["transform_id"] || resp.Data["key_version"] != 1 {
+			t.Errorf("decrypt/split scheme parameters = %v, want those of the export", resp.Data)
+		}
+		return resp.Data["vector"].([]float64)
+	}
+	partial := decrypt(outer, ciphertext)
+	plaintext := decrypt(inner, partial)
+	for i, want := range testVector(1) {
+		if math.Abs(plaintext[i]-want.(float64)) > 1e-9 {
+			t.Fatalf("decrypted %v, want %v", plaintext, testVector(1))
+		}
+	}
+
+	// The outer step alone does not reveal the plaintext.
+	var distance float64
+	for i, want := range testVector(1) {
+		distance += math.Abs(partial[i]/2 - want.(float64))
+	}
+	if distance < 1e-3 {
+		t.Error("the outer factor alone decrypted the ciphertext")
+	}
+}
+
+func TestSplitExportRequiresSplitKey(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorOuter,
+	}); err == nil {
+		t.Error("exported a factor of an unsplit key")
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "decrypt/split", map[string]interface{}{
+		"vector": testVector(0),
+	}); err == nil {
+		t.Error("decrypted without an imported factor")
+	}
+}
+
+func TestSplitExportStrict(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+		"split":     true,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"hardening_profile": hardeningProfileStrict,
+	})
+	resp, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/split/export", map[string]interface{}{
+		"factor": factorOuter,
+	})
+	if err == nil || !strings.Contains(err.Error(), "strict") {
+		t.Errorf("strict mount exported a factor: %v, %v", resp, err)
+	}
+}
+
+func TestSplitFactorImportIsControlled(t *testing.T) {
+	b, s := getTestBackend(t)
+	if !slices.Contains(b.SpecialPaths().Root, "config/split/factor") {
+		t.Error("config/split/factor does not require sudo")
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/dual-control", map[string]interface{}{
+		"paths": "config/split/factor",
+	})
+	resp, err := entityRequest(b, s, "alice", logical.UpdateOperation, "config/split/factor", map[string]interface{}{
+		"factor":         factorOuter,
+		"seed":           base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"dimension":      testDimension,
+		"scaling_factor": 1.0,
+	})
+	if err != nil || resp.Data["approval_id"] == nil {
+		t.Fatalf("unapproved import = %v, %v; want a pending approval", resp, err)
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "config/split", nil); resp != nil {
+		t.Errorf("unapproved import installed a factor: %v", resp.Data)
+	}
+}
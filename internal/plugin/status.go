@@ -0,0 +1,81 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathStatus returns the path configuration for status.
+func (b *vectorBackend) pathStatus() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "status",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleStatusRead,
+					Summary:  "Report whether the mount is ready to serve requests.",
+				},
+			},
+			HelpSynopsis:    pathStatusHelpSyn,
+			HelpDescription: pathStatusHelpDesc,
+		},
+	}
+}
+
+// handleStatusRead reports readiness. It responds with 503 until the
+// startup warm-up has finished so load balancers can gate traffic on it.
+func (b *vectorBackend) handleStatusRead(_ context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	ready := b.ready.Load()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ready":   ready,
+			"warming": b.warming(),
+		},
+	}
+	if !ready {
+		return logical.RespondWithStatusCode(resp, req, http.StatusServiceUnavailable)
+	}
+	return resp, nil
+}
+
+// warming reports whether a startup warm-up is in progress.
+func (b *vectorBackend) warming() bool {
+	if b.warmDone == nil {
+		return false
+	}
+	select {
+	case <-b.warmDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// Help text constants for the status path.
+const pathStatusHelpSyn = `Report whether the mount is ready to serve requests.`
+
+const pathStatusHelpDesc = `
+This unauthenticated endpoint is intended for load balancer and agent health
+checks. It returns HTTP 200 with ready=true once the mount can serve
+encryption requests without a matrix generation delay, and HTTP 503 with
+ready=false until then.
+
+Without warm_on_startup the mount is ready as soon as it is initialized and
+the matrix is generated on the first request. With warm_on_startup, ready
+turns true only after the background warm-up has generated the matrix (or
+found no key to warm). A failed warm-up leaves the mount not ready until a
+request generates the matrix successfully.
+
+Output:
+  ready   - Whether traffic should be sent to this node
+  warming - Whether the startup warm-up is still running
+
+Example:
+  curl -fsS $VAULT_ADDR/v1/vector/status
+`
@@ -0,0 +1,270 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// maxStorageChunkSize is the largest value written to a single storage entry.
+	// Consul's KV limit is 512KB per value; the headroom covers Vault's
+	// encryption and entry framing overhead.
+	maxStorageChunkSize = 384 * 1024
+
+	// maxChunkedValueSize bounds the reassembled size of a chunked value.
+	maxChunkedValueSize = 256 << 20
+)
+
+// sealWrappedStorage are the storage entries holding key material, which
+// Vault seal-wraps on seals that support it. Entries ending in "/" are
+// prefixes; the key entry's own prefix covers its chunks.
+var sealWrappedStorage = []string{
+	configStoragePath,
+	configStoragePath + "/",
+	keyVersionStoragePrefix,
+	wrappingKeyStoragePath,
+	ceremonyStoragePath,
+	splitFactorStoragePath,
+}
+
+// chunkManifest is stored at an entry's path when its value was split into chunks.
+// The chunks live under <path>/chunks/<generation>/<index>; a new generation is
+// used for every write so readers never observe a mix of old and new chunks.
+type chunkManifest struct {
+	Generation string `json:"generation"`
+	Chunks     int    `json:"chunks"`
+	Size       int    `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+// chunkEnvelope distinguishes a manifest from a value stored inline.
+type chunkEnvelope struct {
+	Manifest *chunkManifest `json:"chunk_manifest"`
+}
+
+// putStorageJSON JSON-encodes v and writes it to path, chunking if necessary.
+func putStorageJSON(ctx context.Context, storage logical.Storage, path string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+	return putChunked(ctx, storage, path, value, maxStorageChunkSize)
+}
+
+// getStorageJSON reads the value at path into v, reassembling and verifying
+// chunks if necessary. It returns false if nothing is stored at path.
+func getStorageJSON(ctx context.Context, storage logical.Storage, path string, v interface{}) (bool, error) {
+	value, err := getChunked(ctx, storage, path)
+	if err != nil {
+		return false, err
+	}
+	if value == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return false, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// putChunked writes value to path. Values up to chunkSize are stored inline,
+// exactly as a plain storage entry; larger values are split into chunks and
+// a manifest carrying their SHA-256 is written to path last. Chunks of the
+// previous generation are removed once the new manifest is in place.
+func putChunked(ctx context.Context, storage logical.Storage, path string, value []byte, chunkSize int) error {
+	if len(value) > maxChunkedValueSize {
+		return fmt.Errorf("%s: value of %d bytes exceeds maximum %d", path, len(value), maxChunkedValueSize)
+	}
+
+	previous, err := readManifest(ctx, storage, path)
+	if err != nil {
+		return err
+	}
+
+	if len(value) <= chunkSize {
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: path, Value: value}); err != nil {
+			return err
+		}
+		return deleteChunks(ctx, storage, path, previous)
+	}
+
+	generation := make([]byte, 8)
+	if _, err := rand.Read(generation); err != nil {
+		return fmt.Errorf("generate chunk generation: %w", err)
+	}
+	sum := sha256.Sum256(value)
+	manifest := &chunkManifest{
+		Generation: hex.EncodeToString(generation),
+		Chunks:     (len(value) + chunkSize - 1) / chunkSize,
+		Size:       len(value),
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+
+	for i := 0; i < manifest.Chunks; i++ {
+		end := min((i+1)*chunkSize, len(value))
+		entry := &logical.StorageEntry{
+			Key:   chunkPath(path, manifest.Generation, i),
+			Value: value[i*chunkSize : end],
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			// Best effort: the manifest still points at the previous generation.
+			_ = deleteChunks(ctx, storage, path, &chunkManifest{Generation: manifest.Generation, Chunks: i})
+			return fmt.Errorf("%s: write chunk %d: %w", path, i, err)
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(path, &chunkEnvelope{Manifest: manifest})
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		return err
+	}
+	return deleteChunks(ctx, storage, path, previous)
+}
+
+// getChunked reads the value at path, reassembling chunks if the entry is a
+// manifest. The reassembled value must match the manifest's size and SHA-256.
+// It returns nil if nothing is stored at path.
+func getChunked(ctx context.Context, storage logical.Storage, path string) ([]byte, error) {
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	manifest, err := decodeManifest(path, entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return entry.Value, nil
+	}
+
+	value := make([]byte, 0, manifest.Size)
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk, err := storage.Get(ctx, chunkPath(path, manifest.Generation, i))
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			return nil, fmt.Errorf("%s: chunk %d of %d is missing", path, i, manifest.Chunks)
+		}
+		if len(value)+len(chunk.Value) > manifest.Size {
+			return nil, fmt.Errorf("%s: chunks exceed recorded size %d", path, manifest.Size)
+		}
+		value = append(value, chunk.Value...)
+	}
+	if len(value) != manifest.Size {
+		return nil, fmt.Errorf("%s: reassembled %d bytes, expected %d", path, len(value), manifest.Size)
+	}
+	sum := sha256.Sum256(value)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, fmt.Errorf("%s: chunk checksum mismatch", path)
+	}
+	return value, nil
+}
+
+// deleteStorageEntry removes the entry at path and any chunks it references.
+func deleteStorageEntry(ctx context.Context, storage logical.Storage, path string) error {
+	manifest, err := readManifest(ctx, storage, path)
+	if err != nil {
+		return err
+	}
+	if err := storage.Delete(ctx, path); err != nil {
+		return err
+	}
+	return deleteChunks(ctx, storage, path, manifest)
+}
+
+// readManifest returns the manifest stored at path, or nil if the entry is
+// absent or stored inline.
+func readManifest(ctx context.Context, storage logical.Storage, path string) (*chunkManifest, error) {
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return decodeManifest(path, entry.Value)
+}
+
+// decodeManifest returns the manifest encoded in value, or nil if value is
+// not a manifest. A manifest with implausible fields is an error.
+func decodeManifest(path string, value []byte) (*chunkManifest, error) {
+	// Inline values are never probed beyond the cheap marker check.
+	if !bytes.Contains(value, []byte(`"chunk_manifest"`)) {
+		return nil, nil
+	}
+	var envelope chunkEnvelope
+	if err := json.Unmarshal(value, &envelope); err != nil || envelope.Manifest == nil {
+		return nil, nil
+	}
+	m := envelope.Manifest
+	if m.Generation == "" || m.Chunks <= 0 || m.Size <= 0 || m.Size > maxChunkedValueSize || len(m.SHA256) != sha256.Size*2 {
+		return nil, fmt.Errorf("%s: invalid chunk manifest", path)
+	}
+	return m, nil
+}
+
+// deleteChunks removes the chunks referenced by manifest, if any.
+func deleteChunks(ctx context.Context, storage logical.Storage, path string, manifest *chunkManifest) error {
+	if manifest == nil {
+		return nil
+	}
+	for i := 0; i < manifest.Chunks; i++ {
+		if err := storage.Delete(ctx, chunkPath(path, manifest.Generation, i)); err != nil {
+			return fmt.Errorf("%s: delete chunk %d: %w", path, i, err)
+		}
+	}
+	return nil
+}
+
+// chunkPath returns the storage key of chunk index of the given generation.
+func chunkPath(path, generation string, index int) string {
+	return fmt.Sprintf("%s/chunks/%s/%d", path, generation, index)
+}
+
+// afterField and limitField are the pagination fields of LIST endpoints
+// that page with pageKeys.
+var (
+	afterField = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Only keys sorting after this one; pass the previous page's next_after.",
+	}
+	limitField = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: "Maximum number of keys to return (0 returns all).",
+	}
+)
+
+// pageKeys returns the page of the sorted keys selected by the request's
+// 'after' and 'limit' fields, and the next_after cursor if more remain.
+func pageKeys(keys []string, data *framework.FieldData) ([]string, string, error) {
+	limit := data.Get("limit").(int)
+	if limit < 0 {
+		return nil, "", fmt.Errorf("limit must be non-negative (got %d)", limit)
+	}
+	if after := data.Get("after").(string); after != "" {
+		keys = keys[sort.SearchStrings(keys, after+"\x00"):]
+	}
+	if limit == 0 || len(keys) <= limit {
+		return keys, "", nil
+	}
+	keys = keys[:limit]
+	return keys, keys[limit-1], nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestChunkedStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"inline", 10},
+		{"exact chunk", 16},
+		{"two chunks", 17},
+		{"many chunks", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := bytes.Repeat([]byte{byte(tt.size)}, tt.size)
+			if err := putChunked(ctx, s, "test/value", value, 16); err != nil {
+				t.Fatalf("putChunked: %v", err)
+			}
+			got, err := getChunked(ctx, s, "test/value")
+			if err != nil {
+				t.Fatalf("getChunked: %v", err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Fatalf("round trip returned %d bytes, want %d", len(got), len(value))
+			}
+		})
+	}
+
+	// Each write must clean up the previous generation's chunks.
+	keys, err := logical.CollectKeys(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantChunks := (1000 + 15) / 16
+	chunks := 0
+	for _, k := range keys {
+		if strings.HasPrefix(k, "test/value/chunks/") {
+			chunks++
+		}
+	}
+	if chunks != wantChunks {
+		t.Errorf("found %d chunk entries, want %d", chunks, wantChunks)
+	}
+
+	// Shrinking back to an inline value removes all chunks.
+	if err := putChunked(ctx, s, "test/value", []byte("small"), 16); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = logical.CollectKeys(ctx, s)
+	if len(keys) != 1 {
+		t.Errorf("storage keys after inline write = %v, want only test/value", keys)
+	}
+}
+
+func TestChunkedStorageDetectsCorruption(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (logical.Storage, *chunkManifest) {
+		t.Helper()
+		s := &logical.InmemStorage{}
+		if err := putChunked(ctx, s, "test/value", bytes.Repeat([]byte("x"), 100), 16); err != nil {
+			t.Fatal(err)
+		}
+		m, err := readManifest(ctx, s, "test/value")
+		if err != nil || m == nil {
+			t.Fatalf("readManifest = %v, %v", m, err)
+		}
+		return s, m
+	}
+
+	t.Run("missing chunk", func(t *testing.T) {
+		s, m := setup(t)
+		if err := s.Delete(ctx, chunkPath("test/value", m.Generation, 3)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := getChunked(ctx, s, "test/value"); err == nil || !strings.Contains(err.Error(), "missing") {
+			t.Errorf("error = %v, want missing chunk", err)
+		}
+	})
+
+	t.Run("altered chunk", func(t *testing.T) {
+		s, m := setup(t)
+		if err := s.Put(ctx, &logical.StorageEntry{
+			Key:   chunkPath("test/value", m.Generation, 2),
+			Value: bytes.Repeat([]byte("y"), 16),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := getChunked(ctx, s, "test/value"); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("error = %v, want checksum mismatch", err)
+		}
+	})
+
+	t.Run("truncated chunk", func(t *testing.T) {
+		s, m := setup(t)
+		if err := s.Put(ctx, &logical.StorageEntry{
+			Key:   chunkPath("test/value", m.Generation, 0),
+			Value: []byte("x"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := getChunked(ctx, s, "test/value"); err == nil {
+			t.Error("expected size mismatch error")
+		}
+	})
+}
+
+func TestReadConfigRejectsInvalid(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	cfg := &rotationConfig{
+		Seed:                "c2hvcnQ=",
+		Dimension:           testDimension,
+		ScalingFactor:       1,
+		ApproximationFactor: 1,
+	}
+	if err := putStorageJSON(ctx, s, configStoragePath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.readConfig(ctx, s); err == nil {
+		t.Fatal("expected error for a short seed")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var (
+	// operationMetricPrefix is the metric name prefix for the requests that
+	// encrypt or compare vectors.
+	operationMetricPrefix = []string{"vector_dpe", "operation"}
+
+	// matrixMetricPrefix is the metric name prefix for the matrix caches and
+	// matrix generation.
+	matrixMetricPrefix = []string{"vector_dpe", "matrix"}
+)
+
+// Values of the "cache" label of matrix cache metrics.
+const (
+	matrixCacheMemory = "memory"
+	matrixCacheDisk   = "disk"
+)
+
+// requestMetricsKey is the context key under which a request's metrics are
+// stored.
+type requestMetricsKey struct{}
+
+// requestMetrics collects what a request reports to telemetry once its
+// handler returns. Only handlers that call recordActivity set operation;
+// other requests are not measured.
+type requestMetrics struct {
+	start     time.Time
+	operation string
+	role      string
+	vectors   int
+}
+
+// withRequestMetrics returns a context carrying a new requestMetrics.
+func withRequestMetrics(ctx context.Context) (context.Context, *requestMetrics) {
+	m := &requestMetrics{start: time.Now()}
+	return context.WithValue(ctx, requestMetricsKey{}, m), m
+}
+
+// setOperation records the operation a request performed, the role it used
+// and the number of vectors it carried, if ctx measures the request.
+func setOperation(ctx context.Context, operation, role string, vectors int) {
+	if m, ok := ctx.Value(requestMetricsKey{}).(*requestMetrics); ok {
+		m.operation, m.role, m.vectors = operation, role, vectors
+	}
+}
+
+// emit reports the request's count, vectors, batch size and latency,
+// labelled by operation, role and the dimension of the key it used, and
+// counts it as an error if it failed.
+func (m *requestMetrics) emit(lease *matrixLease, resp *logical.Response, err error) {
+	if m.operation == "" {
+		return
+	}
+	role := m.role
+	if role == "" {
+		// The mount key.
+		role = "-"
+	}
+	labels := []metrics.Label{
+		{Name: "operation", Value: m.operation},
+		{Name: "role", Value: role},
+	}
+	lease.mu.Lock()
+	if len(lease.matrices) > 0 {
+		dim, _ := lease.matrices[0].Dims()
+		labels = append(labels, metrics.Label{Name: "dimension", Value: strconv.Itoa(dim)})
+	}
+	lease.mu.Unlock()
+
+	metrics.IncrCounterWithLabels(append(operationMetricPrefix, "count"), 1, labels)
+	metrics.IncrCounterWithLabels(append(operationMetricPrefix, "vectors"), float32(m.vectors), labels)
+	metrics.AddSampleWithLabels(append(operationMetricPrefix, "batch_size"), float32(m.vectors), labels)
+	metrics.MeasureSinceWithLabels(append(operationMetricPrefix, "latency"), m.start, labels)
+	if err != nil || resp.IsError() {
+		metrics.IncrCounterWithLabels(append(operationMetricPrefix, "error"), 1, labels)
+	}
+}
+
+// recordMatrixCache counts a lookup in the in-memory or disk matrix cache.
+// For the in-memory caches, key is "mount", "derived" or "version".
+func recordMatrixCache(cache, key string, hit bool) {
+	name := "miss"
+	if hit {
+		name = "hit"
+	}
+	labels := []metrics.Label{{Name: "cache", Value: cache}}
+	if key != "" {
+		labels = append(labels, metrics.Label{Name: "key", Value: key})
+	}
+	metrics.IncrCounterWithLabels(append(matrixMetricPrefix, "cache", name), 1, labels)
+}
+
+// recordMatrixGeneration reports how long generating and validating a
+// matrix of dim dimensions took.
+func recordMatrixGeneration(dim int, start time.Time) {
+	metrics.MeasureSinceWithLabels(append(matrixMetricPrefix, "generate"), start, []metrics.Label{
+		{Name: "dimension", Value: strconv.Itoa(dim)},
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOperationMetrics(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { metrics.NewGlobal(conf, &metrics.BlackholeSink{}) })
+
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(0),
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": "v1", "vector": testVector(1)},
+			map[string]interface{}{"id": "v2", "vector": testVector(2)},
+			map[string]interface{}{"id": "v3", "vector": testVector(3)},
+		},
+	})
+
+	intervals := sink.Data()
+	if len(intervals) == 0 {
+		t.Fatal("no metrics")
+	}
+	interval := intervals[len(intervals)-1]
+	interval.RLock()
+	defer interval.RUnlock()
+	find := func(samples map[string]metrics.SampledValue, name string, labels ...string) *metrics.SampledValue {
+		for key, v := range samples {
+			if !strings.HasPrefix(key, name+";") {
+				continue
+			}
+			matched := true
+			for _, l := range labels {
+				matched = matched && strings.Contains(key, ";"+l)
+			}
+			if matched {
+				return &v
+			}
+		}
+		return nil
+	}
+
+	encrypt := find(interval.Counters, "vector_dpe.operation.count", "operation=encrypt", "role=-", "dimension=8")
+	if encrypt == nil || encrypt.Count != 1 {
+		t.Errorf("encrypt count = %+v; counters %v", encrypt, interval.Counters)
+	}
+	batch := find(interval.Samples, "vector_dpe.operation.batch_size", "operation=batch", "dimension=8")
+	if batch == nil || batch.Max != 3 {
+		t.Errorf("batch_size = %+v", batch)
+	}
+	if find(interval.Samples, "vector_dpe.operation.latency", "operation=encrypt") == nil {
+		t.Error("no encrypt latency")
+	}
+	if find(interval.Samples, "vector_dpe.matrix.generate", "dimension=8") == nil {
+		t.Error("no matrix generation time")
+	}
+	if hits := find(interval.Counters, "vector_dpe.matrix.cache.hit", "cache=memory", "key=mount"); hits == nil || hits.Count < 2 {
+		t.Errorf("memory cache hits = %+v", hits)
+	}
+	// Configuration requests are not operations.
+	if find(interval.Counters, "vector_dpe.operation.count", "operation=rotate") != nil {
+		t.Error("config/rotate measured as an operation")
+	}
+}
@@ -0,0 +1,955 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// uploadPrefix, uploadPartPrefix and uploadResultPrefix are the storage
+	// prefixes of upload sessions, their input parts and their results.
+	// Parts and results are stored at <prefix><upload_id>/<part_number>.
+	uploadPrefix       = "uploads/"
+	uploadPartPrefix   = "upload-parts/"
+	uploadResultPrefix = "upload-results/"
+
+	// Upload statuses. An open upload accepts parts; a committed one is
+	// queued for or in processing.
+	uploadOpen      = "open"
+	uploadCommitted = "committed"
+	uploadComplete  = "complete"
+	uploadFailed    = "failed"
+
+	// maxUploadParts bounds the number of parts of an upload.
+	maxUploadParts = 10000
+
+	// maxUploadPartVectors bounds the number of vectors in a single part.
+	maxUploadPartVectors = 8192
+
+	// uploadTTL is how long an open upload is kept after it was started,
+	// and the default for how long results are kept once it finishes.
+	uploadTTL = 24 * time.Hour
+
+	// maxUploadResultTTL bounds how long unacknowledged results are kept.
+	maxUploadResultTTL = 7 * 24 * time.Hour
+
+	// uploadJobBudget bounds the time each periodic run spends encrypting,
+	// so that large uploads are processed over several runs.
+	uploadJobBudget = 30 * time.Second
+)
+
+// uploadSession tracks a batch assembled across several requests.
+type uploadSession struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	Role   string `json:"role,omitempty"`
+
+	// DerivationContext is the role's derivation context as resolved for
+	// the caller who started the upload; processing runs without a caller.
+	DerivationContext string `json:"derivation_context,omitempty"`
+
+	// KeyID is the key the upload is encrypted under. A rotation before
+	// processing finishes fails the upload rather than mixing keys.
+	KeyID    string `json:"key_id"`
+	EntityID string `json:"entity_id,omitempty"`
+
+	// Owner identifies the caller who started the upload (see
+	// callerIdentity); only they may add to it, commit it, read it or its
+	// results, or delete them.
+	Owner  string `json:"owner,omitempty"`
+	Status string `json:"status"`
+
+	// PartVectors is the number of vectors in each part, in part order.
+	PartVectors []int `json:"part_vectors"`
+
+	// ProcessedParts is the number of leading parts encrypted so far.
+	ProcessedParts int `json:"processed_parts"`
+
+	// Acknowledged marks the parts whose results the consumer has
+	// acknowledged and which were deleted, in part order.
+	Acknowledged []bool `json:"acknowledged,omitempty"`
+
+	// ResultTTL is how long results are kept after the upload finishes,
+	// unless all are acknowledged first. Zero means uploadTTL.
+	ResultTTL time.Duration `json:"result_ttl,omitempty"`
+
+	FailedItems int    `json:"failed_items"`
+	Error       string `json:"error,omitempty"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	CommittedAt time.Time `json:"committed_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// vectors returns the total number of vectors uploaded.
+func (s *uploadSession) vectors() int {
+	n := 0
+	for _, v := range s.PartVectors {
+		n += v
+	}
+	return n
+}
+
+// finished reports whether the upload is complete or failed.
+func (s *uploadSession) finished() bool {
+	return s.Status == uploadComplete || s.Status == uploadFailed
+}
+
+// expiresAt returns when the upload is deleted if left alone.
+func (s *uploadSession) expiresAt() time.Time {
+	switch {
+	case s.finished():
+		return s.FinishedAt.Add(s.resultTTL())
+	case s.Status == uploadOpen:
+		return s.CreatedAt.Add(uploadTTL)
+	default:
+		return time.Time{}
+	}
+}
+
+// resultTTL returns how long results are kept once the upload finishes.
+func (s *uploadSession) resultTTL() time.Duration {
+	if s.ResultTTL == 0 {
+		return uploadTTL
+	}
+	return s.ResultTTL
+}
+
+// acknowledged reports whether the result of part n was acknowledged.
+func (s *uploadSession) acknowledged(n int) bool {
+	return n <= len(s.Acknowledged) && s.Acknowledged[n-1]
+}
+
+// acknowledgedParts returns the number of acknowledged results.
+func (s *uploadSession) acknowledgedParts() int {
+	n := 0
+	for _, ack := range s.Acknowledged {
+		if ack {
+			n++
+		}
+	}
+	return n
+}
+
+// responseData renders the upload for API responses.
+func (s *uploadSession) responseData() map[string]interface{} {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"upload_id":          s.ID,
+		"format":             s.Format,
+		"role":               s.Role,
+		"key_id":             s.KeyID,
+		"status":             s.Status,
+		"parts":              len(s.PartVectors),
+		"vectors":            s.vectors(),
+		"processed_parts":    s.ProcessedParts,
+		"acknowledged_parts": s.acknowledgedParts(),
+		"result_ttl":         int64(s.resultTTL().Seconds()),
+		"failed_items":       s.FailedItems,
+		"error":              s.Error,
+		"created_at":         formatTime(s.CreatedAt),
+		"committed_at":       formatTime(s.CommittedAt),
+		"finished_at":        formatTime(s.FinishedAt),
+		"expires_at":         formatTime(s.expiresAt()),
+	}
+}
+
+// uploadFailure is an error that retrying cannot fix, such as an invalid
+// vector in a raw part. It fails the upload; other errors are retried.
+type uploadFailure struct {
+	err error
+}
+
+func (f *uploadFailure) Error() string { return f.err.Error() }
+
+// failUpload returns an uploadFailure with a formatted message.
+func failUpload(format string, args ...interface{}) error {
+	return &uploadFailure{err: fmt.Errorf(format, args...)}
+}
+
+// uploadBlob is the stored form of a part or result.
+type uploadBlob struct {
+	Data []byte `json:"data"`
+}
+
+// uploadIDField is the schema of the 'upload_id' field.
+var uploadIDField = &framework.FieldSchema{
+	Type:        framework.TypeString,
+	Description: "Upload ID returned by upload/start.",
+	Required:    true,
+}
+
+// pathUpload returns the path configuration for upload/.
+func (b *vectorBackend) pathUpload() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: withOptionalRole("upload/start"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": roleNameField,
+				"format": {
+					Type:          framework.TypeString,
+					Description:   "Format of the parts and results: 'ndjson' or 'raw' (packed little-endian float32).",
+					Default:       formatNDJSON,
+					AllowedValues: []interface{}{formatNDJSON, formatRaw},
+				},
+				"model": modelField,
+				"result_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: fmt.Sprintf("How long results are kept after the upload finishes unless acknowledged (default: %s, max: %s).", uploadTTL, maxUploadResultTTL),
+					Default:     int(uploadTTL.Seconds()),
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleUploadStart,
+					Summary:  "Start a batch uploaded in parts.",
+				},
+			},
+			HelpSynopsis:    pathUploadHelpSyn,
+			HelpDescription: pathUploadHelpDesc,
+		},
+		{
+			Pattern: "upload/part",
+			Fields: map[string]*framework.FieldSchema{
+				"upload_id": uploadIDField,
+				"part_number": {
+					Type:        framework.TypeInt,
+					Description: "Number of the part, from 1. Sending an existing part again replaces it.",
+					Required:    true,
+				},
+				"data": {
+					Type:        framework.TypeString,
+					Description: "The part: NDJSON vectors, or a base64-encoded packed float32 frame for format 'raw'.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleUploadPart,
+					Summary:  "Add a part to an open upload.",
+				},
+			},
+			HelpSynopsis:    pathUploadHelpSyn,
+			HelpDescription: pathUploadHelpDesc,
+		},
+		{
+			Pattern: "upload/commit",
+			Fields: map[string]*framework.FieldSchema{
+				"upload_id": uploadIDField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleUploadCommit,
+					Summary:  "Close an upload and queue it for encryption.",
+				},
+			},
+			HelpSynopsis:    pathUploadHelpSyn,
+			HelpDescription: pathUploadHelpDesc,
+		},
+		{
+			Pattern: "upload/" + framework.GenericNameRegex("upload_id") + "/result/(?P<part_number>[0-9]+)",
+			Fields: map[string]*framework.FieldSchema{
+				"upload_id": uploadIDField,
+				"part_number": {
+					Type:        framework.TypeInt,
+					Description: "Number of the part whose ciphertexts to return or acknowledge.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleUploadResult,
+					Summary:  "Read the ciphertexts of a processed part.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleUploadAcknowledge,
+					Summary:  "Acknowledge a part's ciphertexts, deleting them.",
+				},
+			},
+			HelpSynopsis:    pathUploadHelpSyn,
+			HelpDescription: pathUploadHelpDesc,
+		},
+		{
+			Pattern: "upload/" + framework.GenericNameRegex("upload_id"),
+			Fields: map[string]*framework.FieldSchema{
+				"upload_id": uploadIDField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleUploadRead,
+					Summary:  "Report an upload's progress.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleUploadDelete,
+					Summary:  "Abort an upload, or delete a finished one and its results.",
+				},
+			},
+			HelpSynopsis:    pathUploadHelpSyn,
+			HelpDescription: pathUploadHelpDesc,
+		},
+	}
+}
+
+// handleUploadStart opens an upload under the caller's key.
+func (b *vectorBackend) handleUploadStart(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.requestRole(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := role.checkOperation(operationUpload); err != nil {
+		return nil, err
+	}
+	resultTTL := time.Duration(data.Get("result_ttl").(int)) * time.Second
+	if resultTTL <= 0 || resultTTL > maxUploadResultTTL {
+		return nil, fmt.Errorf("result_ttl must be between 1s and %s", maxUploadResultTTL)
+	}
+	format := data.Get("format").(string)
+	if format != formatNDJSON && format != formatRaw {
+		return nil, fmt.Errorf("format must be %q or %q", formatNDJSON, formatRaw)
+	}
+	if err := role.checkFormat(format); err != nil {
+		return nil, err
+	}
+	if err := b.checkKeyUsable(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+	if err := cfg.checkModel(data.Get("model").(string)); err != nil {
+		return nil, err
+	}
+	derivationContext, err := b.resolveDerivationContext(req, role)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := contextKeyID(cfg, derivationContext)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	session := &uploadSession{
+		ID:                id,
+		Format:            format,
+		Role:              data.Get("role").(string),
+		DerivationContext: derivationContext,
+		KeyID:             keyID,
+		EntityID:          req.EntityID,
+		Owner:             callerIdentity(req),
+		Status:            uploadOpen,
+		PartVectors:       []int{},
+		ResultTTL:         resultTTL,
+		CreatedAt:         time.Now().UTC(),
+	}
+	if err := putStorageJSON(ctx, req.Storage, uploadPrefix+id, session); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: session.responseData(),
+	}, nil
+}
+
+// handleUploadPart validates and stores one part of an open upload. Parts
+// are numbered from 1 and sent in order; resending a part replaces it, so
+// a failed request can be retried.
+func (b *vectorBackend) handleUploadPart(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+	settings, err := b.getSettings(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkPressure(settings, operationUpload); err != nil {
+		return nil, err
+	}
+
+	b.uploadLock.Lock()
+	defer b.uploadLock.Unlock()
+
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return logical.ErrorResponse("upload not found"), nil
+	}
+	if session.Status != uploadOpen {
+		return nil, fmt.Errorf("upload is %s and accepts no more parts", session.Status)
+	}
+	n := data.Get("part_number").(int)
+	switch {
+	case n < 1 || n > len(session.PartVectors)+1:
+		return nil, fmt.Errorf("part_number must be between 1 and %d", len(session.PartVectors)+1)
+	case n > maxUploadParts:
+		return nil, fmt.Errorf("upload exceeds maximum %d parts", maxUploadParts)
+	}
+
+	part, vectors, err := parseUploadPart(session.Format, data.Get("data").(string), cfg.Dimension)
+	if err != nil {
+		return nil, fmt.Errorf("part %d: %w", n, err)
+	}
+	if err := putStorageJSON(ctx, req.Storage, uploadPartPath(session.ID, n), &uploadBlob{Data: part}); err != nil {
+		return nil, err
+	}
+	if n > len(session.PartVectors) {
+		session.PartVectors = append(session.PartVectors, vectors)
+	} else {
+		session.PartVectors[n-1] = vectors
+	}
+	if err := putStorageJSON(ctx, req.Storage, uploadPrefix+session.ID, session); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"upload_id":   session.ID,
+			"part_number": n,
+			"vectors":     vectors,
+		},
+	}, nil
+}
+
+// parseUploadPart decodes and checks the shape of a part, returning the
+// bytes to store and the number of vectors in it. Vector contents are only
+// validated when the part is processed.
+func parseUploadPart(format, raw string, dimension int) ([]byte, int, error) {
+	if raw == "" {
+		return nil, 0, fmt.Errorf("data is required")
+	}
+	if format == formatRaw {
+		frame, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode frame: %w", err)
+		}
+		stride := dimension * float32Size
+		if len(frame)%stride != 0 {
+			return nil, 0, fmt.Errorf("frame length %d is not a multiple of %d bytes (dimension %d * 4)",
+				len(frame), stride, dimension)
+		}
+		vectors := len(frame) / stride
+		if vectors > maxUploadPartVectors {
+			return nil, 0, fmt.Errorf("frame of %d vectors exceeds maximum %d", vectors, maxUploadPartVectors)
+		}
+		return frame, vectors, nil
+	}
+	items, err := parseNDJSONVectorsLimit(raw, maxUploadPartVectors)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(raw), len(items), nil
+}
+
+// handleUploadCommit closes an upload and queues it for processing.
+func (b *vectorBackend) handleUploadCommit(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.uploadLock.Lock()
+	defer b.uploadLock.Unlock()
+
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return logical.ErrorResponse("upload not found"), nil
+	}
+	if session.Status != uploadOpen {
+		return nil, fmt.Errorf("upload is already %s", session.Status)
+	}
+	if session.vectors() == 0 {
+		return nil, fmt.Errorf("upload has no vectors")
+	}
+	session.Status = uploadCommitted
+	session.CommittedAt = time.Now().UTC()
+	if err := putStorageJSON(ctx, req.Storage, uploadPrefix+session.ID, session); err != nil {
+		return nil, err
+	}
+	b.activity.record(time.Now(), req.EntityID, session.Role, operationUpload, session.vectors())
+	b.Logger().Info("upload committed",
+		"upload_id", session.ID,
+		"parts", len(session.PartVectors),
+		"vectors", session.vectors(),
+		"client_id", req.ClientToken)
+	return &logical.Response{
+		Data: session.responseData(),
+	}, nil
+}
+
+// handleUploadRead reports an upload's progress.
+func (b *vectorBackend) handleUploadRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: session.responseData(),
+	}, nil
+}
+
+// handleUploadDelete removes an upload with its parts and results.
+func (b *vectorBackend) handleUploadDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.uploadLock.Lock()
+	defer b.uploadLock.Unlock()
+
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, nil
+	}
+	return nil, deleteUpload(ctx, req.Storage, session)
+}
+
+// handleUploadResult returns a processed part's ciphertexts as a raw body
+// in the upload's format, in the order of the part's vectors.
+func (b *vectorBackend) handleUploadResult(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, nil
+	}
+	n := data.Get("part_number").(int)
+	if n < 1 || n > len(session.PartVectors) {
+		return nil, fmt.Errorf("part_number must be between 1 and %d", len(session.PartVectors))
+	}
+	if n > session.ProcessedParts {
+		return nil, fmt.Errorf("part %d has not been processed yet (upload is %s)", n, session.Status)
+	}
+	if session.acknowledged(n) {
+		return nil, fmt.Errorf("part %d was acknowledged and its result deleted", n)
+	}
+	var result uploadBlob
+	found, err := getStorageJSON(ctx, req.Storage, uploadResultPath(session.ID, n), &result)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	contentType := contentTypeNDJSON
+	if session.Format == formatRaw {
+		contentType = contentTypeOctetStream
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     result.Data,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// handleUploadAcknowledge deletes a processed part's result once the
+// consumer has persisted it. Until then the result can be read any number
+// of times, so a consumer that fails between reading and persisting loses
+// nothing. Acknowledging is idempotent; once every result of a finished
+// upload is acknowledged, the upload itself is deleted.
+func (b *vectorBackend) handleUploadAcknowledge(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.uploadLock.Lock()
+	defer b.uploadLock.Unlock()
+
+	session, err := readCallerUpload(ctx, req, data.Get("upload_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, nil
+	}
+	n := data.Get("part_number").(int)
+	if n < 1 || n > len(session.PartVectors) {
+		return nil, fmt.Errorf("part_number must be between 1 and %d", len(session.PartVectors))
+	}
+	if n > session.ProcessedParts {
+		return nil, fmt.Errorf("part %d has not been processed yet (upload is %s)", n, session.Status)
+	}
+	if session.acknowledged(n) {
+		return nil, nil
+	}
+	if err := deleteStorageEntry(ctx, req.Storage, uploadResultPath(session.ID, n)); err != nil {
+		return nil, err
+	}
+	if session.finished() && session.acknowledgedParts()+1 == session.ProcessedParts {
+		return nil, deleteUpload(ctx, req.Storage, session)
+	}
+	for len(session.Acknowledged) < n {
+		session.Acknowledged = append(session.Acknowledged, false)
+	}
+	session.Acknowledged[n-1] = true
+	return nil, putStorageJSON(ctx, req.Storage, uploadPrefix+session.ID, session)
+}
+
+// uploadPartPath and uploadResultPath return the storage paths of part n.
+func uploadPartPath(id string, n int) string {
+	return fmt.Sprintf("%s%s/%06d", uploadPartPrefix, id, n)
+}
+
+func uploadResultPath(id string, n int) string {
+	return fmt.Sprintf("%s%s/%06d", uploadResultPrefix, id, n)
+}
+
+// readUpload retrieves an upload session, or nil if it does not exist.
+func readUpload(ctx context.Context, storage logical.Storage, id string) (*uploadSession, error) {
+	if id == "" {
+		return nil, fmt.Errorf("upload_id is required")
+	}
+	var session uploadSession
+	found, err := getStorageJSON(ctx, storage, uploadPrefix+id, &session)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// readCallerUpload is readUpload for the caller of req, refusing uploads
+// another caller started.
+func readCallerUpload(ctx context.Context, req *logical.Request, id string) (*uploadSession, error) {
+	session, err := readUpload(ctx, req.Storage, id)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	owner := session.Owner
+	if owner == "" && session.EntityID != "" {
+		// Uploads started before owners were recorded.
+		owner = "entity:" + session.EntityID
+	}
+	if owner != callerIdentity(req) {
+		return nil, logical.ErrPermissionDenied
+	}
+	return session, nil
+}
+
+// deleteUpload removes an upload's parts, results and session, the session
+// last so that an interrupted delete can be retried.
+func deleteUpload(ctx context.Context, storage logical.Storage, session *uploadSession) error {
+	for n := 1; n <= len(session.PartVectors); n++ {
+		if err := deleteStorageEntry(ctx, storage, uploadPartPath(session.ID, n)); err != nil {
+			return err
+		}
+		if err := deleteStorageEntry(ctx, storage, uploadResultPath(session.ID, n)); err != nil {
+			return err
+		}
+	}
+	return deleteStorageEntry(ctx, storage, uploadPrefix+session.ID)
+}
+
+// runUploadJobs is called from the periodic function. It deletes expired
+// uploads and encrypts committed ones, oldest first, for at most
+// uploadJobBudget; unfinished work continues on the next run.
+func (b *vectorBackend) runUploadJobs(ctx context.Context, storage logical.Storage, now time.Time) error {
+	ids, err := storage.List(ctx, uploadPrefix)
+	if err != nil {
+		return err
+	}
+	var committed []*uploadSession
+	for _, id := range ids {
+		session, err := readUpload(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if session == nil {
+			continue
+		}
+		if expires := session.expiresAt(); !expires.IsZero() && now.After(expires) {
+			if pending := session.ProcessedParts - session.acknowledgedParts(); pending > 0 {
+				b.Logger().Warn("deleting expired upload with unacknowledged results",
+					"upload_id", session.ID, "parts", pending)
+			}
+			b.uploadLock.Lock()
+			err := deleteUpload(ctx, storage, session)
+			b.uploadLock.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if session.Status == uploadCommitted {
+			committed = append(committed, session)
+		}
+	}
+	sort.Slice(committed, func(i, j int) bool {
+		return committed[i].CommittedAt.Before(committed[j].CommittedAt)
+	})
+
+	deadline := time.Now().Add(uploadJobBudget)
+	for _, session := range committed {
+		if err := b.processUpload(ctx, storage, session.ID, deadline); err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	return nil
+}
+
+// processUpload encrypts the remaining parts of a committed upload until
+// it finishes or the deadline passes. Errors that retrying cannot fix fail
+// the upload; others are returned and retried on the next run.
+func (b *vectorBackend) processUpload(ctx context.Context, storage logical.Storage, id string, deadline time.Time) error {
+	// The key may be disabled or expired for a while; try again later.
+	if err := b.checkKeyUsable(ctx, storage); err != nil {
+		return nil
+	}
+	for time.Now().Before(deadline) {
+		session, err := readUpload(ctx, storage, id)
+		if err != nil || session == nil || session.Status != uploadCommitted {
+			return err
+		}
+		n := session.ProcessedParts + 1
+
+		result, failed, err := b.encryptUploadPart(ctx, storage, session, n)
+		var failure *uploadFailure
+		if errors.As(err, &failure) {
+			return b.finishUpload(ctx, storage, id, fmt.Errorf("part %d: %w", n, err))
+		}
+		if err != nil {
+			return err
+		}
+
+		b.uploadLock.Lock()
+		// The upload may have been deleted while the part was encrypted.
+		session, err = readUpload(ctx, storage, id)
+		if err == nil && session != nil && session.Status == uploadCommitted && session.ProcessedParts == n-1 {
+			err = putStorageJSON(ctx, storage, uploadResultPath(id, n), &uploadBlob{Data: result})
+			if err == nil {
+				err = deleteStorageEntry(ctx, storage, uploadPartPath(id, n))
+			}
+			if err == nil {
+				session.ProcessedParts = n
+				session.FailedItems += failed
+				err = putStorageJSON(ctx, storage, uploadPrefix+id, session)
+			}
+		}
+		b.uploadLock.Unlock()
+		if err != nil || session == nil {
+			return err
+		}
+		if session.ProcessedParts == len(session.PartVectors) {
+			return b.finishUpload(ctx, storage, id, nil)
+		}
+	}
+	return nil
+}
+
+// finishUpload marks an upload complete, or failed with cause.
+func (b *vectorBackend) finishUpload(ctx context.Context, storage logical.Storage, id string, cause error) error {
+	b.uploadLock.Lock()
+	defer b.uploadLock.Unlock()
+
+	session, err := readUpload(ctx, storage, id)
+	if err != nil || session == nil {
+		return err
+	}
+	session.Status = uploadComplete
+	if cause != nil {
+		session.Status = uploadFailed
+		session.Error = cause.Error()
+	}
+	session.FinishedAt = time.Now().UTC()
+	if err := putStorageJSON(ctx, storage, uploadPrefix+id, session); err != nil {
+		return err
+	}
+	b.emitEvent(ctx, "upload-"+session.Status, "upload_id", id)
+	b.Logger().Info("upload finished", "upload_id", id, "status", session.Status,
+		"vectors", session.vectors(), "failed_items", session.FailedItems)
+	return nil
+}
+
+// encryptUploadPart encrypts part n of an upload under the upload's key
+// and returns the result in the upload's format, with the number of NDJSON
+// items that failed. A raw part fails as a whole, as in encrypt/raw.
+func (b *vectorBackend) encryptUploadPart(ctx context.Context, storage logical.Storage, session *uploadSession, n int) (result []byte, failed int, retErr error) {
+	// Panic Safety: Recover from panics (e.g., gonum matrix math or memory issues).
+	defer func() {
+		if r := recover(); r != nil {
+			b.Logger().Error("internal plugin error", "panic", r)
+			retErr = failUpload("internal plugin error")
+		}
+	}()
+
+	var role *vectorRole
+	if session.Role != "" {
+		var err error
+		if role, err = b.getRole(ctx, storage, session.Role); err != nil {
+			return nil, 0, err
+		}
+		if role == nil {
+			return nil, 0, failUpload("role %q was deleted", session.Role)
+		}
+		if err := role.checkOperation(operationUpload); err != nil {
+			return nil, 0, &uploadFailure{err: err}
+		}
+	}
+
+	// Hold the matrix for the whole part, as a request would.
+	leaseCtx, lease := withMatrixLease(ctx)
+	defer b.releaseLease(lease)
+	var matrix *mat.Dense
+	var cfg *rotationConfig
+	var err error
+	if session.DerivationContext == "" {
+		matrix, cfg, err = b.getMatrixAndConfig(leaseCtx, storage)
+	} else {
+		matrix, cfg, err = b.getDerivedMatrix(leaseCtx, storage, session.DerivationContext)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	keyID, err := contextKeyID(cfg, session.DerivationContext)
+	if err != nil {
+		return nil, 0, err
+	}
+	if keyID != session.KeyID {
+		return nil, 0, failUpload("the key was rotated after the upload started; start a new upload")
+	}
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var part uploadBlob
+	found, err := getStorageJSON(ctx, storage, uploadPartPath(session.ID, n), &part)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !found {
+		return nil, 0, failUpload("part is missing from storage")
+	}
+
+	if session.Format == formatRaw {
+		vectors, err := unpackFloat32Frame(part.Data, cfg.Dimension)
+		if err != nil {
+			return nil, 0, &uploadFailure{err: err}
+		}
+		out := make([]byte, 0, len(part.Data))
+		for i, vector := range vectors {
+			r, err := b.encryptVector(matrix, cfg, settings, vector)
+			if err != nil {
+				return nil, 0, failUpload("vector %d: %w", i, err)
+			}
+			out = packFloat32(out, r.Ciphertext)
+		}
+		return out, 0, nil
+	}
+
+	items, err := parseNDJSONVectorsLimit(string(part.Data), maxUploadPartVectors)
+	if err != nil {
+		return nil, 0, &uploadFailure{err: err}
+	}
+	scheme := newSchemeParams(cfg, keyID)
+	results := make([]batchItemResult, len(items))
+	for i, raw := range items {
+		if settings.strict() {
+			if err := checkStrictVectorInput(raw); err != nil {
+				results[i].Error = err.Error()
+				failed++
+				continue
+			}
+		}
+		vector, err := parseVector(raw)
+		if err != nil {
+			results[i].Error = err.Error()
+			failed++
+			continue
+		}
+		r, err := b.encryptVector(matrix, cfg, settings, vector)
+		if err != nil {
+			results[i].Error = err.Error()
+			failed++
+			continue
+		}
+		roundToPrecision(r.Ciphertext, settings.OutputPrecision)
+		results[i].Ciphertext = r.Ciphertext
+		results[i].ClippedComponents = r.Clipped
+		results[i].OutlierComponents = r.Outliers.Components
+		results[i].InputScale = r.Outliers.Scale
+		warnings := r.warnings(settings)
+		results[i].Warnings = warningMessages(warnings)
+		results[i].StructuredWarnings = warnings
+		scheme.setItem(&results[i])
+		role.filterBatchItem(&results[i])
+	}
+	out, err := encodeNDJSON(results)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, failed, nil
+}
+
+// Help text constants for the upload paths.
+const pathUploadHelpSyn = `Encrypt a batch too large for one request, uploaded in parts.`
+
+const pathUploadHelpDesc = `
+These endpoints assemble a batch across several requests, each under
+Vault's request size limit, and encrypt it in the background.
+
+  1. upload/start (or upload/start/<role>) opens an upload and returns its
+     upload_id. 'format' is 'ndjson' (default) or 'raw'.
+  2. upload/part stores one part. Parts are numbered from 1 and sent in
+     order; resending a part replaces it, so failed requests can be retried.
+     A part holds at most 8192 vectors: NDJSON lines, or a base64-encoded
+     frame of packed little-endian float32 values for 'raw'.
+  3. upload/commit closes the upload and queues it for encryption.
+  4. READ upload/<upload_id> reports progress. Once a part is processed,
+     READ upload/<upload_id>/result/<part_number> returns its ciphertexts
+     as a raw body in the upload's format, in the order of its vectors.
+     It can be read any number of times until acknowledged.
+  5. DELETE upload/<upload_id>/result/<part_number> acknowledges a part
+     once its ciphertexts are persisted, deleting them. When every result
+     of a finished upload is acknowledged, the upload is deleted.
+  6. DELETE upload/<upload_id> aborts the upload, or removes a finished one
+     and its results.
+
+Encryption runs in the periodic function, about once a minute, for up to
+30 seconds at a time, so it continues across restarts and leader changes.
+The upload is encrypted under the key (and role) it was started with. If
+the key is rotated before processing finishes, the upload fails; while the
+key is disabled, processing waits.
+
+An upload belongs to the caller who started it: its entity, or its token
+accessor for tokens without one. Requests for it from anyone else, to any
+of the paths above, are denied.
+
+In NDJSON uploads, each vector gets its own result line, and invalid
+vectors are reported there, as in encrypt/batch. In raw uploads, an invalid
+vector fails the upload. Canary ciphertexts are not added to uploads.
+
+Open uploads are deleted 24 hours after they were started. Finished
+uploads are deleted with their unacknowledged results 'result_ttl' after
+they finished (default: 24h, max: 168h), set when the upload starts.
+The role must allow the 'upload' operation.
+`
@@ -0,0 +1,397 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// ndjsonVectors renders vectors as NDJSON lines.
+func ndjsonVectors(t *testing.T, vectors ...[]interface{}) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, v := range vectors {
+		line, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestUploadNDJSON(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "upload/start", nil)
+	id := resp.Data["upload_id"].(string)
+	if resp.Data["status"] != uploadOpen {
+		t.Fatalf("status = %v, want %s", resp.Data["status"], uploadOpen)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 1,
+		"data":        ndjsonVectors(t, testVector(0), testVector(1)),
+	})
+	part2 := ndjsonVectors(t, testVector(2)) + "[1.0, 2.0]\n"
+	resp = testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 2,
+		"data":        part2,
+	})
+	if resp.Data["vectors"] != 2 {
+		t.Errorf("part 2 vectors = %v, want 2", resp.Data["vectors"])
+	}
+
+	// Results are not available before processing.
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "upload/" + id + "/result/1",
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("result of an unprocessed part was returned")
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "upload/commit", map[string]interface{}{
+		"upload_id": id,
+	})
+	if resp.Data["status"] != uploadCommitted || resp.Data["vectors"] != 4 {
+		t.Fatalf("commit = %v, want committed with 4 vectors", resp.Data)
+	}
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil)
+	if resp.Data["status"] != uploadComplete || resp.Data["processed_parts"] != 2 || resp.Data["failed_items"] != 1 {
+		t.Fatalf("upload = %v, want complete with 2 parts and 1 failed item", resp.Data)
+	}
+	for n, want := range map[int][]bool{1: {true, true}, 2: {true, false}} {
+		resp = testRequest(t, b, s, logical.ReadOperation, fmt.Sprintf("upload/%s/result/%d", id, n), nil)
+		lines := bytes.Split(bytes.TrimSpace(resp.Data[logical.HTTPRawBody].([]byte)), []byte("\n"))
+		if len(lines) != len(want) {
+			t.Fatalf("part %d has %d result lines, want %d", n, len(lines), len(want))
+		}
+		for i, line := range lines {
+			var item batchItemResult
+			if err := json.Unmarshal(line, &item); err != nil {
+				t.Fatal(err)
+			}
+			if ok := len(item.Ciphertext) == testDimension; ok != want[i] {
+				t.Errorf("part %d item %d = %+v, want success %v", n, i, item, want[i])
+			}
+		}
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, "upload/"+id, nil)
+	if resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil); resp != nil {
+		t.Errorf("upload still readable after delete: %v", resp.Data)
+	}
+	if entries, err := s.List(ctx, uploadResultPrefix+id+"/"); err != nil || len(entries) != 0 {
+		t.Errorf("results left after delete: %v %v", entries, err)
+	}
+}
+
+func TestUploadRawUnderRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"approximation_factor": 0.0,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "roles/tenant", map[string]interface{}{
+		"derivation_context": "tenant",
+	})
+
+	id := testRequest(t, b, s, logical.UpdateOperation, "upload/start/tenant", map[string]interface{}{
+		"format": formatRaw,
+	}).Data["upload_id"].(string)
+	var frame []byte
+	for _, offset := range []float64{0, 1} {
+		v, err := parseVector(testVector(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame = packFloat32(frame, v)
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 1,
+		"data":        base64.StdEncoding.EncodeToString(frame),
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "upload/commit", map[string]interface{}{
+		"upload_id": id,
+	})
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id+"/result/1", nil)
+	if resp.Data[logical.HTTPContentType] != contentTypeOctetStream {
+		t.Errorf("content type = %v, want %s", resp.Data[logical.HTTPContentType], contentTypeOctetStream)
+	}
+	got, err := unpackFloat32Frame(resp.Data[logical.HTTPRawBody].([]byte), testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without noise, the upload matches encrypt/raw under the same role.
+	direct := testRequest(t, b, s, logical.UpdateOperation, "encrypt/raw/tenant", map[string]interface{}{
+		"frame": base64.StdEncoding.EncodeToString(frame),
+	})
+	want, err := unpackFloat32Frame(direct.Data[logical.HTTPRawBody].([]byte), testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if !equalFloats(got[i], want[i]) {
+			t.Errorf("vector %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUploadFailsAfterRotation(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	id := testRequest(t, b, s, logical.UpdateOperation, "upload/start", nil).Data["upload_id"].(string)
+	testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 1,
+		"data":        ndjsonVectors(t, testVector(0)),
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "upload/commit", map[string]interface{}{
+		"upload_id": id,
+	})
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil)
+	if resp.Data["status"] != uploadFailed || !strings.Contains(resp.Data["error"].(string), "rotated") {
+		t.Errorf("upload = %v, want failed after rotation", resp.Data)
+	}
+}
+
+func TestUploadPartRules(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	id := testRequest(t, b, s, logical.UpdateOperation, "upload/start", nil).Data["upload_id"].(string)
+
+	write := func(path string, data map[string]interface{}) error {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+	part := func(n int, data string) error {
+		return write("upload/part", map[string]interface{}{"upload_id": id, "part_number": n, "data": data})
+	}
+
+	if err := write("upload/commit", map[string]interface{}{"upload_id": id}); err == nil {
+		t.Error("empty upload was committed")
+	}
+	if err := part(2, ndjsonVectors(t, testVector(0))); err == nil {
+		t.Error("part 2 was accepted before part 1")
+	}
+	if err := part(1, "[1.0,"); err == nil {
+		t.Error("malformed part was accepted")
+	}
+	if err := part(1, ndjsonVectors(t, testVector(0))); err != nil {
+		t.Fatal(err)
+	}
+	// Resending a part replaces it.
+	if err := part(1, ndjsonVectors(t, testVector(0), testVector(1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("upload/commit", map[string]interface{}{"upload_id": id}); err != nil {
+		t.Fatal(err)
+	}
+	if err := part(2, ndjsonVectors(t, testVector(2))); err == nil {
+		t.Error("part accepted after commit")
+	}
+	if err := write("upload/commit", map[string]interface{}{"upload_id": "no-such-upload"}); err == nil {
+		t.Error("unknown upload was committed")
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil)
+	if resp.Data["vectors"] != 2 {
+		t.Errorf("vectors = %v, want 2 after replacing part 1", resp.Data["vectors"])
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	id := testRequest(t, b, s, logical.UpdateOperation, "upload/start", nil).Data["upload_id"].(string)
+	testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 1,
+		"data":        ndjsonVectors(t, testVector(0)),
+	})
+
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil); resp == nil {
+		t.Fatal("open upload deleted before its TTL")
+	}
+	if err := b.runUploadJobs(ctx, s, time.Now().Add(uploadTTL+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil); resp != nil {
+		t.Error("abandoned upload not deleted after its TTL")
+	}
+	if entries, err := s.List(ctx, uploadPartPrefix+id+"/"); err != nil || len(entries) != 0 {
+		t.Errorf("parts left after expiry: %v %v", entries, err)
+	}
+}
+
+func TestUploadResultAcknowledgement(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	id := testRequest(t, b, s, logical.UpdateOperation, "upload/start", map[string]interface{}{
+		"result_ttl": "48h",
+	}).Data["upload_id"].(string)
+	for n := 1; n <= 2; n++ {
+		testRequest(t, b, s, logical.UpdateOperation, "upload/part", map[string]interface{}{
+			"upload_id":   id,
+			"part_number": n,
+			"data":        ndjsonVectors(t, testVector(float64(n))),
+		})
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "upload/commit", map[string]interface{}{
+		"upload_id": id,
+	})
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Results can be read again until acknowledged.
+	result := "upload/" + id + "/result/1"
+	first := testRequest(t, b, s, logical.ReadOperation, result, nil).Data[logical.HTTPRawBody].([]byte)
+	second := testRequest(t, b, s, logical.ReadOperation, result, nil).Data[logical.HTTPRawBody].([]byte)
+	if !bytes.Equal(first, second) {
+		t.Error("second read of a result differs from the first")
+	}
+	testRequest(t, b, s, logical.DeleteOperation, result, nil)
+	testRequest(t, b, s, logical.DeleteOperation, result, nil)
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      result,
+		Storage:   s,
+	}); err == nil {
+		t.Error("acknowledged result was returned")
+	}
+
+	// Unacknowledged results outlive the default TTL when asked to.
+	if err := b.runUploadJobs(ctx, s, time.Now().Add(uploadTTL+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil)
+	if resp == nil {
+		t.Fatal("upload deleted before its result_ttl")
+	}
+	if resp.Data["acknowledged_parts"] != 1 || resp.Data["result_ttl"] != int64(48*3600) {
+		t.Errorf("upload = %v, want 1 acknowledged part and a 48h result_ttl", resp.Data)
+	}
+
+	// Acknowledging the last result deletes the upload.
+	testRequest(t, b, s, logical.DeleteOperation, "upload/"+id+"/result/2", nil)
+	if resp := testRequest(t, b, s, logical.ReadOperation, "upload/"+id, nil); resp != nil {
+		t.Errorf("upload kept after all results were acknowledged: %v", resp.Data)
+	}
+	if entries, err := s.List(ctx, uploadResultPrefix+id+"/"); err != nil || len(entries) != 0 {
+		t.Errorf("results left after acknowledgement: %v %v", entries, err)
+	}
+}
+
+func TestUploadBelongsToItsCreator(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	resp, err := entityRequest(b, s, "alice", logical.UpdateOperation, "upload/start", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.Data["upload_id"].(string)
+	part := map[string]interface{}{
+		"upload_id":   id,
+		"part_number": 1,
+		"data":        ndjsonVectors(t, testVector(1)),
+	}
+	commit := map[string]interface{}{"upload_id": id}
+
+	if _, err := entityRequest(b, s, "mallory", logical.UpdateOperation, "upload/part", part); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("part by another entity = %v, want permission denied", err)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "upload/part", part); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entityRequest(b, s, "mallory", logical.UpdateOperation, "upload/commit", commit); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Errorf("commit by another entity = %v, want permission denied", err)
+	}
+	if _, err := entityRequest(b, s, "alice", logical.UpdateOperation, "upload/commit", commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.runUploadJobs(ctx, s, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []struct {
+		operation logical.Operation
+		path      string
+	}{
+		{logical.ReadOperation, "upload/" + id},
+		{logical.ReadOperation, "upload/" + id + "/result/1"},
+		{logical.DeleteOperation, "upload/" + id + "/result/1"},
+		{logical.DeleteOperation, "upload/" + id},
+	} {
+		if _, err := entityRequest(b, s, "mallory", op.operation, op.path, nil); !errors.Is(err, logical.ErrPermissionDenied) {
+			t.Errorf("%s %s by another entity = %v, want permission denied", op.operation, op.path, err)
+		}
+	}
+	resp, err = entityRequest(b, s, "alice", logical.ReadOperation, "upload/"+id+"/result/1", nil)
+	if err != nil || len(resp.Data[logical.HTTPRawBody].([]byte)) == 0 {
+		t.Errorf("result for the creator = %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultReferenceNorm is the plaintext norm assumed by the security estimator.
+	// Most embedding models emit unit-normalized vectors.
+	defaultReferenceNorm = 1.0
+
+	// residualNoiseTarget is the residual noise-to-signal ratio at which an
+	// averaging attacker is considered to have effectively removed the noise.
+	residualNoiseTarget = 0.01
+)
+
+// securityMargin holds the quantitative indicators reported by verify/security-margin.
+type securityMargin struct {
+	NoiseRadius          float64
+	NoiseNormMean        float64
+	NoiseNormRMS         float64
+	NSRQuantiles         map[string]float64
+	DistanceErrorRMS     float64
+	DistanceErrorMax     float64
+	ResidualNSR          float64
+	EncryptionsToDefeat  float64
+	AveragingResistant   bool
+	EncryptionsPerVector int
+}
+
+// pathVerify returns the path configuration for verify/security-margin.
+func (b *vectorBackend) pathVerify() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "verify/security-margin",
+			Fields: map[string]*framework.FieldSchema{
+				"reference_norm": {
+					Type:        framework.TypeFloat,
+					Description: "Expected L2 norm of plaintext vectors (default: 1.0 for normalized embeddings).",
+					Default:     defaultReferenceNorm,
+				},
+				"encryptions_per_vector": {
+					Type:        framework.TypeInt,
+					Description: "Expected number of times the same plaintext is encrypted (traffic volume).",
+					Default:     1,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleVerifySecurityMargin,
+					Summary:  "Report quantitative security indicators for the current parameters.",
+				},
+			},
+			HelpSynopsis:    pathVerifySecurityMarginHelpSyn,
+			HelpDescription: pathVerifySecurityMarginHelpDesc,
+		},
+	}
+}
+
+// handleVerifySecurityMargin computes security indicators from the stored configuration.
+// It only reads the non-secret parameters; the seed and matrix are never touched.
+func (b *vectorBackend) handleVerifySecurityMargin(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errConfigNotInitialized
+	}
+
+	referenceNorm, err := coerceFloat(data.Get("reference_norm"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference_norm: %w", err)
+	}
+	if referenceNorm <= 0 || math.IsNaN(referenceNorm) || math.IsInf(referenceNorm, 0) {
+		return nil, fmt.Errorf("reference_norm must be a positive finite number (got %v)", referenceNorm)
+	}
+
+	encryptions := data.Get("encryptions_per_vector").(int)
+	if encryptions <= 0 {
+		return nil, fmt.Errorf("encryptions_per_vector must be positive (got %d)", encryptions)
+	}
+
+	m := estimateSecurityMargin(cfg, referenceNorm, encryptions)
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"dimension":                cfg.Dimension,
+			"scaling_factor":           cfg.ScalingFactor,
+			"approximation_factor":     cfg.ApproximationFactor,
+			"reference_norm":           referenceNorm,
+			"encryptions_per_vector":   m.EncryptionsPerVector,
+			"noise_radius":             m.NoiseRadius,
+			"noise_norm_mean":          m.NoiseNormMean,
+			"noise_norm_rms":           m.NoiseNormRMS,
+			"noise_to_signal_ratio":    m.NSRQuantiles,
+			"distance_error_rms":       m.DistanceErrorRMS,
+			"distance_error_max":       m.DistanceErrorMax,
+			"residual_noise_to_signal": m.ResidualNSR,
+			"encryptions_to_defeat":    m.EncryptionsToDefeat,
+			"averaging_resistant":      m.AveragingResistant,
+		},
+	}
+	if m.NoiseRadius == 0 {
+		addWarning(resp, warnNoiselessKey, "Noise radius is 0: ciphertexts carry no noise and encryption is deterministic.")
+	} else if !m.AveragingResistant {
+		addWarning(resp, warnAveragingVulnerable, fmt.Sprintf(
+			"Averaging %d ciphertexts of the same plaintext reduces noise below %.0f%% of the signal.",
+			encryptions, residualNoiseTarget*100))
+	}
+	return resp, nil
+}
+
+// estimateSecurityMargin derives closed-form security indicators for the SAP scheme.
+//
+// The noise λ is uniform in a d-ball of radius R = max(s·β/4, min_noise_radius),
+// so its norm is distributed as R·U^(1/d). This gives:
+//
+//	E[||λ||]  = R·d/(d+1)
+//	E[||λ||²] = R²·d/(d+2)
+//	P(||λ|| ≤ R·p^(1/d)) = p
+//
+// Recovered distances d_enc/s differ from plaintext distances by at most
+// ||λ₁ − λ₂||/s ≤ 2R/s (β/2 without a noise floor), with RMS error sqrt(2·E[||λ||²])/s.
+//
+// Whitened noise (noise_variance) keeps E[||λ||²], and so the RMS figures;
+// its worst case grows to R·max(w_i), and the quantiles below max are those
+// of the ball.
+//
+// An attacker averaging n ciphertexts of the same plaintext shrinks the RMS
+// noise by 1/√n, so the residual noise-to-signal ratio is
+// sqrt(E[||λ||²]/n) / (s·||v||).
+func estimateSecurityMargin(cfg *rotationConfig, referenceNorm float64, encryptions int) *securityMargin {
+	d := float64(cfg.Dimension)
+	s := cfg.ScalingFactor
+	radius := cfg.noiseRadius()
+	signal := s * referenceNorm
+
+	meanSq := radius * radius * d / (d + 2)
+	rms := math.Sqrt(meanSq)
+
+	quantiles := map[string]float64{}
+	for _, q := range []struct {
+		name string
+		p    float64
+	}{
+		{"p50", 0.50},
+		{"p90", 0.90},
+		{"p99", 0.99},
+	} {
+		quantiles[q.name] = radius * math.Pow(q.p, 1.0/d) / signal
+	}
+	quantiles["max"] = cfg.maxNoiseNorm() / signal
+
+	residual := math.Sqrt(meanSq/float64(encryptions)) / signal
+
+	// n such that sqrt(meanSq/n) / signal = residualNoiseTarget.
+	target := residualNoiseTarget * signal
+	toDefeat := math.Ceil(meanSq / (target * target))
+	if toDefeat < 1 {
+		toDefeat = 1
+	}
+
+	return &securityMargin{
+		NoiseRadius:          radius,
+		NoiseNormMean:        radius * d / (d + 1),
+		NoiseNormRMS:         rms,
+		NSRQuantiles:         quantiles,
+		DistanceErrorRMS:     math.Sqrt(2*meanSq) / s,
+		DistanceErrorMax:     2 * cfg.maxNoiseNorm() / s,
+		ResidualNSR:          residual,
+		EncryptionsToDefeat:  toDefeat,
+		AveragingResistant:   residual > residualNoiseTarget,
+		EncryptionsPerVector: encryptions,
+	}
+}
+
+// Help text constants for the verify path.
+const pathVerifySecurityMarginHelpSyn = `Report quantitative security indicators for the current SAP parameters.`
+
+const pathVerifySecurityMarginHelpDesc = `
+This endpoint derives closed-form security indicators from the configured
+dimension, scaling factor and approximation factor. It never reads the seed.
+
+Parameters:
+  reference_norm         - Expected plaintext L2 norm (default: 1.0)
+  encryptions_per_vector - Expected encryptions of the same plaintext (default: 1)
+
+Output:
+  noise_radius             - Radius of the noise ball (max(s * β / 4, min_noise_radius))
+  noise_norm_mean          - Expected ||λ||
+  noise_norm_rms           - Root-mean-square ||λ||
+  noise_to_signal_ratio    - Quantiles (p50/p90/p99/max) of ||λ|| / (s * ||v||)
+  distance_error_rms       - RMS error of recovered distances (d_enc / s - d)
+  distance_error_max       - Worst-case error of recovered distances (2R / s,
+                             times the largest scale with noise_variance)
+  residual_noise_to_signal - Noise-to-signal ratio left after averaging
+                             encryptions_per_vector ciphertexts
+  encryptions_to_defeat    - Ciphertexts of one plaintext an attacker must
+                             average to push noise below 1% of the signal
+  averaging_resistant      - Whether the residual ratio stays above 1%
+
+Example:
+  vault read vector/verify/security-margin encryptions_per_vector=100
+`
@@ -0,0 +1,57 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateSecurityMargin(t *testing.T) {
+	cfg := &rotationConfig{
+		Dimension:           1536,
+		ScalingFactor:       2.0,
+		ApproximationFactor: 4.0,
+	}
+
+	m := estimateSecurityMargin(cfg, 1.0, 1)
+
+	// R = s * β / 4 = 2.0
+	if m.NoiseRadius != 2.0 {
+		t.Errorf("NoiseRadius = %v, want 2.0", m.NoiseRadius)
+	}
+	// Worst-case recovered distance error is β / 2.
+	if math.Abs(m.DistanceErrorMax-2.0) > 1e-12 {
+		t.Errorf("DistanceErrorMax = %v, want 2.0", m.DistanceErrorMax)
+	}
+	if m.NSRQuantiles["p50"] > m.NSRQuantiles["p99"] || m.NSRQuantiles["p99"] > m.NSRQuantiles["max"] {
+		t.Errorf("NSR quantiles not monotonic: %v", m.NSRQuantiles)
+	}
+	if !m.AveragingResistant {
+		t.Error("single encryption should be averaging resistant")
+	}
+
+	// Averaging enough ciphertexts must defeat the noise.
+	defeated := estimateSecurityMargin(cfg, 1.0, int(m.EncryptionsToDefeat)+1)
+	if defeated.AveragingResistant {
+		t.Errorf("expected averaging %d ciphertexts to defeat noise (residual %v)",
+			int(m.EncryptionsToDefeat)+1, defeated.ResidualNSR)
+	}
+}
+
+func TestEstimateSecurityMarginZeroNoise(t *testing.T) {
+	cfg := &rotationConfig{
+		Dimension:           8,
+		ScalingFactor:       1.0,
+		ApproximationFactor: 0,
+	}
+
+	m := estimateSecurityMargin(cfg, 1.0, 1)
+	if m.NoiseRadius != 0 || m.ResidualNSR != 0 {
+		t.Errorf("expected zero noise, got radius %v residual %v", m.NoiseRadius, m.ResidualNSR)
+	}
+	if m.AveragingResistant {
+		t.Error("zero-noise configuration must not be reported as averaging resistant")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// defaultWarmingWait is how long a request waits by default for
+	// another request to finish generating the matrix it needs.
+	defaultWarmingWait = 30 * time.Second
+
+	// maxWarmingWait bounds warming_wait, below Vault's default request
+	// timeout.
+	maxWarmingWait = 60 * time.Second
+
+	// mountFlightKey identifies the generation of the mount key's matrix.
+	mountFlightKey = "mount"
+)
+
+// matrixFlight is a matrix generation in progress. Requests that miss the
+// cache for the same matrix wait for it instead of generating it again.
+type matrixFlight struct {
+	done    chan struct{}
+	started time.Time
+	err     error
+}
+
+// derivedFlightKey and versionFlightKey identify the generation of a
+// derived or archived key's matrix.
+func derivedFlightKey(derivationContext string) string { return "derived\x00" + derivationContext }
+func versionFlightKey(version int) string              { return "version\x00" + strconv.Itoa(version) }
+
+// errMatrixWarming is returned to a request that waited warming_wait for
+// another request's generation of its matrix. It is a 503, which clients
+// and load balancers retry.
+func errMatrixWarming(elapsed time.Duration) error {
+	return logical.CodedError(http.StatusServiceUnavailable, fmt.Sprintf(
+		"the key's matrix is being generated (for %s so far); retry shortly", elapsed.Round(time.Millisecond)))
+}
+
+// generateSharedLocked generates a matrix that missed the cache, once among
+// concurrent requests, without holding matrixLock: generation takes seconds
+// at high dimensions, and holding the lock would stall requests for every
+// other key too. It MUST be called with matrixLock held for writing and
+// returns with it held.
+//
+// The first request to miss runs generate; install then caches the result,
+// and the matrix is held for the request. Requests that miss meanwhile wait
+// up to warming_wait and return retry, upon which the caller looks the
+// cache up again. The generator retries too when the cache was invalidated
+// (e.g. by a rotation) during generation, since its result may be stale.
+func (b *vectorBackend) generateSharedLocked(ctx context.Context, storage logical.Storage, key string,
+	generate func() (*mat.Dense, error), install func(*mat.Dense)) (matrix *mat.Dense, retry bool, err error) {
+	if flight := b.matrixFlights[key]; flight != nil {
+		b.matrixLock.Unlock()
+		err := b.awaitFlight(ctx, storage, flight)
+		b.matrixLock.Lock()
+		return nil, err == nil, err
+	}
+
+	flight := &matrixFlight{done: make(chan struct{}), started: time.Now()}
+	if b.matrixFlights == nil {
+		b.matrixFlights = make(map[string]*matrixFlight)
+	}
+	b.matrixFlights[key] = flight
+	epoch := b.cacheEpoch
+	b.matrixLock.Unlock()
+
+	matrix, err = generate()
+
+	b.matrixLock.Lock()
+	flight.err = err
+	delete(b.matrixFlights, key)
+	close(flight.done)
+	if err != nil {
+		return nil, false, err
+	}
+	if b.cacheEpoch != epoch {
+		zeroMatrix(matrix)
+		return nil, true, nil
+	}
+	install(matrix)
+	b.holdMatrixLocked(ctx, matrix)
+	return matrix, false, nil
+}
+
+// awaitFlight waits for another request's generation to finish, up to the
+// mount's warming_wait. It MUST be called without matrixLock.
+func (b *vectorBackend) awaitFlight(ctx context.Context, storage logical.Storage, flight *matrixFlight) error {
+	settings, err := b.getSettings(ctx, storage)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(settings.WarmingWait)
+	defer timer.Stop()
+	select {
+	case <-flight.done:
+		return flight.err
+	case <-timer.C:
+		return errMatrixWarming(time.Since(flight.started))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
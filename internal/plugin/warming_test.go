@@ -0,0 +1,150 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+// startFlight runs generateSharedLocked for key in the background with a
+// generator that blocks until release is closed, and waits until the
+// generation is in flight.
+func startFlight(t *testing.T, b *vectorBackend, s logical.Storage, key string, release <-chan struct{}, generations *atomic.Int32) <-chan bool {
+	t.Helper()
+	installed := make(chan bool, 1)
+	go func() {
+		b.matrixLock.Lock()
+		defer b.matrixLock.Unlock()
+		_, retry, err := b.generateSharedLocked(context.Background(), s, key, func() (*mat.Dense, error) {
+			generations.Add(1)
+			<-release
+			return mat.NewDense(testDimension, testDimension, nil), nil
+		}, func(*mat.Dense) { installed <- true })
+		if err != nil || retry {
+			installed <- false
+		}
+	}()
+	for {
+		b.matrixLock.RLock()
+		inFlight := b.matrixFlights[key] != nil
+		b.matrixLock.RUnlock()
+		if inFlight {
+			return installed
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGenerateSharedWaiters(t *testing.T) {
+	b, s := getTestBackend(t)
+	var generations atomic.Int32
+	release := make(chan struct{})
+	installed := startFlight(t, b, s, mountFlightKey, release, &generations)
+
+	var wg sync.WaitGroup
+	var retries atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.matrixLock.Lock()
+			defer b.matrixLock.Unlock()
+			_, retry, err := b.generateSharedLocked(context.Background(), s, mountFlightKey, func() (*mat.Dense, error) {
+				generations.Add(1)
+				return nil, errors.New("waiter generated")
+			}, func(*mat.Dense) {})
+			if err == nil && retry {
+				retries.Add(1)
+			}
+		}()
+	}
+	// The waiters do not hold matrixLock while they wait.
+	time.Sleep(10 * time.Millisecond)
+	b.matrixLock.Lock()
+	b.matrixLock.Unlock()
+
+	close(release)
+	wg.Wait()
+	if !<-installed {
+		t.Error("generator's matrix not installed")
+	}
+	if got := generations.Load(); got != 1 {
+		t.Errorf("generations = %d, want 1", got)
+	}
+	if got := retries.Load(); got != 8 {
+		t.Errorf("waiters told to retry = %d, want 8", got)
+	}
+}
+
+func TestGenerateSharedWarmingTimeout(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"warming_wait": "0s",
+	})
+	var generations atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+	startFlight(t, b, s, derivedFlightKey("tenant"), release, &generations)
+
+	b.matrixLock.Lock()
+	_, _, err := b.generateSharedLocked(context.Background(), s, derivedFlightKey("tenant"), nil, nil)
+	b.matrixLock.Unlock()
+	var coded logical.HTTPCodedError
+	if !errors.As(err, &coded) || coded.Code() != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want a 503", err)
+	}
+}
+
+func TestGenerateSharedInvalidated(t *testing.T) {
+	b, s := getTestBackend(t)
+	var generations atomic.Int32
+	release := make(chan struct{})
+	installed := startFlight(t, b, s, mountFlightKey, release, &generations)
+
+	// A rotation lands while the matrix is being generated.
+	b.matrixLock.Lock()
+	b.zeroizeCacheLocked()
+	b.matrixLock.Unlock()
+	close(release)
+	if <-installed {
+		t.Error("matrix generated before an invalidation was cached")
+	}
+}
+
+func TestColdKeyConcurrentRequests(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+	b.matrixLock.Lock()
+	b.zeroizeCacheLocked()
+	b.matrixLock.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "encrypt/vector",
+				Data:      map[string]interface{}{"vector": testVector(0)},
+				Storage:   s,
+			})
+			if err != nil || resp.IsError() {
+				t.Errorf("encrypt: %v %v", err, resp)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Warning codes. Every response warning is also returned under
+// structured_warnings with one of these, so automation can act on warnings
+// without matching their text, which may change. A code, once released,
+// keeps its meaning.
+const (
+	// Encryption of a vector.
+	warnClippedOutput     = "CLIPPED_OUTPUT"
+	warnClippedInput      = "CLIPPED_INPUT"
+	warnScaledInput       = "SCALED_INPUT"
+	warnRepeatedPlaintext = "REPEATED_PLAINTEXT"
+	warnNearNormLimit     = "NEAR_NORM_LIMIT"
+	warnDeprecatedVersion = "DEPRECATED_KEY_VERSION"
+
+	// Keys and their parameters.
+	warnLargeMemoryConfig   = "LARGE_MEMORY_CONFIG"
+	warnNoiselessKey        = "NOISELESS_KEY"
+	warnAveragingVulnerable = "AVERAGING_VULNERABLE"
+	warnNonPortableKey      = "NON_PORTABLE_KEY"
+	warnCompromisedCopies   = "COMPROMISED_KEY_COPIES"
+
+	// Other operations.
+	warnZeroVector        = "ZERO_VECTOR"
+	warnApprovalRequired  = "APPROVAL_REQUIRED"
+	warnErasureAggregates = "ERASURE_AGGREGATES"
+	warnErasureCopies     = "ERASURE_UNTRACKED_COPIES"
+	warnStressAnomalies   = "STRESS_ANOMALIES"
+)
+
+// nearNormFraction is the share of the plaintext norm limit beyond which
+// NEAR_NORM_LIMIT is raised.
+const nearNormFraction = 0.9
+
+// typedWarning is a response warning with its code.
+type typedWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Item is the index of the vector the warning is about, in responses
+	// for several vectors that report warnings together.
+	Item *int `json:"item,omitempty"`
+}
+
+// forItem returns w about the vector at index i, its message prefixed
+// with the index.
+func (w typedWarning) forItem(i int) typedWarning {
+	return typedWarning{Code: w.Code, Message: fmt.Sprintf("vector %d: %s", i, w.Message), Item: &i}
+}
+
+// addWarning adds a warning to resp, as free text in its warnings and
+// typed in its structured_warnings.
+func addWarning(resp *logical.Response, code, message string) {
+	addWarnings(resp, []typedWarning{{Code: code, Message: message}})
+}
+
+// addWarnings adds typed warnings to resp; see addWarning.
+func addWarnings(resp *logical.Response, warnings []typedWarning) {
+	if len(warnings) == 0 {
+		return
+	}
+	if resp.Data == nil {
+		resp.Data = make(map[string]interface{})
+	}
+	structured, _ := resp.Data["structured_warnings"].([]typedWarning)
+	for _, w := range warnings {
+		resp.AddWarning(w.Message)
+		structured = append(structured, w)
+	}
+	resp.Data["structured_warnings"] = structured
+}
+
+// warningMessages returns the messages of warnings, for batch items'
+// free-text warnings.
+func warningMessages(warnings []typedWarning) []string {
+	if len(warnings) == 0 {
+		return nil
+	}
+	messages := make([]string, len(warnings))
+	for i, w := range warnings {
+		messages[i] = w.Message
+	}
+	return messages
+}
+
+// keyVersionWarnings returns DEPRECATED_KEY_VERSION when a request pinned
+// the key version of cfg with key_version and it is no longer current.
+func (b *vectorBackend) keyVersionWarnings(ctx context.Context, storage logical.Storage, cfg *rotationConfig, pinned int) ([]typedWarning, error) {
+	if pinned == 0 {
+		return nil, nil
+	}
+	current, err := b.readConfig(ctx, storage)
+	if err != nil || current == nil || current.version() == cfg.version() {
+		return nil, err
+	}
+	return []typedWarning{{
+		Code: warnDeprecatedVersion,
+		Message: fmt.Sprintf("key version %d has been replaced by version %d; rewrap its ciphertexts and move clients to the current key",
+			cfg.version(), current.version()),
+	}}, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// warningCodes returns the codes of a response's structured warnings,
+// failing the test unless they match its free-text warnings.
+func warningCodes(t *testing.T, resp *logical.Response) []string {
+	t.Helper()
+	structured, _ := resp.Data["structured_warnings"].([]typedWarning)
+	if len(structured) != len(resp.Warnings) {
+		t.Fatalf("%d structured warnings for %d warnings: %v", len(structured), len(resp.Warnings), resp.Warnings)
+	}
+	var codes []string
+	for i, w := range structured {
+		if w.Message != resp.Warnings[i] {
+			t.Errorf("structured warning %d = %q, want %q", i, w.Message, resp.Warnings[i])
+		}
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func TestStructuredWarnings(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension": testDimension,
+	})
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if _, ok := resp.Data["structured_warnings"]; ok || len(resp.Warnings) != 0 {
+		t.Errorf("warnings for an ordinary vector: %v", resp.Warnings)
+	}
+
+	// A vector just under the norm limit.
+	near := make([]interface{}, testDimension)
+	for i := range near {
+		near[i] = 0.95 * math.Sqrt(maxPlaintextNormSq/testDimension)
+	}
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": near,
+	})
+	if codes := warningCodes(t, resp); len(codes) != 1 || codes[0] != warnNearNormLimit {
+		t.Errorf("near the norm limit: codes %v", codes)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, "config/settings", map[string]interface{}{
+		"max_abs_output": 0.001,
+		"clip_policy":    clipPolicyClip,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": testVector(1),
+	})
+	if codes := warningCodes(t, resp); len(codes) != 1 || codes[0] != warnClippedOutput {
+		t.Errorf("clipped output: codes %v", codes)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/queries", map[string]interface{}{
+		"vectors": []interface{}{testVector(1), testVector(2)},
+	})
+	codes := warningCodes(t, resp)
+	structured := resp.Data["structured_warnings"].([]typedWarning)
+	if len(codes) != 2 || codes[1] != warnClippedOutput || structured[1].Item == nil || *structured[1].Item != 1 {
+		t.Errorf("query set: %+v", structured)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": []interface{}{testVector(1)},
+	})
+	item := resp.Data["batch_results"].([]batchItemResult)[0]
+	if len(item.StructuredWarnings) != 1 || item.StructuredWarnings[0].Code != warnClippedOutput ||
+		item.StructuredWarnings[0].Message != item.Warnings[0] {
+		t.Errorf("batch item warnings: %+v", item)
+	}
+}
+
+func TestStructuredWarnings_DeprecatedKeyVersion(t *testing.T) {
+	b, s := getTestBackend(t)
+	for i := 0; i < 2; i++ {
+		testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+			"dimension": testDimension,
+		})
+	}
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 2,
+	})
+	if _, ok := resp.Data["structured_warnings"]; ok {
+		t.Errorf("warning for the current version: %v", resp.Warnings)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 1,
+	})
+	if codes := warningCodes(t, resp); len(codes) != 1 || codes[0] != warnDeprecatedVersion {
+		t.Errorf("pinned old version: codes %v", codes)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors":     []interface{}{testVector(1)},
+		"key_version": 1,
+	})
+	if codes := warningCodes(t, resp); len(codes) != 1 || codes[0] != warnDeprecatedVersion {
+		t.Errorf("batch with pinned old version: codes %v", codes)
+	}
+
+	// Roles that hide warnings hide their codes too.
+	testRequest(t, b, s, logical.UpdateOperation, "roles/partners", map[string]interface{}{
+		"hidden_fields": "warnings",
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector/partners", map[string]interface{}{
+		"vector":      testVector(1),
+		"key_version": 1,
+	})
+	if _, ok := resp.Data["structured_warnings"]; ok || len(resp.Warnings) != 0 {
+		t.Errorf("role response leaks warnings: %+v", resp)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// fitVarianceFloor is the smallest variance config/fit-scale reports for a
+// component, relative to the mean variance, so that components that barely
+// vary in the sample still receive noise.
+const fitVarianceFloor = 0.01
+
+// noiseVariance is a key's diagonal covariance estimate of the corpus:
+// one variance per plaintext component. Empty means isotropic noise. It is
+// kept as supplied, so that an exported key imports to the same transform.
+type noiseVariance []float64
+
+// parseNoiseVariance parses a noise_variance value for vectors of dim
+// components. An empty value is isotropic noise.
+func parseNoiseVariance(raw interface{}, dim int) (noiseVariance, error) {
+	if isEmptySlice(raw) {
+		return nil, nil
+	}
+	values, err := parseVector(raw)
+	if err != nil {
+		return nil, fmt.Errorf("noise_variance: %w", err)
+	}
+	v := noiseVariance(values)
+	if err := v.validate(dim); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// isEmptySlice reports whether a field value holds no elements.
+func isEmptySlice(raw interface{}) bool {
+	switch v := raw.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// validate checks the variance of a key of dim components.
+func (v noiseVariance) validate(dim int) error {
+	if len(v) == 0 {
+		return nil
+	}
+	if len(v) != dim {
+		return fmt.Errorf("noise_variance has %d components, expected %d", len(v), dim)
+	}
+	for i, x := range v {
+		if !(x > 0) || math.IsInf(x, 0) {
+			return fmt.Errorf("noise_variance component %d must be a positive finite number (got %v)", i, x)
+		}
+	}
+	return nil
+}
+
+// scalesInto writes the per-component noise scales to dst: the standard
+// deviations relative to their root mean square, sqrt(v_i / mean(v)). The
+// scales have mean square 1, so whitened noise has the same expected
+// squared norm as the isotropic ball it replaces.
+func (v noiseVariance) scalesInto(dst []float64) {
+	var mean float64
+	for _, x := range v {
+		mean += x
+	}
+	mean /= float64(len(v))
+	for i, x := range v {
+		dst[i] = math.Sqrt(x / mean)
+	}
+}
+
+// maxScale returns the largest noise scale, or 1 without whitening.
+func (v noiseVariance) maxScale() float64 {
+	return v.extremeScale(math.Max)
+}
+
+// minScale returns the smallest noise scale, or 1 without whitening.
+func (v noiseVariance) minScale() float64 {
+	return v.extremeScale(math.Min)
+}
+
+func (v noiseVariance) extremeScale(pick func(a, b float64) float64) float64 {
+	if len(v) == 0 {
+		return 1
+	}
+	var mean float64
+	for _, x := range v {
+		mean += x
+	}
+	mean /= float64(len(v))
+	extreme := v[0]
+	for _, x := range v[1:] {
+		extreme = pick(extreme, x)
+	}
+	return math.Sqrt(extreme / mean)
+}
+
+// digest identifies the variance in transform IDs: a short SHA-256 of the
+// values as supplied, or "" without whitening.
+func (v noiseVariance) digest() string {
+	if len(v) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	var buf [8]byte
+	for _, x := range v {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x))
+		h.Write(buf[:])
+	}
+	return "whiten:" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// noiseShape identifies how cfg shapes its noise, for transform IDs: the
+// noise mask or the whitening digest, "" for the isotropic ball.
+func (c *rotationConfig) noiseShape() string {
+	if len(c.NoiseVariance) > 0 {
+		return c.NoiseVariance.digest()
+	}
+	return c.NoiseMask.String()
+}
+
+// maxNoiseNorm bounds ||λ||: the noise radius, stretched by the largest
+// noise scale when the noise is whitened.
+func (c *rotationConfig) maxNoiseNorm() float64 {
+	return c.noiseRadius() * c.NoiseVariance.maxScale()
+}
+
+// generateWhitenedNoise draws the ball of radius noiseRadius in plaintext
+// space, stretches each component by its noise scale, and rotates the
+// result into ciphertext space, λ = Q * W * u, so that
+// C = Q * (s * v + W * u) perturbs each plaintext component in proportion
+// to its typical spread.
+func (b *vectorBackend) generateWhitenedNoise(matrix *mat.Dense, cfg *rotationConfig, noise []float64) error {
+	planePtr := b.borrowFloats()
+	defer b.returnFloats(planePtr)
+	b.sizeFloats(planePtr, cfg.Dimension)
+	plane, err := GenerateSecureBallNoise(*planePtr, cfg.Dimension, cfg.noiseRadius())
+	if err != nil {
+		return err
+	}
+
+	scalesPtr := b.borrowFloats()
+	defer b.returnFloats(scalesPtr)
+	b.sizeFloats(scalesPtr, cfg.Dimension)
+	scales := *scalesPtr
+	cfg.NoiseVariance.scalesInto(scales)
+	for i := range plane {
+		plane[i] *= scales[i]
+	}
+
+	mat.NewVecDense(cfg.Dimension, noise[:cfg.Dimension]).MulVec(matrix, mat.NewVecDense(cfg.Dimension, plane))
+	return nil
+}
+
+// fitNoiseVariance returns the per-component variance of a sample, each
+// floored at fitVarianceFloor times the mean variance.
+func fitNoiseVariance(vectors [][]float64) noiseVariance {
+	dim := len(vectors[0])
+	n := float64(len(vectors))
+	mean := make([]float64, dim)
+	for _, v := range vectors {
+		for i, x := range v {
+			mean[i] += x / n
+		}
+	}
+	variance := make(noiseVariance, dim)
+	var total float64
+	for _, v := range vectors {
+		for i, x := range v {
+			d := x - mean[i]
+			variance[i] += d * d / n
+		}
+	}
+	for _, x := range variance {
+		total += x
+	}
+	floor := fitVarianceFloor * total / float64(dim)
+	if floor == 0 {
+		// A sample with no spread at all: fall back to isotropic weights.
+		floor = 1
+	}
+	for i, x := range variance {
+		variance[i] = math.Max(x, floor)
+	}
+	return variance
+}
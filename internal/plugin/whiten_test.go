@@ -0,0 +1,170 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParseNoiseVariance(t *testing.T) {
+	for _, raw := range []interface{}{nil, []interface{}{}} {
+		if v, err := parseNoiseVariance(raw, testDimension); err != nil || v != nil {
+			t.Errorf("%v: got %v, %v", raw, v, err)
+		}
+	}
+	v, err := parseNoiseVariance([]interface{}{"[4, 1, 1, 1, 1, 1, 1, 1]"}, testDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scales := make([]float64, testDimension)
+	v.scalesInto(scales)
+	var meanSq float64
+	for _, w := range scales {
+		meanSq += w * w / testDimension
+	}
+	if math.Abs(meanSq-1) > 1e-12 || math.Abs(scales[0]/scales[1]-2) > 1e-12 {
+		t.Errorf("scales = %v", scales)
+	}
+	if math.Abs(v.maxScale()-scales[0]) > 1e-12 || math.Abs(v.minScale()-scales[1]) > 1e-12 {
+		t.Errorf("max %v, min %v for scales %v", v.maxScale(), v.minScale(), scales)
+	}
+
+	for _, raw := range [][]interface{}{
+		{1.0, 1.0},
+		{0.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0},
+		{-1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0},
+	} {
+		if _, err := parseNoiseVariance(raw, testDimension); err == nil {
+			t.Errorf("%v: expected an error", raw)
+		}
+	}
+}
+
+func TestWhitenedNoise(t *testing.T) {
+	b, s := getTestBackend(t)
+	variance := []interface{}{16.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":            testDimension,
+		"scaling_factor":       2.0,
+		"approximation_factor": 0.0,
+		"min_noise_radius":     1.0,
+		"noise_variance":       variance,
+	})
+	if resp.Data["noise_whitened"] != true {
+		t.Fatalf("noise_whitened = %v", resp.Data["noise_whitened"])
+	}
+	matrix, cfg, err := b.getMatrixAndConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scales := make([]float64, testDimension)
+	cfg.NoiseVariance.scalesInto(scales)
+
+	// Qᵀ (C - query) is the noise in plaintext space; divided by the
+	// scales, it lies in the unit ball.
+	query := testRequest(t, b, s, logical.UpdateOperation, "encrypt/query", map[string]interface{}{
+		"vector": testVector(1),
+	}).Data["ciphertext"].([]float64)
+	var spread [2]float64
+	const draws = 200
+	for n := 0; n < draws; n++ {
+		ciphertext := testRequest(t, b, s, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+			"vector": testVector(1),
+		}).Data["ciphertext"].([]float64)
+		diff := make([]float64, testDimension)
+		for i := range diff {
+			diff[i] = ciphertext[i] - query[i]
+		}
+		var mu mat.VecDense
+		mu.MulVec(matrix.T(), mat.NewVecDense(testDimension, diff))
+
+		var norm float64
+		for i := 0; i < testDimension; i++ {
+			u := mu.AtVec(i) / scales[i]
+			norm += u * u
+		}
+		if math.Sqrt(norm) > 1+1e-9 {
+			t.Fatalf("whitened noise norm %v exceeds the radius 1", math.Sqrt(norm))
+		}
+		spread[0] += mu.AtVec(0) * mu.AtVec(0)
+		spread[1] += mu.AtVec(1) * mu.AtVec(1)
+	}
+	// Component 0 has 16 times the variance, so 4 times the noise scale.
+	if ratio := spread[0] / spread[1]; ratio < 4 {
+		t.Errorf("noise variance ratio of components 0 and 1 = %v, want about 16", ratio)
+	}
+
+	// The error bounds allow for the largest scale.
+	margin := testRequest(t, b, s, logical.ReadOperation, "verify/security-margin", nil)
+	if want := 2 * scales[0] / 2.0; math.Abs(margin.Data["distance_error_max"].(float64)-want) > 1e-12 {
+		t.Errorf("distance_error_max = %v, want %v", margin.Data["distance_error_max"], want)
+	}
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Data:      map[string]interface{}{"dimension": testDimension, "noise_variance": variance, "noise_mask": "0-3"},
+		Storage:   s,
+	})
+	if err == nil {
+		t.Error("expected noise_mask with noise_variance to be refused")
+	}
+}
+
+func TestFitNoiseVariance(t *testing.T) {
+	b, s := getTestBackend(t)
+	sample := make([]interface{}, 50)
+	for n := range sample {
+		v := make([]interface{}, testDimension)
+		for i := range v {
+			v[i] = 0.0
+		}
+		// Component 0 alternates ±1; component 1 is constant.
+		v[0] = float64(2*(n%2) - 1)
+		v[1] = 0.5
+		v[2] = float64(n%5) / 10
+		sample[n] = v
+	}
+	resp := testRequest(t, b, s, logical.UpdateOperation, "config/fit-scale", map[string]interface{}{
+		"vectors":      sample,
+		"fit_variance": true,
+	})
+	variance := resp.Data["noise_variance"].([]float64)
+	if len(variance) != testDimension || math.Abs(variance[0]-1) > 1e-12 {
+		t.Fatalf("noise_variance = %v", variance)
+	}
+	// Constant components are raised to the floor, 1% of the sample's mean
+	// variance, (1 + 0.02) / 8.
+	floor := fitVarianceFloor * (1 + 0.02) / testDimension
+	for _, i := range []int{1, 3, 7} {
+		if math.Abs(variance[i]-floor) > 1e-12 {
+			t.Errorf("component %d: variance %v, want the floor %v", i, variance[i], floor)
+		}
+	}
+
+	plain := testRequest(t, b, s, logical.UpdateOperation, "config/fit-scale", map[string]interface{}{
+		"vectors": sample,
+	})
+	if _, ok := plain.Data["noise_variance"]; ok {
+		t.Error("noise_variance returned without fit_variance")
+	}
+	if resp.Data["scaling_factor"].(float64) >= plain.Data["scaling_factor"].(float64) {
+		t.Errorf("whitened fit %v should be below the isotropic %v", resp.Data["scaling_factor"], plain.Data["scaling_factor"])
+	}
+
+	// The fitted variance is accepted by config/rotate.
+	raw := make([]interface{}, len(variance))
+	for i, x := range variance {
+		raw[i] = x
+	}
+	testRequest(t, b, s, logical.UpdateOperation, "config/rotate", map[string]interface{}{
+		"dimension":      testDimension,
+		"noise_variance": raw,
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rawgrpc carries protobuf messages that the companion services
+// encode by hand with protowire, rather than importing generated code for
+// a handful of messages.
+package rawgrpc
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Message is an encoded message, passed through by Codec.
+type Message []byte
+
+// Codec is a gRPC codec for Messages. Force it on a server or client with
+// grpc.ForceServerCodec or grpc.ForceCodec rather than registering it, so
+// it does not replace the real proto codec for others in the process.
+type Codec struct{}
+
+var _ encoding.Codec = Codec{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*Message)
+	if !ok {
+		return nil, fmt.Errorf("rawgrpc: cannot marshal %T", v)
+	}
+	return *m, nil
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*Message)
+	if !ok {
+		return fmt.Errorf("rawgrpc: cannot unmarshal into %T", v)
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+func (Codec) Name() string { return "proto" }
+
+// WalkFields calls fn for each field of a message. For varint fields v is
+// the varint's encoding; for length-delimited fields it is the contents.
+func WalkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			bytes, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			v, n = bytes, m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			v = b[:n]
+		}
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package stream is a consumer that encrypts embedding messages in a
+// message stream through a Vault mount of the plugin and republishes them.
+//
+// Each input message is a JSON object carrying a plaintext embedding in
+// one field. The consumer replaces the embedding with its ciphertext, adds
+// the scheme parameters under "vector_dpe", publishes the result to the
+// output subject, and only then acknowledges the input: the acknowledged
+// position of the durable consumer is the checkpoint. Delivery is
+// therefore at-least-once; a crash between the publish and the ack
+// republishes the message on redelivery.
+//
+// Messages that can never be encrypted (not JSON, no embedding, refused
+// by the plugin) are published to the dead-letter subject and terminated.
+// Transient failures (Vault or the output unreachable) are negatively
+// acknowledged and retried after RetryDelay.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/ops"
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// Broker is a message stream with acknowledgements. *JetStream implements
+// it.
+type Broker interface {
+	// Fetch returns up to max messages, waiting at most wait for them.
+	Fetch(ctx context.Context, max int, wait time.Duration) ([]*Message, error)
+	// Publish returns once the broker has durably stored the message.
+	Publish(ctx context.Context, subject string, data []byte) error
+}
+
+// acker settles a fetched message with the broker.
+type acker interface {
+	ack() error
+	nak(delay time.Duration) error
+	term() error
+}
+
+// Message is a fetched message. It must be settled exactly once.
+type Message struct {
+	Subject string
+	Data    []byte
+
+	acker acker
+}
+
+// Encrypter encrypts vectors, reporting refused vectors individually.
+// *vaultenc.Client implements it.
+type Encrypter interface {
+	EncryptItems(ctx context.Context, vectors [][]float64) ([]vaultenc.Item, *vaultenc.SchemeParams, error)
+}
+
+// Consumer encrypts messages from a Broker.
+type Consumer struct {
+	Broker    Broker
+	Encrypter Encrypter
+
+	// OutSubject receives the encrypted messages.
+	OutSubject string
+	// DLQSubject receives messages that cannot be encrypted, wrapped in a
+	// deadLetter. If it is empty they are only logged and terminated.
+	DLQSubject string
+	// Field is the name of the embedding field; default "embedding".
+	Field string
+	// BatchSize is the most messages encrypted in one request; default 256.
+	BatchSize int
+	// MaxWait is how long a fetch waits to fill a batch; default 1s.
+	MaxWait time.Duration
+	// RetryDelay is the redelivery delay after a transient failure;
+	// default 5s.
+	RetryDelay time.Duration
+
+	ErrorLog *log.Logger
+
+	// Metrics, if set, receives the consumer's counters when Run is
+	// called.
+	Metrics *ops.Registry
+
+	messages *ops.Counter
+}
+
+// deadLetter is the payload published to the dead-letter subject. Data is
+// the original message, which still holds the plaintext embedding, so the
+// dead-letter subject needs the same protection as the input.
+type deadLetter struct {
+	Error   string `json:"error"`
+	Subject string `json:"subject"`
+	Data    []byte `json:"data"`
+}
+
+// pending is a message on its way through a batch.
+type pending struct {
+	msg    *Message
+	fields map[string]json.RawMessage
+	vector []float64
+}
+
+// Run consumes until ctx is done or the broker fails. It returns nil when
+// ctx is done. A batch already fetched is still finished then, so a
+// drain leaves nothing waiting out the broker's ack wait.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.messages = c.Metrics.Counter("vector_dpe_stream_messages_total",
+		"Messages settled, by result: published, dead_lettered or retried.", "result")
+	for ctx.Err() == nil {
+		msgs, err := c.Broker.Fetch(ctx, c.batchSize(), c.maxWait())
+		if len(msgs) > 0 {
+			c.process(context.WithoutCancel(ctx), msgs)
+		}
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// process encrypts and republishes one batch, settling every message.
+func (c *Consumer) process(ctx context.Context, msgs []*Message) {
+	batch := make([]pending, 0, len(msgs))
+	for _, msg := range msgs {
+		p, err := c.decode(msg)
+		if err != nil {
+			c.deadLetter(ctx, msg, err.Error())
+			continue
+		}
+		batch = append(batch, p)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	vectors := make([][]float64, len(batch))
+	for i, p := range batch {
+		vectors[i] = p.vector
+	}
+	items, params, err := c.Encrypter.EncryptItems(ctx, vectors)
+	if err == nil && len(items) != len(batch) {
+		err = fmt.Errorf("got %d results for %d vectors", len(items), len(batch))
+	}
+	if err != nil {
+		c.logf("encrypt failed, retrying %d messages in %s: %v", len(batch), c.retryDelay(), err)
+		for _, p := range batch {
+			c.retry(p.msg)
+		}
+		return
+	}
+
+	for i, p := range batch {
+		if items[i].Error != "" {
+			c.deadLetter(ctx, p.msg, "the plugin refused the embedding: "+items[i].Error)
+			continue
+		}
+		out, err := c.encode(p, items[i].Ciphertext, params)
+		if err == nil {
+			err = c.Broker.Publish(ctx, c.OutSubject, out)
+		}
+		if err != nil {
+			c.logf("publish to %s failed, retrying in %s: %v", c.OutSubject, c.retryDelay(), err)
+			c.retry(p.msg)
+			continue
+		}
+		c.messages.Inc("published")
+		c.settle(p.msg.acker.ack())
+	}
+}
+
+// decode extracts the embedding of a message.
+func (c *Consumer) decode(msg *Message) (pending, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Data, &fields); err != nil {
+		return pending{}, fmt.Errorf("message is not a JSON object: %w", err)
+	}
+	raw, ok := fields[c.field()]
+	if !ok {
+		return pending{}, fmt.Errorf("message has no %q field", c.field())
+	}
+	var vector []float64
+	if err := json.Unmarshal(raw, &vector); err != nil {
+		return pending{}, fmt.Errorf("%q is not an array of numbers: %w", c.field(), err)
+	}
+	if len(vector) == 0 {
+		return pending{}, fmt.Errorf("%q is empty", c.field())
+	}
+	return pending{msg: msg, fields: fields, vector: vector}, nil
+}
+
+// encode replaces the embedding of a decoded message with its ciphertext.
+func (c *Consumer) encode(p pending, ciphertext []float64, params *vaultenc.SchemeParams) ([]byte, error) {
+	var err error
+	if p.fields[c.field()], err = json.Marshal(ciphertext); err != nil {
+		return nil, err
+	}
+	if params != nil {
+		if p.fields["vector_dpe"], err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(p.fields)
+}
+
+// deadLetter publishes msg to the dead-letter subject and terminates it,
+// so it is never redelivered. If the dead-letter publish fails the message
+// is retried instead, so nothing is dropped silently.
+func (c *Consumer) deadLetter(ctx context.Context, msg *Message, reason string) {
+	if c.DLQSubject == "" {
+		c.logf("dropping message from %s: %s", msg.Subject, reason)
+		c.messages.Inc("dead_lettered")
+		c.settle(msg.acker.term())
+		return
+	}
+	body, err := json.Marshal(deadLetter{Error: reason, Subject: msg.Subject, Data: msg.Data})
+	if err == nil {
+		err = c.Broker.Publish(ctx, c.DLQSubject, body)
+	}
+	if err != nil {
+		c.logf("dead-letter publish to %s failed, retrying in %s: %v", c.DLQSubject, c.retryDelay(), err)
+		c.retry(msg)
+		return
+	}
+	c.messages.Inc("dead_lettered")
+	c.settle(msg.acker.term())
+}
+
+// retry asks the broker to redeliver msg after RetryDelay.
+func (c *Consumer) retry(msg *Message) {
+	c.messages.Inc("retried")
+	c.settle(msg.acker.nak(c.retryDelay()))
+}
+
+// settle logs a failed acknowledgement. The broker redelivers the message
+// after its ack wait, so there is nothing else to do.
+func (c *Consumer) settle(err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.logf("acknowledgement failed: %v", err)
+	}
+}
+
+func (c *Consumer) field() string {
+	if c.Field == "" {
+		return "embedding"
+	}
+	return c.Field
+}
+
+func (c *Consumer) batchSize() int {
+	if c.BatchSize <= 0 {
+		return 256
+	}
+	return c.BatchSize
+}
+
+func (c *Consumer) maxWait() time.Duration {
+	if c.MaxWait <= 0 {
+		return time.Second
+	}
+	return c.MaxWait
+}
+
+func (c *Consumer) retryDelay() time.Duration {
+	if c.RetryDelay <= 0 {
+		return 5 * time.Second
+	}
+	return c.RetryDelay
+}
+
+func (c *Consumer) logf(format string, args ...interface{}) {
+	if c.ErrorLog != nil {
+		c.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/vaultenc"
+)
+
+// recordAcker records how a message was settled.
+type recordAcker struct{ settled *string }
+
+func (a recordAcker) ack() error              { *a.settled = "ack"; return nil }
+func (a recordAcker) nak(time.Duration) error { *a.settled = "nak"; return nil }
+func (a recordAcker) term() error             { *a.settled = "term"; return nil }
+
+// fakeBroker records publishes and fails those to failSubject.
+type fakeBroker struct {
+	published   map[string][][]byte
+	failSubject string
+}
+
+func (f *fakeBroker) Fetch(context.Context, int, time.Duration) ([]*Message, error) {
+	return nil, nil
+}
+
+func (f *fakeBroker) Publish(_ context.Context, subject string, data []byte) error {
+	if subject == f.failSubject {
+		return fmt.Errorf("stream unavailable")
+	}
+	if f.published == nil {
+		f.published = map[string][][]byte{}
+	}
+	f.published[subject] = append(f.published[subject], data)
+	return nil
+}
+
+// fakeEncrypter doubles every component and refuses vectors whose first
+// component is negative.
+type fakeEncrypter struct{ err error }
+
+func (f fakeEncrypter) EncryptItems(_ context.Context, vectors [][]float64) ([]vaultenc.Item, *vaultenc.SchemeParams, error) {
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	items := make([]vaultenc.Item, len(vectors))
+	for i, v := range vectors {
+		if v[0] < 0 {
+			items[i].Error = "vector norm out of range"
+			continue
+		}
+		items[i].Ciphertext = make([]float64, len(v))
+		for j, x := range v {
+			items[i].Ciphertext[j] = 2 * x
+		}
+	}
+	return items, &vaultenc.SchemeParams{Scheme: "sap", KeyVersion: 2, Dimension: 2, TransformID: "abc"}, nil
+}
+
+func testMessages(payloads ...string) ([]*Message, []string) {
+	settled := make([]string, len(payloads))
+	msgs := make([]*Message, len(payloads))
+	for i, p := range payloads {
+		msgs[i] = &Message{Subject: "embeddings.in", Data: []byte(p), acker: recordAcker{&settled[i]}}
+	}
+	return msgs, settled
+}
+
+func testConsumer(broker *fakeBroker, enc Encrypter) *Consumer {
+	return &Consumer{
+		Broker:     broker,
+		Encrypter:  enc,
+		OutSubject: "embeddings.out",
+		DLQSubject: "embeddings.dlq",
+		ErrorLog:   log.New(io.Discard, "", 0),
+	}
+}
+
+func TestConsumer_Process(t *testing.T) {
+	broker := &fakeBroker{}
+	msgs, settled := testMessages(
+		`{"id":"a","embedding":[0.5,1]}`,
+		`not json`,
+		`{"id":"b","embedding":[-1,1]}`,
+		`{"id":"c"}`,
+	)
+	testConsumer(broker, fakeEncrypter{}).process(context.Background(), msgs)
+
+	if want := []string{"ack", "term", "term", "term"}; fmt.Sprint(settled) != fmt.Sprint(want) {
+		t.Errorf("settled = %v, want %v", settled, want)
+	}
+
+	out := broker.published["embeddings.out"]
+	if len(out) != 1 {
+		t.Fatalf("published %d messages, want 1", len(out))
+	}
+	var msg struct {
+		ID        string                `json:"id"`
+		Embedding []float64             `json:"embedding"`
+		Params    vaultenc.SchemeParams `json:"vector_dpe"`
+	}
+	if err := json.Unmarshal(out[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != "a" || fmt.Sprint(msg.Embedding) != "[1 2]" || msg.Params.TransformID != "abc" {
+		t.Errorf("published %s", out[0])
+	}
+
+	dlq := broker.published["embeddings.dlq"]
+	if len(dlq) != 3 {
+		t.Fatalf("dead-lettered %d messages, want 3", len(dlq))
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(dlq[2], &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Subject != "embeddings.in" || string(letter.Data) != `{"id":"b","embedding":[-1,1]}` || letter.Error == "" {
+		t.Errorf("dead letter = %+v", letter)
+	}
+}
+
+func TestConsumer_TransientFailures(t *testing.T) {
+	// Vault unreachable: everything is retried, nothing published.
+	broker := &fakeBroker{}
+	msgs, settled := testMessages(`{"embedding":[1,1]}`, `{"embedding":[2,2]}`)
+	testConsumer(broker, fakeEncrypter{err: fmt.Errorf("connection refused")}).process(context.Background(), msgs)
+	if fmt.Sprint(settled) != "[nak nak]" || len(broker.published) != 0 {
+		t.Errorf("settled = %v, published = %v", settled, broker.published)
+	}
+
+	// Output unavailable: the input is not acknowledged.
+	broker = &fakeBroker{failSubject: "embeddings.out"}
+	msgs, settled = testMessages(`{"embedding":[1,1]}`)
+	testConsumer(broker, fakeEncrypter{}).process(context.Background(), msgs)
+	if settled[0] != "nak" {
+		t.Errorf("settled = %v, want nak", settled)
+	}
+
+	// Dead-letter subject unavailable: the message is kept, not dropped.
+	broker = &fakeBroker{failSubject: "embeddings.dlq"}
+	msgs, settled = testMessages(`[]`)
+	testConsumer(broker, fakeEncrypter{}).process(context.Background(), msgs)
+	if settled[0] != "nak" {
+		t.Errorf("settled = %v, want nak", settled)
+	}
+}
+
+func TestConsumer_CustomField(t *testing.T) {
+	broker := &fakeBroker{}
+	msgs, settled := testMessages(`{"vec":[1,3],"embedding":"untouched"}`)
+	c := testConsumer(broker, fakeEncrypter{})
+	c.Field = "vec"
+	c.process(context.Background(), msgs)
+	if settled[0] != "ack" {
+		t.Fatalf("settled = %v", settled)
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(broker.published["embeddings.out"][0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if string(msg["vec"]) != "[2,6]" || string(msg["embedding"]) != `"untouched"` {
+		t.Errorf("published %v", msg)
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package stream
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The NATS client protocol is text-based and small, so JetStream is spoken
+// directly here rather than through a client library: connect, one pull
+// consumer, acknowledged publishes, and acks. See
+// https://docs.nats.io/reference/reference-protocols/nats-protocol and
+// https://docs.nats.io/reference/reference-protocols/nats_api_reference.
+
+// Subscription IDs of the connection's two inbox subscriptions.
+const (
+	sidFetch = "1"
+	sidReply = "2"
+)
+
+// maxControlLine bounds a protocol line, as the server does.
+const maxControlLine = 4096
+
+// natsMsg is a message delivered to one of the inbox subscriptions.
+type natsMsg struct {
+	subject string
+	reply   string
+	status  int // from a status header (HMSG), or 0
+	data    []byte
+}
+
+// JetStream is a connection to a NATS server that consumes from one
+// durable pull consumer and publishes to JetStream subjects. It is safe
+// for one Fetch at a time, concurrently with Publish.
+type JetStream struct {
+	// Stream and Consumer name the durable pull consumer to fetch from,
+	// which must exist.
+	Stream   string
+	Consumer string
+
+	// PublishTimeout bounds the wait for a publish to be stored.
+	PublishTimeout time.Duration
+
+	conn  net.Conn
+	wmu   sync.Mutex
+	w     *bufio.Writer
+	inbox string
+
+	fetched chan *natsMsg
+
+	mu      sync.Mutex
+	replies map[string]chan *natsMsg
+	nextID  int
+	err     error
+	done    chan struct{}
+}
+
+// DialJetStream connects to the NATS server at rawURL: nats://host:port,
+// or tls://host:port to require TLS. User and password, or a token as the
+// user alone, may be given in the URL. tlsConfig may be nil.
+func DialJetStream(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*JetStream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	js, err := newJetStream(conn, u, tlsConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return js, nil
+}
+
+// newJetStream runs the connection handshake on conn and starts reading.
+func newJetStream(conn net.Conn, u *url.URL, tlsConfig *tls.Config) (*JetStream, error) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReaderSize(conn, 64*1024)
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("read INFO: %w", err)
+	}
+	info, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		return nil, fmt.Errorf("expected INFO from the server, got %q", line)
+	}
+	var serverInfo struct {
+		TLSRequired bool `json:"tls_required"`
+		Headers     bool `json:"headers"`
+		JetStream   bool `json:"jetstream"`
+	}
+	if err := json.Unmarshal([]byte(info), &serverInfo); err != nil {
+		return nil, fmt.Errorf("parse INFO: %w", err)
+	}
+	if !serverInfo.Headers {
+		return nil, fmt.Errorf("the NATS server does not support headers; JetStream requires NATS 2.2 or later")
+	}
+
+	if serverInfo.TLSRequired || u.Scheme == "tls" {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		conn = tlsConn
+		r = bufio.NewReaderSize(conn, 64*1024)
+	}
+
+	connect := map[string]interface{}{
+		"verbose":       false,
+		"pedantic":      false,
+		"name":          "vector-dpe-stream",
+		"lang":          "go",
+		"version":       "1",
+		"protocol":      1,
+		"headers":       true,
+		"no_responders": true,
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			connect["user"], connect["pass"] = u.User.Username(), pass
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	body, err := json.Marshal(connect)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	js := &JetStream{
+		PublishTimeout: 10 * time.Second,
+		conn:           conn,
+		w:              bufio.NewWriter(conn),
+		inbox:          "_INBOX." + hex.EncodeToString(id),
+		fetched:        make(chan *natsMsg, 1024),
+		replies:        map[string]chan *natsMsg{},
+		done:           make(chan struct{}),
+	}
+	fmt.Fprintf(js.w, "CONNECT %s\r\nPING\r\n", body)
+	if err := js.w.Flush(); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, fmt.Errorf("connect: %w", err)
+		}
+		if line == "PONG" {
+			break
+		}
+		if msg, ok := strings.CutPrefix(line, "-ERR "); ok {
+			return nil, fmt.Errorf("connect: %s", strings.Trim(msg, "'"))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	fmt.Fprintf(js.w, "SUB %s.fetch %s\r\nSUB %s.reply.* %s\r\n", js.inbox, sidFetch, js.inbox, sidReply)
+	if err := js.w.Flush(); err != nil {
+		return nil, err
+	}
+	go js.readLoop(r)
+	return js, nil
+}
+
+// Close closes the connection. Unacknowledged messages are redelivered
+// by the server after the consumer's ack wait.
+func (js *JetStream) Close() error {
+	return js.conn.Close()
+}
+
+// fail records the connection's first error, closes the connection and
+// wakes every waiter.
+func (js *JetStream) fail(err error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.err == nil {
+		js.err = err
+		close(js.done)
+		js.conn.Close()
+	}
+}
+
+// Err returns the error that closed the connection, or nil while it is
+// open.
+func (js *JetStream) Err() error {
+	return js.failure()
+}
+
+// failure returns the error that closed the connection.
+func (js *JetStream) failure() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.err
+}
+
+// readLoop dispatches what the server sends until the connection fails.
+func (js *JetStream) readLoop(r *bufio.Reader) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			js.fail(fmt.Errorf("NATS connection lost: %w", err))
+			return
+		}
+		switch {
+		case line == "PING":
+			if err := js.write("PONG\r\n", nil); err != nil {
+				js.fail(err)
+				return
+			}
+		case line == "PONG", line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR "):
+			js.fail(fmt.Errorf("NATS server error: %s", strings.Trim(strings.TrimPrefix(line, "-ERR "), "'")))
+			return
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			sid, msg, err := readMsg(r, line)
+			if err != nil {
+				js.fail(err)
+				return
+			}
+			js.dispatch(sid, msg)
+		default:
+			js.fail(fmt.Errorf("unexpected NATS protocol line %q", line))
+			return
+		}
+	}
+}
+
+// dispatch routes a message to the fetch in progress or to the waiter of
+// a reply.
+func (js *JetStream) dispatch(sid string, msg *natsMsg) {
+	if sid == sidFetch {
+		select {
+		case js.fetched <- msg:
+		case <-js.done:
+		}
+		return
+	}
+	js.mu.Lock()
+	ch, ok := js.replies[msg.subject]
+	delete(js.replies, msg.subject)
+	js.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// readLine reads one CRLF-terminated protocol line.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", fmt.Errorf("protocol line exceeds %d bytes", r.Size())
+		}
+		return "", err
+	}
+	if len(line) > maxControlLine {
+		return "", fmt.Errorf("protocol line exceeds %d bytes", maxControlLine)
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readMsg reads the payload of a MSG or HMSG whose control line is line:
+//
+//	MSG <subject> <sid> [reply-to] <#bytes>
+//	HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+func readMsg(r *bufio.Reader, line string) (string, *natsMsg, error) {
+	fields := strings.Fields(line)
+	headers := fields[0] == "HMSG"
+	args := fields[1:]
+	want := 3
+	if headers {
+		want = 4
+	}
+	if len(args) != want && len(args) != want+1 {
+		return "", nil, fmt.Errorf("malformed %s line %q", fields[0], line)
+	}
+	msg := &natsMsg{subject: args[0]}
+	sid := args[1]
+	if len(args) == want+1 {
+		msg.reply = args[2]
+	}
+	total, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || total < 0 {
+		return "", nil, fmt.Errorf("malformed %s line %q", fields[0], line)
+	}
+	headerLen := 0
+	if headers {
+		if headerLen, err = strconv.Atoi(args[len(args)-2]); err != nil || headerLen < 0 || headerLen > total {
+			return "", nil, fmt.Errorf("malformed HMSG line %q", line)
+		}
+	}
+
+	payload := make([]byte, total+2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, err
+	}
+	if headers {
+		msg.status = parseStatus(payload[:headerLen])
+	}
+	msg.data = payload[headerLen:total]
+	return sid, msg, nil
+}
+
+// parseStatus returns the status code of a header block that starts with
+// "NATS/1.0 <code> <description>", or 0.
+func parseStatus(header []byte) int {
+	line, _, _ := strings.Cut(string(header), "\r\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "NATS/1.0" {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+// write sends a protocol line, followed by payload and CRLF when payload
+// is not nil.
+func (js *JetStream) write(line string, payload []byte) error {
+	js.wmu.Lock()
+	defer js.wmu.Unlock()
+	js.w.WriteString(line)
+	if payload != nil {
+		js.w.Write(payload)
+		js.w.WriteString("\r\n")
+	}
+	return js.w.Flush()
+}
+
+// publish sends a PUB, with reply as the reply subject if it is not empty.
+func (js *JetStream) publish(subject, reply string, data []byte) error {
+	if reply != "" {
+		return js.write(fmt.Sprintf("PUB %s %s %d\r\n", subject, reply, len(data)), data)
+	}
+	return js.write(fmt.Sprintf("PUB %s %d\r\n", subject, len(data)), data)
+}
+
+// Fetch requests up to max messages from the pull consumer and returns
+// those delivered within wait, possibly none.
+func (js *JetStream) Fetch(ctx context.Context, max int, wait time.Duration) ([]*Message, error) {
+	if err := js.failure(); err != nil {
+		return nil, err
+	}
+	// Drain leftovers of an earlier fetch abandoned by its context, so
+	// they are not mistaken for this fetch's.
+	for len(js.fetched) > 0 {
+		<-js.fetched
+	}
+
+	request, err := json.Marshal(map[string]interface{}{
+		"batch":   max,
+		"expires": wait.Nanoseconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	subject := "$JS.API.CONSUMER.MSG.NEXT." + js.Stream + "." + js.Consumer
+	if err := js.publish(subject, js.inbox+".fetch", request); err != nil {
+		return nil, err
+	}
+
+	// The server ends a short batch with a 408 when wait expires; the
+	// extra second covers its clock and the round trip.
+	timer := time.NewTimer(wait + time.Second)
+	defer timer.Stop()
+	var out []*Message
+	for len(out) < max {
+		select {
+		case msg := <-js.fetched:
+			switch msg.status {
+			case 0:
+				out = append(out, &Message{Subject: msg.subject, Data: msg.data, acker: jsAcker{js, msg.reply}})
+			case 404, 408:
+				// No messages, or the request expired.
+				return out, nil
+			case 100:
+				// Idle heartbeat.
+			default:
+				return out, fmt.Errorf("fetch from %s/%s: status %d", js.Stream, js.Consumer, msg.status)
+			}
+		case <-timer.C:
+			return out, nil
+		case <-ctx.Done():
+			return out, ctx.Err()
+		case <-js.done:
+			return out, js.failure()
+		}
+	}
+	return out, nil
+}
+
+// Publish publishes data to subject and waits for JetStream to store it.
+func (js *JetStream) Publish(ctx context.Context, subject string, data []byte) error {
+	js.mu.Lock()
+	if js.err != nil {
+		js.mu.Unlock()
+		return js.err
+	}
+	js.nextID++
+	reply := js.inbox + ".reply." + strconv.Itoa(js.nextID)
+	ch := make(chan *natsMsg, 1)
+	js.replies[reply] = ch
+	js.mu.Unlock()
+	defer func() {
+		js.mu.Lock()
+		delete(js.replies, reply)
+		js.mu.Unlock()
+	}()
+
+	if err := js.publish(subject, reply, data); err != nil {
+		return err
+	}
+	timer := time.NewTimer(js.PublishTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		if msg.status == 503 {
+			return fmt.Errorf("publish to %s: no stream stores this subject", subject)
+		}
+		var ack struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(msg.data, &ack); err != nil {
+			return fmt.Errorf("publish to %s: invalid acknowledgement: %w", subject, err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("publish to %s: %s (%d)", subject, ack.Error.Description, ack.Error.Code)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("publish to %s: no acknowledgement within %s", subject, js.PublishTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-js.done:
+		return js.failure()
+	}
+}
+
+// jsAcker acknowledges a JetStream message through its reply subject.
+type jsAcker struct {
+	js    *JetStream
+	reply string
+}
+
+func (a jsAcker) ack() error { return a.js.publish(a.reply, "", []byte("+ACK")) }
+
+func (a jsAcker) nak(delay time.Duration) error {
+	return a.js.publish(a.reply, "", []byte(fmt.Sprintf(`-NAK {"delay":%d}`, delay.Nanoseconds())))
+}
+
+func (a jsAcker) term() error { return a.js.publish(a.reply, "", []byte("+TERM")) }
@@ -0,0 +1,183 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package vaultenc is the encrypt/batch client shared by the plugin's
+// companion services, the Envoy external processor and the stream
+// consumer.
+package vaultenc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// MaxBatchVectors is the plugin's encrypt/batch limit.
+const MaxBatchVectors = 1024
+
+// SchemeParams are the scheme parameters the plugin reports with each
+// response; see the plugin's scheme.go. Companions add them to what they
+// rewrite under "vector_dpe", as the plugin's own embeddings endpoint does.
+type SchemeParams struct {
+	Scheme      string `json:"scheme"`
+	KeyVersion  int    `json:"key_version"`
+	Dimension   int    `json:"dimension"`
+	TransformID string `json:"transform_id"`
+}
+
+// Item is the result for one vector: a ciphertext, or the plugin's reason
+// for refusing the vector.
+type Item struct {
+	Ciphertext []float64
+	Error      string
+}
+
+// Client encrypts through a mount's encrypt/batch endpoint, under an
+// optional role.
+type Client struct {
+	Vault *api.Client
+	Mount string
+	Role  string
+}
+
+// Encrypt returns the ciphertexts of vectors in input order. Any vector
+// the plugin refuses fails the whole call.
+func (c *Client) Encrypt(ctx context.Context, vectors [][]float64) ([][]float64, *SchemeParams, error) {
+	items, params, err := c.EncryptItems(ctx, vectors)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([][]float64, len(items))
+	for i, item := range items {
+		if item.Error != "" {
+			return nil, nil, fmt.Errorf("vector %d: %s", i, item.Error)
+		}
+		out[i] = item.Ciphertext
+	}
+	return out, params, nil
+}
+
+// EncryptItems returns one item per vector, in input order, so that the
+// caller can handle refused vectors individually. An error means no
+// result at all. A key rotation between chunks is an error rather than a
+// mix of ciphertexts under two keys.
+func (c *Client) EncryptItems(ctx context.Context, vectors [][]float64) ([]Item, *SchemeParams, error) {
+	path := strings.Trim(c.Mount, "/") + "/encrypt/batch"
+	if c.Role != "" {
+		path += "/" + c.Role
+	}
+
+	out := make([]Item, 0, len(vectors))
+	var params *SchemeParams
+	for start := 0; start < len(vectors); start += MaxBatchVectors {
+		chunk := vectors[start:min(start+MaxBatchVectors, len(vectors))]
+		secret, err := c.Vault.Logical().WriteWithContext(ctx, path, map[string]interface{}{
+			"vectors": chunk,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret == nil {
+			return nil, nil, fmt.Errorf("empty response from %s", path)
+		}
+		next, err := responseParams(secret.Data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if params != nil && next.TransformID != params.TransformID {
+			return nil, nil, fmt.Errorf("the key changed during the request")
+		}
+		params = next
+
+		results, _ := secret.Data["batch_results"].([]interface{})
+		n := 0
+		for _, raw := range results {
+			result, _ := raw.(map[string]interface{})
+			if canary, _ := result["canary"].(bool); canary {
+				// Canaries are decoys for leak detection, not results.
+				continue
+			}
+			if msg, ok := result["error"].(string); ok && msg != "" {
+				out = append(out, Item{Error: msg})
+				n++
+				continue
+			}
+			ciphertext, err := toFloats(result["ciphertext"])
+			if err != nil {
+				return nil, nil, fmt.Errorf("vector %d: %w", start+n, err)
+			}
+			out = append(out, Item{Ciphertext: ciphertext})
+			n++
+		}
+		if n != len(chunk) {
+			return nil, nil, fmt.Errorf("got %d results for %d vectors", n, len(chunk))
+		}
+	}
+	return out, params, nil
+}
+
+// Ready returns an error unless Vault is initialized and unsealed, for
+// readiness probes.
+func (c *Client) Ready(ctx context.Context) error {
+	health, err := c.Vault.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	if !health.Initialized || health.Sealed {
+		return fmt.Errorf("vault is sealed or not initialized")
+	}
+	return nil
+}
+
+// responseParams returns the scheme parameters of a batch response.
+func responseParams(data map[string]interface{}) (*SchemeParams, error) {
+	params := &SchemeParams{}
+	params.Scheme, _ = data["scheme"].(string)
+	params.TransformID, _ = data["transform_id"].(string)
+	if params.TransformID == "" {
+		return nil, fmt.Errorf("response has no transform_id; the plugin is too old for this client")
+	}
+	params.KeyVersion, _ = toInt(data["key_version"])
+	params.Dimension, _ = toInt(data["dimension"])
+	return params, nil
+}
+
+// toFloats converts a decoded JSON array of numbers.
+func toFloats(v interface{}) ([]float64, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of numbers, got %T", v)
+	}
+	out := make([]float64, len(items))
+	for i, item := range items {
+		switch n := item.(type) {
+		case json.Number:
+			f, err := n.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out[i] = f
+		case float64:
+			out[i] = n
+		default:
+			return nil, fmt.Errorf("element %d: expected a number, got %T", i, item)
+		}
+	}
+	return out, nil
+}
+
+// toInt converts a decoded JSON number.
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
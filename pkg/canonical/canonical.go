@@ -0,0 +1,93 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package canonical defines the canonical byte serialization of a
+// ciphertext. Anything that hashes, MACs or signs a ciphertext must do so
+// over these bytes, so that clients in any language, storing ciphertexts at
+// any precision, compute the same digest for the same ciphertext.
+//
+// Version 1 is laid out as follows, with no padding:
+//
+//	offset  size     field
+//	0       1        version, 0x01
+//	1       4        dimension n, unsigned big-endian
+//	5       8 * n    components in index order, each an IEEE 754 binary64
+//	                 in big-endian byte order
+//
+// Components must be finite. Negative zero is encoded as positive zero, so
+// numerically equal ciphertexts have one encoding. Values rounded to
+// float32 widen to binary64 exactly, so a ciphertext kept as float32 by a
+// vector database encodes to the same bytes as the float32-rounded
+// ciphertext the plugin returned.
+package canonical
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Version is the version byte of the encoding produced by Marshal.
+const Version byte = 1
+
+const (
+	headerSize    = 1 + 4
+	componentSize = 8
+)
+
+// ErrVersion is returned by Unmarshal for an encoding of an unknown version.
+var ErrVersion = errors.New("unsupported canonical ciphertext version")
+
+// Marshal returns the canonical encoding of ciphertext.
+func Marshal(ciphertext []float64) ([]byte, error) {
+	return Append(make([]byte, 0, headerSize+componentSize*len(ciphertext)), ciphertext)
+}
+
+// Append appends the canonical encoding of ciphertext to dst.
+func Append(dst []byte, ciphertext []float64) ([]byte, error) {
+	if uint64(len(ciphertext)) > math.MaxUint32 {
+		return nil, fmt.Errorf("dimension %d exceeds the encoding's limit", len(ciphertext))
+	}
+	dst = append(dst, Version)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(ciphertext)))
+	for i, v := range ciphertext {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("component %d is not finite", i)
+		}
+		if v == 0 {
+			v = 0 // normalize -0
+		}
+		dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(v))
+	}
+	return dst, nil
+}
+
+// Unmarshal decodes a canonical encoding. It rejects any input that Marshal
+// would not have produced, so decoding and re-encoding is the identity.
+func Unmarshal(data []byte) ([]float64, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("encoding of %d bytes is shorter than its header", len(data))
+	}
+	if data[0] != Version {
+		return nil, fmt.Errorf("%w %d", ErrVersion, data[0])
+	}
+	n := binary.BigEndian.Uint32(data[1:headerSize])
+	if uint64(len(data)-headerSize) != uint64(n)*componentSize {
+		return nil, fmt.Errorf("encoding of %d bytes does not hold %d components", len(data), n)
+	}
+
+	ciphertext := make([]float64, n)
+	for i := range ciphertext {
+		bits := binary.BigEndian.Uint64(data[headerSize+i*componentSize:])
+		v := math.Float64frombits(bits)
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			return nil, fmt.Errorf("component %d is not finite", i)
+		case v == 0 && math.Signbit(v):
+			return nil, fmt.Errorf("component %d is a negative zero, which is not canonical", i)
+		}
+		ciphertext[i] = v
+	}
+	return ciphertext, nil
+}
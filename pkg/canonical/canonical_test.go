@@ -0,0 +1,85 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package canonical
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+// TestGolden pins the encoding; implementations in other languages should
+// reproduce it byte for byte.
+func TestGolden(t *testing.T) {
+	got, err := Marshal([]float64{1, -2.5, math.Copysign(0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "01" + "00000003" +
+		"3ff0000000000000" +
+		"c004000000000000" +
+		"0000000000000000"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("Marshal = %x, want %s", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, ciphertext := range [][]float64{
+		{},
+		{0.1, -0.2, 3e300, -5e-324},
+		{float64(float32(0.1)), float64(float32(-7.25))},
+	} {
+		data, err := Marshal(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(back, ciphertext) {
+			t.Errorf("round trip of %v = %v", ciphertext, back)
+		}
+	}
+}
+
+func TestMarshalRejectsNonFinite(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := Marshal([]float64{1, v}); err == nil {
+			t.Errorf("Marshal accepted %v", v)
+		}
+	}
+}
+
+func TestUnmarshalRejectsNonCanonical(t *testing.T) {
+	valid, err := Marshal([]float64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	negativeZero := slices.Clone(valid)
+	copy(negativeZero[5:], []byte{0x80, 0, 0, 0, 0, 0, 0, 0})
+	nan := slices.Clone(valid)
+	copy(nan[5:], []byte{0x7f, 0xf8, 0, 0, 0, 0, 0, 0})
+
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"truncated":     valid[:len(valid)-1],
+		"trailing":      append(slices.Clone(valid), 0),
+		"negative zero": negativeZero,
+		"nan":           nan,
+	} {
+		if _, err := Unmarshal(data); err == nil {
+			t.Errorf("%s: Unmarshal succeeded, want error", name)
+		}
+	}
+
+	future := slices.Clone(valid)
+	future[0] = Version + 1
+	if _, err := Unmarshal(future); !errors.Is(err, ErrVersion) {
+		t.Errorf("future version: err = %v, want ErrVersion", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package testing runs the vector DPE backend in process, against in-memory
+// storage and a fixed seed, so applications can unit-test their integration
+// without a live Vault.
+//
+// The package name shadows the standard library; import it under an alias:
+//
+//	import dpetest "github.com/lpassig/vault-plugin-secrets-vector-dpe/pkg/testing"
+//
+//	b, err := dpetest.New(dpetest.Options{Dimension: 384})
+//	ciphertext, err := b.EncryptTestVector(ctx, dpetest.TestVector(384, 0))
+//
+// Ciphertexts are deterministic only when ApproximationFactor is zero;
+// otherwise each encryption carries fresh noise, exactly as in production.
+// The fixed seed is public: never use this package outside tests.
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/lpassig/vault-plugin-secrets-vector-dpe/internal/plugin"
+)
+
+// DefaultSeed is the seed used when Options.Seed is empty.
+var DefaultSeed = []byte("vector-dpe-testing-fixed-seed-01")
+
+// Options configure the test backend. Zero fields take the defaults below.
+type Options struct {
+	// Seed is the 32-byte key seed. Default: DefaultSeed.
+	Seed []byte
+
+	// Dimension of the key. Default: 8.
+	Dimension int
+
+	// ScalingFactor (s). Default: 1.
+	ScalingFactor float64
+
+	// ApproximationFactor (β). Zero gives deterministic, noiseless
+	// ciphertexts. Default: 0.
+	ApproximationFactor float64
+}
+
+// Backend is an in-process backend with a key already configured.
+type Backend struct {
+	backend logical.Backend
+	storage logical.Storage
+}
+
+// New creates a backend with in-memory storage and the key from opts.
+func New(opts Options) (*Backend, error) {
+	if opts.Seed == nil {
+		opts.Seed = DefaultSeed
+	}
+	if opts.Dimension == 0 {
+		opts.Dimension = 8
+	}
+	if opts.ScalingFactor == 0 {
+		opts.ScalingFactor = 1
+	}
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	backend, err := plugin.Factory(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	if err := plugin.WriteKey(context.Background(), config.StorageView, opts.Seed, plugin.KeyParams{
+		Dimension:           opts.Dimension,
+		ScalingFactor:       opts.ScalingFactor,
+		ApproximationFactor: opts.ApproximationFactor,
+	}); err != nil {
+		return nil, fmt.Errorf("write test key: %w", err)
+	}
+	return &Backend{backend: backend, storage: config.StorageView}, nil
+}
+
+// Backend returns the underlying logical backend.
+func (b *Backend) Backend() logical.Backend {
+	return b.backend
+}
+
+// Storage returns the backend's in-memory storage.
+func (b *Backend) Storage() logical.Storage {
+	return b.storage
+}
+
+// Request issues a request against the backend, as Vault would after
+// stripping the mount prefix (e.g. path "encrypt/vector").
+func (b *Backend) Request(ctx context.Context, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.backend.HandleRequest(ctx, &logical.Request{
+		Operation: op,
+		Path:      path,
+		Data:      data,
+		Storage:   b.storage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", op, path, err)
+	}
+	if resp != nil && resp.IsError() {
+		return nil, fmt.Errorf("%s %s: %w", op, path, resp.Error())
+	}
+	return resp, nil
+}
+
+// EncryptTestVector encrypts vector with encrypt/vector.
+func (b *Backend) EncryptTestVector(ctx context.Context, vector []float64) ([]float64, error) {
+	resp, err := b.Request(ctx, logical.UpdateOperation, "encrypt/vector", map[string]interface{}{
+		"vector": vector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, ok := resp.Data["ciphertext"].([]float64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected ciphertext type %T", resp.Data["ciphertext"])
+	}
+	return ciphertext, nil
+}
+
+// EncryptTestBatch encrypts vectors with encrypt/batch. It fails if any
+// item fails.
+func (b *Backend) EncryptTestBatch(ctx context.Context, vectors [][]float64) ([][]float64, error) {
+	items := make([]interface{}, len(vectors))
+	for i, v := range vectors {
+		items[i] = v
+	}
+	resp, err := b.Request(ctx, logical.UpdateOperation, "encrypt/batch", map[string]interface{}{
+		"vectors": items,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Decode through JSON, as an API client would.
+	encoded, err := json.Marshal(resp.Data["batch_results"])
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Ciphertext []float64 `json:"ciphertext"`
+		Error      string    `json:"error"`
+		Canary     bool      `json:"canary"`
+	}
+	if err := json.Unmarshal(encoded, &results); err != nil {
+		return nil, fmt.Errorf("decode batch_results: %w", err)
+	}
+	ciphertexts := make([][]float64, 0, len(vectors))
+	for i, r := range results {
+		if r.Canary {
+			continue
+		}
+		if r.Error != "" {
+			return nil, fmt.Errorf("vector %d: %s", i, r.Error)
+		}
+		ciphertexts = append(ciphertexts, r.Ciphertext)
+	}
+	return ciphertexts, nil
+}
+
+// TestVector returns a deterministic vector of dim elements.
+func TestVector(dim int, offset float64) []float64 {
+	v := make([]float64, dim)
+	for i := range v {
+		v[i] = offset + float64(i)/float64(dim)
+	}
+	return v
+}
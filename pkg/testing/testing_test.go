@@ -0,0 +1,62 @@
+// Copyright 2024 The vault-plugin-secrets-vector-dpe Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package testing_test
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	dpetest "github.com/lpassig/vault-plugin-secrets-vector-dpe/pkg/testing"
+)
+
+func TestDeterministicFixture(t *testing.T) {
+	ctx := context.Background()
+
+	encrypt := func() []float64 {
+		b, err := dpetest.New(dpetest.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := b.EncryptTestVector(ctx, dpetest.TestVector(8, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ct
+	}
+	if a, b := encrypt(), encrypt(); !slices.Equal(a, b) {
+		t.Errorf("same seed gave different ciphertexts:\n%v\n%v", a, b)
+	}
+}
+
+func TestEncryptTestBatchPreservesDistance(t *testing.T) {
+	ctx := context.Background()
+	b, err := dpetest.New(dpetest.Options{Dimension: 16, ScalingFactor: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y := dpetest.TestVector(16, 0), dpetest.TestVector(16, 1)
+	cts, err := b.EncryptTestBatch(ctx, [][]float64{x, y})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cts) != 2 {
+		t.Fatalf("got %d ciphertexts, want 2", len(cts))
+	}
+	// Noiseless: encrypted distance is exactly s times the plaintext distance.
+	if got, want := distance(cts[0], cts[1]), 2*distance(x, y); math.Abs(got-want) > 1e-9 {
+		t.Errorf("encrypted distance %v, want %v", got, want)
+	}
+}
+
+func distance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}